@@ -0,0 +1,687 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
+	"github.com/mhsanaei/3x-ui/v2/web/entity"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/op/go-logging"
+)
+
+// Exit codes of the scriptable commands.
+const (
+	cliExitFailure = 1
+	cliExitUsage   = 2
+)
+
+// cliRestartMsg tells that the running panel only applies a change once restarted, as the
+// command line cannot reach its Xray core.
+const cliRestartMsg = "restart the panel to apply the change: x-ui restart"
+
+// cliStatus is the status of the panel printed by the status command.
+type cliStatus struct {
+	Version        string `json:"version"`
+	Running        bool   `json:"running"` // Whether the panel answers on its port
+	Port           int    `json:"port"`
+	BasePath       string `json:"basePath"`
+	Inbounds       int    `json:"inbounds"`
+	Clients        int    `json:"clients"`
+	EnabledClients int    `json:"enabledClients"`
+}
+
+// cliInbound is an inbound as listed by the inbound list command.
+type cliInbound struct {
+	Id         int    `json:"id"`
+	Remark     string `json:"remark"`
+	Tag        string `json:"tag"`
+	Protocol   string `json:"protocol"`
+	Port       int    `json:"port"`
+	Enable     bool   `json:"enable"`
+	Clients    int    `json:"clients"`
+	Up         int64  `json:"up"`
+	Down       int64  `json:"down"`
+	Total      int64  `json:"total"`
+	ExpiryTime int64  `json:"expiryTime"`
+}
+
+// cliClient is a client as listed by the client list command.
+type cliClient struct {
+	InboundId  int    `json:"inboundId"`
+	Email      string `json:"email"`
+	Enable     bool   `json:"enable"`
+	Up         int64  `json:"up"`
+	Down       int64  `json:"down"`
+	Total      int64  `json:"total"`
+	ExpiryTime int64  `json:"expiryTime"`
+	LimitIp    int    `json:"limitIp"`
+	SubId      string `json:"subId"`
+	Comment    string `json:"comment"`
+}
+
+// initCli opens the database for the scriptable commands. The services only log errors, so that
+// the output stays machine-readable.
+func initCli(jsonOut bool) {
+	logger.InitLogger(logging.ERROR)
+	if err := database.InitDB(config.GetDBPath()); err != nil {
+		cliFail(jsonOut, err)
+	}
+}
+
+// cliPrint prints the outcome of a command: the object as JSON, shaped like the answers of the
+// HTTP API, or as text.
+func cliPrint(jsonOut bool, msg string, obj any, text func()) {
+	if jsonOut {
+		data, _ := json.MarshalIndent(entity.Msg{Success: true, Msg: msg, Obj: obj}, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+	text()
+	if msg != "" {
+		fmt.Println(msg)
+	}
+}
+
+// cliFail prints an error and exits with a failure.
+func cliFail(jsonOut bool, err error) {
+	if jsonOut {
+		data, _ := json.MarshalIndent(entity.Msg{Success: false, Msg: err.Error()}, "", "  ")
+		fmt.Println(string(data))
+	} else {
+		fmt.Fprintln(os.Stderr, "error:", err)
+	}
+	os.Exit(cliExitFailure)
+}
+
+// cliUsage prints the usage of a command and exits.
+func cliUsage(fs *flag.FlagSet, usage string) {
+	fmt.Fprintln(os.Stderr, "Usage:", usage)
+	if fs != nil {
+		fs.PrintDefaults()
+	}
+	os.Exit(cliExitUsage)
+}
+
+// cliPanelRunning reports whether the panel answers on its port.
+func cliPanelRunning() bool {
+	settingService := service.SettingService{}
+	port, err := settingService.GetPort()
+	if err != nil {
+		return false
+	}
+	listen, _ := settingService.GetListen()
+	if listen == "" || listen == "0.0.0.0" || listen == "::" {
+		listen = "127.0.0.1"
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(listen, strconv.Itoa(port)), 2*time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// cliStatusCommand prints the status of the panel.
+func cliStatusCommand(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	jsonOut := fs.Bool("json", false, "Print JSON")
+	fs.Parse(args)
+	initCli(*jsonOut)
+
+	settingService := service.SettingService{}
+	inboundService := service.InboundService{}
+	status := &cliStatus{Version: config.GetVersion()}
+	var err error
+	if status.Port, err = settingService.GetPort(); err != nil {
+		cliFail(*jsonOut, err)
+	}
+	if status.BasePath, err = settingService.GetBasePath(); err != nil {
+		cliFail(*jsonOut, err)
+	}
+	status.Running = cliPanelRunning()
+	inbounds, err := inboundService.GetAllInbounds()
+	if err != nil {
+		cliFail(*jsonOut, err)
+	}
+	status.Inbounds = len(inbounds)
+	for _, inbound := range inbounds {
+		for _, clientTraffic := range inbound.ClientStats {
+			status.Clients++
+			if clientTraffic.Enable {
+				status.EnabledClients++
+			}
+		}
+	}
+
+	cliPrint(*jsonOut, "", status, func() {
+		fmt.Println("version:", status.Version)
+		fmt.Println("running:", status.Running)
+		fmt.Println("port:", status.Port)
+		fmt.Println("basePath:", status.BasePath)
+		fmt.Println("inbounds:", status.Inbounds)
+		fmt.Printf("clients: %d (%d enabled)\n", status.Clients, status.EnabledClients)
+	})
+}
+
+// cliInboundCommand runs the inbound commands.
+func cliInboundCommand(args []string) {
+	const usage = "x-ui inbound list [-json]"
+	if len(args) == 0 || args[0] != "list" {
+		cliUsage(nil, usage)
+	}
+	fs := flag.NewFlagSet("inbound list", flag.ExitOnError)
+	jsonOut := fs.Bool("json", false, "Print JSON")
+	fs.Parse(args[1:])
+	initCli(*jsonOut)
+
+	inboundService := service.InboundService{}
+	inbounds, err := inboundService.GetAllInbounds()
+	if err != nil {
+		cliFail(*jsonOut, err)
+	}
+	list := make([]*cliInbound, 0, len(inbounds))
+	for _, inbound := range inbounds {
+		list = append(list, &cliInbound{
+			Id:         inbound.Id,
+			Remark:     inbound.Remark,
+			Tag:        inbound.Tag,
+			Protocol:   string(inbound.Protocol),
+			Port:       inbound.Port,
+			Enable:     inbound.Enable,
+			Clients:    len(inbound.ClientStats),
+			Up:         inbound.Up,
+			Down:       inbound.Down,
+			Total:      inbound.Total,
+			ExpiryTime: inbound.ExpiryTime,
+		})
+	}
+
+	cliPrint(*jsonOut, "", list, func() {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tREMARK\tPROTOCOL\tPORT\tENABLE\tCLIENTS\tUSED")
+		for _, inbound := range list {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%v\t%d\t%s\n", inbound.Id, inbound.Remark, inbound.Protocol,
+				inbound.Port, inbound.Enable, inbound.Clients, common.FormatTraffic(inbound.Up+inbound.Down))
+		}
+		w.Flush()
+	})
+}
+
+// cliClientCommand runs the client commands.
+func cliClientCommand(args []string) {
+	const usage = "x-ui client list|add|del [flags]"
+	if len(args) == 0 {
+		cliUsage(nil, usage)
+	}
+	switch args[0] {
+	case "list":
+		cliClientList(args[1:])
+	case "add":
+		cliClientAdd(args[1:])
+	case "del":
+		cliClientDel(args[1:])
+	default:
+		cliUsage(nil, usage)
+	}
+}
+
+// cliClientList lists the clients of all inbounds or of one.
+func cliClientList(args []string) {
+	fs := flag.NewFlagSet("client list", flag.ExitOnError)
+	inboundId := fs.Int("inbound", 0, "List only the clients of the inbound with this ID")
+	jsonOut := fs.Bool("json", false, "Print JSON")
+	fs.Parse(args)
+	initCli(*jsonOut)
+
+	inboundService := service.InboundService{}
+	inbounds, err := inboundService.GetAllInbounds()
+	if err != nil {
+		cliFail(*jsonOut, err)
+	}
+	list := []*cliClient{}
+	for _, inbound := range inbounds {
+		if *inboundId != 0 && inbound.Id != *inboundId {
+			continue
+		}
+		clients, _ := inboundService.GetClients(inbound)
+		for _, client := range clients {
+			item := &cliClient{
+				InboundId:  inbound.Id,
+				Email:      client.Email,
+				Enable:     client.Enable,
+				Total:      client.TotalGB,
+				ExpiryTime: client.ExpiryTime,
+				LimitIp:    client.LimitIP,
+				SubId:      client.SubID,
+				Comment:    client.Comment,
+			}
+			for _, clientTraffic := range inbound.ClientStats {
+				if clientTraffic.Email == client.Email {
+					item.Enable = client.Enable && clientTraffic.Enable
+					item.Up = clientTraffic.Up
+					item.Down = clientTraffic.Down
+				}
+			}
+			list = append(list, item)
+		}
+	}
+
+	cliPrint(*jsonOut, "", list, func() {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "INBOUND\tEMAIL\tENABLE\tUSED\tTOTAL\tEXPIRY")
+		for _, client := range list {
+			total, expiry := "unlimited", "never"
+			if client.Total > 0 {
+				total = common.FormatTraffic(client.Total)
+			}
+			if client.ExpiryTime > 0 {
+				expiry = time.UnixMilli(client.ExpiryTime).Format("2006-01-02 15:04")
+			} else if client.ExpiryTime < 0 {
+				expiry = fmt.Sprintf("%d days from first use", -client.ExpiryTime/86400000)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%v\t%s\t%s\t%s\n", client.InboundId, client.Email, client.Enable,
+				common.FormatTraffic(client.Up+client.Down), total, expiry)
+		}
+		w.Flush()
+	})
+}
+
+// cliClientAdd adds a client to an inbound. Its ID or password follows the credential policy.
+func cliClientAdd(args []string) {
+	fs := flag.NewFlagSet("client add", flag.ExitOnError)
+	inboundId := fs.Int("inbound", 0, "ID of the inbound to add the client to (required)")
+	email := fs.String("email", "", "Email of the client (required unless an email pattern is set)")
+	totalGB := fs.Float64("total", 0, "Traffic quota in GB, 0 for unlimited")
+	days := fs.Int("days", 0, "Days until the client expires, 0 for never")
+	limitIp := fs.Int("limitIp", 0, "Maximum number of IPs, 0 for unlimited")
+	subId := fs.String("subId", "", "Subscription ID, random if empty")
+	comment := fs.String("comment", "", "Comment")
+	jsonOut := fs.Bool("json", false, "Print JSON")
+	fs.Parse(args)
+	if *inboundId <= 0 || *totalGB < 0 || *days < 0 || *limitIp < 0 {
+		cliUsage(fs, "x-ui client add -inbound ID -email EMAIL [flags]")
+	}
+	initCli(*jsonOut)
+
+	inboundService := service.InboundService{}
+	inbound, err := inboundService.GetInbound(*inboundId)
+	if err != nil {
+		cliFail(*jsonOut, common.NewError("inbound not found:", *inboundId))
+	}
+	if *subId == "" {
+		*subId = random.SeqFrom(16, "0123456789abcdefghijklmnopqrstuvwxyz")
+	}
+	client := map[string]any{
+		"email":      *email,
+		"enable":     true,
+		"totalGB":    int64(*totalGB * 1024 * 1024 * 1024),
+		"expiryTime": 0,
+		"limitIp":    *limitIp,
+		"subId":      *subId,
+		"comment":    *comment,
+		"flow":       "",
+	}
+	if *days > 0 {
+		client["expiryTime"] = time.Now().AddDate(0, 0, *days).UnixMilli()
+	}
+	if inbound.Protocol == model.VMESS {
+		client["security"] = "auto"
+	}
+	settings, err := json.Marshal(map[string]any{"clients": []any{client}})
+	if err != nil {
+		cliFail(*jsonOut, err)
+	}
+	needRestart, err := inboundService.AddInboundClient(&model.Inbound{Id: inbound.Id, Settings: string(settings)})
+	if err != nil {
+		cliFail(*jsonOut, err)
+	}
+
+	// Read the client back for its generated email and credentials
+	inbound, err = inboundService.GetInbound(inbound.Id)
+	if err != nil {
+		cliFail(*jsonOut, err)
+	}
+	clients, err := inboundService.GetClients(inbound)
+	if err != nil || len(clients) == 0 {
+		cliFail(*jsonOut, common.NewError("client not found after adding it"))
+	}
+	added := clients[len(clients)-1]
+	msg := ""
+	if needRestart {
+		msg = cliRestartMsg
+	}
+	cliPrint(*jsonOut, msg, added, func() {
+		fmt.Println("client added:", added.Email)
+		if added.ID != "" {
+			fmt.Println("id:", added.ID)
+		}
+		if added.Password != "" {
+			fmt.Println("password:", added.Password)
+		}
+		fmt.Println("subId:", added.SubID)
+	})
+}
+
+// cliClientDel deletes a client by email.
+func cliClientDel(args []string) {
+	fs := flag.NewFlagSet("client del", flag.ExitOnError)
+	inboundId := fs.Int("inbound", 0, "ID of the inbound of the client, found by email if 0")
+	email := fs.String("email", "", "Email of the client (required)")
+	jsonOut := fs.Bool("json", false, "Print JSON")
+	fs.Parse(args)
+	if *email == "" {
+		cliUsage(fs, "x-ui client del -email EMAIL [-inbound ID]")
+	}
+	initCli(*jsonOut)
+
+	inboundService := service.InboundService{}
+	if *inboundId == 0 {
+		_, inbound, err := inboundService.GetClientInboundByEmail(*email)
+		if err != nil || inbound == nil {
+			cliFail(*jsonOut, common.NewError("client not found:", *email))
+		}
+		*inboundId = inbound.Id
+	}
+	needRestart, err := inboundService.DelInboundClientByEmail(*inboundId, *email)
+	if err != nil {
+		cliFail(*jsonOut, err)
+	}
+	msg := ""
+	if needRestart {
+		msg = cliRestartMsg
+	}
+	cliPrint(*jsonOut, msg, *email, func() {
+		fmt.Println("client deleted:", *email)
+	})
+}
+
+// cliBackupCommand writes a backup archive of the database and the Xray configuration.
+func cliBackupCommand(args []string) {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	dest := fs.String("dest", ".", "Directory or file to write the backup archive to")
+	jsonOut := fs.Bool("json", false, "Print JSON")
+	fs.Parse(args)
+	initCli(*jsonOut)
+
+	path := *dest
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, fmt.Sprintf("x-ui-backup-%s.tar.gz", time.Now().Format("20060102-150405")))
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		cliFail(*jsonOut, err)
+	}
+	serverService := service.ServerService{}
+	if err := serverService.WriteBackup(file); err != nil {
+		file.Close()
+		os.Remove(path)
+		cliFail(*jsonOut, err)
+	}
+	if err := file.Close(); err != nil {
+		cliFail(*jsonOut, err)
+	}
+
+	cliPrint(*jsonOut, "", path, func() {
+		fmt.Println("backup written:", path)
+	})
+}
+
+// cliRestoreCommand restores the database from a backup archive or a database file. The panel
+// keeps the database it opened, so it must be stopped first.
+func cliRestoreCommand(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	force := fs.Bool("force", false, "Restore even though the panel is running")
+	jsonOut := fs.Bool("json", false, "Print JSON")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		cliUsage(fs, "x-ui restore [-force] [-json] ARCHIVE")
+	}
+	path := fs.Arg(0)
+	initCli(*jsonOut)
+
+	if !*force && cliPanelRunning() {
+		cliFail(*jsonOut, common.NewError("the panel is running, stop it first: x-ui stop"))
+	}
+	serverService := service.ServerService{}
+	if err := serverService.RestoreBackup(path, nil); err != nil {
+		cliFail(*jsonOut, err)
+	}
+
+	cliPrint(*jsonOut, "start the panel to use the restored database: x-ui start", path, func() {
+		fmt.Println("database restored from:", path)
+	})
+}
+
+// cliDoctorCommand runs the diagnostics of the panel and its environment, and fails if any check
+// failed.
+func cliDoctorCommand(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	jsonOut := fs.Bool("json", false, "Print JSON")
+	fs.Parse(args)
+	initCli(*jsonOut)
+
+	doctorService := service.DoctorService{}
+	report := doctorService.RunDiagnostics()
+	failed := 0
+	for _, check := range report.Checks {
+		if check.Status == service.DiagnosticFail {
+			failed++
+		}
+	}
+	msg := ""
+	if failed > 0 {
+		msg = fmt.Sprintf("%d checks failed", failed)
+	}
+
+	labels := map[string]string{
+		service.DiagnosticOk:   "[ OK ]",
+		service.DiagnosticWarn: "[WARN]",
+		service.DiagnosticFail: "[FAIL]",
+		service.DiagnosticSkip: "[SKIP]",
+	}
+	cliPrint(*jsonOut, msg, report, func() {
+		fmt.Printf("3x-ui %s on %s/%s, core %s\n\n", report.Version, report.OS, report.Arch, report.CoreType)
+		for _, check := range report.Checks {
+			fmt.Printf("%s %s: %s\n", labels[check.Status], check.Name, check.Message)
+			if check.Hint != "" {
+				fmt.Printf("       %s\n", check.Hint)
+			}
+		}
+		fmt.Println()
+	})
+	if failed > 0 {
+		os.Exit(cliExitFailure)
+	}
+}
+
+// cliUpdateCommand checks for a release of the panel, or installs the latest or a given one. The
+// running panel keeps its binary until restarted.
+func cliUpdateCommand(args []string) {
+	fs := flag.NewFlagSet("update", flag.ExitOnError)
+	check := fs.Bool("check", false, "Only check for a newer release")
+	version := fs.String("version", "", "Install this release, e.g. v2.8.5, instead of the latest")
+	jsonOut := fs.Bool("json", false, "Print JSON")
+	fs.Parse(args)
+	initCli(*jsonOut)
+
+	panelService := service.PanelService{}
+	if *check || *version == "" {
+		update, err := panelService.CheckPanelUpdate()
+		if err != nil {
+			cliFail(*jsonOut, err)
+		}
+		if *check || !update.Available {
+			cliPrint(*jsonOut, "", update, func() {
+				if update.Available {
+					fmt.Printf("panel %s is available, running %s\n", update.Latest, update.Current)
+				} else {
+					fmt.Printf("panel %s is up to date\n", update.Current)
+				}
+			})
+			return
+		}
+		*version = update.Latest
+	}
+	if err := panelService.InstallPanelUpdate(*version); err != nil {
+		cliFail(*jsonOut, err)
+	}
+
+	cliPrint(*jsonOut, cliRestartMsg, *version, func() {
+		fmt.Println("panel installed:", *version)
+	})
+}
+
+// cliImportCommand imports the users of an export of another panel into the inbounds given per
+// protocol.
+func cliImportCommand(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", service.PanelImportAuto, "Format of the export: auto, marzban or hiddify")
+	vless := fs.Int("vless", 0, "ID of the inbound receiving the VLESS users")
+	vmess := fs.Int("vmess", 0, "ID of the inbound receiving the VMess users")
+	trojan := fs.Int("trojan", 0, "ID of the inbound receiving the Trojan users")
+	shadowsocks := fs.Int("shadowsocks", 0, "ID of the inbound receiving the Shadowsocks users")
+	jsonOut := fs.Bool("json", false, "Print JSON")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		cliUsage(fs, "x-ui import [-format FORMAT] [-vless ID] [-vmess ID] [-trojan ID] [-shadowsocks ID] FILE")
+	}
+	initCli(*jsonOut)
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		cliFail(*jsonOut, err)
+	}
+	inboundService := service.InboundService{}
+	targets := map[model.Protocol]int{
+		model.VLESS:       *vless,
+		model.VMESS:       *vmess,
+		model.Trojan:      *trojan,
+		model.Shadowsocks: *shadowsocks,
+	}
+	result, needRestart, err := inboundService.ImportPanelUsers(data, *format, targets)
+	if err != nil {
+		cliFail(*jsonOut, err)
+	}
+	msg := ""
+	if needRestart {
+		msg = cliRestartMsg
+	}
+	cliPrint(*jsonOut, msg, result, func() {
+		fmt.Printf("%d users found in the %s export\n", result.Users, result.Format)
+		for _, protocol := range slices.Sorted(maps.Keys(result.Clients)) {
+			fmt.Printf("  %s: %d clients added\n", protocol, result.Clients[protocol])
+		}
+		if len(result.Skipped) > 0 {
+			fmt.Printf("skipped %d clients whose email exists: %s\n", len(result.Skipped), strings.Join(result.Skipped, ", "))
+		}
+		if len(result.Unmapped) > 0 {
+			fmt.Printf("not imported, no inbound given for: %s\n", strings.Join(result.Unmapped, ", "))
+		}
+	})
+}
+
+// cliParsePeriod parses a period like 12h, 30d or 4w.
+func cliParsePeriod(period string) (time.Duration, error) {
+	units := map[byte]time.Duration{'h': time.Hour, 'd': 24 * time.Hour, 'w': 7 * 24 * time.Hour}
+	if len(period) < 2 || units[period[len(period)-1]] == 0 {
+		return 0, common.NewError("invalid period, use a number of hours, days or weeks like 12h, 30d or 4w:", period)
+	}
+	count, err := strconv.Atoi(period[:len(period)-1])
+	if err != nil || count <= 0 {
+		return 0, common.NewError("invalid period:", period)
+	}
+	return time.Duration(count) * units[period[len(period)-1]], nil
+}
+
+// cliReportCommand prints the traffic of every inbound and client over a period, as a table, CSV
+// or JSON.
+func cliReportCommand(args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	period := fs.String("period", "30d", "Period ending now, like 12h, 30d or 4w")
+	fromDay := fs.String("from", "", "First day of the period as 2006-01-02, instead of -period")
+	toDay := fs.String("to", "", "Last day of the period as 2006-01-02, today by default")
+	inboundId := fs.Int("inbound", 0, "Report only the inbound with this ID and its clients")
+	format := fs.String("format", "table", "Output format: table, csv or json")
+	fs.Parse(args)
+	if *format != "table" && *format != "csv" && *format != "json" {
+		cliUsage(fs, "x-ui report [-period 30d | -from DAY [-to DAY]] [-inbound ID] [-format table|csv|json]")
+	}
+	jsonOut := *format == "json"
+	initCli(jsonOut)
+
+	settingService := service.SettingService{}
+	loc, err := settingService.GetReportTimeLocation()
+	if err != nil {
+		loc = time.Local
+	}
+	now := time.Now().In(loc)
+	from, to := now, now
+	if *fromDay != "" {
+		start, err := time.ParseInLocation("2006-01-02", *fromDay, loc)
+		if err != nil {
+			cliFail(jsonOut, err)
+		}
+		from = start
+		if *toDay != "" {
+			end, err := time.ParseInLocation("2006-01-02", *toDay, loc)
+			if err != nil {
+				cliFail(jsonOut, err)
+			}
+			to = end.AddDate(0, 0, 1)
+		}
+	} else {
+		duration, err := cliParsePeriod(*period)
+		if err != nil {
+			cliFail(jsonOut, err)
+		}
+		from = now.Add(-duration)
+	}
+
+	reportService := service.ReportService{}
+	report, err := reportService.BuildTrafficReport(from.UnixMilli(), to.UnixMilli(), *inboundId)
+	if err != nil {
+		cliFail(jsonOut, err)
+	}
+
+	if *format == "csv" {
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"kind", "name", "inbound_id", "remark", "up", "down", "total"})
+		for _, row := range report.Rows {
+			w.Write([]string{row.Kind, row.Name, strconv.Itoa(row.InboundId), row.Remark,
+				strconv.FormatInt(row.Up, 10), strconv.FormatInt(row.Down, 10), strconv.FormatInt(row.Total, 10)})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			cliFail(false, err)
+		}
+		return
+	}
+	cliPrint(jsonOut, "", report, func() {
+		fmt.Printf("traffic from %s to %s\n\n", from.Format("2006-01-02 15:04"), to.Format("2006-01-02 15:04"))
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KIND\tNAME\tINBOUND\tUP\tDOWN\tTOTAL")
+		for _, row := range report.Rows {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n", row.Kind, row.Name, row.InboundId,
+				common.FormatTraffic(row.Up), common.FormatTraffic(row.Down), common.FormatTraffic(row.Total))
+		}
+		w.Flush()
+	})
+}
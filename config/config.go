@@ -19,6 +19,10 @@ var version string
 //go:embed name
 var name string
 
+// releaseKey is the base64 Ed25519 public key the checksums of the releases are signed with, set
+// by the release builds with -ldflags "-X github.com/mhsanaei/3x-ui/v2/config.releaseKey=...".
+var releaseKey string
+
 // LogLevel represents the logging level for the application.
 type LogLevel string
 
@@ -31,6 +35,27 @@ const (
 	Error   LogLevel = "error"
 )
 
+// LogFormat is the format of the log output.
+type LogFormat string
+
+// Log format constants
+const (
+	LogFormatText LogFormat = "text"
+	LogFormatJSON LogFormat = "json"
+)
+
+// LogOutput is where the console logs of the panel go.
+type LogOutput string
+
+// Log output constants
+const (
+	LogOutputAuto     LogOutput = "auto"     // Local syslog, or stderr when unavailable, the event log in a Windows service
+	LogOutputStderr   LogOutput = "stderr"   // Standard error only
+	LogOutputSyslog   LogOutput = "syslog"   // Local syslog only
+	LogOutputJournald LogOutput = "journald" // The native protocol of systemd-journald
+	LogOutputEventlog LogOutput = "eventlog" // The Windows event log
+)
+
 // GetVersion returns the version string of the 3x-ui application.
 func GetVersion() string {
 	return strings.TrimSpace(version)
@@ -41,6 +66,12 @@ func GetName() string {
 	return strings.TrimSpace(name)
 }
 
+// GetReleaseKey returns the public key the checksums of the releases are signed with, or "" for
+// the builds that cannot verify releases and so cannot update themselves.
+func GetReleaseKey() string {
+	return strings.TrimSpace(releaseKey)
+}
+
 // GetLogLevel returns the current logging level based on environment variables or defaults to Info.
 func GetLogLevel() LogLevel {
 	if IsDebug() {
@@ -53,6 +84,32 @@ func GetLogLevel() LogLevel {
 	return LogLevel(logLevel)
 }
 
+// GetLogFormat returns the log format set by the XUI_LOG_FORMAT environment variable, json for one
+// JSON object per message or text by default.
+func GetLogFormat() LogFormat {
+	if strings.EqualFold(strings.TrimSpace(os.Getenv("XUI_LOG_FORMAT")), string(LogFormatJSON)) {
+		return LogFormatJSON
+	}
+	return LogFormatText
+}
+
+// GetLogOutput returns where the console logs go, set by the XUI_LOG_OUTPUT environment variable
+// to stderr, syslog, journald or eventlog, or auto by default.
+func GetLogOutput() LogOutput {
+	switch output := LogOutput(strings.ToLower(strings.TrimSpace(os.Getenv("XUI_LOG_OUTPUT")))); output {
+	case LogOutputStderr, LogOutputSyslog, LogOutputJournald, LogOutputEventlog:
+		return output
+	}
+	return LogOutputAuto
+}
+
+// GetLogSyslogAddr returns the remote syslog server the logs are also sent to in the RFC 5424
+// format, set by the XUI_LOG_SYSLOG_ADDR environment variable as udp://host:port or
+// tcp://host:port. It is empty when the logs are not sent to a remote server.
+func GetLogSyslogAddr() string {
+	return strings.TrimSpace(os.Getenv("XUI_LOG_SYSLOG_ADDR"))
+}
+
 // IsDebug returns true if debug mode is enabled via the XUI_DEBUG environment variable.
 func IsDebug() bool {
 	return os.Getenv("XUI_DEBUG") == "true"
@@ -67,6 +124,21 @@ func GetBinFolderPath() string {
 	return binFolderPath
 }
 
+// GetXrayBinaryPath returns the custom Xray binary set via XUI_XRAY_BINARY, or "" for the binary in the bin folder.
+func GetXrayBinaryPath() string {
+	return os.Getenv("XUI_XRAY_BINARY")
+}
+
+// GetXrayBinarySHA256 returns the SHA-256 checksum the Xray binary is pinned to via XUI_XRAY_BINARY_SHA256, if any.
+func GetXrayBinarySHA256() string {
+	return strings.ToLower(strings.TrimSpace(os.Getenv("XUI_XRAY_BINARY_SHA256")))
+}
+
+// GetXrayUser returns the system user the Xray process runs as via XUI_XRAY_USER, or "" for the panel user.
+func GetXrayUser() string {
+	return os.Getenv("XUI_XRAY_USER")
+}
+
 func getBaseDir() string {
 	exePath, err := os.Executable()
 	if err != nil {
@@ -137,18 +209,28 @@ func GetDBPath() string {
 	}
 
 	if config.Connection == "mysql" {
-		return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-			config.Username,
-			config.Password,
-			config.Host,
-			config.Port,
-			config.Database)
+		return config.MySQLDSN()
 	}
 
 	// Connection is sqlite
+	return GetSQLiteDBPath()
+}
+
+// GetSQLiteDBPath returns the path of the SQLite database file, whatever the configured connection.
+func GetSQLiteDBPath() string {
 	return fmt.Sprintf("%s/%s.db", GetDBFolderPath(), GetName())
 }
 
+// MySQLDSN returns the data source name of the MySQL database.
+func (c *DatabaseConfig) MySQLDSN() string {
+	return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
+		c.Username,
+		c.Password,
+		c.Host,
+		c.Port,
+		c.Database)
+}
+
 // GetLogFolder returns the path to the log folder based on environment variables or platform defaults.
 func GetLogFolder() string {
 	logFolderPath := os.Getenv("XUI_LOG_FOLDER")
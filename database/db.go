@@ -31,17 +31,51 @@ const (
 	defaultPassword = "admin"
 )
 
-func initModels() error {
-	models := []any{
+// models returns the models stored in the database, in the order they are migrated.
+func models() []any {
+	return []any{
 		&model.User{},
 		&model.Inbound{},
 		&model.OutboundTraffics{},
 		&model.Setting{},
 		&model.InboundClientIps{},
+		&model.InboundHistory{},
+		&model.ClientPlan{},
+		&model.ClientNotice{},
+		&model.ClientTrafficDaily{},
+		&model.TrafficSample{},
+		&model.ServerStatSample{},
+		&model.AlertRule{},
+		&model.AlertEvent{},
+		&model.NotifyChannel{},
+		&model.PendingNotification{},
+		&model.BotAuditLog{},
+		&model.MessageTemplate{},
+		&model.SpeedtestResult{},
+		&model.TrafficAnomaly{},
+		&model.SubIdAlias{},
+		&model.SubAccess{},
+		&model.ShortLink{},
+		&model.RoutingRule{},
+		&model.Outbound{},
+		&model.WarpAccount{},
+		&model.Balancer{},
+		&model.DnsServer{},
+		&model.ReverseProxy{},
+		&model.CrashEvent{},
+		&model.ConnectionStat{},
+		&model.Node{},
+		&model.NodeTraffic{},
+		&model.NodeSyncPolicy{},
+		&model.NodePlacement{},
+		&model.Certificate{},
 		&xray.ClientTraffic{},
 		&model.HistoryOfSeeders{},
 	}
-	for _, model := range models {
+}
+
+func initModels() error {
+	for _, model := range models() {
 		if err := db.AutoMigrate(model); err != nil {
 			log.Printf("Error auto migrating model: %v", err)
 			return err
@@ -114,6 +148,32 @@ func runSeeders(isUsersEmpty bool) error {
 	return nil
 }
 
+// renamedSettings maps the former keys of renamed settings to their current ones.
+var renamedSettings = map[string]string{
+	"httpAccessLogTrustedProxies": "trustedProxies",
+}
+
+// migrateSettings moves the values of renamed settings to their current keys. A value already
+// stored under the current key is kept.
+func migrateSettings() error {
+	for oldKey, newKey := range renamedSettings {
+		var count int64
+		if err := db.Model(&model.Setting{}).Where("`key` = ?", newKey).Count(&count).Error; err != nil {
+			return err
+		}
+		var err error
+		if count == 0 {
+			err = db.Model(&model.Setting{}).Where("`key` = ?", oldKey).Update("key", newKey).Error
+		} else {
+			err = db.Where("`key` = ?", oldKey).Delete(&model.Setting{}).Error
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // isTableEmpty returns true if the named table contains zero rows.
 func isTableEmpty(tableName string) (bool, error) {
 	var count int64
@@ -164,10 +224,18 @@ func InitDB(dbPath string) error {
 		}
 	}
 
+	if err := registerVersionCallbacks(db); err != nil {
+		return err
+	}
+
 	if err := initModels(); err != nil {
 		return err
 	}
 
+	if err := migrateSettings(); err != nil {
+		return err
+	}
+
 	isUsersEmpty, err := isTableEmpty("users")
 	if err != nil {
 		return err
@@ -179,14 +247,19 @@ func InitDB(dbPath string) error {
 	return runSeeders(isUsersEmpty)
 }
 
-// CloseDB closes the database connection if it exists.
+// CloseDB closes the database connection if it exists, after writing the WAL of SQLite back
+// into the database file.
 func CloseDB() error {
 	if db != nil {
+		var checkpointErr error
+		if db.Dialector.Name() == "sqlite" {
+			checkpointErr = Checkpoint()
+		}
 		sqlDB, err := db.DB()
 		if err != nil {
 			return err
 		}
-		return sqlDB.Close()
+		return errors.Join(checkpointErr, sqlDB.Close())
 	}
 	return nil
 }
@@ -0,0 +1,172 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+// migrateBatchSize is the number of rows copied and verified at once.
+const migrateBatchSize = 500
+
+// MigratedTable is a table copied by MigrateToMySQL.
+type MigratedTable struct {
+	Name   string
+	Rows   int64
+	Digest string // SHA-256 of the rows, equal on both sides
+}
+
+// MigrateToMySQL copies every table of the SQLite database at sqlitePath into the MySQL database
+// of dsn, which must not hold any data yet. The copy keeps the IDs of the rows, and is verified
+// before it is committed: the row count and a digest of the rows of every table must be the same
+// on both sides, or nothing is written. The panel should be stopped meanwhile.
+func MigrateToMySQL(sqlitePath string, dsn string) ([]*MigratedTable, error) {
+	if err := ValidateSQLiteDB(sqlitePath); err != nil {
+		return nil, err
+	}
+	c := &gorm.Config{Logger: logger.Discard}
+	src, err := gorm.Open(sqlite.Open(sqlitePath), c)
+	if err != nil {
+		return nil, err
+	}
+	if srcDB, err := src.DB(); err == nil {
+		defer srcDB.Close()
+	}
+	dst, err := gorm.Open(mysql.Open(dsn), c)
+	if err != nil {
+		return nil, err
+	}
+	if dstDB, err := dst.DB(); err == nil {
+		defer dstDB.Close()
+	}
+
+	// Bring both schemas up to date, as the panel does on start
+	for _, model := range models() {
+		if err := src.AutoMigrate(model); err != nil {
+			return nil, err
+		}
+		if err := dst.AutoMigrate(model); err != nil {
+			return nil, err
+		}
+	}
+	schemas := make([]*schema.Schema, 0, len(models()))
+	for _, model := range models() {
+		stmt := &gorm.Statement{DB: dst}
+		if err := stmt.Parse(model); err != nil {
+			return nil, err
+		}
+		if stmt.Schema.PrioritizedPrimaryField == nil {
+			return nil, fmt.Errorf("table %s has no primary key", stmt.Schema.Table)
+		}
+		var count int64
+		if err := dst.Table(stmt.Schema.Table).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return nil, fmt.Errorf("the MySQL database is not empty: table %s has %d rows", stmt.Schema.Table, count)
+		}
+		schemas = append(schemas, stmt.Schema)
+	}
+
+	var tables []*MigratedTable
+	err = dst.Transaction(func(tx *gorm.DB) error {
+		for _, s := range schemas {
+			if err := copyTable(src, tx, s); err != nil {
+				return fmt.Errorf("copy table %s: %w", s.Table, err)
+			}
+			srcRows, srcDigest, err := digestTable(src, s)
+			if err != nil {
+				return err
+			}
+			dstRows, dstDigest, err := digestTable(tx, s)
+			if err != nil {
+				return err
+			}
+			if srcRows != dstRows || srcDigest != dstDigest {
+				return fmt.Errorf("table %s differs after the copy: %d rows in SQLite, %d in MySQL", s.Table, srcRows, dstRows)
+			}
+			tables = append(tables, &MigratedTable{Name: s.Table, Rows: srcRows, Digest: srcDigest})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
+// copyTable copies the rows of a table in batches. They are copied as maps rather than models, so
+// that zero values are written as they are instead of being replaced by the column defaults.
+func copyTable(src *gorm.DB, dst *gorm.DB, s *schema.Schema) error {
+	pk := s.PrioritizedPrimaryField.DBName
+	columns := make([]clause.Column, 0, len(s.DBNames))
+	for _, name := range s.DBNames {
+		columns = append(columns, clause.Column{Name: name})
+	}
+	var last any
+	for {
+		var rows []map[string]any
+		query := src.Table(s.Table).Clauses(clause.Select{Columns: columns}).Order(pk).Limit(migrateBatchSize)
+		if last != nil {
+			query = query.Where(pk+" > ?", last)
+		}
+		if err := query.Find(&rows).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		if err := dst.Table(s.Table).Create(&rows).Error; err != nil {
+			return err
+		}
+		if len(rows) < migrateBatchSize {
+			return nil
+		}
+		last = rows[len(rows)-1][pk]
+	}
+}
+
+// digestTable returns the number of rows of a table and a digest of them, read as models so that
+// both databases yield the same values.
+func digestTable(db *gorm.DB, s *schema.Schema) (int64, string, error) {
+	pk := s.PrioritizedPrimaryField
+	hash := sha256.New()
+	var count int64
+	var last any
+	for {
+		batch := reflect.New(reflect.SliceOf(s.ModelType))
+		query := db.Table(s.Table).Order(pk.DBName).Limit(migrateBatchSize)
+		if last != nil {
+			query = query.Where(pk.DBName+" > ?", last)
+		}
+		if err := query.Find(batch.Interface()).Error; err != nil {
+			return 0, "", err
+		}
+		rows := batch.Elem()
+		if rows.Len() == 0 {
+			break
+		}
+		// Every field counts, including those left out of the JSON of the models
+		fmt.Fprintf(hash, "%v", rows.Interface())
+		count += int64(rows.Len())
+		if rows.Len() < migrateBatchSize {
+			break
+		}
+		var zero bool
+		last, zero = pk.ValueOf(context.Background(), rows.Index(rows.Len()-1))
+		if zero {
+			return 0, "", errors.New("zero primary key in table " + s.Table)
+		}
+	}
+	return count, hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -2,7 +2,10 @@
 package model
 
 import (
+	"encoding/json"
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/mhsanaei/3x-ui/v2/util/json_util"
 	"github.com/mhsanaei/3x-ui/v2/xray"
@@ -21,8 +24,16 @@ const (
 	Shadowsocks Protocol = "shadowsocks"
 	Mixed       Protocol = "mixed"
 	WireGuard   Protocol = "wireguard"
+	Hysteria2   Protocol = "hysteria2"
+	TUIC        Protocol = "tuic"
 )
 
+// IsSingboxOnly reports whether the protocol is only served by the sing-box core.
+// Inbounds of these protocols are left out of the Xray config.
+func (p Protocol) IsSingboxOnly() bool {
+	return p == Hysteria2 || p == TUIC
+}
+
 // User represents a user account in the 3x-ui panel.
 type User struct {
 	Id       int    `json:"id" gorm:"primaryKey;autoIncrement"`
@@ -44,10 +55,15 @@ type Inbound struct {
 	TrafficReset         string               `json:"trafficReset" form:"trafficReset" gorm:"default:never;index:idx_enable_traffic_reset,priority:2"` // Traffic reset schedule
 	LastTrafficResetTime int64                `json:"lastTrafficResetTime" form:"lastTrafficResetTime" gorm:"default:0"`                               // Last traffic reset timestamp
 	ClientStats          []xray.ClientTraffic `gorm:"foreignKey:InboundId;references:Id" json:"clientStats" form:"clientStats"`                        // Client traffic statistics
+	GroupName            string               `json:"group" form:"group" gorm:"default:''"`                                                            // Named group for organizing inbounds
+	Tags                 string               `json:"tags" form:"tags" gorm:"default:''"`                                                              // Comma separated free-form tags
+	OutboundTag          string               `json:"outboundTag" form:"outboundTag" gorm:"default:''"`                                                // Outbound that all traffic of the inbound is routed to
+	MaxConnections       int                  `json:"maxConnections" form:"maxConnections" gorm:"default:0"`                                           // Maximum simultaneous TCP connections of the whole inbound, 0 means unlimited
 
 	// Xray configuration fields
 	Listen         string   `json:"listen" form:"listen"`
 	Port           int      `json:"port" form:"port"`
+	PortRange      string   `json:"portRange" form:"portRange" gorm:"default:''"` // Extra ports for port hopping, e.g. "20000-30000,40000"
 	Protocol       Protocol `json:"protocol" form:"protocol"`
 	Settings       string   `json:"settings" form:"settings"`
 	StreamSettings string   `json:"streamSettings" form:"streamSettings"`
@@ -64,6 +80,393 @@ type OutboundTraffics struct {
 	Total int64  `json:"total" form:"total" gorm:"default:0"`
 }
 
+// ClientPlan is a named package of limits that can be assigned to clients.
+type ClientPlan struct {
+	Id         int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name       string `json:"name" form:"name" gorm:"unique"`
+	TotalGB    int64  `json:"totalGB" form:"totalGB"`       // Traffic quota in bytes, 0 means unlimited
+	ExpiryDays int    `json:"expiryDays" form:"expiryDays"` // Duration in days, 0 means no expiry
+	LimitIP    int    `json:"limitIp" form:"limitIp"`       // IP limit, 0 means unlimited
+	SpeedTier  string `json:"speedTier" form:"speedTier"`   // Free-form speed tier label
+}
+
+// InboundHistory stores a snapshot of an inbound configuration taken before it was changed.
+type InboundHistory struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	InboundId int    `json:"inboundId" gorm:"index"`
+	CreatedAt int64  `json:"createdAt"`
+	Snapshot  string `json:"snapshot"` // Inbound encoded as JSON
+}
+
+// ClientNotice records the last expiry or quota threshold a client was notified about,
+// so the same notice is not sent again on every check.
+type ClientNotice struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Email     string `json:"email" gorm:"index"`
+	Kind      string `json:"kind"`      // "expiry" or "quota"
+	Threshold int    `json:"threshold"` // Days before expiry or percent of quota
+	CreatedAt int64  `json:"createdAt"`
+}
+
+// ClientTrafficDaily holds the traffic of a client on one day, in the panel time zone.
+type ClientTrafficDaily struct {
+	Id    int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Email string `json:"email" gorm:"uniqueIndex:idx_client_traffic_daily"`
+	Day   string `json:"day" gorm:"uniqueIndex:idx_client_traffic_daily"` // Formatted as 2006-01-02
+	Up    int64  `json:"up" gorm:"default:0"`
+	Down  int64  `json:"down" gorm:"default:0"`
+}
+
+// TrafficSample holds the traffic of an inbound or a client in one time bucket. Hourly samples
+// are rolled up into daily ones and daily into monthly ones as they age.
+type TrafficSample struct {
+	Id         int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Kind       string `json:"kind" gorm:"uniqueIndex:idx_traffic_sample"`       // "inbound" or "client"
+	Name       string `json:"name" gorm:"uniqueIndex:idx_traffic_sample"`       // Inbound tag or client email
+	Resolution string `json:"resolution" gorm:"uniqueIndex:idx_traffic_sample"` // "hour", "day" or "month"
+	Time       int64  `json:"time" gorm:"uniqueIndex:idx_traffic_sample"`       // Unix milliseconds of the start of the bucket, in the panel time zone
+	Up         int64  `json:"up" gorm:"default:0"`
+	Down       int64  `json:"down" gorm:"default:0"`
+}
+
+// ServerStatSample is a stored snapshot of the server load, taken at the history sampling interval.
+type ServerStatSample struct {
+	Id       int     `json:"-" gorm:"primaryKey;autoIncrement"`
+	Time     int64   `json:"time" gorm:"index"` // Unix seconds
+	Cpu      float64 `json:"cpu"`               // Percent 0..100
+	Mem      uint64  `json:"mem"`               // Used bytes
+	Swap     uint64  `json:"swap"`              // Used bytes
+	Disk     uint64  `json:"disk"`              // Used bytes
+	Load     float64 `json:"load"`              // One minute load average
+	NetUp    uint64  `json:"netUp"`             // Bytes per second
+	NetDown  uint64  `json:"netDown"`           // Bytes per second
+	TcpCount int     `json:"tcpCount"`
+	UdpCount int     `json:"udpCount"`
+}
+
+// AlertRule is a user defined condition the scheduler checks every minute. When it starts to hold,
+// an AlertEvent is recorded and sent to the administrators.
+type AlertRule struct {
+	Id            int     `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name          string  `json:"name" form:"name"`
+	Type          string  `json:"type" form:"type"`                   // "cpu", "disk", "dailyTraffic", "clientExpiry", "coreRestart", "speedtest" or "unreachable"
+	Threshold     float64 `json:"threshold" form:"threshold"`         // Percent for cpu and disk, GB for dailyTraffic, days for clientExpiry, Mbps for speedtest
+	Enable        bool    `json:"enable" form:"enable"`               // Whether the rule is evaluated
+	RepeatMinutes int     `json:"repeatMinutes" form:"repeatMinutes"` // Resend an unacknowledged alert after these minutes, 0 sends it once
+	SilencedUntil int64   `json:"silencedUntil" form:"silencedUntil"` // Unix seconds until which alerts are recorded but not sent
+}
+
+// NotifyChannel is a destination of the administrator notifications besides the Telegram bot.
+type NotifyChannel struct {
+	Id     int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name   string `json:"name" form:"name"`
+	Type   string `json:"type" form:"type"`     // "discord", "slack", "webhook" or "matrix"
+	Url    string `json:"url" form:"url"`       // Webhook URL the notifications are posted to, or the Matrix homeserver URL
+	Secret string `json:"secret" form:"secret"` // Generic webhooks: key of the HMAC-SHA256 signature sent in the X-Signature header; Matrix: access token
+	RoomId string `json:"roomId" form:"roomId"` // Matrix only: room the notifications are sent to, e.g. "!abc:example.org"
+	Events string `json:"events" form:"events"` // Comma separated events sent to the channel, empty for all events
+	Enable bool   `json:"enable" form:"enable"`
+
+	QuietHours   string `json:"quietHours" form:"quietHours"`     // Daily range such as "22:00-07:00" in the panel time zone during which only urgent events are sent, empty for none
+	BatchMinutes int    `json:"batchMinutes" form:"batchMinutes"` // Collect the events that are not urgent and send them as one digest every these minutes, 0 sends them immediately
+}
+
+// PendingNotification is a notification held back by the quiet hours or the batching of its
+// destination, to be sent later in a digest.
+type PendingNotification struct {
+	Id          int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Destination string `json:"destination" gorm:"index"` // "telegram", "email" or "channel:<id>"
+	Event       string `json:"event"`
+	Message     string `json:"message"`
+	CreatedAt   int64  `json:"createdAt"` // Unix seconds
+}
+
+// MessageTemplate is a customized notification message of one language, replacing the translated one.
+type MessageTemplate struct {
+	Id       int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Lang     string `json:"lang" gorm:"uniqueIndex:idx_message_template"`           // Language tag, e.g. "en-US"
+	Key      string `json:"key" gorm:"column:key;uniqueIndex:idx_message_template"` // Message ID, e.g. "tgbot.messages.cpuThreshold"
+	Template string `json:"template"`                                               // Go template filled with the fields of the event
+}
+
+// BotAuditLog records a command or button used by an admin or support agent of the Telegram bot.
+type BotAuditLog struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	TgId      int64  `json:"tgId" gorm:"index"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`    // "admin" or "support"
+	Action    string `json:"action"`  // Command or button, e.g. "/usage" or "reset_traffic_c"
+	Target    string `json:"target"`  // Arguments of the action, e.g. the client email
+	Allowed   bool   `json:"allowed"` // False when the role of the user does not permit the action
+	CreatedAt int64  `json:"createdAt" gorm:"index"`
+}
+
+// AlertEvent is one firing of an alert rule, open until its condition no longer holds.
+type AlertEvent struct {
+	Id         int     `json:"id" gorm:"primaryKey;autoIncrement"`
+	RuleId     int     `json:"ruleId" gorm:"index"`
+	Value      float64 `json:"value"`  // Measured value that crossed the threshold
+	Detail     string  `json:"detail"` // Extra context, e.g. the emails of the expiring clients
+	FiredAt    int64   `json:"firedAt"`
+	NotifiedAt int64   `json:"notifiedAt"` // Unix seconds of the last notification, 0 while silenced
+	AckAt      int64   `json:"ackAt"`      // Unix seconds of the acknowledgement, 0 if not acknowledged
+	ResolvedAt int64   `json:"resolvedAt"` // Unix seconds the condition stopped holding, 0 while firing
+}
+
+// SpeedtestResult is the measurement of one target by a scheduled or manual speedtest.
+type SpeedtestResult struct {
+	Id           int     `json:"id" gorm:"primaryKey;autoIncrement"`
+	Time         int64   `json:"time" gorm:"index"` // Unix seconds the test started
+	Target       string  `json:"target"`
+	LatencyMs    float64 `json:"latencyMs"`    // Average TCP connect time
+	DownloadMbps float64 `json:"downloadMbps"` // Throughput of the download
+	Bytes        int64   `json:"bytes"`        // Bytes downloaded
+	Error        string  `json:"error"`        // Why the test failed, empty on success
+}
+
+// TrafficAnomaly is an unusual traffic pattern of a client on one day, found by the anomaly detector.
+type TrafficAnomaly struct {
+	Id          int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Email       string `json:"email" gorm:"uniqueIndex:idx_traffic_anomaly"`
+	Type        string `json:"type" gorm:"uniqueIndex:idx_traffic_anomaly"` // "spike" or "constant"
+	Day         string `json:"day" gorm:"uniqueIndex:idx_traffic_anomaly"`  // Formatted as 2006-01-02
+	Usage       int64  `json:"usage"`                                       // Traffic of the client on the day
+	Baseline    int64  `json:"baseline"`                                    // Typical daily traffic of the client before the day
+	ActiveHours int    `json:"activeHours"`                                 // Hours of the day the client had traffic
+	DetectedAt  int64  `json:"detectedAt"`                                  // Unix seconds
+}
+
+// SubAccess records one fetch of a subscription.
+type SubAccess struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	SubId     string `json:"subId" gorm:"index"`
+	Ip        string `json:"ip"`
+	UserAgent string `json:"userAgent"`
+	Format    string `json:"format"` // "links", "json", "clash", "clash-meta", "sing-box" or "page"
+	CreatedAt int64  `json:"createdAt"`
+}
+
+// SubIdAlias keeps a rotated subscription ID working until its grace period ends.
+type SubIdAlias struct {
+	Id         int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	SubId      string `json:"subId" gorm:"unique"` // The old subscription ID
+	NewSubId   string `json:"newSubId" gorm:"index"`
+	ExpiryTime int64  `json:"expiryTime"` // Unix milliseconds after which the old ID stops working
+}
+
+// ShortLink maps a short code served by the subscription server to a subscription or share URL.
+type ShortLink struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Code      string `json:"code" gorm:"unique"`
+	Target    string `json:"target"`
+	Email     string `json:"email" gorm:"index"` // Client the link belongs to, empty for standalone links
+	Clicks    int64  `json:"clicks"`
+	LastClick int64  `json:"lastClick"` // Unix milliseconds of the last visit
+	CreatedAt int64  `json:"createdAt"`
+}
+
+// RoutingRule is a panel-managed Xray routing rule. List fields hold comma separated values
+// in the Xray rule syntax, e.g. "geosite:netflix,domain:example.com" or "geoip:private".
+type RoutingRule struct {
+	Id          int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Priority    int    `json:"priority" form:"priority" gorm:"index"` // Rules are applied in ascending priority
+	Enable      bool   `json:"enable" form:"enable"`
+	Remark      string `json:"remark" form:"remark"`
+	InboundTag  string `json:"inboundTag" form:"inboundTag"`
+	Domain      string `json:"domain" form:"domain"`
+	IP          string `json:"ip" form:"ip"`
+	Port        string `json:"port" form:"port"` // Ports and ranges, e.g. "53,443,1000-2000"
+	SourceIP    string `json:"sourceIP" form:"sourceIP"`
+	User        string `json:"user" form:"user"`         // Client emails
+	Network     string `json:"network" form:"network"`   // "tcp", "udp" or "tcp,udp"
+	Protocol    string `json:"protocol" form:"protocol"` // Sniffed protocols: http, tls, quic, bittorrent
+	OutboundTag string `json:"outboundTag" form:"outboundTag"`
+	BalancerTag string `json:"balancerTag" form:"balancerTag"`
+}
+
+// Outbound is a panel-managed Xray outbound. Only the fields of its protocol are used:
+// freedom and blackhole need none, socks and http dial an upstream server,
+// wireguard (also used for WARP) connects to a peer.
+type Outbound struct {
+	Id             int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Tag            string `json:"tag" form:"tag" gorm:"unique"`
+	Enable         bool   `json:"enable" form:"enable"`
+	Remark         string `json:"remark" form:"remark"`
+	Protocol       string `json:"protocol" form:"protocol"`             // "freedom", "blackhole", "socks", "http" or "wireguard"
+	Address        string `json:"address" form:"address"`               // Upstream server, or the WireGuard peer endpoint host
+	Port           int    `json:"port" form:"port"`                     // Upstream server or WireGuard peer endpoint port
+	Username       string `json:"username" form:"username"`             // socks/http authentication
+	Password       string `json:"password" form:"password"`             // socks/http authentication
+	PrivateKey     string `json:"privateKey" form:"privateKey"`         // WireGuard interface key
+	PeerPublicKey  string `json:"peerPublicKey" form:"peerPublicKey"`   // WireGuard peer key
+	LocalAddress   string `json:"localAddress" form:"localAddress"`     // Comma separated WireGuard interface addresses
+	Reserved       string `json:"reserved" form:"reserved"`             // Comma separated WireGuard reserved bytes, the WARP client ID
+	MTU            int    `json:"mtu" form:"mtu"`                       // WireGuard MTU, 0 uses the Xray default
+	DomainStrategy string `json:"domainStrategy" form:"domainStrategy"` // freedom/wireguard domain strategy, e.g. "UseIPv4" or "ForceIP"
+	DialerProxy    string `json:"dialerProxy" form:"dialerProxy"`       // Tag of the outbound this one connects through, for proxy chains
+	Warp           bool   `json:"warp" form:"warp"`                     // Registered as a Cloudflare WARP device by the panel
+}
+
+// WarpAccount is the Cloudflare WARP device registered for a panel-managed WireGuard outbound.
+// The WireGuard private key is kept in the outbound.
+type WarpAccount struct {
+	Id          int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	OutboundId  int    `json:"outboundId" gorm:"uniqueIndex"`
+	DeviceId    string `json:"deviceId"`
+	AccessToken string `json:"-"`
+	License     string `json:"license"`
+	AccountType string `json:"accountType"` // "free", "limited" for WARP+ or "unlimited"
+	PremiumData int64  `json:"premiumData"` // WARP+ data in bytes
+	Quota       int64  `json:"quota"`
+	UpdatedAt   int64  `json:"updatedAt"` // Unix milliseconds of the last refresh from Cloudflare
+}
+
+// Balancer is a panel-managed Xray balancer that spreads the traffic of routing rules
+// over the outbounds whose tags start with one of its selectors.
+type Balancer struct {
+	Id          int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Tag         string `json:"tag" form:"tag" gorm:"unique"`
+	Enable      bool   `json:"enable" form:"enable"`
+	Remark      string `json:"remark" form:"remark"`
+	Selector    string `json:"selector" form:"selector"`       // Comma separated outbound tag prefixes
+	Strategy    string `json:"strategy" form:"strategy"`       // "random", "roundRobin", "leastPing" or "leastLoad"
+	FallbackTag string `json:"fallbackTag" form:"fallbackTag"` // Outbound used when no selected outbound is alive
+}
+
+// DnsServer is a resolver of the panel-managed Xray DNS block. A server with domains
+// only resolves those domains; list fields hold comma separated values.
+type DnsServer struct {
+	Id            int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Priority      int    `json:"priority" form:"priority" gorm:"index"` // Servers are queried in ascending priority
+	Enable        bool   `json:"enable" form:"enable"`
+	Remark        string `json:"remark" form:"remark"`
+	Address       string `json:"address" form:"address"` // IP, "localhost", or a DoH/DoQ/TCP URL such as "https://1.1.1.1/dns-query"
+	Port          int    `json:"port" form:"port"`       // Port of a plain IP server, 0 means 53
+	Domains       string `json:"domains" form:"domains"` // Domains routed to this server, e.g. "geosite:cn,domain:example.com"
+	ExpectIPs     string `json:"expectIPs" form:"expectIPs"`
+	SkipFallback  bool   `json:"skipFallback" form:"skipFallback"`
+	QueryStrategy string `json:"queryStrategy" form:"queryStrategy"` // Overrides the global strategy: "UseIP", "UseIPv4" or "UseIPv6"
+}
+
+// ReverseProxy is a panel-managed Xray reverse proxy endpoint. A bridge runs on the host behind NAT
+// and dials out to a portal, which publishes the bridged service on its entry inbounds.
+type ReverseProxy struct {
+	Id        int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Tag       string `json:"tag" form:"tag" gorm:"unique"`
+	Enable    bool   `json:"enable" form:"enable"`
+	Remark    string `json:"remark" form:"remark"`
+	Type      string `json:"type" form:"type"`           // "bridge" or "portal"
+	Domain    string `json:"domain" form:"domain"`       // Internal domain shared by a bridge and its portal
+	TunnelTag string `json:"tunnelTag" form:"tunnelTag"` // Bridge: outbound to the portal; portal: inbound the bridge connects to
+	TargetTag string `json:"targetTag" form:"targetTag"` // Bridge: outbound to the local service; portal: comma separated entry inbounds
+}
+
+// Certificate is a certificate and key file pair of the certificate store. The panel and the
+// subscription server serve it to the clients asking for one of its domains, and the inbounds
+// using the store are given all the enabled certificates for Xray to pick one by SNI.
+type Certificate struct {
+	Id       int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Enable   bool   `json:"enable" form:"enable"`
+	Remark   string `json:"remark" form:"remark"`
+	Domains  string `json:"domains" form:"domains"` // Comma separated, wildcards allowed; the names of the certificate when empty
+	CertFile string `json:"certFile" form:"certFile"`
+	KeyFile  string `json:"keyFile" form:"keyFile"`
+}
+
+// CrashEvent records an unexpected exit of the proxy core and the output it wrote before it stopped.
+type CrashEvent struct {
+	Id       int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Time     int64  `json:"time" gorm:"index"` // Unix milliseconds when the exit was detected
+	CoreType string `json:"coreType"`
+	Version  string `json:"version"`
+	Error    string `json:"error"`   // Exit error of the process
+	Output   string `json:"output"`  // Last lines of the core output, newline separated
+	Attempt  int    `json:"attempt"` // Restart attempts since the core last ran stable
+}
+
+// ConnectionStat aggregates the connections of a client from one source IP to one destination
+// within an hour, as parsed from the Xray access log.
+type ConnectionStat struct {
+	Id          int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Bucket      int64  `json:"bucket" gorm:"uniqueIndex:idx_connection_stat"` // Unix seconds of the start of the hour
+	Email       string `json:"email" gorm:"uniqueIndex:idx_connection_stat"`
+	SourceIP    string `json:"sourceIp" gorm:"uniqueIndex:idx_connection_stat"`
+	Destination string `json:"destination" gorm:"uniqueIndex:idx_connection_stat"` // Domain or IP without port
+	Network     string `json:"network" gorm:"uniqueIndex:idx_connection_stat"`     // "tcp" or "udp"
+	InboundTag  string `json:"inboundTag"`
+	OutboundTag string `json:"outboundTag"`
+	Country     string `json:"country"` // Two letter code of the destination in geoip.dat, empty if unknown
+	ASN         uint   `json:"asn"`     // Autonomous system of the destination in GeoLite2-ASN, 0 if unknown
+	ASOrg       string `json:"asOrg"`   // Organization of the autonomous system
+	Count       int64  `json:"count"`
+	LastSeen    int64  `json:"lastSeen"` // Unix seconds
+}
+
+// Node is a server managed by this panel through the node agent of its own 3x-ui. The panel
+// pushes the assigned inbounds with their clients to the node and pulls their traffic.
+type Node struct {
+	Id          int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name        string `json:"name" form:"name"`
+	Enable      bool   `json:"enable" form:"enable"`
+	Group       string `json:"group" form:"group"`         // Group of the node, which sync policies select nodes by
+	Inbounds    string `json:"inbounds" form:"inbounds"`   // Comma separated IDs of the inbounds pushed to the node with all their clients
+	Address     string `json:"address" form:"address"`     // URL of the node agent, set when the node registers
+	Host        string `json:"host" form:"host"`           // Address of the node in subscription links, the host of the agent URL if empty
+	Region      string `json:"region" form:"region"`       // Region of the node, e.g. "DE", which subscriptions group its proxies by
+	Flag        string `json:"flag" form:"flag"`           // Flag emoji of the region, put in front of the names of its proxies
+	Labels      string `json:"labels" form:"labels"`       // Comma separated labels, e.g. "streaming", which subscriptions group proxies by too
+	Bandwidth   int    `json:"bandwidth" form:"bandwidth"` // Mbit/s of the uplink of the node, 0 if unknown, for the bandwidth headroom of placement
+	Token       string `json:"token" form:"-"`             // One-time registration token, empty once the node registered
+	TokenExpiry int64  `json:"tokenExpiry" form:"-"`       // Unix seconds
+	CertExpiry  int64  `json:"certExpiry" form:"-"`        // Unix seconds when the certificate of the node expires
+	ConfigHash  string `json:"-" form:"-"`                 // Hash of the configuration last pushed to the node
+	LastSeen    int64  `json:"lastSeen" form:"-"`          // Unix seconds of the last successful sync
+	LastError   string `json:"lastError" form:"-"`         // Error of the last sync, empty when it succeeded
+	Status      string `json:"status" form:"-"`            // JSON server status reported by the node
+	SyncStatus  string `json:"syncStatus" form:"-"`        // "synced", "conflict" or "error" after the last sync
+	Conflicts   string `json:"conflicts" form:"-"`         // Newline separated conflicts the node reported on the last push
+	LastSync    int64  `json:"lastSync" form:"-"`          // Unix seconds of the last push
+	Healthy     bool   `json:"healthy" form:"-"`           // Whether the node passed its last health check
+	HealthError string `json:"healthError" form:"-"`       // Why the node failed its last health check
+}
+
+// NodeSyncPolicy selects inbounds, and optionally only some of their clients, that are pushed to
+// a group of nodes on top of the inbounds assigned to every node.
+type NodeSyncPolicy struct {
+	Id        int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name      string `json:"name" form:"name"`
+	Enable    bool   `json:"enable" form:"enable"`
+	NodeGroup string `json:"nodeGroup" form:"nodeGroup"` // Group of the nodes the policy applies to, empty for all nodes
+	Inbounds  string `json:"inbounds" form:"inbounds"`   // Comma separated IDs of the inbounds to push
+	Groups    string `json:"groups" form:"groups"`       // Comma separated groups of the inbounds to push
+	Clients   string `json:"clients" form:"clients"`     // Comma separated email patterns of the clients to push, e.g. "vip-*", empty for all
+	Placement bool   `json:"placement" form:"placement"` // Push every client to a single node of the group, picked by load, instead of to all of them
+}
+
+// NodePlacement is the node of its group a client of a placement sync policy was pushed to.
+type NodePlacement struct {
+	Id       int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	PolicyId int    `json:"policyId" gorm:"uniqueIndex:idx_node_placement"`
+	Email    string `json:"email" gorm:"uniqueIndex:idx_node_placement"`
+	NodeId   int    `json:"nodeId" gorm:"index"`
+}
+
+// NodeTraffic is the last traffic counter of an inbound or client reported by a node, from
+// which the traffic used since the previous sync is derived, and the share of the usage on the
+// panel the node contributed.
+type NodeTraffic struct {
+	Id         int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	NodeId     int    `json:"nodeId" gorm:"uniqueIndex:idx_node_traffic"`
+	Kind       string `json:"kind" gorm:"uniqueIndex:idx_node_traffic"` // "inbound" or "client"
+	Name       string `json:"name" gorm:"uniqueIndex:idx_node_traffic"` // Inbound tag or client email
+	Up         int64  `json:"up"`
+	Down       int64  `json:"down"`
+	UsedUp     int64  `json:"usedUp"`     // Upload added to the panel since the usage there was last reset
+	UsedDown   int64  `json:"usedDown"`   // Download added to the panel since the usage there was last reset
+	Seen       int64  `json:"-"`          // Usage on the panel after the last sync, to notice when it is reset
+	LastOnline int64  `json:"lastOnline"` // Unix milliseconds of the last sync with traffic
+}
+
 // InboundClientIps stores IP addresses associated with inbound clients for access control.
 type InboundClientIps struct {
 	Id          int    `json:"id" gorm:"primaryKey;autoIncrement"`
@@ -83,17 +486,135 @@ func (i *Inbound) GenXrayInboundConfig() *xray.InboundConfig {
 	if listen != "" {
 		listen = fmt.Sprintf("\"%v\"", listen)
 	}
+	port := strconv.Itoa(i.Port)
+	if i.PortRange != "" {
+		port = fmt.Sprintf("\"%v,%v\"", i.Port, i.PortRange)
+	}
+	streamSettings := i.StreamSettings
+	if i.GetRealityPreviousKeys() != nil {
+		main := *i
+		main.SplitRealityGrace()
+		streamSettings = main.StreamSettings
+	}
 	return &xray.InboundConfig{
 		Listen:         json_util.RawMessage(listen),
-		Port:           i.Port,
+		Port:           json_util.RawMessage(port),
 		Protocol:       string(i.Protocol),
 		Settings:       json_util.RawMessage(i.Settings),
-		StreamSettings: json_util.RawMessage(i.StreamSettings),
+		StreamSettings: json_util.RawMessage(streamSettings),
 		Tag:            i.Tag,
 		Sniffing:       json_util.RawMessage(i.Sniffing),
 	}
 }
 
+// RealityPreviousKeys is the REALITY key pair an inbound used before its keys were rotated.
+// Until it is retired, connections with the previous key are passed on to a grace inbound on a
+// loopback port that still accepts it, so clients keep working until they refresh their links.
+type RealityPreviousKeys struct {
+	PrivateKey string   `json:"privateKey"`
+	PublicKey  string   `json:"publicKey"`
+	ShortIds   []string `json:"shortIds"`
+	Port       int      `json:"port"`  // Loopback port of the grace inbound
+	Until      int64    `json:"until"` // Unix milliseconds the previous keys are retired at
+}
+
+// realityStream returns the stream settings of an inbound and its REALITY settings, or nil
+// if the inbound does not use REALITY.
+func (i *Inbound) realityStream() (map[string]any, map[string]any) {
+	var stream map[string]any
+	if err := json.Unmarshal([]byte(i.StreamSettings), &stream); err != nil || stream["security"] != "reality" {
+		return nil, nil
+	}
+	realitySettings, _ := stream["realitySettings"].(map[string]any)
+	if realitySettings == nil {
+		return nil, nil
+	}
+	return stream, realitySettings
+}
+
+// GetRealityPreviousKeys returns the previous REALITY keys of an inbound still in their grace
+// period, or nil if there are none.
+func (i *Inbound) GetRealityPreviousKeys() *RealityPreviousKeys {
+	_, realitySettings := i.realityStream()
+	settings, _ := realitySettings["settings"].(map[string]any)
+	if settings["previousKeys"] == nil {
+		return nil
+	}
+	data, err := json.Marshal(settings["previousKeys"])
+	if err != nil {
+		return nil
+	}
+	previous := &RealityPreviousKeys{}
+	if err := json.Unmarshal(data, previous); err != nil || previous.PrivateKey == "" || previous.Port <= 0 {
+		return nil
+	}
+	return previous
+}
+
+// SplitRealityGrace turns an inbound with previous REALITY keys into the inbound passing the
+// connections it cannot authenticate on to the grace inbound, and returns the grace inbound,
+// which authenticates them with the previous keys and passes the rest on to the real target.
+// It returns nil and leaves the inbound as it is if there are no previous keys.
+func (i *Inbound) SplitRealityGrace() *Inbound {
+	previous := i.GetRealityPreviousKeys()
+	if previous == nil {
+		return nil
+	}
+
+	grace := *i
+	grace.Listen = "127.0.0.1"
+	grace.Port = previous.Port
+	grace.PortRange = ""
+	grace.Tag = xray.RealityGraceTag(i.Tag)
+	graceStream, graceReality := grace.realityStream()
+	graceReality["privateKey"] = previous.PrivateKey
+	graceReality["shortIds"] = previous.ShortIds
+	delete(graceReality["settings"].(map[string]any), "previousKeys")
+	if xver, _ := graceReality["xver"].(float64); xver > 0 {
+		// The inbound sends the PROXY protocol header to its target, which is now the grace inbound
+		sockopt, _ := graceStream["sockopt"].(map[string]any)
+		if sockopt == nil {
+			sockopt = map[string]any{}
+		}
+		sockopt["acceptProxyProtocol"] = true
+		graceStream["sockopt"] = sockopt
+	}
+	data, _ := json.MarshalIndent(graceStream, "", "  ")
+	grace.StreamSettings = string(data)
+
+	stream, realitySettings := i.realityStream()
+	target := fmt.Sprintf("127.0.0.1:%d", previous.Port)
+	if _, ok := realitySettings["target"]; !ok && realitySettings["dest"] != nil {
+		realitySettings["dest"] = target
+	} else {
+		realitySettings["target"] = target
+	}
+	delete(realitySettings["settings"].(map[string]any), "previousKeys")
+	data, _ = json.MarshalIndent(stream, "", "  ")
+	i.StreamSettings = string(data)
+	return &grace
+}
+
+// GetRealityPreviousInbound returns a copy of an inbound carrying its previous REALITY public key
+// and short IDs instead of the current ones, for the links of the previous keys, or nil if there
+// are no previous keys or their grace period is over.
+func (i *Inbound) GetRealityPreviousInbound() *Inbound {
+	previous := i.GetRealityPreviousKeys()
+	if previous == nil || previous.Until <= time.Now().UnixMilli() {
+		return nil
+	}
+	inbound := *i
+	stream, realitySettings := inbound.realityStream()
+	realitySettings["shortIds"] = previous.ShortIds
+	settings := realitySettings["settings"].(map[string]any)
+	settings["publicKey"] = previous.PublicKey
+	delete(settings, "previousKeys")
+	data, _ := json.MarshalIndent(stream, "", "  ")
+	inbound.StreamSettings = string(data)
+	inbound.Remark = i.Remark + " (previous key)"
+	return &inbound
+}
+
 // Setting stores key-value configuration settings for the 3x-ui panel.
 type Setting struct {
 	Id    int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
@@ -103,19 +624,27 @@ type Setting struct {
 
 // Client represents a client configuration for Xray inbounds with traffic limits and settings.
 type Client struct {
-	ID         string `json:"id"`                           // Unique client identifier
-	Security   string `json:"security"`                     // Security method (e.g., "auto", "aes-128-gcm")
-	Password   string `json:"password"`                     // Client password
-	Flow       string `json:"flow"`                         // Flow control (XTLS)
-	Email      string `json:"email"`                        // Client email identifier
-	LimitIP    int    `json:"limitIp"`                      // IP limit for this client
-	TotalGB    int64  `json:"totalGB" form:"totalGB"`       // Total traffic limit in GB
-	ExpiryTime int64  `json:"expiryTime" form:"expiryTime"` // Expiration timestamp
-	Enable     bool   `json:"enable" form:"enable"`         // Whether the client is enabled
-	TgID       int64  `json:"tgId" form:"tgId"`             // Telegram user ID for notifications
-	SubID      string `json:"subId" form:"subId"`           // Subscription identifier
-	Comment    string `json:"comment" form:"comment"`       // Client comment
-	Reset      int    `json:"reset" form:"reset"`           // Reset period in days
-	CreatedAt  int64  `json:"created_at,omitempty"`         // Creation timestamp
-	UpdatedAt  int64  `json:"updated_at,omitempty"`         // Last update timestamp
+	ID          string            `json:"id"`                                       // Unique client identifier
+	Security    string            `json:"security"`                                 // Security method (e.g., "auto", "aes-128-gcm")
+	Password    string            `json:"password"`                                 // Client password
+	Flow        string            `json:"flow"`                                     // Flow control (XTLS)
+	Email       string            `json:"email"`                                    // Client email identifier
+	LimitIP     int               `json:"limitIp"`                                  // IP limit for this client
+	SpeedLimit  int               `json:"speedLimit,omitempty" form:"speedLimit"`   // Throughput cap in Mbit/s per direction, shaped on Linux, 0 means unlimited
+	TotalGB     int64             `json:"totalGB" form:"totalGB"`                   // Total traffic limit in GB
+	ExpiryTime  int64             `json:"expiryTime" form:"expiryTime"`             // Expiration timestamp
+	Enable      bool              `json:"enable" form:"enable"`                     // Whether the client is enabled
+	TgID        int64             `json:"tgId" form:"tgId"`                         // Telegram user ID for notifications
+	SubID       string            `json:"subId" form:"subId"`                       // Subscription identifier
+	StatusToken string            `json:"statusToken,omitempty" form:"statusToken"` // Token for the read-only status API
+	Address     string            `json:"address,omitempty" form:"address"`         // Public address used in the links of this client
+	Comment     string            `json:"comment" form:"comment"`                   // Client comment
+	Reset       int               `json:"reset" form:"reset"`                       // Reset period in days
+	PlanId      int               `json:"planId,omitempty" form:"planId"`           // Assigned client plan
+	ResetPolicy string            `json:"resetPolicy,omitempty" form:"resetPolicy"` // Scheduled traffic reset: "monthly", "interval" or "renewal"
+	ResetValue  int               `json:"resetValue,omitempty" form:"resetValue"`   // Day of month for "monthly", number of days for "interval"
+	Note        string            `json:"note,omitempty" form:"note"`               // Free-text note, e.g. the customer name
+	Fields      map[string]string `json:"fields,omitempty" form:"-"`                // Custom metadata, e.g. invoice ID or reseller
+	CreatedAt   int64             `json:"created_at,omitempty"`                     // Creation timestamp
+	UpdatedAt   int64             `json:"updated_at,omitempty"`                     // Last update timestamp
 }
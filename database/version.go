@@ -0,0 +1,215 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"gorm.io/gorm"
+)
+
+// stateColumns are the columns the jobs update every few seconds or minutes without changing any
+// configuration: the traffic counters, and the sync state of the nodes.
+var stateColumns = map[string]bool{
+	"up": true, "down": true, "all_time": true, "last_online": true,
+	"last_error": true, "last_seen": true, "status": true, "config_hash": true, "sync_status": true,
+	"conflicts": true, "last_sync": true,
+}
+
+// tableVersion counts the committed writes to a table.
+type tableVersion struct {
+	count       atomic.Uint64
+	configCount atomic.Uint64 // Writes that changed more than the state columns
+}
+
+// bump records a write to the table, which changed only state columns if stateOnly is set.
+func (v *tableVersion) bump(stateOnly bool) {
+	v.count.Add(1)
+	if !stateOnly {
+		v.configCount.Add(1)
+	}
+}
+
+// isStateUpdate reports whether an update statement sets nothing but state columns, either as a
+// map or as the selected fields of a struct.
+func isStateUpdate(tx *gorm.DB) bool {
+	var columns []string
+	if values, ok := tx.Statement.Dest.(map[string]any); ok {
+		for column := range values {
+			columns = append(columns, column)
+		}
+	} else {
+		columns = tx.Statement.Selects
+	}
+	if len(columns) == 0 {
+		return false
+	}
+	for _, column := range columns {
+		if !stateColumns[column] {
+			return false
+		}
+	}
+	return true
+}
+
+// tableVersions holds the version of every table written to, by table name.
+var tableVersions sync.Map
+
+// anyTableVersion is bumped on raw statements and when the database is opened, as they may
+// change any table.
+var anyTableVersion tableVersion
+
+// versionOf returns the version of a table, or anyTableVersion for the empty name.
+func versionOf(table string) *tableVersion {
+	if table == "" {
+		return &anyTableVersion
+	}
+	v, _ := tableVersions.LoadOrStore(table, new(tableVersion))
+	return v.(*tableVersion)
+}
+
+// TableVersion returns a counter that changes whenever rows of the given tables are created,
+// updated or deleted, so caches built from them can tell they are out of date. Writes in a
+// transaction change it once the transaction commits, so a value read before can be cached.
+func TableVersion(tables ...string) uint64 {
+	count := anyTableVersion.count.Load()
+	for _, table := range tables {
+		count += versionOf(table).count.Load()
+	}
+	return count
+}
+
+// InboundsVersion returns a counter that changes whenever inbounds or their clients are
+// created, updated or deleted, so caches built from them can tell they are out of date. Updates
+// of only the traffic counters of inbounds leave it unchanged, as they do not change their
+// configuration.
+func InboundsVersion() uint64 {
+	return ConfigVersion("inbounds")
+}
+
+// ConfigVersion is TableVersion without the updates of only the state columns, such as the
+// traffic counters, so caches of the configuration in the tables are kept while traffic flows.
+func ConfigVersion(tables ...string) uint64 {
+	count := anyTableVersion.configCount.Load()
+	for _, table := range tables {
+		count += versionOf(table).configCount.Load()
+	}
+	return count
+}
+
+// versionedPool wraps the connection pool of the database, so the writes of the transactions
+// begun on it are only counted once the transaction commits.
+type versionedPool struct {
+	gorm.ConnPool
+}
+
+// BeginTx begins a transaction that counts its writes when it commits.
+func (p *versionedPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	var tx gorm.ConnPool
+	var err error
+	switch beginner := p.ConnPool.(type) {
+	case gorm.TxBeginner:
+		tx, err = beginner.BeginTx(ctx, opts)
+	case gorm.ConnPoolBeginner:
+		tx, err = beginner.BeginTx(ctx, opts)
+	default:
+		return nil, gorm.ErrInvalidTransaction
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &versionedTx{ConnPool: tx, writes: make(map[string]bool)}, nil
+}
+
+// GetDBConn returns the wrapped database.
+func (p *versionedPool) GetDBConn() (*sql.DB, error) {
+	if sqlDB, ok := p.ConnPool.(*sql.DB); ok {
+		return sqlDB, nil
+	}
+	if connector, ok := p.ConnPool.(gorm.GetDBConnector); ok {
+		return connector.GetDBConn()
+	}
+	return nil, gorm.ErrInvalidDB
+}
+
+// versionedTx is a transaction that holds back the writes to the table versions until it
+// commits, so no cache is built from data read while the transaction was still open and kept
+// after it changed that data.
+type versionedTx struct {
+	gorm.ConnPool
+	mu     sync.Mutex
+	writes map[string]bool // Tables written to, true while only their state columns were
+}
+
+// record notes a write to a table, the empty name standing for any table.
+func (t *versionedTx) record(table string, stateOnly bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if previous, ok := t.writes[table]; ok {
+		stateOnly = stateOnly && previous
+	}
+	t.writes[table] = stateOnly
+}
+
+// Commit commits the transaction and counts its writes. They are counted even when the commit
+// fails, as an extra change of a version only costs a cache miss.
+func (t *versionedTx) Commit() error {
+	err := t.ConnPool.(gorm.TxCommitter).Commit()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for table, stateOnly := range t.writes {
+		versionOf(table).bump(stateOnly)
+	}
+	return err
+}
+
+// Rollback rolls the transaction back, dropping its writes.
+func (t *versionedTx) Rollback() error {
+	return t.ConnPool.(gorm.TxCommitter).Rollback()
+}
+
+// recordWrite counts a write to a table right away, or when its transaction commits.
+func recordWrite(tx *gorm.DB, table string, stateOnly bool) {
+	if versioned, ok := tx.Statement.ConnPool.(*versionedTx); ok {
+		versioned.record(table, stateOnly)
+		return
+	}
+	versionOf(table).bump(stateOnly)
+}
+
+// registerVersionCallbacks hooks the write callbacks and the transactions of db to keep
+// TableVersion current.
+func registerVersionCallbacks(db *gorm.DB) error {
+	anyTableVersion.bump(false)
+	db.ConnPool = &versionedPool{ConnPool: db.ConnPool}
+	db.Statement.ConnPool = db.ConnPool
+
+	bump := func(tx *gorm.DB) {
+		if tx.Error == nil && tx.Statement.Table != "" {
+			recordWrite(tx, tx.Statement.Table, false)
+		}
+	}
+	callbacks := db.Callback()
+	if err := callbacks.Create().After("gorm:create").Register("version:create", bump); err != nil {
+		return err
+	}
+	err := callbacks.Update().After("gorm:update").Register("version:update", func(tx *gorm.DB) {
+		if tx.Error == nil && tx.Statement.Table != "" {
+			recordWrite(tx, tx.Statement.Table, isStateUpdate(tx))
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if err := callbacks.Delete().After("gorm:delete").Register("version:delete", bump); err != nil {
+		return err
+	}
+	return callbacks.Raw().After("gorm:raw").Register("version:raw", func(tx *gorm.DB) {
+		statement := strings.ToUpper(strings.TrimSpace(tx.Statement.SQL.String()))
+		if tx.Error == nil && !strings.HasPrefix(statement, "SELECT") && !strings.HasPrefix(statement, "PRAGMA") {
+			recordWrite(tx, "", false)
+		}
+	})
+}
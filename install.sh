@@ -93,27 +93,48 @@ config_after_install() {
 
     if [[ ${#existing_webBasePath} -lt 4 ]]; then
         if [[ "$existing_hasDefaultCredential" == "true" ]]; then
-            local config_webBasePath=$(gen_random_string 18)
-            local config_username=$(gen_random_string 10)
-            local config_password=$(gen_random_string 10)
+            # XUI_* variables configure an unattended install, as they do on the first start of the panel
+            local config_webBasePath=${XUI_WEB_BASE_PATH:-$(gen_random_string 18)}
+            local config_username=${XUI_ADMIN_USERNAME:-$(gen_random_string 10)}
+            local config_password=${XUI_ADMIN_PASSWORD:-$(gen_random_string 10)}
 
-            read -rp "Would you like to customize the Panel Port settings? (If not, a random port will be applied) [y/n]: " config_confirm
-            if [[ "${config_confirm}" == "y" || "${config_confirm}" == "Y" ]]; then
-                read -rp "Please set up the panel port: " config_port
+            if [[ -n "${XUI_PANEL_PORT}" ]]; then
+                local config_port=${XUI_PANEL_PORT}
                 echo -e "${yellow}Your Panel Port is: ${config_port}${plain}"
-            else
+            elif [[ ! -t 0 ]]; then
                 local config_port=$(shuf -i 1024-62000 -n 1)
                 echo -e "${yellow}Generated random port: ${config_port}${plain}"
+            else
+                read -rp "Would you like to customize the Panel Port settings? (If not, a random port will be applied) [y/n]: " config_confirm
+                if [[ "${config_confirm}" == "y" || "${config_confirm}" == "Y" ]]; then
+                    read -rp "Please set up the panel port: " config_port
+                    echo -e "${yellow}Your Panel Port is: ${config_port}${plain}"
+                else
+                    local config_port=$(shuf -i 1024-62000 -n 1)
+                    echo -e "${yellow}Generated random port: ${config_port}${plain}"
+                fi
             fi
 
             /usr/local/x-ui/x-ui setting -username "${config_username}" -password "${config_password}" -port "${config_port}" -webBasePath "${config_webBasePath}"
+            if [[ -n "${XUI_WEB_CERT_FILE}" && -n "${XUI_WEB_KEY_FILE}" ]]; then
+                /usr/local/x-ui/x-ui cert -webCert "${XUI_WEB_CERT_FILE}" -webCertKey "${XUI_WEB_KEY_FILE}"
+            fi
+            if [[ -n "${XUI_ACME_DOMAIN}" ]]; then
+                /usr/local/x-ui/x-ui cert -acme -domain "${XUI_ACME_DOMAIN}" -email "${XUI_ACME_EMAIL}"
+            fi
+            local config_scheme="http"
+            if [[ "${XUI_SELF_SIGNED}" == "true" && -z "${XUI_WEB_CERT_FILE}" ]]; then
+                /usr/local/x-ui/x-ui cert -selfSigned -domain "${server_ip}"
+                config_scheme="https"
+                echo -e "${yellow}The panel uses a self-signed certificate, accept the browser warning or set up a domain later.${plain}"
+            fi
             echo -e "This is a fresh installation, generating random login info for security concerns:"
             echo -e "###############################################"
             echo -e "${green}Username: ${config_username}${plain}"
             echo -e "${green}Password: ${config_password}${plain}"
             echo -e "${green}Port: ${config_port}${plain}"
             echo -e "${green}WebBasePath: ${config_webBasePath}${plain}"
-            echo -e "${green}Access URL: http://${server_ip}:${config_port}/${config_webBasePath}${plain}"
+            echo -e "${green}Access URL: ${config_scheme}://${server_ip}:${config_port}/${config_webBasePath}${plain}"
             echo -e "###############################################"
         else
             local config_webBasePath=$(gen_random_string 18)
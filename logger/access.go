@@ -0,0 +1,51 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+)
+
+// accessLogFileName is the file of the HTTP access log in the log folder.
+const accessLogFileName = "3xui-access.log"
+
+// accessLog is the HTTP access log, opened on the first request logged.
+var accessLog struct {
+	sync.Mutex
+	file *os.File
+}
+
+// GetAccessLogFilePath returns the path of the HTTP access log of the panel and the
+// subscription server.
+func GetAccessLogFilePath() string {
+	return filepath.Join(config.GetLogFolder(), accessLogFileName)
+}
+
+// WriteAccessLog appends a line to the HTTP access log. It is opened for appending, so that it
+// can be truncated in place when it is rotated.
+func WriteAccessLog(line string) {
+	accessLog.Lock()
+	defer accessLog.Unlock()
+	if accessLog.file == nil {
+		file, err := os.OpenFile(GetAccessLogFilePath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open access log: %v\n", err)
+			return
+		}
+		accessLog.file = file
+	}
+	accessLog.file.WriteString(line + "\n")
+}
+
+// closeAccessLog closes the HTTP access log, it is opened again on the next request logged.
+func closeAccessLog() {
+	accessLog.Lock()
+	defer accessLog.Unlock()
+	if accessLog.file != nil {
+		_ = accessLog.file.Close()
+		accessLog.file = nil
+	}
+}
@@ -0,0 +1,89 @@
+package logger
+
+import (
+	"fmt"
+	"maps"
+
+	"github.com/op/go-logging"
+)
+
+// RequestIDField is the field holding the ID of the HTTP request a message was logged for.
+const RequestIDField = "request_id"
+
+// Fields are structured values logged with a message.
+type Fields map[string]any
+
+// Entry logs messages with a set of fields.
+type Entry struct {
+	fields Fields
+}
+
+// WithFields returns an entry logging messages with the fields.
+func WithFields(fields Fields) *Entry {
+	return &Entry{fields: maps.Clone(fields)}
+}
+
+// WithField returns an entry logging messages with the field.
+func WithField(key string, value any) *Entry {
+	return &Entry{fields: Fields{key: value}}
+}
+
+// WithField returns a copy of the entry with the field added.
+func (e *Entry) WithField(key string, value any) *Entry {
+	fields := maps.Clone(e.fields)
+	if fields == nil {
+		fields = Fields{}
+	}
+	fields[key] = value
+	return &Entry{fields: fields}
+}
+
+// Debug logs a debug message with the fields of the entry.
+func (e *Entry) Debug(args ...any) {
+	output(logging.DEBUG, fmt.Sprint(args...), e.fields)
+}
+
+// Debugf logs a formatted debug message with the fields of the entry.
+func (e *Entry) Debugf(format string, args ...any) {
+	output(logging.DEBUG, fmt.Sprintf(format, args...), e.fields)
+}
+
+// Info logs an info message with the fields of the entry.
+func (e *Entry) Info(args ...any) {
+	output(logging.INFO, fmt.Sprint(args...), e.fields)
+}
+
+// Infof logs a formatted info message with the fields of the entry.
+func (e *Entry) Infof(format string, args ...any) {
+	output(logging.INFO, fmt.Sprintf(format, args...), e.fields)
+}
+
+// Notice logs a notice message with the fields of the entry.
+func (e *Entry) Notice(args ...any) {
+	output(logging.NOTICE, fmt.Sprint(args...), e.fields)
+}
+
+// Noticef logs a formatted notice message with the fields of the entry.
+func (e *Entry) Noticef(format string, args ...any) {
+	output(logging.NOTICE, fmt.Sprintf(format, args...), e.fields)
+}
+
+// Warning logs a warning message with the fields of the entry.
+func (e *Entry) Warning(args ...any) {
+	output(logging.WARNING, fmt.Sprint(args...), e.fields)
+}
+
+// Warningf logs a formatted warning message with the fields of the entry.
+func (e *Entry) Warningf(format string, args ...any) {
+	output(logging.WARNING, fmt.Sprintf(format, args...), e.fields)
+}
+
+// Error logs an error message with the fields of the entry.
+func (e *Entry) Error(args ...any) {
+	output(logging.ERROR, fmt.Sprint(args...), e.fields)
+}
+
+// Errorf logs a formatted error message with the fields of the entry.
+func (e *Entry) Errorf(format string, args ...any) {
+	output(logging.ERROR, fmt.Sprintf(format, args...), e.fields)
+}
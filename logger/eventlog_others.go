@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package logger
+
+import (
+	"errors"
+
+	"github.com/op/go-logging"
+)
+
+// EventlogSource is the source the panel writes to the Windows event log under.
+const EventlogSource = "x-ui"
+
+// newEventlogBackend fails, the event log only exists on Windows.
+func newEventlogBackend() (logging.Backend, error) {
+	return nil, errors.New("the event log is only available on Windows")
+}
+
+// runningAsService reports whether the panel runs as a Windows service, never elsewhere.
+func runningAsService() bool {
+	return false
+}
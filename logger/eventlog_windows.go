@@ -0,0 +1,49 @@
+//go:build windows
+// +build windows
+
+package logger
+
+import (
+	"strings"
+
+	"github.com/op/go-logging"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// EventlogSource is the source the panel writes to the Windows event log under, registered when
+// the service is installed.
+const EventlogSource = "x-ui"
+
+// eventlogBackend writes the messages to the Windows Application event log.
+type eventlogBackend struct {
+	log *eventlog.Log
+}
+
+// newEventlogBackend opens the event log of the panel source.
+func newEventlogBackend() (logging.Backend, error) {
+	log, err := eventlog.Open(EventlogSource)
+	if err != nil {
+		return nil, err
+	}
+	return &eventlogBackend{log: log}, nil
+}
+
+// Log writes the message as an error, a warning or an information event by its level.
+func (b *eventlogBackend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	msg := strings.TrimRight(rec.Formatted(calldepth+1), "\n")
+	switch level {
+	case logging.CRITICAL, logging.ERROR:
+		return b.log.Error(1, msg)
+	case logging.WARNING:
+		return b.log.Warning(1, msg)
+	default:
+		return b.log.Info(1, msg)
+	}
+}
+
+// runningAsService reports whether the panel runs as a Windows service, without a console.
+func runningAsService() bool {
+	inService, err := svc.IsWindowsService()
+	return err == nil && inService
+}
@@ -0,0 +1,89 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/op/go-logging"
+)
+
+// journaldSocket is where systemd-journald receives the messages of its native protocol.
+const journaldSocket = "/run/systemd/journal/socket"
+
+// journaldBackend sends the messages to systemd-journald with their priority, the module of the
+// caller and the fields of the message as journal fields.
+type journaldBackend struct {
+	conn *net.UnixConn
+}
+
+// newJournaldBackend connects to journald, it fails when journald is not running.
+func newJournaldBackend() (*journaldBackend, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journaldBackend{conn: conn}, nil
+}
+
+// Log sends the message without the time and level, which journald records itself.
+func (b *journaldBackend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	var buf bytes.Buffer
+	msg := ""
+	if m, ok := singleLogMessage(rec); ok {
+		msg = m.msg
+		for key, value := range m.fields {
+			if name := journaldFieldName(key); name != "" {
+				writeJournaldField(&buf, name, fmt.Sprint(value))
+			}
+		}
+	} else {
+		msg = rec.Message()
+	}
+	writeJournaldField(&buf, "MESSAGE", msg)
+	writeJournaldField(&buf, "PRIORITY", strconv.Itoa(syslogSeverity(level)))
+	writeJournaldField(&buf, "SYSLOG_IDENTIFIER", "x-ui")
+	if module := callerModule(calldepth + 1); module != "" {
+		writeJournaldField(&buf, "XUI_MODULE", module)
+	}
+	_, err := b.conn.Write(buf.Bytes())
+	return err
+}
+
+// journaldFieldName turns a field key into a journal field name: uppercase letters, digits and
+// underscores, not starting with an underscore or a digit. It is empty when nothing is left.
+func journaldFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, key)
+	name = strings.TrimLeft(name, "_0123456789")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// writeJournaldField writes a field of the native protocol, with the binary length form for
+// values spanning lines.
+func writeJournaldField(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+	buf.WriteByte('\n')
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
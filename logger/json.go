@@ -0,0 +1,99 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+// modulePath is stripped from the package of the caller to name the module of a message.
+const modulePath = "github.com/mhsanaei/3x-ui/v2/"
+
+// jsonRecord is a message as written by the JSON formatter.
+type jsonRecord struct {
+	Time      string         `json:"time"`
+	Level     string         `json:"level"`
+	Module    string         `json:"module"`
+	RequestID any            `json:"request_id,omitempty"`
+	Message   string         `json:"msg"`
+	Fields    map[string]any `json:"fields,omitempty"`
+}
+
+// jsonFormatter writes a message as a JSON object on one line, for log collectors.
+type jsonFormatter struct{}
+
+// Format writes the record with the package of the caller as its module. The request ID
+// field is written as a property of its own and the other fields under fields.
+func (jsonFormatter) Format(calldepth int, r *logging.Record, w io.Writer) error {
+	record := jsonRecord{
+		Time:   r.Time.Format(time.RFC3339Nano),
+		Level:  strings.ToLower(r.Level.String()),
+		Module: callerModule(calldepth + 1),
+	}
+	if m, ok := singleLogMessage(r); ok {
+		record.Message = m.msg
+		for key, value := range m.fields {
+			if key == RequestIDField {
+				record.RequestID = value
+				continue
+			}
+			if record.Fields == nil {
+				record.Fields = make(map[string]any, len(m.fields))
+			}
+			record.Fields[key] = jsonValue(value)
+		}
+	} else {
+		record.Message = r.Message()
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// singleLogMessage returns the message of a record logged by output.
+func singleLogMessage(r *logging.Record) (*logMessage, bool) {
+	if len(r.Args) != 1 {
+		return nil, false
+	}
+	m, ok := r.Args[0].(*logMessage)
+	return m, ok
+}
+
+// jsonValue returns a field value as it should be encoded, errors and stringers as their text
+// since they rarely have exported fields.
+func jsonValue(value any) any {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return value
+}
+
+// callerModule returns the package of the function calldepth frames up the stack, relative to
+// the panel module, like "web/service".
+func callerModule(calldepth int) string {
+	pc, _, _, ok := runtime.Caller(calldepth + 1)
+	if !ok {
+		return ""
+	}
+	fn := runtime.FuncForPC(pc)
+	if fn == nil {
+		return ""
+	}
+	name := strings.TrimPrefix(fn.Name(), modulePath)
+	slash := strings.LastIndex(name, "/")
+	if dot := strings.Index(name[slash+1:], "."); dot >= 0 {
+		name = name[:slash+1+dot]
+	}
+	return name
+}
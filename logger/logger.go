@@ -1,5 +1,6 @@
 // Package logger provides logging functionality for the 3x-ui panel with
 // dual-backend logging (console/syslog and file) and buffered log storage for web UI.
+// With XUI_LOG_FORMAT=json the backends write one JSON object per message.
 package logger
 
 import (
@@ -7,6 +8,9 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/mhsanaei/3x-ui/v2/config"
@@ -20,7 +24,6 @@ const (
 )
 
 var (
-	logger  *logging.Logger
 	logFile *os.File
 
 	// logBuffer maintains recent log entries in memory for web UI retrieval
@@ -29,41 +32,101 @@ var (
 		level logging.Level
 		log   string
 	}
+
+	// subscribers receive every new log entry, for live streaming
+	subscribersLock sync.Mutex
+	subscribers     = map[chan LogEntry]struct{}{}
 )
 
+// LogEntry is a log message as delivered to subscribers.
+type LogEntry struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Module  string `json:"module"` // The module the message was logged by, see Modules
+	Message string `json:"message"`
+}
+
 // InitLogger initializes dual logging backends: console/syslog and file.
-// Console logging uses the specified level, file logging always uses DEBUG level.
+// Console logging uses the specified level, or the level set for the module with
+// SetModuleLevel, file logging always uses DEBUG level.
 func InitLogger(level logging.Level) {
-	newLogger := logging.MustGetLogger("x-ui")
 	backends := make([]logging.Backend, 0, 2)
+	var consoleBackends []*leveledBackend
 
 	// Console/syslog backend with configurable level
 	if consoleBackend := initDefaultBackend(); consoleBackend != nil {
-		leveledBackend := logging.AddModuleLevel(consoleBackend)
-		leveledBackend.SetLevel(level, "x-ui")
+		leveledBackend := newLeveledBackend(consoleBackend, level)
+		consoleBackends = append(consoleBackends, leveledBackend)
 		backends = append(backends, leveledBackend)
 	}
 
+	// Remote syslog backend with the console level, when an address is set
+	if addr := config.GetLogSyslogAddr(); addr != "" {
+		if remoteBackend, err := newRemoteSyslogBackend(addr); err != nil {
+			fmt.Fprintf(os.Stderr, "remote syslog backend disabled: %v\n", err)
+		} else {
+			leveledBackend := newLeveledBackend(logging.NewBackendFormatter(remoteBackend, newMessageFormatter()), level)
+			consoleBackends = append(consoleBackends, leveledBackend)
+			backends = append(backends, leveledBackend)
+		}
+	}
+
 	// File backend with DEBUG level for comprehensive logging
 	if fileBackend := initFileBackend(); fileBackend != nil {
-		leveledBackend := logging.AddModuleLevel(fileBackend)
-		leveledBackend.SetLevel(logging.DEBUG, "x-ui")
-		backends = append(backends, leveledBackend)
+		backends = append(backends, newLeveledBackend(fileBackend, logging.DEBUG))
 	}
 
 	multiBackend := logging.MultiLogger(backends...)
-	newLogger.SetBackend(multiBackend)
-	logger = newLogger
+	newLoggers := make(map[string]*logging.Logger, len(Modules)+1)
+	for _, module := range append([]string{defaultModule}, Modules...) {
+		newLogger := logging.MustGetLogger(module)
+		// The package functions and output are between the caller and the logger
+		newLogger.ExtraCalldepth = 2
+		newLogger.SetBackend(multiBackend)
+		newLoggers[module] = newLogger
+	}
+
+	levels.Lock()
+	levels.level = level
+	levels.backends = consoleBackends
+	for module, moduleLevel := range levels.modules {
+		for _, backend := range consoleBackends {
+			backend.SetLevel(moduleLevel, module)
+		}
+	}
+	levels.Unlock()
+	loggers = newLoggers
 }
 
 // initDefaultBackend creates the console/syslog logging backend.
-// Windows: Uses stderr directly (no syslog support)
+// XUI_LOG_OUTPUT selects stderr, syslog, journald or eventlog, which fall back to stderr when
+// unavailable.
+// Windows: Uses stderr directly (no syslog support), or the event log when running as a service
 // Unix-like: Attempts syslog, falls back to stderr
 func initDefaultBackend() logging.Backend {
 	var backend logging.Backend
 	includeTime := false
+	output := config.GetLogOutput()
+
+	if output == config.LogOutputJournald {
+		journald, err := newJournaldBackend()
+		if err == nil {
+			return journald
+		}
+		fmt.Fprintf(os.Stderr, "journald backend disabled: %v\n", err)
+		output = config.LogOutputStderr
+	}
+
+	if output == config.LogOutputEventlog || (output == config.LogOutputAuto && runningAsService()) {
+		eventlog, err := newEventlogBackend()
+		if err == nil {
+			return logging.NewBackendFormatter(eventlog, newMessageFormatter())
+		}
+		fmt.Fprintf(os.Stderr, "event log backend disabled: %v\n", err)
+		output = config.LogOutputStderr
+	}
 
-	if runtime.GOOS == "windows" {
+	if runtime.GOOS == "windows" || output == config.LogOutputStderr {
 		// Windows: Use stderr directly (no syslog support)
 		backend = logging.NewLogBackend(os.Stderr, "", 0)
 		includeTime = true
@@ -90,8 +153,9 @@ func initFileBackend() logging.Backend {
 		return nil
 	}
 
-	logPath := filepath.Join(logDir, logFileName)
-	file, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o660)
+	// Appending lets the log be truncated in place when it is rotated
+	logPath := GetLogFilePath()
+	file, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_APPEND, 0o660)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to open log file %s: %v\n", logPath, err)
 		return nil
@@ -107,8 +171,17 @@ func initFileBackend() logging.Backend {
 	return logging.NewBackendFormatter(backend, newFormatter(true))
 }
 
-// newFormatter creates a log formatter with optional timestamp.
+// GetLogFilePath returns the path of the log file of the panel.
+func GetLogFilePath() string {
+	return filepath.Join(config.GetLogFolder(), logFileName)
+}
+
+// newFormatter creates a log formatter with optional timestamp, or the JSON formatter when
+// XUI_LOG_FORMAT is json, which always includes the timestamp.
 func newFormatter(withTime bool) logging.Formatter {
+	if config.GetLogFormat() == config.LogFormatJSON {
+		return jsonFormatter{}
+	}
 	format := `%{level} - %{message}`
 	if withTime {
 		format = `%{time:` + timeFormat + `} %{level} - %{message}`
@@ -116,6 +189,15 @@ func newFormatter(withTime bool) logging.Formatter {
 	return logging.MustStringFormatter(format)
 }
 
+// newMessageFormatter creates a formatter of the message alone, for the backends recording the
+// time and level themselves, or the JSON formatter when XUI_LOG_FORMAT is json.
+func newMessageFormatter() logging.Formatter {
+	if config.GetLogFormat() == config.LogFormatJSON {
+		return jsonFormatter{}
+	}
+	return logging.MustStringFormatter(`%{message}`)
+}
+
 // CloseLogger closes the log file and cleans up resources.
 // Should be called during application shutdown.
 func CloseLogger() {
@@ -123,70 +205,111 @@ func CloseLogger() {
 		_ = logFile.Close()
 		logFile = nil
 	}
+	closeAccessLog()
 }
 
 // Debug logs a debug message and adds it to the log buffer.
 func Debug(args ...any) {
-	logger.Debug(args...)
-	addToBuffer("DEBUG", fmt.Sprint(args...))
+	output(logging.DEBUG, fmt.Sprint(args...), nil)
 }
 
 // Debugf logs a formatted debug message and adds it to the log buffer.
 func Debugf(format string, args ...any) {
-	logger.Debugf(format, args...)
-	addToBuffer("DEBUG", fmt.Sprintf(format, args...))
+	output(logging.DEBUG, fmt.Sprintf(format, args...), nil)
 }
 
 // Info logs an info message and adds it to the log buffer.
 func Info(args ...any) {
-	logger.Info(args...)
-	addToBuffer("INFO", fmt.Sprint(args...))
+	output(logging.INFO, fmt.Sprint(args...), nil)
 }
 
 // Infof logs a formatted info message and adds it to the log buffer.
 func Infof(format string, args ...any) {
-	logger.Infof(format, args...)
-	addToBuffer("INFO", fmt.Sprintf(format, args...))
+	output(logging.INFO, fmt.Sprintf(format, args...), nil)
 }
 
 // Notice logs a notice message and adds it to the log buffer.
 func Notice(args ...any) {
-	logger.Notice(args...)
-	addToBuffer("NOTICE", fmt.Sprint(args...))
+	output(logging.NOTICE, fmt.Sprint(args...), nil)
 }
 
 // Noticef logs a formatted notice message and adds it to the log buffer.
 func Noticef(format string, args ...any) {
-	logger.Noticef(format, args...)
-	addToBuffer("NOTICE", fmt.Sprintf(format, args...))
+	output(logging.NOTICE, fmt.Sprintf(format, args...), nil)
 }
 
 // Warning logs a warning message and adds it to the log buffer.
 func Warning(args ...any) {
-	logger.Warning(args...)
-	addToBuffer("WARNING", fmt.Sprint(args...))
+	output(logging.WARNING, fmt.Sprint(args...), nil)
 }
 
 // Warningf logs a formatted warning message and adds it to the log buffer.
 func Warningf(format string, args ...any) {
-	logger.Warningf(format, args...)
-	addToBuffer("WARNING", fmt.Sprintf(format, args...))
+	output(logging.WARNING, fmt.Sprintf(format, args...), nil)
 }
 
 // Error logs an error message and adds it to the log buffer.
 func Error(args ...any) {
-	logger.Error(args...)
-	addToBuffer("ERROR", fmt.Sprint(args...))
+	output(logging.ERROR, fmt.Sprint(args...), nil)
 }
 
 // Errorf logs a formatted error message and adds it to the log buffer.
 func Errorf(format string, args ...any) {
-	logger.Errorf(format, args...)
-	addToBuffer("ERROR", fmt.Sprintf(format, args...))
+	output(logging.ERROR, fmt.Sprintf(format, args...), nil)
+}
+
+// output sends a message with its fields to the backends and adds it to the log buffer. It
+// must be called directly by the exported logging functions, ExtraCalldepth relies on it.
+func output(level logging.Level, msg string, fields Fields) {
+	m := &logMessage{msg: msg, fields: fields}
+	// The exported logging function is between output and its caller
+	logger := callerLogger(2)
+	switch level {
+	case logging.DEBUG:
+		logger.Debug(m)
+	case logging.INFO:
+		logger.Info(m)
+	case logging.NOTICE:
+		logger.Notice(m)
+	case logging.WARNING:
+		logger.Warning(m)
+	default:
+		logger.Error(m)
+	}
+	addToBuffer(level.String(), logger.Module, m.String())
+}
+
+// logMessage is a message with its fields, written as key=value pairs after the message by
+// the text formatter and as JSON properties by the JSON formatter.
+type logMessage struct {
+	msg    string
+	fields Fields
+}
+
+// String returns the message followed by its fields sorted by key.
+func (m *logMessage) String() string {
+	if len(m.fields) == 0 {
+		return m.msg
+	}
+	keys := make([]string, 0, len(m.fields))
+	for key := range m.fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(m.msg)
+	for _, key := range keys {
+		value := fmt.Sprint(m.fields[key])
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(&b, " %s=%s", key, value)
+	}
+	return b.String()
 }
 
 // addToBuffer adds a log entry to the in-memory ring buffer for web UI retrieval.
-func addToBuffer(level string, newLog string) {
+func addToBuffer(level string, module string, newLog string) {
 	t := time.Now()
 	if len(logBuffer) >= maxLogBufferSize {
 		logBuffer = logBuffer[1:]
@@ -202,6 +325,34 @@ func addToBuffer(level string, newLog string) {
 		level: logLevel,
 		log:   newLog,
 	})
+
+	subscribersLock.Lock()
+	defer subscribersLock.Unlock()
+	for ch := range subscribers {
+		// A subscriber that does not keep up misses entries instead of blocking the logger
+		select {
+		case ch <- LogEntry{Time: t.Format(timeFormat), Level: level, Module: module, Message: newLog}:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel that receives every new log entry, and a function that
+// ends the subscription and closes the channel.
+func Subscribe() (<-chan LogEntry, func()) {
+	ch := make(chan LogEntry, 256)
+	subscribersLock.Lock()
+	subscribers[ch] = struct{}{}
+	subscribersLock.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			subscribersLock.Lock()
+			delete(subscribers, ch)
+			subscribersLock.Unlock()
+			close(ch)
+		})
+	}
 }
 
 // GetLogs retrieves up to c log entries from the buffer that are at or below the specified level.
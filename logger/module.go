@@ -0,0 +1,172 @@
+package logger
+
+import (
+	"errors"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/op/go-logging"
+)
+
+// The subsystems whose console log level can be set apart from the default level. The messages
+// of the other packages are logged under the default module.
+const (
+	ModuleWeb      = "web"
+	ModuleXray     = "xray"
+	ModuleJobs     = "jobs"
+	ModuleTelegram = "telegram"
+	ModuleDatabase = "database"
+
+	defaultModule = "x-ui"
+)
+
+// Modules are the subsystems whose level can be set with SetModuleLevel.
+var Modules = []string{ModuleWeb, ModuleXray, ModuleJobs, ModuleTelegram, ModuleDatabase}
+
+var (
+	// loggers are the loggers of the modules, they share the backends
+	loggers map[string]*logging.Logger
+
+	// levels are the console level and the levels set for modules, applied to the backends
+	// logging at the console level
+	levels struct {
+		sync.Mutex
+		level    logging.Level
+		modules  map[string]logging.Level
+		backends []*leveledBackend
+	}
+)
+
+// GetLevel returns the console log level of the modules without a level of their own.
+func GetLevel() string {
+	levels.Lock()
+	defer levels.Unlock()
+	return strings.ToLower(levels.level.String())
+}
+
+// GetModuleLevels returns the console log level of every module.
+func GetModuleLevels() map[string]string {
+	levels.Lock()
+	defer levels.Unlock()
+	result := make(map[string]string, len(Modules))
+	for _, module := range Modules {
+		level, ok := levels.modules[module]
+		if !ok {
+			level = levels.level
+		}
+		result[module] = strings.ToLower(level.String())
+	}
+	return result
+}
+
+// SetModuleLevel sets the console log level of a module, or resets it to the default level when
+// level is empty. It takes effect at once.
+func SetModuleLevel(module, level string) error {
+	if !slices.Contains(Modules, module) {
+		return errors.New("unknown log module: " + module)
+	}
+	levels.Lock()
+	defer levels.Unlock()
+	if level == "" {
+		delete(levels.modules, module)
+		for _, backend := range levels.backends {
+			backend.SetLevel(levels.level, module)
+		}
+		return nil
+	}
+	lvl, err := logging.LogLevel(strings.ToUpper(level))
+	if err != nil {
+		return err
+	}
+	if levels.modules == nil {
+		levels.modules = make(map[string]logging.Level)
+	}
+	levels.modules[module] = lvl
+	for _, backend := range levels.backends {
+		backend.SetLevel(lvl, module)
+	}
+	return nil
+}
+
+// callerLogger returns the logger of the module of the function skip frames up the stack of
+// the caller of callerLogger.
+func callerLogger(skip int) *logging.Logger {
+	pc, file, _, ok := runtime.Caller(skip + 1)
+	if !ok {
+		return loggers[defaultModule]
+	}
+	module := defaultModule
+	if fn := runtime.FuncForPC(pc); fn != nil {
+		module = moduleOf(strings.TrimPrefix(fn.Name(), modulePath), filepath.Base(file))
+	}
+	return loggers[module]
+}
+
+// moduleOf returns the module of a function by its package relative to the panel module and
+// the name of its file, since the Telegram bot and the Xray management live in web/service.
+func moduleOf(function, file string) string {
+	switch {
+	case strings.HasPrefix(function, "database"):
+		return ModuleDatabase
+	case strings.HasPrefix(function, "xray."), strings.HasPrefix(function, "xray/"):
+		return ModuleXray
+	case strings.HasPrefix(function, "web/job."):
+		return ModuleJobs
+	case strings.HasPrefix(function, "web/service."):
+		switch {
+		case strings.HasPrefix(file, "tgbot"):
+			return ModuleTelegram
+		case strings.HasPrefix(file, "xray"):
+			return ModuleXray
+		}
+		return ModuleWeb
+	case strings.HasPrefix(function, "web."), strings.HasPrefix(function, "web/"),
+		strings.HasPrefix(function, "sub."), strings.HasPrefix(function, "sub/"):
+		return ModuleWeb
+	}
+	return defaultModule
+}
+
+// leveledBackend passes on the messages at or above the level of their module, which can be
+// changed while logging, unlike the module levels of go-logging.
+type leveledBackend struct {
+	backend logging.Backend
+
+	mu     sync.RWMutex
+	levels map[string]logging.Level // The level of the modules without one is under ""
+}
+
+// newLeveledBackend creates a backend logging the messages at or above level.
+func newLeveledBackend(backend logging.Backend, level logging.Level) *leveledBackend {
+	return &leveledBackend{backend: backend, levels: map[string]logging.Level{"": level}}
+}
+
+// Log passes the message on to the backend.
+func (b *leveledBackend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	return b.backend.Log(level, calldepth+1, rec)
+}
+
+// GetLevel returns the level of a module.
+func (b *leveledBackend) GetLevel(module string) logging.Level {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if level, ok := b.levels[module]; ok {
+		return level
+	}
+	return b.levels[""]
+}
+
+// SetLevel sets the level of a module, or of the modules without one when module is empty.
+func (b *leveledBackend) SetLevel(level logging.Level, module string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.levels[module] = level
+}
+
+// IsEnabledFor reports whether the messages of a module at a level are logged.
+func (b *leveledBackend) IsEnabledFor(level logging.Level, module string) bool {
+	return level <= b.GetLevel(module)
+}
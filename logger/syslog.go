@@ -0,0 +1,100 @@
+package logger
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+// syslogFacility is the daemon facility, under which the messages are sent to syslog.
+const syslogFacility = 3
+
+// syslogSeverity maps a level to its syslog severity, also used as the journald priority.
+func syslogSeverity(level logging.Level) int {
+	switch level {
+	case logging.CRITICAL:
+		return 2
+	case logging.ERROR:
+		return 3
+	case logging.WARNING:
+		return 4
+	case logging.NOTICE:
+		return 5
+	case logging.INFO:
+		return 6
+	default:
+		return 7
+	}
+}
+
+// remoteSyslogBackend sends the messages to a remote syslog server in the RFC 5424 format, over
+// UDP or over TCP with octet counting framing. A lost connection is opened again on the next
+// message.
+type remoteSyslogBackend struct {
+	mu       sync.Mutex
+	network  string
+	addr     string
+	hostname string
+	conn     net.Conn
+}
+
+// newRemoteSyslogBackend creates the backend for an address like udp://host:514.
+func newRemoteSyslogBackend(rawURL string) (*remoteSyslogBackend, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "udp" && u.Scheme != "tcp" {
+		return nil, fmt.Errorf("syslog address %q must start with udp:// or tcp://", rawURL)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "514")
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	return &remoteSyslogBackend{network: u.Scheme, addr: addr, hostname: hostname}, nil
+}
+
+// Log sends the formatted message with the module of the caller as its MSGID.
+func (b *remoteSyslogBackend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	module := callerModule(calldepth + 1)
+	if module == "" {
+		module = "-"
+	}
+	msg := fmt.Sprintf("<%d>1 %s %s x-ui %d %s - %s",
+		syslogFacility*8+syslogSeverity(level),
+		rec.Time.Format("2006-01-02T15:04:05.000000Z07:00"),
+		b.hostname, os.Getpid(), module,
+		strings.TrimRight(rec.Formatted(calldepth+1), "\n"))
+	if b.network == "tcp" {
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if b.conn == nil {
+			if b.conn, err = net.DialTimeout(b.network, b.addr, 5*time.Second); err != nil {
+				b.conn = nil
+				return err
+			}
+		}
+		b.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if _, err = b.conn.Write([]byte(msg)); err == nil {
+			return nil
+		}
+		b.conn.Close()
+		b.conn = nil
+	}
+	return err
+}
@@ -8,16 +8,23 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	_ "unsafe"
 
 	"github.com/mhsanaei/3x-ui/v2/config"
 	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/node"
 	"github.com/mhsanaei/3x-ui/v2/sub"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
 	"github.com/mhsanaei/3x-ui/v2/util/crypto"
+	"github.com/mhsanaei/3x-ui/v2/util/systemd"
 	"github.com/mhsanaei/3x-ui/v2/web"
 	"github.com/mhsanaei/3x-ui/v2/web/global"
+	"github.com/mhsanaei/3x-ui/v2/web/network"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
 
 	"github.com/joho/godotenv"
@@ -25,7 +32,9 @@ import (
 )
 
 // runWebServer initializes and starts the web server for the 3x-ui panel.
-func runWebServer() {
+// The first-run setup is applied before the servers start. The servers restart on SIGHUP and
+// stop on SIGTERM, received from the system or sent on signals by the Windows service.
+func runWebServer(setup *firstRunSetup, signals chan os.Signal) {
 	log.Printf("Starting %v %v", config.GetName(), config.GetVersion())
 
 	switch config.GetLogLevel() {
@@ -45,11 +54,22 @@ func runWebServer() {
 
 	godotenv.Load()
 
+	// Roll back an update whose new version failed to start, before it touches the database
+	panelService := service.PanelService{}
+	if err := panelService.PreparePanelStart(); err != nil {
+		log.Fatalf("Error rolling back panel update: %v", err)
+	}
+
 	err := database.InitDB(config.GetDBPath())
 	if err != nil {
 		log.Fatalf("Error initializing database: %v", err)
 	}
 
+	setup.loadEnv()
+	if err := applyFirstRunSetup(setup); err != nil {
+		log.Fatalf("Error applying first-run setup: %v", err)
+	}
+
 	var server *web.Server
 	server = web.NewServer()
 	global.SetWebServer(server)
@@ -68,15 +88,33 @@ func runWebServer() {
 		return
 	}
 
-	sigCh := make(chan os.Signal, 1)
+	var nodeServer *node.Server
+	nodeServer = node.NewServer()
+	err = nodeServer.Start()
+	if err != nil {
+		log.Fatalf("Error starting node agent: %v", err)
+		return
+	}
+
+	// Tell systemd the panel is up, and keep its watchdog notified while the panel is healthy
+	if err := systemd.Notify(systemd.Ready); err != nil {
+		logger.Warning("Error notifying systemd:", err)
+	}
+	panelService.ConfirmPanelStart()
+	if interval := systemd.WatchdogInterval(); interval > 0 {
+		watchdogService := service.WatchdogService{}
+		go watchdogService.Run(interval)
+	}
+
 	// Trap shutdown signals
-	signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGTERM)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM)
 	for {
-		sig := <-sigCh
+		sig := <-signals
 
 		switch sig {
 		case syscall.SIGHUP:
 			logger.Info("Received SIGHUP signal. Restarting servers...")
+			systemd.Notify(systemd.Reloading)
 
 			err := server.Stop()
 			if err != nil {
@@ -86,6 +124,10 @@ func runWebServer() {
 			if err != nil {
 				logger.Debug("Error stopping sub server:", err)
 			}
+			err = nodeServer.Stop()
+			if err != nil {
+				logger.Debug("Error stopping node agent:", err)
+			}
 
 			server = web.NewServer()
 			global.SetWebServer(server)
@@ -105,10 +147,25 @@ func runWebServer() {
 			}
 			log.Println("Sub server restarted successfully.")
 
+			nodeServer = node.NewServer()
+			err = nodeServer.Start()
+			if err != nil {
+				log.Fatalf("Error restarting node agent: %v", err)
+				return
+			}
+			// The sockets of the addresses no longer listened on
+			network.CloseIdleListeners()
+			systemd.Notify(systemd.Ready)
+
 		default:
+			systemd.Notify(systemd.Stopping)
 			server.Stop()
 			subServer.Stop()
-			database.CloseDB()
+			nodeServer.Stop()
+			network.CloseIdleListeners()
+			if err := database.CloseDB(); err != nil {
+				logger.Warning("Error closing database:", err)
+			}
 			log.Println("Shutting down servers.")
 			return
 		}
@@ -165,8 +222,13 @@ func showSetting(show bool) {
 			fmt.Println("current username or password is empty")
 		}
 
+		acmeEnable, err := settingService.GetAcmeEnable()
+		if err != nil {
+			fmt.Println("get acme setting failed, error info:", err)
+		}
+
 		fmt.Println("current panel settings as follows:")
-		if certFile == "" || keyFile == "" {
+		if (certFile == "" || keyFile == "") && !acmeEnable {
 			fmt.Println("Warning: Panel is not secure with SSL")
 		} else {
 			fmt.Println("Panel is secure with SSL")
@@ -182,6 +244,129 @@ func showSetting(show bool) {
 	}
 }
 
+// firstRunSeeder marks in the seeder history that the first-run setup was done.
+const firstRunSeeder = "FirstRunSetup"
+
+// firstRunSetup configures the panel on its first start without the interactive prompts of the
+// install script, for unattended provisioning with cloud-init, Ansible or a Docker entrypoint.
+// Each value comes from a flag of the run command or else from its environment variable.
+type firstRunSetup struct {
+	Username    string // XUI_ADMIN_USERNAME
+	Password    string // XUI_ADMIN_PASSWORD
+	Port        int    // XUI_PANEL_PORT
+	WebBasePath string // XUI_WEB_BASE_PATH
+	CertFile    string // XUI_WEB_CERT_FILE
+	KeyFile     string // XUI_WEB_KEY_FILE
+	AcmeDomain  string // XUI_ACME_DOMAIN
+	AcmeEmail   string // XUI_ACME_EMAIL
+	SelfSigned  bool   // XUI_SELF_SIGNED
+}
+
+// loadEnv fills the values not given as flags from the environment.
+func (f *firstRunSetup) loadEnv() {
+	setDefault := func(value *string, key string) {
+		if *value == "" {
+			*value = os.Getenv(key)
+		}
+	}
+	setDefault(&f.Username, "XUI_ADMIN_USERNAME")
+	setDefault(&f.Password, "XUI_ADMIN_PASSWORD")
+	setDefault(&f.WebBasePath, "XUI_WEB_BASE_PATH")
+	setDefault(&f.CertFile, "XUI_WEB_CERT_FILE")
+	setDefault(&f.KeyFile, "XUI_WEB_KEY_FILE")
+	setDefault(&f.AcmeDomain, "XUI_ACME_DOMAIN")
+	setDefault(&f.AcmeEmail, "XUI_ACME_EMAIL")
+	if f.Port == 0 {
+		f.Port, _ = strconv.Atoi(os.Getenv("XUI_PANEL_PORT"))
+	}
+	if !f.SelfSigned {
+		f.SelfSigned, _ = strconv.ParseBool(os.Getenv("XUI_SELF_SIGNED"))
+	}
+}
+
+// isEmpty reports whether the setup has nothing to apply.
+func (f *firstRunSetup) isEmpty() bool {
+	return *f == firstRunSetup{}
+}
+
+// applyFirstRunSetup applies the first-run setup once, to a panel that still has the default
+// credentials. Later starts leave the settings alone, so that changes made in the panel stick.
+func applyFirstRunSetup(setup *firstRunSetup) error {
+	if setup.isEmpty() {
+		return nil
+	}
+	db := database.GetDB()
+	var count int64
+	if err := db.Model(&model.HistoryOfSeeders{}).Where("seeder_name = ?", firstRunSeeder).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	userService := service.UserService{}
+	user, err := userService.GetFirstUser()
+	if err != nil {
+		return err
+	}
+	if user.Username != "admin" || !crypto.CheckPasswordHash(user.Password, "admin") {
+		logger.Info("First-run setup skipped, the panel is already configured")
+		return db.Create(&model.HistoryOfSeeders{SeederName: firstRunSeeder}).Error
+	}
+
+	if (setup.Username == "") != (setup.Password == "") {
+		return common.NewError("both the admin username and password must be set")
+	}
+	if (setup.CertFile == "") != (setup.KeyFile == "") {
+		return common.NewError("both the certificate and key files must be set")
+	}
+	if setup.Port < 0 || setup.Port > 65535 {
+		return common.NewError("invalid panel port:", setup.Port)
+	}
+
+	settingService := service.SettingService{}
+	if setup.Username != "" {
+		if err := userService.UpdateFirstUser(setup.Username, setup.Password); err != nil {
+			return err
+		}
+	}
+	if setup.Port > 0 {
+		if err := settingService.SetPort(setup.Port); err != nil {
+			return err
+		}
+	}
+	if setup.WebBasePath != "" {
+		if err := settingService.SetBasePath(setup.WebBasePath); err != nil {
+			return err
+		}
+	}
+	if setup.CertFile != "" {
+		if err := settingService.SetCertFile(setup.CertFile); err != nil {
+			return err
+		}
+		if err := settingService.SetKeyFile(setup.KeyFile); err != nil {
+			return err
+		}
+	} else if setup.SelfSigned {
+		// Encrypts the panel until a certificate for its domain is set up
+		if _, err := settingService.GenerateSelfSignedCert(nil, false); err != nil {
+			return err
+		}
+	}
+	if setup.AcmeDomain != "" {
+		if err := settingService.SetWebDomain(setup.AcmeDomain); err != nil {
+			return err
+		}
+		if err := settingService.SetAcmeEmail(setup.AcmeEmail); err != nil {
+			return err
+		}
+		if err := settingService.SetAcmeEnable(true); err != nil {
+			return err
+		}
+	}
+	logger.Info("First-run setup applied")
+	return db.Create(&model.HistoryOfSeeders{SeederName: firstRunSeeder}).Error
+}
+
 // updateTgbotEnableSts enables or disables the Telegram bot notifications based on the status parameter.
 func updateTgbotEnableSts(status bool) {
 	settingService := service.SettingService{}
@@ -327,6 +512,87 @@ func updateCert(publicKey string, privateKey string) {
 	}
 }
 
+// updateSelfSignedCert generates a certificate for the comma separated hosts and sets it as the
+// certificate of the panel.
+func updateSelfSignedCert(hosts string, withCA bool) {
+	err := database.InitDB(config.GetDBPath())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	settingService := service.SettingService{}
+	cert, err := settingService.GenerateSelfSignedCert(strings.Split(hosts, ","), withCA)
+	if err != nil {
+		fmt.Println("generate self-signed certificate failed:", err)
+		return
+	}
+	fmt.Println("self-signed certificate generated and set for the panel")
+	fmt.Println("certificate file:", cert.CertFile)
+	fmt.Println("private key file:", cert.KeyFile)
+	if cert.CaFile != "" {
+		fmt.Println("local CA certificate, to trust on the clients:", cert.CaFile)
+	}
+}
+
+// updateAcme enables the built-in ACME client for the panel domain, or disables it. A DNS provider
+// selects DNS-01 challenges, or else HTTP-01 and TLS-ALPN-01 are used.
+func updateAcme(enable bool, domain string, email string, dnsProvider string, dnsCredentials string, wildcard bool) {
+	err := database.InitDB(config.GetDBPath())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	settingService := service.SettingService{}
+	if enable {
+		if domain == "" {
+			if domain, err = settingService.GetWebDomain(); err != nil || domain == "" {
+				fmt.Println("the panel domain should be entered with -domain.")
+				return
+			}
+		} else if err = settingService.SetWebDomain(domain); err != nil {
+			fmt.Println("set panel domain failed:", err)
+			return
+		}
+		if email != "" {
+			if err = settingService.SetAcmeEmail(email); err != nil {
+				fmt.Println("set ACME email failed:", err)
+				return
+			}
+		}
+		challenge := "http"
+		if dnsProvider != "" {
+			challenge = "dns"
+			if err = settingService.SetAcmeDnsProvider(dnsProvider); err != nil {
+				fmt.Println("set ACME DNS provider failed:", err)
+				return
+			}
+			if dnsCredentials != "" {
+				if err = settingService.SetAcmeDnsCredentials(dnsCredentials); err != nil {
+					fmt.Println("set ACME DNS credentials failed:", err)
+					return
+				}
+			}
+		}
+		if err = settingService.SetAcmeChallenge(challenge); err != nil {
+			fmt.Println("set ACME challenge failed:", err)
+			return
+		}
+		if err = settingService.SetAcmeWildcard(wildcard && dnsProvider != ""); err != nil {
+			fmt.Println("set ACME wildcard failed:", err)
+			return
+		}
+	}
+	if err = settingService.SetAcmeEnable(enable); err != nil {
+		fmt.Println("set ACME failed:", err)
+	} else if enable {
+		fmt.Println("ACME enabled for", domain+", the certificate is obtained when the panel starts")
+	} else {
+		fmt.Println("ACME disabled")
+	}
+}
+
 // GetCertificate displays the current SSL certificate settings if getCert is true.
 func GetCertificate(getCert bool) {
 	if getCert {
@@ -342,6 +608,10 @@ func GetCertificate(getCert bool) {
 
 		fmt.Println("cert:", certFile)
 		fmt.Println("key:", keyFile)
+		if acmeEnable, err := settingService.GetAcmeEnable(); err == nil && acmeEnable {
+			domain, _ := settingService.GetWebDomain()
+			fmt.Println("acme:", domain)
+		}
 	}
 }
 
@@ -360,6 +630,28 @@ func GetListenIP(getListen bool) {
 	}
 }
 
+// registerNode registers this server as a node of a central panel with a registration token
+// issued by that panel.
+func registerNode(panelUrl string, token string, address string) {
+	if panelUrl == "" || token == "" || address == "" {
+		fmt.Println("the panel URL, the token and the address of the node should be entered.")
+		return
+	}
+	err := database.InitDB(config.GetDBPath())
+	if err != nil {
+		fmt.Println("Failed to initialize database:", err)
+		return
+	}
+
+	nodeAgentService := service.NodeAgentService{}
+	err = nodeAgentService.RegisterNodeAgent(panelUrl, token, address)
+	if err != nil {
+		fmt.Println("register node failed:", err)
+		return
+	}
+	fmt.Println("register node success, restart the panel to start the node agent")
+}
+
 // migrateDb performs database migration operations for the 3x-ui panel.
 func migrateDb() {
 	inboundService := service.InboundService{}
@@ -373,11 +665,44 @@ func migrateDb() {
 	fmt.Println("Migration done!")
 }
 
+// migrateDbToMySQL copies the SQLite database of the panel into a MySQL database and verifies the
+// copy. The panel then runs on MySQL once XUI_DB_CONNECTION=mysql and the XUI_DB_* variables are set.
+func migrateDbToMySQL(dbConfig *config.DatabaseConfig) {
+	if dbConfig.Host == "" || dbConfig.Database == "" || dbConfig.Username == "" {
+		fmt.Println("MySQL host, database and username are required")
+		os.Exit(1)
+	}
+	if dbConfig.Port == "" {
+		dbConfig.Port = "3306"
+	}
+	sqlitePath := config.GetSQLiteDBPath()
+	fmt.Printf("Copying %s to MySQL database %s on %s:%s...\n", sqlitePath, dbConfig.Database, dbConfig.Host, dbConfig.Port)
+	fmt.Println("Stop the panel first, changes made during the copy are lost")
+	tables, err := database.MigrateToMySQL(sqlitePath, dbConfig.MySQLDSN())
+	if err != nil {
+		fmt.Println("Migration failed:", err)
+		os.Exit(1)
+	}
+	var rows int64
+	for _, table := range tables {
+		fmt.Printf("  %-28s %8d rows  sha256 %s\n", table.Name, table.Rows, table.Digest[:16])
+		rows += table.Rows
+	}
+	fmt.Printf("Migration done and verified: %d tables, %d rows\n", len(tables), rows)
+	fmt.Println("To run the panel on MySQL, set these variables, e.g. in /usr/local/x-ui/.env, and restart it:")
+	fmt.Println("  XUI_DB_CONNECTION=mysql")
+	fmt.Println("  XUI_DB_HOST=" + dbConfig.Host)
+	fmt.Println("  XUI_DB_PORT=" + dbConfig.Port)
+	fmt.Println("  XUI_DB_DATABASE=" + dbConfig.Database)
+	fmt.Println("  XUI_DB_USERNAME=" + dbConfig.Username)
+	fmt.Println("  XUI_DB_PASSWORD=...")
+}
+
 // main is the entry point of the 3x-ui application.
 // It parses command-line arguments to run the web server, migrate database, or update settings.
 func main() {
 	if len(os.Args) < 2 {
-		runWebServer()
+		runWebServer(&firstRunSetup{}, make(chan os.Signal, 1))
 		return
 	}
 
@@ -385,6 +710,16 @@ func main() {
 	flag.BoolVar(&showVersion, "v", false, "show version")
 
 	runCmd := flag.NewFlagSet("run", flag.ExitOnError)
+	setup := &firstRunSetup{}
+	runCmd.StringVar(&setup.Username, "username", "", "Set admin username on first start")
+	runCmd.StringVar(&setup.Password, "password", "", "Set admin password on first start")
+	runCmd.IntVar(&setup.Port, "port", 0, "Set panel port number on first start")
+	runCmd.StringVar(&setup.WebBasePath, "webBasePath", "", "Set base path for Panel on first start")
+	runCmd.StringVar(&setup.CertFile, "webCert", "", "Set path to public key file for panel on first start")
+	runCmd.StringVar(&setup.KeyFile, "webCertKey", "", "Set path to private key file for panel on first start")
+	runCmd.StringVar(&setup.AcmeDomain, "acmeDomain", "", "Set panel domain to obtain an ACME certificate for on first start")
+	runCmd.StringVar(&setup.AcmeEmail, "acmeEmail", "", "Set contact email of the ACME account on first start")
+	runCmd.BoolVar(&setup.SelfSigned, "selfSigned", false, "Generate a self-signed panel certificate on first start")
 
 	settingCmd := flag.NewFlagSet("setting", flag.ExitOnError)
 	var port int
@@ -402,6 +737,14 @@ func main() {
 	var reset bool
 	var show bool
 	var getCert bool
+	var acmeEnable bool
+	var acmeDomain string
+	var acmeEmail string
+	var acmeDnsProvider string
+	var acmeDnsCredentials string
+	var acmeWildcard bool
+	var selfSigned bool
+	var selfSignedCA bool
 	var resetTwoFactor bool
 	settingCmd.BoolVar(&reset, "reset", false, "Reset all settings")
 	settingCmd.BoolVar(&show, "show", false, "Display current settings")
@@ -415,11 +758,43 @@ func main() {
 	settingCmd.BoolVar(&getCert, "getCert", false, "Display current certificate settings")
 	settingCmd.StringVar(&webCertFile, "webCert", "", "Set path to public key file for panel")
 	settingCmd.StringVar(&webKeyFile, "webCertKey", "", "Set path to private key file for panel")
+	settingCmd.BoolVar(&acmeEnable, "acme", false, "Obtain the panel certificate through the built-in ACME client")
+	settingCmd.StringVar(&acmeDomain, "domain", "", "Set panel domain to obtain the ACME certificate for")
+	settingCmd.StringVar(&acmeEmail, "email", "", "Set contact email of the ACME account")
+	settingCmd.StringVar(&acmeDnsProvider, "dns", "", "Use DNS-01 challenges with a DNS provider: cloudflare, route53, gandi or digitalocean")
+	settingCmd.StringVar(&acmeDnsCredentials, "dnsCredentials", "", "Set API credentials of the DNS provider as a JSON object")
+	settingCmd.BoolVar(&acmeWildcard, "wildcard", false, "Add the wildcard of the parent domain, with DNS-01 only")
+	settingCmd.BoolVar(&selfSigned, "selfSigned", false, "Generate a self-signed panel certificate for -domain, comma separated, or this server")
+	settingCmd.BoolVar(&selfSignedCA, "ca", false, "Issue the self-signed certificate from a local CA the clients can trust")
 	settingCmd.StringVar(&tgbottoken, "tgbottoken", "", "Set token for Telegram bot")
 	settingCmd.StringVar(&tgbotRuntime, "tgbotRuntime", "", "Set cron time for Telegram bot notifications")
 	settingCmd.StringVar(&tgbotchatid, "tgbotchatid", "", "Set chat ID for Telegram bot notifications")
 	settingCmd.BoolVar(&enabletgbot, "enabletgbot", false, "Enable notifications via Telegram bot")
 
+	migrateDbCmd := flag.NewFlagSet("migrate-db", flag.ExitOnError)
+	var migrateTo string
+	dbConfig := &config.DatabaseConfig{
+		Host:     os.Getenv("XUI_DB_HOST"),
+		Port:     os.Getenv("XUI_DB_PORT"),
+		Database: os.Getenv("XUI_DB_DATABASE"),
+		Username: os.Getenv("XUI_DB_USERNAME"),
+		Password: os.Getenv("XUI_DB_PASSWORD"),
+	}
+	migrateDbCmd.StringVar(&migrateTo, "to", "", "Set database to copy the SQLite database to, only mysql is supported")
+	migrateDbCmd.StringVar(&dbConfig.Host, "host", dbConfig.Host, "Set MySQL host, XUI_DB_HOST by default")
+	migrateDbCmd.StringVar(&dbConfig.Port, "port", dbConfig.Port, "Set MySQL port, XUI_DB_PORT or 3306 by default")
+	migrateDbCmd.StringVar(&dbConfig.Database, "database", dbConfig.Database, "Set MySQL database, XUI_DB_DATABASE by default")
+	migrateDbCmd.StringVar(&dbConfig.Username, "username", dbConfig.Username, "Set MySQL username, XUI_DB_USERNAME by default")
+	migrateDbCmd.StringVar(&dbConfig.Password, "password", dbConfig.Password, "Set MySQL password, XUI_DB_PASSWORD by default")
+
+	nodeCmd := flag.NewFlagSet("node", flag.ExitOnError)
+	var nodePanel string
+	var nodeToken string
+	var nodeAddress string
+	nodeCmd.StringVar(&nodePanel, "panel", "", "Set URL of the central panel, including its base path")
+	nodeCmd.StringVar(&nodeToken, "token", "", "Set registration token issued by the central panel")
+	nodeCmd.StringVar(&nodeAddress, "address", "", "Set https URL the central panel reaches this node agent at")
+
 	oldUsage := flag.Usage
 	flag.Usage = func() {
 		oldUsage()
@@ -427,7 +802,20 @@ func main() {
 		fmt.Println("Commands:")
 		fmt.Println("    run            run web panel")
 		fmt.Println("    migrate        migrate form other/old x-ui")
+		fmt.Println("    migrate-db     copy the SQLite database to MySQL")
 		fmt.Println("    setting        set settings")
+		fmt.Println("    node           register as a node of a central panel")
+		fmt.Println("    install-service install the panel as a Windows service started at boot")
+		fmt.Println("    remove-service remove the Windows service of the panel")
+		fmt.Println("    status         show panel status [-json]")
+		fmt.Println("    inbound        list inbounds: inbound list [-json]")
+		fmt.Println("    client         manage clients: client list|add|del [flags] [-json]")
+		fmt.Println("    backup         write a backup archive: backup [-dest DIR|FILE]")
+		fmt.Println("    restore        restore a backup archive or database file: restore ARCHIVE")
+		fmt.Println("    doctor         diagnose the panel and its environment [-json]")
+		fmt.Println("    import         import users exported from Marzban or Hiddify: import [flags] FILE")
+		fmt.Println("    report         print traffic per inbound and client: report [-period 30d] [-format csv|json]")
+		fmt.Println("    update         check for or install a release of the panel: update [-check] [-version vX.Y.Z]")
 	}
 
 	flag.Parse()
@@ -443,9 +831,27 @@ func main() {
 			fmt.Println(err)
 			return
 		}
-		runWebServer()
+		if !runWindowsService(setup) {
+			runWebServer(setup, make(chan os.Signal, 1))
+		}
+	case "install-service":
+		installWindowsService()
+	case "remove-service":
+		removeWindowsService()
 	case "migrate":
 		migrateDb()
+	case "migrate-db":
+		err := migrateDbCmd.Parse(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if migrateTo != "mysql" {
+			fmt.Println("Unsupported target database, use -to mysql")
+			migrateDbCmd.Usage()
+			os.Exit(2)
+		}
+		migrateDbToMySQL(dbConfig)
 	case "setting":
 		err := settingCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -480,14 +886,46 @@ func main() {
 		}
 		if reset {
 			updateCert("", "")
+			updateAcme(false, "", "", "", "", false)
+		} else if acmeEnable {
+			updateAcme(true, acmeDomain, acmeEmail, acmeDnsProvider, acmeDnsCredentials, acmeWildcard)
+		} else if selfSigned {
+			updateSelfSignedCert(acmeDomain, selfSignedCA)
 		} else {
 			updateCert(webCertFile, webKeyFile)
 		}
+	case "node":
+		err := nodeCmd.Parse(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		registerNode(nodePanel, nodeToken, nodeAddress)
+	case "status":
+		cliStatusCommand(os.Args[2:])
+	case "inbound":
+		cliInboundCommand(os.Args[2:])
+	case "client":
+		cliClientCommand(os.Args[2:])
+	case "backup":
+		cliBackupCommand(os.Args[2:])
+	case "restore":
+		cliRestoreCommand(os.Args[2:])
+	case "doctor":
+		cliDoctorCommand(os.Args[2:])
+	case "import":
+		cliImportCommand(os.Args[2:])
+	case "report":
+		cliReportCommand(os.Args[2:])
+	case "update":
+		cliUpdateCommand(os.Args[2:])
 	default:
 		fmt.Println("Invalid subcommands")
 		fmt.Println()
 		runCmd.Usage()
 		fmt.Println()
 		settingCmd.Usage()
+		fmt.Println()
+		nodeCmd.Usage()
 	}
 }
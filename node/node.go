@@ -0,0 +1,168 @@
+// Package node provides the node agent server of the 3x-ui panel, through which a central
+// panel manages the inbounds of this server over mutually authenticated TLS.
+package node
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/web/network"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// syncMaxBody limits the size of the inbounds pushed by the panel.
+const syncMaxBody = 64 << 20
+
+// Server is the node agent server the central panel pulls stats from and pushes inbounds to.
+type Server struct {
+	httpServer *http.Server
+	listener   net.Listener
+
+	settingService   service.SettingService
+	nodeAgentService service.NodeAgentService
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewServer creates a new node agent server instance with a cancellable context.
+func NewServer() *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// initRouter configures the routes of the node agent. Every request is authenticated by the
+// client certificate of the panel, checked during the TLS handshake.
+func (s *Server) initRouter() *gin.Engine {
+	gin.DefaultWriter = io.Discard
+	gin.DefaultErrorWriter = io.Discard
+	gin.SetMode(gin.ReleaseMode)
+
+	engine := gin.New()
+	engine.Use(gin.Recovery())
+	engine.GET("/stats", s.getStats)
+	engine.POST("/sync", s.sync)
+	engine.POST("/limits", s.limits)
+	return engine
+}
+
+// getStats reports the status of the server and the traffic of the managed inbounds.
+func (s *Server) getStats(c *gin.Context) {
+	stats, err := s.nodeAgentService.GetStats()
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// sync replaces the managed inbounds with those pushed by the panel and reports the pushed
+// inbounds that conflict with local ones.
+func (s *Server) sync(c *gin.Context) {
+	var inbounds []*model.Inbound
+	if err := json.NewDecoder(io.LimitReader(c.Request.Body, syncMaxBody)).Decode(&inbounds); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	result, err := s.nodeAgentService.SyncInbounds(inbounds, c.GetHeader(service.NodeConfigHashHeader))
+	if err != nil {
+		logger.Warning("Node agent sync failed:", err)
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// limits saves the limits of the managed clients, which the node agent enforces while the panel
+// cannot be reached.
+func (s *Server) limits(c *gin.Context) {
+	var limits []*service.NodeClientLimit
+	if err := json.NewDecoder(io.LimitReader(c.Request.Body, syncMaxBody)).Decode(&limits); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := s.nodeAgentService.SetClientLimits(limits); err != nil {
+		logger.Warning("Node agent saving client limits failed:", err)
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Start starts the node agent server if this server is registered as a node.
+func (s *Server) Start() (err error) {
+	defer func() {
+		if err != nil {
+			s.Stop()
+		}
+	}()
+
+	enable, err := s.settingService.GetNodeAgentEnable()
+	if err != nil {
+		return err
+	}
+	if !enable {
+		return nil
+	}
+
+	tlsConfig, err := s.settingService.NodeAgentTLSConfig()
+	if err != nil {
+		return err
+	}
+	listen, err := s.settingService.GetNodeAgentListen()
+	if err != nil {
+		return err
+	}
+	port, err := s.settingService.GetNodeAgentPort()
+	if err != nil {
+		return err
+	}
+
+	listener, err := network.Listen(net.JoinHostPort(listen, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	s.listener = tls.NewListener(listener, tlsConfig)
+	logger.Info("Node agent running on", listener.Addr())
+
+	s.httpServer = &http.Server{
+		Handler: s.initRouter(),
+	}
+
+	go func() {
+		s.httpServer.Serve(s.listener)
+	}()
+
+	return nil
+}
+
+// Stop gracefully shuts down the node agent server, waiting for the requests in flight, and closes
+// the listener, whose socket is kept for the next server on the same address.
+func (s *Server) Stop() error {
+	s.cancel()
+
+	var err1 error
+	var err2 error
+	if s.httpServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), network.DrainTimeout)
+		err1 = s.httpServer.Shutdown(ctx)
+		cancel()
+	}
+	if s.listener != nil {
+		err2 = s.listener.Close()
+	}
+	return common.Combine(err1, err2)
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// runWindowsService does nothing and reports false, services are only run on Windows.
+func runWindowsService(setup *firstRunSetup) bool {
+	return false
+}
+
+// installWindowsService fails, the panel is installed as a systemd or OpenRC service elsewhere.
+func installWindowsService() {
+	fmt.Println("Windows services are only supported on Windows, use x-ui.service or x-ui.rc instead")
+	os.Exit(1)
+}
+
+// removeWindowsService fails, services are only installed by the panel on Windows.
+func removeWindowsService() {
+	fmt.Println("Windows services are only supported on Windows")
+	os.Exit(1)
+}
@@ -0,0 +1,155 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// windowsServiceName is the name of the Windows service of the panel.
+const windowsServiceName = "x-ui"
+
+// windowsService runs the panel under the service control manager of Windows.
+type windowsService struct {
+	setup *firstRunSetup
+}
+
+// Execute runs the servers until the service is stopped or the system shuts down.
+func (s *windowsService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	go func() {
+		runWebServer(s.setup, signals)
+		close(done)
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case <-done:
+			return false, 0
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				signals <- syscall.SIGTERM
+				<-done
+				return false, 0
+			}
+		}
+	}
+}
+
+// runWindowsService runs the panel as a Windows service when started by the service control
+// manager, and reports whether it did. The working directory is set to that of the executable,
+// since services start in the system directory.
+func runWindowsService(setup *firstRunSetup) bool {
+	inService, err := svc.IsWindowsService()
+	if err != nil || !inService {
+		return false
+	}
+	if exePath, err := os.Executable(); err == nil {
+		os.Chdir(filepath.Dir(exePath))
+	}
+	if err := svc.Run(windowsServiceName, &windowsService{setup: setup}); err != nil {
+		if log, err := eventlog.Open(logger.EventlogSource); err == nil {
+			log.Error(1, fmt.Sprintf("Service %s failed: %v", windowsServiceName, err))
+			log.Close()
+		}
+		os.Exit(1)
+	}
+	return true
+}
+
+// installWindowsService installs the panel as a service started at boot and restarted when it
+// fails, registers its event log source and starts it.
+func installWindowsService() {
+	exePath, err := os.Executable()
+	if err != nil {
+		fmt.Println("Failed to find the executable:", err)
+		os.Exit(1)
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		fmt.Println("Failed to connect to the service manager, run as administrator:", err)
+		os.Exit(1)
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(windowsServiceName); err == nil {
+		s.Close()
+		fmt.Printf("Service %s is already installed\n", windowsServiceName)
+		os.Exit(1)
+	}
+	s, err := m.CreateService(windowsServiceName, exePath, mgr.Config{
+		DisplayName:  "3x-ui panel",
+		Description:  "Web panel of the Xray-core proxy server",
+		StartType:    mgr.StartAutomatic,
+		ErrorControl: mgr.ErrorNormal,
+	}, "run")
+	if err != nil {
+		fmt.Println("Failed to install the service:", err)
+		os.Exit(1)
+	}
+	defer s.Close()
+
+	restart := mgr.RecoveryAction{Type: mgr.ServiceRestart, Delay: 10 * time.Second}
+	if err := s.SetRecoveryActions([]mgr.RecoveryAction{restart, restart, restart}, uint32((24 * time.Hour).Seconds())); err != nil {
+		fmt.Println("Failed to set the service to restart on failure:", err)
+	}
+	if err := eventlog.InstallAsEventCreate(logger.EventlogSource, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		fmt.Println("Failed to register the event log source:", err)
+	}
+	if err := s.Start(); err != nil {
+		fmt.Println("Service installed, but failed to start:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Service %s installed and started, it starts at boot\n", windowsServiceName)
+}
+
+// removeWindowsService stops and removes the service of the panel and its event log source.
+func removeWindowsService() {
+	m, err := mgr.Connect()
+	if err != nil {
+		fmt.Println("Failed to connect to the service manager, run as administrator:", err)
+		os.Exit(1)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(windowsServiceName)
+	if err != nil {
+		fmt.Printf("Service %s is not installed\n", windowsServiceName)
+		os.Exit(1)
+	}
+	defer s.Close()
+
+	if status, err := s.Control(svc.Stop); err == nil {
+		// Wait a while for the servers to stop
+		for deadline := time.Now().Add(30 * time.Second); status.State != svc.Stopped && time.Now().Before(deadline); {
+			time.Sleep(500 * time.Millisecond)
+			if status, err = s.Query(); err != nil {
+				break
+			}
+		}
+	}
+	if err := s.Delete(); err != nil {
+		fmt.Println("Failed to remove the service:", err)
+		os.Exit(1)
+	}
+	eventlog.Remove(logger.EventlogSource)
+	fmt.Printf("Service %s removed\n", windowsServiceName)
+}
@@ -0,0 +1,68 @@
+package singbox
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	statsService "github.com/xtls/xray-core/app/stats/command"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// queryStatsMethod is the stats query of the sing-box V2Ray API. Its messages match the
+// Xray stats service, only the service is registered under a different name.
+const queryStatsMethod = "/experimental.v2rayapi.StatsService/QueryStats"
+
+// StatsAPI is a gRPC client for the traffic statistics of the sing-box V2Ray API.
+// sing-box has to be built with the with_v2ray_api tag for the API to be available.
+type StatsAPI struct {
+	grpcClient *grpc.ClientConn
+}
+
+// Init connects to the V2Ray API of sing-box on the given local port.
+func (a *StatsAPI) Init(apiPort int) error {
+	if apiPort <= 0 || apiPort > math.MaxUint16 {
+		return fmt.Errorf("invalid sing-box API port: %d", apiPort)
+	}
+
+	conn, err := grpc.NewClient(fmt.Sprintf("127.0.0.1:%d", apiPort), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return fmt.Errorf("failed to connect to sing-box API: %w", err)
+	}
+	a.grpcClient = conn
+	return nil
+}
+
+// Close closes the gRPC connection.
+func (a *StatsAPI) Close() {
+	if a.grpcClient != nil {
+		a.grpcClient.Close()
+	}
+	a.grpcClient = nil
+}
+
+// GetTraffic queries the inbound and user traffic counters, optionally resetting them.
+func (a *StatsAPI) GetTraffic(reset bool) ([]*xray.Traffic, []*xray.ClientTraffic, error) {
+	if a.grpcClient == nil {
+		return nil, nil, common.NewError("sing-box api is not initialized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	resp := &statsService.QueryStatsResponse{}
+	err := a.grpcClient.Invoke(ctx, queryStatsMethod, &statsService.QueryStatsRequest{Reset_: reset}, resp)
+	if err != nil {
+		logger.Debug("Failed to query sing-box stats:", err)
+		return nil, nil, err
+	}
+
+	traffic, clientTraffic := xray.TrafficFromStats(resp.GetStat())
+	return traffic, clientTraffic, nil
+}
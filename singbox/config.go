@@ -0,0 +1,39 @@
+package singbox
+
+import (
+	"bytes"
+
+	"github.com/mhsanaei/3x-ui/v2/util/json_util"
+)
+
+// Config represents the complete sing-box configuration structure.
+// Inbounds are kept as raw JSON objects since their shape depends on the inbound type.
+type Config struct {
+	Log          json_util.RawMessage   `json:"log,omitempty"`
+	DNS          json_util.RawMessage   `json:"dns,omitempty"`
+	NTP          json_util.RawMessage   `json:"ntp,omitempty"`
+	Inbounds     []json_util.RawMessage `json:"inbounds"`
+	Outbounds    json_util.RawMessage   `json:"outbounds,omitempty"`
+	Endpoints    json_util.RawMessage   `json:"endpoints,omitempty"`
+	Route        json_util.RawMessage   `json:"route,omitempty"`
+	Experimental json_util.RawMessage   `json:"experimental,omitempty"`
+}
+
+// Equals compares two Config instances for deep equality.
+func (c *Config) Equals(other *Config) bool {
+	if len(c.Inbounds) != len(other.Inbounds) {
+		return false
+	}
+	for i, inbound := range c.Inbounds {
+		if !bytes.Equal(inbound, other.Inbounds[i]) {
+			return false
+		}
+	}
+	return bytes.Equal(c.Log, other.Log) &&
+		bytes.Equal(c.DNS, other.DNS) &&
+		bytes.Equal(c.NTP, other.NTP) &&
+		bytes.Equal(c.Outbounds, other.Outbounds) &&
+		bytes.Equal(c.Endpoints, other.Endpoints) &&
+		bytes.Equal(c.Route, other.Route) &&
+		bytes.Equal(c.Experimental, other.Experimental)
+}
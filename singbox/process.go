@@ -0,0 +1,292 @@
+// Package singbox provides integration with the sing-box proxy core.
+// It includes configuration types, process control and a stats client for sing-box instances,
+// so the panel can run sing-box in place of Xray.
+package singbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+)
+
+// GetBinaryName returns the sing-box binary filename for the current OS and architecture.
+func GetBinaryName() string {
+	return fmt.Sprintf("sing-box-%s-%s", runtime.GOOS, runtime.GOARCH)
+}
+
+// GetBinaryPath returns the full path to the sing-box binary executable.
+func GetBinaryPath() string {
+	return config.GetBinFolderPath() + "/" + GetBinaryName()
+}
+
+// GetConfigPath returns the path to the sing-box configuration file in the binary folder.
+func GetConfigPath() string {
+	return config.GetBinFolderPath() + "/sing-box.json"
+}
+
+// stopProcess calls Stop on the given Process instance.
+func stopProcess(p *Process) {
+	p.Stop()
+}
+
+// Process wraps a sing-box process instance and provides management methods.
+type Process struct {
+	*process
+}
+
+// NewProcess creates a new sing-box process and sets up cleanup on garbage collection.
+func NewProcess(singboxConfig *Config) *Process {
+	p := &Process{newProcess(singboxConfig)}
+	runtime.SetFinalizer(p, stopProcess)
+	return p
+}
+
+type process struct {
+	cmd *exec.Cmd
+
+	version string
+	apiPort int
+
+	onlineClients []string
+
+	config    *Config
+	logWriter *logWriter
+	exitErr   error
+	startTime time.Time
+}
+
+// newProcess creates a new internal process struct for sing-box.
+func newProcess(config *Config) *process {
+	return &process{
+		version:   "Unknown",
+		config:    config,
+		logWriter: &logWriter{},
+		startTime: time.Now(),
+	}
+}
+
+// IsRunning returns true if the sing-box process is currently running.
+func (p *process) IsRunning() bool {
+	if p.cmd == nil || p.cmd.Process == nil {
+		return false
+	}
+	return p.cmd.ProcessState == nil
+}
+
+// GetErr returns the last error encountered by the sing-box process.
+func (p *process) GetErr() error {
+	return p.exitErr
+}
+
+// GetResult returns the last error line or exit error of the sing-box process.
+func (p *process) GetResult() string {
+	if len(p.logWriter.lastLine) == 0 && p.exitErr != nil {
+		return p.exitErr.Error()
+	}
+	return p.logWriter.lastLine
+}
+
+// GetLastLines returns the last lines the sing-box process wrote to its output.
+func (p *process) GetLastLines() []string {
+	p.logWriter.mu.Lock()
+	defer p.logWriter.mu.Unlock()
+	return append([]string(nil), p.logWriter.tail...)
+}
+
+// GetVersion returns the version string of the sing-box process.
+func (p *process) GetVersion() string {
+	return p.version
+}
+
+// GetAPIPort returns the port of the V2Ray API used for traffic statistics.
+func (p *Process) GetAPIPort() int {
+	return p.apiPort
+}
+
+// GetConfig returns the configuration used by the sing-box process.
+func (p *Process) GetConfig() *Config {
+	return p.config
+}
+
+// GetOnlineClients returns the list of online clients for the sing-box process.
+func (p *Process) GetOnlineClients() []string {
+	return p.onlineClients
+}
+
+// SetOnlineClients sets the list of online clients for the sing-box process.
+func (p *Process) SetOnlineClients(users []string) {
+	p.onlineClients = users
+}
+
+// GetUptime returns the uptime of the sing-box process in seconds.
+func (p *Process) GetUptime() uint64 {
+	return uint64(time.Since(p.startTime).Seconds())
+}
+
+// refreshAPIPort reads the V2Ray API port from the experimental section of the config.
+func (p *process) refreshAPIPort() {
+	experimental := struct {
+		V2RayAPI struct {
+			Listen string `json:"listen"`
+		} `json:"v2ray_api"`
+	}{}
+	if err := json.Unmarshal(p.config.Experimental, &experimental); err != nil {
+		return
+	}
+	_, port, err := net.SplitHostPort(experimental.V2RayAPI.Listen)
+	if err != nil {
+		return
+	}
+	p.apiPort, _ = strconv.Atoi(port)
+}
+
+// refreshVersion updates the version string by running the sing-box binary with version.
+func (p *process) refreshVersion() {
+	version, err := GetBinaryVersion()
+	if err != nil {
+		p.version = "Unknown"
+		return
+	}
+	p.version = version
+}
+
+// GetBinaryVersion runs the sing-box binary with version and returns the reported version.
+func GetBinaryVersion() (string, error) {
+	data, err := exec.Command(GetBinaryPath(), "version").Output()
+	if err != nil {
+		return "", err
+	}
+	// The first line reads "sing-box version 1.11.0"
+	fields := strings.Fields(strings.SplitN(string(data), "\n", 2)[0])
+	if len(fields) < 3 {
+		return "", errors.New("unexpected sing-box version output")
+	}
+	return fields[2], nil
+}
+
+// writeConfig writes the configuration of the process to the configuration file.
+func (p *process) writeConfig() error {
+	data, err := json.MarshalIndent(p.config, "", "  ")
+	if err != nil {
+		return common.NewErrorf("Failed to generate sing-box configuration file: %v", err)
+	}
+	err = os.WriteFile(GetConfigPath(), data, fs.ModePerm)
+	if err != nil {
+		return common.NewErrorf("Failed to write configuration file: %v", err)
+	}
+	return nil
+}
+
+// Start launches the sing-box process with the current configuration.
+func (p *process) Start() (err error) {
+	if p.IsRunning() {
+		return errors.New("sing-box is already running")
+	}
+
+	defer func() {
+		if err != nil {
+			logger.Error("Failure in running sing-box process: ", err)
+			p.exitErr = err
+		}
+	}()
+
+	err = os.MkdirAll(config.GetLogFolder(), 0o770)
+	if err != nil {
+		logger.Warningf("Failed to create log folder: %s", err)
+	}
+
+	err = p.writeConfig()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(GetBinaryPath(), "run", "-c", GetConfigPath())
+	p.cmd = cmd
+
+	cmd.Stdout = p.logWriter
+	cmd.Stderr = p.logWriter
+
+	go func() {
+		err := cmd.Run()
+		if err != nil {
+			// On Windows, killing the process results in "exit status 1" which isn't an error for us
+			if runtime.GOOS == "windows" && strings.Contains(strings.ToLower(err.Error()), "exit status 1") {
+				p.exitErr = err
+				return
+			}
+			logger.Error("Failure in running sing-box:", err)
+			p.exitErr = err
+		}
+	}()
+
+	p.refreshVersion()
+	p.refreshAPIPort()
+
+	return nil
+}
+
+// Stop terminates the running sing-box process.
+func (p *process) Stop() error {
+	if !p.IsRunning() {
+		return errors.New("sing-box is not running")
+	}
+
+	if runtime.GOOS == "windows" {
+		return p.cmd.Process.Kill()
+	}
+	return p.cmd.Process.Signal(syscall.SIGTERM)
+}
+
+// logWriter forwards the output of the sing-box process to the panel log
+// and keeps the last error line to report why the core stopped.
+type logWriter struct {
+	lastLine string
+
+	mu   sync.Mutex
+	tail []string // Last lines of output, to report why the core stopped
+}
+
+// logTailSize is the number of output lines kept in the tail.
+const logTailSize = 20
+
+// Write logs every line written by sing-box at the level found in the line.
+func (lw *logWriter) Write(m []byte) (n int, err error) {
+	for line := range strings.SplitSeq(strings.TrimSpace(string(m)), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		lw.mu.Lock()
+		lw.tail = append(lw.tail, line)
+		if len(lw.tail) > logTailSize {
+			lw.tail = append([]string(nil), lw.tail[len(lw.tail)-logTailSize:]...)
+		}
+		lw.mu.Unlock()
+		switch {
+		case strings.Contains(line, "FATAL") || strings.Contains(line, "PANIC") || strings.Contains(line, "ERROR"):
+			logger.Error("SING-BOX: " + line)
+			lw.lastLine = line
+		case strings.Contains(line, "WARN"):
+			logger.Warning("SING-BOX: " + line)
+		case strings.Contains(line, "INFO"):
+			logger.Info("SING-BOX: " + line)
+		default:
+			logger.Debug("SING-BOX: " + line)
+		}
+	}
+	return len(m), nil
+}
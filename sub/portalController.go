@@ -0,0 +1,103 @@
+package sub
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+)
+
+// PortalController serves the read-only handlers of the client self-service portal.
+type PortalController struct {
+	portalPath  string
+	jsonEnabled bool
+
+	subService     *SubService
+	subJsonService *SubJsonService
+	portalService  *PortalService
+}
+
+// NewPortalController creates the portal controller and registers its routes on the given router group.
+func NewPortalController(g *gin.RouterGroup, portalPath string, jsonEnabled bool, sub *SUBController) *PortalController {
+	a := &PortalController{
+		portalPath:  portalPath,
+		jsonEnabled: jsonEnabled,
+
+		subService:     sub.subService,
+		subJsonService: sub.subJsonService,
+		portalService:  NewPortalService(sub.subService),
+	}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter registers the portal routes, all scoped by the subscription ID.
+func (a *PortalController) initRouter(g *gin.RouterGroup) {
+	gPortal := g.Group(a.portalPath + ":subid")
+	gPortal.GET("", a.info)
+	gPortal.GET("/qr/:email", a.qr)
+	gPortal.GET("/configs", a.configs)
+	if a.jsonEnabled {
+		gPortal.GET("/json", a.json)
+	}
+}
+
+// info returns the remaining traffic, expiry, active devices and links of the subscription.
+func (a *PortalController) info(c *gin.Context) {
+	_, host, _, _ := a.subService.ResolveRequest(c)
+	info, err := a.portalService.GetPortalInfo(a.subService.GetSubId(c), host)
+	if err != nil {
+		c.String(404, "Error!")
+		return
+	}
+	c.JSON(200, info)
+}
+
+// qr returns the share link of one client of the subscription as a QR code PNG.
+func (a *PortalController) qr(c *gin.Context) {
+	_, host, _, _ := a.subService.ResolveRequest(c)
+	link, err := a.portalService.GetPortalLink(a.subService.GetSubId(c), c.Param("email"), host)
+	if err != nil {
+		c.String(404, "Error!")
+		return
+	}
+	png, err := qrcode.Encode(link, qrcode.Medium, 256)
+	if err != nil {
+		c.String(500, "Error!")
+		return
+	}
+	c.Data(200, "image/png", png)
+}
+
+// configs downloads the share links of the subscription as a text file.
+func (a *PortalController) configs(c *gin.Context) {
+	subId := a.subService.GetSubId(c)
+	_, host, _, _ := a.subService.ResolveRequest(c)
+	info, err := a.portalService.GetPortalInfo(subId, host)
+	if err != nil {
+		c.String(404, "Error!")
+		return
+	}
+	var links []string
+	for _, client := range info.Clients {
+		if client.Link != "" {
+			links = append(links, client.Link)
+		}
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.txt", subId))
+	c.String(200, strings.Join(links, "\n"))
+}
+
+// json downloads the JSON client configuration of the subscription.
+func (a *PortalController) json(c *gin.Context) {
+	subId := a.subService.GetSubId(c)
+	_, host, _, _ := a.subService.ResolveRequest(c)
+	jsonSub, _, err := a.subJsonService.GetJson(subId, host)
+	if err != nil || len(jsonSub) == 0 {
+		c.String(404, "Error!")
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.json", subId))
+	c.Data(200, "application/json", []byte(jsonSub))
+}
@@ -0,0 +1,150 @@
+package sub
+
+import (
+	"slices"
+
+	"github.com/goccy/go-json"
+
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+)
+
+// PortalClient describes one client of a subscription as shown in the self-service portal.
+type PortalClient struct {
+	Email      string   `json:"email"`
+	Inbound    string   `json:"inbound"`
+	Protocol   string   `json:"protocol"`
+	Enable     bool     `json:"enable"`
+	Up         int64    `json:"up"`
+	Down       int64    `json:"down"`
+	Total      int64    `json:"total"`
+	ExpiryTime int64    `json:"expiryTime"`
+	LastOnline int64    `json:"lastOnline"`
+	Online     bool     `json:"online"`
+	Ips        []string `json:"ips"`
+	Link       string   `json:"link,omitempty"`
+}
+
+// PortalInfo holds the usage summary and clients of a subscription for the self-service portal.
+type PortalInfo struct {
+	SubId      string         `json:"subId"`
+	Up         int64          `json:"up"`
+	Down       int64          `json:"down"`
+	Total      int64          `json:"total"`
+	Remained   int64          `json:"remained"`
+	ExpiryTime int64          `json:"expiryTime"`
+	LastOnline int64          `json:"lastOnline"`
+	Clients    []PortalClient `json:"clients"`
+}
+
+// PortalService provides the read-only data behind the client self-service portal.
+// The subscription ID acts as the access token of the portal.
+type PortalService struct {
+	subService *SubService
+}
+
+// NewPortalService creates a new portal service that generates links with the given subscription service.
+func NewPortalService(subService *SubService) *PortalService {
+	return &PortalService{subService: subService}
+}
+
+// GetPortalInfo returns the traffic, expiry, active devices and links of every client in a subscription.
+func (s *PortalService) GetPortalInfo(subId string, host string) (*PortalInfo, error) {
+	inbounds, err := s.subService.getInboundsBySubId(subId)
+	if err != nil {
+		return nil, err
+	}
+	if len(inbounds) == 0 {
+		return nil, common.NewError("No inbounds found with ", subId)
+	}
+
+	s.subService.address = host
+	onlines := s.subService.inboundService.GetOnlineClients()
+	info := &PortalInfo{SubId: subId}
+	expiryTime := int64(-1)
+	for _, inbound := range inbounds {
+		clients, err := s.subService.inboundService.GetClients(inbound)
+		if err != nil {
+			continue
+		}
+		if len(inbound.Listen) > 0 && inbound.Listen[0] == '@' {
+			listen, port, streamSettings, err := s.subService.getFallbackMaster(inbound.Listen, inbound.StreamSettings)
+			if err == nil {
+				inbound.Listen = listen
+				inbound.Port = port
+				inbound.StreamSettings = streamSettings
+			}
+		}
+		for _, client := range clients {
+			if client.SubID != subId {
+				continue
+			}
+			traffic := s.subService.getClientTraffics(inbound.ClientStats, client.Email)
+			portalClient := PortalClient{
+				Email:      client.Email,
+				Inbound:    inbound.Remark,
+				Protocol:   string(inbound.Protocol),
+				Enable:     client.Enable && traffic.Enable,
+				Up:         traffic.Up,
+				Down:       traffic.Down,
+				Total:      traffic.Total,
+				ExpiryTime: traffic.ExpiryTime,
+				LastOnline: traffic.LastOnline,
+				Online:     slices.Contains(onlines, client.Email),
+				Ips:        s.getClientIps(client.Email),
+			}
+			if client.Enable {
+				portalClient.Link = s.subService.getLink(inbound, client.Email)
+			}
+			info.Clients = append(info.Clients, portalClient)
+
+			info.Up += traffic.Up
+			info.Down += traffic.Down
+			if len(info.Clients) == 1 || (info.Total > 0 && traffic.Total > 0) {
+				info.Total += traffic.Total
+			} else {
+				info.Total = 0
+			}
+			if expiryTime == -1 {
+				expiryTime = traffic.ExpiryTime
+			} else if expiryTime != traffic.ExpiryTime {
+				expiryTime = 0
+			}
+			info.LastOnline = max(info.LastOnline, traffic.LastOnline)
+		}
+	}
+	if len(info.Clients) == 0 {
+		return nil, common.NewError("No clients found with ", subId)
+	}
+	info.ExpiryTime = max(expiryTime, 0)
+	if info.Total > 0 {
+		info.Remained = max(info.Total-info.Up-info.Down, 0)
+	}
+	return info, nil
+}
+
+// GetPortalLink returns the share link of the client with the given email in a subscription.
+func (s *PortalService) GetPortalLink(subId string, email string, host string) (string, error) {
+	info, err := s.GetPortalInfo(subId, host)
+	if err != nil {
+		return "", err
+	}
+	for _, client := range info.Clients {
+		if client.Email == email && client.Link != "" {
+			return client.Link, nil
+		}
+	}
+	return "", common.NewError("No link found for ", email)
+}
+
+// getClientIps returns the IPs recently seen for a client.
+func (s *PortalService) getClientIps(email string) []string {
+	ips := []string{}
+	clientIps, err := s.subService.inboundService.GetInboundClientIps(email)
+	if err != nil || clientIps == "" {
+		return ips
+	}
+	if err = json.Unmarshal([]byte(clientIps), &ips); err != nil {
+		return []string{}
+	}
+	return ips
+}
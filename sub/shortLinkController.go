@@ -0,0 +1,45 @@
+package sub
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// ShortLinkController resolves the short links created in the panel.
+type ShortLinkController struct {
+	shortPath string
+
+	shortLinkService service.ShortLinkService
+}
+
+// NewShortLinkController creates the short link controller and registers its route on the given router group.
+func NewShortLinkController(g *gin.RouterGroup, shortPath string) *ShortLinkController {
+	a := &ShortLinkController{
+		shortPath: shortPath,
+	}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter registers the short link route, scoped by the short code.
+func (a *ShortLinkController) initRouter(g *gin.RouterGroup) {
+	g.GET(a.shortPath+":code", a.visit)
+}
+
+// visit redirects to the target of a short link. Share links, which browsers cannot open,
+// are returned as plain text so client apps can import them like a subscription.
+func (a *ShortLinkController) visit(c *gin.Context) {
+	target, err := a.shortLinkService.VisitShortLink(c.Param("code"))
+	if err != nil {
+		c.String(404, "Error!")
+		return
+	}
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		c.Redirect(302, target)
+		return
+	}
+	c.String(200, target)
+}
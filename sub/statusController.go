@@ -0,0 +1,63 @@
+package sub
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// ClientStatus is the read-only status of a client returned by the status API.
+type ClientStatus struct {
+	Email      string `json:"email"`
+	Enable     bool   `json:"enable"`
+	Up         int64  `json:"up"`
+	Down       int64  `json:"down"`
+	Total      int64  `json:"total"`
+	Remained   int64  `json:"remained"`
+	ExpiryTime int64  `json:"expiryTime"`
+	LastOnline int64  `json:"lastOnline"`
+}
+
+// StatusController serves the per-client status API. Every client has its own status token,
+// which only grants access to its usage and not to its configuration.
+type StatusController struct {
+	statusPath string
+
+	inboundService service.InboundService
+}
+
+// NewStatusController creates the status controller and registers its route on the given router group.
+func NewStatusController(g *gin.RouterGroup, statusPath string) *StatusController {
+	a := &StatusController{
+		statusPath: statusPath,
+	}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter registers the status route, scoped by the status token.
+func (a *StatusController) initRouter(g *gin.RouterGroup) {
+	g.GET(a.statusPath+":token", a.status)
+}
+
+// status returns the traffic, remaining quota, expiry and enabled state of the client owning the token.
+func (a *StatusController) status(c *gin.Context) {
+	traffic, client, err := a.inboundService.GetClientByStatusToken(c.Param("token"))
+	if err != nil {
+		c.String(404, "Error!")
+		return
+	}
+	status := ClientStatus{
+		Email:      client.Email,
+		Enable:     client.Enable && traffic.Enable,
+		Up:         traffic.Up,
+		Down:       traffic.Down,
+		Total:      traffic.Total,
+		ExpiryTime: traffic.ExpiryTime,
+		LastOnline: traffic.LastOnline,
+	}
+	if traffic.Total > 0 {
+		status.Remained = max(traffic.Total-traffic.Up-traffic.Down, 0)
+	}
+	c.JSON(200, status)
+}
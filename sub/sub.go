@@ -44,10 +44,14 @@ func setEmbeddedTemplates(engine *gin.Engine) error {
 // Server represents the subscription server that serves subscription links and JSON configurations.
 type Server struct {
 	httpServer *http.Server
-	listener   net.Listener
+	listeners  []net.Listener // The main listener first
 
 	sub            *SUBController
+	portal         *PortalController
+	status         *StatusController
+	short          *ShortLinkController
 	settingService service.SettingService
+	acmeService    service.AcmeService
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -71,6 +75,22 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 	gin.SetMode(gin.ReleaseMode)
 
 	engine := gin.Default()
+	trustedProxies, err := s.settingService.GetTrustedProxies()
+	if err != nil {
+		return nil, err
+	}
+	if err := middleware.UseTrustedProxies(engine, trustedProxies); err != nil {
+		return nil, err
+	}
+	engine.Use(middleware.RequestIDMiddleware())
+
+	accessLog, err := s.settingService.GetHttpAccessLog()
+	if err != nil {
+		return nil, err
+	}
+	if accessLog != nil {
+		engine.Use(middleware.AccessLogMiddleware("sub", "/", accessLog))
+	}
 
 	subDomain, err := s.settingService.GetSubDomain()
 	if err != nil {
@@ -105,7 +125,7 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 	}
 	// logger.Debug("sub: Setting base_path to:", basePath)
 	engine.Use(func(c *gin.Context) {
-		c.Set("base_path", basePath)
+		c.Set("base_path", middleware.ForwardedPrefix(c)+basePath)
 	})
 
 	Encrypt, err := s.settingService.GetSubEncrypt()
@@ -153,6 +173,91 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 		SubTitle = ""
 	}
 
+	SubPortalEnable, err := s.settingService.GetSubPortalEnable()
+	if err != nil {
+		SubPortalEnable = false
+	}
+
+	SubPortalPath, err := s.settingService.GetSubPortalPath()
+	if err != nil {
+		SubPortalPath = "/portal/"
+	}
+
+	SubStatusEnable, err := s.settingService.GetSubStatusEnable()
+	if err != nil {
+		SubStatusEnable = false
+	}
+
+	SubStatusPath, err := s.settingService.GetSubStatusPath()
+	if err != nil {
+		SubStatusPath = "/status/"
+	}
+
+	SubClashEnable, err := s.settingService.GetSubClashEnable()
+	if err != nil {
+		SubClashEnable = false
+	}
+
+	SubClashPath, err := s.settingService.GetSubClashPath()
+	if err != nil {
+		SubClashPath = "/clash/"
+	}
+
+	SubClashRules, err := s.settingService.GetSubClashRules()
+	if err != nil {
+		SubClashRules = ""
+	}
+
+	SubSingboxEnable, err := s.settingService.GetSubSingboxEnable()
+	if err != nil {
+		SubSingboxEnable = false
+	}
+
+	SubSingboxPath, err := s.settingService.GetSubSingboxPath()
+	if err != nil {
+		SubSingboxPath = "/singbox/"
+	}
+
+	SubLinksTemplate, err := s.settingService.GetSubLinksTemplate()
+	if err != nil {
+		SubLinksTemplate = ""
+	}
+
+	SubClashTemplate, err := s.settingService.GetSubClashTemplate()
+	if err != nil {
+		SubClashTemplate = ""
+	}
+
+	SubSingboxTemplate, err := s.settingService.GetSubSingboxTemplate()
+	if err != nil {
+		SubSingboxTemplate = ""
+	}
+
+	SubSignEnable, err := s.settingService.GetSubSignEnable()
+	if err != nil {
+		SubSignEnable = false
+	}
+
+	SubFragmentAll, err := s.settingService.GetSubFragmentAll()
+	if err != nil {
+		SubFragmentAll = false
+	}
+
+	SubCacheTtl, err := s.settingService.GetSubCacheTtl()
+	if err != nil {
+		SubCacheTtl = 0
+	}
+
+	SubShortEnable, err := s.settingService.GetSubShortEnable()
+	if err != nil {
+		SubShortEnable = false
+	}
+
+	SubShortPath, err := s.settingService.GetSubShortPath()
+	if err != nil {
+		SubShortPath = "/s/"
+	}
+
 	// set per-request localizer from headers/cookies
 	engine.Use(locale.LocalizerMiddleware())
 
@@ -228,14 +333,56 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 	}
 
 	g := engine.Group("/")
+	if SubSignEnable {
+		g.Use(s.checkSubSignature)
+	}
 
 	s.sub = NewSUBController(
 		g, LinksPath, JsonPath, subJsonEnable, Encrypt, ShowInfo, RemarkModel, SubUpdates,
-		SubJsonFragment, SubJsonNoises, SubJsonMux, SubJsonRules, SubTitle)
+		SubJsonFragment, SubJsonNoises, SubJsonMux, SubJsonRules, SubTitle, SubClashPath, SubClashEnable, SubClashRules,
+		SubSingboxPath, SubSingboxEnable, SubLinksTemplate, SubClashTemplate, SubSingboxTemplate, SubFragmentAll, SubCacheTtl)
+
+	if SubPortalEnable {
+		s.portal = NewPortalController(g, SubPortalPath, subJsonEnable, s.sub)
+	}
+
+	if SubStatusEnable {
+		s.status = NewStatusController(g, SubStatusPath)
+	}
+
+	if SubShortEnable {
+		s.short = NewShortLinkController(g, SubShortPath)
+	}
+
+	// Serve the decoy site for the paths of no subscription
+	if decoySite, err := s.settingService.GetDecoySite(); err == nil && decoySite != "" {
+		if decoy, err := middleware.DecoyHandler(decoySite); err != nil {
+			logger.Warning("Serve decoy site failed:", err)
+		} else {
+			engine.NoRoute(decoy)
+		}
+	}
 
 	return engine, nil
 }
 
+// checkSubSignature rejects requests for a subscription ID whose URL carries no valid,
+// unexpired signature.
+func (s *Server) checkSubSignature(c *gin.Context) {
+	subId := c.Param("subid")
+	if subId == "" {
+		c.Next()
+		return
+	}
+	if err := s.settingService.VerifySubSignature(subId, c.Query("expires"), c.Query("sig")); err != nil {
+		logger.Debug("sub: rejected", subId, ":", err)
+		c.String(403, "Error!")
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
 // getHtmlFiles loads templates from local folder (used in debug mode)
 func (s *Server) getHtmlFiles() ([]string, error) {
 	dir, _ := os.Getwd()
@@ -290,6 +437,10 @@ func (s *Server) Start() (err error) {
 	if err != nil {
 		return err
 	}
+	subDomain, err := s.settingService.GetSubDomain()
+	if err != nil {
+		return err
+	}
 	listen, err := s.settingService.GetSubListen()
 	if err != nil {
 		return err
@@ -300,55 +451,98 @@ func (s *Server) Start() (err error) {
 	}
 
 	listenAddr := net.JoinHostPort(listen, strconv.Itoa(port))
-	listener, err := net.Listen("tcp", listenAddr)
+	listener, err := network.Listen(listenAddr)
 	if err != nil {
 		return err
 	}
 
-	if certFile != "" || keyFile != "" {
-		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
-		if err == nil {
-			c := &tls.Config{
-				Certificates: []tls.Certificate{cert},
-			}
-			listener = network.NewAutoHttpsListener(listener)
-			listener = tls.NewListener(listener, c)
-			logger.Info("Sub server running HTTPS on", listener.Addr())
-		} else {
-			logger.Error("Error loading certificates:", err)
-			logger.Info("Sub server running HTTP on", listener.Addr())
-		}
+	c, err := s.acmeService.TLSConfig(subDomain, certFile, keyFile)
+	if err != nil {
+		logger.Error("Error loading certificates:", err)
+	}
+	if c != nil {
+		listener = network.NewAutoHttpsListener(listener)
+		listener = tls.NewListener(listener, c)
+		logger.Info("Sub server running HTTPS on", listener.Addr())
 	} else {
 		logger.Info("Sub server running HTTP on", listener.Addr())
 	}
-	s.listener = listener
+	s.listeners = []net.Listener{listener}
+
+	// The additional addresses are skipped when they fail, the main one keeps the server reachable
+	extraListeners, err := s.settingService.GetSubListeners()
+	if err != nil {
+		return err
+	}
+	for _, extra := range extraListeners {
+		extraConfig, err := s.acmeService.ListenerTLSConfig(extra, c)
+		if err != nil {
+			logger.Error("Error loading certificates of listener", extra.Addr+":", err)
+			continue
+		}
+		extraListener, err := network.ListenTLS(extra.Addr, extraConfig)
+		if err != nil {
+			logger.Error("Error listening on", extra.Addr+":", err)
+			continue
+		}
+		s.listeners = append(s.listeners, extraListener)
+		if extraConfig != nil {
+			logger.Info("Sub server also running HTTPS on", extraListener.Addr())
+		} else {
+			logger.Info("Sub server also running HTTP on", extraListener.Addr())
+		}
+	}
 
 	s.httpServer = &http.Server{
 		Handler: engine,
 	}
 
-	go func() {
-		s.httpServer.Serve(listener)
-	}()
+	for _, listener := range s.listeners {
+		go func() {
+			s.httpServer.Serve(listener)
+		}()
+	}
 
 	return nil
 }
 
-// Stop gracefully shuts down the subscription server and closes the listener.
+// Stop gracefully shuts down the subscription server, waiting for the requests in flight, and
+// closes the listener, whose socket is kept for the next server on the same address.
 func (s *Server) Stop() error {
 	s.cancel()
 
 	var err1 error
 	var err2 error
 	if s.httpServer != nil {
-		err1 = s.httpServer.Shutdown(s.ctx)
+		ctx, cancel := context.WithTimeout(context.Background(), network.DrainTimeout)
+		err1 = s.httpServer.Shutdown(ctx)
+		cancel()
 	}
-	if s.listener != nil {
-		err2 = s.listener.Close()
+	for _, listener := range s.listeners {
+		err2 = common.Combine(err2, listener.Close())
 	}
 	return common.Combine(err1, err2)
 }
 
+// GetClientLinks generates the share links of a client for the given host, independently of
+// whether the subscription server is running.
+func (s *Server) GetClientLinks(email string, host string) ([]string, error) {
+	showInfo, err := s.settingService.GetSubShowInfo()
+	if err != nil {
+		showInfo = false
+	}
+	remarkModel, err := s.settingService.GetRemarkModel()
+	if err != nil {
+		remarkModel = "-ieo"
+	}
+	subService := NewSubService(showInfo, remarkModel)
+	if fragmentAll, _ := s.settingService.GetSubFragmentAll(); fragmentAll {
+		fragment, _ := s.settingService.GetSubJsonFragment()
+		subService.fragment = parseSubFragment(fragment)
+	}
+	return subService.GetClientLinks(email, host)
+}
+
 // GetCtx returns the server's context for cancellation and deadline management.
 func (s *Server) GetCtx() context.Context {
 	return s.ctx
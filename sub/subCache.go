@@ -0,0 +1,95 @@
+package sub
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+)
+
+// subCacheTables are the tables the subscriptions are rendered from: the inbounds with their
+// clients, the settings with the remark and host templates and the address overrides, the nodes
+// with their health and sync policies, and the aliases of renewed subscription IDs.
+var subCacheTables = []string{"inbounds", "client_traffics", "settings", "nodes", "node_sync_policies", "sub_id_aliases"}
+
+// subCacheVersion returns a counter that changes whenever the configuration in one of the tables
+// the subscriptions are rendered from changes.
+func subCacheVersion() uint64 {
+	return database.ConfigVersion(subCacheTables...)
+}
+
+// subCacheMaxEntries bounds the cache; outdated entries are dropped once it is reached.
+const subCacheMaxEntries = 10000
+
+// subCacheEntry is a rendered subscription response.
+type subCacheEntry struct {
+	body    string
+	header  string
+	version uint64
+	expires time.Time
+}
+
+// subCache keeps rendered subscription responses for a short time so clients polling the
+// subscription do not rebuild it from the database every time. An entry is served until its
+// TTL passes or the data it was rendered from changes, whichever comes first.
+type subCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]subCacheEntry
+}
+
+// newSubCache creates a cache with the given TTL. A TTL of zero disables caching and returns nil.
+func newSubCache(ttl time.Duration) *subCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &subCache{
+		ttl:     ttl,
+		entries: make(map[string]subCacheEntry),
+	}
+}
+
+// get returns the entry for key if it is still valid.
+func (c *subCache) get(key string) (subCacheEntry, bool) {
+	if c == nil {
+		return subCacheEntry{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || !c.valid(entry, time.Now()) {
+		return subCacheEntry{}, false
+	}
+	return entry, true
+}
+
+// set stores a response rendered while the data was at the given subCacheVersion.
+func (c *subCache) set(key string, body string, header string, version uint64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.entries) >= subCacheMaxEntries {
+		for k, entry := range c.entries {
+			if !c.valid(entry, now) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= subCacheMaxEntries {
+			return
+		}
+	}
+	c.entries[key] = subCacheEntry{
+		body:    body,
+		header:  header,
+		version: version,
+		expires: now.Add(c.ttl),
+	}
+}
+
+// valid reports whether an entry is neither expired nor built from outdated data.
+func (c *subCache) valid(entry subCacheEntry, now time.Time) bool {
+	return now.Before(entry.expires) && entry.version == subCacheVersion()
+}
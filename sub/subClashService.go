@@ -0,0 +1,456 @@
+package sub
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/goccy/go-yaml"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// Clash subscription formats. The Clash.Meta format also covers VLESS, Hysteria2, Reality and HTTP upgrade.
+const (
+	ClashFormat     = "clash"
+	ClashMetaFormat = "clash-meta"
+)
+
+const clashProxyGroup = "PROXY"
+
+var clashDefaultRules = []string{
+	"DOMAIN-SUFFIX,local,DIRECT",
+	"IP-CIDR,127.0.0.0/8,DIRECT,no-resolve",
+	"IP-CIDR,10.0.0.0/8,DIRECT,no-resolve",
+	"IP-CIDR,172.16.0.0/12,DIRECT,no-resolve",
+	"IP-CIDR,192.168.0.0/16,DIRECT,no-resolve",
+}
+
+// SubClashService generates Clash and Clash.Meta YAML configurations for subscriptions.
+type SubClashService struct {
+	rules    []string
+	template *template.Template
+
+	inboundService service.InboundService
+	SubService     *SubService
+}
+
+// NewSubClashService creates a new Clash subscription service.
+// rules holds custom Clash rules, one per line, placed before the default ones, and
+// templateText an optional Go template replacing the generated configuration.
+func NewSubClashService(rules string, templateText string, subService *SubService) *SubClashService {
+	var clashRules []string
+	for _, rule := range strings.Split(rules, "\n") {
+		if rule = strings.TrimSpace(rule); rule != "" {
+			clashRules = append(clashRules, rule)
+		}
+	}
+	return &SubClashService{
+		rules:      clashRules,
+		template:   parseSubTemplate("clash", templateText),
+		SubService: subService,
+	}
+}
+
+// GetClash generates a Clash YAML configuration for the given subscription ID and host.
+// format is ClashFormat or ClashMetaFormat; proxies the format cannot express are left out.
+func (s *SubClashService) GetClash(subId string, host string, format string) (string, string, error) {
+	inbounds, err := s.SubService.getInboundsBySubId(subId)
+	if err != nil || len(inbounds) == 0 {
+		return "", "", err
+	}
+
+	var clientTraffics []xray.ClientTraffic
+	var proxies []yaml.MapSlice
+	names := make(map[string]int)
+	var groups subProxyGroups
+	addProxies := func(inbound *model.Inbound, client model.Client, host string, proxyGroups []string) {
+		for _, proxy := range s.getProxies(inbound, client, host, format) {
+			// Clash refuses duplicated proxy names
+			name := proxy[0].Value.(string)
+			names[name]++
+			if names[name] > 1 {
+				proxy[0].Value = fmt.Sprintf("%s %d", name, names[name])
+			}
+			proxies = append(proxies, proxy)
+			groups.add(proxyGroups, proxy[0].Value.(string))
+		}
+	}
+	var lastEndpoints []*subEndpoint // Endpoints of unhealthy nodes, listed last
+	for _, inbound := range inbounds {
+		clients, err := s.inboundService.GetClients(inbound)
+		if err != nil {
+			logger.Error("SubClashService - GetClients: Unable to get clients from inbound")
+		}
+		if clients == nil {
+			continue
+		}
+		if len(inbound.Listen) > 0 && inbound.Listen[0] == '@' {
+			listen, port, streamSettings, err := s.SubService.getFallbackMaster(inbound.Listen, inbound.StreamSettings)
+			if err == nil {
+				inbound.Listen = listen
+				inbound.Port = port
+				inbound.StreamSettings = streamSettings
+			}
+		}
+
+		for _, client := range clients {
+			if client.Enable && client.SubID == subId {
+				clientTraffics = append(clientTraffics, s.SubService.getClientTraffics(inbound.ClientStats, client.Email))
+				clientInbound, clientHost := s.SubService.clientEndpoint(inbound, client, host)
+				addProxies(clientInbound, client, clientHost, nil)
+				for _, endpoint := range s.SubService.endpoints(inbound, client, host) {
+					if endpoint.last {
+						lastEndpoints = append(lastEndpoints, endpoint)
+					} else {
+						addProxies(endpoint.inbound, client, endpoint.host, endpoint.groups)
+					}
+				}
+			}
+		}
+	}
+	for _, endpoint := range lastEndpoints {
+		addProxies(endpoint.inbound, endpoint.client, endpoint.host, endpoint.groups)
+	}
+
+	if len(proxies) == 0 {
+		return "", "", nil
+	}
+
+	proxyNames := make([]string, 0, len(proxies))
+	for _, proxy := range proxies {
+		proxyNames = append(proxyNames, proxy[0].Value.(string))
+	}
+	rules := append(append([]string{}, s.rules...), clashDefaultRules...)
+	rules = append(rules, "MATCH,"+clashProxyGroup)
+
+	// The proxies of the nodes are also grouped by region and label, each group testing for
+	// the fastest of its proxies
+	selected := append(append([]string{"Auto"}, groups.names...), proxyNames...)
+	proxyGroups := []yaml.MapSlice{
+		{
+			{Key: "name", Value: clashProxyGroup},
+			{Key: "type", Value: "select"},
+			{Key: "proxies", Value: selected},
+		},
+		clashUrlTestGroup("Auto", proxyNames),
+	}
+	for _, name := range groups.names {
+		proxyGroups = append(proxyGroups, clashUrlTestGroup(name, groups.proxies[name]))
+	}
+
+	config := yaml.MapSlice{
+		{Key: "mixed-port", Value: 7890},
+		{Key: "allow-lan", Value: false},
+		{Key: "mode", Value: "rule"},
+		{Key: "log-level", Value: "info"},
+		{Key: "proxies", Value: proxies},
+		{Key: "proxy-groups", Value: proxyGroups},
+		{Key: "rules", Value: rules},
+	}
+	result, err := yaml.Marshal(config)
+	if err != nil {
+		return "", "", err
+	}
+
+	traffic := mergeClientTraffics(clientTraffics)
+	header := fmt.Sprintf("upload=%d; download=%d; total=%d; expire=%d", traffic.Up, traffic.Down, traffic.Total, traffic.ExpiryTime/1000)
+	if s.template != nil {
+		data := s.SubService.newTemplateData(subId, host, traffic)
+		for _, proxy := range proxies {
+			data.Proxies = append(data.Proxies, proxy)
+		}
+		data.ProxyNames = proxyNames
+		data.Default = string(result)
+		return renderSubTemplate(s.template, data), header, nil
+	}
+	return string(result), header, nil
+}
+
+// clashUrlTestGroup returns a proxy group using the fastest of its proxies.
+func clashUrlTestGroup(name string, proxies []string) yaml.MapSlice {
+	return yaml.MapSlice{
+		{Key: "name", Value: name},
+		{Key: "type", Value: "url-test"},
+		{Key: "url", Value: "http://www.gstatic.com/generate_204"},
+		{Key: "interval", Value: 300},
+		{Key: "proxies", Value: proxies},
+	}
+}
+
+// getProxies returns the Clash proxies of a client, one per external proxy of the inbound.
+func (s *SubClashService) getProxies(inbound *model.Inbound, client model.Client, host string, format string) []yaml.MapSlice {
+	var stream map[string]any
+	json.Unmarshal([]byte(inbound.StreamSettings), &stream)
+
+	externalProxies, ok := stream["externalProxy"].([]any)
+	if !ok || len(externalProxies) == 0 {
+		externalProxies = []any{
+			map[string]any{
+				"forceTls": "same",
+				"dest":     host,
+				"port":     float64(inbound.Port),
+				"remark":   "",
+			},
+		}
+	}
+
+	var proxies []yaml.MapSlice
+	for _, ep := range externalProxies {
+		extPrxy := ep.(map[string]any)
+		security, _ := stream["security"].(string)
+		switch extPrxy["forceTls"].(string) {
+		case "tls":
+			security = "tls"
+		case "none":
+			security = "none"
+		}
+
+		proxy := yaml.MapSlice{
+			{Key: "name", Value: s.SubService.genRemark(inbound, client.Email, extPrxy["remark"].(string))},
+			{Key: "type", Value: ""},
+			{Key: "server", Value: extPrxy["dest"].(string)},
+			{Key: "port", Value: int(extPrxy["port"].(float64))},
+			{Key: "udp", Value: true},
+		}
+		if ports := hoppingPorts(inbound, int(extPrxy["port"].(float64))); ports != nil {
+			proxy = append(proxy, yaml.MapItem{Key: "ports", Value: strings.Join(ports, ",")})
+		}
+		if !s.addCredentials(&proxy, inbound, client, format) ||
+			!s.addSecurity(&proxy, inbound.Protocol, stream, security, format) ||
+			!s.addNetwork(&proxy, inbound.Protocol, stream, format) {
+			continue
+		}
+		proxies = append(proxies, proxy)
+	}
+	return proxies
+}
+
+// addCredentials sets the proxy type and the client credentials.
+// Reports false if the protocol is not supported by the format.
+func (s *SubClashService) addCredentials(proxy *yaml.MapSlice, inbound *model.Inbound, client model.Client, format string) bool {
+	switch inbound.Protocol {
+	case model.VMESS:
+		cipher := client.Security
+		if cipher == "" {
+			cipher = "auto"
+		}
+		(*proxy)[1].Value = "vmess"
+		*proxy = append(*proxy,
+			yaml.MapItem{Key: "uuid", Value: client.ID},
+			yaml.MapItem{Key: "alterId", Value: 0},
+			yaml.MapItem{Key: "cipher", Value: cipher})
+	case model.VLESS:
+		if format != ClashMetaFormat {
+			return false
+		}
+		(*proxy)[1].Value = "vless"
+		*proxy = append(*proxy, yaml.MapItem{Key: "uuid", Value: client.ID})
+		if client.Flow != "" {
+			*proxy = append(*proxy, yaml.MapItem{Key: "flow", Value: client.Flow})
+		}
+	case model.Trojan:
+		(*proxy)[1].Value = "trojan"
+		*proxy = append(*proxy, yaml.MapItem{Key: "password", Value: client.Password})
+	case model.Shadowsocks:
+		var settings map[string]any
+		json.Unmarshal([]byte(inbound.Settings), &settings)
+		method, _ := settings["method"].(string)
+		password := client.Password
+		if strings.HasPrefix(method, "2022") {
+			if format != ClashMetaFormat {
+				return false
+			}
+			// server password in multi-user 2022 protocols
+			if serverPassword, ok := settings["password"].(string); ok {
+				password = fmt.Sprintf("%s:%s", serverPassword, client.Password)
+			}
+		}
+		(*proxy)[1].Value = "ss"
+		*proxy = append(*proxy,
+			yaml.MapItem{Key: "cipher", Value: method},
+			yaml.MapItem{Key: "password", Value: password})
+	case model.Hysteria2:
+		if format != ClashMetaFormat {
+			return false
+		}
+		var settings map[string]any
+		json.Unmarshal([]byte(inbound.Settings), &settings)
+		(*proxy)[1].Value = "hysteria2"
+		*proxy = append(*proxy, yaml.MapItem{Key: "password", Value: client.Password})
+		if obfs, _ := settings["obfsPassword"].(string); obfs != "" {
+			*proxy = append(*proxy,
+				yaml.MapItem{Key: "obfs", Value: "salamander"},
+				yaml.MapItem{Key: "obfs-password", Value: obfs})
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// addSecurity sets the TLS or Reality options of the proxy.
+// Reports false if the security is not supported by the format or the protocol.
+func (s *SubClashService) addSecurity(proxy *yaml.MapSlice, protocol model.Protocol, stream map[string]any, security string, format string) bool {
+	serverNameKey := "servername"
+	if protocol == model.Trojan || protocol == model.Hysteria2 {
+		serverNameKey = "sni"
+	}
+	if protocol == model.Hysteria2 && security != "tls" {
+		return false
+	}
+	switch security {
+	case "tls":
+		if protocol == model.Shadowsocks {
+			return false
+		}
+		tlsSettings, _ := stream["tlsSettings"].(map[string]any)
+		tlsClientSettings, _ := tlsSettings["settings"].(map[string]any)
+		// Trojan and Hysteria2 always run over TLS
+		if protocol != model.Trojan && protocol != model.Hysteria2 {
+			*proxy = append(*proxy, yaml.MapItem{Key: "tls", Value: true})
+		}
+		if serverName, _ := tlsSettings["serverName"].(string); serverName != "" {
+			*proxy = append(*proxy, yaml.MapItem{Key: serverNameKey, Value: serverName})
+		}
+		if alpn, ok := tlsSettings["alpn"].([]any); ok && len(alpn) > 0 {
+			*proxy = append(*proxy, yaml.MapItem{Key: "alpn", Value: alpn})
+		}
+		if allowInsecure, _ := tlsClientSettings["allowInsecure"].(bool); allowInsecure {
+			*proxy = append(*proxy, yaml.MapItem{Key: "skip-cert-verify", Value: true})
+		}
+		if fingerprint, _ := tlsClientSettings["fingerprint"].(string); fingerprint != "" && format == ClashMetaFormat {
+			*proxy = append(*proxy, yaml.MapItem{Key: "client-fingerprint", Value: fingerprint})
+		}
+	case "reality":
+		if format != ClashMetaFormat || protocol != model.VLESS {
+			return false
+		}
+		realitySettings, _ := stream["realitySettings"].(map[string]any)
+		realityClientSettings, _ := realitySettings["settings"].(map[string]any)
+		*proxy = append(*proxy, yaml.MapItem{Key: "tls", Value: true})
+		if serverNames, ok := realitySettings["serverNames"].([]any); ok && len(serverNames) > 0 {
+			*proxy = append(*proxy, yaml.MapItem{Key: serverNameKey, Value: serverNames[random.Num(len(serverNames))]})
+		}
+		fingerprint, _ := realityClientSettings["fingerprint"].(string)
+		if fingerprint == "" {
+			fingerprint = "chrome"
+		}
+		*proxy = append(*proxy, yaml.MapItem{Key: "client-fingerprint", Value: fingerprint})
+		shortId := ""
+		if shortIds, ok := realitySettings["shortIds"].([]any); ok && len(shortIds) > 0 {
+			shortId, _ = shortIds[random.Num(len(shortIds))].(string)
+		}
+		publicKey, _ := realityClientSettings["publicKey"].(string)
+		*proxy = append(*proxy, yaml.MapItem{Key: "reality-opts", Value: yaml.MapSlice{
+			{Key: "public-key", Value: publicKey},
+			{Key: "short-id", Value: shortId},
+		}})
+	default:
+		// Trojan always runs over TLS in Clash
+		if protocol == model.Trojan {
+			return false
+		}
+	}
+	return true
+}
+
+// addNetwork sets the transport options of the proxy.
+// Reports false if the transport is not supported by the format.
+func (s *SubClashService) addNetwork(proxy *yaml.MapSlice, protocol model.Protocol, stream map[string]any, format string) bool {
+	network, _ := stream["network"].(string)
+	// Hysteria2 has its own QUIC transport, its inbounds keep the plain TCP network
+	if protocol == model.Shadowsocks || protocol == model.Hysteria2 {
+		return network == "tcp"
+	}
+	switch network {
+	case "tcp":
+		tcp, _ := stream["tcpSettings"].(map[string]any)
+		header, _ := tcp["header"].(map[string]any)
+		if typeStr, _ := header["type"].(string); typeStr != "http" {
+			return true
+		}
+		if protocol != model.VMESS {
+			return false
+		}
+		request, _ := header["request"].(map[string]any)
+		httpOpts := yaml.MapSlice{{Key: "method", Value: "GET"}}
+		if requestPath, ok := request["path"].([]any); ok && len(requestPath) > 0 {
+			httpOpts = append(httpOpts, yaml.MapItem{Key: "path", Value: requestPath})
+		}
+		if host := searchHost(request["headers"]); host != "" {
+			httpOpts = append(httpOpts, yaml.MapItem{Key: "headers", Value: yaml.MapSlice{{Key: "Host", Value: []string{host}}}})
+		}
+		*proxy = append(*proxy,
+			yaml.MapItem{Key: "network", Value: "http"},
+			yaml.MapItem{Key: "http-opts", Value: httpOpts})
+	case "ws", "httpupgrade":
+		if network == "httpupgrade" && format != ClashMetaFormat {
+			return false
+		}
+		ws, _ := stream[network+"Settings"].(map[string]any)
+		path, _ := ws["path"].(string)
+		host, _ := ws["host"].(string)
+		if host == "" {
+			host = searchHost(ws["headers"])
+		}
+		wsOpts := yaml.MapSlice{{Key: "path", Value: path}}
+		if host != "" {
+			wsOpts = append(wsOpts, yaml.MapItem{Key: "headers", Value: yaml.MapSlice{{Key: "Host", Value: host}}})
+		}
+		if network == "httpupgrade" {
+			wsOpts = append(wsOpts, yaml.MapItem{Key: "v2ray-http-upgrade", Value: true})
+		}
+		*proxy = append(*proxy,
+			yaml.MapItem{Key: "network", Value: "ws"},
+			yaml.MapItem{Key: "ws-opts", Value: wsOpts})
+	case "grpc":
+		grpc, _ := stream["grpcSettings"].(map[string]any)
+		serviceName, _ := grpc["serviceName"].(string)
+		*proxy = append(*proxy,
+			yaml.MapItem{Key: "network", Value: "grpc"},
+			yaml.MapItem{Key: "grpc-opts", Value: yaml.MapSlice{{Key: "grpc-service-name", Value: serviceName}}})
+	default:
+		return false
+	}
+	return true
+}
+
+// mergeClientTraffics sums the traffic of the clients of a subscription.
+// Total and expiry are only kept when every client shares a limit. A client listed more than
+// once, like under the previous keys of a REALITY inbound, is counted once.
+func mergeClientTraffics(clientTraffics []xray.ClientTraffic) xray.ClientTraffic {
+	var traffic xray.ClientTraffic
+	seen := make(map[string]bool, len(clientTraffics))
+	for index, clientTraffic := range clientTraffics {
+		if seen[clientTraffic.Email] {
+			continue
+		}
+		seen[clientTraffic.Email] = true
+		if index == 0 {
+			traffic.Up = clientTraffic.Up
+			traffic.Down = clientTraffic.Down
+			traffic.Total = clientTraffic.Total
+			if clientTraffic.ExpiryTime > 0 {
+				traffic.ExpiryTime = clientTraffic.ExpiryTime
+			}
+		} else {
+			traffic.Up += clientTraffic.Up
+			traffic.Down += clientTraffic.Down
+			if traffic.Total == 0 || clientTraffic.Total == 0 {
+				traffic.Total = 0
+			} else {
+				traffic.Total += clientTraffic.Total
+			}
+			if clientTraffic.ExpiryTime != traffic.ExpiryTime {
+				traffic.ExpiryTime = 0
+			}
+		}
+	}
+	return traffic
+}
@@ -3,9 +3,16 @@ package sub
 import (
 	"encoding/base64"
 	"fmt"
+	"net/url"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/extension"
+	"github.com/mhsanaei/3x-ui/v2/web/middleware"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
 
 	"github.com/gin-gonic/gin"
 )
@@ -15,12 +22,21 @@ type SUBController struct {
 	subTitle       string
 	subPath        string
 	subJsonPath    string
+	subClashPath   string
+	subSingboxPath string
 	jsonEnabled    bool
+	clashEnabled   bool
+	singboxEnabled bool
 	subEncrypt     bool
 	updateInterval string
+	linksTemplate  *template.Template
+	cache          *subCache
 
-	subService     *SubService
-	subJsonService *SubJsonService
+	subService        *SubService
+	subJsonService    *SubJsonService
+	subClashService   *SubClashService
+	subSingboxService *SubSingboxService
+	extensionService  service.ExtensionService
 }
 
 // NewSUBController creates a new subscription controller with the given configuration.
@@ -38,18 +54,39 @@ func NewSUBController(
 	jsonMux string,
 	jsonRules string,
 	subTitle string,
+	clashPath string,
+	clashEnabled bool,
+	clashRules string,
+	singboxPath string,
+	singboxEnabled bool,
+	linksTemplate string,
+	clashTemplate string,
+	singboxTemplate string,
+	fragmentAll bool,
+	cacheTtl int,
 ) *SUBController {
 	sub := NewSubService(showInfo, rModel)
+	if fragmentAll {
+		sub.fragment = parseSubFragment(jsonFragment)
+	}
 	a := &SUBController{
 		subTitle:       subTitle,
 		subPath:        subPath,
 		subJsonPath:    jsonPath,
+		subClashPath:   clashPath,
+		subSingboxPath: singboxPath,
 		jsonEnabled:    jsonEnabled,
+		clashEnabled:   clashEnabled,
+		singboxEnabled: singboxEnabled,
 		subEncrypt:     encrypt,
 		updateInterval: update,
+		linksTemplate:  parseSubTemplate("links", linksTemplate),
+		cache:          newSubCache(time.Duration(cacheTtl) * time.Second),
 
-		subService:     sub,
-		subJsonService: NewSubJsonService(jsonFragment, jsonNoise, jsonMux, jsonRules, sub),
+		subService:        sub,
+		subJsonService:    NewSubJsonService(jsonFragment, jsonNoise, jsonMux, jsonRules, sub),
+		subClashService:   NewSubClashService(clashRules, clashTemplate, sub),
+		subSingboxService: NewSubSingboxService(singboxTemplate, sub),
 	}
 	a.initRouter(g)
 	return a
@@ -64,95 +101,225 @@ func (a *SUBController) initRouter(g *gin.RouterGroup) {
 		gJson := g.Group(a.subJsonPath)
 		gJson.GET(":subid", a.subJsons)
 	}
+	if a.clashEnabled {
+		gClash := g.Group(a.subClashPath)
+		gClash.GET(":subid", a.subClash)
+	}
+	if a.singboxEnabled {
+		gSingbox := g.Group(a.subSingboxPath)
+		gSingbox.GET(":subid", a.subSingbox)
+	}
 }
 
 // subs handles HTTP requests for subscription links, returning either HTML page or base64-encoded subscription data.
 func (a *SUBController) subs(c *gin.Context) {
-	subId := c.Param("subid")
+	subId := a.subService.GetSubId(c)
 	scheme, host, hostWithPort, hostHeader := a.subService.ResolveRequest(c)
-	subs, lastOnline, traffic, err := a.subService.GetSubs(subId, host)
-	if err != nil || len(subs) == 0 {
-		c.String(400, "Error!")
-	} else {
-		result := ""
-		for _, sub := range subs {
-			result += sub + "\n"
-		}
 
-		// If the request expects HTML (e.g., browser) or explicitly asked (?html=1 or ?view=html), render the info page here
-		accept := c.GetHeader("Accept")
-		if strings.Contains(strings.ToLower(accept), "text/html") || c.Query("html") == "1" || strings.EqualFold(c.Query("view"), "html") {
-			// Build page data in service
-			subURL, subJsonURL := a.subService.BuildURLs(scheme, hostWithPort, a.subPath, a.subJsonPath, subId)
-			if !a.jsonEnabled {
-				subJsonURL = ""
-			}
-			// Get base_path from context (set by middleware)
-			basePath, exists := c.Get("base_path")
-			if !exists {
-				basePath = "/"
-			}
-			// Add subId to base_path for asset URLs
-			basePathStr := basePath.(string)
-			if basePathStr == "/" {
-				basePathStr = "/" + subId + "/"
-			} else {
-				// Remove trailing slash if exists, add subId, then add trailing slash
-				basePathStr = strings.TrimRight(basePathStr, "/") + "/" + subId + "/"
-			}
-			page := a.subService.BuildPageData(subId, hostHeader, traffic, lastOnline, subs, subURL, subJsonURL, basePathStr)
-			c.HTML(200, "subpage.html", gin.H{
-				"title":        "subscription.title",
-				"cur_ver":      config.GetVersion(),
-				"host":         page.Host,
-				"base_path":    page.BasePath,
-				"sId":          page.SId,
-				"download":     page.Download,
-				"upload":       page.Upload,
-				"total":        page.Total,
-				"used":         page.Used,
-				"remained":     page.Remained,
-				"expire":       page.Expire,
-				"lastOnline":   page.LastOnline,
-				"datepicker":   page.Datepicker,
-				"downloadByte": page.DownloadByte,
-				"uploadByte":   page.UploadByte,
-				"totalByte":    page.TotalByte,
-				"subUrl":       page.SubUrl,
-				"subJsonUrl":   page.SubJsonUrl,
-				"result":       page.Result,
-			})
+	// If the request expects HTML (e.g., browser) or explicitly asked (?html=1 or ?view=html), render the info page here
+	accept := c.GetHeader("Accept")
+	if !strings.Contains(strings.ToLower(accept), "text/html") && c.Query("html") != "1" && !strings.EqualFold(c.Query("view"), "html") {
+		// Client apps get the format they import, unless ?format asks for another one
+		switch format := a.requestFormat(c.Query("format"), c.Request.UserAgent()); format {
+		case ClashFormat, ClashMetaFormat:
+			a.serveClash(c, format)
+			return
+		case SingboxFormat:
+			a.subSingbox(c)
+			return
+		case JsonFormat:
+			a.subJsons(c)
+			return
+		}
+		result, header, err := a.cached(subId, LinksFormat, host, func() (string, string, error) {
+			return a.links(subId, host)
+		})
+		if err != nil || len(result) == 0 {
+			c.String(400, "Error!")
 			return
 		}
 
+		if a.subEncrypt {
+			result = base64.StdEncoding.EncodeToString([]byte(result))
+		}
+
 		// Add headers
-		header := fmt.Sprintf("upload=%d; download=%d; total=%d; expire=%d", traffic.Up, traffic.Down, traffic.Total, traffic.ExpiryTime/1000)
 		a.ApplyCommonHeaders(c, header, a.updateInterval, a.subTitle)
+		a.logAccess(c, subId, LinksFormat)
+		c.String(200, result)
+		return
+	}
 
-		if a.subEncrypt {
-			c.String(200, base64.StdEncoding.EncodeToString([]byte(result)))
-		} else {
-			c.String(200, result)
+	subs, lastOnline, traffic, err := a.subService.GetSubs(subId, host)
+	if err != nil || len(subs) == 0 {
+		c.String(400, "Error!")
+		return
+	}
+	// Build page data in service
+	prefix := middleware.ForwardedPrefix(c)
+	subURL, subJsonURL := a.subService.BuildURLs(scheme, hostWithPort, prefix+a.subPath, prefix+a.subJsonPath, subId)
+	if !a.jsonEnabled {
+		subJsonURL = ""
+	}
+	// Signed links keep their signature so the page links still work
+	if sig := c.Query("sig"); sig != "" {
+		query := "?" + url.Values{"expires": {c.Query("expires")}, "sig": {sig}}.Encode()
+		subURL += query
+		if subJsonURL != "" {
+			subJsonURL += query
 		}
 	}
+	// Get base_path from context (set by middleware)
+	basePath, exists := c.Get("base_path")
+	if !exists {
+		basePath = "/"
+	}
+	// Add subId to base_path for asset URLs
+	basePathStr := basePath.(string)
+	if basePathStr == "/" {
+		basePathStr = "/" + subId + "/"
+	} else {
+		// Remove trailing slash if exists, add subId, then add trailing slash
+		basePathStr = strings.TrimRight(basePathStr, "/") + "/" + subId + "/"
+	}
+	a.logAccess(c, subId, "page")
+	page := a.subService.BuildPageData(subId, hostHeader, traffic, lastOnline, subs, subURL, subJsonURL, basePathStr)
+	c.HTML(200, "subpage.html", gin.H{
+		"title":        "subscription.title",
+		"cur_ver":      config.GetVersion(),
+		"host":         page.Host,
+		"base_path":    page.BasePath,
+		"sId":          page.SId,
+		"download":     page.Download,
+		"upload":       page.Upload,
+		"total":        page.Total,
+		"used":         page.Used,
+		"remained":     page.Remained,
+		"expire":       page.Expire,
+		"lastOnline":   page.LastOnline,
+		"datepicker":   page.Datepicker,
+		"downloadByte": page.DownloadByte,
+		"uploadByte":   page.UploadByte,
+		"totalByte":    page.TotalByte,
+		"subUrl":       page.SubUrl,
+		"subJsonUrl":   page.SubJsonUrl,
+		"result":       page.Result,
+	})
+}
+
+// links renders the share links of a subscription and the matching Subscription-Userinfo header.
+func (a *SUBController) links(subId string, host string) (string, string, error) {
+	subs, _, traffic, err := a.subService.GetSubs(subId, host)
+	if err != nil || len(subs) == 0 {
+		return "", "", err
+	}
+	result := ""
+	for _, sub := range subs {
+		result += sub + "\n"
+	}
+	if a.linksTemplate != nil {
+		data := a.subService.newTemplateData(subId, host, traffic)
+		data.Links = subs
+		data.Default = result
+		result = renderSubTemplate(a.linksTemplate, data)
+	}
+	header := fmt.Sprintf("upload=%d; download=%d; total=%d; expire=%d", traffic.Up, traffic.Down, traffic.Total, traffic.ExpiryTime/1000)
+	return result, header, nil
 }
 
 // subJsons handles HTTP requests for JSON subscription configurations.
 func (a *SUBController) subJsons(c *gin.Context) {
-	subId := c.Param("subid")
+	subId := a.subService.GetSubId(c)
 	_, host, _, _ := a.subService.ResolveRequest(c)
-	jsonSub, header, err := a.subJsonService.GetJson(subId, host)
+	jsonSub, header, err := a.cached(subId, JsonFormat, host, func() (string, string, error) {
+		return a.subJsonService.GetJson(subId, host)
+	})
 	if err != nil || len(jsonSub) == 0 {
 		c.String(400, "Error!")
 	} else {
 
 		// Add headers
 		a.ApplyCommonHeaders(c, header, a.updateInterval, a.subTitle)
+		a.logAccess(c, subId, JsonFormat)
 
 		c.String(200, jsonSub)
 	}
 }
 
+// subClash handles HTTP requests for Clash YAML subscription configurations.
+// Clash.Meta is served unless ?format=clash asks for the plain Clash format.
+func (a *SUBController) subClash(c *gin.Context) {
+	format := ClashMetaFormat
+	if c.Query("format") == ClashFormat {
+		format = ClashFormat
+	}
+	a.serveClash(c, format)
+}
+
+// serveClash writes the Clash YAML subscription configuration in the given format.
+func (a *SUBController) serveClash(c *gin.Context, format string) {
+	subId := a.subService.GetSubId(c)
+	_, host, _, _ := a.subService.ResolveRequest(c)
+	clashSub, header, err := a.cached(subId, format, host, func() (string, string, error) {
+		return a.subClashService.GetClash(subId, host, format)
+	})
+	if err != nil || len(clashSub) == 0 {
+		c.String(400, "Error!")
+	} else {
+
+		// Add headers
+		a.ApplyCommonHeaders(c, header, a.updateInterval, a.subTitle)
+		a.logAccess(c, subId, format)
+
+		c.Data(200, "text/yaml; charset=utf-8", []byte(clashSub))
+	}
+}
+
+// subSingbox handles HTTP requests for sing-box JSON subscription configurations.
+func (a *SUBController) subSingbox(c *gin.Context) {
+	subId := a.subService.GetSubId(c)
+	_, host, _, _ := a.subService.ResolveRequest(c)
+	singboxSub, header, err := a.cached(subId, SingboxFormat, host, func() (string, string, error) {
+		return a.subSingboxService.GetSingbox(subId, host)
+	})
+	if err != nil || len(singboxSub) == 0 {
+		c.String(400, "Error!")
+	} else {
+
+		// Add headers
+		a.ApplyCommonHeaders(c, header, a.updateInterval, a.subTitle)
+		a.logAccess(c, subId, SingboxFormat)
+
+		c.Data(200, "application/json; charset=utf-8", []byte(singboxSub))
+	}
+}
+
+// cached returns the response of a subscription in the given format from the cache, rendering
+// it with render and running the extension hooks on it on a miss.
+func (a *SUBController) cached(subId string, format string, host string, render func() (string, string, error)) (string, string, error) {
+	key := subId + "|" + format + "|" + host
+	if entry, ok := a.cache.get(key); ok {
+		return entry.body, entry.header, nil
+	}
+	version := subCacheVersion()
+	body, header, err := render()
+	if err == nil && body != "" {
+		payload := &extension.SubscriptionPayload{SubId: subId, Format: format, Host: host, Body: body, Header: header}
+		a.extensionService.RunHooks(extension.SubscriptionRendered, payload)
+		body, header = payload.Body, payload.Header
+		a.cache.set(key, body, header, version)
+	}
+	return body, header, err
+}
+
+// logAccess records a served subscription for the access analytics.
+func (a *SUBController) logAccess(c *gin.Context, subId string, format string) {
+	err := a.subService.inboundService.AddSubAccess(subId, c.ClientIP(), c.Request.UserAgent(), format)
+	if err != nil {
+		logger.Warning("sub: unable to record access of", subId, ":", err)
+	}
+}
+
 // ApplyCommonHeaders sets common HTTP headers for subscription responses including user info, update interval, and profile title.
 func (a *SUBController) ApplyCommonHeaders(c *gin.Context, header, updateInterval, profileTitle string) {
 	c.Writer.Header().Set("Subscription-Userinfo", header)
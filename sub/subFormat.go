@@ -0,0 +1,69 @@
+package sub
+
+import "strings"
+
+const (
+	// LinksFormat selects the share link list on the links path, overriding the User-Agent detection.
+	LinksFormat = "links"
+	// JsonFormat selects the Xray JSON configuration on the links path.
+	JsonFormat = "json"
+)
+
+// userAgentFormats maps User-Agent fragments of client apps to the format they import best.
+// The first match wins, so the more specific fragments go first.
+var userAgentFormats = []struct {
+	fragment string
+	format   string
+}{
+	{"clash.meta", ClashMetaFormat},
+	{"clash-meta", ClashMetaFormat},
+	{"mihomo", ClashMetaFormat},
+	{"clash-verge", ClashMetaFormat},
+	{"flclash", ClashMetaFormat},
+	{"stash", ClashMetaFormat},
+	{"clash", ClashFormat},
+	{"sing-box", SingboxFormat},
+	{"sfa/", SingboxFormat},
+	{"sfi/", SingboxFormat},
+	{"sfm/", SingboxFormat},
+	{"sft/", SingboxFormat},
+	{"v2rayn", LinksFormat},
+	{"streisand", LinksFormat},
+	{"shadowrocket", LinksFormat},
+	{"hiddify", LinksFormat},
+}
+
+// detectFormat returns the subscription format matching the client app of userAgent,
+// or an empty string for unknown apps.
+func detectFormat(userAgent string) string {
+	userAgent = strings.ToLower(userAgent)
+	for _, entry := range userAgentFormats {
+		if strings.Contains(userAgent, entry.fragment) {
+			return entry.format
+		}
+	}
+	return ""
+}
+
+// requestFormat returns the format requested with ?format, falling back to the format of the
+// client app. Formats whose endpoint is disabled are served as share links.
+func (a *SUBController) requestFormat(format string, userAgent string) string {
+	if format == "" {
+		format = detectFormat(userAgent)
+	}
+	switch format {
+	case ClashFormat, ClashMetaFormat:
+		if a.clashEnabled {
+			return format
+		}
+	case SingboxFormat:
+		if a.singboxEnabled {
+			return format
+		}
+	case JsonFormat:
+		if a.jsonEnabled {
+			return format
+		}
+	}
+	return LinksFormat
+}
@@ -0,0 +1,39 @@
+package sub
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+)
+
+// subFragment holds the TLS fragment parameters of the JSON subscription, which can also be
+// embedded into share links and sing-box configurations.
+type subFragment struct {
+	Packets  string `json:"packets"`
+	Length   string `json:"length"`
+	Interval string `json:"interval"`
+}
+
+// parseSubFragment reads the fragment parameters from the fragment outbound of the JSON subscription.
+// Returns nil if fragmentation is disabled or the outbound is not valid.
+func parseSubFragment(fragmentOutbound string) *subFragment {
+	if fragmentOutbound == "" {
+		return nil
+	}
+	var outbound struct {
+		Settings struct {
+			Fragment *subFragment `json:"fragment"`
+		} `json:"settings"`
+	}
+	if err := json.Unmarshal([]byte(fragmentOutbound), &outbound); err != nil {
+		logger.Warning("sub: invalid fragment settings:", err)
+		return nil
+	}
+	return outbound.Settings.Fragment
+}
+
+// linkParam formats the fragment as the "fragment" share link parameter: packets,length,interval.
+func (f *subFragment) linkParam() string {
+	return strings.Join([]string{f.Packets, f.Length, f.Interval}, ",")
+}
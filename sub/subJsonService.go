@@ -80,6 +80,7 @@ func (s *SubJsonService) GetJson(subId string, host string) (string, string, err
 	var traffic xray.ClientTraffic
 	var clientTraffics []xray.ClientTraffic
 	var configArray []json_util.RawMessage
+	var lastEndpoints []*subEndpoint // Endpoints of unhealthy nodes, listed last
 
 	// Prepare Inbounds
 	for _, inbound := range inbounds {
@@ -102,11 +103,22 @@ func (s *SubJsonService) GetJson(subId string, host string) (string, string, err
 		for _, client := range clients {
 			if client.Enable && client.SubID == subId {
 				clientTraffics = append(clientTraffics, s.SubService.getClientTraffics(inbound.ClientStats, client.Email))
-				newConfigs := s.getConfig(inbound, client, host)
+				clientInbound, clientHost := s.SubService.clientEndpoint(inbound, client, host)
+				newConfigs := s.getConfig(clientInbound, client, clientHost)
 				configArray = append(configArray, newConfigs...)
+				for _, endpoint := range s.SubService.endpoints(inbound, client, host) {
+					if endpoint.last {
+						lastEndpoints = append(lastEndpoints, endpoint)
+					} else {
+						configArray = append(configArray, s.getConfig(endpoint.inbound, client, endpoint.host)...)
+					}
+				}
 			}
 		}
 	}
+	for _, endpoint := range lastEndpoints {
+		configArray = append(configArray, s.getConfig(endpoint.inbound, endpoint.client, endpoint.host)...)
+	}
 
 	if len(configArray) == 0 {
 		return "", "", nil
@@ -149,6 +161,10 @@ func (s *SubJsonService) GetJson(subId string, host string) (string, string, err
 
 func (s *SubJsonService) getConfig(inbound *model.Inbound, client model.Client, host string) []json_util.RawMessage {
 	var newJsonArray []json_util.RawMessage
+	// Xray clients cannot connect to the inbounds served only by sing-box
+	if inbound.Protocol.IsSingboxOnly() {
+		return newJsonArray
+	}
 	stream := s.streamData(inbound.StreamSettings)
 
 	externalProxies, ok := stream["externalProxy"].([]any)
@@ -0,0 +1,160 @@
+package sub
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// subNodesTtl is how long the nodes are reused between subscription requests.
+const subNodesTtl = 10 * time.Second
+
+var (
+	subNodes     []*service.NodeEndpoint
+	subNodesTime time.Time
+	subNodesMu   sync.Mutex
+)
+
+// subEndpoint is a server a client of an inbound is reachable at.
+type subEndpoint struct {
+	inbound *model.Inbound
+	client  model.Client
+	host    string
+	groups  []string // Proxy groups of the node, see service.NodeEndpoint
+	last    bool     // Listed after all other endpoints, as the node is unhealthy
+}
+
+// getNodeEndpoints returns the nodes of the panel, loading them at most every subNodesTtl.
+func (s *SubService) getNodeEndpoints() []*service.NodeEndpoint {
+	subNodesMu.Lock()
+	defer subNodesMu.Unlock()
+	if time.Since(subNodesTime) < subNodesTtl {
+		return subNodes
+	}
+	nodes, err := s.nodeService.GetNodeEndpoints()
+	if err != nil {
+		logger.Warning("sub: get nodes failed:", err)
+		return subNodes
+	}
+	subNodes, subNodesTime = nodes, time.Now()
+	return subNodes
+}
+
+// nodeEndpoints returns the nodes a client of an inbound is reachable at, as the inbound and the
+// client are pushed to them. Unhealthy nodes are left out or listed last, as configured.
+func (s *SubService) nodeEndpoints(inbound *model.Inbound, client model.Client) []*subEndpoint {
+	nodes := s.getNodeEndpoints()
+	if len(nodes) == 0 {
+		return nil
+	}
+	unhealthy, err := s.settingService.GetSubNodeUnhealthy()
+	if err != nil {
+		unhealthy = "exclude"
+	}
+	if loadOrder, _ := s.settingService.GetSubNodeLoadOrder(); loadOrder {
+		nodes = slices.Clone(nodes)
+		slices.SortStableFunc(nodes, func(a, b *service.NodeEndpoint) int { return cmp.Compare(a.Load, b.Load) })
+	}
+	var endpoints []*subEndpoint
+	for _, node := range nodes {
+		if !slices.Contains(node.Clients[inbound.Tag], client.Email) {
+			continue
+		}
+		if !node.Healthy && unhealthy != "last" {
+			continue
+		}
+		nodeInbound := inboundAt(inbound, node.Host)
+		nodeInbound.Remark = inbound.Remark + "-" + node.Name
+		if node.Flag != "" {
+			nodeInbound.Remark = node.Flag + " " + nodeInbound.Remark
+		}
+		endpoints = append(endpoints, &subEndpoint{inbound: nodeInbound, client: client, host: node.Host, groups: node.Groups, last: !node.Healthy})
+	}
+	return endpoints
+}
+
+// endpoints returns the other endpoints a client of an inbound is reachable at besides host: the
+// public IPv6 address of the server, then the nodes.
+func (s *SubService) endpoints(inbound *model.Inbound, client model.Client, host string) []*subEndpoint {
+	endpoints := s.nodeEndpoints(inbound, client)
+	if endpoint := s.ipv6Endpoint(inbound, client, host); endpoint != nil {
+		endpoints = append([]*subEndpoint{endpoint}, endpoints...)
+	}
+	return endpoints
+}
+
+// ipv6Endpoint returns the public IPv6 address of the server as an endpoint of a client of an
+// inbound, when the IPv6 links are enabled, for the clients on IPv6-only networks. An inbound
+// bound to a public IPv6 address is reachable at it, one listening on all addresses at the
+// detected address of the server. It is nil when host already is an IPv6 address, the inbound is
+// bound to another address or has external proxies, or the client has an address of its own.
+func (s *SubService) ipv6Endpoint(inbound *model.Inbound, client model.Client, host string) *subEndpoint {
+	if enable, err := s.settingService.GetSubIpv6Links(); err != nil || !enable {
+		return nil
+	}
+	if strings.Contains(host, ":") {
+		return nil
+	}
+	if clientInbound, _ := s.clientEndpoint(inbound, client, host); clientInbound != inbound {
+		return nil
+	}
+	var stream map[string]any
+	json.Unmarshal([]byte(inbound.StreamSettings), &stream)
+	if externalProxies, _ := stream["externalProxy"].([]any); len(externalProxies) > 0 {
+		return nil
+	}
+
+	var address string
+	switch inbound.Listen {
+	case "", "0.0.0.0", "::", "::0":
+		address = s.serverService.GetPublicIPv6()
+	default:
+		addr, err := netip.ParseAddr(inbound.Listen)
+		if err != nil || !addr.Is6() || addr.Is4In6() || !addr.IsGlobalUnicast() || addr.IsPrivate() {
+			return nil
+		}
+		address = addr.String()
+	}
+	if address == "" {
+		return nil
+	}
+	ipv6Inbound := *inbound
+	ipv6Inbound.Remark = inbound.Remark + "-IPv6"
+	return &subEndpoint{inbound: &ipv6Inbound, client: client, host: address}
+}
+
+// getNodeLink returns the share link of a client at a node.
+func (s *SubService) getNodeLink(endpoint *subEndpoint, email string) string {
+	host := s.address
+	s.address = endpoint.host
+	defer func() { s.address = host }()
+	return s.genLink(endpoint.inbound, email)
+}
+
+// subProxyGroups collects the names of the proxies of every proxy group of the nodes, in the
+// order the groups are first seen.
+type subProxyGroups struct {
+	names   []string
+	proxies map[string][]string
+}
+
+// add adds a proxy to groups.
+func (g *subProxyGroups) add(groups []string, proxy string) {
+	if g.proxies == nil {
+		g.proxies = make(map[string][]string)
+	}
+	for _, group := range groups {
+		if _, ok := g.proxies[group]; !ok {
+			g.names = append(g.names, group)
+		}
+		g.proxies[group] = append(g.proxies[group], proxy)
+	}
+}
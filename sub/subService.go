@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,6 +17,7 @@ import (
 	"github.com/mhsanaei/3x-ui/v2/logger"
 	"github.com/mhsanaei/3x-ui/v2/util/common"
 	"github.com/mhsanaei/3x-ui/v2/util/random"
+	"github.com/mhsanaei/3x-ui/v2/web/middleware"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
 	"github.com/mhsanaei/3x-ui/v2/xray"
 )
@@ -26,8 +28,11 @@ type SubService struct {
 	showInfo       bool
 	remarkModel    string
 	datepicker     string
+	fragment       *subFragment
 	inboundService service.InboundService
 	settingService service.SettingService
+	nodeService    service.NodeService
+	serverService  service.ServerService
 }
 
 // NewSubService creates a new subscription service with the given configuration.
@@ -42,6 +47,7 @@ func NewSubService(showInfo bool, remarkModel string) *SubService {
 func (s *SubService) GetSubs(subId string, host string) ([]string, int64, xray.ClientTraffic, error) {
 	s.address = host
 	var result []string
+	var lastLinks []string // Links of unhealthy nodes, listed last
 	var traffic xray.ClientTraffic
 	var lastOnline int64
 	var clientTraffics []xray.ClientTraffic
@@ -78,6 +84,13 @@ func (s *SubService) GetSubs(subId string, host string) ([]string, int64, xray.C
 			if client.Enable && client.SubID == subId {
 				link := s.getLink(inbound, client.Email)
 				result = append(result, link)
+				for _, endpoint := range s.endpoints(inbound, client, host) {
+					if endpoint.last {
+						lastLinks = append(lastLinks, s.getNodeLink(endpoint, client.Email))
+					} else {
+						result = append(result, s.getNodeLink(endpoint, client.Email))
+					}
+				}
 				ct := s.getClientTraffics(inbound.ClientStats, client.Email)
 				clientTraffics = append(clientTraffics, ct)
 				if ct.LastOnline > lastOnline {
@@ -87,58 +100,65 @@ func (s *SubService) GetSubs(subId string, host string) ([]string, int64, xray.C
 		}
 	}
 
-	// Prepare statistics
-	for index, clientTraffic := range clientTraffics {
-		if index == 0 {
-			traffic.Up = clientTraffic.Up
-			traffic.Down = clientTraffic.Down
-			traffic.Total = clientTraffic.Total
-			if clientTraffic.ExpiryTime > 0 {
-				traffic.ExpiryTime = clientTraffic.ExpiryTime
-			}
-		} else {
-			traffic.Up += clientTraffic.Up
-			traffic.Down += clientTraffic.Down
-			if traffic.Total == 0 || clientTraffic.Total == 0 {
-				traffic.Total = 0
-			} else {
-				traffic.Total += clientTraffic.Total
-			}
-			if clientTraffic.ExpiryTime != traffic.ExpiryTime {
-				traffic.ExpiryTime = 0
-			}
+	result = append(result, lastLinks...)
+
+	traffic = mergeClientTraffics(clientTraffics)
+	return result, lastOnline, traffic, nil
+}
+
+// GetClientLinks returns the share links of the client with the given email.
+// A client of an inbound with external proxies gets one link per proxy.
+func (s *SubService) GetClientLinks(email string, host string) ([]string, error) {
+	s.address = host
+	_, inbound, err := s.inboundService.GetClientInboundByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if inbound == nil {
+		return nil, common.NewError("Client not found:", email)
+	}
+	if len(inbound.Listen) > 0 && inbound.Listen[0] == '@' {
+		listen, port, streamSettings, err := s.getFallbackMaster(inbound.Listen, inbound.StreamSettings)
+		if err == nil {
+			inbound.Listen = listen
+			inbound.Port = port
+			inbound.StreamSettings = streamSettings
 		}
 	}
-	return result, lastOnline, traffic, nil
+	var links []string
+	for _, link := range strings.Split(s.getLink(inbound, email), "\n") {
+		if link != "" {
+			links = append(links, link)
+		}
+	}
+	if len(links) == 0 {
+		return nil, common.NewError("Protocol has no share link:", inbound.Protocol)
+	}
+	return links, nil
+}
+
+// GetSubId returns the subscription ID of a request. A rotated ID that is still in its
+// grace period resolves to the current one.
+func (s *SubService) GetSubId(c *gin.Context) string {
+	return s.inboundService.ResolveSubId(c.Param("subid"))
 }
 
+// getInboundsBySubId returns the inbounds with clients of a subscription. An inbound whose
+// REALITY keys were rotated is followed by a copy with its previous keys during their grace
+// period, so clients that cannot refresh right away still get a link that works.
 func (s *SubService) getInboundsBySubId(subId string) ([]*model.Inbound, error) {
-	db := database.GetDB()
-	var inbounds []*model.Inbound
-	err := db.Model(model.Inbound{}).
-		Preload("ClientStats").
-		Where("protocol IN ? AND enable = ?", []string{"vmess", "vless", "trojan", "shadowsocks"}, true).
-		Find(&inbounds).Error
+	inbounds, err := s.inboundService.GetInboundsBySubId(subId)
 	if err != nil {
 		return nil, err
 	}
-
-	// Filter inbounds that have clients with matching subId
-	var filteredInbounds []*model.Inbound
+	result := make([]*model.Inbound, 0, len(inbounds))
 	for _, inbound := range inbounds {
-		clients, err := s.inboundService.GetClients(inbound)
-		if err != nil {
-			continue
-		}
-		for _, client := range clients {
-			if client.SubID == subId {
-				filteredInbounds = append(filteredInbounds, inbound)
-				break
-			}
+		result = append(result, inbound)
+		if previous := inbound.GetRealityPreviousInbound(); previous != nil {
+			result = append(result, previous)
 		}
 	}
-
-	return filteredInbounds, nil
+	return result, nil
 }
 
 func (s *SubService) getClientTraffics(traffics []xray.ClientTraffic, email string) xray.ClientTraffic {
@@ -204,6 +224,25 @@ func (s *SubService) getFallbackMaster(dest string, streamSettings string) (stri
 }
 
 func (s *SubService) getLink(inbound *model.Inbound, email string) string {
+	clients, _ := s.inboundService.GetClients(inbound)
+	for _, client := range clients {
+		if client.Email != email {
+			continue
+		}
+		clientInbound, address := s.clientEndpoint(inbound, client, s.address)
+		if clientInbound != inbound {
+			host := s.address
+			s.address = address
+			defer func() { s.address = host }()
+			inbound = clientInbound
+		}
+		break
+	}
+	return s.genLink(inbound, email)
+}
+
+// genLink returns the share link of a client of an inbound at the current address.
+func (s *SubService) genLink(inbound *model.Inbound, email string) string {
 	switch inbound.Protocol {
 	case "vmess":
 		return s.genVmessLink(inbound, email)
@@ -213,10 +252,49 @@ func (s *SubService) getLink(inbound *model.Inbound, email string) string {
 		return s.genTrojanLink(inbound, email)
 	case "shadowsocks":
 		return s.genShadowsocksLink(inbound, email)
+	case "hysteria2":
+		return s.genHysteria2Link(inbound, email)
+	case "tuic":
+		return s.genTuicLink(inbound, email)
 	}
 	return ""
 }
 
+// clientEndpoint applies the address override of a client, set on the client itself or
+// through its group, to the host and the external proxies of an inbound. Without an
+// override the inbound and host are returned unchanged.
+func (s *SubService) clientEndpoint(inbound *model.Inbound, client model.Client, host string) (*model.Inbound, string) {
+	address := client.Address
+	if group := client.Fields["group"]; address == "" && group != "" {
+		groups, err := s.settingService.GetSubAddressGroups()
+		if err == nil {
+			address = common.ParseKeyValues(groups)[group]
+		}
+	}
+	if address == "" {
+		return inbound, host
+	}
+	return inboundAt(inbound, address), address
+}
+
+// inboundAt returns a copy of an inbound whose external proxies point to address.
+func inboundAt(inbound *model.Inbound, address string) *model.Inbound {
+	clientInbound := *inbound
+	var stream map[string]any
+	if json.Unmarshal([]byte(inbound.StreamSettings), &stream) == nil {
+		if externalProxies, ok := stream["externalProxy"].([]any); ok && len(externalProxies) > 0 {
+			for _, externalProxy := range externalProxies {
+				if ep, ok := externalProxy.(map[string]any); ok {
+					ep["dest"] = address
+				}
+			}
+			streamSettings, _ := json.Marshal(stream)
+			clientInbound.StreamSettings = string(streamSettings)
+		}
+	}
+	return &clientInbound
+}
+
 func (s *SubService) genVmessLink(inbound *model.Inbound, email string) string {
 	if inbound.Protocol != model.VMESS {
 		return ""
@@ -310,6 +388,9 @@ func (s *SubService) genVmessLink(inbound *model.Inbound, email string) string {
 				obj["allowInsecure"], _ = insecure.(bool)
 			}
 		}
+		if s.fragment != nil {
+			obj["fragment"] = s.fragment.linkParam()
+		}
 	}
 
 	clients, _ := s.inboundService.GetClients(inbound)
@@ -332,7 +413,7 @@ func (s *SubService) genVmessLink(inbound *model.Inbound, email string) string {
 			newSecurity, _ := ep["forceTls"].(string)
 			newObj := map[string]any{}
 			for key, value := range obj {
-				if !(newSecurity == "none" && (key == "alpn" || key == "sni" || key == "fp" || key == "allowInsecure")) {
+				if !(newSecurity == "none" && (key == "alpn" || key == "sni" || key == "fp" || key == "allowInsecure" || key == "fragment")) {
 					newObj[key] = value
 				}
 			}
@@ -510,6 +591,9 @@ func (s *SubService) genVlessLink(inbound *model.Inbound, email string) string {
 	if security != "tls" && security != "reality" {
 		params["security"] = "none"
 	}
+	if s.fragment != nil && (security == "tls" || security == "reality") {
+		params["fragment"] = s.fragment.linkParam()
+	}
 
 	externalProxies, _ := stream["externalProxy"].([]any)
 
@@ -520,7 +604,7 @@ func (s *SubService) genVlessLink(inbound *model.Inbound, email string) string {
 			newSecurity, _ := ep["forceTls"].(string)
 			dest, _ := ep["dest"].(string)
 			port := int(ep["port"].(float64))
-			link := fmt.Sprintf("vless://%s@%s:%d", uuid, dest, port)
+			link := fmt.Sprintf("vless://%s@%s:%d", uuid, linkHost(dest), port)
 
 			if newSecurity != "same" {
 				params["security"] = newSecurity
@@ -531,7 +615,7 @@ func (s *SubService) genVlessLink(inbound *model.Inbound, email string) string {
 			q := url.Query()
 
 			for k, v := range params {
-				if !(newSecurity == "none" && (k == "alpn" || k == "sni" || k == "fp" || k == "allowInsecure")) {
+				if !(newSecurity == "none" && (k == "alpn" || k == "sni" || k == "fp" || k == "allowInsecure" || k == "fragment")) {
 					q.Add(k, v)
 				}
 			}
@@ -549,7 +633,7 @@ func (s *SubService) genVlessLink(inbound *model.Inbound, email string) string {
 		return links
 	}
 
-	link := fmt.Sprintf("vless://%s@%s:%d", uuid, address, port)
+	link := fmt.Sprintf("vless://%s@%s:%d", uuid, linkHost(address), port)
 	url, _ := url.Parse(link)
 	q := url.Query()
 
@@ -705,6 +789,9 @@ func (s *SubService) genTrojanLink(inbound *model.Inbound, email string) string
 	if security != "tls" && security != "reality" {
 		params["security"] = "none"
 	}
+	if s.fragment != nil && (security == "tls" || security == "reality") {
+		params["fragment"] = s.fragment.linkParam()
+	}
 
 	externalProxies, _ := stream["externalProxy"].([]any)
 
@@ -715,7 +802,7 @@ func (s *SubService) genTrojanLink(inbound *model.Inbound, email string) string
 			newSecurity, _ := ep["forceTls"].(string)
 			dest, _ := ep["dest"].(string)
 			port := int(ep["port"].(float64))
-			link := fmt.Sprintf("trojan://%s@%s:%d", password, dest, port)
+			link := fmt.Sprintf("trojan://%s@%s:%d", password, linkHost(dest), port)
 
 			if newSecurity != "same" {
 				params["security"] = newSecurity
@@ -726,7 +813,7 @@ func (s *SubService) genTrojanLink(inbound *model.Inbound, email string) string
 			q := url.Query()
 
 			for k, v := range params {
-				if !(newSecurity == "none" && (k == "alpn" || k == "sni" || k == "fp" || k == "allowInsecure")) {
+				if !(newSecurity == "none" && (k == "alpn" || k == "sni" || k == "fp" || k == "allowInsecure" || k == "fragment")) {
 					q.Add(k, v)
 				}
 			}
@@ -744,7 +831,7 @@ func (s *SubService) genTrojanLink(inbound *model.Inbound, email string) string
 		return links
 	}
 
-	link := fmt.Sprintf("trojan://%s@%s:%d", password, address, port)
+	link := fmt.Sprintf("trojan://%s@%s:%d", password, linkHost(address), port)
 
 	url, _ := url.Parse(link)
 	q := url.Query()
@@ -882,7 +969,7 @@ func (s *SubService) genShadowsocksLink(inbound *model.Inbound, email string) st
 			newSecurity, _ := ep["forceTls"].(string)
 			dest, _ := ep["dest"].(string)
 			port := int(ep["port"].(float64))
-			link := fmt.Sprintf("ss://%s@%s:%d", base64.StdEncoding.EncodeToString([]byte(encPart)), dest, port)
+			link := fmt.Sprintf("ss://%s@%s:%d", base64.StdEncoding.EncodeToString([]byte(encPart)), linkHost(dest), port)
 
 			if newSecurity != "same" {
 				params["security"] = newSecurity
@@ -893,7 +980,7 @@ func (s *SubService) genShadowsocksLink(inbound *model.Inbound, email string) st
 			q := url.Query()
 
 			for k, v := range params {
-				if !(newSecurity == "none" && (k == "alpn" || k == "sni" || k == "fp" || k == "allowInsecure")) {
+				if !(newSecurity == "none" && (k == "alpn" || k == "sni" || k == "fp" || k == "allowInsecure" || k == "fragment")) {
 					q.Add(k, v)
 				}
 			}
@@ -911,7 +998,7 @@ func (s *SubService) genShadowsocksLink(inbound *model.Inbound, email string) st
 		return links
 	}
 
-	link := fmt.Sprintf("ss://%s@%s:%d", base64.StdEncoding.EncodeToString([]byte(encPart)), address, inbound.Port)
+	link := fmt.Sprintf("ss://%s@%s:%d", base64.StdEncoding.EncodeToString([]byte(encPart)), linkHost(address), inbound.Port)
 	url, _ := url.Parse(link)
 	q := url.Query()
 
@@ -926,6 +1013,122 @@ func (s *SubService) genShadowsocksLink(inbound *model.Inbound, email string) st
 	return url.String()
 }
 
+// genHysteria2Link generates a hysteria2:// share link for a client of a Hysteria2 inbound.
+func (s *SubService) genHysteria2Link(inbound *model.Inbound, email string) string {
+	if inbound.Protocol != model.Hysteria2 {
+		return ""
+	}
+	client, ok := s.findClient(inbound, email)
+	if !ok {
+		return ""
+	}
+	var settings map[string]any
+	json.Unmarshal([]byte(inbound.Settings), &settings)
+	params := s.quicLinkParams(inbound)
+	if obfs, _ := settings["obfsPassword"].(string); obfs != "" {
+		params["obfs"] = "salamander"
+		params["obfs-password"] = obfs
+	}
+	if ports := hoppingPorts(inbound, inbound.Port); len(ports) > 1 {
+		params["mport"] = strings.Join(ports[1:], ",")
+	}
+	link := fmt.Sprintf("hysteria2://%s@%s:%d", url.PathEscape(client.Password), linkHost(s.address), inbound.Port)
+	return s.finishQuicLink(inbound, email, link, params)
+}
+
+// genTuicLink generates a tuic:// share link for a client of a TUIC inbound.
+func (s *SubService) genTuicLink(inbound *model.Inbound, email string) string {
+	if inbound.Protocol != model.TUIC {
+		return ""
+	}
+	client, ok := s.findClient(inbound, email)
+	if !ok {
+		return ""
+	}
+	var settings map[string]any
+	json.Unmarshal([]byte(inbound.Settings), &settings)
+	params := s.quicLinkParams(inbound)
+	if congestion, _ := settings["congestionControl"].(string); congestion != "" {
+		params["congestion_control"] = congestion
+	}
+	link := fmt.Sprintf("tuic://%s:%s@%s:%d", client.ID, url.PathEscape(client.Password), linkHost(s.address), inbound.Port)
+	return s.finishQuicLink(inbound, email, link, params)
+}
+
+// hoppingPorts returns the ports and port ranges such as "20000-30000" a client of a Hysteria2
+// inbound reached on port may hop between, the base port first. It returns nil for other
+// protocols, which have no port hopping, and when the port is not the one of the inbound, as an
+// external proxy forwards only that port.
+func hoppingPorts(inbound *model.Inbound, port int) []string {
+	if inbound.Protocol != model.Hysteria2 || inbound.PortRange == "" || port != inbound.Port {
+		return nil
+	}
+	ports := []string{strconv.Itoa(inbound.Port)}
+	for part := range strings.SplitSeq(inbound.PortRange, ",") {
+		if part = strings.ReplaceAll(part, " ", ""); part != "" {
+			ports = append(ports, part)
+		}
+	}
+	return ports
+}
+
+// linkHost returns an address as the host of a share link, IPv6 addresses in brackets.
+func linkHost(address string) string {
+	if strings.Contains(address, ":") && !strings.HasPrefix(address, "[") {
+		return "[" + address + "]"
+	}
+	return address
+}
+
+// findClient returns the client of an inbound with the given email.
+func (s *SubService) findClient(inbound *model.Inbound, email string) (model.Client, bool) {
+	clients, _ := s.inboundService.GetClients(inbound)
+	for _, client := range clients {
+		if client.Email == email {
+			return client, true
+		}
+	}
+	return model.Client{}, false
+}
+
+// quicLinkParams returns the TLS parameters of the share link of a QUIC based inbound.
+func (s *SubService) quicLinkParams(inbound *model.Inbound) map[string]string {
+	var stream map[string]any
+	json.Unmarshal([]byte(inbound.StreamSettings), &stream)
+	tlsSetting, _ := stream["tlsSettings"].(map[string]any)
+	params := make(map[string]string)
+	if sni, _ := tlsSetting["serverName"].(string); sni != "" {
+		params["sni"] = sni
+	}
+	alpns, _ := tlsSetting["alpn"].([]any)
+	var alpn []string
+	for _, a := range alpns {
+		if value, ok := a.(string); ok {
+			alpn = append(alpn, value)
+		}
+	}
+	if len(alpn) > 0 {
+		params["alpn"] = strings.Join(alpn, ",")
+	}
+	tlsSettings, _ := tlsSetting["settings"].(map[string]any)
+	if insecure, _ := tlsSettings["allowInsecure"].(bool); insecure {
+		params["insecure"] = "1"
+	}
+	return params
+}
+
+// finishQuicLink adds the parameters and the remark to the share link of a QUIC based inbound.
+func (s *SubService) finishQuicLink(inbound *model.Inbound, email string, link string, params map[string]string) string {
+	url, _ := url.Parse(link)
+	q := url.Query()
+	for k, v := range params {
+		q.Add(k, v)
+	}
+	url.RawQuery = q.Encode()
+	url.Fragment = s.genRemark(inbound, email, "")
+	return url.String()
+}
+
 func (s *SubService) genRemark(inbound *model.Inbound, email string, extra string) string {
 	separationChar := string(s.remarkModel[0])
 	orderChars := s.remarkModel[1:]
@@ -1077,45 +1280,23 @@ type PageData struct {
 
 // ResolveRequest extracts scheme and host info from request/headers consistently.
 // ResolveRequest extracts scheme, host, and header information from an HTTP request.
+// The forwarding headers are only present when a trusted proxy set them.
 func (s *SubService) ResolveRequest(c *gin.Context) (scheme string, host string, hostWithPort string, hostHeader string) {
 	// scheme
-	scheme = "http"
-	if c.Request.TLS != nil || strings.EqualFold(c.GetHeader("X-Forwarded-Proto"), "https") {
-		scheme = "https"
-	}
+	scheme = middleware.RequestScheme(c)
+
+	// host:port for URLs
+	hostWithPort = middleware.RequestHost(c)
 
 	// base host (no port)
-	if h, err := getHostFromXFH(c.GetHeader("X-Forwarded-Host")); err == nil && h != "" {
+	if h, err := getHostFromXFH(hostWithPort); err == nil && h != "" {
 		host = h
-	}
-	if host == "" {
-		host = c.GetHeader("X-Real-IP")
-	}
-	if host == "" {
-		var err error
-		host, _, err = net.SplitHostPort(c.Request.Host)
-		if err != nil {
-			host = c.Request.Host
-		}
-	}
-
-	// host:port for URLs
-	hostWithPort = c.GetHeader("X-Forwarded-Host")
-	if hostWithPort == "" {
-		hostWithPort = c.Request.Host
-	}
-	if hostWithPort == "" {
-		hostWithPort = host
+	} else {
+		host = hostWithPort
 	}
 
 	// header display host
-	hostHeader = c.GetHeader("X-Forwarded-Host")
-	if hostHeader == "" {
-		hostHeader = c.GetHeader("X-Real-IP")
-	}
-	if hostHeader == "" {
-		hostHeader = host
-	}
+	hostHeader = hostWithPort
 	return
 }
 
@@ -1165,7 +1346,7 @@ func (s *SubService) getBaseSchemeAndHost(requestScheme, requestHostWithPort str
 	}
 
 	// Build host:port, always include port for clarity
-	hostWithPort := fmt.Sprintf("%s:%d", subDomain, subPort)
+	hostWithPort := net.JoinHostPort(subDomain, strconv.Itoa(subPort))
 
 	return scheme, hostWithPort
 }
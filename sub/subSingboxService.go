@@ -0,0 +1,400 @@
+package sub
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// SingboxFormat selects the sing-box configuration on the links path.
+const SingboxFormat = "sing-box"
+
+// SubSingboxService generates sing-box JSON configurations for subscriptions.
+type SubSingboxService struct {
+	template *template.Template
+
+	inboundService service.InboundService
+	SubService     *SubService
+}
+
+// NewSubSingboxService creates a new sing-box subscription service.
+// templateText is an optional Go template replacing the generated configuration.
+func NewSubSingboxService(templateText string, subService *SubService) *SubSingboxService {
+	return &SubSingboxService{
+		template:   parseSubTemplate("sing-box", templateText),
+		SubService: subService,
+	}
+}
+
+// GetSingbox generates a sing-box configuration for the given subscription ID and host.
+// The configuration routes all traffic through a TUN and a local mixed inbound to the
+// selected outbound; proxies sing-box cannot express are left out.
+func (s *SubSingboxService) GetSingbox(subId string, host string) (string, string, error) {
+	inbounds, err := s.SubService.getInboundsBySubId(subId)
+	if err != nil || len(inbounds) == 0 {
+		return "", "", err
+	}
+
+	var clientTraffics []xray.ClientTraffic
+	var outbounds []map[string]any
+	tags := make(map[string]int)
+	var groups subProxyGroups
+	addOutbounds := func(inbound *model.Inbound, client model.Client, host string, proxyGroups []string) {
+		for _, outbound := range s.getOutbounds(inbound, client, host) {
+			// sing-box refuses duplicated outbound tags
+			tag := outbound["tag"].(string)
+			tags[tag]++
+			if tags[tag] > 1 {
+				outbound["tag"] = fmt.Sprintf("%s %d", tag, tags[tag])
+			}
+			outbounds = append(outbounds, outbound)
+			groups.add(proxyGroups, outbound["tag"].(string))
+		}
+	}
+	var lastEndpoints []*subEndpoint // Endpoints of unhealthy nodes, listed last
+	for _, inbound := range inbounds {
+		clients, err := s.inboundService.GetClients(inbound)
+		if err != nil {
+			logger.Error("SubSingboxService - GetClients: Unable to get clients from inbound")
+		}
+		if clients == nil {
+			continue
+		}
+		if len(inbound.Listen) > 0 && inbound.Listen[0] == '@' {
+			listen, port, streamSettings, err := s.SubService.getFallbackMaster(inbound.Listen, inbound.StreamSettings)
+			if err == nil {
+				inbound.Listen = listen
+				inbound.Port = port
+				inbound.StreamSettings = streamSettings
+			}
+		}
+
+		for _, client := range clients {
+			if client.Enable && client.SubID == subId {
+				clientTraffics = append(clientTraffics, s.SubService.getClientTraffics(inbound.ClientStats, client.Email))
+				clientInbound, clientHost := s.SubService.clientEndpoint(inbound, client, host)
+				addOutbounds(clientInbound, client, clientHost, nil)
+				for _, endpoint := range s.SubService.endpoints(inbound, client, host) {
+					if endpoint.last {
+						lastEndpoints = append(lastEndpoints, endpoint)
+					} else {
+						addOutbounds(endpoint.inbound, client, endpoint.host, endpoint.groups)
+					}
+				}
+			}
+		}
+	}
+	for _, endpoint := range lastEndpoints {
+		addOutbounds(endpoint.inbound, endpoint.client, endpoint.host, endpoint.groups)
+	}
+
+	if len(outbounds) == 0 {
+		return "", "", nil
+	}
+
+	outboundTags := make([]string, 0, len(outbounds))
+	for _, outbound := range outbounds {
+		outboundTags = append(outboundTags, outbound["tag"].(string))
+	}
+	// The outbounds of the nodes are also grouped by region and label, each group testing for
+	// the fastest of its outbounds
+	allOutbounds := []map[string]any{
+		{
+			"type":      "selector",
+			"tag":       "proxy",
+			"outbounds": append(append([]string{"auto"}, groups.names...), outboundTags...),
+		},
+		singboxUrlTestOutbound("auto", outboundTags),
+	}
+	for _, name := range groups.names {
+		allOutbounds = append(allOutbounds, singboxUrlTestOutbound(name, groups.proxies[name]))
+	}
+	allOutbounds = append(allOutbounds, outbounds...)
+	allOutbounds = append(allOutbounds, map[string]any{"type": "direct", "tag": "direct"})
+
+	config := map[string]any{
+		"log": map[string]any{"level": "warn"},
+		"inbounds": []map[string]any{
+			{
+				"type":         "tun",
+				"tag":          "tun-in",
+				"address":      []string{"172.19.0.1/30", "fdfe:dcba:9876::1/126"},
+				"auto_route":   true,
+				"strict_route": true,
+			},
+			{
+				"type":        "mixed",
+				"tag":         "mixed-in",
+				"listen":      "127.0.0.1",
+				"listen_port": 2080,
+			},
+		},
+		"outbounds": allOutbounds,
+		"route": map[string]any{
+			"rules": []map[string]any{
+				{"action": "sniff"},
+				{"protocol": "dns", "action": "hijack-dns"},
+				{"ip_is_private": true, "outbound": "direct"},
+			},
+			"final":                 "proxy",
+			"auto_detect_interface": true,
+		},
+	}
+	result, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return "", "", err
+	}
+
+	traffic := mergeClientTraffics(clientTraffics)
+	header := fmt.Sprintf("upload=%d; download=%d; total=%d; expire=%d", traffic.Up, traffic.Down, traffic.Total, traffic.ExpiryTime/1000)
+	if s.template != nil {
+		data := s.SubService.newTemplateData(subId, host, traffic)
+		for _, outbound := range outbounds {
+			data.Proxies = append(data.Proxies, outbound)
+		}
+		data.ProxyNames = outboundTags
+		data.Default = string(result)
+		return renderSubTemplate(s.template, data), header, nil
+	}
+	return string(result), header, nil
+}
+
+// singboxUrlTestOutbound returns an outbound using the fastest of the given outbounds.
+func singboxUrlTestOutbound(tag string, outbounds []string) map[string]any {
+	return map[string]any{
+		"type":      "urltest",
+		"tag":       tag,
+		"outbounds": outbounds,
+		"url":       "https://www.gstatic.com/generate_204",
+		"interval":  "5m",
+	}
+}
+
+// getOutbounds returns the sing-box outbounds of a client, one per external proxy of the inbound.
+func (s *SubSingboxService) getOutbounds(inbound *model.Inbound, client model.Client, host string) []map[string]any {
+	var stream map[string]any
+	json.Unmarshal([]byte(inbound.StreamSettings), &stream)
+
+	externalProxies, ok := stream["externalProxy"].([]any)
+	if !ok || len(externalProxies) == 0 {
+		externalProxies = []any{
+			map[string]any{
+				"forceTls": "same",
+				"dest":     host,
+				"port":     float64(inbound.Port),
+				"remark":   "",
+			},
+		}
+	}
+
+	var outbounds []map[string]any
+	for _, ep := range externalProxies {
+		extPrxy := ep.(map[string]any)
+		security, _ := stream["security"].(string)
+		switch extPrxy["forceTls"].(string) {
+		case "tls":
+			security = "tls"
+		case "none":
+			security = "none"
+		}
+
+		outbound := map[string]any{
+			"tag":         s.SubService.genRemark(inbound, client.Email, extPrxy["remark"].(string)),
+			"server":      extPrxy["dest"].(string),
+			"server_port": int(extPrxy["port"].(float64)),
+		}
+		if ports := hoppingPorts(inbound, int(extPrxy["port"].(float64))); ports != nil {
+			// sing-box takes ranges as "from:to" and rejects server_port next to them
+			for i, port := range ports {
+				if !strings.Contains(port, "-") {
+					port += "-" + port
+				}
+				ports[i] = strings.Replace(port, "-", ":", 1)
+			}
+			delete(outbound, "server_port")
+			outbound["server_ports"] = ports
+		}
+		if !s.addCredentials(outbound, inbound, client) ||
+			!s.addTls(outbound, inbound.Protocol, stream, security) ||
+			!s.addTransport(outbound, inbound.Protocol, stream) {
+			continue
+		}
+		outbounds = append(outbounds, outbound)
+	}
+	return outbounds
+}
+
+// addCredentials sets the outbound type and the client credentials.
+// Reports false if the protocol is not supported by sing-box.
+func (s *SubSingboxService) addCredentials(outbound map[string]any, inbound *model.Inbound, client model.Client) bool {
+	switch inbound.Protocol {
+	case model.VMESS:
+		security := client.Security
+		if security == "" {
+			security = "auto"
+		}
+		outbound["type"] = "vmess"
+		outbound["uuid"] = client.ID
+		outbound["security"] = security
+		outbound["alter_id"] = 0
+	case model.VLESS:
+		outbound["type"] = "vless"
+		outbound["uuid"] = client.ID
+		if client.Flow != "" {
+			outbound["flow"] = client.Flow
+		}
+	case model.Trojan:
+		outbound["type"] = "trojan"
+		outbound["password"] = client.Password
+	case model.Shadowsocks:
+		var settings map[string]any
+		json.Unmarshal([]byte(inbound.Settings), &settings)
+		method, _ := settings["method"].(string)
+		password := client.Password
+		// server password in multi-user 2022 protocols
+		if strings.HasPrefix(method, "2022") {
+			if serverPassword, ok := settings["password"].(string); ok {
+				password = fmt.Sprintf("%s:%s", serverPassword, client.Password)
+			}
+		}
+		outbound["type"] = "shadowsocks"
+		outbound["method"] = method
+		outbound["password"] = password
+	case model.Hysteria2:
+		var settings map[string]any
+		json.Unmarshal([]byte(inbound.Settings), &settings)
+		outbound["type"] = "hysteria2"
+		outbound["password"] = client.Password
+		if obfs, _ := settings["obfsPassword"].(string); obfs != "" {
+			outbound["obfs"] = map[string]any{"type": "salamander", "password": obfs}
+		}
+	case model.TUIC:
+		var settings map[string]any
+		json.Unmarshal([]byte(inbound.Settings), &settings)
+		outbound["type"] = "tuic"
+		outbound["uuid"] = client.ID
+		outbound["password"] = client.Password
+		if congestion, _ := settings["congestionControl"].(string); congestion != "" {
+			outbound["congestion_control"] = congestion
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// addTls sets the TLS or Reality options of the outbound.
+// Reports false if the security is not supported by the protocol.
+func (s *SubSingboxService) addTls(outbound map[string]any, protocol model.Protocol, stream map[string]any, security string) bool {
+	if protocol.IsSingboxOnly() && security != "tls" {
+		return false
+	}
+	switch security {
+	case "tls":
+		if protocol == model.Shadowsocks {
+			return false
+		}
+		tlsSettings, _ := stream["tlsSettings"].(map[string]any)
+		tlsClientSettings, _ := tlsSettings["settings"].(map[string]any)
+		tls := map[string]any{"enabled": true}
+		if serverName, _ := tlsSettings["serverName"].(string); serverName != "" {
+			tls["server_name"] = serverName
+		}
+		if alpn, ok := tlsSettings["alpn"].([]any); ok && len(alpn) > 0 {
+			tls["alpn"] = alpn
+		}
+		if allowInsecure, _ := tlsClientSettings["allowInsecure"].(bool); allowInsecure {
+			tls["insecure"] = true
+		}
+		if fingerprint, _ := tlsClientSettings["fingerprint"].(string); fingerprint != "" {
+			tls["utls"] = map[string]any{"enabled": true, "fingerprint": fingerprint}
+		}
+		if s.SubService.fragment != nil {
+			tls["fragment"] = true
+		}
+		outbound["tls"] = tls
+	case "reality":
+		if protocol != model.VLESS {
+			return false
+		}
+		realitySettings, _ := stream["realitySettings"].(map[string]any)
+		realityClientSettings, _ := realitySettings["settings"].(map[string]any)
+		tls := map[string]any{"enabled": true}
+		if serverNames, ok := realitySettings["serverNames"].([]any); ok && len(serverNames) > 0 {
+			tls["server_name"] = serverNames[random.Num(len(serverNames))]
+		}
+		fingerprint, _ := realityClientSettings["fingerprint"].(string)
+		if fingerprint == "" {
+			fingerprint = "chrome"
+		}
+		tls["utls"] = map[string]any{"enabled": true, "fingerprint": fingerprint}
+		shortId := ""
+		if shortIds, ok := realitySettings["shortIds"].([]any); ok && len(shortIds) > 0 {
+			shortId, _ = shortIds[random.Num(len(shortIds))].(string)
+		}
+		publicKey, _ := realityClientSettings["publicKey"].(string)
+		tls["reality"] = map[string]any{
+			"enabled":    true,
+			"public_key": publicKey,
+			"short_id":   shortId,
+		}
+		outbound["tls"] = tls
+	}
+	return true
+}
+
+// addTransport sets the V2Ray transport of the outbound.
+// Reports false if the transport is not supported by sing-box.
+func (s *SubSingboxService) addTransport(outbound map[string]any, protocol model.Protocol, stream map[string]any) bool {
+	network, _ := stream["network"].(string)
+	if protocol == model.Shadowsocks || protocol.IsSingboxOnly() {
+		return network == "tcp"
+	}
+	switch network {
+	case "tcp":
+		tcp, _ := stream["tcpSettings"].(map[string]any)
+		header, _ := tcp["header"].(map[string]any)
+		if typeStr, _ := header["type"].(string); typeStr != "http" {
+			return true
+		}
+		request, _ := header["request"].(map[string]any)
+		transport := map[string]any{"type": "http", "method": "GET"}
+		if requestPath, ok := request["path"].([]any); ok && len(requestPath) > 0 {
+			transport["path"] = requestPath[0]
+		}
+		if host := searchHost(request["headers"]); host != "" {
+			transport["host"] = []string{host}
+		}
+		outbound["transport"] = transport
+	case "ws", "httpupgrade":
+		settings, _ := stream[network+"Settings"].(map[string]any)
+		path, _ := settings["path"].(string)
+		host, _ := settings["host"].(string)
+		if host == "" {
+			host = searchHost(settings["headers"])
+		}
+		transport := map[string]any{"type": network, "path": path}
+		if host != "" {
+			if network == "ws" {
+				transport["headers"] = map[string]any{"Host": host}
+			} else {
+				transport["host"] = host
+			}
+		}
+		outbound["transport"] = transport
+	case "grpc":
+		grpc, _ := stream["grpcSettings"].(map[string]any)
+		serviceName, _ := grpc["serviceName"].(string)
+		outbound["transport"] = map[string]any{"type": "grpc", "service_name": serviceName}
+	default:
+		return false
+	}
+	return true
+}
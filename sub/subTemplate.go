@@ -0,0 +1,119 @@
+package sub
+
+import (
+	"text/template"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/tmpl"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// SubTemplateClient describes one client of a subscription for custom templates.
+type SubTemplateClient struct {
+	Email      string
+	Comment    string
+	Note       string
+	Fields     map[string]string
+	Enable     bool
+	Up         int64
+	Down       int64
+	Total      int64
+	ExpiryTime int64
+	Inbound    string
+	Protocol   string
+	Port       int
+	Link       string
+}
+
+// SubTemplateData is the data custom subscription templates are rendered with.
+// Proxies and ProxyNames hold the generated Clash proxies or sing-box outbounds, and
+// Default the output the subscription server renders without a template.
+type SubTemplateData struct {
+	SubId      string
+	Up         int64
+	Down       int64
+	Total      int64
+	ExpiryTime int64
+	Clients    []SubTemplateClient
+	Links      []string
+	Proxies    []any
+	ProxyNames []string
+	Default    string
+}
+
+// parseSubTemplate parses a custom subscription template.
+// An invalid template is logged and ignored, so the default output is served instead.
+func parseSubTemplate(name string, text string) *template.Template {
+	t, err := tmpl.Parse(name, text)
+	if err != nil {
+		logger.Warning("sub: invalid", name, "template:", err)
+		return nil
+	}
+	return t
+}
+
+// renderSubTemplate renders a custom subscription template.
+// A failing template is logged and the default output is returned instead.
+func renderSubTemplate(t *template.Template, data *SubTemplateData) string {
+	result, err := tmpl.Execute(t, data)
+	if err != nil {
+		logger.Warning("sub: unable to render", t.Name(), "template:", err)
+		return data.Default
+	}
+	return result
+}
+
+// newTemplateData collects the clients of a subscription and their usage for a custom template.
+func (s *SubService) newTemplateData(subId string, host string, traffic xray.ClientTraffic) *SubTemplateData {
+	data := &SubTemplateData{
+		SubId:      subId,
+		Up:         traffic.Up,
+		Down:       traffic.Down,
+		Total:      traffic.Total,
+		ExpiryTime: traffic.ExpiryTime,
+	}
+	inbounds, err := s.getInboundsBySubId(subId)
+	if err != nil {
+		return data
+	}
+	s.address = host
+	for _, inbound := range inbounds {
+		clients, err := s.inboundService.GetClients(inbound)
+		if err != nil {
+			continue
+		}
+		if len(inbound.Listen) > 0 && inbound.Listen[0] == '@' {
+			listen, port, streamSettings, err := s.getFallbackMaster(inbound.Listen, inbound.StreamSettings)
+			if err == nil {
+				inbound.Listen = listen
+				inbound.Port = port
+				inbound.StreamSettings = streamSettings
+			}
+		}
+		for _, client := range clients {
+			if client.SubID != subId {
+				continue
+			}
+			clientTraffic := s.getClientTraffics(inbound.ClientStats, client.Email)
+			templateClient := SubTemplateClient{
+				Email:      client.Email,
+				Comment:    client.Comment,
+				Note:       client.Note,
+				Fields:     client.Fields,
+				Enable:     client.Enable && clientTraffic.Enable,
+				Up:         clientTraffic.Up,
+				Down:       clientTraffic.Down,
+				Total:      clientTraffic.Total,
+				ExpiryTime: clientTraffic.ExpiryTime,
+				Inbound:    inbound.Remark,
+				Protocol:   string(inbound.Protocol),
+				Port:       inbound.Port,
+			}
+			if client.Enable {
+				templateClient.Link = s.getLink(inbound, client.Email)
+			}
+			data.Clients = append(data.Clients, templateClient)
+		}
+	}
+	return data
+}
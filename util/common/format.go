@@ -2,6 +2,8 @@ package common
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 )
 
 // FormatTraffic formats traffic bytes into human-readable units (B, KB, MB, GB, TB, PB).
@@ -16,3 +18,65 @@ func FormatTraffic(trafficBytes int64) string {
 	}
 	return fmt.Sprintf("%.2f%s", size, units[unitIndex])
 }
+
+// ParseIntList parses a comma-separated list of positive integers such as "7,3,1".
+// Empty entries are ignored.
+func ParseIntList(list string) ([]int, error) {
+	var values []int
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		value, err := strconv.Atoi(part)
+		if err != nil || value <= 0 {
+			return nil, NewError("invalid number in list:", part)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// ParseClockRange parses a daily time range such as "22:00-07:00" into minutes after midnight.
+// The range wraps past midnight when it ends before it starts.
+func ParseClockRange(value string) (start int, end int, err error) {
+	from, to, ok := strings.Cut(value, "-")
+	if !ok {
+		return 0, 0, NewError("invalid time range:", value)
+	}
+	if start, err = parseClock(strings.TrimSpace(from)); err != nil {
+		return 0, 0, err
+	}
+	if end, err = parseClock(strings.TrimSpace(to)); err != nil {
+		return 0, 0, err
+	}
+	if start == end {
+		return 0, 0, NewError("empty time range:", value)
+	}
+	return start, end, nil
+}
+
+// parseClock parses a time of day such as "07:30" into minutes after midnight.
+func parseClock(value string) (int, error) {
+	hours, minutes, ok := strings.Cut(value, ":")
+	h, err1 := strconv.Atoi(hours)
+	m, err2 := strconv.Atoi(minutes)
+	if !ok || err1 != nil || err2 != nil || h < 0 || h > 23 || m < 0 || m > 59 {
+		return 0, NewError("invalid time of day:", value)
+	}
+	return h*60 + m, nil
+}
+
+// ParseKeyValues parses "key=value" lines into a map. Lines without "=" and empty keys are ignored.
+func ParseKeyValues(text string) map[string]string {
+	values := make(map[string]string)
+	for _, line := range strings.Split(text, "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			continue
+		}
+		values[key] = strings.TrimSpace(value)
+	}
+	return values
+}
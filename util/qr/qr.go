@@ -0,0 +1,110 @@
+// Package qr renders QR codes for share links as PNG or SVG images with an optional logo.
+package qr
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"strings"
+
+	// Register decoders for logo files
+	_ "image/gif"
+	_ "image/jpeg"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// logoRatio is the share of the QR code width covered by the logo. It stays well below
+// what the highest error correction level can recover.
+const logoRatio = 5
+
+// DecodeLogo decodes a PNG, JPEG or GIF logo.
+func DecodeLogo(data []byte) (image.Image, error) {
+	logo, _, err := image.Decode(bytes.NewReader(data))
+	return logo, err
+}
+
+// EncodePNG renders content as a PNG QR code of size pixels, with the logo drawn in the center if it is set.
+func EncodePNG(content string, size int, logo image.Image) ([]byte, error) {
+	code, err := newCode(content, logo != nil)
+	if err != nil {
+		return nil, err
+	}
+	if logo == nil {
+		return code.PNG(size)
+	}
+
+	img := code.Image(size)
+	canvas := image.NewRGBA(img.Bounds())
+	draw.Draw(canvas, canvas.Bounds(), img, image.Point{}, draw.Src)
+
+	width := canvas.Bounds().Dx() / logoRatio
+	scaled := scale(logo, width)
+	offset := image.Pt((canvas.Bounds().Dx()-width)/2, (canvas.Bounds().Dy()-width)/2)
+	draw.Draw(canvas, scaled.Bounds().Add(offset), scaled, image.Point{}, draw.Over)
+
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, canvas); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// EncodeSVG renders content as an SVG QR code of size pixels, with the logo embedded in the center if it is set.
+func EncodeSVG(content string, size int, logo []byte) ([]byte, error) {
+	code, err := newCode(content, len(logo) > 0)
+	if err != nil {
+		return nil, err
+	}
+	bitmap := code.Bitmap()
+	modules := len(bitmap)
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		size, size, modules, modules)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, modules, modules)
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&buf, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	buf.WriteString(`"/>`)
+	if len(logo) > 0 {
+		width := float64(modules) / logoRatio
+		offset := (float64(modules) - width) / 2
+		fmt.Fprintf(&buf, `<image x="%.2f" y="%.2f" width="%.2f" height="%.2f" href="data:%s;base64,%s"/>`,
+			offset, offset, width, width, http.DetectContentType(logo), base64.StdEncoding.EncodeToString(logo))
+	}
+	buf.WriteString(`</svg>`)
+	return []byte(buf.String()), nil
+}
+
+// newCode creates the QR code, with the highest error correction when a logo covers part of it.
+func newCode(content string, withLogo bool) (*qrcode.QRCode, error) {
+	level := qrcode.Medium
+	if withLogo {
+		level = qrcode.Highest
+	}
+	return qrcode.New(content, level)
+}
+
+// scale resizes img to a square of width pixels with nearest-neighbour sampling.
+func scale(img image.Image, width int) image.Image {
+	if width <= 0 {
+		width = 1
+	}
+	src := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, width))
+	for y := 0; y < width; y++ {
+		for x := 0; x < width; x++ {
+			dst.Set(x, y, img.At(src.Min.X+x*src.Dx()/width, src.Min.Y+y*src.Dy()/width))
+		}
+	}
+	return dst
+}
@@ -50,6 +50,24 @@ func Seq(n int) string {
 	return string(runes)
 }
 
+// SeqFrom generates a random string of length n containing characters from the given alphabet.
+// An empty alphabet falls back to the alphanumeric characters used by Seq.
+func SeqFrom(n int, alphabet string) string {
+	seq := []rune(alphabet)
+	if len(seq) == 0 {
+		seq = allSeq[:]
+	}
+	runes := make([]rune, n)
+	for i := 0; i < n; i++ {
+		idx, err := rand.Int(rand.Reader, big.NewInt(int64(len(seq))))
+		if err != nil {
+			panic("crypto/rand failed: " + err.Error())
+		}
+		runes[i] = seq[idx.Int64()]
+	}
+	return string(runes)
+}
+
 // Num generates a random integer between 0 and n-1.
 func Num(n int) int {
 	bn := big.NewInt(int64(n))
@@ -0,0 +1,28 @@
+package sys
+
+import (
+	"net/netip"
+
+	"github.com/shirou/gopsutil/v4/net"
+)
+
+// GetEstablishedTCPCountByAddr returns the number of established TCP connections grouped by local
+// address and port. IPv4-mapped addresses of dual-stack sockets are counted as IPv4.
+func GetEstablishedTCPCountByAddr() (map[netip.AddrPort]int, error) {
+	connections, err := net.Connections("tcp")
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[netip.AddrPort]int)
+	for _, conn := range connections {
+		if conn.Status != "ESTABLISHED" {
+			continue
+		}
+		addr, err := netip.ParseAddr(conn.Laddr.IP)
+		if err != nil {
+			continue
+		}
+		counts[netip.AddrPortFrom(addr.Unmap().WithZone(""), uint16(conn.Laddr.Port))]++
+	}
+	return counts, nil
+}
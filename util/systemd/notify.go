@@ -0,0 +1,51 @@
+// Package systemd reports the state of the panel to systemd through the notification socket of
+// the services of Type=notify, and answers their watchdog.
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// The states sent to systemd.
+const (
+	Ready     = "READY=1"     // The servers are started
+	Reloading = "RELOADING=1" // The servers are restarting, Ready follows
+	Stopping  = "STOPPING=1"  // The panel is shutting down
+	Watchdog  = "WATCHDOG=1"  // The panel is alive
+)
+
+// Notify sends a state to systemd. It does nothing when the panel was not started by systemd
+// with a notification socket.
+func Notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// Abstract sockets are given with a leading @
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// WatchdogInterval returns the time systemd waits for the watchdog to be notified before
+// restarting the panel, or 0 when the watchdog is not enabled for this process.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
@@ -0,0 +1,50 @@
+// Package tmpl parses the Go templates admins use to customize subscription outputs.
+package tmpl
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"text/template"
+
+	"github.com/goccy/go-yaml"
+)
+
+// funcs are the helpers available in subscription templates besides the built-in ones.
+var funcs = template.FuncMap{
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"indent": func(spaces int, text string) string {
+		pad := strings.Repeat(" ", spaces)
+		return pad + strings.ReplaceAll(strings.TrimRight(text, "\n"), "\n", "\n"+pad)
+	},
+	"base64": func(text string) string {
+		return base64.StdEncoding.EncodeToString([]byte(text))
+	},
+	"toJson": func(v any) (string, error) {
+		data, err := json.MarshalIndent(v, "", "  ")
+		return string(data), err
+	},
+	"toYaml": func(v any) (string, error) {
+		data, err := yaml.Marshal(v)
+		return string(data), err
+	},
+}
+
+// Parse parses a subscription template. An empty text returns a nil template.
+func Parse(name string, text string) (*template.Template, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+	return template.New(name).Funcs(funcs).Option("missingkey=zero").Parse(text)
+}
+
+// Execute renders a template with the given data.
+func Execute(t *template.Template, data any) (string, error) {
+	var sb strings.Builder
+	if err := t.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
@@ -12,9 +12,14 @@ class DBInbound {
         this.expiryTime = 0;
         this.trafficReset = "never";
         this.lastTrafficResetTime = 0;
+        this.group = "";
+        this.tags = "";
+        this.outboundTag = "";
+        this.maxConnections = 0;
 
         this.listen = "";
         this.port = 0;
+        this.portRange = "";
         this.protocol = "";
         this.settings = "";
         this.streamSettings = "";
@@ -65,7 +70,7 @@ class DBInbound {
 
     get address() {
         let address = location.hostname;
-        if (!ObjectUtil.isEmpty(this.listen) && this.listen !== "0.0.0.0") {
+        if (!ObjectUtil.isEmpty(this.listen) && !['0.0.0.0', '::', '::0'].includes(this.listen)) {
             address = this.listen;
         }
         return address;
@@ -124,6 +129,8 @@ class DBInbound {
             case Protocols.VMESS:
             case Protocols.VLESS:
             case Protocols.TROJAN:
+            case Protocols.HYSTERIA2:
+            case Protocols.TUIC:
                 return true;
             case Protocols.SHADOWSOCKS:
                 return this.toInbound().isSSMultiUser;
@@ -138,6 +145,8 @@ class DBInbound {
             case Protocols.VLESS:
             case Protocols.TROJAN:
             case Protocols.SHADOWSOCKS:
+            case Protocols.HYSTERIA2:
+            case Protocols.TUIC:
                 return true;
             default:
                 return false;
@@ -7,6 +7,8 @@ const Protocols = {
     MIXED: 'mixed',
     HTTP: 'http',
     WIREGUARD: 'wireguard',
+    HYSTERIA2: 'hysteria2',
+    TUIC: 'tuic',
 };
 
 const SSMethods = {
@@ -594,7 +596,7 @@ class TlsStreamSettings extends XrayCommonClass {
         }
 
         if (!ObjectUtil.isEmpty(json.settings)) {
-            settings = new TlsStreamSettings.Settings(json.settings.allowInsecure, json.settings.fingerprint, json.settings.echConfigList);
+            settings = new TlsStreamSettings.Settings(json.settings.allowInsecure, json.settings.fingerprint, json.settings.echConfigList, json.settings.certStore);
         }
         return new TlsStreamSettings(
             json.serverName,
@@ -702,24 +704,28 @@ TlsStreamSettings.Settings = class extends XrayCommonClass {
         allowInsecure = false,
         fingerprint = UTLS_FINGERPRINT.UTLS_CHROME,
         echConfigList = '',
+        certStore = false,
     ) {
         super();
         this.allowInsecure = allowInsecure;
         this.fingerprint = fingerprint;
         this.echConfigList = echConfigList;
+        this.certStore = certStore;
     }
     static fromJson(json = {}) {
         return new TlsStreamSettings.Settings(
             json.allowInsecure,
             json.fingerprint,
             json.echConfigList,
+            json.certStore,
         );
     }
     toJson() {
         return {
             allowInsecure: this.allowInsecure,
             fingerprint: this.fingerprint,
-            echConfigList: this.echConfigList
+            echConfigList: this.echConfigList,
+            certStore: this.certStore,
         };
     }
 };
@@ -802,7 +808,9 @@ RealityStreamSettings.Settings = class extends XrayCommonClass {
         fingerprint = UTLS_FINGERPRINT.UTLS_CHROME,
         serverName = '',
         spiderX = '/',
-        mldsa65Verify = ''
+        mldsa65Verify = '',
+        keyRotatedAt = undefined,
+        previousKeys = undefined
     ) {
         super();
         this.publicKey = publicKey;
@@ -810,6 +818,8 @@ RealityStreamSettings.Settings = class extends XrayCommonClass {
         this.serverName = serverName;
         this.spiderX = spiderX;
         this.mldsa65Verify = mldsa65Verify;
+        this.keyRotatedAt = keyRotatedAt;
+        this.previousKeys = previousKeys;
     }
     static fromJson(json = {}) {
         return new RealityStreamSettings.Settings(
@@ -817,7 +827,9 @@ RealityStreamSettings.Settings = class extends XrayCommonClass {
             json.fingerprint,
             json.serverName,
             json.spiderX,
-            json.mldsa65Verify
+            json.mldsa65Verify,
+            json.keyRotatedAt,
+            json.previousKeys
         );
     }
     toJson() {
@@ -826,7 +838,9 @@ RealityStreamSettings.Settings = class extends XrayCommonClass {
             fingerprint: this.fingerprint,
             serverName: this.serverName,
             spiderX: this.spiderX,
-            mldsa65Verify: this.mldsa65Verify
+            mldsa65Verify: this.mldsa65Verify,
+            keyRotatedAt: this.keyRotatedAt,
+            previousKeys: this.previousKeys
         };
     }
 };
@@ -1071,10 +1085,17 @@ class Inbound extends XrayCommonClass {
             case Protocols.VLESS: return this.settings.vlesses;
             case Protocols.TROJAN: return this.settings.trojans;
             case Protocols.SHADOWSOCKS: return this.isSSMultiUser ? this.settings.shadowsockses : null;
+            case Protocols.HYSTERIA2: return this.settings.hysterias;
+            case Protocols.TUIC: return this.settings.tuics;
             default: return null;
         }
     }
 
+    // Hysteria2 and TUIC run on QUIC with TLS and are served by the sing-box core only
+    get isSingboxOnly() {
+        return [Protocols.HYSTERIA2, Protocols.TUIC].includes(this.protocol);
+    }
+
     get protocol() {
         return this._protocol;
     }
@@ -1085,6 +1106,10 @@ class Inbound extends XrayCommonClass {
         if (protocol === Protocols.TROJAN) {
             this.tls = false;
         }
+        if (this.isSingboxOnly) {
+            this.stream.network = 'tcp';
+            this.stream.security = 'tls';
+        }
     }
 
     get network() {
@@ -1194,6 +1219,7 @@ class Inbound extends XrayCommonClass {
     }
 
     canEnableTls() {
+        if (this.isSingboxOnly) return true;
         if (![Protocols.VMESS, Protocols.VLESS, Protocols.TROJAN, Protocols.SHADOWSOCKS].includes(this.protocol)) return false;
         return ["tcp", "ws", "http", "grpc", "httpupgrade", "xhttp"].includes(this.network);
     }
@@ -1225,6 +1251,16 @@ class Inbound extends XrayCommonClass {
         this.sniffing = new Sniffing();
     }
 
+    // The address of the links, the host of the panel when the inbound listens on all addresses
+    get linkAddress() {
+        return ObjectUtil.isEmpty(this.listen) || ['0.0.0.0', '::', '::0'].includes(this.listen) ? location.hostname : this.listen;
+    }
+
+    // IPv6 addresses are put in brackets in the host of a link
+    static linkHost(address) {
+        return address.includes(':') && !address.startsWith('[') ? `[${address}]` : address;
+    }
+
     genVmessLink(address = '', port = this.port, forceTls, remark = '', clientId, security) {
         if (this.protocol !== Protocols.VMESS) {
             return '';
@@ -1390,7 +1426,7 @@ class Inbound extends XrayCommonClass {
             params.set("security", "none");
         }
 
-        const link = `vless://${uuid}@${address}:${port}`;
+        const link = `vless://${uuid}@${Inbound.linkHost(address)}:${port}`;
         const url = new URL(link);
         for (const [key, value] of params) {
             url.searchParams.set(key, value)
@@ -1472,7 +1508,7 @@ class Inbound extends XrayCommonClass {
         if (this.isSS2022) password.push(settings.password);
         if (this.isSSMultiUser) password.push(clientPassword);
 
-        let link = `ss://${Base64.encode(`${settings.method}:${password.join(':')}`, true)}@${address}:${port}`;
+        let link = `ss://${Base64.encode(`${settings.method}:${password.join(':')}`, true)}@${Inbound.linkHost(address)}:${port}`;
         const url = new URL(link);
         for (const [key, value] of params) {
             url.searchParams.set(key, value)
@@ -1570,7 +1606,7 @@ class Inbound extends XrayCommonClass {
             params.set("security", "none");
         }
 
-        const link = `trojan://${clientPassword}@${address}:${port}`;
+        const link = `trojan://${clientPassword}@${Inbound.linkHost(address)}:${port}`;
         const url = new URL(link);
         for (const [key, value] of params) {
             url.searchParams.set(key, value)
@@ -1579,6 +1615,49 @@ class Inbound extends XrayCommonClass {
         return url.toString();
     }
 
+    genQuicLinkParams() {
+        const params = new Map();
+        if (!ObjectUtil.isEmpty(this.stream.tls.sni)) {
+            params.set("sni", this.stream.tls.sni);
+        }
+        if (this.stream.tls.alpn?.length > 0) {
+            params.set("alpn", this.stream.tls.alpn);
+        }
+        if (this.stream.tls.settings.allowInsecure) {
+            params.set("insecure", "1");
+        }
+        return params;
+    }
+
+    genHysteria2Link(address = '', port = this.port, remark = '', clientPassword) {
+        if (this.protocol !== Protocols.HYSTERIA2) return '';
+        const params = this.genQuicLinkParams();
+        if (!ObjectUtil.isEmpty(this.settings.obfsPassword)) {
+            params.set("obfs", "salamander");
+            params.set("obfs-password", this.settings.obfsPassword);
+        }
+        const url = new URL(`hysteria2://${encodeURIComponent(clientPassword)}@${Inbound.linkHost(address)}:${port}`);
+        for (const [key, value] of params) {
+            url.searchParams.set(key, value)
+        }
+        url.hash = encodeURIComponent(remark);
+        return url.toString();
+    }
+
+    genTuicLink(address = '', port = this.port, remark = '', clientId, clientPassword) {
+        if (this.protocol !== Protocols.TUIC) return '';
+        const params = this.genQuicLinkParams();
+        if (!ObjectUtil.isEmpty(this.settings.congestionControl)) {
+            params.set("congestion_control", this.settings.congestionControl);
+        }
+        const url = new URL(`tuic://${clientId}:${encodeURIComponent(clientPassword)}@${Inbound.linkHost(address)}:${port}`);
+        for (const [key, value] of params) {
+            url.searchParams.set(key, value)
+        }
+        url.hash = encodeURIComponent(remark);
+        return url.toString();
+    }
+
     getWireguardLink(address, port, remark, peerId) {
         let txt = `[Interface]\n`
         txt += `PrivateKey = ${this.settings.peers[peerId].privateKey}\n`
@@ -1591,7 +1670,7 @@ class Inbound extends XrayCommonClass {
         txt += `[Peer]\n`
         txt += `PublicKey = ${this.settings.pubKey}\n`
         txt += `AllowedIPs = 0.0.0.0/0, ::/0\n`
-        txt += `Endpoint = ${address}:${port}`
+        txt += `Endpoint = ${Inbound.linkHost(address)}:${port}`
         if (this.settings.peers[peerId].psk) {
             txt += `\nPresharedKey = ${this.settings.peers[peerId].psk}`
         }
@@ -1611,6 +1690,10 @@ class Inbound extends XrayCommonClass {
                 return this.genSSLink(address, port, forceTls, remark, this.isSSMultiUser ? client.password : '');
             case Protocols.TROJAN:
                 return this.genTrojanLink(address, port, forceTls, remark, client.password);
+            case Protocols.HYSTERIA2:
+                return this.genHysteria2Link(address, port, remark, client.password);
+            case Protocols.TUIC:
+                return this.genTuicLink(address, port, remark, client.id, client.password);
             default: return '';
         }
     }
@@ -1618,7 +1701,10 @@ class Inbound extends XrayCommonClass {
     genAllLinks(remark = '', remarkModel = '-ieo', client) {
         let result = [];
         let email = client ? client.email : '';
-        let addr = !ObjectUtil.isEmpty(this.listen) && this.listen !== "0.0.0.0" ? this.listen : location.hostname;
+        let addr = this.linkAddress;
+        if (client && !ObjectUtil.isEmpty(client.address)) {
+            addr = client.address;
+        }
         let port = this.port;
         const separationChar = remarkModel.charAt(0);
         const orderChars = remarkModel.slice(1);
@@ -1639,7 +1725,7 @@ class Inbound extends XrayCommonClass {
                 let r = orderChars.split('').map(char => orders[char]).filter(x => x.length > 0).join(separationChar);
                 result.push({
                     remark: r,
-                    link: this.genLink(ep.dest, ep.port, ep.forceTls, r, client)
+                    link: this.genLink(client && !ObjectUtil.isEmpty(client.address) ? client.address : ep.dest, ep.port, ep.forceTls, r, client)
                 });
             });
         }
@@ -1647,7 +1733,7 @@ class Inbound extends XrayCommonClass {
     }
 
     genInboundLinks(remark = '', remarkModel = '-ieo') {
-        let addr = !ObjectUtil.isEmpty(this.listen) && this.listen !== "0.0.0.0" ? this.listen : location.hostname;
+        let addr = this.linkAddress;
         if (this.clients) {
             let links = [];
             this.clients.forEach((client) => {
@@ -1684,7 +1770,7 @@ class Inbound extends XrayCommonClass {
 
     toJson() {
         let streamSettings;
-        if (this.canEnableStream() || this.stream?.sockopt) {
+        if (this.canEnableStream() || this.isSingboxOnly || this.stream?.sockopt) {
             streamSettings = this.stream.toJson();
         }
         return {
@@ -1716,6 +1802,8 @@ Inbound.Settings = class extends XrayCommonClass {
             case Protocols.MIXED: return new Inbound.MixedSettings(protocol);
             case Protocols.HTTP: return new Inbound.HttpSettings(protocol);
             case Protocols.WIREGUARD: return new Inbound.WireguardSettings(protocol);
+            case Protocols.HYSTERIA2: return new Inbound.Hysteria2Settings(protocol);
+            case Protocols.TUIC: return new Inbound.TuicSettings(protocol);
             default: return null;
         }
     }
@@ -1730,6 +1818,8 @@ Inbound.Settings = class extends XrayCommonClass {
             case Protocols.MIXED: return Inbound.MixedSettings.fromJson(json);
             case Protocols.HTTP: return Inbound.HttpSettings.fromJson(json);
             case Protocols.WIREGUARD: return Inbound.WireguardSettings.fromJson(json);
+            case Protocols.HYSTERIA2: return Inbound.Hysteria2Settings.fromJson(json);
+            case Protocols.TUIC: return Inbound.TuicSettings.fromJson(json);
             default: return null;
         }
     }
@@ -1792,7 +1882,15 @@ Inbound.VmessSettings.VMESS = class extends XrayCommonClass {
         comment = '',
         reset = 0,
         created_at = undefined,
-        updated_at = undefined
+        updated_at = undefined,
+        planId = 0,
+        resetPolicy = '',
+        resetValue = 0,
+        note = '',
+        fields = {},
+        speedLimit = 0,
+        statusToken = RandomUtil.randomLowerAndNum(24),
+        address = '',
     ) {
         super();
         this.id = id;
@@ -1808,6 +1906,14 @@ Inbound.VmessSettings.VMESS = class extends XrayCommonClass {
         this.reset = reset;
         this.created_at = created_at;
         this.updated_at = updated_at;
+        this.planId = planId;
+        this.resetPolicy = resetPolicy;
+        this.resetValue = resetValue;
+        this.note = note;
+        this.fields = fields;
+        this.speedLimit = speedLimit;
+        this.statusToken = statusToken;
+        this.address = address;
     }
 
     static fromJson(json = {}) {
@@ -1825,6 +1931,14 @@ Inbound.VmessSettings.VMESS = class extends XrayCommonClass {
             json.reset,
             json.created_at,
             json.updated_at,
+            json.planId,
+            json.resetPolicy,
+            json.resetValue,
+            json.note,
+            json.fields,
+            json.speedLimit,
+            json.statusToken,
+            json.address,
         );
     }
     get _expiryTime() {
@@ -1844,6 +1958,22 @@ Inbound.VmessSettings.VMESS = class extends XrayCommonClass {
             this.expiryTime = t.valueOf();
         }
     }
+    get _fields() {
+        return Object.entries(this.fields || {}).map(([key, value]) => `${key}=${value}`).join('\n');
+    }
+
+    set _fields(text) {
+        const fields = {};
+        text.split('\n').forEach(line => {
+            const index = line.indexOf('=');
+            const key = (index < 0 ? line : line.slice(0, index)).trim();
+            if (key) {
+                fields[key] = index < 0 ? '' : line.slice(index + 1).trim();
+            }
+        });
+        this.fields = fields;
+    }
+
     get _totalGB() {
         return NumberFormatter.toFixed(this.totalGB / SizeFormatter.ONE_GB, 2);
     }
@@ -1932,7 +2062,15 @@ Inbound.VLESSSettings.VLESS = class extends XrayCommonClass {
         comment = '',
         reset = 0,
         created_at = undefined,
-        updated_at = undefined
+        updated_at = undefined,
+        planId = 0,
+        resetPolicy = '',
+        resetValue = 0,
+        note = '',
+        fields = {},
+        speedLimit = 0,
+        statusToken = RandomUtil.randomLowerAndNum(24),
+        address = '',
     ) {
         super();
         this.id = id;
@@ -1948,6 +2086,14 @@ Inbound.VLESSSettings.VLESS = class extends XrayCommonClass {
         this.reset = reset;
         this.created_at = created_at;
         this.updated_at = updated_at;
+        this.planId = planId;
+        this.resetPolicy = resetPolicy;
+        this.resetValue = resetValue;
+        this.note = note;
+        this.fields = fields;
+        this.speedLimit = speedLimit;
+        this.statusToken = statusToken;
+        this.address = address;
     }
 
     static fromJson(json = {}) {
@@ -1965,6 +2111,14 @@ Inbound.VLESSSettings.VLESS = class extends XrayCommonClass {
             json.reset,
             json.created_at,
             json.updated_at,
+            json.planId,
+            json.resetPolicy,
+            json.resetValue,
+            json.note,
+            json.fields,
+            json.speedLimit,
+            json.statusToken,
+            json.address,
         );
     }
 
@@ -1985,6 +2139,22 @@ Inbound.VLESSSettings.VLESS = class extends XrayCommonClass {
             this.expiryTime = t.valueOf();
         }
     }
+    get _fields() {
+        return Object.entries(this.fields || {}).map(([key, value]) => `${key}=${value}`).join('\n');
+    }
+
+    set _fields(text) {
+        const fields = {};
+        text.split('\n').forEach(line => {
+            const index = line.indexOf('=');
+            const key = (index < 0 ? line : line.slice(0, index)).trim();
+            if (key) {
+                fields[key] = index < 0 ? '' : line.slice(index + 1).trim();
+            }
+        });
+        this.fields = fields;
+    }
+
     get _totalGB() {
         return NumberFormatter.toFixed(this.totalGB / SizeFormatter.ONE_GB, 2);
     }
@@ -2077,7 +2247,15 @@ Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
         comment = '',
         reset = 0,
         created_at = undefined,
-        updated_at = undefined
+        updated_at = undefined,
+        planId = 0,
+        resetPolicy = '',
+        resetValue = 0,
+        note = '',
+        fields = {},
+        speedLimit = 0,
+        statusToken = RandomUtil.randomLowerAndNum(24),
+        address = '',
     ) {
         super();
         this.password = password;
@@ -2092,6 +2270,14 @@ Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
         this.reset = reset;
         this.created_at = created_at;
         this.updated_at = updated_at;
+        this.planId = planId;
+        this.resetPolicy = resetPolicy;
+        this.resetValue = resetValue;
+        this.note = note;
+        this.fields = fields;
+        this.speedLimit = speedLimit;
+        this.statusToken = statusToken;
+        this.address = address;
     }
 
     toJson() {
@@ -2108,6 +2294,14 @@ Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
             reset: this.reset,
             created_at: this.created_at,
             updated_at: this.updated_at,
+            planId: this.planId,
+            resetPolicy: this.resetPolicy,
+            resetValue: this.resetValue,
+            note: this.note,
+            fields: this.fields,
+            speedLimit: this.speedLimit,
+            statusToken: this.statusToken,
+            address: this.address,
         };
     }
 
@@ -2125,6 +2319,14 @@ Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
             json.reset,
             json.created_at,
             json.updated_at,
+            json.planId,
+            json.resetPolicy,
+            json.resetValue,
+            json.note,
+            json.fields,
+            json.speedLimit,
+            json.statusToken,
+            json.address,
         );
     }
 
@@ -2145,6 +2347,22 @@ Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
             this.expiryTime = t.valueOf();
         }
     }
+    get _fields() {
+        return Object.entries(this.fields || {}).map(([key, value]) => `${key}=${value}`).join('\n');
+    }
+
+    set _fields(text) {
+        const fields = {};
+        text.split('\n').forEach(line => {
+            const index = line.indexOf('=');
+            const key = (index < 0 ? line : line.slice(0, index)).trim();
+            if (key) {
+                fields[key] = index < 0 ? '' : line.slice(index + 1).trim();
+            }
+        });
+        this.fields = fields;
+    }
+
     get _totalGB() {
         return NumberFormatter.toFixed(this.totalGB / SizeFormatter.ONE_GB, 2);
     }
@@ -2194,6 +2412,87 @@ Inbound.TrojanSettings.Fallback = class extends XrayCommonClass {
     }
 };
 
+Inbound.Hysteria2Settings = class extends Inbound.Settings {
+    constructor(protocol,
+        hysterias = [new Inbound.Hysteria2Settings.Hysteria2()],
+        upMbps = 0,
+        downMbps = 0,
+        obfsPassword = '',) {
+        super(protocol);
+        this.hysterias = hysterias;
+        this.upMbps = upMbps;
+        this.downMbps = downMbps;
+        this.obfsPassword = obfsPassword;
+    }
+
+    static fromJson(json = {}) {
+        return new Inbound.Hysteria2Settings(
+            Protocols.HYSTERIA2,
+            (json.clients || []).map(client => Inbound.Hysteria2Settings.Hysteria2.fromJson(client)),
+            json.upMbps,
+            json.downMbps,
+            json.obfsPassword,);
+    }
+
+    toJson() {
+        return {
+            clients: Inbound.Hysteria2Settings.toJsonArray(this.hysterias),
+            upMbps: this.upMbps,
+            downMbps: this.downMbps,
+            obfsPassword: this.obfsPassword,
+        };
+    }
+};
+
+// Hysteria2 clients authenticate with a password like Trojan clients
+Inbound.Hysteria2Settings.Hysteria2 = class extends Inbound.TrojanSettings.Trojan {
+    static fromJson(json = {}) {
+        return Object.assign(new Inbound.Hysteria2Settings.Hysteria2(), Inbound.TrojanSettings.Trojan.fromJson(json));
+    }
+};
+
+Inbound.TuicSettings = class extends Inbound.Settings {
+    constructor(protocol,
+        tuics = [new Inbound.TuicSettings.Tuic()],
+        congestionControl = 'bbr',) {
+        super(protocol);
+        this.tuics = tuics;
+        this.congestionControl = congestionControl;
+    }
+
+    static fromJson(json = {}) {
+        return new Inbound.TuicSettings(
+            Protocols.TUIC,
+            (json.clients || []).map(client => Inbound.TuicSettings.Tuic.fromJson(client)),
+            json.congestionControl,);
+    }
+
+    toJson() {
+        return {
+            clients: Inbound.TuicSettings.toJsonArray(this.tuics),
+            congestionControl: this.congestionControl,
+        };
+    }
+};
+
+// TUIC clients authenticate with a UUID and a password
+Inbound.TuicSettings.Tuic = class extends Inbound.TrojanSettings.Trojan {
+    constructor(id = RandomUtil.randomUUID(), ...args) {
+        super(...args);
+        this.id = id;
+    }
+
+    toJson() {
+        return { id: this.id, ...super.toJson() };
+    }
+
+    static fromJson(json = {}) {
+        const client = Object.assign(new Inbound.TuicSettings.Tuic(), Inbound.TrojanSettings.Trojan.fromJson(json));
+        client.id = json.id;
+        return client;
+    }
+};
+
 Inbound.ShadowsocksSettings = class extends Inbound.Settings {
     constructor(protocol,
         method = SSMethods.BLAKE3_AES_256_GCM,
@@ -2246,7 +2545,15 @@ Inbound.ShadowsocksSettings.Shadowsocks = class extends XrayCommonClass {
         comment = '',
         reset = 0,
         created_at = undefined,
-        updated_at = undefined
+        updated_at = undefined,
+        planId = 0,
+        resetPolicy = '',
+        resetValue = 0,
+        note = '',
+        fields = {},
+        speedLimit = 0,
+        statusToken = RandomUtil.randomLowerAndNum(24),
+        address = '',
     ) {
         super();
         this.method = method;
@@ -2262,6 +2569,14 @@ Inbound.ShadowsocksSettings.Shadowsocks = class extends XrayCommonClass {
         this.reset = reset;
         this.created_at = created_at;
         this.updated_at = updated_at;
+        this.planId = planId;
+        this.resetPolicy = resetPolicy;
+        this.resetValue = resetValue;
+        this.note = note;
+        this.fields = fields;
+        this.speedLimit = speedLimit;
+        this.statusToken = statusToken;
+        this.address = address;
     }
 
     toJson() {
@@ -2279,6 +2594,14 @@ Inbound.ShadowsocksSettings.Shadowsocks = class extends XrayCommonClass {
             reset: this.reset,
             created_at: this.created_at,
             updated_at: this.updated_at,
+            planId: this.planId,
+            resetPolicy: this.resetPolicy,
+            resetValue: this.resetValue,
+            note: this.note,
+            fields: this.fields,
+            speedLimit: this.speedLimit,
+            statusToken: this.statusToken,
+            address: this.address,
         };
     }
 
@@ -2297,6 +2620,14 @@ Inbound.ShadowsocksSettings.Shadowsocks = class extends XrayCommonClass {
             json.reset,
             json.created_at,
             json.updated_at,
+            json.planId,
+            json.resetPolicy,
+            json.resetValue,
+            json.note,
+            json.fields,
+            json.speedLimit,
+            json.statusToken,
+            json.address,
         );
     }
 
@@ -2317,6 +2648,22 @@ Inbound.ShadowsocksSettings.Shadowsocks = class extends XrayCommonClass {
             this.expiryTime = t.valueOf();
         }
     }
+    get _fields() {
+        return Object.entries(this.fields || {}).map(([key, value]) => `${key}=${value}`).join('\n');
+    }
+
+    set _fields(text) {
+        const fields = {};
+        text.split('\n').forEach(line => {
+            const index = line.indexOf('=');
+            const key = (index < 0 ? line : line.slice(0, index)).trim();
+            if (key) {
+                fields[key] = index < 0 ? '' : line.slice(index + 1).trim();
+            }
+        });
+        this.fields = fields;
+    }
+
     get _totalGB() {
         return NumberFormatter.toFixed(this.totalGB / SizeFormatter.ONE_GB, 2);
     }
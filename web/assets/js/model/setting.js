@@ -4,13 +4,26 @@ class AllSetting {
         this.webListen = "";
         this.webDomain = "";
         this.webPort = 2053;
+        this.webListeners = "";
+        this.trustedProxies = "127.0.0.1,::1";
         this.webCertFile = "";
         this.webKeyFile = "";
+        this.acmeEnable = false;
+        this.acmeEmail = "";
+        this.acmeDirectory = "https://acme-v02.api.letsencrypt.org/directory";
+        this.acmeChallenge = "http";
+        this.acmeDnsProvider = "cloudflare";
+        this.acmeDnsCredentials = "";
+        this.acmeWildcard = false;
+        this.certExpiryDays = "14,7,1";
         this.webBasePath = "/";
+        this.decoySite = "";
         this.sessionMaxAge = 360;
         this.pageSize = 25;
         this.expireDiff = 0;
         this.trafficDiff = 0;
+        this.expiryNotifyDays = "";
+        this.quotaNotifyPercent = "";
         this.remarkModel = "-ieo";
         this.datepicker = "gregorian";
         this.tgBotEnable = false;
@@ -18,11 +31,23 @@ class AllSetting {
         this.tgBotProxy = "";
         this.tgBotAPIServer = "";
         this.tgBotChatId = "";
+        this.tgBotSupportChatId = "";
         this.tgRunTime = "@daily";
         this.tgBotBackup = false;
         this.tgBotLoginNotify = true;
+        this.tgBotEvents = "";
+        this.tgBotQuietHours = "";
+        this.tgBotBatchMinutes = 0;
+        this.tgBotWebhookEnable = false;
+        this.tgBotWebhookUrl = "";
         this.tgCpu = 80;
         this.tgLang = "en-US";
+        this.notifyClientUser = false;
+        this.notifyUrgentEvents = "crash,alert";
+        this.clientIdMode = "uuid";
+        this.clientPasswordLength = 10;
+        this.clientPasswordAlphabet = "";
+        this.clientEmailPattern = "";
         this.twoFactorEnable = false;
         this.twoFactorToken = "";
         this.xrayTemplateConfig = "";
@@ -31,11 +56,94 @@ class AllSetting {
         this.subTitle = "";
         this.subListen = "";
         this.subPort = 2096;
+        this.subListeners = "";
         this.subPath = "/sub/";
         this.subJsonPath = "/json/";
         this.subDomain = "";
         this.externalTrafficInformEnable = false;
         this.externalTrafficInformURI = "";
+        this.extensionHooks = "";
+        this.realityKeyRotation = 0;
+        this.realityKeyGrace = 24;
+        this.ipLimitAction = "suspend";
+        this.ipLimitWindow = 5;
+        this.expiryGraceDays = 0;
+        this.coreType = "xray";
+        this.singboxTemplateConfig = "";
+        this.geofileUpdateInterval = 0;
+        this.geofileMirrors = "";
+        this.speedtestInterval = 0;
+        this.speedtestTargets = "https://speed.cloudflare.com/__down?bytes=25000000";
+        this.reachabilityInterval = 0;
+        this.reachabilityHost = "";
+        this.reachabilityProbeUrl = "";
+        this.reportDaily = false;
+        this.reportWeekly = false;
+        this.reportTelegram = true;
+        this.reportEmailTo = "";
+        this.smtpHost = "";
+        this.smtpPort = 587;
+        this.smtpUsername = "";
+        this.smtpPassword = "";
+        this.smtpFrom = "";
+        this.smtpSecurity = "auto";
+        this.smtpAdminTo = "";
+        this.smtpEvents = "";
+        this.smtpQuietHours = "";
+        this.smtpBatchMinutes = 0;
+        this.smtpClientNotify = false;
+        this.smtpBackup = false;
+        this.observatoryProbeUrl = "https://www.google.com/generate_204";
+        this.observatoryProbeInterval = "1m";
+        this.dnsManaged = false;
+        this.dnsQueryStrategy = "UseIP";
+        this.dnsFakeDns = false;
+        this.dnsDisableCache = false;
+        this.trafficStatsInterval = 10;
+        this.trafficFlushInterval = 30;
+        this.jobWorkers = 4;
+        this.outboundTrafficStats = true;
+        this.accessLogAnalytics = false;
+        this.accessLogAnalyticsDays = 7;
+        this.logRotateSize = 100;
+        this.logRotateDays = 7;
+        this.logRotateKeep = 5;
+        this.logRotateCompress = true;
+        this.httpAccessLog = false;
+        this.httpAccessLogFormat = "combined";
+        this.httpAccessLogExclude = "/assets/,/panel/api/server/status";
+        this.trafficHourlyRetentionDays = 7;
+        this.trafficDailyRetentionDays = 90;
+        this.trafficMonthlyRetention = 24;
+        this.trafficArchiveDays = 0;
+        this.trafficArchiveFolder = "";
+        this.trafficArchiveS3Url = "";
+        this.trafficArchiveS3Region = "us-east-1";
+        this.trafficArchiveS3AccessKey = "";
+        this.trafficArchiveS3SecretKey = "";
+        this.serverHistoryInterval = 60;
+        this.serverHistoryRetentionDays = 7;
+        this.anomalyDetection = false;
+        this.anomalySpikeFactor = 5;
+        this.metricsEnable = false;
+        this.metricsToken = "";
+        this.diagnosticsListen = "";
+        this.diagnosticsToken = "";
+        this.metricsPushEnable = false;
+        this.metricsPushFormat = "influx";
+        this.metricsPushUrl = "";
+        this.metricsPushToken = "";
+        this.metricsPushInstance = "";
+        this.metricsPushInterval = 60;
+        this.lokiEnable = false;
+        this.lokiUrl = "";
+        this.lokiToken = "";
+        this.lokiInstance = "";
+        this.lokiAccessLog = false;
+        this.fragmentEnable = false;
+        this.fragmentPackets = "tlshello";
+        this.fragmentLength = "100-200";
+        this.fragmentInterval = "10-20";
         this.subCertFile = "";
         this.subKeyFile = "";
         this.subUpdates = 12;
@@ -47,8 +155,34 @@ class AllSetting {
         this.subJsonNoises = "";
         this.subJsonMux = "";
         this.subJsonRules = "";
+        this.subPortalEnable = false;
+        this.subPortalPath = "/portal/";
+        this.subStatusEnable = false;
+        this.subStatusPath = "/status/";
+        this.subClashEnable = false;
+        this.subClashPath = "/clash/";
+        this.subClashRules = "";
+        this.subSingboxEnable = false;
+        this.subSingboxPath = "/singbox/";
+        this.subLinksTemplate = "";
+        this.subClashTemplate = "";
+        this.subSingboxTemplate = "";
+        this.subAddressGroups = "";
+        this.subSignEnable = false;
+        this.subSignDays = 30;
+        this.subFragmentAll = false;
+        this.subCacheTtl = 0;
+        this.subNodeUnhealthy = "exclude";
+        this.subNodeLoadOrder = false;
+        this.subIpv6Links = false;
+        this.subShortEnable = false;
+        this.subShortPath = "/s/";
+        this.qrLogoFile = "";
 
         this.timeLocation = "Local";
+        this.expiryTimeLocation = "";
+        this.trafficResetTimeLocation = "";
+        this.reportTimeLocation = "";
 
         // LDAP settings
         this.ldapEnable = false;
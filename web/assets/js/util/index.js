@@ -113,6 +113,16 @@ class RandomUtil {
         return Array.from(randomValues, v => seq[v % seqLength]).join('');
     }
 
+    static randomSeqFrom(count, alphabet = '') {
+        if (alphabet.length === 0) {
+            return this.randomSeq(count);
+        }
+        const chars = Array.from(alphabet);
+        const randomValues = new Uint32Array(count);
+        window.crypto.getRandomValues(randomValues);
+        return Array.from(randomValues, v => chars[v % chars.length]).join('');
+    }
+
     static randomShortIds() {
         const lengths = [2, 4, 6, 8, 10, 12, 14, 16].sort(() => Math.random() - 0.5);
 
@@ -0,0 +1,146 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AlertController handles HTTP requests for the alert rules and their events.
+type AlertController struct {
+	alertService service.AlertService
+}
+
+// NewAlertController creates a new AlertController and sets up its routes.
+func NewAlertController(g *gin.RouterGroup) *AlertController {
+	a := &AlertController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for alert rule operations.
+func (a *AlertController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getAlertRules)
+	g.GET("/events", a.getAlertEvents)
+
+	g.POST("/add", a.addAlertRule)
+	g.POST("/update/:id", a.updateAlertRule)
+	g.POST("/del/:id", a.delAlertRule)
+	g.POST("/silence/:id", a.silenceAlertRule)
+	g.POST("/ack/:id", a.ackAlertEvent)
+}
+
+// getAlertRules retrieves all alert rules.
+func (a *AlertController) getAlertRules(c *gin.Context) {
+	rules, err := a.alertService.GetAlertRules()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, rules, nil)
+}
+
+// getAlertEvents retrieves the recent alert events, newest first. The limit query defaults to 100.
+func (a *AlertController) getAlertEvents(c *gin.Context) {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit < 1 || limit > 1000 {
+		limit = 100
+	}
+	events, err := a.alertService.GetAlertEvents(limit)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, events, nil)
+}
+
+// addAlertRule creates a new alert rule.
+func (a *AlertController) addAlertRule(c *gin.Context) {
+	rule := &model.AlertRule{}
+	err := c.ShouldBind(rule)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.alertRuleSaved"), err)
+		return
+	}
+	err = a.alertService.AddAlertRule(rule)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.toasts.alertRuleSaved"), rule, nil)
+}
+
+// updateAlertRule updates an alert rule.
+func (a *AlertController) updateAlertRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.alertRuleSaved"), err)
+		return
+	}
+	rule := &model.AlertRule{}
+	err = c.ShouldBind(rule)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.alertRuleSaved"), err)
+		return
+	}
+	rule.Id = id
+	err = a.alertService.UpdateAlertRule(rule)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.toasts.alertRuleSaved"), rule, nil)
+}
+
+// delAlertRule deletes an alert rule and its events.
+func (a *AlertController) delAlertRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.alertRuleDeleted"), err)
+		return
+	}
+	err = a.alertService.DelAlertRule(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.toasts.alertRuleDeleted"), id, nil)
+}
+
+// silenceAlertRule stops sending the alerts of a rule for the minutes form value, 0 ends the silence.
+func (a *AlertController) silenceAlertRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.alertSilenced"), err)
+		return
+	}
+	minutes, err := strconv.Atoi(c.PostForm("minutes"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.alertSilenced"), err)
+		return
+	}
+	err = a.alertService.SilenceAlertRule(id, minutes)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.alertSilenced"), nil)
+}
+
+// ackAlertEvent acknowledges an alert event.
+func (a *AlertController) ackAlertEvent(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.alertAcknowledged"), err)
+		return
+	}
+	err = a.alertService.AckAlertEvent(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.alertAcknowledged"), nil)
+}
@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AnalyticsController handles HTTP requests for the connection analytics of the access log.
+type AnalyticsController struct {
+	analyticsService service.AnalyticsService
+	anomalyService   service.AnomalyService
+}
+
+// NewAnalyticsController creates a new AnalyticsController and sets up its routes.
+func NewAnalyticsController(g *gin.RouterGroup) *AnalyticsController {
+	a := &AnalyticsController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for connection analytics.
+func (a *AnalyticsController) initRouter(g *gin.RouterGroup) {
+	g.GET("/destinations/:email", a.getClientDestinations)
+	g.GET("/sourceIps/:email", a.getClientSourceIps)
+	g.GET("/domains/:tag", a.getInboundDomains)
+	g.GET("/online", a.getOnlineClients)
+	g.GET("/geo/:kind/:name", a.getGeoBreakdown)
+	g.GET("/anomalies", a.getAnomalies)
+}
+
+// analyticsQuery reads the hours and limit query values, defaulting to the last day and 100 entries.
+func analyticsQuery(c *gin.Context) (int, int) {
+	hours, err := strconv.Atoi(c.Query("hours"))
+	if err != nil || hours < 1 {
+		hours = 24
+	}
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit < 1 || limit > 1000 {
+		limit = 100
+	}
+	return hours, limit
+}
+
+// getClientDestinations returns the destinations a client visited, most used first.
+func (a *AnalyticsController) getClientDestinations(c *gin.Context) {
+	hours, limit := analyticsQuery(c)
+	summaries, err := a.analyticsService.GetClientDestinations(c.Param("email"), hours, limit)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, summaries, nil)
+}
+
+// getClientSourceIps returns the IPs that used a client, most used first.
+func (a *AnalyticsController) getClientSourceIps(c *gin.Context) {
+	hours, limit := analyticsQuery(c)
+	summaries, err := a.analyticsService.GetClientSourceIps(c.Param("email"), hours, limit)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, summaries, nil)
+}
+
+// getInboundDomains returns the domains the clients of an inbound visited, most used first.
+func (a *AnalyticsController) getInboundDomains(c *gin.Context) {
+	hours, limit := analyticsQuery(c)
+	summaries, err := a.analyticsService.GetInboundDomains(c.Param("tag"), hours, limit)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, summaries, nil)
+}
+
+// getOnlineClients returns the online clients with their source IPs and countries.
+func (a *AnalyticsController) getOnlineClients(c *gin.Context) {
+	clients, err := a.analyticsService.GetOnlineClients()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, clients, nil)
+}
+
+// getGeoBreakdown returns the connections of a client or an inbound by destination country and network.
+func (a *AnalyticsController) getGeoBreakdown(c *gin.Context) {
+	hours, _ := analyticsQuery(c)
+	breakdown, err := a.analyticsService.GetGeoBreakdown(c.Param("kind"), c.Param("name"), hours)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, breakdown, nil)
+}
+
+// getAnomalies returns the traffic anomalies of the last days, of one client when the email query is set.
+func (a *AnalyticsController) getAnomalies(c *gin.Context) {
+	days, err := strconv.Atoi(c.Query("days"))
+	if err != nil || days < 1 {
+		days = 7
+	}
+	anomalies, err := a.anomalyService.GetAnomalies(c.Query("email"), days)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, anomalies, nil)
+}
@@ -12,9 +12,20 @@ import (
 // APIController handles the main API routes for the 3x-ui panel, including inbounds and server management.
 type APIController struct {
 	BaseController
-	inboundController *InboundController
-	serverController  *ServerController
-	Tgbot             service.Tgbot
+	inboundController     *InboundController
+	serverController      *ServerController
+	planController        *PlanController
+	routingController     *RoutingController
+	outboundController    *OutboundController
+	balancerController    *BalancerController
+	dnsController         *DnsController
+	reverseController     *ReverseController
+	certificateController *CertificateController
+	analyticsController   *AnalyticsController
+	alertController       *AlertController
+	notifyController      *NotifyController
+	nodeController        *NodeController
+	Tgbot                 service.Tgbot
 }
 
 // NewAPIController creates a new APIController instance and initializes its routes.
@@ -44,6 +55,50 @@ func (a *APIController) initRouter(g *gin.RouterGroup) {
 	inbounds := api.Group("/inbounds")
 	a.inboundController = NewInboundController(inbounds)
 
+	// Client plans API
+	plans := api.Group("/plans")
+	a.planController = NewPlanController(plans)
+
+	// Routing rules API
+	routing := api.Group("/routing")
+	a.routingController = NewRoutingController(routing)
+
+	// Outbounds API
+	outbounds := api.Group("/outbounds")
+	a.outboundController = NewOutboundController(outbounds)
+
+	// Balancers API
+	balancers := api.Group("/balancers")
+	a.balancerController = NewBalancerController(balancers)
+
+	// DNS API
+	dns := api.Group("/dns")
+	a.dnsController = NewDnsController(dns)
+
+	// Reverse proxies API
+	reverses := api.Group("/reverses")
+	a.reverseController = NewReverseController(reverses)
+
+	// Certificate store API
+	certificates := api.Group("/certificates")
+	a.certificateController = NewCertificateController(certificates)
+
+	// Connection analytics API
+	analytics := api.Group("/analytics")
+	a.analyticsController = NewAnalyticsController(analytics)
+
+	// Alert rules API
+	alerts := api.Group("/alerts")
+	a.alertController = NewAlertController(alerts)
+
+	// Notification channels API
+	notify := api.Group("/notify")
+	a.notifyController = NewNotifyController(notify)
+
+	// Nodes API
+	nodes := api.Group("/nodes")
+	a.nodeController = NewNodeController(nodes)
+
 	// Server API
 	server := api.Group("/server")
 	a.serverController = NewServerController(server)
@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BalancerController handles HTTP requests related to the panel-managed balancers
+// and the outbound latencies measured for them.
+type BalancerController struct {
+	balancerService service.BalancerService
+	xrayService     service.XrayService
+}
+
+// NewBalancerController creates a new BalancerController and sets up its routes.
+func NewBalancerController(g *gin.RouterGroup) *BalancerController {
+	a := &BalancerController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for balancer operations.
+func (a *BalancerController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getBalancers)
+	g.GET("/get/:id", a.getBalancer)
+	g.GET("/observatory", a.getObservatory)
+
+	g.POST("/add", a.addBalancer)
+	g.POST("/update/:id", a.updateBalancer)
+	g.POST("/del/:id", a.delBalancer)
+}
+
+// getBalancers retrieves all panel-managed balancers.
+func (a *BalancerController) getBalancers(c *gin.Context) {
+	balancers, err := a.balancerService.GetBalancers()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, balancers, nil)
+}
+
+// getBalancer retrieves a specific balancer by its ID.
+func (a *BalancerController) getBalancer(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "get"), err)
+		return
+	}
+	balancer, err := a.balancerService.GetBalancer(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, balancer, nil)
+}
+
+// getObservatory retrieves the health and latency of the outbounds probed by the observatory.
+func (a *BalancerController) getObservatory(c *gin.Context) {
+	statuses, err := a.xrayService.GetOutboundStatus()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, statuses, nil)
+}
+
+// addBalancer creates a new balancer.
+func (a *BalancerController) addBalancer(c *gin.Context) {
+	balancer := &model.Balancer{}
+	err := c.ShouldBind(balancer)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.balancer.balancerSaved"), err)
+		return
+	}
+	err = a.balancerService.AddBalancer(balancer)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.balancer.balancerSaved"), balancer, nil)
+	a.xrayService.SetToNeedRestart()
+}
+
+// updateBalancer updates a balancer.
+func (a *BalancerController) updateBalancer(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.balancer.balancerSaved"), err)
+		return
+	}
+	balancer := &model.Balancer{}
+	err = c.ShouldBind(balancer)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.balancer.balancerSaved"), err)
+		return
+	}
+	balancer.Id = id
+	err = a.balancerService.UpdateBalancer(balancer)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.balancer.balancerSaved"), balancer, nil)
+	a.xrayService.SetToNeedRestart()
+}
+
+// delBalancer deletes a balancer that no routing rule uses.
+func (a *BalancerController) delBalancer(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.balancer.balancerDeleted"), err)
+		return
+	}
+	err = a.balancerService.DelBalancer(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.balancer.balancerDeleted"), id, nil)
+	a.xrayService.SetToNeedRestart()
+}
@@ -5,7 +5,6 @@ package controller
 import (
 	"net/http"
 
-	"github.com/mhsanaei/3x-ui/v2/logger"
 	"github.com/mhsanaei/3x-ui/v2/web/locale"
 	"github.com/mhsanaei/3x-ui/v2/web/session"
 
@@ -33,7 +32,7 @@ func (a *BaseController) checkLogin(c *gin.Context) {
 func I18nWeb(c *gin.Context, name string, params ...string) string {
 	anyfunc, funcExists := c.Get("I18n")
 	if !funcExists {
-		logger.Warning("I18n function not exists in gin context!")
+		requestLogger(c).Warning("I18n function not exists in gin context!")
 		return ""
 	}
 	i18nFunc, _ := anyfunc.(func(i18nType locale.I18nType, key string, keyParams ...string) string)
@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CertificateController handles HTTP requests related to the certificate store.
+type CertificateController struct {
+	certificateService service.CertificateService
+	xrayService        service.XrayService
+}
+
+// NewCertificateController creates a new CertificateController and sets up its routes.
+func NewCertificateController(g *gin.RouterGroup) *CertificateController {
+	a := &CertificateController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for certificate store operations.
+func (a *CertificateController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getCertificates)
+	g.GET("/get/:id", a.getCertificate)
+	g.GET("/status", a.getCertificateStatus)
+
+	g.POST("/add", a.addCertificate)
+	g.POST("/update/:id", a.updateCertificate)
+	g.POST("/del/:id", a.delCertificate)
+}
+
+// getCertificates retrieves all certificates of the store.
+func (a *CertificateController) getCertificates(c *gin.Context) {
+	certificates, err := a.certificateService.GetCertificates()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, certificates, nil)
+}
+
+// getCertificate retrieves a specific certificate by its ID.
+func (a *CertificateController) getCertificate(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "get"), err)
+		return
+	}
+	certificate, err := a.certificateService.GetCertificate(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, certificate, nil)
+}
+
+// getCertificateStatus retrieves every certificate in use with when it expires.
+func (a *CertificateController) getCertificateStatus(c *gin.Context) {
+	status, err := a.certificateService.GetCertificateStatus()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, status, nil)
+}
+
+// addCertificate adds a certificate to the store.
+func (a *CertificateController) addCertificate(c *gin.Context) {
+	certificate := &model.Certificate{}
+	err := c.ShouldBind(certificate)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.certificateSaved"), err)
+		return
+	}
+	needRestart, err := a.certificateService.AddCertificate(certificate)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.certificateSaved"), certificate, nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// updateCertificate updates a certificate of the store.
+func (a *CertificateController) updateCertificate(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.certificateSaved"), err)
+		return
+	}
+	certificate := &model.Certificate{}
+	err = c.ShouldBind(certificate)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.certificateSaved"), err)
+		return
+	}
+	certificate.Id = id
+	needRestart, err := a.certificateService.UpdateCertificate(certificate)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.certificateSaved"), certificate, nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// delCertificate removes a certificate from the store.
+func (a *CertificateController) delCertificate(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.certificateDeleted"), err)
+		return
+	}
+	needRestart, err := a.certificateService.DelCertificate(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.certificateDeleted"), id, nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
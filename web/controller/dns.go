@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DnsController handles HTTP requests related to the managed Xray DNS servers.
+type DnsController struct {
+	dnsService  service.DnsService
+	xrayService service.XrayService
+}
+
+// NewDnsController creates a new DnsController and sets up its routes.
+func NewDnsController(g *gin.RouterGroup) *DnsController {
+	a := &DnsController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for DNS server operations.
+func (a *DnsController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getServers)
+	g.GET("/get/:id", a.getServer)
+
+	g.POST("/add", a.addServer)
+	g.POST("/update/:id", a.updateServer)
+	g.POST("/del/:id", a.delServer)
+	g.POST("/order", a.reorderServers)
+}
+
+// getServers retrieves all DNS servers in the order they are queried.
+func (a *DnsController) getServers(c *gin.Context) {
+	servers, err := a.dnsService.GetServers()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, servers, nil)
+}
+
+// getServer retrieves a specific DNS server by its ID.
+func (a *DnsController) getServer(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "get"), err)
+		return
+	}
+	server, err := a.dnsService.GetServer(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, server, nil)
+}
+
+// addServer creates a new DNS server.
+func (a *DnsController) addServer(c *gin.Context) {
+	server := &model.DnsServer{}
+	err := c.ShouldBind(server)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.dns.serverSaved"), err)
+		return
+	}
+	err = a.dnsService.AddServer(server)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.dns.serverSaved"), server, nil)
+	a.xrayService.SetToNeedRestart()
+}
+
+// updateServer updates a DNS server.
+func (a *DnsController) updateServer(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.dns.serverSaved"), err)
+		return
+	}
+	server := &model.DnsServer{}
+	err = c.ShouldBind(server)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.dns.serverSaved"), err)
+		return
+	}
+	server.Id = id
+	err = a.dnsService.UpdateServer(server)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.dns.serverSaved"), server, nil)
+	a.xrayService.SetToNeedRestart()
+}
+
+// delServer deletes a DNS server.
+func (a *DnsController) delServer(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.dns.serverDeleted"), err)
+		return
+	}
+	err = a.dnsService.DelServer(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.dns.serverDeleted"), id, nil)
+	a.xrayService.SetToNeedRestart()
+}
+
+// reorderServers applies the server order given as a JSON array of server IDs in the ids form value.
+func (a *DnsController) reorderServers(c *gin.Context) {
+	var ids []int
+	err := json.Unmarshal([]byte(c.PostForm("ids")), &ids)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.dns.serverSaved"), err)
+		return
+	}
+	err = a.dnsService.ReorderServers(ids)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsg(c, I18nWeb(c, "pages.xray.dns.serverSaved"), nil)
+	a.xrayService.SetToNeedRestart()
+}
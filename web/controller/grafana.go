@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GrafanaController serves the traffic and server history to the Grafana JSON datasource.
+type GrafanaController struct {
+	grafanaService service.GrafanaService
+	metricsService service.MetricsService
+}
+
+// grafanaQuery is the body of a Grafana JSON datasource query.
+type grafanaQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	IntervalMs int64 `json:"intervalMs"`
+	Targets    []struct {
+		Target string `json:"target"`
+		Hide   bool   `json:"hide"`
+	} `json:"targets"`
+}
+
+// NewGrafanaController creates a new GrafanaController and sets up its routes.
+func NewGrafanaController(g *gin.RouterGroup) *GrafanaController {
+	a := &GrafanaController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes of the JSON datasource, authenticated with the metrics token.
+func (a *GrafanaController) initRouter(g *gin.RouterGroup) {
+	g = g.Group("/grafana")
+	g.Use(a.checkToken)
+
+	g.GET("", a.testDatasource)
+	g.GET("/", a.testDatasource)
+	g.POST("/search", a.search)
+	g.POST("/metrics", a.metrics)
+	g.POST("/query", a.query)
+}
+
+// checkToken answers 404 unless the request carries the metrics token, like the metrics endpoint.
+func (a *GrafanaController) checkToken(c *gin.Context) {
+	if !a.metricsService.CheckMetricsToken(metricsRequestToken(c)) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.Next()
+}
+
+// testDatasource answers the connection test of Grafana.
+func (a *GrafanaController) testDatasource(c *gin.Context) {
+	c.String(http.StatusOK, "OK")
+}
+
+// search returns the names of the available targets, filtered by the target of the body.
+func (a *GrafanaController) search(c *gin.Context) {
+	var body struct {
+		Target string `json:"target"`
+	}
+	_ = c.ShouldBindJSON(&body)
+	targets, err := a.grafanaService.SearchTargets(body.Target)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, targets)
+}
+
+// metrics returns the available targets as the label and value pairs of the newer JSON datasource.
+func (a *GrafanaController) metrics(c *gin.Context) {
+	var body struct {
+		Metric string `json:"metric"`
+	}
+	_ = c.ShouldBindJSON(&body)
+	targets, err := a.grafanaService.SearchTargets(body.Metric)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	options := make([]gin.H, 0, len(targets))
+	for _, target := range targets {
+		options = append(options, gin.H{"label": target, "value": target})
+	}
+	c.JSON(http.StatusOK, options)
+}
+
+// query returns the time series of the targets of the body over its range.
+func (a *GrafanaController) query(c *gin.Context) {
+	var body grafanaQuery
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	var targets []string
+	for _, target := range body.Targets {
+		if !target.Hide && target.Target != "" {
+			targets = append(targets, target.Target)
+		}
+	}
+	series, err := a.grafanaService.Query(targets, body.Range.From, body.Range.To, time.Duration(body.IntervalMs)*time.Millisecond)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, series)
+}
@@ -2,10 +2,11 @@ package controller
 
 import (
 	"encoding/json"
-	"fmt"
+	"io"
 	"strconv"
 
 	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/middleware"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
 	"github.com/mhsanaei/3x-ui/v2/web/session"
 
@@ -14,8 +15,15 @@ import (
 
 // InboundController handles HTTP requests related to Xray inbounds management.
 type InboundController struct {
-	inboundService service.InboundService
-	xrayService    service.XrayService
+	inboundService   service.InboundService
+	xrayService      service.XrayService
+	shareService     service.ShareService
+	settingService   service.SettingService
+	shortLinkService service.ShortLinkService
+	tgbotService     service.Tgbot
+
+	trafficSampleService service.TrafficSampleService
+	reachabilityService  service.ReachabilityService
 }
 
 // NewInboundController creates a new InboundController and sets up its routes.
@@ -29,20 +37,47 @@ func NewInboundController(g *gin.RouterGroup) *InboundController {
 func (a *InboundController) initRouter(g *gin.RouterGroup) {
 
 	g.GET("/list", a.getInbounds)
+	g.GET("/groups", a.getInboundGroups)
+	g.GET("/freePort", a.getFreePort)
+	g.GET("/connections", a.getInboundConnections)
 	g.GET("/get/:id", a.getInbound)
+	g.GET("/history/:id", a.getInboundHistory)
+	g.GET("/history/:id/diff", a.diffInboundVersions)
 	g.GET("/getClientTraffics/:email", a.getClientTraffics)
 	g.GET("/getClientTrafficsById/:id", a.getClientTrafficsById)
+	g.GET("/getClientTrafficHistory/:email", a.getClientTrafficHistory)
+	g.GET("/trafficSamples/:kind/:name", a.getTrafficSamples)
+	g.GET("/reachability", a.getReachability)
+	g.GET("/getClientSubAccess/:email", a.getClientSubAccess)
+	g.GET("/clientLinks/:email", a.getClientLinks)
+	g.GET("/clientQr/:email", a.getClientQr)
+	g.GET("/shortLinks", a.getShortLinks)
 
 	g.POST("/add", a.addInbound)
+	g.POST("/validate", a.validateInbound)
 	g.POST("/del/:id", a.delInbound)
 	g.POST("/update/:id", a.updateInbound)
+	g.POST("/history/:id/rollback/:versionId", a.rollbackInbound)
 	g.POST("/clientIps/:email", a.getClientIps)
 	g.POST("/clearClientIps/:email", a.clearClientIps)
 	g.POST("/addClient", a.addInboundClient)
 	g.POST("/:id/delClient/:clientId", a.delInboundClient)
 	g.POST("/updateClient/:clientId", a.updateInboundClient)
+	g.POST("/moveClients", a.moveClients)
+	g.POST("/checkReachability", a.checkReachability)
+	g.POST("/transferClient/:email", a.transferClient)
+	g.POST("/rotateClientSubId/:email", a.rotateClientSubId)
+	g.POST("/revokeClientSubId/:email", a.revokeClientSubId)
+	g.POST("/clientLinkCode/:email", a.createClientLinkCode)
+	g.POST("/signSubLink/:subId", a.signSubLink)
+	g.POST("/addShortLink", a.addShortLink)
+	g.POST("/delShortLink/:id", a.delShortLink)
+	g.POST("/bulkClients", a.bulkUpdateClients)
+	g.POST("/importPanel", a.importPanelUsers)
 	g.POST("/:id/resetClientTraffic/:email", a.resetClientTraffic)
 	g.POST("/resetAllTraffics", a.resetAllTraffics)
+	g.POST("/resetTraffic/:id", a.resetInboundTraffic)
+	g.POST("/rotateRealityKeys/:id", a.rotateRealityKeys)
 	g.POST("/resetAllClientTraffics/:id", a.resetAllClientTraffics)
 	g.POST("/delDepletedClients/:id", a.delDepletedClients)
 	g.POST("/import", a.importInbound)
@@ -60,7 +95,41 @@ func (a *InboundController) getInbounds(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
 		return
 	}
-	jsonObj(c, inbounds, nil)
+	inbounds = a.inboundService.FilterInbounds(inbounds, c.Query("group"), c.Query("tag"), c.Query("client"), c.Query("field"))
+	jsonList(c, inbounds)
+}
+
+// getInboundGroups retrieves the aggregated traffic of the logged-in user's inbounds per group.
+func (a *InboundController) getInboundGroups(c *gin.Context) {
+	user := session.GetLoginUser(c)
+	groups, err := a.inboundService.GetInboundGroups(user.Id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, groups, nil)
+}
+
+// getFreePort suggests a port that is free for a new inbound on the requested listen address.
+func (a *InboundController) getFreePort(c *gin.Context) {
+	port, err := a.inboundService.GetFreePort(c.Query("listen"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, port, nil)
+}
+
+// getInboundConnections retrieves the current number of connections of each inbound of the logged-in user.
+func (a *InboundController) getInboundConnections(c *gin.Context) {
+	user := session.GetLoginUser(c)
+	inbounds, err := a.inboundService.GetInbounds(user.Id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	counts, err := a.inboundService.GetInboundConnections(inbounds)
+	jsonObj(c, counts, err)
 }
 
 // getInbound retrieves a specific inbound by its ID.
@@ -78,6 +147,62 @@ func (a *InboundController) getInbound(c *gin.Context) {
 	jsonObj(c, inbound, nil)
 }
 
+// getInboundHistory retrieves the stored configuration snapshots of an inbound.
+func (a *InboundController) getInboundHistory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "get"), err)
+		return
+	}
+	history, err := a.inboundService.GetInboundHistory(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, history, nil)
+}
+
+// diffInboundVersions compares two versions of an inbound given by the from and to query parameters.
+// A missing or zero version refers to the current configuration.
+func (a *InboundController) diffInboundVersions(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "get"), err)
+		return
+	}
+	from, _ := strconv.Atoi(c.Query("from"))
+	to, _ := strconv.Atoi(c.Query("to"))
+	diffs, err := a.inboundService.DiffInboundVersions(id, from, to)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, diffs, nil)
+}
+
+// rollbackInbound restores the configuration of an inbound from a stored snapshot.
+func (a *InboundController) rollbackInbound(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), err)
+		return
+	}
+	versionId, err := strconv.Atoi(c.Param("versionId"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), err)
+		return
+	}
+	inbound, needRestart, err := a.inboundService.RollbackInbound(id, versionId)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), inbound, nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
 // getClientTraffics retrieves client traffic information by email.
 func (a *InboundController) getClientTraffics(c *gin.Context) {
 	email := c.Param("email")
@@ -89,6 +214,79 @@ func (a *InboundController) getClientTraffics(c *gin.Context) {
 	jsonObj(c, clientTraffics, nil)
 }
 
+// getClientTrafficHistory retrieves the daily traffic of a client, optionally limited by the from and to days.
+func (a *InboundController) getClientTrafficHistory(c *gin.Context) {
+	email := c.Param("email")
+	traffics, err := a.inboundService.GetClientDailyTraffics(email, c.Query("from"), c.Query("to"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.trafficGetError"), err)
+		return
+	}
+	jsonList(c, traffics)
+}
+
+// getTrafficSamples retrieves the traffic time series of an inbound tag or a client email,
+// optionally limited by the from and to Unix milliseconds.
+func (a *InboundController) getTrafficSamples(c *gin.Context) {
+	from, _ := strconv.ParseInt(c.Query("from"), 10, 64)
+	to, _ := strconv.ParseInt(c.Query("to"), 10, 64)
+	samples, err := a.trafficSampleService.GetTrafficSamples(c.Param("kind"), c.Param("name"), from, to)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.trafficGetError"), err)
+		return
+	}
+	jsonList(c, samples)
+}
+
+// getReachability returns the results of the last reachability check of the inbounds.
+func (a *InboundController) getReachability(c *gin.Context) {
+	jsonObj(c, a.reachabilityService.GetReachability(), nil)
+}
+
+// checkReachability checks now whether the enabled inbounds are reachable from the outside.
+func (a *InboundController) checkReachability(c *gin.Context) {
+	results, err := a.reachabilityService.CheckInbounds()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, results, nil)
+}
+
+// getClientSubAccess retrieves the recorded subscription fetches of a client.
+func (a *InboundController) getClientSubAccess(c *gin.Context) {
+	stats, err := a.inboundService.GetClientSubAccess(c.Param("email"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, stats, nil)
+}
+
+// getClientLinks returns the share links of a client.
+func (a *InboundController) getClientLinks(c *gin.Context) {
+	links, err := a.shareService.GetClientLinks(c.Param("email"), middleware.RequestHost(c))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, links, nil)
+}
+
+// getClientQr returns a share link of a client as a PNG or SVG QR code.
+// Query parameters: index of the link, format (png or svg), size in pixels and logo (true/false).
+func (a *InboundController) getClientQr(c *gin.Context) {
+	index, _ := strconv.Atoi(c.DefaultQuery("index", "0"))
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "256"))
+	withLogo := c.DefaultQuery("logo", "true") == "true"
+	data, contentType, err := a.shareService.GetClientQr(c.Param("email"), middleware.RequestHost(c), index, c.Query("format"), size, withLogo)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	c.Data(200, contentType, data)
+}
+
 // getClientTrafficsById retrieves client traffic information by inbound ID.
 func (a *InboundController) getClientTrafficsById(c *gin.Context) {
 	id := c.Param("id")
@@ -110,11 +308,19 @@ func (a *InboundController) addInbound(c *gin.Context) {
 	}
 	user := session.GetLoginUser(c)
 	inbound.UserId = user.Id
-	if inbound.Listen == "" || inbound.Listen == "0.0.0.0" || inbound.Listen == "::" || inbound.Listen == "::0" {
-		inbound.Tag = fmt.Sprintf("inbound-%v", inbound.Port)
-	} else {
-		inbound.Tag = fmt.Sprintf("inbound-%v:%v", inbound.Listen, inbound.Port)
+	inbound.Listen, err = service.NormalizeListen(inbound.Listen)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	if inbound.Port == 0 {
+		inbound.Port, err = a.inboundService.GetFreePort(inbound.Listen)
+		if err != nil {
+			jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+			return
+		}
 	}
+	inbound.Tag = service.InboundTag(inbound.Listen, inbound.Port)
 
 	inbound, needRestart, err := a.inboundService.AddInbound(inbound)
 	if err != nil {
@@ -196,6 +402,17 @@ func (a *InboundController) clearClientIps(c *gin.Context) {
 	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.logCleanSuccess"), nil)
 }
 
+// validateInbound checks the fallbacks, sniffing and transport settings of an inbound without saving it.
+func (a *InboundController) validateInbound(c *gin.Context) {
+	inbound := &model.Inbound{}
+	err := c.ShouldBind(inbound)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundValidateSuccess"), a.inboundService.ValidateInbound(inbound))
+}
+
 // addInboundClient adds a new client to an existing inbound.
 func (a *InboundController) addInboundClient(c *gin.Context) {
 	data := &model.Inbound{}
@@ -284,12 +501,46 @@ func (a *InboundController) resetAllTraffics(c *gin.Context) {
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
-	} else {
-		a.xrayService.SetToNeedRestart()
 	}
 	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.resetAllTrafficSuccess"), nil)
 }
 
+// resetInboundTraffic resets traffic counters of a specific inbound and re-enables it if it was depleted.
+func (a *InboundController) resetInboundTraffic(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), err)
+		return
+	}
+	needRestart, err := a.inboundService.ResetInboundTraffic(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.resetInboundClientTrafficSuccess"), nil)
+}
+
+// rotateRealityKeys replaces the REALITY key pair of a specific inbound with a new one.
+func (a *InboundController) rotateRealityKeys(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), err)
+		return
+	}
+	inbound, needRestart, err := a.inboundService.RotateRealityKeys(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), inbound, nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
 // resetAllClientTraffics resets traffic counters for all clients in a specific inbound.
 func (a *InboundController) resetAllClientTraffics(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
@@ -298,11 +549,11 @@ func (a *InboundController) resetAllClientTraffics(c *gin.Context) {
 		return
 	}
 
-	err = a.inboundService.ResetAllClientTraffics(id)
+	needRestart, err := a.inboundService.ResetAllClientTraffics(id)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
-	} else {
+	} else if needRestart {
 		a.xrayService.SetToNeedRestart()
 	}
 	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.resetAllClientTrafficSuccess"), nil)
@@ -319,11 +570,12 @@ func (a *InboundController) importInbound(c *gin.Context) {
 	user := session.GetLoginUser(c)
 	inbound.Id = 0
 	inbound.UserId = user.Id
-	if inbound.Listen == "" || inbound.Listen == "0.0.0.0" || inbound.Listen == "::" || inbound.Listen == "::0" {
-		inbound.Tag = fmt.Sprintf("inbound-%v", inbound.Port)
-	} else {
-		inbound.Tag = fmt.Sprintf("inbound-%v:%v", inbound.Listen, inbound.Port)
+	inbound.Listen, err = service.NormalizeListen(inbound.Listen)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
 	}
+	inbound.Tag = service.InboundTag(inbound.Listen, inbound.Port)
 
 	for index := range inbound.ClientStats {
 		inbound.ClientStats[index].Id = 0
@@ -390,6 +642,206 @@ func (a *InboundController) updateClientTraffic(c *gin.Context) {
 	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundClientUpdateSuccess"), nil)
 }
 
+// moveClients moves or copies the selected clients from one inbound to another.
+func (a *InboundController) moveClients(c *gin.Context) {
+	type MoveClientsRequest struct {
+		FromId int      `json:"fromId" form:"fromId"`
+		ToId   int      `json:"toId" form:"toId"`
+		Emails []string `json:"emails" form:"emails"`
+		Copy   bool     `json:"copy" form:"copy"`
+	}
+
+	var request MoveClientsRequest
+	err := c.ShouldBind(&request)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), err)
+		return
+	}
+
+	needRestart, err := a.inboundService.MoveClients(request.FromId, request.ToId, request.Emails, request.Copy)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundClientUpdateSuccess"), nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// transferClient moves a client to another inbound, keeping its identity and stats.
+func (a *InboundController) transferClient(c *gin.Context) {
+	toId, err := strconv.Atoi(c.PostForm("toId"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundClientUpdateSuccess"), err)
+		return
+	}
+
+	needRestart, err := a.inboundService.TransferClient(c.Param("email"), toId)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundClientUpdateSuccess"), nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// rotateClientSubId gives the subscription of a client a new ID, keeping the old one
+// working for the graceHours form value.
+func (a *InboundController) rotateClientSubId(c *gin.Context) {
+	graceHours := 0
+	if value := c.PostForm("graceHours"); value != "" {
+		var err error
+		graceHours, err = strconv.Atoi(value)
+		if err != nil {
+			jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+			return
+		}
+	}
+	subId, err := a.inboundService.RotateClientSubId(c.Param("email"), graceHours)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.inboundClientUpdateSuccess"), subId, nil)
+}
+
+// createClientLinkCode creates a one-time code the client sends to the Telegram bot to link their account.
+func (a *InboundController) createClientLinkCode(c *gin.Context) {
+	linkCode, err := a.tgbotService.CreateClientLinkCode(c.Param("email"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, linkCode, nil)
+}
+
+// revokeClientSubId removes the subscription ID from a client and the clients sharing it.
+func (a *InboundController) revokeClientSubId(c *gin.Context) {
+	count, err := a.inboundService.RevokeClientSubId(c.Param("email"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.inboundClientUpdateSuccess"), count, nil)
+}
+
+// getShortLinks lists the short links of the client given by the email query, or all of them.
+func (a *InboundController) getShortLinks(c *gin.Context) {
+	links, err := a.shortLinkService.GetShortLinks(c.Query("email"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, links, nil)
+}
+
+// addShortLink creates a short link to the target URL, optionally tied to a client.
+func (a *InboundController) addShortLink(c *gin.Context) {
+	link, err := a.shortLinkService.AddShortLink(c.PostForm("target"), c.PostForm("email"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, link, nil)
+}
+
+// delShortLink revokes a short link.
+func (a *InboundController) delShortLink(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	err = a.shortLinkService.DelShortLink(id)
+	jsonMsg(c, I18nWeb(c, "pages.inbounds.shortLinkRevoked"), err)
+}
+
+// signSubLink returns the query string signing a subscription URL for the given number of days.
+func (a *InboundController) signSubLink(c *gin.Context) {
+	days := 0
+	if value := c.PostForm("days"); value != "" {
+		var err error
+		days, err = strconv.Atoi(value)
+		if err != nil {
+			jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+			return
+		}
+	}
+	query, err := a.settingService.SignSubIdForDays(c.Param("subId"), days)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, query, nil)
+}
+
+// bulkUpdateClients applies one action to the selected clients of all inbounds.
+func (a *InboundController) bulkUpdateClients(c *gin.Context) {
+	type BulkClientsRequest struct {
+		Emails []string `json:"emails" form:"emails"`
+		Action string   `json:"action" form:"action"`
+		Value  int      `json:"value" form:"value"`
+	}
+
+	var request BulkClientsRequest
+	err := c.ShouldBind(&request)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundClientUpdateSuccess"), err)
+		return
+	}
+
+	needRestart, count, err := a.inboundService.BulkUpdateClients(request.Emails, request.Action, request.Value)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.bulkClientsSuccess"), count, nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// importPanelUsers imports the users of an export of another panel, uploaded as file or sent as
+// data, into the inbounds given per protocol.
+func (a *InboundController) importPanelUsers(c *gin.Context) {
+	type PanelImportRequest struct {
+		Format      string `form:"format"`
+		Data        string `form:"data"`
+		Vless       int    `form:"vless"`
+		Vmess       int    `form:"vmess"`
+		Trojan      int    `form:"trojan"`
+		Shadowsocks int    `form:"shadowsocks"`
+	}
+
+	var request PanelImportRequest
+	if err := c.ShouldBind(&request); err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	data := []byte(request.Data)
+	if file, _, err := c.Request.FormFile("file"); err == nil {
+		defer file.Close()
+		if data, err = io.ReadAll(file); err != nil {
+			jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+			return
+		}
+	}
+	targets := map[model.Protocol]int{
+		model.VLESS:       request.Vless,
+		model.VMESS:       request.Vmess,
+		model.Trojan:      request.Trojan,
+		model.Shadowsocks: request.Shadowsocks,
+	}
+	result, needRestart, err := a.inboundService.ImportPanelUsers(data, request.Format, targets)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.panelImportSuccess"), result, err)
+}
+
 // delInboundClientByEmail deletes a client from an inbound by email address.
 func (a *InboundController) delInboundClientByEmail(c *gin.Context) {
 	inboundId, err := strconv.Atoi(c.Param("id"))
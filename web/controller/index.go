@@ -5,7 +5,6 @@ import (
 	"text/template"
 	"time"
 
-	"github.com/mhsanaei/3x-ui/v2/logger"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
 	"github.com/mhsanaei/3x-ui/v2/web/session"
 
@@ -77,28 +76,28 @@ func (a *IndexController) login(c *gin.Context) {
 	safePass := template.HTMLEscapeString(form.Password)
 
 	if user == nil {
-		logger.Warningf("wrong username: \"%s\", password: \"%s\", IP: \"%s\"", safeUser, safePass, getRemoteIp(c))
-		a.tgbot.UserLoginNotify(safeUser, safePass, getRemoteIp(c), timeStr, 0)
+		requestLogger(c).Warningf("wrong username: \"%s\", password: \"%s\", IP: \"%s\"", safeUser, safePass, c.ClientIP())
+		a.tgbot.UserLoginNotify(safeUser, safePass, c.ClientIP(), timeStr, 0)
 		pureJsonMsg(c, http.StatusOK, false, I18nWeb(c, "pages.login.toasts.wrongUsernameOrPassword"))
 		return
 	}
 
-	logger.Infof("%s logged in successfully, Ip Address: %s\n", safeUser, getRemoteIp(c))
-	a.tgbot.UserLoginNotify(safeUser, ``, getRemoteIp(c), timeStr, 1)
+	requestLogger(c).Infof("%s logged in successfully, Ip Address: %s\n", safeUser, c.ClientIP())
+	a.tgbot.UserLoginNotify(safeUser, ``, c.ClientIP(), timeStr, 1)
 
 	sessionMaxAge, err := a.settingService.GetSessionMaxAge()
 	if err != nil {
-		logger.Warning("Unable to get session's max age from DB")
+		requestLogger(c).Warning("Unable to get session's max age from DB")
 	}
 
 	session.SetMaxAge(c, sessionMaxAge*60)
 	session.SetLoginUser(c, user)
 	if err := sessions.Default(c).Save(); err != nil {
-		logger.Warning("Unable to save session: ", err)
+		requestLogger(c).Warning("Unable to save session: ", err)
 		return
 	}
 
-	logger.Infof("%s logged in successfully", safeUser)
+	requestLogger(c).Infof("%s logged in successfully", safeUser)
 	jsonMsg(c, I18nWeb(c, "pages.login.toasts.successLogin"), nil)
 }
 
@@ -106,11 +105,11 @@ func (a *IndexController) login(c *gin.Context) {
 func (a *IndexController) logout(c *gin.Context) {
 	user := session.GetLoginUser(c)
 	if user != nil {
-		logger.Infof("%s logged out successfully", user.Username)
+		requestLogger(c).Infof("%s logged out successfully", user.Username)
 	}
 	session.ClearSession(c)
 	if err := sessions.Default(c).Save(); err != nil {
-		logger.Warning("Unable to save session after clearing:", err)
+		requestLogger(c).Warning("Unable to save session after clearing:", err)
 	}
 	c.Redirect(http.StatusTemporaryRedirect, c.GetString("base_path"))
 }
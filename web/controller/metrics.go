@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsController serves the panel and core metrics to Prometheus.
+type MetricsController struct {
+	metricsService service.MetricsService
+}
+
+// NewMetricsController creates a new MetricsController and sets up its routes.
+func NewMetricsController(g *gin.RouterGroup) *MetricsController {
+	a := &MetricsController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the route of the metrics endpoint.
+func (a *MetricsController) initRouter(g *gin.RouterGroup) {
+	g.GET("/metrics", a.getMetrics)
+}
+
+// metricsRequestToken returns the metrics token of a request, taken from a bearer Authorization
+// header, the password of basic authentication or the token query parameter.
+func metricsRequestToken(c *gin.Context) string {
+	if token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); found {
+		return token
+	}
+	if _, password, ok := c.Request.BasicAuth(); ok {
+		return password
+	}
+	return c.Query("token")
+}
+
+// getMetrics returns the metrics in the Prometheus text format. Like the API, the endpoint
+// answers 404 if it is disabled or the token is wrong, to hide its existence.
+func (a *MetricsController) getMetrics(c *gin.Context) {
+	if !a.metricsService.CheckMetricsToken(metricsRequestToken(c)) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	metrics, err := a.metricsService.GetMetrics()
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(metrics))
+}
@@ -0,0 +1,281 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/middleware"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NodeController handles HTTP requests for the servers managed through their node agents.
+type NodeController struct {
+	nodeService service.NodeService
+}
+
+// NewNodeController creates a new NodeController and sets up its routes.
+func NewNodeController(g *gin.RouterGroup) *NodeController {
+	a := &NodeController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for node operations.
+func (a *NodeController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getNodes)
+
+	g.POST("/add", a.addNode)
+	g.POST("/bootstrap/hostKey", a.getNodeHostKey)
+	g.POST("/bootstrap", a.bootstrapNode)
+	g.POST("/update/:id", a.updateNode)
+	g.POST("/del/:id", a.delNode)
+	g.POST("/token/:id", a.renewNodeToken)
+	g.POST("/sync/:id", a.syncNode)
+
+	g.GET("/status", a.getNodeSyncStatuses)
+	g.GET("/status/:id", a.getNodeSyncStatus)
+	g.GET("/usage/:email", a.getClientNodeUsage)
+	g.GET("/load", a.getNodeLoads)
+
+	g.GET("/policy/list", a.getNodeSyncPolicies)
+	g.POST("/policy/add", a.addNodeSyncPolicy)
+	g.POST("/policy/update/:id", a.updateNodeSyncPolicy)
+	g.POST("/policy/del/:id", a.delNodeSyncPolicy)
+}
+
+// getNodes retrieves all nodes.
+func (a *NodeController) getNodes(c *gin.Context) {
+	nodes, err := a.nodeService.GetNodes()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, nodes, nil)
+}
+
+// addNode creates a new node and returns it with its registration token.
+func (a *NodeController) addNode(c *gin.Context) {
+	node := &model.Node{}
+	err := c.ShouldBind(node)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.nodeSaved"), err)
+		return
+	}
+	err = a.nodeService.AddNode(node)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.toasts.nodeSaved"), node, nil)
+}
+
+// getNodeHostKey returns the SSH host key fingerprint of a server to bootstrap, which the
+// administrator confirms and passes to bootstrapNode.
+func (a *NodeController) getNodeHostKey(c *gin.Context) {
+	bootstrap := &service.NodeBootstrap{}
+	err := c.ShouldBind(bootstrap)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	hostKey, err := a.nodeService.GetNodeHostKey(bootstrap.SshHost, bootstrap.SshPort)
+	jsonObj(c, hostKey, err)
+}
+
+// bootstrapNode installs the node agent on a fresh server over SSH and registers it as a node.
+// The SSH host key fingerprint has to be confirmed first, see getNodeHostKey.
+// The panel URL defaults to the URL this request reached the panel at.
+func (a *NodeController) bootstrapNode(c *gin.Context) {
+	bootstrap := &service.NodeBootstrap{}
+	err := c.ShouldBind(bootstrap)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.nodeBootstrapped"), err)
+		return
+	}
+	if bootstrap.PanelUrl == "" {
+		bootstrap.PanelUrl = middleware.RequestScheme(c) + "://" + middleware.RequestHost(c) + c.GetString("base_path")
+	}
+	result, err := a.nodeService.BootstrapNode(bootstrap)
+	if err != nil {
+		jsonMsgObj(c, I18nWeb(c, "somethingWentWrong"), result, err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.toasts.nodeBootstrapped"), result, nil)
+}
+
+// updateNode updates a node.
+func (a *NodeController) updateNode(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.nodeSaved"), err)
+		return
+	}
+	node := &model.Node{}
+	err = c.ShouldBind(node)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.nodeSaved"), err)
+		return
+	}
+	node.Id = id
+	err = a.nodeService.UpdateNode(node)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.toasts.nodeSaved"), node, nil)
+}
+
+// delNode deletes a node.
+func (a *NodeController) delNode(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.nodeDeleted"), err)
+		return
+	}
+	err = a.nodeService.DelNode(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.toasts.nodeDeleted"), id, nil)
+}
+
+// renewNodeToken issues a new registration token to a node.
+func (a *NodeController) renewNodeToken(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.nodeSaved"), err)
+		return
+	}
+	node, err := a.nodeService.RenewNodeToken(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.toasts.nodeSaved"), node, nil)
+}
+
+// syncNode syncs a node right away.
+func (a *NodeController) syncNode(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.nodeSynced"), err)
+		return
+	}
+	node, err := a.nodeService.SyncNode(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.toasts.nodeSynced"), node, nil)
+}
+
+// getNodeSyncStatuses retrieves the sync state of all nodes.
+func (a *NodeController) getNodeSyncStatuses(c *gin.Context) {
+	statuses, err := a.nodeService.GetNodeSyncStatuses()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, statuses, nil)
+}
+
+// getNodeSyncStatus retrieves the sync state of a node.
+func (a *NodeController) getNodeSyncStatus(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	status, err := a.nodeService.GetNodeSyncStatus(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, status, nil)
+}
+
+// getClientNodeUsage retrieves the usage of a client broken down by server.
+func (a *NodeController) getClientNodeUsage(c *gin.Context) {
+	usages, err := a.nodeService.GetClientNodeUsage(c.Param("email"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, usages, nil)
+}
+
+// getNodeLoads retrieves the load of every node.
+func (a *NodeController) getNodeLoads(c *gin.Context) {
+	loads, err := a.nodeService.GetNodeLoads()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, loads, nil)
+}
+
+// getNodeSyncPolicies retrieves all sync policies.
+func (a *NodeController) getNodeSyncPolicies(c *gin.Context) {
+	policies, err := a.nodeService.GetNodeSyncPolicies()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, policies, nil)
+}
+
+// addNodeSyncPolicy creates a new sync policy.
+func (a *NodeController) addNodeSyncPolicy(c *gin.Context) {
+	policy := &model.NodeSyncPolicy{}
+	err := c.ShouldBind(policy)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.nodeSyncPolicySaved"), err)
+		return
+	}
+	err = a.nodeService.AddNodeSyncPolicy(policy)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.toasts.nodeSyncPolicySaved"), policy, nil)
+}
+
+// updateNodeSyncPolicy updates a sync policy.
+func (a *NodeController) updateNodeSyncPolicy(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.nodeSyncPolicySaved"), err)
+		return
+	}
+	policy := &model.NodeSyncPolicy{}
+	err = c.ShouldBind(policy)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.nodeSyncPolicySaved"), err)
+		return
+	}
+	policy.Id = id
+	err = a.nodeService.UpdateNodeSyncPolicy(policy)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.toasts.nodeSyncPolicySaved"), policy, nil)
+}
+
+// delNodeSyncPolicy deletes a sync policy.
+func (a *NodeController) delNodeSyncPolicy(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.nodeSyncPolicyDeleted"), err)
+		return
+	}
+	err = a.nodeService.DelNodeSyncPolicy(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.toasts.nodeSyncPolicyDeleted"), id, nil)
+}
@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NodeRegisterController lets node agents register with the panel using a registration token.
+type NodeRegisterController struct {
+	nodeService service.NodeService
+}
+
+// NewNodeRegisterController creates a new NodeRegisterController and sets up its routes.
+func NewNodeRegisterController(g *gin.RouterGroup) *NodeRegisterController {
+	a := &NodeRegisterController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the route of the registration endpoint, authenticated with the token.
+func (a *NodeRegisterController) initRouter(g *gin.RouterGroup) {
+	g.POST("/node/register", a.register)
+}
+
+// register signs the certificate request of a node agent. Like the metrics endpoint, it answers
+// 404 if the token is wrong, to hide its existence.
+func (a *NodeRegisterController) register(c *gin.Context) {
+	var req struct {
+		Token   string `json:"token"`
+		Address string `json:"address"`
+		Csr     string `json:"csr"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	cert, err := a.nodeService.RegisterNode(req.Token, req.Address, req.Csr)
+	if err != nil {
+		requestLogger(c).Warning("Node registration from", c.ClientIP(), "failed:", err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	jsonObj(c, cert, nil)
+}
@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NotifyController handles HTTP requests for the notification channels.
+type NotifyController struct {
+	notifyService service.NotifyService
+}
+
+// NewNotifyController creates a new NotifyController and sets up its routes.
+func NewNotifyController(g *gin.RouterGroup) *NotifyController {
+	a := &NotifyController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for notification channel operations.
+func (a *NotifyController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getNotifyChannels)
+
+	g.POST("/add", a.addNotifyChannel)
+	g.POST("/update/:id", a.updateNotifyChannel)
+	g.POST("/del/:id", a.delNotifyChannel)
+	g.POST("/test/:id", a.testNotifyChannel)
+}
+
+// getNotifyChannels retrieves all notification channels.
+func (a *NotifyController) getNotifyChannels(c *gin.Context) {
+	channels, err := a.notifyService.GetNotifyChannels()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, channels, nil)
+}
+
+// addNotifyChannel creates a new notification channel.
+func (a *NotifyController) addNotifyChannel(c *gin.Context) {
+	channel := &model.NotifyChannel{}
+	err := c.ShouldBind(channel)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.notifyChannelSaved"), err)
+		return
+	}
+	err = a.notifyService.AddNotifyChannel(channel)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.toasts.notifyChannelSaved"), channel, nil)
+}
+
+// updateNotifyChannel updates a notification channel.
+func (a *NotifyController) updateNotifyChannel(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.notifyChannelSaved"), err)
+		return
+	}
+	channel := &model.NotifyChannel{}
+	err = c.ShouldBind(channel)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.notifyChannelSaved"), err)
+		return
+	}
+	channel.Id = id
+	err = a.notifyService.UpdateNotifyChannel(channel)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.toasts.notifyChannelSaved"), channel, nil)
+}
+
+// delNotifyChannel deletes a notification channel.
+func (a *NotifyController) delNotifyChannel(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.notifyChannelDeleted"), err)
+		return
+	}
+	err = a.notifyService.DelNotifyChannel(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.toasts.notifyChannelDeleted"), id, nil)
+}
+
+// testNotifyChannel sends a test message to a notification channel.
+func (a *NotifyController) testNotifyChannel(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.notifyChannelTested"), err)
+		return
+	}
+	err = a.notifyService.TestNotifyChannel(id, I18nWeb(c, "pages.settings.notifyTestMessage"))
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.notifyChannelTested"), err)
+}
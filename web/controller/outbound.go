@@ -0,0 +1,182 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OutboundController handles HTTP requests related to the panel-managed outbounds.
+type OutboundController struct {
+	outboundService service.OutboundService
+	warpService     service.WarpService
+	xrayService     service.XrayService
+}
+
+// NewOutboundController creates a new OutboundController and sets up its routes.
+func NewOutboundController(g *gin.RouterGroup) *OutboundController {
+	a := &OutboundController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for outbound operations.
+func (a *OutboundController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getOutbounds)
+	g.GET("/get/:id", a.getOutbound)
+	g.GET("/traffic", a.getOutboundsTraffic)
+	g.GET("/warp/:id", a.getWarpAccount)
+
+	g.POST("/add", a.addOutbound)
+	g.POST("/addWarp", a.addWarpOutbound)
+	g.POST("/warpLicense/:id", a.setWarpLicense)
+	g.POST("/update/:id", a.updateOutbound)
+	g.POST("/del/:id", a.delOutbound)
+	g.POST("/resetTraffic/:tag", a.resetOutboundTraffic)
+}
+
+// getOutbounds retrieves all panel-managed outbounds.
+func (a *OutboundController) getOutbounds(c *gin.Context) {
+	outbounds, err := a.outboundService.GetOutbounds()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, outbounds, nil)
+}
+
+// getOutbound retrieves a specific outbound by its ID.
+func (a *OutboundController) getOutbound(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "get"), err)
+		return
+	}
+	outbound, err := a.outboundService.GetOutbound(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, outbound, nil)
+}
+
+// addOutbound creates a new outbound.
+func (a *OutboundController) addOutbound(c *gin.Context) {
+	outbound := &model.Outbound{}
+	err := c.ShouldBind(outbound)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outbound.outboundSaved"), err)
+		return
+	}
+	outbound.Warp = false
+	err = a.outboundService.AddOutbound(outbound)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.outbound.outboundSaved"), outbound, nil)
+	a.xrayService.SetToNeedRestart()
+}
+
+// addWarpOutbound registers a new WARP device and creates a WireGuard outbound for it.
+func (a *OutboundController) addWarpOutbound(c *gin.Context) {
+	outbound, err := a.outboundService.AddWarpOutbound(c.PostForm("tag"), c.PostForm("remark"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.outbound.outboundSaved"), outbound, nil)
+	a.xrayService.SetToNeedRestart()
+}
+
+// getWarpAccount retrieves the WARP account of an outbound, refreshed from Cloudflare.
+func (a *OutboundController) getWarpAccount(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "get"), err)
+		return
+	}
+	account, err := a.warpService.GetWarpAccount(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, account, nil)
+}
+
+// setWarpLicense binds a WARP+ license key to the WARP account of an outbound.
+func (a *OutboundController) setWarpLicense(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outbound.outboundSaved"), err)
+		return
+	}
+	account, err := a.warpService.SetWarpAccountLicense(id, c.PostForm("license"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.outbound.outboundSaved"), account, nil)
+}
+
+// updateOutbound updates an outbound.
+func (a *OutboundController) updateOutbound(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outbound.outboundSaved"), err)
+		return
+	}
+	outbound := &model.Outbound{}
+	err = c.ShouldBind(outbound)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outbound.outboundSaved"), err)
+		return
+	}
+	outbound.Id = id
+	err = a.outboundService.UpdateOutbound(outbound)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.outbound.outboundSaved"), outbound, nil)
+	a.xrayService.SetToNeedRestart()
+}
+
+// delOutbound deletes an outbound that nothing refers to.
+func (a *OutboundController) delOutbound(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outbound.outboundDeleted"), err)
+		return
+	}
+	err = a.outboundService.DelOutbound(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.outbound.outboundDeleted"), id, nil)
+	a.xrayService.SetToNeedRestart()
+}
+
+// getOutboundsTraffic retrieves the uplink and downlink counted per outbound tag.
+func (a *OutboundController) getOutboundsTraffic(c *gin.Context) {
+	traffics, err := a.outboundService.GetOutboundsTraffic()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.getOutboundTrafficError"), err)
+		return
+	}
+	jsonObj(c, traffics, nil)
+}
+
+// resetOutboundTraffic resets the traffic of an outbound tag, or of all tags for "-alltags-".
+func (a *OutboundController) resetOutboundTraffic(c *gin.Context) {
+	err := a.outboundService.ResetOutboundTraffic(c.Param("tag"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.resetOutboundTrafficError"), err)
+		return
+	}
+	jsonObj(c, "", nil)
+}
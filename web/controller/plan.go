@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PlanController handles HTTP requests related to client plans.
+type PlanController struct {
+	planService service.PlanService
+}
+
+// NewPlanController creates a new PlanController and sets up its routes.
+func NewPlanController(g *gin.RouterGroup) *PlanController {
+	a := &PlanController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for client plan operations.
+func (a *PlanController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getPlans)
+	g.GET("/get/:id", a.getPlan)
+
+	g.POST("/add", a.addPlan)
+	g.POST("/update/:id", a.updatePlan)
+	g.POST("/del/:id", a.delPlan)
+}
+
+// getPlans retrieves all client plans.
+func (a *PlanController) getPlans(c *gin.Context) {
+	plans, err := a.planService.GetPlans()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, plans, nil)
+}
+
+// getPlan retrieves a specific client plan by its ID.
+func (a *PlanController) getPlan(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "get"), err)
+		return
+	}
+	plan, err := a.planService.GetPlan(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, plan, nil)
+}
+
+// addPlan creates a new client plan.
+func (a *PlanController) addPlan(c *gin.Context) {
+	plan := &model.ClientPlan{}
+	err := c.ShouldBind(plan)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.planCreateSuccess"), err)
+		return
+	}
+	err = a.planService.AddPlan(plan)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.planCreateSuccess"), plan, nil)
+}
+
+// updatePlan updates a client plan. The cascade field applies the changes to the clients on the plan.
+func (a *PlanController) updatePlan(c *gin.Context) {
+	type UpdatePlanRequest struct {
+		model.ClientPlan
+		Cascade bool `json:"cascade" form:"cascade"`
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.planUpdateSuccess"), err)
+		return
+	}
+	var request UpdatePlanRequest
+	err = c.ShouldBind(&request)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.planUpdateSuccess"), err)
+		return
+	}
+	plan := &request.ClientPlan
+	plan.Id = id
+	err = a.planService.UpdatePlan(plan, request.Cascade)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.planUpdateSuccess"), plan, nil)
+}
+
+// delPlan deletes a client plan that is not assigned to any client.
+func (a *PlanController) delPlan(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.planDeleteSuccess"), err)
+		return
+	}
+	err = a.planService.DelPlan(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.planDeleteSuccess"), id, nil)
+}
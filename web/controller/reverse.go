@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReverseController handles HTTP requests related to the managed Xray reverse proxies.
+type ReverseController struct {
+	reverseService service.ReverseService
+	xrayService    service.XrayService
+}
+
+// NewReverseController creates a new ReverseController and sets up its routes.
+func NewReverseController(g *gin.RouterGroup) *ReverseController {
+	a := &ReverseController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for reverse proxy operations.
+func (a *ReverseController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getReverses)
+	g.GET("/get/:id", a.getReverse)
+
+	g.POST("/add", a.addReverse)
+	g.POST("/update/:id", a.updateReverse)
+	g.POST("/del/:id", a.delReverse)
+}
+
+// getReverses retrieves all reverse proxies.
+func (a *ReverseController) getReverses(c *gin.Context) {
+	reverses, err := a.reverseService.GetReverses()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, reverses, nil)
+}
+
+// getReverse retrieves a specific reverse proxy by its ID.
+func (a *ReverseController) getReverse(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "get"), err)
+		return
+	}
+	reverse, err := a.reverseService.GetReverse(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, reverse, nil)
+}
+
+// addReverse creates a new reverse proxy.
+func (a *ReverseController) addReverse(c *gin.Context) {
+	reverse := &model.ReverseProxy{}
+	err := c.ShouldBind(reverse)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outbound.reverseSaved"), err)
+		return
+	}
+	err = a.reverseService.AddReverse(reverse)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.outbound.reverseSaved"), reverse, nil)
+	a.xrayService.SetToNeedRestart()
+}
+
+// updateReverse updates a reverse proxy.
+func (a *ReverseController) updateReverse(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outbound.reverseSaved"), err)
+		return
+	}
+	reverse := &model.ReverseProxy{}
+	err = c.ShouldBind(reverse)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outbound.reverseSaved"), err)
+		return
+	}
+	reverse.Id = id
+	err = a.reverseService.UpdateReverse(reverse)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.outbound.reverseSaved"), reverse, nil)
+	a.xrayService.SetToNeedRestart()
+}
+
+// delReverse deletes a reverse proxy.
+func (a *ReverseController) delReverse(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outbound.reverseDeleted"), err)
+		return
+	}
+	err = a.reverseService.DelReverse(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.outbound.reverseDeleted"), id, nil)
+	a.xrayService.SetToNeedRestart()
+}
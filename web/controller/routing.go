@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RoutingController handles HTTP requests related to the managed Xray routing rules.
+type RoutingController struct {
+	routingService service.RoutingService
+	xrayService    service.XrayService
+}
+
+// NewRoutingController creates a new RoutingController and sets up its routes.
+func NewRoutingController(g *gin.RouterGroup) *RoutingController {
+	a := &RoutingController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for routing rule operations.
+func (a *RoutingController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getRules)
+	g.GET("/get/:id", a.getRule)
+
+	g.POST("/add", a.addRule)
+	g.POST("/update/:id", a.updateRule)
+	g.POST("/del/:id", a.delRule)
+	g.POST("/enable/:id", a.setRuleEnable)
+	g.POST("/order", a.reorderRules)
+}
+
+// getRules retrieves all routing rules in the order they are applied.
+func (a *RoutingController) getRules(c *gin.Context) {
+	rules, err := a.routingService.GetRules()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, rules, nil)
+}
+
+// getRule retrieves a specific routing rule by its ID.
+func (a *RoutingController) getRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "get"), err)
+		return
+	}
+	rule, err := a.routingService.GetRule(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, rule, nil)
+}
+
+// addRule creates a new routing rule.
+func (a *RoutingController) addRule(c *gin.Context) {
+	rule := &model.RoutingRule{}
+	err := c.ShouldBind(rule)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.rules.ruleSaved"), err)
+		return
+	}
+	err = a.routingService.AddRule(rule)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.rules.ruleSaved"), rule, nil)
+	a.xrayService.SetToNeedRestart()
+}
+
+// updateRule updates a routing rule.
+func (a *RoutingController) updateRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.rules.ruleSaved"), err)
+		return
+	}
+	rule := &model.RoutingRule{}
+	err = c.ShouldBind(rule)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.rules.ruleSaved"), err)
+		return
+	}
+	rule.Id = id
+	err = a.routingService.UpdateRule(rule)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.rules.ruleSaved"), rule, nil)
+	a.xrayService.SetToNeedRestart()
+}
+
+// delRule deletes a routing rule.
+func (a *RoutingController) delRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.rules.ruleDeleted"), err)
+		return
+	}
+	err = a.routingService.DelRule(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.rules.ruleDeleted"), id, nil)
+	a.xrayService.SetToNeedRestart()
+}
+
+// setRuleEnable enables or disables a routing rule from the enable form value.
+func (a *RoutingController) setRuleEnable(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.rules.ruleSaved"), err)
+		return
+	}
+	enable, err := strconv.ParseBool(c.PostForm("enable"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.rules.ruleSaved"), err)
+		return
+	}
+	err = a.routingService.SetRuleEnable(id, enable)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsg(c, I18nWeb(c, "pages.xray.rules.ruleSaved"), nil)
+	a.xrayService.SetToNeedRestart()
+}
+
+// reorderRules applies the rule order given as a JSON array of rule IDs in the ids form value.
+func (a *RoutingController) reorderRules(c *gin.Context) {
+	var ids []int
+	err := json.Unmarshal([]byte(c.PostForm("ids")), &ids)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.rules.ruleSaved"), err)
+		return
+	}
+	err = a.routingService.ReorderRules(ids)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsg(c, I18nWeb(c, "pages.xray.rules.ruleSaved"), nil)
+	a.xrayService.SetToNeedRestart()
+}
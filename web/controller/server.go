@@ -1,26 +1,40 @@
 package controller
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"regexp"
 	"strconv"
 	"time"
 
+	"github.com/mhsanaei/3x-ui/v2/logger"
 	"github.com/mhsanaei/3x-ui/v2/web/global"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 )
 
 var filenameRegex = regexp.MustCompile(`^[a-zA-Z0-9_\-.]+$`)
 
+// logStreamUpgrader upgrades log stream requests. The default origin check only accepts the panel itself.
+var logStreamUpgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 4096}
+
 // ServerController handles server management and status-related operations.
 type ServerController struct {
 	BaseController
 
-	serverService  service.ServerService
-	settingService service.SettingService
+	serverService    service.ServerService
+	settingService   service.SettingService
+	xrayService      service.XrayService
+	speedtestService service.SpeedtestService
+	reportService    service.ReportService
+	mailService      service.MailService
+	logStreamService service.LogStreamService
+	logLevelService  service.LogLevelService
+	tgbotService     service.Tgbot
+	panelService     service.PanelService
 
 	lastStatus *service.Status
 
@@ -41,7 +55,19 @@ func (a *ServerController) initRouter(g *gin.RouterGroup) {
 
 	g.GET("/status", a.status)
 	g.GET("/cpuHistory/:bucket", a.getCpuHistoryBucket)
+	g.GET("/statusHistory", a.getStatusHistory)
 	g.GET("/getXrayVersion", a.getXrayVersion)
+	g.GET("/getInstalledXrayVersions", a.getInstalledXrayVersions)
+	g.GET("/getGeofileVersions", a.getGeofileVersions)
+	g.GET("/getPanelUpdate", a.getPanelUpdate)
+	g.GET("/crashEvents", a.getCrashEvents)
+	g.GET("/jobs", a.getJobs)
+	g.GET("/coreStatus", a.getCoreStatus)
+	g.GET("/speedtest", a.getSpeedtestResults)
+	g.GET("/logs/stream", a.streamLogs)
+	g.GET("/logLevels", a.getLogLevels)
+	g.GET("/usageReport/:period", a.getUsageReport)
+	g.GET("/botAudit", a.getBotAuditLogs)
 	g.GET("/getConfigJson", a.getConfigJson)
 	g.GET("/getDb", a.getDb)
 	g.GET("/getNewUUID", a.getNewUUID)
@@ -52,11 +78,21 @@ func (a *ServerController) initRouter(g *gin.RouterGroup) {
 
 	g.POST("/stopXrayService", a.stopXrayService)
 	g.POST("/restartXrayService", a.restartXrayService)
+	g.POST("/testFragment", a.testFragment)
+	g.POST("/speedtest", a.runSpeedtest)
+	g.POST("/usageReport/:period", a.sendUsageReport)
+	g.POST("/testMail", a.testMail)
 	g.POST("/installXray/:version", a.installXray)
+	g.POST("/downloadXray/:version", a.downloadXray)
+	g.POST("/switchXray/:version", a.switchXray)
+	g.POST("/rollbackXray", a.rollbackXray)
+	g.POST("/deleteXray/:version", a.deleteXray)
+	g.POST("/updatePanel/:version", a.updatePanel)
 	g.POST("/updateGeofile", a.updateGeofile)
 	g.POST("/updateGeofile/:fileName", a.updateGeofile)
 	g.POST("/logs/:count", a.getLogs)
 	g.POST("/xraylogs/:count", a.getXrayLogs)
+	g.POST("/logLevel", a.setLogLevel)
 	g.POST("/importDB", a.importDB)
 	g.POST("/getNewEchCert", a.getNewEchCert)
 }
@@ -73,12 +109,27 @@ func (a *ServerController) refreshStatus() {
 // startTask initiates background tasks for continuous status monitoring.
 func (a *ServerController) startTask() {
 	webServer := global.GetWebServer()
-	c := webServer.GetCron()
-	c.AddFunc("@every 2s", func() {
+	c := webServer.GetScheduler()
+	c.AddFunc("serverStatus", "@every 2s", func() {
 		// Always refresh to keep CPU history collected continuously.
 		// Sampling is lightweight and capped to ~6 hours in memory.
 		a.refreshStatus()
 	})
+
+	interval, err := a.settingService.GetServerHistoryInterval()
+	if err != nil || interval <= 0 {
+		return
+	}
+	c.AddFunc("serverHistory", fmt.Sprintf("@every %ds", interval), func() {
+		if err := a.serverService.AddStatusSample(a.lastStatus); err != nil {
+			logger.Warning("store server status sample failed:", err)
+		}
+	})
+	c.AddFunc("serverHistoryPrune", "@hourly", func() {
+		if err := a.serverService.PruneStatusSamples(); err != nil {
+			logger.Warning("prune server status samples failed:", err)
+		}
+	})
 }
 
 // status returns the current server status information.
@@ -108,6 +159,20 @@ func (a *ServerController) getCpuHistoryBucket(c *gin.Context) {
 	jsonObj(c, points, nil)
 }
 
+// getStatusHistory returns the stored server status samples between the from and to Unix seconds,
+// averaged per bucket seconds when bucket is given.
+func (a *ServerController) getStatusHistory(c *gin.Context) {
+	from, _ := strconv.ParseInt(c.Query("from"), 10, 64)
+	to, _ := strconv.ParseInt(c.Query("to"), 10, 64)
+	bucket, _ := strconv.ParseInt(c.Query("bucket"), 10, 64)
+	samples, err := a.serverService.GetStatusHistory(from, to, bucket)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, samples, nil)
+}
+
 // getXrayVersion retrieves available Xray versions, with caching for 1 minute.
 func (a *ServerController) getXrayVersion(c *gin.Context) {
 	now := time.Now().Unix()
@@ -135,6 +200,56 @@ func (a *ServerController) installXray(c *gin.Context) {
 	jsonMsg(c, I18nWeb(c, "pages.index.xraySwitchVersionPopover"), err)
 }
 
+// getInstalledXrayVersions lists the Xray versions downloaded into the bin folder.
+func (a *ServerController) getInstalledXrayVersions(c *gin.Context) {
+	versions, err := a.serverService.GetInstalledXrayVersions()
+	jsonObj(c, versions, err)
+}
+
+// downloadXray downloads and verifies an Xray version without switching to it.
+func (a *ServerController) downloadXray(c *gin.Context) {
+	version := c.Param("version")
+	err := a.serverService.InstallXrayVersion(version)
+	jsonMsg(c, I18nWeb(c, "pages.index.xrayDownloaded"), err)
+}
+
+// switchXray switches the active Xray binary to a downloaded version.
+func (a *ServerController) switchXray(c *gin.Context) {
+	version := c.Param("version")
+	err := a.serverService.SwitchXray(version)
+	jsonMsg(c, I18nWeb(c, "pages.index.xraySwitchVersionPopover"), err)
+}
+
+// rollbackXray switches back to the Xray binary that was active before the last switch.
+func (a *ServerController) rollbackXray(c *gin.Context) {
+	err := a.serverService.RollbackXray()
+	jsonMsg(c, I18nWeb(c, "pages.index.xrayRolledBack"), err)
+}
+
+// deleteXray removes a downloaded Xray version from the bin folder.
+func (a *ServerController) deleteXray(c *gin.Context) {
+	version := c.Param("version")
+	err := a.serverService.DeleteXrayVersion(version)
+	jsonMsg(c, I18nWeb(c, "delete"), err)
+}
+
+// getPanelUpdate returns the running version of the panel and its latest release.
+func (a *ServerController) getPanelUpdate(c *gin.Context) {
+	update, err := a.panelService.CheckPanelUpdate()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "getVersion"), err)
+		return
+	}
+	jsonObj(c, update, nil)
+}
+
+// updatePanel installs a release of the panel and restarts the panel to run it.
+func (a *ServerController) updatePanel(c *gin.Context) {
+	version := c.Param("version")
+	err := a.panelService.UpdatePanel(version)
+	jsonMsg(c, I18nWeb(c, "pages.index.panelUpdateInstalled"), err)
+}
+
 // updateGeofile updates the specified geo file for Xray.
 func (a *ServerController) updateGeofile(c *gin.Context) {
 	fileName := c.Param("fileName")
@@ -150,6 +265,22 @@ func (a *ServerController) updateGeofile(c *gin.Context) {
 	jsonMsg(c, I18nWeb(c, "pages.index.geofileUpdatePopover"), err)
 }
 
+// getGeofileVersions reports the installed versions of the geo databases.
+func (a *ServerController) getGeofileVersions(c *gin.Context) {
+	jsonObj(c, a.serverService.GetGeofileVersions(), nil)
+}
+
+// getCrashEvents returns the recorded crashes of the core, newest first.
+func (a *ServerController) getCrashEvents(c *gin.Context) {
+	events, err := a.xrayService.GetCrashEvents(50)
+	jsonObj(c, events, err)
+}
+
+// getJobs returns the state of the background jobs and the outcome and duration of their last run.
+func (a *ServerController) getJobs(c *gin.Context) {
+	jsonObj(c, global.GetWebServer().GetScheduler().Status(), nil)
+}
+
 // stopXrayService stops the Xray service.
 func (a *ServerController) stopXrayService(c *gin.Context) {
 	err := a.serverService.StopXrayService()
@@ -170,6 +301,76 @@ func (a *ServerController) restartXrayService(c *gin.Context) {
 	jsonMsg(c, I18nWeb(c, "pages.xray.restartSuccess"), err)
 }
 
+// getCoreStatus retrieves the runtime stats, handlers and observatory results of the running core.
+func (a *ServerController) getCoreStatus(c *gin.Context) {
+	status, err := a.xrayService.GetCoreStatus()
+	jsonObj(c, status, err)
+}
+
+// getSpeedtestResults returns the recent speedtest results, newest first. The limit query defaults to 100.
+func (a *ServerController) getSpeedtestResults(c *gin.Context) {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit < 1 || limit > 1000 {
+		limit = 100
+	}
+	results, err := a.speedtestService.GetSpeedtestResults(limit)
+	jsonObj(c, results, err)
+}
+
+// runSpeedtest runs a speedtest of the configured targets now and returns its results.
+func (a *ServerController) runSpeedtest(c *gin.Context) {
+	results, err := a.speedtestService.RunSpeedtest()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.speedtestError"), err)
+		return
+	}
+	jsonObj(c, results, nil)
+}
+
+// getBotAuditLogs returns the recent commands of the Telegram bot admins and support agents, newest first.
+// The tgId query selects one user and the limit query defaults to 100.
+func (a *ServerController) getBotAuditLogs(c *gin.Context) {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit < 1 || limit > 1000 {
+		limit = 100
+	}
+	tgId, _ := strconv.ParseInt(c.Query("tgId"), 10, 64)
+	entries, err := a.tgbotService.GetBotAuditLogs(tgId, limit)
+	jsonObj(c, entries, err)
+}
+
+// getUsageReport returns the daily or weekly usage report of the last complete period.
+func (a *ServerController) getUsageReport(c *gin.Context) {
+	report, err := a.reportService.BuildUsageReport(c.Param("period"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, report, nil)
+}
+
+// sendUsageReport sends the daily or weekly usage report now, to check the delivery settings.
+func (a *ServerController) sendUsageReport(c *gin.Context) {
+	err := a.reportService.SendUsageReport(c.Param("period"))
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.usageReportSent"), err)
+}
+
+// testMail sends a test email with the saved SMTP settings.
+func (a *ServerController) testMail(c *gin.Context) {
+	err := a.mailService.SendTestMail(I18nWeb(c, "pages.settings.notifyTestMessage"))
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.testMailSent"), err)
+}
+
+// testFragment restarts Xray with the fragment settings and checks that a request through it succeeds.
+func (a *ServerController) testFragment(c *gin.Context) {
+	result, err := a.xrayService.TestFragment()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.fragmentTestError"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.toasts.fragmentTest", "Status=="+strconv.Itoa(result.StatusCode), "Delay=="+strconv.FormatInt(result.Delay, 10)), result, nil)
+}
+
 // getLogs retrieves the application logs based on count, level, and syslog filters.
 func (a *ServerController) getLogs(c *gin.Context) {
 	count := c.Param("count")
@@ -179,6 +380,70 @@ func (a *ServerController) getLogs(c *gin.Context) {
 	jsonObj(c, logs, nil)
 }
 
+// getLogLevels retrieves the console log level of the panel and of each of its modules.
+func (a *ServerController) getLogLevels(c *gin.Context) {
+	jsonObj(c, a.logLevelService.GetLogLevels(), nil)
+}
+
+// setLogLevel sets the console log level of a module, or resets it when the level is empty.
+func (a *ServerController) setLogLevel(c *gin.Context) {
+	err := a.logLevelService.SetLogLevel(c.PostForm("module"), c.PostForm("level"))
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), a.logLevelService.GetLogLevels(), err)
+}
+
+// streamLogs upgrades the request to a WebSocket and sends new log lines as JSON messages
+// while it is open. The source query selects the panel, xray or access log, level the least
+// severe level sent and filter a substring the lines must contain.
+func (a *ServerController) streamLogs(c *gin.Context) {
+	filter := service.LogFilter{
+		Source: c.DefaultQuery("source", service.LogSourcePanel),
+		Level:  c.Query("level"),
+		Search: c.Query("filter"),
+	}
+	conn, err := logStreamUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader already answered the request
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	// Nothing is expected from the client, reading only notices when it goes away
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	err = a.logStreamService.StreamLogs(ctx, filter, func(line *service.LogLine) error {
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return conn.WriteJSON(line)
+	})
+	if err != nil {
+		msg := websocket.FormatCloseMessage(websocket.CloseInternalServerErr, err.Error())
+		conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	}
+}
+
 // getXrayLogs retrieves Xray logs with filtering options for direct, blocked, and proxy traffic.
 func (a *ServerController) getXrayLogs(c *gin.Context) {
 	count := c.Param("count")
@@ -236,11 +501,12 @@ func (a *ServerController) getConfigJson(c *gin.Context) {
 
 // getDb downloads the database file.
 func (a *ServerController) getDb(c *gin.Context) {
-	db, err := a.serverService.GetDb()
+	db, size, err := a.serverService.OpenDb()
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "pages.index.getDatabaseError"), err)
 		return
 	}
+	defer db.Close()
 
 	filename := "x-ui.db"
 
@@ -249,12 +515,10 @@ func (a *ServerController) getDb(c *gin.Context) {
 		return
 	}
 
-	// Set the headers for the response
-	c.Header("Content-Type", "application/octet-stream")
-	c.Header("Content-Disposition", "attachment; filename="+filename)
-
-	// Write the file contents to the response
-	c.Writer.Write(db)
+	// Stream the file contents to the response
+	c.DataFromReader(http.StatusOK, size, "application/octet-stream", db, map[string]string{
+		"Content-Disposition": "attachment; filename=" + filename,
+	})
 }
 
 func isValidFilename(filename string) bool {
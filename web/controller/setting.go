@@ -1,11 +1,14 @@
 package controller
 
 import (
+	"encoding/json"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/mhsanaei/3x-ui/v2/util/crypto"
 	"github.com/mhsanaei/3x-ui/v2/web/entity"
+	"github.com/mhsanaei/3x-ui/v2/web/middleware"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
 	"github.com/mhsanaei/3x-ui/v2/web/session"
 
@@ -22,9 +25,11 @@ type updateUserForm struct {
 
 // SettingController handles settings and user management operations.
 type SettingController struct {
-	settingService service.SettingService
-	userService    service.UserService
-	panelService   service.PanelService
+	settingService         service.SettingService
+	userService            service.UserService
+	panelService           service.PanelService
+	messageTemplateService service.MessageTemplateService
+	notifyService          service.NotifyService
 }
 
 // NewSettingController creates a new SettingController and initializes its routes.
@@ -43,7 +48,12 @@ func (a *SettingController) initRouter(g *gin.RouterGroup) {
 	g.POST("/update", a.updateSetting)
 	g.POST("/updateUser", a.updateUser)
 	g.POST("/restartPanel", a.restartPanel)
+	g.POST("/selfSignedCert", a.generateSelfSignedCert)
 	g.GET("/getDefaultJsonConfig", a.getDefaultXrayConfig)
+	g.POST("/messageTemplates", a.getMessageTemplates)
+	g.POST("/messageTemplates/update", a.updateMessageTemplate)
+	g.POST("/notifyMatrix", a.getNotifyMatrix)
+	g.POST("/notifyMatrix/update", a.updateNotifyMatrix)
 }
 
 // getAllSetting retrieves all current settings.
@@ -58,7 +68,7 @@ func (a *SettingController) getAllSetting(c *gin.Context) {
 
 // getDefaultSettings retrieves the default settings based on the host.
 func (a *SettingController) getDefaultSettings(c *gin.Context) {
-	result, err := a.settingService.GetDefaultSettings(c.Request.Host)
+	result, err := a.settingService.GetDefaultSettings(middleware.RequestHost(c))
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.getSettings"), err)
 		return
@@ -110,6 +120,17 @@ func (a *SettingController) restartPanel(c *gin.Context) {
 	jsonMsg(c, I18nWeb(c, "pages.settings.restartPanelSuccess"), err)
 }
 
+// generateSelfSignedCert generates a certificate for the comma separated hosts form value, issued
+// by the local CA when the ca form value is true, and sets it as the certificate of the panel.
+func (a *SettingController) generateSelfSignedCert(c *gin.Context) {
+	cert, err := a.settingService.GenerateSelfSignedCert(strings.Split(c.PostForm("hosts"), ","), c.PostForm("ca") == "true")
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.selfSignedCert"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.settings.toasts.selfSignedCert"), cert, nil)
+}
+
 // getDefaultXrayConfig retrieves the default Xray configuration.
 func (a *SettingController) getDefaultXrayConfig(c *gin.Context) {
 	defaultJsonConfig, err := a.settingService.GetDefaultXrayConfig()
@@ -119,3 +140,40 @@ func (a *SettingController) getDefaultXrayConfig(c *gin.Context) {
 	}
 	jsonObj(c, defaultJsonConfig, nil)
 }
+
+// getMessageTemplates retrieves the notification messages of the lang form value and their customizations.
+func (a *SettingController) getMessageTemplates(c *gin.Context) {
+	templates, err := a.messageTemplateService.GetMessageTemplates(c.PostForm("lang"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.getSettings"), err)
+		return
+	}
+	jsonObj(c, templates, nil)
+}
+
+// updateMessageTemplate customizes a notification message, an empty template restores the translated one.
+func (a *SettingController) updateMessageTemplate(c *gin.Context) {
+	err := a.messageTemplateService.UpdateMessageTemplate(c.PostForm("lang"), c.PostForm("key"), c.PostForm("template"))
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+// getNotifyMatrix retrieves the notification events routed to every destination.
+func (a *SettingController) getNotifyMatrix(c *gin.Context) {
+	matrix, err := a.notifyService.GetNotifyMatrix()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.getSettings"), err)
+		return
+	}
+	jsonObj(c, matrix, nil)
+}
+
+// updateNotifyMatrix saves the notification events of the destinations in the routes form
+// value, a JSON object of the events by destination key.
+func (a *SettingController) updateNotifyMatrix(c *gin.Context) {
+	routes := make(map[string][]string)
+	err := json.Unmarshal([]byte(c.PostForm("routes")), &routes)
+	if err == nil {
+		err = a.notifyService.UpdateNotifyMatrix(routes)
+	}
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
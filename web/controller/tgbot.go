@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mymmrac/telego"
+)
+
+// tgbotWebhookMaxBody limits the size of an update posted to the webhook endpoint.
+const tgbotWebhookMaxBody = 1 << 20
+
+// TgbotController receives the updates of the Telegram bot in webhook mode.
+type TgbotController struct {
+	tgbotService service.Tgbot
+}
+
+// NewTgbotController creates a new TgbotController and sets up its routes.
+func NewTgbotController(g *gin.RouterGroup) *TgbotController {
+	a := &TgbotController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the route of the webhook endpoint, authenticated with the webhook secret.
+func (a *TgbotController) initRouter(g *gin.RouterGroup) {
+	g.POST("/"+service.TgBotWebhookPath+":secret", a.webhook)
+}
+
+// webhook passes an update from Telegram to the bot. Like the metrics endpoint, it answers 404
+// if webhook mode is off or a secret is wrong, to hide its existence.
+func (a *TgbotController) webhook(c *gin.Context) {
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, tgbotWebhookMaxBody))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	ok, err := a.tgbotService.HandleWebhook(c.Request.Context(), c.Param("secret"), c.GetHeader(telego.WebhookSecretTokenHeader), data)
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		requestLogger(c).Warning("Telegram bot webhook update failed:", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.Status(http.StatusOK)
+}
@@ -1,31 +1,21 @@
 package controller
 
 import (
+	"encoding/json"
 	"net"
 	"net/http"
-	"strings"
 
 	"github.com/mhsanaei/3x-ui/v2/config"
 	"github.com/mhsanaei/3x-ui/v2/logger"
 	"github.com/mhsanaei/3x-ui/v2/web/entity"
+	"github.com/mhsanaei/3x-ui/v2/web/middleware"
 
 	"github.com/gin-gonic/gin"
 )
 
-// getRemoteIp extracts the real IP address from the request headers or remote address.
-func getRemoteIp(c *gin.Context) string {
-	value := c.GetHeader("X-Real-IP")
-	if value != "" {
-		return value
-	}
-	value = c.GetHeader("X-Forwarded-For")
-	if value != "" {
-		ips := strings.Split(value, ",")
-		return ips[0]
-	}
-	addr := c.Request.RemoteAddr
-	ip, _, _ := net.SplitHostPort(addr)
-	return ip
+// requestLogger returns a logger entry adding the ID of the request to the messages.
+func requestLogger(c *gin.Context) *logger.Entry {
+	return logger.WithField(logger.RequestIDField, c.GetString(logger.RequestIDField))
 }
 
 // jsonMsg sends a JSON response with a message and error status.
@@ -51,11 +41,46 @@ func jsonMsgObj(c *gin.Context, msg string, obj any, err error) {
 	} else {
 		m.Success = false
 		m.Msg = msg + " (" + err.Error() + ")"
-		logger.Warning(msg+" "+I18nWeb(c, "fail")+": ", err)
+		requestLogger(c).Warning(msg+" "+I18nWeb(c, "fail")+": ", err)
 	}
 	c.JSON(http.StatusOK, m)
 }
 
+// streamFlushItems is how many items of a streamed list are written between two flushes.
+const streamFlushItems = 500
+
+// jsonList sends a successful JSON response with a list like jsonObj does, encoding and
+// flushing its items a few at a time with chunked transfer instead of building the whole
+// response in memory first.
+func jsonList[T any](c *gin.Context, items []T) {
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Status(http.StatusOK)
+	if items == nil {
+		c.Writer.WriteString(`{"success":true,"msg":"","obj":null}`)
+		return
+	}
+	c.Writer.WriteString(`{"success":true,"msg":"","obj":[`)
+	for i, item := range items {
+		data, err := json.Marshal(item)
+		if err != nil {
+			// The status is sent already, the truncated response tells the client it failed
+			requestLogger(c).Warning("Unable to encode the list of the response:", err)
+			c.Abort()
+			return
+		}
+		if i > 0 {
+			c.Writer.WriteString(",")
+		}
+		if _, err := c.Writer.Write(data); err != nil {
+			return
+		}
+		if (i+1)%streamFlushItems == 0 {
+			c.Writer.Flush()
+		}
+	}
+	c.Writer.WriteString("]}")
+}
+
 // pureJsonMsg sends a pure JSON message response with custom status code.
 func pureJsonMsg(c *gin.Context, statusCode int, success bool, msg string) {
 	c.JSON(statusCode, entity.Msg{
@@ -70,16 +95,9 @@ func html(c *gin.Context, name string, title string, data gin.H) {
 		data = gin.H{}
 	}
 	data["title"] = title
-	host := c.GetHeader("X-Forwarded-Host")
-	if host == "" {
-		host = c.GetHeader("X-Real-IP")
-	}
-	if host == "" {
-		var err error
-		host, _, err = net.SplitHostPort(c.Request.Host)
-		if err != nil {
-			host = c.Request.Host
-		}
+	host, _, err := net.SplitHostPort(middleware.RequestHost(c))
+	if err != nil {
+		host = middleware.RequestHost(c)
 	}
 	data["host"] = host
 	data["request_uri"] = c.Request.RequestURI
@@ -0,0 +1,113 @@
+package web
+
+import (
+	"context"
+	"crypto/subtle"
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// startedAt is when the panel process started, for the uptime of the runtime stats.
+var startedAt = time.Now()
+
+func init() {
+	// The memory stats and the command line are published by expvar itself
+	expvar.Publish("goroutines", expvar.Func(func() any {
+		return runtime.NumGoroutine()
+	}))
+	expvar.Publish("uptime", expvar.Func(func() any {
+		return int64(time.Since(startedAt).Seconds())
+	}))
+	expvar.Publish("xrayRunning", expvar.Func(func() any {
+		return new(service.XrayService).IsXrayRunning()
+	}))
+	expvar.Publish("db", expvar.Func(func() any {
+		db := database.GetDB()
+		if db == nil {
+			return nil
+		}
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil
+		}
+		return sqlDB.Stats()
+	}))
+}
+
+// startDiagnostics serves the profiles of net/http/pprof under /debug/pprof/ and the runtime
+// stats of expvar under /debug/vars on the diagnostics address, if one is set. It is separate
+// from the panel, so profiling never depends on the state of the panel server.
+func (s *Server) startDiagnostics() {
+	listen, err := s.settingService.GetDiagnosticsListen()
+	if err != nil || listen == "" {
+		return
+	}
+	token, err := s.settingService.GetDiagnosticsToken()
+	if err != nil {
+		logger.Warning("Unable to read the diagnostics token:", err)
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		logger.Errorf("Unable to listen for diagnostics on %s: %v", listen, err)
+		return
+	}
+	s.diagnosticsServer = &http.Server{
+		Handler:           diagnosticsAuth(token, mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go s.diagnosticsServer.Serve(listener)
+	logger.Infof("Diagnostics running on %s", listener.Addr())
+}
+
+// stopDiagnostics stops serving the diagnostics.
+func (s *Server) stopDiagnostics() error {
+	if s.diagnosticsServer == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return s.diagnosticsServer.Shutdown(ctx)
+}
+
+// diagnosticsAuth lets the requests carrying the token through, as a bearer Authorization
+// header, the password of basic authentication or the token query parameter. Without a token,
+// which is only allowed on loopback addresses, every request is let through. Other requests
+// are answered 404, to hide the endpoints.
+func diagnosticsAuth(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" {
+			given, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !found {
+				if _, password, ok := r.BasicAuth(); ok {
+					given = password
+				} else {
+					given = r.URL.Query().Get("token")
+				}
+			}
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -3,14 +3,27 @@ package entity
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"math"
 	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/tmpl"
+	"github.com/mhsanaei/3x-ui/v2/web/extension"
+	"github.com/mhsanaei/3x-ui/v2/web/network"
 )
 
+// fragmentRangeRegex matches a fragment option given as a number or a range of numbers.
+var fragmentRangeRegex = regexp.MustCompile(`^\d+(-\d+)?$`)
+
 // Msg represents a standard API response message with success status, message text, and optional data object.
 type Msg struct {
 	Success bool   `json:"success"` // Indicates if the operation was successful
@@ -21,13 +34,24 @@ type Msg struct {
 // AllSetting contains all configuration settings for the 3x-ui panel including web server, Telegram bot, and subscription settings.
 type AllSetting struct {
 	// Web server settings
-	WebListen     string `json:"webListen" form:"webListen"`         // Web server listen IP address
-	WebDomain     string `json:"webDomain" form:"webDomain"`         // Web server domain for domain validation
-	WebPort       int    `json:"webPort" form:"webPort"`             // Web server port number
-	WebCertFile   string `json:"webCertFile" form:"webCertFile"`     // Path to SSL certificate file for web server
-	WebKeyFile    string `json:"webKeyFile" form:"webKeyFile"`       // Path to SSL private key file for web server
-	WebBasePath   string `json:"webBasePath" form:"webBasePath"`     // Base path for web panel URLs
-	SessionMaxAge int    `json:"sessionMaxAge" form:"sessionMaxAge"` // Session maximum age in minutes
+	WebListen          string `json:"webListen" form:"webListen"`                   // Web server listen IP address
+	WebDomain          string `json:"webDomain" form:"webDomain"`                   // Web server domain for domain validation
+	WebPort            int    `json:"webPort" form:"webPort"`                       // Web server port number
+	WebListeners       string `json:"webListeners" form:"webListeners"`             // Lines of "address:port [none | certFile keyFile]" the web server also listens on
+	TrustedProxies     string `json:"trustedProxies" form:"trustedProxies"`         // Comma-separated IPs and CIDRs of the reverse proxies whose forwarding headers are trusted
+	WebCertFile        string `json:"webCertFile" form:"webCertFile"`               // Path to SSL certificate file for web server
+	WebKeyFile         string `json:"webKeyFile" form:"webKeyFile"`                 // Path to SSL private key file for web server
+	AcmeEnable         bool   `json:"acmeEnable" form:"acmeEnable"`                 // Obtain certificates for the panel and sub domains through ACME
+	AcmeEmail          string `json:"acmeEmail" form:"acmeEmail"`                   // Contact email of the ACME account
+	AcmeDirectory      string `json:"acmeDirectory" form:"acmeDirectory"`           // Directory URL of the ACME CA
+	AcmeChallenge      string `json:"acmeChallenge" form:"acmeChallenge"`           // "http" for HTTP-01 and TLS-ALPN-01, or "dns" for DNS-01
+	AcmeDnsProvider    string `json:"acmeDnsProvider" form:"acmeDnsProvider"`       // DNS provider setting the TXT records of DNS-01 challenges
+	AcmeDnsCredentials string `json:"acmeDnsCredentials" form:"acmeDnsCredentials"` // JSON object of the API credentials of the DNS provider
+	AcmeWildcard       bool   `json:"acmeWildcard" form:"acmeWildcard"`             // Add the wildcard of the parent domain with DNS-01
+	CertExpiryDays     string `json:"certExpiryDays" form:"certExpiryDays"`         // Comma-separated days before a certificate expires to alert at
+	WebBasePath        string `json:"webBasePath" form:"webBasePath"`               // Base path for web panel URLs
+	DecoySite          string `json:"decoySite" form:"decoySite"`                   // Folder or http(s) URL of the website served for the paths of neither the panel nor the subscription
+	SessionMaxAge      int    `json:"sessionMaxAge" form:"sessionMaxAge"`           // Session maximum age in minutes
 
 	// UI settings
 	PageSize    int    `json:"pageSize" form:"pageSize"`       // Number of items per page in lists
@@ -37,28 +61,52 @@ type AllSetting struct {
 	Datepicker  string `json:"datepicker" form:"datepicker"`   // Date picker format
 
 	// Telegram bot settings
-	TgBotEnable      bool   `json:"tgBotEnable" form:"tgBotEnable"`           // Enable Telegram bot notifications
-	TgBotToken       string `json:"tgBotToken" form:"tgBotToken"`             // Telegram bot token
-	TgBotProxy       string `json:"tgBotProxy" form:"tgBotProxy"`             // Proxy URL for Telegram bot
-	TgBotAPIServer   string `json:"tgBotAPIServer" form:"tgBotAPIServer"`     // Custom API server for Telegram bot
-	TgBotChatId      string `json:"tgBotChatId" form:"tgBotChatId"`           // Telegram chat ID for notifications
-	TgRunTime        string `json:"tgRunTime" form:"tgRunTime"`               // Cron schedule for Telegram notifications
-	TgBotBackup      bool   `json:"tgBotBackup" form:"tgBotBackup"`           // Enable database backup via Telegram
-	TgBotLoginNotify bool   `json:"tgBotLoginNotify" form:"tgBotLoginNotify"` // Send login notifications
-	TgCpu            int    `json:"tgCpu" form:"tgCpu"`                       // CPU usage threshold for alerts
-	TgLang           string `json:"tgLang" form:"tgLang"`                     // Telegram bot language
+	TgBotEnable        bool   `json:"tgBotEnable" form:"tgBotEnable"`               // Enable Telegram bot notifications
+	TgBotToken         string `json:"tgBotToken" form:"tgBotToken"`                 // Telegram bot token
+	TgBotProxy         string `json:"tgBotProxy" form:"tgBotProxy"`                 // Proxy URL for Telegram bot
+	TgBotAPIServer     string `json:"tgBotAPIServer" form:"tgBotAPIServer"`         // Custom API server for Telegram bot
+	TgBotChatId        string `json:"tgBotChatId" form:"tgBotChatId"`               // Telegram chat ID for notifications
+	TgBotSupportChatId string `json:"tgBotSupportChatId" form:"tgBotSupportChatId"` // Comma separated chat IDs of support agents, limited to lookups and renewals
+	TgRunTime          string `json:"tgRunTime" form:"tgRunTime"`                   // Cron schedule for Telegram notifications
+	TgBotBackup        bool   `json:"tgBotBackup" form:"tgBotBackup"`               // Enable database backup via Telegram
+	TgBotLoginNotify   bool   `json:"tgBotLoginNotify" form:"tgBotLoginNotify"`     // Send login notifications
+	TgBotEvents        string `json:"tgBotEvents" form:"tgBotEvents"`               // Comma separated notification events sent to the bot, empty for all
+	TgBotQuietHours    string `json:"tgBotQuietHours" form:"tgBotQuietHours"`       // Daily range such as "22:00-07:00" during which only urgent events are sent to the bot
+	TgBotBatchMinutes  int    `json:"tgBotBatchMinutes" form:"tgBotBatchMinutes"`   // Minutes the events that are not urgent are collected for one digest, 0 sends them immediately
+	TgBotWebhookEnable bool   `json:"tgBotWebhookEnable" form:"tgBotWebhookEnable"` // Receive the updates of the bot through a webhook instead of long polling
+	TgBotWebhookUrl    string `json:"tgBotWebhookUrl" form:"tgBotWebhookUrl"`       // Public HTTPS URL of the base path of the panel the webhook is registered under
+	TgCpu              int    `json:"tgCpu" form:"tgCpu"`                           // CPU usage threshold for alerts
+	TgLang             string `json:"tgLang" form:"tgLang"`                         // Telegram bot language
+
+	// Client notice settings
+	ExpiryNotifyDays   string `json:"expiryNotifyDays" form:"expiryNotifyDays"`     // Comma-separated days before expiry to notify at
+	QuotaNotifyPercent string `json:"quotaNotifyPercent" form:"quotaNotifyPercent"` // Comma-separated quota percentages to notify at
+	NotifyClientUser   bool   `json:"notifyClientUser" form:"notifyClientUser"`     // Send the notices to the client's Telegram user too
+	NotifyUrgentEvents string `json:"notifyUrgentEvents" form:"notifyUrgentEvents"` // Comma separated events sent immediately, ignoring quiet hours and batching
+
+	// Client credential settings
+	ClientIdMode           string `json:"clientIdMode" form:"clientIdMode"`                     // "uuid" generates random UUIDs, "custom" leaves IDs to the admin
+	ClientPasswordLength   int    `json:"clientPasswordLength" form:"clientPasswordLength"`     // Length of generated Trojan and Shadowsocks passwords
+	ClientPasswordAlphabet string `json:"clientPasswordAlphabet" form:"clientPasswordAlphabet"` // Characters of generated passwords, empty for letters and digits
+	ClientEmailPattern     string `json:"clientEmailPattern" form:"clientEmailPattern"`         // Pattern of generated emails with {seq} and {rand} placeholders
 
 	// Security settings
 	TimeLocation    string `json:"timeLocation" form:"timeLocation"`       // Time zone location
 	TwoFactorEnable bool   `json:"twoFactorEnable" form:"twoFactorEnable"` // Enable two-factor authentication
 	TwoFactorToken  string `json:"twoFactorToken" form:"twoFactorToken"`   // Two-factor authentication token
 
+	// Time zones overriding the panel time zone for some jobs, empty to use the panel time zone
+	ExpiryTimeLocation       string `json:"expiryTimeLocation" form:"expiryTimeLocation"`             // Client expiries computed in days
+	TrafficResetTimeLocation string `json:"trafficResetTimeLocation" form:"trafficResetTimeLocation"` // Traffic resets of inbounds and clients
+	ReportTimeLocation       string `json:"reportTimeLocation" form:"reportTimeLocation"`             // Usage report periods
+
 	// Subscription server settings
 	SubEnable                   bool   `json:"subEnable" form:"subEnable"`                                     // Enable subscription server
 	SubJsonEnable               bool   `json:"subJsonEnable" form:"subJsonEnable"`                             // Enable JSON subscription endpoint
 	SubTitle                    string `json:"subTitle" form:"subTitle"`                                       // Subscription title
 	SubListen                   string `json:"subListen" form:"subListen"`                                     // Subscription server listen IP
 	SubPort                     int    `json:"subPort" form:"subPort"`                                         // Subscription server port
+	SubListeners                string `json:"subListeners" form:"subListeners"`                               // Lines of "address:port [none | certFile keyFile]" the subscription server also listens on
 	SubPath                     string `json:"subPath" form:"subPath"`                                         // Base path for subscription URLs
 	SubDomain                   string `json:"subDomain" form:"subDomain"`                                     // Domain for subscription server validation
 	SubCertFile                 string `json:"subCertFile" form:"subCertFile"`                                 // SSL certificate file for subscription server
@@ -66,6 +114,7 @@ type AllSetting struct {
 	SubUpdates                  int    `json:"subUpdates" form:"subUpdates"`                                   // Subscription update interval in minutes
 	ExternalTrafficInformEnable bool   `json:"externalTrafficInformEnable" form:"externalTrafficInformEnable"` // Enable external traffic reporting
 	ExternalTrafficInformURI    string `json:"externalTrafficInformURI" form:"externalTrafficInformURI"`       // URI for external traffic reporting
+	ExtensionHooks              string `json:"extensionHooks" form:"extensionHooks"`                           // Lines of "event target" run by external programs and HTTP endpoints
 	SubEncrypt                  bool   `json:"subEncrypt" form:"subEncrypt"`                                   // Encrypt subscription responses
 	SubShowInfo                 bool   `json:"subShowInfo" form:"subShowInfo"`                                 // Show client information in subscriptions
 	SubURI                      string `json:"subURI" form:"subURI"`                                           // Subscription server URI
@@ -75,6 +124,174 @@ type AllSetting struct {
 	SubJsonNoises               string `json:"subJsonNoises" form:"subJsonNoises"`                             // JSON subscription noise configuration
 	SubJsonMux                  string `json:"subJsonMux" form:"subJsonMux"`                                   // JSON subscription mux configuration
 	SubJsonRules                string `json:"subJsonRules" form:"subJsonRules"`
+	SubPortalEnable             bool   `json:"subPortalEnable" form:"subPortalEnable"` // Enable the client self-service portal
+	SubPortalPath               string `json:"subPortalPath" form:"subPortalPath"`     // Path for the client self-service portal
+	SubStatusEnable             bool   `json:"subStatusEnable" form:"subStatusEnable"` // Enable the per-client status API
+	SubStatusPath               string `json:"subStatusPath" form:"subStatusPath"`     // Path for the per-client status API
+	QrLogoFile                  string `json:"qrLogoFile" form:"qrLogoFile"`           // Logo image placed in the center of generated QR codes
+
+	// Clash subscription settings
+	SubClashEnable bool   `json:"subClashEnable" form:"subClashEnable"` // Enable the Clash YAML subscription endpoint
+	SubClashPath   string `json:"subClashPath" form:"subClashPath"`     // Path for the Clash YAML subscription endpoint
+	SubClashRules  string `json:"subClashRules" form:"subClashRules"`   // Custom Clash rules, one per line
+
+	// sing-box subscription settings
+	SubSingboxEnable bool   `json:"subSingboxEnable" form:"subSingboxEnable"` // Enable the sing-box JSON subscription endpoint
+	SubSingboxPath   string `json:"subSingboxPath" form:"subSingboxPath"`     // Path for the sing-box JSON subscription endpoint
+
+	// Subscription template settings
+	SubLinksTemplate   string `json:"subLinksTemplate" form:"subLinksTemplate"`     // Go template replacing the link list
+	SubClashTemplate   string `json:"subClashTemplate" form:"subClashTemplate"`     // Go template replacing the Clash configuration
+	SubSingboxTemplate string `json:"subSingboxTemplate" form:"subSingboxTemplate"` // Go template replacing the sing-box configuration
+
+	// Subscription address settings
+	SubAddressGroups string `json:"subAddressGroups" form:"subAddressGroups"` // "group=address" lines matched against the client field "group"
+
+	// Subscription signing settings
+	SubSignEnable bool `json:"subSignEnable" form:"subSignEnable"` // Require signed, expiring subscription URLs
+	SubSignDays   int  `json:"subSignDays" form:"subSignDays"`     // Default validity of signed subscription URLs in days, 0 for no expiry
+
+	// Subscription fragment settings
+	SubFragmentAll bool `json:"subFragmentAll" form:"subFragmentAll"` // Embed the JSON subscription fragment into share links and sing-box configurations
+
+	// Subscription cache settings
+	SubCacheTtl int `json:"subCacheTtl" form:"subCacheTtl"` // Seconds rendered subscriptions are cached, 0 disables the cache
+
+	// Subscription node settings
+	SubNodeUnhealthy string `json:"subNodeUnhealthy" form:"subNodeUnhealthy"` // "exclude" leaves the links of unhealthy nodes out, "last" lists them last
+	SubNodeLoadOrder bool   `json:"subNodeLoadOrder" form:"subNodeLoadOrder"` // List the links of the least loaded nodes first
+	SubIpv6Links     bool   `json:"subIpv6Links" form:"subIpv6Links"`         // Also list the links at the public IPv6 address of the server
+
+	// Short link settings
+	SubShortEnable bool   `json:"subShortEnable" form:"subShortEnable"` // Serve the short links created in the panel
+	SubShortPath   string `json:"subShortPath" form:"subShortPath"`     // Path for short links
+
+	// Inbound settings
+	RealityKeyRotation int    `json:"realityKeyRotation" form:"realityKeyRotation"` // REALITY key rotation interval in days, 0 disables rotation
+	RealityKeyGrace    int    `json:"realityKeyGrace" form:"realityKeyGrace"`       // Hours the previous REALITY keys stay valid after a rotation, 0 retires them at once
+	IpLimitAction      string `json:"ipLimitAction" form:"ipLimitAction"`           // Action on clients over their IP limit: "suspend" or "fail2ban"
+	IpLimitWindow      int    `json:"ipLimitWindow" form:"ipLimitWindow"`           // Sliding window in minutes for counting client IPs
+	ExpiryGraceDays    int    `json:"expiryGraceDays" form:"expiryGraceDays"`       // Days expired clients are kept before cleanup, 0 keeps them
+
+	// Core settings
+	CoreType              string `json:"coreType" form:"coreType"`                           // Proxy core run by the panel: "xray" or "sing-box"
+	SingboxTemplateConfig string `json:"singboxTemplateConfig" form:"singboxTemplateConfig"` // sing-box config the inbounds are added to
+
+	// Geofile settings
+	GeofileUpdateInterval int    `json:"geofileUpdateInterval" form:"geofileUpdateInterval"` // Hours between geoip/geosite updates, 0 disables scheduled updates
+	GeofileMirrors        string `json:"geofileMirrors" form:"geofileMirrors"`               // Lines of "file.dat=URL" overriding the download URLs
+
+	// Speedtest settings
+	SpeedtestInterval int    `json:"speedtestInterval" form:"speedtestInterval"` // Hours between scheduled speedtests, 0 disables them
+	SpeedtestTargets  string `json:"speedtestTargets" form:"speedtestTargets"`   // Download URLs to test, one per line
+
+	// Inbound reachability check settings
+	ReachabilityInterval int    `json:"reachabilityInterval" form:"reachabilityInterval"` // Minutes between reachability checks of the inbounds, 0 disables them
+	ReachabilityHost     string `json:"reachabilityHost" form:"reachabilityHost"`         // Public address the inbounds are checked at, detected if empty
+	ReachabilityProbeUrl string `json:"reachabilityProbeUrl" form:"reachabilityProbeUrl"` // External probe asked instead of connecting from the server, with {host}, {port} and {sni}
+
+	// Usage report settings
+	ReportDaily    bool   `json:"reportDaily" form:"reportDaily"`       // Send a usage report of the previous day every midnight
+	ReportWeekly   bool   `json:"reportWeekly" form:"reportWeekly"`     // Send a usage report of the previous week every Sunday midnight
+	ReportTelegram bool   `json:"reportTelegram" form:"reportTelegram"` // Send the reports to the Telegram bot admins
+	ReportEmailTo  string `json:"reportEmailTo" form:"reportEmailTo"`   // Addresses the reports are emailed to, comma separated
+
+	// SMTP settings
+	SmtpHost         string `json:"smtpHost" form:"smtpHost"`                 // SMTP server the emails are sent through
+	SmtpPort         int    `json:"smtpPort" form:"smtpPort"`                 // SMTP port
+	SmtpSecurity     string `json:"smtpSecurity" form:"smtpSecurity"`         // "tls", "starttls", "none" or "auto" for implicit TLS on port 465 and STARTTLS when offered on others
+	SmtpUsername     string `json:"smtpUsername" form:"smtpUsername"`         // SMTP login, empty to send without authentication
+	SmtpPassword     string `json:"smtpPassword" form:"smtpPassword"`         // SMTP password
+	SmtpFrom         string `json:"smtpFrom" form:"smtpFrom"`                 // Sender address of the emails
+	SmtpAdminTo      string `json:"smtpAdminTo" form:"smtpAdminTo"`           // Addresses the admin notifications and backups are emailed to, comma separated
+	SmtpEvents       string `json:"smtpEvents" form:"smtpEvents"`             // Notification events emailed to the admins, comma separated; empty for all
+	SmtpQuietHours   string `json:"smtpQuietHours" form:"smtpQuietHours"`     // Daily range during which only urgent events are emailed
+	SmtpBatchMinutes int    `json:"smtpBatchMinutes" form:"smtpBatchMinutes"` // Minutes the events that are not urgent are collected for one email, 0 sends them immediately
+	SmtpClientNotify bool   `json:"smtpClientNotify" form:"smtpClientNotify"` // Email the expiry and quota notices to clients whose email is an address
+	SmtpBackup       bool   `json:"smtpBackup" form:"smtpBackup"`             // Email a database backup to the admins every day
+
+	// Observatory settings
+	ObservatoryProbeUrl      string `json:"observatoryProbeUrl" form:"observatoryProbeUrl"`           // URL requested through balanced outbounds to measure their latency
+	ObservatoryProbeInterval string `json:"observatoryProbeInterval" form:"observatoryProbeInterval"` // Time between probes, e.g. "1m"
+
+	// DNS settings
+	DnsManaged       bool   `json:"dnsManaged" form:"dnsManaged"`             // Replace the DNS block of the template with the managed DNS servers
+	DnsQueryStrategy string `json:"dnsQueryStrategy" form:"dnsQueryStrategy"` // "UseIP", "UseIPv4" or "UseIPv6"
+	DnsFakeDns       bool   `json:"dnsFakeDns" form:"dnsFakeDns"`             // Answer queries with fake IPs from the FakeDNS pool
+	DnsDisableCache  bool   `json:"dnsDisableCache" form:"dnsDisableCache"`
+
+	// Traffic statistics settings
+	TrafficStatsInterval int  `json:"trafficStatsInterval" form:"trafficStatsInterval"` // Seconds between two queries of the Xray StatsService
+	TrafficFlushInterval int  `json:"trafficFlushInterval" form:"trafficFlushInterval"` // Seconds the collected traffic is buffered before it is written to the database
+	OutboundTrafficStats bool `json:"outboundTrafficStats" form:"outboundTrafficStats"` // Count traffic per outbound tag even if the template policy does not
+
+	// Background job settings
+	JobWorkers int `json:"jobWorkers" form:"jobWorkers"` // Background jobs run at the same time at most
+
+	// Access log analytics settings
+	AccessLogAnalytics     bool `json:"accessLogAnalytics" form:"accessLogAnalytics"`         // Aggregate the connections of the Xray access log per client
+	AccessLogAnalyticsDays int  `json:"accessLogAnalyticsDays" form:"accessLogAnalyticsDays"` // Days the aggregated connections are kept
+
+	// Log rotation settings, for the log of the panel and the access and error logs of Xray
+	LogRotateSize     int  `json:"logRotateSize" form:"logRotateSize"`         // Megabytes a log is rotated at, 0 disables the size limit
+	LogRotateDays     int  `json:"logRotateDays" form:"logRotateDays"`         // Days a log is rotated after, 0 disables the age limit
+	LogRotateKeep     int  `json:"logRotateKeep" form:"logRotateKeep"`         // Archives kept per log
+	LogRotateCompress bool `json:"logRotateCompress" form:"logRotateCompress"` // Compress the archives with gzip
+
+	// HTTP access log settings, for the requests to the panel and the subscription server
+	HttpAccessLog        bool   `json:"httpAccessLog" form:"httpAccessLog"`
+	HttpAccessLogFormat  string `json:"httpAccessLogFormat" form:"httpAccessLogFormat"`   // "combined" or "json"
+	HttpAccessLogExclude string `json:"httpAccessLogExclude" form:"httpAccessLogExclude"` // Comma-separated path prefixes, relative to the base path, not logged
+
+	// Traffic history retention settings
+	TrafficHourlyRetentionDays int `json:"trafficHourlyRetentionDays" form:"trafficHourlyRetentionDays"` // Days hourly samples are kept before they become daily ones
+	TrafficDailyRetentionDays  int `json:"trafficDailyRetentionDays" form:"trafficDailyRetentionDays"`   // Days daily samples are kept before they become monthly ones
+	TrafficMonthlyRetention    int `json:"trafficMonthlyRetention" form:"trafficMonthlyRetention"`       // Months monthly samples are kept, 0 keeps them forever
+
+	// Traffic history archive settings
+	TrafficArchiveDays        int    `json:"trafficArchiveDays" form:"trafficArchiveDays"`               // Days traffic history is kept in the database before it is archived, 0 disables archiving
+	TrafficArchiveFolder      string `json:"trafficArchiveFolder" form:"trafficArchiveFolder"`           // Folder of the archive files, empty for the archive folder next to the database
+	TrafficArchiveS3Url       string `json:"trafficArchiveS3Url" form:"trafficArchiveS3Url"`             // Bucket URL with an optional key prefix the archives are uploaded to, empty keeps them on disk
+	TrafficArchiveS3Region    string `json:"trafficArchiveS3Region" form:"trafficArchiveS3Region"`       // Region of the bucket
+	TrafficArchiveS3AccessKey string `json:"trafficArchiveS3AccessKey" form:"trafficArchiveS3AccessKey"` // Access key ID
+	TrafficArchiveS3SecretKey string `json:"trafficArchiveS3SecretKey" form:"trafficArchiveS3SecretKey"` // Secret access key
+
+	// Server resource history settings
+	ServerHistoryInterval      int `json:"serverHistoryInterval" form:"serverHistoryInterval"`           // Seconds between stored server status samples, 0 disables the history
+	ServerHistoryRetentionDays int `json:"serverHistoryRetentionDays" form:"serverHistoryRetentionDays"` // Days server status samples are kept
+
+	// Traffic anomaly detection settings
+	AnomalyDetection   bool `json:"anomalyDetection" form:"anomalyDetection"`     // Check the traffic of the clients for anomalies every day
+	AnomalySpikeFactor int  `json:"anomalySpikeFactor" form:"anomalySpikeFactor"` // Times the typical daily traffic a day needs to be a spike
+
+	// Prometheus metrics settings
+	MetricsEnable bool   `json:"metricsEnable" form:"metricsEnable"` // Serve the metrics endpoint
+	MetricsToken  string `json:"metricsToken" form:"metricsToken"`   // Bearer token Prometheus authenticates with
+
+	// Diagnostics settings
+	DiagnosticsListen string `json:"diagnosticsListen" form:"diagnosticsListen"` // Address serving pprof and the runtime stats, empty disables it
+	DiagnosticsToken  string `json:"diagnosticsToken" form:"diagnosticsToken"`   // Token the diagnostics requests authenticate with, required off loopback
+
+	// Metrics push exporter settings
+	MetricsPushEnable   bool   `json:"metricsPushEnable" form:"metricsPushEnable"`     // Push the metrics to a time-series database
+	MetricsPushFormat   string `json:"metricsPushFormat" form:"metricsPushFormat"`     // "influx" line protocol or Prometheus "remoteWrite"
+	MetricsPushUrl      string `json:"metricsPushUrl" form:"metricsPushUrl"`           // Write endpoint of the database
+	MetricsPushToken    string `json:"metricsPushToken" form:"metricsPushToken"`       // Token sent in the Authorization header, optional
+	MetricsPushInstance string `json:"metricsPushInstance" form:"metricsPushInstance"` // Instance label of the pushed metrics, the host name if empty
+	MetricsPushInterval int    `json:"metricsPushInterval" form:"metricsPushInterval"` // Seconds between pushes
+
+	// Loki log shipping settings
+	LokiEnable    bool   `json:"lokiEnable" form:"lokiEnable"`       // Ship the logs of the panel and of Xray to Grafana Loki
+	LokiUrl       string `json:"lokiUrl" form:"lokiUrl"`             // Push endpoint, e.g. http://loki:3100/loki/api/v1/push
+	LokiToken     string `json:"lokiToken" form:"lokiToken"`         // Bearer token, or user:token for basic authentication, optional
+	LokiInstance  string `json:"lokiInstance" form:"lokiInstance"`   // Instance label of the shipped lines, the host name if empty
+	LokiAccessLog bool   `json:"lokiAccessLog" form:"lokiAccessLog"` // Also ship the Xray access log
+
+	// TLS fragment settings of the freedom outbounds
+	FragmentEnable   bool   `json:"fragmentEnable" form:"fragmentEnable"`
+	FragmentPackets  string `json:"fragmentPackets" form:"fragmentPackets"`   // "tlshello" or a range of TCP packets such as "1-3"
+	FragmentLength   string `json:"fragmentLength" form:"fragmentLength"`     // Range of fragment lengths in bytes
+	FragmentInterval string `json:"fragmentInterval" form:"fragmentInterval"` // Range of delays between fragments in milliseconds
 
 	// LDAP settings
 	LdapEnable     bool   `json:"ldapEnable" form:"ldapEnable"`
@@ -102,6 +319,28 @@ type AllSetting struct {
 }
 
 // CheckValid validates all settings in the AllSetting struct, checking IP addresses, ports, SSL certificates, and other configuration values.
+// listenAddrsOverlap reports whether two listen addresses use the same port on the same IP
+// address, or on all addresses: all IPv4 addresses for 0.0.0.0, all addresses for :: or none.
+func listenAddrsOverlap(a, b string) bool {
+	hostA, portA, _ := net.SplitHostPort(a)
+	hostB, portB, _ := net.SplitHostPort(b)
+	if portA != portB {
+		return false
+	}
+	ipA, ipB := net.ParseIP(hostA), net.ParseIP(hostB)
+	anyA := ipA == nil || ipA.IsUnspecified()
+	anyB := ipB == nil || ipB.IsUnspecified()
+	switch {
+	case anyA && anyB:
+		return true
+	case anyA:
+		return ipA == nil || ipA.To4() == nil || ipB.To4() != nil
+	case anyB:
+		return ipB == nil || ipB.To4() == nil || ipA.To4() != nil
+	}
+	return ipA.Equal(ipB)
+}
+
 func (s *AllSetting) CheckValid() error {
 	if s.WebListen != "" {
 		ip := net.ParseIP(s.WebListen)
@@ -129,6 +368,35 @@ func (s *AllSetting) CheckValid() error {
 		return common.NewError("Sub and Web could not use same ip:port, ", s.SubListen, ":", s.SubPort, " & ", s.WebListen, ":", s.WebPort)
 	}
 
+	if _, err := extension.ParseExternal(s.ExtensionHooks); err != nil {
+		return common.NewError(err)
+	}
+
+	// The additional listeners must not overlap another listener
+	addrs := []string{
+		net.JoinHostPort(s.WebListen, strconv.Itoa(s.WebPort)),
+		net.JoinHostPort(s.SubListen, strconv.Itoa(s.SubPort)),
+	}
+	for _, value := range []string{s.WebListeners, s.SubListeners} {
+		listeners, err := network.ParseListeners(value)
+		if err != nil {
+			return common.NewError(err)
+		}
+		for _, listener := range listeners {
+			for _, addr := range addrs {
+				if listenAddrsOverlap(addr, listener.Addr) {
+					return common.NewError("listen address", listener.Addr, "overlaps", addr)
+				}
+			}
+			addrs = append(addrs, listener.Addr)
+			if listener.CertFile != "" {
+				if _, err := tls.LoadX509KeyPair(listener.CertFile, listener.KeyFile); err != nil {
+					return common.NewErrorf("cert file <%v> or key file <%v> of listener %v invalid: %v", listener.CertFile, listener.KeyFile, listener.Addr, err)
+				}
+			}
+		}
+	}
+
 	if s.WebCertFile != "" || s.WebKeyFile != "" {
 		_, err := tls.LoadX509KeyPair(s.WebCertFile, s.WebKeyFile)
 		if err != nil {
@@ -136,6 +404,30 @@ func (s *AllSetting) CheckValid() error {
 		}
 	}
 
+	if s.AcmeEnable {
+		if strings.TrimSpace(s.WebDomain) == "" && strings.TrimSpace(s.SubDomain) == "" {
+			return common.NewError("ACME needs the domain of the panel or of the subscription server")
+		}
+		if u, err := url.Parse(s.AcmeDirectory); err != nil || u.Scheme != "https" || u.Host == "" {
+			return common.NewError("ACME directory is not a valid https URL:", s.AcmeDirectory)
+		}
+		switch s.AcmeChallenge {
+		case "http":
+		case "dns":
+			switch s.AcmeDnsProvider {
+			case "cloudflare", "route53", "gandi", "digitalocean":
+			default:
+				return common.NewError("unknown ACME DNS provider:", s.AcmeDnsProvider)
+			}
+			var credentials map[string]string
+			if err := json.Unmarshal([]byte(s.AcmeDnsCredentials), &credentials); err != nil {
+				return common.NewError("ACME DNS credentials are not a JSON object of strings:", err)
+			}
+		default:
+			return common.NewError("unknown ACME challenge:", s.AcmeChallenge)
+		}
+	}
+
 	if s.SubCertFile != "" || s.SubKeyFile != "" {
 		_, err := tls.LoadX509KeyPair(s.SubCertFile, s.SubKeyFile)
 		if err != nil {
@@ -143,6 +435,20 @@ func (s *AllSetting) CheckValid() error {
 		}
 	}
 
+	if _, err := common.ParseIntList(s.CertExpiryDays); err != nil {
+		return common.NewError("certificate expiry alert days are not valid:", s.CertExpiryDays)
+	}
+
+	if s.DecoySite != "" {
+		if strings.HasPrefix(s.DecoySite, "http://") || strings.HasPrefix(s.DecoySite, "https://") {
+			if u, err := url.Parse(s.DecoySite); err != nil || u.Host == "" {
+				return common.NewError("decoy site is not a valid URL:", s.DecoySite)
+			}
+		} else if stat, err := os.Stat(s.DecoySite); err != nil || !stat.IsDir() {
+			return common.NewError("decoy site is neither a folder nor an http(s) URL:", s.DecoySite)
+		}
+	}
+
 	if !strings.HasPrefix(s.WebBasePath, "/") {
 		s.WebBasePath = "/" + s.WebBasePath
 	}
@@ -163,10 +469,333 @@ func (s *AllSetting) CheckValid() error {
 		s.SubJsonPath += "/"
 	}
 
+	if !strings.HasPrefix(s.SubPortalPath, "/") {
+		s.SubPortalPath = "/" + s.SubPortalPath
+	}
+	if !strings.HasSuffix(s.SubPortalPath, "/") {
+		s.SubPortalPath += "/"
+	}
+	if s.SubPortalEnable && (s.SubPortalPath == s.SubPath || s.SubPortalPath == s.SubJsonPath) {
+		return common.NewError("Portal path could not be the same as the subscription path:", s.SubPortalPath)
+	}
+
+	if !strings.HasPrefix(s.SubStatusPath, "/") {
+		s.SubStatusPath = "/" + s.SubStatusPath
+	}
+	if !strings.HasSuffix(s.SubStatusPath, "/") {
+		s.SubStatusPath += "/"
+	}
+	if s.SubStatusEnable && (s.SubStatusPath == s.SubPath || s.SubStatusPath == s.SubJsonPath ||
+		(s.SubPortalEnable && s.SubStatusPath == s.SubPortalPath)) {
+		return common.NewError("Status path could not be the same as another subscription path:", s.SubStatusPath)
+	}
+
+	if !strings.HasPrefix(s.SubClashPath, "/") {
+		s.SubClashPath = "/" + s.SubClashPath
+	}
+	if !strings.HasSuffix(s.SubClashPath, "/") {
+		s.SubClashPath += "/"
+	}
+	if s.SubClashEnable && (s.SubClashPath == s.SubPath || s.SubClashPath == s.SubJsonPath ||
+		(s.SubPortalEnable && s.SubClashPath == s.SubPortalPath) ||
+		(s.SubStatusEnable && s.SubClashPath == s.SubStatusPath)) {
+		return common.NewError("Clash path could not be the same as another subscription path:", s.SubClashPath)
+	}
+
+	if !strings.HasPrefix(s.SubSingboxPath, "/") {
+		s.SubSingboxPath = "/" + s.SubSingboxPath
+	}
+	if !strings.HasSuffix(s.SubSingboxPath, "/") {
+		s.SubSingboxPath += "/"
+	}
+	if s.SubSingboxEnable && (s.SubSingboxPath == s.SubPath || s.SubSingboxPath == s.SubJsonPath ||
+		(s.SubPortalEnable && s.SubSingboxPath == s.SubPortalPath) ||
+		(s.SubStatusEnable && s.SubSingboxPath == s.SubStatusPath) ||
+		(s.SubClashEnable && s.SubSingboxPath == s.SubClashPath)) {
+		return common.NewError("sing-box path could not be the same as another subscription path:", s.SubSingboxPath)
+	}
+
+	if !strings.HasPrefix(s.SubShortPath, "/") {
+		s.SubShortPath = "/" + s.SubShortPath
+	}
+	if !strings.HasSuffix(s.SubShortPath, "/") {
+		s.SubShortPath += "/"
+	}
+	if s.SubShortEnable && (s.SubShortPath == s.SubPath || s.SubShortPath == s.SubJsonPath ||
+		(s.SubPortalEnable && s.SubShortPath == s.SubPortalPath) ||
+		(s.SubStatusEnable && s.SubShortPath == s.SubStatusPath) ||
+		(s.SubClashEnable && s.SubShortPath == s.SubClashPath) ||
+		(s.SubSingboxEnable && s.SubShortPath == s.SubSingboxPath)) {
+		return common.NewError("Short link path could not be the same as another subscription path:", s.SubShortPath)
+	}
+
+	for name, text := range map[string]string{"links": s.SubLinksTemplate, "clash": s.SubClashTemplate, "sing-box": s.SubSingboxTemplate} {
+		if _, err := tmpl.Parse(name, text); err != nil {
+			return common.NewError("subscription template is not valid:", err)
+		}
+	}
+
+	if s.SubCacheTtl < 0 {
+		return common.NewError("subscription cache TTL is not valid:", s.SubCacheTtl)
+	}
+	if s.SubNodeUnhealthy != "exclude" && s.SubNodeUnhealthy != "last" {
+		return common.NewError("unhealthy node handling is not valid:", s.SubNodeUnhealthy)
+	}
+
+	if s.SubSignDays < 0 {
+		return common.NewError("signed subscription validity is not valid:", s.SubSignDays)
+	}
+
+	if _, err := common.ParseIntList(s.ExpiryNotifyDays); err != nil {
+		return common.NewError("expiry notify days are not valid:", s.ExpiryNotifyDays)
+	}
+	if _, err := common.ParseIntList(s.QuotaNotifyPercent); err != nil {
+		return common.NewError("quota notify percentages are not valid:", s.QuotaNotifyPercent)
+	}
+	if s.TgBotQuietHours != "" {
+		if _, _, err := common.ParseClockRange(s.TgBotQuietHours); err != nil {
+			return common.NewError("Telegram quiet hours are not valid:", s.TgBotQuietHours)
+		}
+	}
+	if s.SmtpQuietHours != "" {
+		if _, _, err := common.ParseClockRange(s.SmtpQuietHours); err != nil {
+			return common.NewError("email quiet hours are not valid:", s.SmtpQuietHours)
+		}
+	}
+	if s.TgBotWebhookEnable {
+		u, err := url.Parse(s.TgBotWebhookUrl)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return common.NewError("Telegram bot webhook URL must be an https URL:", s.TgBotWebhookUrl)
+		}
+		// Telegram sends webhook requests only to these ports
+		if port := u.Port(); port != "" && port != "443" && port != "80" && port != "88" && port != "8443" {
+			return common.NewError("Telegram bot webhook port must be 443, 80, 88 or 8443:", port)
+		}
+	}
+	if s.TgBotBatchMinutes < 0 || s.SmtpBatchMinutes < 0 {
+		return common.NewError("notification batch minutes must not be negative")
+	}
+
+	if s.ClientIdMode != "uuid" && s.ClientIdMode != "custom" {
+		return common.NewError("client ID mode is not valid:", s.ClientIdMode)
+	}
+	if s.ClientPasswordLength < 4 || s.ClientPasswordLength > 64 {
+		return common.NewError("client password length must be between 4 and 64:", s.ClientPasswordLength)
+	}
+	if s.ClientEmailPattern != "" && !strings.Contains(s.ClientEmailPattern, "{seq}") && !strings.Contains(s.ClientEmailPattern, "{rand}") {
+		return common.NewError("client email pattern needs a {seq} or {rand} placeholder:", s.ClientEmailPattern)
+	}
+
+	if s.CoreType != "xray" && s.CoreType != "sing-box" {
+		return common.NewError("unknown core:", s.CoreType)
+	}
+	if !json.Valid([]byte(s.SingboxTemplateConfig)) {
+		return common.NewError("sing-box template config is not valid JSON")
+	}
+
+	if s.GeofileUpdateInterval < 0 {
+		return common.NewError("geofile update interval must not be negative:", s.GeofileUpdateInterval)
+	}
+	for line := range strings.SplitSeq(s.GeofileMirrors, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name, url, ok := strings.Cut(line, "=")
+		if !ok || !strings.HasSuffix(strings.TrimSpace(name), ".dat") || !strings.HasPrefix(strings.TrimSpace(url), "https://") {
+			return common.NewError("geofile mirror must be file.dat=https://URL:", line)
+		}
+	}
+
+	if s.SpeedtestInterval < 0 {
+		return common.NewError("speedtest interval must not be negative:", s.SpeedtestInterval)
+	}
+	for line := range strings.SplitSeq(s.SpeedtestTargets, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "http://") && !strings.HasPrefix(line, "https://") {
+			return common.NewError("speedtest target must be an http or https URL:", line)
+		}
+	}
+
+	if s.ReachabilityInterval < 0 {
+		return common.NewError("reachability check interval must not be negative:", s.ReachabilityInterval)
+	}
+	if s.ReachabilityProbeUrl != "" && !strings.HasPrefix(s.ReachabilityProbeUrl, "http://") && !strings.HasPrefix(s.ReachabilityProbeUrl, "https://") {
+		return common.NewError("reachability probe must be an http or https URL:", s.ReachabilityProbeUrl)
+	}
+
+	if s.SmtpPort <= 0 || s.SmtpPort > math.MaxUint16 {
+		return common.NewError("SMTP port is not a valid port:", s.SmtpPort)
+	}
+	if s.SmtpSecurity != "auto" && s.SmtpSecurity != "tls" && s.SmtpSecurity != "starttls" && s.SmtpSecurity != "none" {
+		return common.NewError("unknown SMTP security:", s.SmtpSecurity)
+	}
+	if s.ReportEmailTo != "" || s.SmtpAdminTo != "" || s.SmtpClientNotify {
+		if s.SmtpHost == "" || s.SmtpFrom == "" {
+			return common.NewError("SMTP host and sender are required to send emails")
+		}
+	}
+	if s.SmtpBackup && s.SmtpAdminTo == "" {
+		return common.NewError("admin email addresses are required to email the backups")
+	}
+	for addr := range strings.SplitSeq(s.SmtpAdminTo, ",") {
+		if addr = strings.TrimSpace(addr); addr == "" {
+			continue
+		}
+		if _, err := mail.ParseAddress(addr); err != nil {
+			return common.NewError("admin email address is not valid:", addr)
+		}
+	}
+	if s.ReportEmailTo != "" {
+		for addr := range strings.SplitSeq(s.ReportEmailTo, ",") {
+			if _, err := mail.ParseAddress(strings.TrimSpace(addr)); err != nil {
+				return common.NewError("report email address is not valid:", addr)
+			}
+		}
+	}
+	if s.SmtpFrom != "" {
+		if _, err := mail.ParseAddress(s.SmtpFrom); err != nil {
+			return common.NewError("SMTP sender is not a valid email address:", s.SmtpFrom)
+		}
+	}
+
+	if !strings.HasPrefix(s.ObservatoryProbeUrl, "http://") && !strings.HasPrefix(s.ObservatoryProbeUrl, "https://") {
+		return common.NewError("observatory probe URL must be an http(s) URL:", s.ObservatoryProbeUrl)
+	}
+	if interval, err := time.ParseDuration(s.ObservatoryProbeInterval); err != nil || interval < time.Second {
+		return common.NewError("observatory probe interval must be a duration of at least 1s:", s.ObservatoryProbeInterval)
+	}
+
+	switch s.DnsQueryStrategy {
+	case "UseIP", "UseIPv4", "UseIPv6":
+	default:
+		return common.NewError("DNS query strategy is not valid:", s.DnsQueryStrategy)
+	}
+
+	if s.AccessLogAnalyticsDays < 1 {
+		return common.NewError("access log analytics retention must be at least one day:", s.AccessLogAnalyticsDays)
+	}
+
+	if s.LogRotateSize < 0 || s.LogRotateDays < 0 || s.LogRotateKeep < 0 {
+		return common.NewError("log rotation size, days and kept archives cannot be negative")
+	}
+
+	switch s.HttpAccessLogFormat {
+	case "combined", "json":
+	default:
+		return common.NewError("HTTP access log format is not valid:", s.HttpAccessLogFormat)
+	}
+	for _, proxy := range strings.Split(s.TrustedProxies, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return common.NewError("Trusted proxy is not valid:", proxy)
+		}
+	}
+
+	if s.TrafficHourlyRetentionDays < 1 || s.TrafficDailyRetentionDays < 1 || s.TrafficMonthlyRetention < 0 {
+		return common.NewError("traffic history retention must be at least one day, or 0 months to keep monthly samples forever")
+	}
+	if s.TrafficArchiveDays < 0 {
+		return common.NewError("traffic archive age must be at least one day, or 0 to disable archiving:", s.TrafficArchiveDays)
+	}
+	if s.TrafficArchiveFolder != "" && !filepath.IsAbs(s.TrafficArchiveFolder) {
+		return common.NewError("traffic archive folder must be an absolute path:", s.TrafficArchiveFolder)
+	}
+	if s.TrafficArchiveS3Url != "" {
+		if u, err := url.Parse(s.TrafficArchiveS3Url); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return common.NewError("traffic archive S3 URL must be an http or https URL:", s.TrafficArchiveS3Url)
+		}
+		if s.TrafficArchiveS3Region == "" || s.TrafficArchiveS3AccessKey == "" || s.TrafficArchiveS3SecretKey == "" {
+			return common.NewError("traffic archive S3 upload needs a region, an access key and a secret key")
+		}
+	}
+
+	if s.ServerHistoryInterval != 0 && (s.ServerHistoryInterval < 10 || s.ServerHistoryInterval > 3600) {
+		return common.NewError("server history interval must be between 10 and 3600 seconds, or 0 to disable it:", s.ServerHistoryInterval)
+	}
+	if s.ServerHistoryRetentionDays < 1 {
+		return common.NewError("server history retention must be at least one day:", s.ServerHistoryRetentionDays)
+	}
+
+	if s.AnomalySpikeFactor < 2 {
+		return common.NewError("anomaly spike factor must be at least 2:", s.AnomalySpikeFactor)
+	}
+
+	if s.MetricsEnable && len(s.MetricsToken) < 16 {
+		return common.NewError("metrics token must be at least 16 characters")
+	}
+
+	if s.DiagnosticsListen != "" {
+		host, port, err := net.SplitHostPort(s.DiagnosticsListen)
+		if err != nil {
+			return common.NewError("diagnostics address must be a host and a port:", s.DiagnosticsListen)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > math.MaxUint16 {
+			return common.NewError("diagnostics port is not a valid port:", port)
+		}
+		ip := net.ParseIP(host)
+		loopback := host == "localhost" || (ip != nil && ip.IsLoopback())
+		if s.DiagnosticsToken != "" && len(s.DiagnosticsToken) < 16 {
+			return common.NewError("diagnostics token must be at least 16 characters")
+		}
+		if !loopback && s.DiagnosticsToken == "" {
+			return common.NewError("diagnostics address off loopback requires a token:", s.DiagnosticsListen)
+		}
+	}
+
+	if s.MetricsPushEnable {
+		if s.MetricsPushFormat != "influx" && s.MetricsPushFormat != "remoteWrite" {
+			return common.NewError("metrics push format must be influx or remoteWrite:", s.MetricsPushFormat)
+		}
+		if u, err := url.Parse(s.MetricsPushUrl); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return common.NewError("metrics push URL must be an http or https URL:", s.MetricsPushUrl)
+		}
+		if s.MetricsPushInterval < 10 || s.MetricsPushInterval > 3600 {
+			return common.NewError("metrics push interval must be between 10 and 3600 seconds:", s.MetricsPushInterval)
+		}
+	}
+
+	if s.LokiEnable {
+		if u, err := url.Parse(s.LokiUrl); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return common.NewError("Loki push URL must be an http or https URL:", s.LokiUrl)
+		}
+	}
+
+	if s.FragmentPackets != "tlshello" && !fragmentRangeRegex.MatchString(s.FragmentPackets) {
+		return common.NewError("fragment packets must be tlshello or a range such as 1-3:", s.FragmentPackets)
+	}
+	if !fragmentRangeRegex.MatchString(s.FragmentLength) {
+		return common.NewError("fragment length must be a range such as 100-200:", s.FragmentLength)
+	}
+	if !fragmentRangeRegex.MatchString(s.FragmentInterval) {
+		return common.NewError("fragment interval must be a range such as 10-20:", s.FragmentInterval)
+	}
+
+	if s.TrafficStatsInterval < 1 || s.TrafficStatsInterval > 3600 {
+		return common.NewError("traffic stats interval must be between 1 and 3600 seconds:", s.TrafficStatsInterval)
+	}
+	if s.TrafficFlushInterval < 1 || s.TrafficFlushInterval > 3600 {
+		return common.NewError("traffic flush interval must be between 1 and 3600 seconds:", s.TrafficFlushInterval)
+	}
+	if s.JobWorkers < 1 || s.JobWorkers > 64 {
+		return common.NewError("job workers must be between 1 and 64:", s.JobWorkers)
+	}
+
 	_, err := time.LoadLocation(s.TimeLocation)
 	if err != nil {
 		return common.NewError("time location not exist:", s.TimeLocation)
 	}
+	for _, location := range []string{s.ExpiryTimeLocation, s.TrafficResetTimeLocation, s.ReportTimeLocation} {
+		if location == "" {
+			continue
+		}
+		if _, err := time.LoadLocation(location); err != nil {
+			return common.NewError("time location not exist:", location)
+		}
+	}
 
 	return nil
 }
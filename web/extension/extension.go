@@ -0,0 +1,238 @@
+// Package extension lets integrators customize the panel without forking it. Hooks run at key
+// points of the panel: Go functions registered by code built into the panel, and external
+// programs or HTTP endpoints configured in the settings, which are sent a JSON-RPC 2.0 request.
+package extension
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+)
+
+// The events the hooks run at, with the type of their payload.
+const (
+	ClientCreated        = "client.created"        // A client was added to an inbound: *ClientPayload, changes are ignored
+	ConfigGenerated      = "config.generated"      // The Xray configuration was generated: *xray.Config
+	SubscriptionRendered = "subscription.rendered" // A subscription was rendered: *SubscriptionPayload
+)
+
+// Events lists every event of the hooks.
+var Events = []string{ClientCreated, ConfigGenerated, SubscriptionRendered}
+
+// externalTimeout is how long an external hook may take to answer.
+const externalTimeout = 10 * time.Second
+
+// maxResultSize bounds the answer of an external hook.
+const maxResultSize = 16 << 20
+
+// ClientPayload is the payload of ClientCreated.
+type ClientPayload struct {
+	InboundId  int          `json:"inboundId"`
+	InboundTag string       `json:"inboundTag"`
+	Protocol   string       `json:"protocol"`
+	Client     model.Client `json:"client"`
+}
+
+// SubscriptionPayload is the payload of SubscriptionRendered. Hooks may change the body and the
+// Subscription-Userinfo header, which are cached then like the rendered subscription.
+type SubscriptionPayload struct {
+	SubId  string `json:"subId"`
+	Format string `json:"format"` // links, json, clash, clash.meta or singbox
+	Host   string `json:"host"`   // The host the subscription was requested at
+	Body   string `json:"body"`
+	Header string `json:"header"`
+}
+
+// Hook is a hook built into the panel. It receives a pointer to the payload of the event, which
+// it may change in place.
+type Hook func(event string, payload any) error
+
+// External is a hook run by an external program or HTTP endpoint.
+type External struct {
+	Event  string
+	Target string // An http(s) URL, or the absolute path of a program
+}
+
+// registry holds the hooks built into the panel, by event.
+var registry struct {
+	sync.RWMutex
+	hooks map[string][]Hook
+}
+
+// Register adds a hook built into the panel for an event, usually from the init function of
+// the package implementing it.
+func Register(event string, hook Hook) {
+	registry.Lock()
+	defer registry.Unlock()
+	if registry.hooks == nil {
+		registry.hooks = make(map[string][]Hook)
+	}
+	registry.hooks[event] = append(registry.hooks[event], hook)
+}
+
+// ParseExternal parses external hooks given one per line as "event target", e.g.
+// "subscription.rendered https://hooks.example.com/sub" or "config.generated /usr/local/bin/tweak".
+// Empty lines and lines starting with # are skipped.
+func ParseExternal(value string) ([]External, error) {
+	var hooks []External
+	for line := range strings.SplitSeq(value, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("extension hook %q must be an event followed by a URL or program", strings.TrimSpace(line))
+		}
+		if !slices.Contains(Events, fields[0]) {
+			return nil, fmt.Errorf("extension hook %q has an unknown event, use one of %s", strings.TrimSpace(line), strings.Join(Events, ", "))
+		}
+		if !isURL(fields[1]) && !filepath.IsAbs(fields[1]) {
+			return nil, fmt.Errorf("extension hook %q must call an http(s) URL or a program by its absolute path", strings.TrimSpace(line))
+		}
+		hooks = append(hooks, External{Event: fields[0], Target: fields[1]})
+	}
+	return hooks, nil
+}
+
+// isURL reports whether the target of an external hook is an HTTP endpoint.
+func isURL(target string) bool {
+	return strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://")
+}
+
+// Run runs the hooks of an event on its payload, a pointer to a value of the type of the event:
+// the registered hooks first, then the external ones in their order. A hook that fails is
+// logged and the payload continues as it was before it.
+func Run(event string, payload any, external []External) {
+	registry.RLock()
+	hooks := registry.hooks[event]
+	registry.RUnlock()
+	for _, hook := range hooks {
+		if err := runHook(hook, event, payload); err != nil {
+			logger.Warningf("Extension hook of %s failed: %v", event, err)
+		}
+	}
+
+	for _, hook := range external {
+		if hook.Event != event {
+			continue
+		}
+		result, err := callExternal(hook, payload)
+		if err == nil && len(result) > 0 && string(result) != "null" {
+			err = applyResult(payload, result)
+		}
+		if err != nil {
+			logger.Warningf("Extension hook of %s at %s failed: %v", event, hook.Target, err)
+		}
+	}
+}
+
+// runHook runs a hook built into the panel, turning its panics into errors.
+func runHook(hook Hook, event string, payload any) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return hook(event, payload)
+}
+
+// rpcRequest is the JSON-RPC 2.0 request sent to the external hooks. The method is the event and
+// the params are its payload.
+type rpcRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	Id      int64  `json:"id"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+// rpcResponse is the answer of an external hook. The result is the changed payload, or null to
+// keep it as it is.
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// callExternal sends the request of an event to an external hook: POSTed to a URL, or written to
+// the standard input of a program, which writes the response to its standard output. Programs
+// may also exit without output to keep the payload.
+func callExternal(hook External, payload any) (json.RawMessage, error) {
+	request, err := json.Marshal(rpcRequest{JSONRPC: "2.0", Id: time.Now().UnixNano(), Method: hook.Event, Params: payload})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), externalTimeout)
+	defer cancel()
+
+	var output []byte
+	if isURL(hook.Target) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.Target, bytes.NewReader(request))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return nil, fmt.Errorf("hook answered %s", resp.Status)
+		}
+		if output, err = io.ReadAll(io.LimitReader(resp.Body, maxResultSize)); err != nil {
+			return nil, err
+		}
+	} else {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, hook.Target)
+		cmd.Stdin = bytes.NewReader(request)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		output = stdout.Bytes()
+	}
+
+	if len(bytes.TrimSpace(output)) == 0 {
+		return nil, nil
+	}
+	var response rpcResponse
+	if err := json.Unmarshal(output, &response); err != nil {
+		return nil, fmt.Errorf("invalid JSON-RPC response: %v", err)
+	}
+	if response.Error != nil {
+		return nil, fmt.Errorf("error %d: %s", response.Error.Code, response.Error.Message)
+	}
+	return response.Result, nil
+}
+
+// applyResult replaces the payload with the result of an external hook, leaving it unchanged
+// if the result does not decode.
+func applyResult(payload any, result json.RawMessage) error {
+	value := reflect.ValueOf(payload)
+	if value.Kind() != reflect.Pointer {
+		return fmt.Errorf("payload %T is not a pointer", payload)
+	}
+	fresh := reflect.New(value.Type().Elem())
+	if err := json.Unmarshal(result, fresh.Interface()); err != nil {
+		return fmt.Errorf("invalid result: %v", err)
+	}
+	value.Elem().Set(fresh.Elem())
+	return nil
+}
@@ -5,7 +5,7 @@ import (
 	"context"
 	_ "unsafe"
 
-	"github.com/robfig/cron/v3"
+	"github.com/mhsanaei/3x-ui/v2/web/scheduler"
 )
 
 var (
@@ -15,13 +15,14 @@ var (
 
 // WebServer interface defines methods for accessing the web server instance.
 type WebServer interface {
-	GetCron() *cron.Cron     // Get the cron scheduler
-	GetCtx() context.Context // Get the server context
+	GetScheduler() *scheduler.Scheduler // Get the job scheduler
+	GetCtx() context.Context            // Get the server context
 }
 
 // SubServer interface defines methods for accessing the subscription server instance.
 type SubServer interface {
-	GetCtx() context.Context // Get the server context
+	GetCtx() context.Context                                    // Get the server context
+	GetClientLinks(email string, host string) ([]string, error) // Generate the share links of a client
 }
 
 // SetWebServer sets the global web server instance.
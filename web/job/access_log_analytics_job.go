@@ -0,0 +1,83 @@
+package job
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// AccessLogAnalyticsJob tails the Xray access log and aggregates the accepted connections
+// per client, source IP and destination.
+type AccessLogAnalyticsJob struct {
+	analyticsService service.AnalyticsService
+
+	path      string
+	offset    int64
+	started   bool
+	lastPrune time.Time
+}
+
+// NewAccessLogAnalyticsJob creates a new access log analytics job instance.
+func NewAccessLogAnalyticsJob() *AccessLogAnalyticsJob {
+	return new(AccessLogAnalyticsJob)
+}
+
+// Run reads the lines appended to the access log since the last run and stores their connections.
+func (j *AccessLogAnalyticsJob) Run() {
+	if time.Since(j.lastPrune) > time.Hour {
+		if err := j.analyticsService.PruneConnections(); err != nil {
+			logger.Warning("prune connection analytics failed:", err)
+		}
+		j.lastPrune = time.Now()
+	}
+
+	path, err := xray.GetAccessLogPath()
+	if err != nil || path == "" || path == "none" {
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return
+	}
+
+	// Start at the end on the first run so a panel restart does not count the log twice
+	if !j.started || path != j.path {
+		j.path, j.offset, j.started = path, stat.Size(), true
+		return
+	}
+	// The log was truncated by the IP limit job or rotated
+	if stat.Size() < j.offset {
+		j.offset = 0
+	}
+	if _, err := file.Seek(j.offset, io.SeekStart); err != nil {
+		return
+	}
+
+	var stats []*model.ConnectionStat
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			// A partial last line is read again on the next run
+			break
+		}
+		j.offset += int64(len(line))
+		if connection, ok := service.ParseAccessLogLine(line); ok {
+			stats = append(stats, connection)
+		}
+	}
+	if err := j.analyticsService.AddConnections(stats); err != nil {
+		logger.Warning("add connection analytics failed:", err)
+	}
+}
@@ -0,0 +1,46 @@
+package job
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// AlertJob evaluates the alert rules and sends the due alerts to the notification channels.
+type AlertJob struct {
+	alertService  service.AlertService
+	notifyService service.NotifyService
+	tgbotService  service.Tgbot
+}
+
+// NewAlertJob creates a new alert rule evaluation job instance.
+func NewAlertJob() *AlertJob {
+	return new(AlertJob)
+}
+
+// Run evaluates the alert rules and sends the alerts that are due.
+func (j *AlertJob) Run() {
+	notices, err := j.alertService.EvaluateAlertRules()
+	if err != nil {
+		logger.Warning("Evaluate alert rules failed:", err)
+		return
+	}
+	if len(notices) == 0 || !j.notifyService.HasDestinations() {
+		return
+	}
+	for _, notice := range notices {
+		key := "tgbot.messages.alert" + strings.ToUpper(notice.Rule.Type[:1]) + notice.Rule.Type[1:]
+		msg := j.tgbotService.I18nBot(key,
+			"Name=="+notice.Rule.Name,
+			"Value=="+strconv.FormatFloat(math.Round(notice.Event.Value*100)/100, 'f', -1, 64),
+			"Threshold=="+strconv.FormatFloat(notice.Rule.Threshold, 'f', -1, 64),
+			"Detail=="+notice.Event.Detail)
+		j.notifyService.Notify(service.NotifyAlert, msg)
+	}
+	if err := j.alertService.MarkAlertsNotified(notices); err != nil {
+		logger.Warning("Mark alerts notified failed:", err)
+	}
+}
@@ -0,0 +1,46 @@
+package job
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// AnomalyJob checks the traffic of the clients for anomalies and reports new ones to the notification channels.
+type AnomalyJob struct {
+	anomalyService service.AnomalyService
+	notifyService  service.NotifyService
+	tgbotService   service.Tgbot
+}
+
+// NewAnomalyJob creates a new traffic anomaly detection job instance.
+func NewAnomalyJob() *AnomalyJob {
+	return new(AnomalyJob)
+}
+
+// Run detects the anomalies of the previous day and sends the new ones.
+func (j *AnomalyJob) Run() {
+	anomalies, err := j.anomalyService.DetectAnomalies()
+	if err != nil {
+		logger.Warning("Detect traffic anomalies failed:", err)
+		return
+	}
+	if len(anomalies) == 0 {
+		return
+	}
+	var sb strings.Builder
+	sb.WriteString(j.tgbotService.I18nBot("tgbot.messages.anomalies", "Count=="+strconv.Itoa(len(anomalies))))
+	for _, anomaly := range anomalies {
+		key := "tgbot.messages.anomaly" + strings.ToUpper(anomaly.Type[:1]) + anomaly.Type[1:]
+		sb.WriteString(j.tgbotService.I18nBot(key,
+			"Email=="+anomaly.Email,
+			"Day=="+anomaly.Day,
+			"Usage=="+common.FormatTraffic(anomaly.Usage),
+			"Baseline=="+common.FormatTraffic(anomaly.Baseline),
+			"Hours=="+strconv.Itoa(anomaly.ActiveHours)))
+	}
+	j.notifyService.Notify(service.NotifyAnomaly, sb.String())
+}
@@ -0,0 +1,45 @@
+package job
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/scheduler"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// BackupMailJob emails a backup of the database and the Xray configuration to the administrators.
+type BackupMailJob struct {
+	mailService   service.MailService
+	notifyService service.NotifyService
+	tgbotService  service.Tgbot
+}
+
+// NewBackupMailJob creates a new backup email job instance.
+func NewBackupMailJob() *BackupMailJob {
+	return new(BackupMailJob)
+}
+
+// Policy retries a failed backup email, as the mail server may be briefly unreachable.
+func (j *BackupMailJob) Policy() scheduler.Policy {
+	return scheduler.Policy{Timeout: 10 * time.Minute, Retries: 2, RetryDelay: 5 * time.Minute}
+}
+
+// Run sends the backup email.
+func (j *BackupMailJob) Run() {
+	if err := j.Execute(context.Background()); err != nil {
+		logger.Warning("Email backup failed:", err)
+	}
+}
+
+// Execute sends the backup email and reports its failure.
+func (j *BackupMailJob) Execute(ctx context.Context) error {
+	if err := j.mailService.SendBackup(); err != nil {
+		return err
+	}
+	hostname, _ := os.Hostname()
+	j.notifyService.Notify(service.NotifyBackup, j.tgbotService.I18nBot("tgbot.messages.backupDone", "Hostname=="+hostname, "Via==Email"))
+	return nil
+}
@@ -0,0 +1,163 @@
+package job
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// certAlertInterval is how often a renewal failure that persists is alerted again.
+const certAlertInterval = 24 * time.Hour
+
+// CertRenewJob renews the ACME certificates and writes them to their files, and notices when the
+// certificates of the panel, of the subscription server, of the certificate store or of the TLS
+// inbounds are renewed, e.g. by acme.sh. The panel listeners load renewed files by themselves,
+// the inbounds using them are reloaded through the Xray API. Failed renewals are alerted, and
+// certificates about to expire at each of the configured days before they do.
+type CertRenewJob struct {
+	settingService     service.SettingService
+	notifyService      service.NotifyService
+	tgbotService       service.Tgbot
+	acmeService        service.AcmeService
+	certificateService service.CertificateService
+	xrayService        service.XrayService
+
+	serials map[string]string    // Serial numbers of the certificates seen last, by source
+	levels  map[string]int       // Lowest number of days before expiry alerted, by source
+	alerted map[string]time.Time // When a renewal failure was alerted last, by domain
+}
+
+// NewCertRenewJob creates a new certificate renewal job instance.
+func NewCertRenewJob() *CertRenewJob {
+	return &CertRenewJob{
+		serials: make(map[string]string),
+		levels:  make(map[string]int),
+		alerted: make(map[string]time.Time),
+	}
+}
+
+// Run renews the ACME certificates and compares the certificates with those seen on the previous
+// run. The first run only records them.
+func (j *CertRenewJob) Run() {
+	_, failed := j.acmeService.Renew()
+	for domain, err := range failed {
+		logger.Warning("ACME certificate for", domain, "failed:", err)
+		if j.shouldAlert(domain) {
+			msg := j.tgbotService.I18nBot("tgbot.messages.certRenewFailed",
+				"Domains=="+domain,
+				"Error=="+err.Error())
+			j.notifyService.Notify(service.NotifyCert, msg)
+		}
+	}
+	for domain := range j.alerted {
+		if failed[domain] == nil {
+			delete(j.alerted, domain)
+		}
+	}
+
+	certificates, err := j.certificateService.GetCertificateStatus()
+	if err != nil {
+		logger.Warning("Get the certificates in use failed:", err)
+		return
+	}
+	daysSetting, _ := j.settingService.GetCertExpiryDays()
+	expiryDays, err := common.ParseIntList(daysSetting)
+	if err != nil {
+		logger.Warning("Invalid certificate expiry alert days:", err)
+	}
+
+	var reload []string
+	seen := make(map[string]bool, len(certificates))
+	for _, cert := range certificates {
+		seen[cert.Source] = true
+		if cert.Error != "" {
+			logger.Warning("Read certificate", cert.Source, "failed:", cert.Error)
+			continue
+		}
+		domains := strings.Join(cert.Domains, ", ")
+		notAfter := time.UnixMilli(cert.NotAfter).Format("2006-01-02 15:04:05")
+		previous, known := j.serials[cert.Source]
+		j.serials[cert.Source] = cert.Serial
+		if previous != cert.Serial {
+			delete(j.levels, cert.Source)
+		}
+
+		if level := expiryLevel(cert.DaysLeft, expiryDays); level == 0 {
+			delete(j.levels, cert.Source)
+		} else if alerted, ok := j.levels[cert.Source]; !ok || level < alerted {
+			j.levels[cert.Source] = level
+			var msg string
+			if cert.DaysLeft < 0 {
+				msg = j.tgbotService.I18nBot("tgbot.messages.certExpired",
+					"File=="+cert.Source,
+					"Domains=="+domains,
+					"Time=="+notAfter)
+			} else {
+				msg = j.tgbotService.I18nBot("tgbot.messages.certExpiring",
+					"File=="+cert.Source,
+					"Domains=="+domains,
+					"Days=="+strconv.Itoa(cert.DaysLeft),
+					"Time=="+notAfter)
+			}
+			j.notifyService.Notify(service.NotifyCert, msg)
+		}
+
+		// Inline certificates change with the inbound, which applies them itself
+		if !known || previous == cert.Serial || cert.IsInline() {
+			continue
+		}
+		for _, tag := range cert.Inbounds {
+			if !slices.Contains(reload, tag) {
+				reload = append(reload, tag)
+			}
+		}
+		msg := j.tgbotService.I18nBot("tgbot.messages.certRenewed",
+			"File=="+cert.Source,
+			"Domains=="+domains,
+			"Time=="+notAfter)
+		j.notifyService.Notify(service.NotifyCert, msg)
+	}
+	for source := range j.serials {
+		if !seen[source] {
+			delete(j.serials, source)
+			delete(j.levels, source)
+		}
+	}
+
+	if len(reload) > 0 {
+		if err := j.xrayService.ReloadInbounds(reload); err != nil {
+			logger.Warning("Reload the inbounds of the renewed certificates failed:", err)
+		} else {
+			logger.Info("Inbounds reloaded for the renewed certificates:", strings.Join(reload, ", "))
+		}
+	}
+}
+
+// expiryLevel returns the lowest of the alert days a certificate is within, -1 once it expired,
+// or 0 when it is not within any.
+func expiryLevel(daysLeft int, expiryDays []int) int {
+	if daysLeft < 0 {
+		return -1
+	}
+	level := 0
+	for _, days := range expiryDays {
+		if daysLeft < days && (level == 0 || days < level) {
+			level = days
+		}
+	}
+	return level
+}
+
+// shouldAlert reports whether a failure is to be alerted, the first time and then once a day.
+func (j *CertRenewJob) shouldAlert(key string) bool {
+	if last, ok := j.alerted[key]; ok && time.Since(last) < certAlertInterval {
+		return false
+	}
+	j.alerted[key] = time.Now()
+	return true
+}
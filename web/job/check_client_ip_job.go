@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"io"
 	"log"
+	"net/netip"
 	"os"
 	"os/exec"
 	"regexp"
@@ -15,20 +16,33 @@ import (
 	"github.com/mhsanaei/3x-ui/v2/database"
 	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
 	"github.com/mhsanaei/3x-ui/v2/xray"
 )
 
 // CheckClientIpJob monitors client IP addresses from access logs and manages IP blocking based on configured limits.
+// IPs are counted over a sliding window. Clients above their limit are either suspended in the
+// running Xray instance until they are back within the limit, or reported to Fail2Ban.
 type CheckClientIpJob struct {
 	lastClear     int64
 	disAllowedIps []string
+
+	inboundService service.InboundService
+	settingService service.SettingService
+	xrayService    service.XrayService
+	ipLimitAction  string
+	seenIps        map[string]map[string]int64 // email -> IP -> last seen, unix seconds
+	suspended      map[string]int64            // email -> suspended at, unix seconds
 }
 
 var job *CheckClientIpJob
 
 // NewCheckClientIpJob creates a new client IP monitoring job instance.
 func NewCheckClientIpJob() *CheckClientIpJob {
-	job = new(CheckClientIpJob)
+	job = &CheckClientIpJob{
+		seenIps:   make(map[string]map[string]int64),
+		suspended: make(map[string]int64),
+	}
 	return job
 }
 
@@ -39,26 +53,27 @@ func (j *CheckClientIpJob) Run() {
 
 	shouldClearAccessLog := false
 	iplimitActive := j.hasLimitIp()
-	f2bInstalled := j.checkFail2BanInstalled()
 	isAccessLogAvailable := j.checkAccessLogAvailable(iplimitActive)
+	j.ipLimitAction, _ = j.settingService.GetIpLimitAction()
 
 	if isAccessLogAvailable {
-		if runtime.GOOS == "windows" {
+		if runtime.GOOS == "windows" || j.ipLimitAction != "fail2ban" {
 			if iplimitActive {
 				shouldClearAccessLog = j.processLogFile()
 			}
 		} else {
 			if iplimitActive {
-				if f2bInstalled {
+				if j.checkFail2BanInstalled() {
 					shouldClearAccessLog = j.processLogFile()
 				} else {
-					if !f2bInstalled {
-						logger.Warning("[LimitIP] Fail2Ban is not installed, Please install Fail2Ban from the x-ui bash menu.")
-					}
+					logger.Warning("[LimitIP] Fail2Ban is not installed, Please install Fail2Ban from the x-ui bash menu.")
 				}
 			}
 		}
 	}
+	if !iplimitActive || !isAccessLogAvailable {
+		j.resumeAllClients()
+	}
 
 	if shouldClearAccessLog || (isAccessLogAvailable && time.Now().Unix()-j.lastClear > 3600) {
 		j.clearAccessLog()
@@ -115,6 +130,29 @@ func (j *CheckClientIpJob) hasLimitIp() bool {
 	return false
 }
 
+// ipLimitIPv6Prefix is the length of the IPv6 networks clients are counted by, as a device
+// changes its address within its /64, e.g. with privacy extensions.
+const ipLimitIPv6Prefix = 64
+
+// clientIpKey returns what an IP of a client is counted as: IPv4 addresses, also when mapped
+// to IPv6, as they are and IPv6 addresses as their /64 network. It is empty for loopback and
+// invalid addresses.
+func clientIpKey(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap().WithZone("")
+	if addr.IsLoopback() {
+		return ""
+	}
+	if addr.Is6() {
+		prefix, _ := addr.Prefix(ipLimitIPv6Prefix)
+		return prefix.String()
+	}
+	return addr.String()
+}
+
 func (j *CheckClientIpJob) processLogFile() bool {
 
 	ipRegex := regexp.MustCompile(`from (?:tcp:|udp:)?\[?([0-9a-fA-F\.:]+)\]?:\d+ accepted`)
@@ -124,7 +162,11 @@ func (j *CheckClientIpJob) processLogFile() bool {
 	file, _ := os.Open(accessLogPath)
 	defer file.Close()
 
-	inboundClientIps := make(map[string]map[string]struct{}, 100)
+	now := time.Now().Unix()
+	window, err := j.settingService.GetIpLimitWindow()
+	if err != nil || window <= 0 {
+		window = 5
+	}
 
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
@@ -135,9 +177,8 @@ func (j *CheckClientIpJob) processLogFile() bool {
 			continue
 		}
 
-		ip := ipMatches[1]
-
-		if ip == "127.0.0.1" || ip == "::1" {
+		ip := clientIpKey(ipMatches[1])
+		if ip == "" {
 			continue
 		}
 
@@ -147,14 +188,35 @@ func (j *CheckClientIpJob) processLogFile() bool {
 		}
 		email := emailMatches[1]
 
-		if _, exists := inboundClientIps[email]; !exists {
-			inboundClientIps[email] = make(map[string]struct{})
+		seen := now
+		if len(line) >= 19 {
+			if t, err := time.ParseInLocation("2006/01/02 15:04:05", line[:19], time.Local); err == nil {
+				seen = t.Unix()
+			}
+		}
+		if _, exists := j.seenIps[email]; !exists {
+			j.seenIps[email] = make(map[string]int64)
+		}
+		if seen > j.seenIps[email][ip] {
+			j.seenIps[email][ip] = seen
+		}
+	}
+
+	// Forget the IPs that were not seen within the window
+	since := now - int64(window)*60
+	for email, ips := range j.seenIps {
+		for ip, seen := range ips {
+			if seen < since {
+				delete(ips, ip)
+			}
+		}
+		if len(ips) == 0 {
+			delete(j.seenIps, email)
 		}
-		inboundClientIps[email][ip] = struct{}{}
 	}
 
 	shouldCleanLog := false
-	for email, uniqueIps := range inboundClientIps {
+	for email, uniqueIps := range j.seenIps {
 
 		ips := make([]string, 0, len(uniqueIps))
 		for ip := range uniqueIps {
@@ -170,10 +232,59 @@ func (j *CheckClientIpJob) processLogFile() bool {
 
 		shouldCleanLog = j.updateInboundClientIps(clientIpsRecord, email, ips) || shouldCleanLog
 	}
+	j.resumeClients()
 
 	return shouldCleanLog
 }
 
+// suspendClient takes a client over its IP limit out of the running Xray instance.
+func (j *CheckClientIpJob) suspendClient(email string, ipCount int, limitIp int) {
+	if _, ok := j.suspended[email]; ok {
+		return
+	}
+	needRestart, err := j.inboundService.SetClientSuspended(email, true)
+	if err != nil {
+		logger.Warning("[LimitIP] Failed to suspend client", email, ":", err)
+	}
+	if needRestart {
+		j.xrayService.SetToNeedRestart()
+		return
+	}
+	j.suspended[email] = time.Now().Unix()
+	logger.Infof("[LimitIP] Client %s suspended, %d IPs used with a limit of %d", email, ipCount, limitIp)
+}
+
+// resumeClients serves the suspended clients again once they are back within their IP limit.
+// Suspended clients cannot connect, so their IPs age out of the window.
+func (j *CheckClientIpJob) resumeClients() {
+	for email := range j.suspended {
+		_, client, err := j.inboundService.GetClientByEmail(email)
+		if err == nil && client.LimitIP > 0 && len(j.seenIps[email]) > client.LimitIP {
+			continue
+		}
+		j.resumeClient(email)
+	}
+}
+
+// resumeAllClients serves every suspended client again, e.g. after the IP limit was turned off.
+func (j *CheckClientIpJob) resumeAllClients() {
+	for email := range j.suspended {
+		j.resumeClient(email)
+	}
+}
+
+func (j *CheckClientIpJob) resumeClient(email string) {
+	needRestart, err := j.inboundService.SetClientSuspended(email, false)
+	if err != nil {
+		logger.Warning("[LimitIP] Failed to resume client", email, ":", err)
+	}
+	if needRestart {
+		j.xrayService.SetToNeedRestart()
+	}
+	delete(j.suspended, email)
+	logger.Infof("[LimitIP] Client %s resumed", email)
+}
+
 func (j *CheckClientIpJob) checkFail2BanInstalled() bool {
 	cmd := "fail2ban-client"
 	args := []string{"-h"}
@@ -287,6 +398,9 @@ func (j *CheckClientIpJob) updateInboundClientIps(inboundClientIps *model.Inboun
 					for i := limitIp; i < len(ips); i++ {
 						log.Printf("[LIMIT_IP] Email = %s || SRC = %s", clientEmail, ips[i])
 					}
+					if j.ipLimitAction != "fail2ban" {
+						j.suspendClient(clientEmail, len(ips), limitIp)
+					}
 				}
 			}
 		}
@@ -9,9 +9,10 @@ import (
 	"github.com/shirou/gopsutil/v4/cpu"
 )
 
-// CheckCpuJob monitors CPU usage and sends Telegram notifications when usage exceeds the configured threshold.
+// CheckCpuJob monitors CPU usage and sends notifications when usage exceeds the configured threshold.
 type CheckCpuJob struct {
 	tgbotService   service.Tgbot
+	notifyService  service.NotifyService
 	settingService service.SettingService
 }
 
@@ -20,7 +21,7 @@ func NewCheckCpuJob() *CheckCpuJob {
 	return new(CheckCpuJob)
 }
 
-// Run checks CPU usage over the last minute and sends a Telegram alert if it exceeds the threshold.
+// Run checks CPU usage over the last minute and sends an alert if it exceeds the threshold.
 func (j *CheckCpuJob) Run() {
 	threshold, _ := j.settingService.GetTgCpu()
 
@@ -31,6 +32,6 @@ func (j *CheckCpuJob) Run() {
 			"Percent=="+strconv.FormatFloat(percent[0], 'f', 2, 64),
 			"Threshold=="+strconv.Itoa(threshold))
 
-		j.tgbotService.SendMsgToTgbotAdmins(msg)
+		j.notifyService.Notify(service.NotifyCpu, msg)
 	}
 }
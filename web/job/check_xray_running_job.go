@@ -3,14 +3,33 @@
 package job
 
 import (
+	"html"
+	"strconv"
+	"time"
+
 	"github.com/mhsanaei/3x-ui/v2/logger"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
 )
 
-// CheckXrayRunningJob monitors Xray process health and restarts it if it crashes.
+const (
+	// crashBackoffMin is the delay before the first restart after a crash, doubled for every failed attempt.
+	crashBackoffMin = 2 * time.Second
+	crashBackoffMax = 5 * time.Minute
+	// crashStableTime is how long the core has to run before the backoff starts over.
+	crashStableTime = time.Minute
+)
+
+// CheckXrayRunningJob supervises the core process. When it exits unexpectedly the crash is recorded
+// and reported, and the core is restarted with an exponential backoff while it keeps crashing.
 type CheckXrayRunningJob struct {
-	xrayService service.XrayService
-	checkTime   int
+	xrayService   service.XrayService
+	notifyService service.NotifyService
+	tgbotService  service.Tgbot
+
+	crashed      bool // The current crash has been recorded
+	attempts     int  // Restart attempts since the core last ran stable
+	nextRestart  time.Time
+	runningSince time.Time
 }
 
 // NewCheckXrayRunningJob creates a new Xray health check job instance.
@@ -18,19 +37,64 @@ func NewCheckXrayRunningJob() *CheckXrayRunningJob {
 	return new(CheckXrayRunningJob)
 }
 
-// Run checks if Xray has crashed and restarts it after confirming it's down for 2 consecutive checks.
+// Run records a crashed core and restarts it once its backoff delay has passed.
 func (j *CheckXrayRunningJob) Run() {
+	now := time.Now()
 	if !j.xrayService.DidXrayCrash() {
-		j.checkTime = 0
-	} else {
-		j.checkTime++
-		// only restart if it's down 2 times in a row
-		if j.checkTime > 1 {
-			err := j.xrayService.RestartXray(false)
-			j.checkTime = 0
-			if err != nil {
-				logger.Error("Restart xray failed:", err)
-			}
+		if j.crashed {
+			// Started again from the panel
+			j.crashed = false
+			j.runningSince = now
+		}
+		if j.attempts > 0 && now.Sub(j.runningSince) > crashStableTime {
+			j.attempts = 0
 		}
+		return
+	}
+
+	if !j.crashed {
+		j.crashed = true
+		j.recordCrash()
+		j.nextRestart = now.Add(crashBackoff(j.attempts))
+	}
+	if now.Before(j.nextRestart) {
+		return
+	}
+
+	j.attempts++
+	err := j.xrayService.RestartXray(false)
+	// A core that fails to start or exits again is recorded as a new crash
+	j.crashed = false
+	j.runningSince = now
+	if err != nil {
+		logger.Error("Restart xray failed:", err)
+	}
+}
+
+// recordCrash stores the crash event and notifies the administrators.
+func (j *CheckXrayRunningJob) recordCrash() {
+	event, err := j.xrayService.RecordCrash(j.attempts)
+	if err != nil {
+		logger.Warning("record core crash failed:", err)
+	}
+	logger.Errorf("Core stopped unexpectedly (restart attempt %d): %s", j.attempts, event.Error)
+	output := event.Output
+	if len(output) > 1500 {
+		output = output[len(output)-1500:]
+	}
+	msg := j.tgbotService.I18nBot("tgbot.messages.coreCrashed",
+		"Error=="+html.EscapeString(event.Error),
+		"Attempt=="+strconv.Itoa(j.attempts),
+		"Delay=="+crashBackoff(j.attempts).String(),
+		"Output=="+html.EscapeString(output))
+	j.notifyService.Notify(service.NotifyCrash, msg)
+}
+
+// crashBackoff returns the delay before the restart that follows the given number of attempts.
+func crashBackoff(attempts int) time.Duration {
+	delay := crashBackoffMin
+	for i := 0; i < attempts && delay < crashBackoffMax; i++ {
+		delay *= 2
 	}
+	return min(delay, crashBackoffMax)
 }
@@ -0,0 +1,20 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// ClientNotifyJob notifies about clients that are close to their expiry or traffic quota.
+type ClientNotifyJob struct {
+	tgbotService service.Tgbot
+}
+
+// NewClientNotifyJob creates a new client notification job instance.
+func NewClientNotifyJob() *ClientNotifyJob {
+	return new(ClientNotifyJob)
+}
+
+// Run sends the expiry and quota notices that are due.
+func (j *ClientNotifyJob) Run() {
+	j.tgbotService.NotifyClientThresholds()
+}
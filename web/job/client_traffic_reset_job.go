@@ -0,0 +1,32 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// ClientTrafficResetJob resets the traffic of clients according to their reset policy.
+type ClientTrafficResetJob struct {
+	inboundService service.InboundService
+	xrayService    service.XrayService
+}
+
+// NewClientTrafficResetJob creates a new client traffic reset job instance.
+func NewClientTrafficResetJob() *ClientTrafficResetJob {
+	return new(ClientTrafficResetJob)
+}
+
+// Run resets the traffic of every client whose scheduled reset is due.
+func (j *ClientTrafficResetJob) Run() {
+	needRestart, count, err := j.inboundService.ResetScheduledClientTraffics()
+	if err != nil {
+		logger.Warning("Failed to reset scheduled client traffics:", err)
+		return
+	}
+	if count > 0 {
+		logger.Infof("Scheduled client traffic reset completed: %d clients reset", count)
+	}
+	if needRestart {
+		j.xrayService.SetToNeedRestart()
+	}
+}
@@ -0,0 +1,85 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// ConnectionLimitJob enforces the maximum number of simultaneous connections of inbounds.
+// Xray has no connection cap of its own, so an inbound that reaches its limit is taken out of
+// the running core until enough connections have closed, and then added back.
+//
+// The limit is inbound-wide, not per client: a paused inbound refuses new connections from all
+// of its clients alike. Only established TCP connections are counted, so UDP transports such as
+// mKCP, QUIC or Hysteria are never limited.
+type ConnectionLimitJob struct {
+	inboundService service.InboundService
+	xrayService    service.XrayService
+	paused         map[int]bool
+}
+
+// NewConnectionLimitJob creates a new connection limit job instance.
+func NewConnectionLimitJob() *ConnectionLimitJob {
+	return &ConnectionLimitJob{
+		paused: make(map[int]bool),
+	}
+}
+
+// Run compares the connection counts of limited inbounds with their limits and pauses or resumes them.
+func (j *ConnectionLimitJob) Run() {
+	if !j.xrayService.IsXrayRunning() {
+		// A restarted core serves every enabled inbound again
+		clear(j.paused)
+		return
+	}
+
+	inbounds, err := j.inboundService.GetAllInbounds()
+	if err != nil {
+		logger.Warning("Failed to get inbounds for connection limit check:", err)
+		return
+	}
+
+	limited := len(j.paused) > 0
+	for _, inbound := range inbounds {
+		limited = limited || inbound.MaxConnections > 0
+	}
+	if !limited {
+		return
+	}
+
+	counts, err := j.inboundService.GetInboundConnections(inbounds)
+	if err != nil {
+		logger.Warning("Failed to get inbound connections:", err)
+		return
+	}
+
+	active := make(map[int]bool, len(j.paused))
+	for _, inbound := range inbounds {
+		if !inbound.Enable {
+			continue
+		}
+		if inbound.MaxConnections > 0 && counts[inbound.Id] >= inbound.MaxConnections {
+			// Pause again even if already paused, an edit of the inbound adds it back to the core
+			err := j.inboundService.SetInboundPaused(inbound, true)
+			if !j.paused[inbound.Id] {
+				if err != nil {
+					logger.Warning("Failed to pause inbound", inbound.Id, ":", err)
+					continue
+				}
+				logger.Infof("Inbound %s reached its connection limit (%d/%d), pausing new connections", inbound.Remark, counts[inbound.Id], inbound.MaxConnections)
+			}
+			active[inbound.Id] = true
+			continue
+		}
+		if !j.paused[inbound.Id] {
+			continue
+		}
+		if err := j.inboundService.SetInboundPaused(inbound, false); err != nil {
+			logger.Warning("Failed to resume inbound", inbound.Id, ":", err)
+			active[inbound.Id] = true
+			continue
+		}
+		logger.Infof("Inbound %s is below its connection limit again, accepting new connections", inbound.Remark)
+	}
+	j.paused = active
+}
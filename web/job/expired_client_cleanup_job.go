@@ -0,0 +1,38 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// ExpiredClientCleanupJob deletes expired clients once their grace period is over.
+type ExpiredClientCleanupJob struct {
+	inboundService service.InboundService
+	settingService service.SettingService
+	xrayService    service.XrayService
+}
+
+// NewExpiredClientCleanupJob creates a new expired client cleanup job instance.
+func NewExpiredClientCleanupJob() *ExpiredClientCleanupJob {
+	return new(ExpiredClientCleanupJob)
+}
+
+// Run deletes the clients that have been expired for longer than the configured grace period.
+func (j *ExpiredClientCleanupJob) Run() {
+	days, err := j.settingService.GetExpiryGraceDays()
+	if err != nil || days <= 0 {
+		return
+	}
+
+	needRestart, count, err := j.inboundService.DelExpiredClients(days)
+	if err != nil {
+		logger.Warning("Failed to delete expired clients:", err)
+		return
+	}
+	if count > 0 {
+		logger.Infof("%d expired clients deleted", count)
+	}
+	if needRestart {
+		j.xrayService.SetToNeedRestart()
+	}
+}
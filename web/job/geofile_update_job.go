@@ -0,0 +1,41 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/scheduler"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// GeofileUpdateJob downloads updated geoip/geosite databases and restarts Xray when they changed.
+type GeofileUpdateJob struct {
+	serverService service.ServerService
+}
+
+// NewGeofileUpdateJob creates a new geofile update job instance.
+func NewGeofileUpdateJob() *GeofileUpdateJob {
+	return new(GeofileUpdateJob)
+}
+
+// Policy gives the downloads time to complete and retries a failed update.
+func (j *GeofileUpdateJob) Policy() scheduler.Policy {
+	return scheduler.Policy{Timeout: 15 * time.Minute, Retries: 2, RetryDelay: 10 * time.Minute}
+}
+
+// Run updates all geofiles and logs which outcome the update had.
+func (j *GeofileUpdateJob) Run() {
+	if err := j.Execute(context.Background()); err != nil {
+		logger.Warning("Scheduled geofile update failed:", err)
+	}
+}
+
+// Execute updates all geofiles and reports the failure of the update.
+func (j *GeofileUpdateJob) Execute(ctx context.Context) error {
+	changed, err := j.serverService.UpdateGeofilesIfChanged()
+	if changed {
+		logger.Info("Geofiles updated, Xray restarted")
+	}
+	return err
+}
@@ -1,8 +1,6 @@
 package job
 
 import (
-	"time"
-
 	"strings"
 
 	"github.com/mhsanaei/3x-ui/v2/database/model"
@@ -195,7 +193,7 @@ func (j *LdapSyncJob) buildClient(ib *model.Inbound, email string, defGB, defExp
 		TotalGB: int64(defGB),
 	}
 	if defExpiryDays > 0 {
-		c.ExpiryTime = time.Now().Add(time.Duration(defExpiryDays) * 24 * time.Hour).UnixMilli()
+		c.ExpiryTime = j.settingService.ExpiryAfterDays(defExpiryDays)
 	}
 	switch ib.Protocol {
 	case model.Trojan, model.Shadowsocks:
@@ -358,7 +356,7 @@ func (j *LdapSyncJob) ensureClientExists(inboundTag string, email string, defGB
 		TotalGB: int64(defGB),
 	}
 	if defExpiryDays > 0 {
-		newClient.ExpiryTime = time.Now().Add(time.Duration(defExpiryDays) * 24 * time.Hour).UnixMilli()
+		newClient.ExpiryTime = j.settingService.ExpiryAfterDays(defExpiryDays)
 	}
 
 	switch target.Protocol {
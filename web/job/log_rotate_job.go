@@ -0,0 +1,24 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// LogRotateJob rotates the log of the panel and the logs of Xray that exceed the configured
+// size or age.
+type LogRotateJob struct {
+	logRotateService service.LogRotateService
+}
+
+// NewLogRotateJob creates a new log rotation job instance.
+func NewLogRotateJob() *LogRotateJob {
+	return new(LogRotateJob)
+}
+
+// Run rotates the logs that are due.
+func (j *LogRotateJob) Run() {
+	if err := j.logRotateService.RotateLogs(); err != nil {
+		logger.Warning("rotate logs failed:", err)
+	}
+}
@@ -0,0 +1,23 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// MetricsPushJob writes the panel metrics to an InfluxDB or Prometheus remote-write endpoint.
+type MetricsPushJob struct {
+	metricsService service.MetricsService
+}
+
+// NewMetricsPushJob creates a new metrics push job instance.
+func NewMetricsPushJob() *MetricsPushJob {
+	return new(MetricsPushJob)
+}
+
+// Run pushes the current metrics.
+func (j *MetricsPushJob) Run() {
+	if err := j.metricsService.PushMetrics(); err != nil {
+		logger.Warning("Push metrics failed:", err)
+	}
+}
@@ -0,0 +1,21 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// NodeAgentLimitJob enforces the limits of the clients managed by the central panel while this
+// server, as a node, cannot reach it.
+type NodeAgentLimitJob struct {
+	nodeAgentService service.NodeAgentService
+}
+
+// NewNodeAgentLimitJob creates a new node agent limit job instance.
+func NewNodeAgentLimitJob() *NodeAgentLimitJob {
+	return new(NodeAgentLimitJob)
+}
+
+// Run disables the managed clients that ran out of traffic or expired during an outage.
+func (j *NodeAgentLimitJob) Run() {
+	j.nodeAgentService.EnforceClientLimits()
+}
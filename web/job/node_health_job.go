@@ -0,0 +1,20 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// NodeHealthJob checks whether the managed nodes keep syncing and their inbounds are reachable.
+type NodeHealthJob struct {
+	nodeService service.NodeService
+}
+
+// NewNodeHealthJob creates a new node health check job instance.
+func NewNodeHealthJob() *NodeHealthJob {
+	return new(NodeHealthJob)
+}
+
+// Run checks the health of all enabled nodes.
+func (j *NodeHealthJob) Run() {
+	j.nodeService.CheckNodesHealth()
+}
@@ -0,0 +1,20 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// NodeSyncJob pulls the traffic of the nodes managed by the panel and pushes their inbounds.
+type NodeSyncJob struct {
+	nodeService service.NodeService
+}
+
+// NewNodeSyncJob creates a new node sync job instance.
+func NewNodeSyncJob() *NodeSyncJob {
+	return new(NodeSyncJob)
+}
+
+// Run syncs all enabled nodes.
+func (j *NodeSyncJob) Run() {
+	j.nodeService.SyncNodes()
+}
@@ -0,0 +1,20 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// NotifyDigestJob sends the notifications held back by quiet hours and batching once they are due.
+type NotifyDigestJob struct {
+	notifyService service.NotifyService
+}
+
+// NewNotifyDigestJob creates a new notification digest job instance.
+func NewNotifyDigestJob() *NotifyDigestJob {
+	return new(NotifyDigestJob)
+}
+
+// Run sends the digests that are due.
+func (j *NotifyDigestJob) Run() {
+	j.notifyService.FlushPendingNotifications()
+}
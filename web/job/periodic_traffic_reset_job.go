@@ -11,6 +11,7 @@ type Period string
 // PeriodicTrafficResetJob resets traffic statistics for inbounds based on their configured reset period.
 type PeriodicTrafficResetJob struct {
 	inboundService service.InboundService
+	xrayService    service.XrayService
 	period         Period
 }
 
@@ -35,17 +36,20 @@ func (j *PeriodicTrafficResetJob) Run() {
 	logger.Infof("Running periodic traffic reset job for period: %s (%d matching inbounds)", j.period, len(inbounds))
 
 	resetCount := 0
+	needRestart := false
 
 	for _, inbound := range inbounds {
-		resetInboundErr := j.inboundService.ResetAllTraffics()
+		restart, resetInboundErr := j.inboundService.ResetInboundTraffic(inbound.Id)
 		if resetInboundErr != nil {
 			logger.Warning("Failed to reset traffic for inbound", inbound.Id, ":", resetInboundErr)
 		}
+		needRestart = needRestart || restart
 
-		resetClientErr := j.inboundService.ResetAllClientTraffics(inbound.Id)
+		restart, resetClientErr := j.inboundService.ResetAllClientTraffics(inbound.Id)
 		if resetClientErr != nil {
 			logger.Warning("Failed to reset traffic for all users of inbound", inbound.Id, ":", resetClientErr)
 		}
+		needRestart = needRestart || restart
 
 		if resetInboundErr == nil && resetClientErr == nil {
 			resetCount++
@@ -55,4 +59,8 @@ func (j *PeriodicTrafficResetJob) Run() {
 	if resetCount > 0 {
 		logger.Infof("Periodic traffic reset completed: %d inbounds reset", resetCount)
 	}
+
+	if needRestart {
+		j.xrayService.SetToNeedRestart()
+	}
 }
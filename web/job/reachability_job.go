@@ -0,0 +1,30 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// ReachabilityJob checks that the enabled inbounds can be reached from the outside.
+type ReachabilityJob struct {
+	reachabilityService service.ReachabilityService
+}
+
+// NewReachabilityJob creates a new inbound reachability job instance.
+func NewReachabilityJob() *ReachabilityJob {
+	return new(ReachabilityJob)
+}
+
+// Run checks the inbounds and logs the ones that failed.
+func (j *ReachabilityJob) Run() {
+	results, err := j.reachabilityService.CheckInbounds()
+	if err != nil {
+		logger.Warning("Inbound reachability check failed:", err)
+		return
+	}
+	for _, result := range results {
+		if !result.OK() {
+			logger.Warning("Inbound", result.Tag, "is not reachable at", result.Address+":", result.Error)
+		}
+	}
+}
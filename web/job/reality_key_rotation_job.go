@@ -0,0 +1,68 @@
+package job
+
+import (
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// RealityKeyRotationJob rotates the REALITY key pairs of inbounds once the configured interval has passed
+// and retires the previous keys once their grace period is over.
+type RealityKeyRotationJob struct {
+	inboundService service.InboundService
+	settingService service.SettingService
+	xrayService    service.XrayService
+}
+
+// NewRealityKeyRotationJob creates a new REALITY key rotation job instance.
+func NewRealityKeyRotationJob() *RealityKeyRotationJob {
+	return new(RealityKeyRotationJob)
+}
+
+// Run retires the previous REALITY keys whose grace period is over, then rotates the keys of every
+// enabled REALITY inbound whose keys are older than the rotation interval.
+func (j *RealityKeyRotationJob) Run() {
+	needRestart, err := j.inboundService.RetireRealityKeys()
+	if err != nil {
+		logger.Warning("Failed to retire previous REALITY keys:", err)
+	}
+	if needRestart {
+		j.xrayService.SetToNeedRestart()
+	}
+
+	days, err := j.settingService.GetRealityKeyRotation()
+	if err != nil || days <= 0 {
+		return
+	}
+
+	inbounds, err := j.inboundService.GetAllInbounds()
+	if err != nil {
+		logger.Warning("Failed to get inbounds for REALITY key rotation:", err)
+		return
+	}
+
+	interval := int64(days) * 24 * int64(time.Hour/time.Millisecond)
+	now := time.Now().UnixMilli()
+	needRestart = false
+	for _, inbound := range inbounds {
+		if !inbound.Enable {
+			continue
+		}
+		rotatedAt, isReality := j.inboundService.GetRealityKeyRotatedAt(inbound)
+		if !isReality || now-rotatedAt < interval {
+			continue
+		}
+		_, restart, err := j.inboundService.RotateRealityKeys(inbound.Id)
+		if err != nil {
+			logger.Warning("Failed to rotate REALITY keys of inbound", inbound.Id, ":", err)
+			continue
+		}
+		logger.Infof("REALITY keys of inbound %s rotated", inbound.Remark)
+		needRestart = needRestart || restart
+	}
+
+	if needRestart {
+		j.xrayService.SetToNeedRestart()
+	}
+}
@@ -0,0 +1,38 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/scheduler"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// SpeedtestJob measures the latency and throughput of the server at the configured interval.
+type SpeedtestJob struct {
+	speedtestService service.SpeedtestService
+}
+
+// NewSpeedtestJob creates a new speedtest job instance.
+func NewSpeedtestJob() *SpeedtestJob {
+	return new(SpeedtestJob)
+}
+
+// Policy gives the throughput tests of every target time to complete.
+func (j *SpeedtestJob) Policy() scheduler.Policy {
+	return scheduler.Policy{Timeout: 15 * time.Minute}
+}
+
+// Run tests the configured targets and stores the results.
+func (j *SpeedtestJob) Run() {
+	if err := j.Execute(context.Background()); err != nil {
+		logger.Warning("Scheduled speedtest failed:", err)
+	}
+}
+
+// Execute tests the configured targets, stores the results and reports a failure.
+func (j *SpeedtestJob) Execute(ctx context.Context) error {
+	_, err := j.speedtestService.RunSpeedtest()
+	return err
+}
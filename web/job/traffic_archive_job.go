@@ -0,0 +1,23 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// TrafficArchiveJob moves aged traffic history from the database to archive files.
+type TrafficArchiveJob struct {
+	trafficArchiveService service.TrafficArchiveService
+}
+
+// NewTrafficArchiveJob creates a new traffic archive job instance.
+func NewTrafficArchiveJob() *TrafficArchiveJob {
+	return new(TrafficArchiveJob)
+}
+
+// Run archives the traffic history older than the archive age, when archiving is enabled.
+func (j *TrafficArchiveJob) Run() {
+	if err := j.trafficArchiveService.Archive(); err != nil {
+		logger.Warning("archive traffic history failed:", err)
+	}
+}
@@ -0,0 +1,23 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// TrafficSampleJob downsamples the traffic time series of inbounds and clients.
+type TrafficSampleJob struct {
+	trafficSampleService service.TrafficSampleService
+}
+
+// NewTrafficSampleJob creates a new traffic sample job instance.
+func NewTrafficSampleJob() *TrafficSampleJob {
+	return new(TrafficSampleJob)
+}
+
+// Run rolls aged hourly and daily samples up into coarser ones and deletes expired monthly samples.
+func (j *TrafficSampleJob) Run() {
+	if err := j.trafficSampleService.Downsample(); err != nil {
+		logger.Warning("downsample traffic samples failed:", err)
+	}
+}
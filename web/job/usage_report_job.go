@@ -0,0 +1,24 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// UsageReportJob sends the usage report of the period that just ended to the administrators.
+type UsageReportJob struct {
+	period        string
+	reportService service.ReportService
+}
+
+// NewUsageReportJob creates a new usage report job for the daily or weekly period.
+func NewUsageReportJob(period string) *UsageReportJob {
+	return &UsageReportJob{period: period}
+}
+
+// Run builds and sends the usage report.
+func (j *UsageReportJob) Run() {
+	if err := j.reportService.SendUsageReport(j.period); err != nil {
+		logger.Warning("Send", j.period, "usage report failed:", err)
+	}
+}
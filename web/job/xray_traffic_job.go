@@ -2,8 +2,10 @@ package job
 
 import (
 	"encoding/json"
+	"strconv"
 
 	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
 	"github.com/mhsanaei/3x-ui/v2/xray"
 
@@ -11,11 +13,19 @@ import (
 )
 
 // XrayTrafficJob collects and processes traffic statistics from Xray, updating the database and optionally informing external APIs.
+// It runs every trafficStatsInterval seconds. Each run reads and resets the counters through the
+// StatsService API of Xray; what is counted after the last run is collected by XrayService when it
+// stops or restarts the core.
 type XrayTrafficJob struct {
-	settingService  service.SettingService
-	xrayService     service.XrayService
-	inboundService  service.InboundService
-	outboundService service.OutboundService
+	settingService service.SettingService
+	xrayService    service.XrayService
+	inboundService service.InboundService
+	notifyService  service.NotifyService
+	tgbotService   service.Tgbot
+
+	// depletedInbounds holds the IDs of inbounds already reported as depleted,
+	// it stays nil until the first run so inbounds depleted before startup are not reported
+	depletedInbounds map[int]bool
 }
 
 // NewXrayTrafficJob creates a new traffic collection job instance.
@@ -32,22 +42,45 @@ func (j *XrayTrafficJob) Run() {
 	if err != nil {
 		return
 	}
-	err, needRestart0 := j.inboundService.AddTraffic(traffics, clientTraffics)
-	if err != nil {
-		logger.Warning("add inbound traffic failed:", err)
-	}
-	err, needRestart1 := j.outboundService.AddTraffic(traffics, clientTraffics)
+	j.inboundService.BufferTraffic(traffics, clientTraffics)
+	needRestart, err := j.inboundService.FlushTraffic(false)
 	if err != nil {
-		logger.Warning("add outbound traffic failed:", err)
+		logger.Warning("add traffic failed:", err)
 	}
 	if ExternalTrafficInformEnable, err := j.settingService.GetExternalTrafficInformEnable(); ExternalTrafficInformEnable {
 		j.informTrafficToExternalAPI(traffics, clientTraffics)
 	} else if err != nil {
 		logger.Warning("get ExternalTrafficInformEnable failed:", err)
 	}
-	if needRestart0 || needRestart1 {
+	if needRestart {
 		j.xrayService.SetToNeedRestart()
 	}
+	j.notifyDepletedInbounds()
+}
+
+// notifyDepletedInbounds informs the admins about inbounds disabled for reaching their total traffic limit.
+// Every inbound is reported once until its traffic is reset.
+func (j *XrayTrafficJob) notifyDepletedInbounds() {
+	inbounds, err := j.inboundService.GetDepletedInbounds()
+	if err != nil {
+		logger.Warning("get depleted inbounds failed:", err)
+		return
+	}
+
+	depleted := make(map[int]bool, len(inbounds))
+	for _, inbound := range inbounds {
+		depleted[inbound.Id] = true
+		if j.depletedInbounds == nil || j.depletedInbounds[inbound.Id] {
+			continue
+		}
+		logger.Infof("Inbound %s disabled: total traffic limit of %s reached", inbound.Remark, common.FormatTraffic(inbound.Total))
+		msg := j.tgbotService.I18nBot("tgbot.messages.inboundDepleted",
+			"Remark=="+inbound.Remark,
+			"Port=="+strconv.Itoa(inbound.Port),
+			"Total=="+common.FormatTraffic(inbound.Total))
+		j.notifyService.Notify(service.NotifyDepleted, msg)
+	}
+	j.depletedInbounds = depleted
 }
 
 func (j *XrayTrafficJob) informTrafficToExternalAPI(inboundTraffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) {
@@ -4,6 +4,7 @@ package locale
 
 import (
 	"embed"
+	"fmt"
 	"io/fs"
 	"os"
 	"strings"
@@ -20,6 +21,13 @@ var (
 	i18nBundle   *i18n.Bundle
 	LocalizerWeb *i18n.Localizer
 	LocalizerBot *i18n.Localizer
+
+	// translationFS and localeSettings are kept to reload the translations when the customized messages change
+	translationFS  *embed.FS
+	localeSettings SettingService
+
+	// messageOverrides holds the customized messages, keyed by language tag and message ID
+	messageOverrides map[string]map[string]string
 )
 
 // I18nType represents the type of interface for internationalization.
@@ -45,6 +53,11 @@ func InitLocalizer(i18nFS embed.FS, settingService SettingService) error {
 	if err := parseTranslationFiles(i18nFS, i18nBundle); err != nil {
 		return err
 	}
+	translationFS = &i18nFS
+	localeSettings = settingService
+
+	// customized messages take precedence over the translation files
+	applyMessageOverrides(i18nBundle)
 
 	// setup bot locale
 	if err := initTGBotLocalizer(settingService); err != nil {
@@ -54,6 +67,59 @@ func InitLocalizer(i18nFS embed.FS, settingService SettingService) error {
 	return nil
 }
 
+// SetMessageOverrides replaces the customized messages, keyed by language tag such as "en-US"
+// and message ID, and reloads the translations with them.
+func SetMessageOverrides(overrides map[string]map[string]string) error {
+	messageOverrides = overrides
+	if translationFS == nil {
+		// Applied once InitLocalizer runs
+		return nil
+	}
+	return InitLocalizer(*translationFS, localeSettings)
+}
+
+// TranslationMessages returns the messages of a section of the translation file of a language,
+// e.g. "tgbot.messages", keyed by message ID. Customized messages are not included.
+func TranslationMessages(lang string, section string) (map[string]string, error) {
+	if translationFS == nil {
+		return nil, fmt.Errorf("translations are not loaded")
+	}
+	data, err := translationFS.ReadFile("translation/translate." + strings.ReplaceAll(lang, "-", "_") + ".toml")
+	if err != nil {
+		return nil, fmt.Errorf("unknown language %s", lang)
+	}
+	var table map[string]any
+	if err := toml.Unmarshal(data, &table); err != nil {
+		return nil, err
+	}
+	for _, name := range strings.Split(section, ".") {
+		table, _ = table[name].(map[string]any)
+	}
+	messages := make(map[string]string, len(table))
+	for key, value := range table {
+		if text, ok := value.(string); ok {
+			messages[section+"."+key] = text
+		}
+	}
+	return messages, nil
+}
+
+// applyMessageOverrides adds the customized messages to a bundle, replacing the translated ones.
+func applyMessageOverrides(bundle *i18n.Bundle) {
+	for lang, overrides := range messageOverrides {
+		tag, err := language.Parse(lang)
+		if err != nil {
+			logger.Warning("Invalid language of customized messages:", lang)
+			continue
+		}
+		for id, text := range overrides {
+			if err := bundle.AddMessages(tag, &i18n.Message{ID: id, Other: text}); err != nil {
+				logger.Warningf("Failed to add customized message %s for %s: %v", id, lang, err)
+			}
+		}
+	}
+}
+
 // createTemplateData creates a template data map from parameters with optional separator.
 func createTemplateData(params []string, separator ...string) map[string]any {
 	var sep string = "=="
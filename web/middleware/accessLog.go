@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+	"github.com/mhsanaei/3x-ui/v2/web/session"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// accessLogRecord is a request as written to the access log in the JSON format.
+type accessLogRecord struct {
+	Time      string  `json:"time"`
+	Source    string  `json:"source"`
+	RequestID string  `json:"request_id"`
+	ClientIP  string  `json:"client_ip"`
+	User      string  `json:"user,omitempty"`
+	Method    string  `json:"method"`
+	URI       string  `json:"uri"`
+	Proto     string  `json:"proto"`
+	Status    int     `json:"status"`
+	Bytes     int     `json:"bytes"`
+	Duration  float64 `json:"duration_ms"`
+	Referer   string  `json:"referer,omitempty"`
+	UserAgent string  `json:"user_agent,omitempty"`
+}
+
+// AccessLogMiddleware returns a Gin middleware that writes the requests to the HTTP access log
+// in the combined or JSON format, but for those whose path relative to basePath starts with an
+// excluded prefix. source names the server, "panel" or "sub", and the user logged in to the
+// panel is recorded for its requests.
+func AccessLogMiddleware(source, basePath string, config *service.HttpAccessLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := "/" + strings.TrimPrefix(c.Request.URL.Path, basePath)
+		for _, prefix := range config.Exclude {
+			if strings.HasPrefix(path, prefix) {
+				c.Next()
+				return
+			}
+		}
+		start := time.Now()
+		writer := c.Writer
+		c.Next()
+
+		user := ""
+		// The session is missing when the request was aborted before the session middleware
+		if _, ok := c.Get(sessions.DefaultKey); ok {
+			if loginUser := session.GetLoginUser(c); loginUser != nil {
+				user = loginUser.Username
+			}
+		}
+		record := accessLogRecord{
+			Time:      start.Format(time.RFC3339Nano),
+			Source:    source,
+			RequestID: c.GetString(logger.RequestIDField),
+			ClientIP:  c.ClientIP(),
+			User:      user,
+			Method:    c.Request.Method,
+			URI:       c.Request.RequestURI,
+			Proto:     c.Request.Proto,
+			Status:    writer.Status(),
+			Bytes:     max(writer.Size(), 0),
+			Duration:  float64(time.Since(start).Microseconds()) / 1000,
+			Referer:   c.Request.Referer(),
+			UserAgent: c.Request.UserAgent(),
+		}
+		if config.Format == "json" {
+			data, _ := json.Marshal(record)
+			logger.WriteAccessLog(string(data))
+			return
+		}
+		logger.WriteAccessLog(fmt.Sprintf(`%s - %s [%s] "%s %s %s" %d %d "%s" "%s"`,
+			record.ClientIP, orDash(user), start.Format("02/Jan/2006:15:04:05 -0700"),
+			record.Method, escapeQuotes(record.URI), record.Proto, record.Status, record.Bytes,
+			escapeQuotes(orDash(record.Referer)), escapeQuotes(orDash(record.UserAgent))))
+	}
+}
+
+// orDash returns "-" for an empty field, as in the combined log format.
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// escapeQuotes escapes the double quotes of a quoted field of the combined log format.
+func escapeQuotes(value string) string {
+	return strings.ReplaceAll(value, `"`, `\"`)
+}
@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DecoyHandler returns a handler serving a decoy website for the requests matching no route, so
+// that probing the port shows a plausible site rather than a login page or a 404 error. The site
+// is reverse-proxied when it is an http(s) URL and served from a folder otherwise.
+func DecoyHandler(site string) (gin.HandlerFunc, error) {
+	if strings.HasPrefix(site, "http://") || strings.HasPrefix(site, "https://") {
+		target, err := url.Parse(site)
+		if err != nil {
+			return nil, err
+		}
+		proxy := &httputil.ReverseProxy{
+			Rewrite: func(r *httputil.ProxyRequest) {
+				r.SetURL(target)
+				// The transport decompresses the response then, which the server may compress itself
+				r.Out.Header.Del("Accept-Encoding")
+			},
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				logger.Debug("Decoy site request failed:", err)
+				w.WriteHeader(http.StatusBadGateway)
+			},
+		}
+		return func(c *gin.Context) {
+			proxy.ServeHTTP(c.Writer, c.Request)
+		}, nil
+	}
+
+	stat, err := os.Stat(site)
+	if err != nil {
+		return nil, err
+	}
+	if !stat.IsDir() {
+		return nil, &fs.PathError{Op: "open", Path: site, Err: fs.ErrInvalid}
+	}
+	root := os.DirFS(site)
+	return func(c *gin.Context) {
+		serveDecoyFile(c, root)
+	}, nil
+}
+
+// serveDecoyFile serves the file of the request from the folder of the decoy site, index.html for
+// the folders, or its 404.html with a 404 status when there is no such file. Folders are never
+// listed.
+func serveDecoyFile(c *gin.Context, root fs.FS) {
+	name := strings.TrimPrefix(path.Clean("/"+c.Request.URL.Path), "/")
+	if name == "" {
+		name = "."
+	}
+	if stat, err := fs.Stat(root, name); err == nil && stat.IsDir() {
+		name = path.Join(name, "index.html")
+	}
+	if file, err := root.Open(name); err == nil {
+		defer file.Close()
+		stat, err := file.Stat()
+		if content, ok := file.(io.ReadSeeker); ok && err == nil && !stat.IsDir() {
+			http.ServeContent(c.Writer, c.Request, stat.Name(), stat.ModTime(), content)
+			return
+		}
+	}
+
+	file, err := root.Open("404.html")
+	if err != nil {
+		c.String(http.StatusNotFound, "404 page not found")
+		return
+	}
+	defer file.Close()
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusNotFound)
+	io.Copy(c.Writer, file)
+}
@@ -11,14 +11,14 @@ import (
 )
 
 // DomainValidatorMiddleware returns a Gin middleware that validates the request domain.
-// It extracts the host from the request, strips any port number, and compares it
-// against the configured domain. Requests from unauthorized domains are rejected
-// with HTTP 403 Forbidden status.
+// It extracts the host from the request, as forwarded by a trusted proxy, strips any port
+// number, and compares it against the configured domain. Requests from unauthorized domains
+// are rejected with HTTP 403 Forbidden status.
 func DomainValidatorMiddleware(domain string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		host := c.Request.Host
+		host := RequestHost(c)
 		if colonIndex := strings.LastIndex(host, ":"); colonIndex != -1 {
-			host, _, _ = net.SplitHostPort(c.Request.Host)
+			host, _, _ = net.SplitHostPort(host)
 		}
 
 		if host != domain {
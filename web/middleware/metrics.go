@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsMiddleware returns a Gin middleware that counts the requests served by the panel
+// and their duration per route pattern for the metrics endpoint.
+func MetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		service.ObserveHTTPRequest(c.Request.Method, c.FullPath(), c.Writer.Status(), time.Since(start))
+	}
+}
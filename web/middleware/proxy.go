@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"net"
+	"net/netip"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// forwardingHeaders are the headers a reverse proxy describes the request of the client with.
+var forwardingHeaders = []string{
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Port",
+	"X-Forwarded-Prefix",
+	"X-Forwarded-Proto",
+	"X-Real-IP",
+}
+
+// UseTrustedProxies makes an engine trust the forwarding headers of the reverse proxies in front
+// of it only. The headers of the other peers are removed, so that the handlers can read them
+// without clients spoofing their IP, the scheme, the host or the path prefix, and c.ClientIP()
+// takes the client IP from X-Forwarded-For, skipping the trusted proxies, or from X-Real-IP.
+func UseTrustedProxies(engine *gin.Engine, trustedProxies []netip.Prefix) error {
+	proxies := make([]string, 0, len(trustedProxies))
+	for _, prefix := range trustedProxies {
+		proxies = append(proxies, prefix.String())
+	}
+	engine.RemoteIPHeaders = []string{"X-Forwarded-For", "X-Real-IP"}
+	if err := engine.SetTrustedProxies(proxies); err != nil {
+		return err
+	}
+	engine.Use(func(c *gin.Context) {
+		if !isTrustedProxy(c.Request.RemoteAddr, trustedProxies) {
+			for _, header := range forwardingHeaders {
+				c.Request.Header.Del(header)
+			}
+		}
+		c.Next()
+	})
+	return nil
+}
+
+// isTrustedProxy reports whether the peer of a request is a trusted proxy.
+func isTrustedProxy(remoteAddr string, trustedProxies []netip.Prefix) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequestScheme returns the scheme of the request of the client, https also when a trusted proxy
+// terminates TLS.
+func RequestScheme(c *gin.Context) string {
+	if c.Request.TLS != nil || strings.EqualFold(forwardedValue(c, "X-Forwarded-Proto"), "https") {
+		return "https"
+	}
+	return "http"
+}
+
+// RequestHost returns the host of the request of the client with its port, as forwarded by a
+// trusted proxy.
+func RequestHost(c *gin.Context) string {
+	if host := forwardedValue(c, "X-Forwarded-Host"); host != "" {
+		return host
+	}
+	return c.Request.Host
+}
+
+// ForwardedPrefix returns the path a trusted proxy serves the server under when it strips it
+// from the requests, from X-Forwarded-Prefix, without a trailing slash. It is empty otherwise.
+func ForwardedPrefix(c *gin.Context) string {
+	prefix := forwardedValue(c, "X-Forwarded-Prefix")
+	if !strings.HasPrefix(prefix, "/") || strings.ContainsAny(prefix, "\"'<>\\?#") {
+		return ""
+	}
+	prefix = path.Clean(prefix)
+	if prefix == "/" {
+		return ""
+	}
+	return prefix
+}
+
+// forwardedValue returns the first value of a forwarding header, set by the proxy nearest to
+// the client.
+func forwardedValue(c *gin.Context, header string) string {
+	value, _, _ := strings.Cut(c.GetHeader(header), ",")
+	return strings.TrimSpace(value)
+}
@@ -24,7 +24,7 @@ func RedirectMiddleware(basePath string) gin.HandlerFunc {
 			from, to = basePath+from, basePath+to
 
 			if strings.HasPrefix(path, from) {
-				newPath := to + path[len(from):]
+				newPath := ForwardedPrefix(c) + to + path[len(from):]
 
 				c.Redirect(http.StatusMovedPermanently, newPath)
 				c.Abort()
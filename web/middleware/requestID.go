@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader is the header carrying the ID of a request, taken from the client or proxy
+// when valid and returned in the response.
+const RequestIDHeader = "X-Request-Id"
+
+// RequestIDMiddleware returns a Gin middleware that gives every request an ID, kept under
+// logger.RequestIDField in the context, so that the messages logged for it can be correlated.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		c.Set(logger.RequestIDField, id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// validRequestID reports whether an ID set by the client is short and only made of characters
+// that are safe to log.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,133 @@
+package network
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// DrainTimeout is how long a stopping server waits for the requests in flight to complete.
+const DrainTimeout = 10 * time.Second
+
+// handoffSocket is a listening socket kept open across restarts of the servers, with the
+// connections accepted after its listener was closed, which the next listener serves first.
+type handoffSocket struct {
+	listener *net.TCPListener
+	addr     string
+
+	mu      sync.Mutex
+	pending []net.Conn
+}
+
+// parked are the sockets of the closed listeners waiting to be taken over, by address.
+var parked struct {
+	sync.Mutex
+	sockets map[string]*handoffSocket
+}
+
+// HandoffListener is a listener whose socket is handed off to the next listener on the same
+// address when it is closed, instead of being closed. While the servers restart, the new
+// connections wait in the backlog of the socket until the new server accepts them, rather than
+// being refused.
+type HandoffListener struct {
+	socket *handoffSocket
+
+	acceptMu  sync.Mutex
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// Listen returns a listener on a TCP address, taking over the socket of the closed listener on
+// the same address if there is one.
+func Listen(addr string) (net.Listener, error) {
+	parked.Lock()
+	socket := parked.sockets[addr]
+	delete(parked.sockets, addr)
+	parked.Unlock()
+
+	if socket != nil {
+		socket.listener.SetDeadline(time.Time{})
+	} else {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		socket = &handoffSocket{listener: listener.(*net.TCPListener), addr: addr}
+	}
+	return &HandoffListener{socket: socket, closed: make(chan struct{})}, nil
+}
+
+// CloseIdleListeners closes the sockets that were not taken over, e.g. after the address of a
+// server changed or when the panel stops.
+func CloseIdleListeners() {
+	parked.Lock()
+	sockets := parked.sockets
+	parked.sockets = nil
+	parked.Unlock()
+
+	for _, socket := range sockets {
+		socket.listener.Close()
+		for _, conn := range socket.pending {
+			conn.Close()
+		}
+	}
+}
+
+// Accept waits for the next connection, starting with those accepted after the previous
+// listener of the socket was closed.
+func (l *HandoffListener) Accept() (net.Conn, error) {
+	l.acceptMu.Lock()
+	defer l.acceptMu.Unlock()
+	select {
+	case <-l.closed:
+		return nil, net.ErrClosed
+	default:
+	}
+
+	l.socket.mu.Lock()
+	if len(l.socket.pending) > 0 {
+		conn := l.socket.pending[0]
+		l.socket.pending = l.socket.pending[1:]
+		l.socket.mu.Unlock()
+		return conn, nil
+	}
+	l.socket.mu.Unlock()
+
+	conn, err := l.socket.listener.Accept()
+	select {
+	case <-l.closed:
+		// The connection is kept for the next listener
+		if err == nil {
+			l.socket.mu.Lock()
+			l.socket.pending = append(l.socket.pending, conn)
+			l.socket.mu.Unlock()
+		}
+		return nil, net.ErrClosed
+	default:
+	}
+	return conn, err
+}
+
+// Close stops accepting connections and parks the socket for the next listener on its address.
+func (l *HandoffListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		// Wakes up the pending Accept, then waits for it to return
+		l.socket.listener.SetDeadline(time.Now())
+		l.acceptMu.Lock()
+		l.acceptMu.Unlock()
+
+		parked.Lock()
+		defer parked.Unlock()
+		if parked.sockets == nil {
+			parked.sockets = make(map[string]*handoffSocket)
+		}
+		parked.sockets[l.socket.addr] = l.socket
+	})
+	return nil
+}
+
+// Addr returns the address of the socket.
+func (l *HandoffListener) Addr() net.Addr {
+	return l.socket.listener.Addr()
+}
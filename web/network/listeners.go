@@ -0,0 +1,66 @@
+package network
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ListenerConfig is an address a server listens on besides its main one, with TLS settings of
+// its own.
+type ListenerConfig struct {
+	Addr     string // The IP address and port, the IP being empty for all addresses
+	NoTLS    bool   // Plain HTTP, even when the server has a certificate
+	CertFile string // The certificate of the listener, the one of the server is used if empty
+	KeyFile  string // The private key of the certificate of the listener
+}
+
+// ParseListeners parses listeners given one per line as "address:port", optionally followed by
+// "none" for plain HTTP or by the certificate and key files of the listener, e.g.
+// "[::1]:8080 none" or "0.0.0.0:8443 /etc/ssl/panel.crt /etc/ssl/panel.key".
+func ParseListeners(value string) ([]ListenerConfig, error) {
+	var listeners []ListenerConfig
+	for line := range strings.SplitSeq(value, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		host, portStr, err := net.SplitHostPort(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("listener %q is not an address:port: %v", fields[0], err)
+		}
+		if host != "" && net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("listener %q is not an IP address", fields[0])
+		}
+		if port, err := strconv.Atoi(portStr); err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("listener %q has no valid port", fields[0])
+		}
+		listener := ListenerConfig{Addr: net.JoinHostPort(host, portStr)}
+		switch {
+		case len(fields) == 1:
+		case len(fields) == 2 && fields[1] == "none":
+			listener.NoTLS = true
+		case len(fields) == 3:
+			listener.CertFile, listener.KeyFile = fields[1], fields[2]
+		default:
+			return nil, fmt.Errorf("listener %q must be followed by none or by a certificate and key file", fields[0])
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// ListenTLS returns a listener on a TCP address, serving TLS when a configuration is given and
+// redirecting plain HTTP requests to HTTPS then.
+func ListenTLS(addr string, config *tls.Config) (net.Listener, error) {
+	listener, err := Listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		listener = tls.NewListener(NewAutoHttpsListener(listener), config)
+	}
+	return listener, nil
+}
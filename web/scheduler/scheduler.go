@@ -0,0 +1,290 @@
+// Package scheduler runs the background jobs of the panel on their cron schedules, through a
+// bounded pool of workers, so heavy jobs cannot pile up and starve the panel.
+package scheduler
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+
+	"github.com/robfig/cron/v3"
+)
+
+// DefaultTimeout bounds a run of the jobs without a policy of their own.
+const DefaultTimeout = 5 * time.Minute
+
+// Policy controls how the runs of a job are executed.
+type Policy struct {
+	Timeout    time.Duration // Longest a run may take, DefaultTimeout when 0
+	Retries    int           // Times a failed run of a Task is retried
+	RetryDelay time.Duration // Wait before the first retry, doubled for every further one
+}
+
+// Task is a job that reports its failure, which lets the scheduler retry it and record the
+// error. ctx is done when the run times out or the scheduler stops.
+type Task interface {
+	Execute(ctx context.Context) error
+}
+
+// PolicyJob is a job with its own policy instead of the default one.
+type PolicyJob interface {
+	Policy() Policy
+}
+
+// Result is the outcome of a run of a job.
+type Result string
+
+const (
+	ResultOK      Result = "ok"
+	ResultFailed  Result = "failed"
+	ResultTimeout Result = "timeout"
+)
+
+// JobStatus is the state of a scheduled job and the outcome of its last run.
+type JobStatus struct {
+	Name         string `json:"name"`
+	Schedule     string `json:"schedule"`
+	Running      bool   `json:"running"`      // A run is in progress, or waiting for a worker
+	NextRun      int64  `json:"nextRun"`      // Unix milliseconds of the next scheduled run
+	LastStart    int64  `json:"lastStart"`    // Unix milliseconds of the start of the last run, 0 before the first one
+	LastDuration int64  `json:"lastDuration"` // Milliseconds the last run took from its start, including its retries
+	LastResult   Result `json:"lastResult"`
+	LastError    string `json:"lastError"`
+	Runs         int    `json:"runs"`
+	Failures     int    `json:"failures"` // Runs that failed or timed out
+	Skipped      int    `json:"skipped"`  // Runs not started as the previous one was still in progress
+}
+
+// Scheduler runs jobs on cron schedules through a pool of workers. A job never runs twice at
+// the same time: a run that is due while the previous one is still in progress is skipped.
+type Scheduler struct {
+	cron    *cron.Cron
+	workers chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	mu   sync.Mutex
+	jobs map[cron.EntryID]*scheduledJob
+}
+
+// New creates a scheduler running the schedules in loc on the given number of workers.
+func New(loc *time.Location, workers int) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{
+		cron:    cron.New(cron.WithLocation(loc), cron.WithSeconds()),
+		workers: make(chan struct{}, max(workers, 1)),
+		ctx:     ctx,
+		cancel:  cancel,
+		jobs:    make(map[cron.EntryID]*scheduledJob),
+	}
+}
+
+// Start starts running the jobs on their schedules.
+func (s *Scheduler) Start() {
+	s.cron.Start()
+}
+
+// Stop stops scheduling runs and cancels the runs in progress.
+func (s *Scheduler) Stop() {
+	s.cancel()
+	s.cron.Stop()
+}
+
+// AddJob schedules a job under a name, with the policy of the job if it has one. Jobs that are
+// a Task are executed through it.
+func (s *Scheduler) AddJob(name string, spec string, job cron.Job) (cron.EntryID, error) {
+	policy := Policy{}
+	if policyJob, ok := job.(PolicyJob); ok {
+		policy = policyJob.Policy()
+	}
+	if policy.Timeout <= 0 {
+		policy.Timeout = DefaultTimeout
+	}
+	scheduled := &scheduledJob{scheduler: s, name: name, spec: spec, job: job, policy: policy}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, err := s.cron.AddJob(spec, scheduled)
+	if err != nil {
+		return 0, err
+	}
+	s.jobs[id] = scheduled
+	return id, nil
+}
+
+// AddFunc schedules a function under a name, with the default policy.
+func (s *Scheduler) AddFunc(name string, spec string, fn func()) (cron.EntryID, error) {
+	return s.AddJob(name, spec, cron.FuncJob(fn))
+}
+
+// Remove unschedules a job.
+func (s *Scheduler) Remove(id cron.EntryID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cron.Remove(id)
+	delete(s.jobs, id)
+}
+
+// Status returns the state of the scheduled jobs, ordered by name.
+func (s *Scheduler) Status() []JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := make([]JobStatus, 0, len(s.jobs))
+	for id, job := range s.jobs {
+		status := job.snapshot()
+		if next := s.cron.Entry(id).Next; !next.IsZero() {
+			status.NextRun = next.UnixMilli()
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// acquire takes a worker, waiting for one to be free. It fails once the scheduler stops.
+func (s *Scheduler) acquire() bool {
+	select {
+	case s.workers <- struct{}{}:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
+
+// release frees a worker taken by acquire.
+func (s *Scheduler) release() {
+	<-s.workers
+}
+
+// scheduledJob is a job added to the scheduler, with the state of its runs.
+type scheduledJob struct {
+	scheduler *Scheduler
+	name      string
+	spec      string
+	job       cron.Job
+	policy    Policy
+
+	mu      sync.Mutex
+	running bool
+	status  JobStatus
+}
+
+// Run is called by cron when the job is due.
+func (j *scheduledJob) Run() {
+	j.mu.Lock()
+	if j.running {
+		j.status.Skipped++
+		j.mu.Unlock()
+		logger.Debugf("Job %s skipped, its previous run is still in progress", j.name)
+		return
+	}
+	j.running = true
+	j.mu.Unlock()
+
+	start, timedOut, err := j.execute()
+	if j.scheduler.ctx.Err() != nil {
+		// Runs cut short by the scheduler stopping have no outcome to record
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if !timedOut {
+		j.running = false
+	}
+	j.status.Runs++
+	j.status.LastStart = start.UnixMilli()
+	j.status.LastDuration = time.Since(start).Milliseconds()
+	j.status.LastError = ""
+	switch {
+	case timedOut:
+		j.status.LastResult = ResultTimeout
+		j.status.LastError = err.Error()
+		j.status.Failures++
+		logger.Warningf("Job %s timed out after %s", j.name, j.policy.Timeout)
+	case err != nil:
+		j.status.LastResult = ResultFailed
+		j.status.LastError = err.Error()
+		j.status.Failures++
+		logger.Warningf("Job %s failed: %v", j.name, err)
+	default:
+		j.status.LastResult = ResultOK
+	}
+}
+
+// execute runs the job on a worker until it succeeds, its retries run out or it times out, and
+// returns when it got its first worker. A run that timed out goes on in the background, with the
+// worker freed, and keeps the job from starting again until it ends.
+func (j *scheduledJob) execute() (start time.Time, timedOut bool, err error) {
+	s := j.scheduler
+	delay := j.policy.RetryDelay
+	for attempt := 0; ; attempt++ {
+		if !s.acquire() {
+			return time.Now(), false, context.Canceled
+		}
+		if attempt == 0 {
+			start = time.Now()
+		}
+		ctx, cancel := context.WithTimeout(s.ctx, j.policy.Timeout)
+		done := make(chan error, 1)
+		go func() {
+			done <- j.call(ctx)
+		}()
+		select {
+		case err = <-done:
+			cancel()
+			s.release()
+		case <-ctx.Done():
+			s.release()
+			go func() {
+				<-done
+				cancel()
+				j.mu.Lock()
+				j.running = false
+				j.mu.Unlock()
+			}()
+			return start, true, ctx.Err()
+		}
+		if err == nil || attempt >= j.policy.Retries {
+			return start, false, err
+		}
+		logger.Warningf("Job %s failed, retrying in %s: %v", j.name, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-s.ctx.Done():
+			return start, false, err
+		}
+		delay *= 2
+	}
+}
+
+// call runs the job once, turning a panic into an error.
+func (j *scheduledJob) call(ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = common.NewErrorf("panic: %v", r)
+		}
+	}()
+	if task, ok := j.job.(Task); ok {
+		return task.Execute(ctx)
+	}
+	j.job.Run()
+	return nil
+}
+
+// snapshot returns the state of the job.
+func (j *scheduledJob) snapshot() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	status := j.status
+	status.Name = j.name
+	status.Schedule = j.spec
+	status.Running = j.running
+	return status
+}
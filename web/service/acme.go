@@ -0,0 +1,290 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/web/network"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// acmeHTTPAddr is where HTTP-01 challenges are answered, the CAs only connect to port 80.
+const acmeHTTPAddr = ":80"
+
+// acmeState is the ACME client shared by the panel and the subscription server.
+var acmeState struct {
+	sync.Mutex
+	getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	alpn           bool // Whether the listeners answer TLS-ALPN-01 challenges
+	domains        []string
+	stop           func()
+}
+
+// AcmeService obtains and renews the certificates of the panel and subscription domains from
+// Let's Encrypt, or another ACME CA, in place of acme.sh. The challenges are answered by the panel
+// itself: TLS-ALPN-01 on its HTTPS listeners and HTTP-01 on port 80 while that port is free, or
+// DNS-01 through the API of a DNS provider.
+type AcmeService struct {
+	settingService SettingService
+}
+
+// Start sets up the ACME client from the settings, replacing the previous one. It does nothing
+// when ACME is disabled. The certificates are requested in the background.
+func (s *AcmeService) Start() error {
+	s.Stop()
+
+	enable, err := s.settingService.GetAcmeEnable()
+	if err != nil || !enable {
+		return err
+	}
+	domains, err := s.domains()
+	if err != nil {
+		return err
+	}
+	if len(domains) == 0 {
+		return common.NewError("ACME needs the domain of the panel or of the subscription server")
+	}
+	email, err := s.settingService.GetAcmeEmail()
+	if err != nil {
+		return err
+	}
+	directory, err := s.settingService.GetAcmeDirectory()
+	if err != nil {
+		return err
+	}
+	challenge, err := s.settingService.GetAcmeChallenge()
+	if err != nil {
+		return err
+	}
+	client := &acme.Client{DirectoryURL: directory}
+	cache := autocert.DirCache(filepath.Join(config.GetDBFolderPath(), "acme"))
+
+	if challenge == "dns" {
+		return s.startDNS(client, cache, email, domains)
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      cache,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Email:      email,
+		Client:     client,
+	}
+
+	acmeState.Lock()
+	defer acmeState.Unlock()
+	acmeState.getCertificate = m.GetCertificate
+	acmeState.alpn = true
+	acmeState.domains = domains
+
+	// HTTP-01 is offered to the CA only once the handler exists, so only when the port is free
+	if listener, err := net.Listen("tcp", acmeHTTPAddr); err == nil {
+		httpServer := &http.Server{
+			Handler:           m.HTTPHandler(nil),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go httpServer.Serve(listener)
+		acmeState.stop = func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			httpServer.Shutdown(ctx)
+		}
+	} else {
+		logger.Warning("ACME HTTP-01 challenges unavailable:", err, "- TLS-ALPN-01 needs the panel or the subscription server on port 443")
+	}
+
+	go func() {
+		// Give the listeners time to start, they answer the TLS-ALPN-01 challenges
+		time.Sleep(5 * time.Second)
+		for _, domain := range domains {
+			if _, err := m.GetCertificate(acmeHello(domain)); err != nil {
+				logger.Warning("ACME certificate for", domain, "failed:", err)
+			} else {
+				logger.Info("ACME certificate for", domain, "is ready")
+			}
+		}
+	}()
+	return nil
+}
+
+// startDNS sets up the ACME client for DNS-01 challenges, which obtains one certificate for all the
+// domains, and for their wildcards if enabled.
+func (s *AcmeService) startDNS(client *acme.Client, cache autocert.DirCache, email string, domains []string) error {
+	providerName, err := s.settingService.GetAcmeDnsProvider()
+	if err != nil {
+		return err
+	}
+	credentials, err := s.settingService.GetAcmeDnsCredentials()
+	if err != nil {
+		return err
+	}
+	wildcard, err := s.settingService.GetAcmeWildcard()
+	if err != nil {
+		return err
+	}
+	provider, err := newAcmeDNSProvider(providerName, credentials)
+	if err != nil {
+		return err
+	}
+	names := slices.Clone(domains)
+	if wildcard {
+		for _, domain := range domains {
+			// The wildcard of panel.example.com is *.example.com
+			if _, parent, ok := strings.Cut(domain, "."); ok && strings.Contains(parent, ".") {
+				if name := "*." + parent; !slices.Contains(names, name) {
+					names = append(names, name)
+				}
+			}
+		}
+	}
+
+	m := newAcmeDNSManager(client, cache, email, provider, names)
+	acmeState.Lock()
+	defer acmeState.Unlock()
+	acmeState.getCertificate = m.GetCertificate
+	acmeState.alpn = false
+	acmeState.domains = domains
+	acmeState.stop = m.Stop
+	go m.Run()
+	return nil
+}
+
+// Stop closes the HTTP-01 listener or ends the DNS-01 renewals, and drops the ACME client. The
+// certificates stay in the cache.
+func (s *AcmeService) Stop() {
+	acmeState.Lock()
+	defer acmeState.Unlock()
+	if acmeState.stop != nil {
+		acmeState.stop()
+	}
+	acmeState.getCertificate = nil
+	acmeState.alpn = false
+	acmeState.domains = nil
+	acmeState.stop = nil
+}
+
+// ListenerTLSConfig returns the TLS configuration of an additional listener of a server: none for
+// plain HTTP, one with the certificate of the listener, or else the configuration of the server.
+func (s *AcmeService) ListenerTLSConfig(listener network.ListenerConfig, serverConfig *tls.Config) (*tls.Config, error) {
+	switch {
+	case listener.NoTLS:
+		return nil, nil
+	case listener.CertFile != "":
+		return s.TLSConfig("", listener.CertFile, listener.KeyFile)
+	}
+	return serverConfig, nil
+}
+
+// TLSConfig returns the TLS configuration of a listener of the panel serving domain. A client
+// gets the certificate of the store for the name it asks for, or else the certificate of the ACME
+// client when that is an ACME domain, or else the certificate of the files, which is also used
+// while the ACME certificate is unavailable. The files are loaded again when they change. It
+// returns nil when there is no certificate.
+func (s *AcmeService) TLSConfig(domain, certFile, keyFile string) (*tls.Config, error) {
+	domain = normalizeCertName(domain)
+	acmeState.Lock()
+	getCertificate, alpn, domains := acmeState.getCertificate, acmeState.alpn, acmeState.domains
+	acmeState.Unlock()
+	if !slices.Contains(domains, domain) {
+		getCertificate = nil
+	}
+	stored := certStoreGet(domain) != nil
+
+	var fallback *reloadingCert
+	if certFile != "" || keyFile != "" {
+		var err error
+		fallback, err = loadCertFile(certFile, keyFile)
+		if err != nil {
+			if getCertificate == nil && !stored {
+				return nil, err
+			}
+			logger.Warning("Error loading certificates, using the ACME or stored certificates only:", err)
+		}
+	}
+	if getCertificate == nil && fallback == nil && !stored {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			name := normalizeCertName(hello.ServerName)
+			// TLS-ALPN-01 challenges are answered by the ACME client only
+			challenge := getCertificate != nil && slices.Contains(hello.SupportedProtos, acme.ALPNProto)
+			if cert := certStoreGet(name); cert != nil && !challenge {
+				return cert, nil
+			}
+			if getCertificate != nil && (slices.Contains(domains, name) || fallback == nil) {
+				if !slices.Contains(domains, name) {
+					// Clients connecting by IP get the certificate of the domain
+					h := *hello
+					h.ServerName = domain
+					hello = &h
+				}
+				cert, err := getCertificate(hello)
+				if err == nil || fallback == nil {
+					return cert, err
+				}
+				logger.Warning("ACME certificate for", hello.ServerName, "unavailable:", err)
+			}
+			if fallback != nil {
+				return fallback.Get(), nil
+			}
+			if cert := certStoreGet(domain); cert != nil {
+				return cert, nil
+			}
+			return nil, common.NewError("no certificate for", hello.ServerName)
+		},
+	}
+	if getCertificate != nil {
+		config.NextProtos = []string{"http/1.1"}
+		if alpn {
+			config.NextProtos = append(config.NextProtos, acme.ALPNProto)
+		}
+	}
+	return config, nil
+}
+
+// domains returns the distinct domains of the panel and of the subscription server.
+func (s *AcmeService) domains() ([]string, error) {
+	webDomain, err := s.settingService.GetWebDomain()
+	if err != nil {
+		return nil, err
+	}
+	subDomain, err := s.settingService.GetSubDomain()
+	if err != nil {
+		return nil, err
+	}
+	var domains []string
+	for _, domain := range []string{webDomain, subDomain} {
+		domain = normalizeCertName(domain)
+		if domain == "" || net.ParseIP(domain) != nil || slices.Contains(domains, domain) {
+			continue
+		}
+		domains = append(domains, domain)
+	}
+	return domains, nil
+}
+
+// acmeHello is the handshake of a client supporting ECDSA, which most do, so that the certificate
+// requested ahead is the one served later.
+func acmeHello(domain string) *tls.ClientHelloInfo {
+	return &tls.ClientHelloInfo{
+		ServerName:        domain,
+		SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		SupportedCurves:   []tls.CurveID{tls.CurveP256},
+		CipherSuites:      []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		SupportedVersions: []uint16{tls.VersionTLS13, tls.VersionTLS12},
+	}
+}
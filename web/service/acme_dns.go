@@ -0,0 +1,299 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	// acmeAccountKey is the cache key of the ACME account key, the one autocert uses too.
+	acmeAccountKey = "acme_account+key"
+	// acmeRenewBefore is how long before it expires the certificate is renewed.
+	acmeRenewBefore = 30 * 24 * time.Hour
+)
+
+// acmeDNSManager obtains one certificate for all its names through DNS-01 challenges, which need
+// no open port and allow wildcards, and renews it before it expires.
+type acmeDNSManager struct {
+	client   *acme.Client
+	cache    autocert.DirCache
+	email    string
+	provider acmeDNSProvider
+	names    []string
+
+	mu   sync.Mutex
+	cert *tls.Certificate
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newAcmeDNSManager(client *acme.Client, cache autocert.DirCache, email string, provider acmeDNSProvider, names []string) *acmeDNSManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &acmeDNSManager{
+		client:   client,
+		cache:    cache,
+		email:    email,
+		provider: provider,
+		names:    names,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// cacheKey is the cache key of the certificate, apart from those autocert stores.
+func (m *acmeDNSManager) cacheKey() string {
+	return strings.ReplaceAll(m.names[0], "*", "_") + "+dns"
+}
+
+// Run loads the certificate from the cache, then obtains and renews it until Stop is called.
+func (m *acmeDNSManager) Run() {
+	if cert, err := m.load(); err == nil && sameNames(cert.Leaf.DNSNames, m.names) {
+		m.mu.Lock()
+		m.cert = cert
+		m.mu.Unlock()
+	}
+	for {
+		wait := 12 * time.Hour
+		if m.due() {
+			if err := m.obtain(); err != nil {
+				logger.Warning("ACME certificate for", strings.Join(m.names, ", "), "failed:", err)
+				wait = time.Hour
+			} else {
+				logger.Info("ACME certificate for", strings.Join(m.names, ", "), "is ready")
+			}
+		}
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Stop ends the renewals, and cancels an order in progress.
+func (m *acmeDNSManager) Stop() {
+	m.cancel()
+}
+
+// GetCertificate returns the certificate, whatever the name asked for: the listeners only ask for
+// the names it was obtained for.
+func (m *acmeDNSManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cert == nil {
+		return nil, common.NewError("the ACME certificate is not obtained yet")
+	}
+	return m.cert, nil
+}
+
+// due reports whether the certificate is missing or about to expire.
+func (m *acmeDNSManager) due() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cert == nil || time.Until(m.cert.Leaf.NotAfter) < acmeRenewBefore
+}
+
+// obtain orders a new certificate, answering the challenges one after the other so that providers
+// replacing the TXT records of a name do not drop those of another challenge.
+func (m *acmeDNSManager) obtain() error {
+	ctx, cancel := context.WithTimeout(m.ctx, 15*time.Minute)
+	defer cancel()
+
+	if err := m.register(ctx); err != nil {
+		return err
+	}
+	order, err := m.client.AuthorizeOrder(ctx, acme.DomainIDs(m.names...))
+	if err != nil {
+		return err
+	}
+	for _, url := range order.AuthzURLs {
+		authz, err := m.client.GetAuthorization(ctx, url)
+		if err != nil {
+			return err
+		}
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+		var challenge *acme.Challenge
+		for _, c := range authz.Challenges {
+			if c.Type == "dns-01" {
+				challenge = c
+				break
+			}
+		}
+		if challenge == nil {
+			return common.NewError("no DNS-01 challenge offered for", authz.Identifier.Value)
+		}
+		if err := m.solve(ctx, authz, challenge); err != nil {
+			return err
+		}
+	}
+	if order, err = m.client.WaitOrder(ctx, order.URI); err != nil {
+		return err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: m.names}, key)
+	if err != nil {
+		return err
+	}
+	der, _, err := m.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(der[0])
+	if err != nil {
+		return err
+	}
+	cert := &tls.Certificate{Certificate: der, PrivateKey: key, Leaf: leaf}
+	if err := m.store(ctx, cert); err != nil {
+		logger.Warning("Error caching the ACME certificate:", err)
+	}
+	m.mu.Lock()
+	m.cert = cert
+	m.mu.Unlock()
+	return nil
+}
+
+// solve publishes the TXT record of a challenge, waits until it resolves and has the CA check it.
+func (m *acmeDNSManager) solve(ctx context.Context, authz *acme.Authorization, challenge *acme.Challenge) error {
+	fqdn := "_acme-challenge." + authz.Identifier.Value
+	value, err := m.client.DNS01ChallengeRecord(challenge.Token)
+	if err != nil {
+		return err
+	}
+	if err := m.provider.Present(ctx, fqdn, value); err != nil {
+		return common.NewErrorf("Error creating the TXT record of %s: %v", fqdn, err)
+	}
+	defer func() {
+		if err := m.provider.CleanUp(context.Background(), fqdn, value); err != nil {
+			logger.Warning("Error removing the TXT record of", fqdn+":", err)
+		}
+	}()
+
+	// The CA resolves the record itself, waiting for it here only avoids failing early
+	for deadline := time.Now().Add(2 * time.Minute); time.Now().Before(deadline); {
+		if records, err := net.DefaultResolver.LookupTXT(ctx, fqdn); err == nil && slices.Contains(records, value) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Second):
+		}
+	}
+	if _, err := m.client.Accept(ctx, challenge); err != nil {
+		return err
+	}
+	_, err = m.client.WaitAuthorization(ctx, authz.URI)
+	return err
+}
+
+// register sets the account key of the client, shared with autocert, and creates the account
+// with the CA when it does not exist yet.
+func (m *acmeDNSManager) register(ctx context.Context) error {
+	if m.client.Key == nil {
+		key, err := m.accountKey(ctx)
+		if err != nil {
+			return err
+		}
+		m.client.Key = key
+	}
+	account := &acme.Account{}
+	if m.email != "" {
+		account.Contact = []string{"mailto:" + m.email}
+	}
+	_, err := m.client.Register(ctx, account, acme.AcceptTOS)
+	if err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return err
+	}
+	return nil
+}
+
+// accountKey loads the account key from the cache, or creates it.
+func (m *acmeDNSManager) accountKey(ctx context.Context) (crypto.Signer, error) {
+	if data, err := m.cache.Get(ctx, acmeAccountKey); err == nil {
+		if block, _ := pem.Decode(data); block != nil && block.Type == "EC PRIVATE KEY" {
+			return x509.ParseECPrivateKey(block.Bytes)
+		}
+		return nil, common.NewError("invalid ACME account key in the cache")
+	} else if !errors.Is(err, autocert.ErrCacheMiss) {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if err := m.cache.Put(ctx, acmeAccountKey, data); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// store writes the private key and the certificate chain to the cache, in the format of autocert.
+func (m *acmeDNSManager) store(ctx context.Context, cert *tls.Certificate) error {
+	der, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	pem.Encode(&buf, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	for _, b := range cert.Certificate {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: b})
+	}
+	return m.cache.Put(ctx, m.cacheKey(), buf.Bytes())
+}
+
+// load reads the certificate from the cache.
+func (m *acmeDNSManager) load() (*tls.Certificate, error) {
+	data, err := m.cache.Get(m.ctx, m.cacheKey())
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+	return &cert, nil
+}
+
+// sameNames reports whether a certificate is for the names, in whatever order.
+func sameNames(a, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
@@ -0,0 +1,396 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+)
+
+// acmeDNSProvider publishes the TXT records of DNS-01 challenges through the API of a DNS
+// hosting provider. The names are fully qualified, without the trailing dot.
+type acmeDNSProvider interface {
+	Present(ctx context.Context, fqdn, value string) error
+	CleanUp(ctx context.Context, fqdn, value string) error
+}
+
+// acmeDNSProviders are the supported DNS providers by name, created from the credentials of the
+// settings, a JSON object.
+var acmeDNSProviders = map[string]func(credentials map[string]string) (acmeDNSProvider, error){
+	"cloudflare":   newCloudflareDNS,
+	"route53":      newRoute53DNS,
+	"gandi":        newGandiDNS,
+	"digitalocean": newDigitalOceanDNS,
+}
+
+// newAcmeDNSProvider creates the DNS provider of a name from its credentials.
+func newAcmeDNSProvider(name string, credentials string) (acmeDNSProvider, error) {
+	newProvider, ok := acmeDNSProviders[name]
+	if !ok {
+		return nil, common.NewError("unknown ACME DNS provider:", name)
+	}
+	values := map[string]string{}
+	if strings.TrimSpace(credentials) != "" {
+		if err := json.Unmarshal([]byte(credentials), &values); err != nil {
+			return nil, common.NewErrorf("invalid ACME DNS credentials: %v", err)
+		}
+	}
+	return newProvider(values)
+}
+
+// requireCredentials returns the credentials of keys, failing when one is missing.
+func requireCredentials(provider string, credentials map[string]string, keys ...string) ([]string, error) {
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = strings.TrimSpace(credentials[key])
+		if values[i] == "" {
+			return nil, common.NewErrorf("the %s DNS provider needs the %s credential", provider, key)
+		}
+	}
+	return values, nil
+}
+
+// dnsParents returns name and the domains it is in, from the longest, to look the zone up.
+func dnsParents(name string) []string {
+	var parents []string
+	for strings.Contains(name, ".") {
+		parents = append(parents, name)
+		name = name[strings.Index(name, ".")+1:]
+	}
+	return parents
+}
+
+// dnsAPI sends a JSON request to the API of a DNS provider and decodes the JSON response into
+// result, if not nil.
+func dnsAPI(ctx context.Context, method, url string, header http.Header, body any, result any) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return 0, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, common.NewErrorf("%s %s: %s: %s", method, req.URL.Path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if result != nil && len(data) > 0 {
+		return resp.StatusCode, json.Unmarshal(data, result)
+	}
+	return resp.StatusCode, nil
+}
+
+// cloudflareDNS uses the Cloudflare API with a token allowed to edit the DNS of the zone.
+type cloudflareDNS struct {
+	header http.Header
+}
+
+func newCloudflareDNS(credentials map[string]string) (acmeDNSProvider, error) {
+	values, err := requireCredentials("cloudflare", credentials, "apiToken")
+	if err != nil {
+		return nil, err
+	}
+	return &cloudflareDNS{header: http.Header{"Authorization": {"Bearer " + values[0]}}}, nil
+}
+
+func (c *cloudflareDNS) api(ctx context.Context, method, path string, body any, result any) error {
+	var envelope struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if _, err := dnsAPI(ctx, method, "https://api.cloudflare.com/client/v4"+path, c.header, body, &envelope); err != nil {
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(envelope.Result, result)
+}
+
+func (c *cloudflareDNS) zoneID(ctx context.Context, fqdn string) (string, error) {
+	for _, name := range dnsParents(fqdn) {
+		var zones []struct {
+			ID string `json:"id"`
+		}
+		if err := c.api(ctx, http.MethodGet, "/zones?name="+url.QueryEscape(name), nil, &zones); err != nil {
+			return "", err
+		}
+		if len(zones) > 0 {
+			return zones[0].ID, nil
+		}
+	}
+	return "", common.NewError("no Cloudflare zone found for", fqdn)
+}
+
+func (c *cloudflareDNS) Present(ctx context.Context, fqdn, value string) error {
+	zoneID, err := c.zoneID(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	record := map[string]any{"type": "TXT", "name": fqdn, "content": value, "ttl": 60}
+	return c.api(ctx, http.MethodPost, "/zones/"+zoneID+"/dns_records", record, nil)
+}
+
+func (c *cloudflareDNS) CleanUp(ctx context.Context, fqdn, value string) error {
+	zoneID, err := c.zoneID(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	var records []struct {
+		ID      string `json:"id"`
+		Content string `json:"content"`
+	}
+	if err := c.api(ctx, http.MethodGet, "/zones/"+zoneID+"/dns_records?type=TXT&name="+url.QueryEscape(fqdn), nil, &records); err != nil {
+		return err
+	}
+	for _, record := range records {
+		if strings.Trim(record.Content, `"`) == value {
+			if err := c.api(ctx, http.MethodDelete, "/zones/"+zoneID+"/dns_records/"+record.ID, nil, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// gandiDNS uses the LiveDNS API of Gandi with a personal access token.
+type gandiDNS struct {
+	header http.Header
+}
+
+func newGandiDNS(credentials map[string]string) (acmeDNSProvider, error) {
+	values, err := requireCredentials("gandi", credentials, "token")
+	if err != nil {
+		return nil, err
+	}
+	return &gandiDNS{header: http.Header{"Authorization": {"Bearer " + values[0]}}}, nil
+}
+
+// recordPath returns the API path of the TXT records of fqdn in its zone.
+func (g *gandiDNS) recordPath(ctx context.Context, fqdn string) (string, error) {
+	for _, zone := range dnsParents(fqdn)[1:] {
+		status, err := dnsAPI(ctx, http.MethodGet, "https://api.gandi.net/v5/livedns/domains/"+zone, g.header, nil, nil)
+		if status == http.StatusNotFound || status == http.StatusForbidden {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		name := strings.TrimSuffix(fqdn, "."+zone)
+		return "https://api.gandi.net/v5/livedns/domains/" + zone + "/records/" + name + "/TXT", nil
+	}
+	return "", common.NewError("no Gandi domain found for", fqdn)
+}
+
+func (g *gandiDNS) Present(ctx context.Context, fqdn, value string) error {
+	path, err := g.recordPath(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	record := map[string]any{"rrset_values": []string{value}, "rrset_ttl": 300}
+	_, err = dnsAPI(ctx, http.MethodPut, path, g.header, record, nil)
+	return err
+}
+
+func (g *gandiDNS) CleanUp(ctx context.Context, fqdn, value string) error {
+	path, err := g.recordPath(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	_, err = dnsAPI(ctx, http.MethodDelete, path, g.header, nil, nil)
+	return err
+}
+
+// digitalOceanDNS uses the DigitalOcean API with a token allowed to write the domains.
+type digitalOceanDNS struct {
+	header http.Header
+}
+
+func newDigitalOceanDNS(credentials map[string]string) (acmeDNSProvider, error) {
+	values, err := requireCredentials("digitalocean", credentials, "token")
+	if err != nil {
+		return nil, err
+	}
+	return &digitalOceanDNS{header: http.Header{"Authorization": {"Bearer " + values[0]}}}, nil
+}
+
+func (d *digitalOceanDNS) zone(ctx context.Context, fqdn string) (string, error) {
+	for _, zone := range dnsParents(fqdn)[1:] {
+		status, err := dnsAPI(ctx, http.MethodGet, "https://api.digitalocean.com/v2/domains/"+zone, d.header, nil, nil)
+		if status == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return zone, nil
+	}
+	return "", common.NewError("no DigitalOcean domain found for", fqdn)
+}
+
+func (d *digitalOceanDNS) Present(ctx context.Context, fqdn, value string) error {
+	zone, err := d.zone(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	record := map[string]any{"type": "TXT", "name": strings.TrimSuffix(fqdn, "."+zone), "data": value, "ttl": 60}
+	_, err = dnsAPI(ctx, http.MethodPost, "https://api.digitalocean.com/v2/domains/"+zone+"/records", d.header, record, nil)
+	return err
+}
+
+func (d *digitalOceanDNS) CleanUp(ctx context.Context, fqdn, value string) error {
+	zone, err := d.zone(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	var result struct {
+		Records []struct {
+			ID   int64  `json:"id"`
+			Data string `json:"data"`
+		} `json:"domain_records"`
+	}
+	recordsURL := "https://api.digitalocean.com/v2/domains/" + zone + "/records"
+	if _, err := dnsAPI(ctx, http.MethodGet, recordsURL+"?type=TXT&name="+url.QueryEscape(fqdn), d.header, nil, &result); err != nil {
+		return err
+	}
+	for _, record := range result.Records {
+		if record.Data == value {
+			if _, err := dnsAPI(ctx, http.MethodDelete, fmt.Sprintf("%s/%d", recordsURL, record.ID), d.header, nil, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// route53DNS uses the Route 53 API of AWS with the access key of a user allowed to change the
+// record sets of the hosted zone.
+type route53DNS struct {
+	accessKeyID     string
+	secretAccessKey string
+	hostedZoneID    string
+}
+
+func newRoute53DNS(credentials map[string]string) (acmeDNSProvider, error) {
+	values, err := requireCredentials("route53", credentials, "accessKeyId", "secretAccessKey", "hostedZoneId")
+	if err != nil {
+		return nil, err
+	}
+	return &route53DNS{
+		accessKeyID:     values[0],
+		secretAccessKey: values[1],
+		hostedZoneID:    strings.TrimPrefix(values[2], "/hostedzone/"),
+	}, nil
+}
+
+func (r *route53DNS) Present(ctx context.Context, fqdn, value string) error {
+	return r.change(ctx, "UPSERT", fqdn, value)
+}
+
+func (r *route53DNS) CleanUp(ctx context.Context, fqdn, value string) error {
+	return r.change(ctx, "DELETE", fqdn, value)
+}
+
+// change applies a change to the TXT record set of fqdn. The record of a deletion must be the
+// same as that created.
+func (r *route53DNS) change(ctx context.Context, action, fqdn, value string) error {
+	body := []byte(`<?xml version="1.0" encoding="UTF-8"?>` +
+		`<ChangeResourceRecordSetsRequest xmlns="https://route53.amazonaws.com/doc/2013-04-01/"><ChangeBatch><Changes><Change>` +
+		`<Action>` + action + `</Action><ResourceRecordSet><Name>` + fqdn + `.</Name><Type>TXT</Type><TTL>60</TTL>` +
+		`<ResourceRecords><ResourceRecord><Value>"` + value + `"</Value></ResourceRecord></ResourceRecords>` +
+		`</ResourceRecordSet></Change></Changes></ChangeBatch></ChangeResourceRecordSetsRequest>`)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		"https://route53.amazonaws.com/2013-04-01/hostedzone/"+r.hostedZoneID+"/rrset", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/xml")
+	r.sign(req, body)
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		return common.NewErrorf("Route 53 %s: %s: %s", action, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
+
+// sign signs a request with AWS Signature Version 4, Route 53 being in the us-east-1 region.
+func (r *route53DNS) sign(req *http.Request, body []byte) {
+	signAWSRequest(req, sha256Hex(body), "us-east-1", "route53", r.accessKeyID, r.secretAccessKey)
+}
+
+// signAWSRequest signs a request with AWS Signature Version 4 for the given region and service,
+// payloadHash being the hex SHA-256 of the request body.
+func signAWSRequest(req *http.Request, payloadHash string, region string, service string, accessKeyID string, secretAccessKey string) {
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := []byte("AWS4" + secretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyID, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,300 @@
+package service
+
+import (
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"github.com/shirou/gopsutil/v4/cpu"
+	"github.com/shirou/gopsutil/v4/disk"
+)
+
+// Types of the alert rules.
+const (
+	AlertCpu          = "cpu"
+	AlertDisk         = "disk"
+	AlertDailyTraffic = "dailyTraffic"
+	AlertClientExpiry = "clientExpiry"
+	AlertCoreRestart  = "coreRestart"
+	AlertSpeedtest    = "speedtest"
+	AlertUnreachable  = "unreachable"
+)
+
+// alertCoreStarts is the number of core starts seen by the previous evaluation, -1 before the first one.
+var alertCoreStarts int64 = -1
+
+// AlertNotice is an alert that is due to be sent to the administrators.
+type AlertNotice struct {
+	Rule  *model.AlertRule
+	Event *model.AlertEvent
+}
+
+// AlertService manages the alert rules and evaluates them against the state of the server.
+type AlertService struct {
+	settingService SettingService
+}
+
+// GetAlertRules returns all alert rules.
+func (s *AlertService) GetAlertRules() ([]*model.AlertRule, error) {
+	var rules []*model.AlertRule
+	err := database.GetDB().Model(model.AlertRule{}).Order("id").Find(&rules).Error
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// AddAlertRule creates a new alert rule.
+func (s *AlertService) AddAlertRule(rule *model.AlertRule) error {
+	if err := checkAlertRule(rule); err != nil {
+		return err
+	}
+	rule.Id = 0
+	return database.GetDB().Create(rule).Error
+}
+
+// UpdateAlertRule saves an alert rule. Its open events are resolved, so a changed
+// condition is evaluated and notified afresh.
+func (s *AlertService) UpdateAlertRule(rule *model.AlertRule) error {
+	if err := checkAlertRule(rule); err != nil {
+		return err
+	}
+	db := database.GetDB()
+	if err := db.Model(model.AlertRule{}).First(&model.AlertRule{}, rule.Id).Error; err != nil {
+		return err
+	}
+	if err := db.Save(rule).Error; err != nil {
+		return err
+	}
+	return db.Model(model.AlertEvent{}).
+		Where("rule_id = ? AND resolved_at = 0", rule.Id).
+		Update("resolved_at", time.Now().Unix()).Error
+}
+
+// DelAlertRule deletes an alert rule and its events.
+func (s *AlertService) DelAlertRule(id int) error {
+	db := database.GetDB()
+	if err := db.Where("rule_id = ?", id).Delete(model.AlertEvent{}).Error; err != nil {
+		return err
+	}
+	return db.Delete(model.AlertRule{}, id).Error
+}
+
+// SilenceAlertRule stops sending the alerts of a rule for the given minutes. They are still
+// recorded. Zero minutes ends the silence.
+func (s *AlertService) SilenceAlertRule(id int, minutes int) error {
+	if minutes < 0 {
+		return common.NewError("Silence duration must not be negative:", minutes)
+	}
+	var until int64
+	if minutes > 0 {
+		until = time.Now().Add(time.Duration(minutes) * time.Minute).Unix()
+	}
+	result := database.GetDB().Model(model.AlertRule{}).Where("id = ?", id).Update("silenced_until", until)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return common.NewError("Alert rule not found:", id)
+	}
+	return nil
+}
+
+// GetAlertEvents returns the most recent alert events, newest first.
+func (s *AlertService) GetAlertEvents(limit int) ([]*model.AlertEvent, error) {
+	var events []*model.AlertEvent
+	err := database.GetDB().Model(model.AlertEvent{}).Order("fired_at desc, id desc").Limit(limit).Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// AckAlertEvent acknowledges an alert event, which stops its repeated notifications.
+func (s *AlertService) AckAlertEvent(id int) error {
+	result := database.GetDB().Model(model.AlertEvent{}).
+		Where("id = ? AND ack_at = 0", id).
+		Update("ack_at", time.Now().Unix())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return common.NewError("Alert event not found or already acknowledged:", id)
+	}
+	return nil
+}
+
+// EvaluateAlertRules checks the enabled rules, opens an event for every rule whose condition
+// started to hold and resolves the events of rules whose condition stopped holding. It returns
+// the alerts that are due to be sent: new events and unacknowledged events whose repeat interval
+// passed, unless their rule is silenced.
+func (s *AlertService) EvaluateAlertRules() ([]*AlertNotice, error) {
+	var rules []*model.AlertRule
+	if err := database.GetDB().Model(model.AlertRule{}).Where("enable = ?", true).Find(&rules).Error; err != nil {
+		return nil, err
+	}
+
+	starts := coreStarts.Load()
+	restarts := float64(0)
+	if alertCoreStarts >= 0 {
+		restarts = float64(starts - alertCoreStarts)
+	}
+	alertCoreStarts = starts
+
+	db := database.GetDB()
+	now := time.Now()
+	var notices []*AlertNotice
+	for _, rule := range rules {
+		value, detail, err := s.measureAlert(rule, restarts)
+		if err != nil {
+			logger.Warning("Evaluate alert rule", rule.Name, "failed:", err)
+			continue
+		}
+		var firing bool
+		switch rule.Type {
+		case AlertClientExpiry, AlertCoreRestart, AlertUnreachable:
+			firing = value > 0
+		case AlertSpeedtest:
+			firing = value >= 0 && value < rule.Threshold
+		default:
+			firing = value > rule.Threshold
+		}
+
+		event := &model.AlertEvent{}
+		err = db.Model(model.AlertEvent{}).Where("rule_id = ? AND resolved_at = 0", rule.Id).Order("id desc").First(event).Error
+		open := err == nil
+		switch {
+		case !firing && open:
+			if err := db.Model(event).Update("resolved_at", now.Unix()).Error; err != nil {
+				return nil, err
+			}
+			continue
+		case !firing:
+			continue
+		case !open:
+			event = &model.AlertEvent{RuleId: rule.Id, Value: value, Detail: detail, FiredAt: now.Unix()}
+			if err := db.Create(event).Error; err != nil {
+				return nil, err
+			}
+		default:
+			event.Value = value
+			event.Detail = detail
+			if err := db.Model(event).Updates(map[string]any{"value": value, "detail": detail}).Error; err != nil {
+				return nil, err
+			}
+		}
+
+		if rule.SilencedUntil > now.Unix() || event.AckAt > 0 {
+			continue
+		}
+		due := event.NotifiedAt == 0 ||
+			(rule.RepeatMinutes > 0 && now.Unix()-event.NotifiedAt >= int64(rule.RepeatMinutes)*60)
+		if due {
+			notices = append(notices, &AlertNotice{Rule: rule, Event: event})
+		}
+	}
+	return notices, nil
+}
+
+// MarkAlertsNotified records that the alerts were sent, so they are not sent again before their repeat interval.
+func (s *AlertService) MarkAlertsNotified(notices []*AlertNotice) error {
+	if len(notices) == 0 {
+		return nil
+	}
+	ids := make([]int, len(notices))
+	for i, notice := range notices {
+		ids[i] = notice.Event.Id
+	}
+	return database.GetDB().Model(model.AlertEvent{}).Where("id IN ?", ids).Update("notified_at", time.Now().Unix()).Error
+}
+
+// measureAlert returns the current value of the quantity a rule watches and a detail for the alert.
+func (s *AlertService) measureAlert(rule *model.AlertRule, restarts float64) (float64, string, error) {
+	switch rule.Type {
+	case AlertCpu:
+		percent, err := cpu.Percent(time.Second, false)
+		if err != nil || len(percent) == 0 {
+			return 0, "", err
+		}
+		return percent[0], "", nil
+	case AlertDisk:
+		usage, err := disk.Usage("/")
+		if err != nil {
+			return 0, "", err
+		}
+		return usage.UsedPercent, "", nil
+	case AlertDailyTraffic:
+		loc, err := s.settingService.GetTimeLocation()
+		if err != nil {
+			loc = time.Local
+		}
+		now := time.Now().In(loc)
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+		var total int64
+		err = database.GetDB().Model(model.TrafficSample{}).
+			Select("COALESCE(SUM(up + down), 0)").
+			Where("kind = ? AND resolution = ? AND time >= ?", "inbound", TrafficSampleHour, today.UnixMilli()).
+			Scan(&total).Error
+		if err != nil {
+			return 0, "", err
+		}
+		return float64(total) / (1024 * 1024 * 1024), "", nil
+	case AlertClientExpiry:
+		now := time.Now()
+		var emails []string
+		err := database.GetDB().Model(xray.ClientTraffic{}).
+			Where("enable = ? AND expiry_time > ? AND expiry_time <= ?", true,
+				now.UnixMilli(), now.Add(time.Duration(rule.Threshold*24*float64(time.Hour))).UnixMilli()).
+			Order("expiry_time").
+			Pluck("email", &emails).Error
+		if err != nil {
+			return 0, "", err
+		}
+		detail := emails
+		if len(detail) > 10 {
+			detail = append(detail[:10:10], "…")
+		}
+		return float64(len(emails)), strings.Join(detail, ", "), nil
+	case AlertCoreRestart:
+		return restarts, "", nil
+	case AlertUnreachable:
+		tags := unreachableInbounds()
+		return float64(len(tags)), strings.Join(tags, ", "), nil
+	case AlertSpeedtest:
+		mbps, ok, err := lastSpeedtestMbps()
+		if err != nil || !ok {
+			// No successful speedtest yet, nothing to compare
+			return -1, "", err
+		}
+		return mbps, "", nil
+	}
+	return 0, "", common.NewError("Unknown alert type:", rule.Type)
+}
+
+func checkAlertRule(rule *model.AlertRule) error {
+	if rule.Name == "" {
+		return common.NewError("Alert rule name is empty")
+	}
+	switch rule.Type {
+	case AlertCpu, AlertDisk:
+		if rule.Threshold <= 0 || rule.Threshold >= 100 {
+			return common.NewError("Alert threshold must be a percentage between 0 and 100:", rule.Threshold)
+		}
+	case AlertDailyTraffic, AlertClientExpiry, AlertSpeedtest:
+		if rule.Threshold <= 0 {
+			return common.NewError("Alert threshold must be positive:", rule.Threshold)
+		}
+	case AlertCoreRestart, AlertUnreachable:
+	default:
+		return common.NewError("Unknown alert type:", rule.Type)
+	}
+	if rule.RepeatMinutes < 0 {
+		return common.NewError("Alert repeat interval must not be negative:", rule.RepeatMinutes)
+	}
+	return nil
+}
@@ -0,0 +1,414 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/json_util"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// accessLogRegex matches an accepted connection in the Xray access log, e.g.
+// "2024/01/02 15:04:05.123456 from tcp:1.2.3.4:5678 accepted tcp:example.com:443 [in-443 -> direct] email: user".
+var accessLogRegex = regexp.MustCompile(
+	`^(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2})(?:\.\d+)? from (?:tcp:|udp:)?\[?([0-9a-fA-F\.:]+)\]?:\d+ accepted (tcp|udp):(.+):(\d+) \[([^\]]*)\](?: email: (.+))?$`)
+
+// ConnectionSummary is the aggregated count of connections to a destination or from a source IP.
+type ConnectionSummary struct {
+	Key      string `json:"key" gorm:"column:summary_key"` // Destination or source IP
+	Count    int64  `json:"count"`
+	LastSeen int64  `json:"lastSeen"`
+}
+
+// AnalyticsService aggregates the connections of the Xray access log into hourly
+// per-client records and answers which destinations and source IPs a client used.
+type AnalyticsService struct {
+	settingService SettingService
+	xrayAPI        xray.XrayAPI
+}
+
+// OnlineClient is a client with traffic in the last collection interval and the IPs it is connected from.
+type OnlineClient struct {
+	Email string      `json:"email"`
+	Ips   []*OnlineIp `json:"ips"`
+}
+
+// OnlineIp is a source IP of an online client.
+type OnlineIp struct {
+	Ip          string `json:"ip"`
+	Country     string `json:"country"`     // Two letter code from geoip.dat, empty if unknown
+	Connections int64  `json:"connections"` // Connections in the access log since the start of the last hour
+	LastSeen    int64  `json:"lastSeen"`    // Unix seconds
+}
+
+// ParseAccessLogLine parses an accepted connection of the access log. It reports false for
+// other lines and for connections without a client email.
+func ParseAccessLogLine(line string) (*model.ConnectionStat, bool) {
+	matches := accessLogRegex.FindStringSubmatch(strings.TrimSpace(line))
+	if matches == nil || matches[7] == "" {
+		return nil, false
+	}
+	seen, err := time.ParseInLocation("2006/01/02 15:04:05", matches[1], time.Local)
+	if err != nil {
+		seen = time.Now()
+	}
+	stat := &model.ConnectionStat{
+		Bucket:      seen.Truncate(time.Hour).Unix(),
+		Email:       strings.TrimSpace(matches[7]),
+		SourceIP:    matches[2],
+		Destination: strings.Trim(matches[4], "[]"),
+		Network:     matches[3],
+		Count:       1,
+		LastSeen:    seen.Unix(),
+	}
+	// Newer Xray versions separate the tags with ">>" instead of "->"
+	route := strings.ReplaceAll(matches[6], ">>", "->")
+	if inbound, outbound, ok := strings.Cut(route, "->"); ok {
+		stat.InboundTag = strings.TrimSpace(inbound)
+		stat.OutboundTag = strings.TrimSpace(outbound)
+	} else {
+		stat.InboundTag = strings.TrimSpace(route)
+	}
+	return stat, true
+}
+
+// AddConnections merges parsed connections into the hourly records.
+func (s *AnalyticsService) AddConnections(stats []*model.ConnectionStat) error {
+	if len(stats) == 0 {
+		return nil
+	}
+	// Merge the batch first, a busy log repeats the same connection many times
+	merged := make(map[model.ConnectionStat]*model.ConnectionStat)
+	destinations := make(map[string]bool)
+	for _, stat := range stats {
+		key := model.ConnectionStat{Bucket: stat.Bucket, Email: stat.Email, SourceIP: stat.SourceIP, Destination: stat.Destination, Network: stat.Network}
+		if existing := merged[key]; existing != nil {
+			existing.Count += stat.Count
+			existing.LastSeen = max(existing.LastSeen, stat.LastSeen)
+			existing.OutboundTag = stat.OutboundTag
+		} else {
+			copied := *stat
+			merged[key] = &copied
+			destinations[stat.Destination] = true
+		}
+	}
+	// Located once here, the breakdowns then only group the stored records
+	locations := locateDestinations(destinations)
+	for _, stat := range merged {
+		location := locations[stat.Destination]
+		stat.Country, stat.ASN, stat.ASOrg = location.country, location.asn, location.asOrg
+	}
+
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		for _, stat := range merged {
+			err := tx.Clauses(clause.OnConflict{
+				Columns: []clause.Column{{Name: "bucket"}, {Name: "email"}, {Name: "source_ip"}, {Name: "destination"}, {Name: "network"}},
+				// The later last seen is picked with CASE, as SQLite has MAX and MySQL GREATEST
+				DoUpdates: clause.Assignments(map[string]any{
+					"count":        gorm.Expr("count + ?", stat.Count),
+					"last_seen":    gorm.Expr("CASE WHEN last_seen < ? THEN ? ELSE last_seen END", stat.LastSeen, stat.LastSeen),
+					"outbound_tag": stat.OutboundTag,
+					"country":      stat.Country,
+					"asn":          stat.ASN,
+					"as_org":       stat.ASOrg,
+				}),
+			}).Create(stat).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// PruneConnections deletes the records older than the configured retention.
+func (s *AnalyticsService) PruneConnections() error {
+	days, err := s.settingService.GetAccessLogAnalyticsDays()
+	if err != nil {
+		return err
+	}
+	if days < 1 {
+		days = 1
+	}
+	before := time.Now().AddDate(0, 0, -days).Unix()
+	return database.GetDB().Where("bucket < ?", before).Delete(model.ConnectionStat{}).Error
+}
+
+// GetClientDestinations returns the destinations a client connected to in the last hours, most used first.
+func (s *AnalyticsService) GetClientDestinations(email string, hours int, limit int) ([]*ConnectionSummary, error) {
+	return s.summarize("destination", email, hours, limit)
+}
+
+// GetClientSourceIps returns the IPs a client connected from in the last hours, most used first.
+func (s *AnalyticsService) GetClientSourceIps(email string, hours int, limit int) ([]*ConnectionSummary, error) {
+	return s.summarize("source_ip", email, hours, limit)
+}
+
+// GetInboundDomains returns the domains the clients of an inbound connected to in the last hours,
+// most used first. Destinations that are IP addresses are left out, the domains come from the
+// sniffed TLS SNI or HTTP host of the connections.
+func (s *AnalyticsService) GetInboundDomains(tag string, hours int, limit int) ([]*ConnectionSummary, error) {
+	since := time.Now().Add(-time.Duration(hours) * time.Hour).Truncate(time.Hour).Unix()
+	rows, err := database.GetDB().Model(model.ConnectionStat{}).
+		Select("destination AS summary_key, SUM(count) AS count, MAX(last_seen) AS last_seen").
+		Where("inbound_tag = ? AND bucket >= ?", tag, since).
+		Group("destination").
+		Order("count desc").
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := []*ConnectionSummary{}
+	for rows.Next() && len(summaries) < limit {
+		summary := &ConnectionSummary{}
+		if err := database.GetDB().ScanRows(rows, summary); err != nil {
+			return nil, err
+		}
+		if net.ParseIP(summary.Key) != nil {
+			continue
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, rows.Err()
+}
+
+// summarize sums the connections of a client grouped by column.
+func (s *AnalyticsService) summarize(column string, email string, hours int, limit int) ([]*ConnectionSummary, error) {
+	since := time.Now().Add(-time.Duration(hours) * time.Hour).Truncate(time.Hour).Unix()
+	var summaries []*ConnectionSummary
+	err := database.GetDB().Model(model.ConnectionStat{}).
+		Select(column+" AS summary_key, SUM(count) AS count, MAX(last_seen) AS last_seen").
+		Where("email = ? AND bucket >= ?", email, since).
+		Group(column).
+		Order("count desc").
+		Limit(limit).
+		Scan(&summaries).Error
+	if err != nil {
+		return nil, err
+	}
+	return summaries, nil
+}
+
+// GetOnlineClients returns the online clients with the IPs Xray reports them connected from,
+// the country of each IP and its connections in the access log since the start of the last hour.
+// The IPs are only known while Xray runs, with sing-box the clients are returned without them.
+func (s *AnalyticsService) GetOnlineClients() ([]*OnlineClient, error) {
+	if p == nil || !p.IsRunning() {
+		return []*OnlineClient{}, nil
+	}
+	emails := p.GetOnlineClients()
+	sort.Strings(emails)
+	clients := make([]*OnlineClient, 0, len(emails))
+	for _, email := range emails {
+		clients = append(clients, &OnlineClient{Email: email, Ips: []*OnlineIp{}})
+	}
+	xp, ok := p.(*xray.Process)
+	if !ok || len(clients) == 0 {
+		return clients, nil
+	}
+
+	if err := s.xrayAPI.Init(xp.GetAPIPort()); err != nil {
+		return nil, err
+	}
+	defer s.xrayAPI.Close()
+
+	since := time.Now().Add(-time.Hour).Truncate(time.Hour).Unix()
+	for _, client := range clients {
+		ips, err := s.xrayAPI.GetOnlineIps(client.Email)
+		if err != nil {
+			continue
+		}
+		var summaries []*ConnectionSummary
+		err = database.GetDB().Model(model.ConnectionStat{}).
+			Select("source_ip AS summary_key, SUM(count) AS count, MAX(last_seen) AS last_seen").
+			Where("email = ? AND bucket >= ?", client.Email, since).
+			Group("source_ip").
+			Scan(&summaries).Error
+		if err != nil {
+			return nil, err
+		}
+		connections := make(map[string]int64, len(summaries))
+		for _, summary := range summaries {
+			connections[summary.Key] = summary.Count
+		}
+		for ip, lastSeen := range ips {
+			client.Ips = append(client.Ips, &OnlineIp{
+				Ip:          ip,
+				Country:     xray.GeoIPCountry(ip),
+				Connections: connections[ip],
+				LastSeen:    lastSeen,
+			})
+		}
+		sort.Slice(client.Ips, func(i, j int) bool {
+			return client.Ips[i].LastSeen > client.Ips[j].LastSeen
+		})
+	}
+	return clients, nil
+}
+
+// enableUserOnlineStats turns on the online IP tracking of the clients in policy level 0,
+// which the online clients view reads through the StatsService.
+func enableUserOnlineStats(policyConfig json_util.RawMessage) (json_util.RawMessage, error) {
+	policy := map[string]any{}
+	if len(policyConfig) > 0 {
+		if err := json.Unmarshal(policyConfig, &policy); err != nil {
+			return nil, err
+		}
+	}
+	levels, _ := policy["levels"].(map[string]any)
+	if levels == nil {
+		levels = map[string]any{}
+	}
+	level, _ := levels["0"].(map[string]any)
+	if level == nil {
+		level = map[string]any{}
+	}
+	if level["statsUserOnline"] == true {
+		return policyConfig, nil
+	}
+	level["statsUserOnline"] = true
+	levels["0"] = level
+	policy["levels"] = levels
+	return json.MarshalIndent(policy, "", "  ")
+}
+
+// GeoSummary is the aggregated count of connections to the destinations of a country or a network.
+type GeoSummary struct {
+	Key          string `json:"key" gorm:"column:summary_key"` // Country code or organization of the network, empty if unknown
+	ASN          uint   `json:"asn,omitempty"`                 // Autonomous system number of a network
+	Connections  int64  `json:"connections"`
+	Destinations int    `json:"destinations"`
+}
+
+// GeoBreakdown splits the connections of a client or an inbound by destination country and network.
+type GeoBreakdown struct {
+	Countries []*GeoSummary `json:"countries"`
+	Networks  []*GeoSummary `json:"networks"`
+}
+
+// GetGeoBreakdown returns the connections of a client email or an inbound tag in the last hours,
+// grouped by the country and the autonomous system of their destinations, which were located
+// when the access log was read.
+func (s *AnalyticsService) GetGeoBreakdown(kind string, name string, hours int) (*GeoBreakdown, error) {
+	var column string
+	switch kind {
+	case "client":
+		column = "email"
+	case "inbound":
+		column = "inbound_tag"
+	default:
+		return nil, common.NewError("Breakdown kind must be client or inbound:", kind)
+	}
+	since := time.Now().Add(-time.Duration(hours) * time.Hour).Truncate(time.Hour).Unix()
+	query := func() *gorm.DB {
+		return database.GetDB().Model(model.ConnectionStat{}).
+			Where(column+" = ? AND bucket >= ?", name, since).
+			Order("connections desc, summary_key")
+	}
+
+	breakdown := &GeoBreakdown{Countries: []*GeoSummary{}, Networks: []*GeoSummary{}}
+	err := query().
+		Select("country AS summary_key, SUM(count) AS connections, COUNT(DISTINCT destination) AS destinations").
+		Group("country").
+		Scan(&breakdown.Countries).Error
+	if err != nil {
+		return nil, err
+	}
+	// An organization may be renamed while the hours are covered, the number groups the network
+	err = query().
+		Select("asn, MAX(as_org) AS summary_key, SUM(count) AS connections, COUNT(DISTINCT destination) AS destinations").
+		Group("asn").
+		Scan(&breakdown.Networks).Error
+	if err != nil {
+		return nil, err
+	}
+	return breakdown, nil
+}
+
+// destinationLocation is the country and the autonomous system a destination was located in.
+type destinationLocation struct {
+	country string
+	asn     uint
+	asOrg   string
+	expires time.Time
+}
+
+var (
+	destinationLocationsLock sync.Mutex
+	destinationLocations     = map[string]destinationLocation{}
+)
+
+// locateDestinations locates destinations in geoip.dat and GeoLite2-ASN, resolving domains to
+// their first address. Locations are cached for an hour, and destinations that fail to resolve
+// are located nowhere.
+func locateDestinations(destinations map[string]bool) map[string]destinationLocation {
+	locations := make(map[string]destinationLocation, len(destinations))
+	var pending []string
+	now := time.Now()
+	destinationLocationsLock.Lock()
+	for destination := range destinations {
+		if cached, ok := destinationLocations[destination]; ok && now.Before(cached.expires) {
+			locations[destination] = cached
+		} else {
+			pending = append(pending, destination)
+		}
+	}
+	destinationLocationsLock.Unlock()
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	sem := make(chan struct{}, 16)
+	for _, destination := range pending {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(destination string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			location := destinationLocation{expires: now.Add(time.Hour)}
+			ip := destination
+			if net.ParseIP(destination) == nil {
+				ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+				defer cancel()
+				ip = ""
+				if addrs, err := net.DefaultResolver.LookupIPAddr(ctx, destination); err == nil && len(addrs) > 0 {
+					ip = addrs[0].IP.String()
+				}
+			}
+			if ip != "" {
+				location.country = xray.GeoIPCountry(ip)
+				location.asn, location.asOrg, _ = xray.LookupASN(ip)
+			}
+			lock.Lock()
+			locations[destination] = location
+			lock.Unlock()
+			destinationLocationsLock.Lock()
+			destinationLocations[destination] = location
+			destinationLocationsLock.Unlock()
+		}(destination)
+	}
+	wg.Wait()
+
+	// Drop the expired entries so the cache does not grow with every destination ever seen
+	destinationLocationsLock.Lock()
+	for destination, cached := range destinationLocations {
+		if now.After(cached.expires) {
+			delete(destinationLocations, destination)
+		}
+	}
+	destinationLocationsLock.Unlock()
+	return locations
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+// Types of the traffic anomalies.
+const (
+	AnomalySpike    = "spike"    // A day with several times the typical traffic of the client
+	AnomalyConstant = "constant" // Traffic in nearly every hour of a day, typical of shared or resold accounts
+)
+
+const (
+	anomalyBaselineDays    = 14                 // Days before the checked day the typical traffic is learned from
+	anomalyMinHistoryDays  = 7                  // Days of history a client needs before spikes are detected
+	anomalyMinSpikeBytes   = 1024 * 1024 * 1024 // Days below this are never a spike, whatever the baseline
+	anomalyActiveHourBytes = 1024 * 1024        // Traffic an hour needs to count as active
+	anomalyConstantHours   = 23                 // Active hours of a day that make it constant
+	anomalyRetention       = 90 * 24 * time.Hour
+)
+
+// AnomalyService learns the typical daily traffic of every client and flags days that do not fit it.
+type AnomalyService struct {
+	settingService SettingService
+}
+
+// DetectAnomalies checks the traffic of every client on the previous day in the panel time zone
+// and stores the anomalies found. A day is a spike when its traffic reaches the spike factor times
+// the median daily traffic of the preceding two weeks, and constant when the client had traffic in
+// nearly every hour of it. It returns the anomalies that were not found before.
+func (s *AnomalyService) DetectAnomalies() ([]*model.TrafficAnomaly, error) {
+	factor, err := s.settingService.GetAnomalySpikeFactor()
+	if err != nil {
+		return nil, err
+	}
+	loc, err := s.settingService.GetTimeLocation()
+	if err != nil {
+		loc = time.Local
+	}
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	dayStart := today.AddDate(0, 0, -1)
+	day := dayStart.Format("2006-01-02")
+	baselineFrom := dayStart.AddDate(0, 0, -anomalyBaselineDays).Format("2006-01-02")
+
+	db := database.GetDB()
+	var traffics []*model.ClientTrafficDaily
+	err = db.Model(model.ClientTrafficDaily{}).
+		Where("day >= ? AND day <= ?", baselineFrom, day).
+		Find(&traffics).Error
+	if err != nil {
+		return nil, err
+	}
+	usage := map[string]int64{}
+	history := map[string]map[string]int64{}
+	for _, traffic := range traffics {
+		if traffic.Day == day {
+			usage[traffic.Email] = traffic.Up + traffic.Down
+			continue
+		}
+		if history[traffic.Email] == nil {
+			history[traffic.Email] = map[string]int64{}
+		}
+		history[traffic.Email][traffic.Day] = traffic.Up + traffic.Down
+	}
+
+	var samples []*model.TrafficSample
+	err = db.Model(model.TrafficSample{}).
+		Where("kind = ? AND resolution = ? AND time >= ? AND time < ?", "client", TrafficSampleHour, dayStart.UnixMilli(), today.UnixMilli()).
+		Find(&samples).Error
+	if err != nil {
+		return nil, err
+	}
+	activeHours := map[string]int{}
+	for _, sample := range samples {
+		if sample.Up+sample.Down >= anomalyActiveHourBytes {
+			activeHours[sample.Name]++
+		}
+	}
+
+	detectedAt := time.Now().Unix()
+	var found []*model.TrafficAnomaly
+	for email, bytes := range usage {
+		if baseline, ok := anomalyBaseline(history[email], dayStart); ok && factor > 0 &&
+			bytes >= anomalyMinSpikeBytes && bytes >= baseline*int64(factor) {
+			found = append(found, &model.TrafficAnomaly{
+				Email: email, Type: AnomalySpike, Day: day, Usage: bytes, Baseline: baseline,
+				ActiveHours: activeHours[email], DetectedAt: detectedAt,
+			})
+		}
+		if activeHours[email] >= anomalyConstantHours {
+			baseline, _ := anomalyBaseline(history[email], dayStart)
+			found = append(found, &model.TrafficAnomaly{
+				Email: email, Type: AnomalyConstant, Day: day, Usage: bytes, Baseline: baseline,
+				ActiveHours: activeHours[email], DetectedAt: detectedAt,
+			})
+		}
+	}
+
+	var added []*model.TrafficAnomaly
+	for _, anomaly := range found {
+		var count int64
+		err := db.Model(model.TrafficAnomaly{}).
+			Where("email = ? AND type = ? AND day = ?", anomaly.Email, anomaly.Type, anomaly.Day).
+			Count(&count).Error
+		if err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			continue
+		}
+		if err := db.Create(anomaly).Error; err != nil {
+			return nil, err
+		}
+		added = append(added, anomaly)
+	}
+
+	expired := today.Add(-anomalyRetention).Format("2006-01-02")
+	if err := db.Where("day < ?", expired).Delete(model.TrafficAnomaly{}).Error; err != nil {
+		return nil, err
+	}
+	slices.SortFunc(added, func(a, b *model.TrafficAnomaly) int { return cmp.Compare(b.Usage, a.Usage) })
+	return added, nil
+}
+
+// GetAnomalies returns the anomalies of the last days, newest first. An empty email returns those of all clients.
+func (s *AnomalyService) GetAnomalies(email string, days int) ([]*model.TrafficAnomaly, error) {
+	loc, err := s.settingService.GetTimeLocation()
+	if err != nil {
+		loc = time.Local
+	}
+	from := time.Now().In(loc).AddDate(0, 0, -days).Format("2006-01-02")
+	query := database.GetDB().Model(model.TrafficAnomaly{}).Where("day >= ?", from)
+	if email != "" {
+		query = query.Where("email = ?", email)
+	}
+	var anomalies []*model.TrafficAnomaly
+	if err := query.Order("day desc, usage desc").Find(&anomalies).Error; err != nil {
+		return nil, err
+	}
+	return anomalies, nil
+}
+
+// anomalyBaseline returns the median daily traffic of a client over the baseline days before day,
+// counting the days without traffic since it was first seen. It reports false when the client has
+// less history than anomalyMinHistoryDays.
+func anomalyBaseline(history map[string]int64, day time.Time) (int64, bool) {
+	var daily []int64
+	seen := false
+	for i := anomalyBaselineDays; i >= 1; i-- {
+		bytes, ok := history[day.AddDate(0, 0, -i).Format("2006-01-02")]
+		seen = seen || ok
+		if seen {
+			daily = append(daily, bytes)
+		}
+	}
+	if len(daily) < anomalyMinHistoryDays {
+		return 0, false
+	}
+	slices.Sort(daily)
+	return daily[len(daily)/2], true
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// WriteBackup writes a gzipped tar archive of the database and the Xray configuration, the files
+// the panel sends as backups over Telegram and email.
+func (s *ServerService) WriteBackup(w io.Writer) error {
+	db, size, err := s.OpenDb()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	now := time.Now()
+	add := func(name string, data io.Reader, size int64) error {
+		header := &tar.Header{Name: name, Mode: 0o600, Size: size, ModTime: now}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := io.CopyN(tw, data, size)
+		return err
+	}
+	if err := add(filepath.Base(config.GetDBPath()), db, size); err != nil {
+		return err
+	}
+	if xrayConfig, err := os.ReadFile(xray.GetConfigPath()); err == nil {
+		if err := add(filepath.Base(xray.GetConfigPath()), bytes.NewReader(xrayConfig), int64(len(xrayConfig))); err != nil {
+			return err
+		}
+	} else {
+		logger.Warning("Error in reading config.json for the backup:", err)
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// RestoreBackup restores the database from a backup archive written by WriteBackup, or from a
+// plain database file as downloaded from the panel. The Xray configuration is generated again from
+// the database, so that of the archive is left out.
+func (s *ServerService) RestoreBackup(path string, beforeReplace func()) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	magic, _ := bufio.NewReader(file).Peek(2)
+	if !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		return s.RestoreDB(file, beforeReplace)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	gr, err := gzip.NewReader(file)
+	if err != nil {
+		return common.NewErrorf("Invalid backup archive: %v", err)
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	dbName := filepath.Base(config.GetDBPath())
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return common.NewError("No database in the backup archive")
+		}
+		if err != nil {
+			return common.NewErrorf("Invalid backup archive: %v", err)
+		}
+		if header.Typeflag != tar.TypeReg || filepath.Base(header.Name) != dbName {
+			continue
+		}
+		temp, err := os.CreateTemp(filepath.Dir(config.GetDBPath()), dbName+".restore-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(temp.Name())
+		defer temp.Close()
+		if _, err := io.Copy(temp, tr); err != nil {
+			return common.NewErrorf("Error extracting the database: %v", err)
+		}
+		if _, err := temp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		return s.RestoreDB(temp, beforeReplace)
+	}
+}
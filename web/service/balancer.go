@@ -0,0 +1,293 @@
+package service
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/json_util"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// BalancerService provides business logic for the panel-managed Xray balancers.
+// Balancers with a leastPing or leastLoad strategy get an observatory that probes
+// their outbounds, unless the template configures one itself.
+type BalancerService struct {
+	settingService  SettingService
+	outboundService OutboundService
+}
+
+// GetBalancers returns all panel-managed balancers ordered by tag.
+func (s *BalancerService) GetBalancers() ([]*model.Balancer, error) {
+	db := database.GetDB()
+	var balancers []*model.Balancer
+	err := db.Model(model.Balancer{}).Order("tag").Find(&balancers).Error
+	if err != nil {
+		return nil, err
+	}
+	return balancers, nil
+}
+
+// GetBalancer returns the panel-managed balancer with the given ID.
+func (s *BalancerService) GetBalancer(id int) (*model.Balancer, error) {
+	db := database.GetDB()
+	balancer := &model.Balancer{}
+	err := db.Model(model.Balancer{}).First(balancer, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return balancer, nil
+}
+
+// AddBalancer validates and creates a balancer.
+func (s *BalancerService) AddBalancer(balancer *model.Balancer) error {
+	balancer.Id = 0
+	if err := s.validateBalancer(balancer); err != nil {
+		return err
+	}
+	return database.GetDB().Create(balancer).Error
+}
+
+// UpdateBalancer validates and saves a balancer. Its tag cannot change while routing rules use it.
+func (s *BalancerService) UpdateBalancer(balancer *model.Balancer) error {
+	oldBalancer, err := s.GetBalancer(balancer.Id)
+	if err != nil {
+		return err
+	}
+	if oldBalancer.Tag != balancer.Tag || (oldBalancer.Enable && !balancer.Enable) {
+		if err := checkBalancerUnused(oldBalancer.Tag); err != nil {
+			return err
+		}
+	}
+	if err := s.validateBalancer(balancer); err != nil {
+		return err
+	}
+	return database.GetDB().Save(balancer).Error
+}
+
+// DelBalancer deletes a balancer that no routing rule uses.
+func (s *BalancerService) DelBalancer(id int) error {
+	balancer, err := s.GetBalancer(id)
+	if err != nil {
+		return err
+	}
+	if err := checkBalancerUnused(balancer.Tag); err != nil {
+		return err
+	}
+	return database.GetDB().Delete(model.Balancer{}, id).Error
+}
+
+// checkBalancerUnused returns an error if a routing rule uses the balancer tag.
+func checkBalancerUnused(tag string) error {
+	var count int64
+	err := database.GetDB().Model(model.RoutingRule{}).Where("balancer_tag = ?", tag).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return common.NewError("Balancer is used by routing rules:", tag)
+	}
+	return nil
+}
+
+// GetBalancerTags returns the tags of the balancers in the template and of the enabled panel-managed balancers.
+func (s *BalancerService) GetBalancerTags() (map[string]bool, error) {
+	tags, err := s.getTemplateBalancerTags()
+	if err != nil {
+		return nil, err
+	}
+	balancers, err := s.GetBalancers()
+	if err != nil {
+		return nil, err
+	}
+	for _, balancer := range balancers {
+		if balancer.Enable {
+			tags[balancer.Tag] = true
+		}
+	}
+	return tags, nil
+}
+
+// getTemplateBalancerTags returns the tags of the balancers in the routing section of the template.
+func (s *BalancerService) getTemplateBalancerTags() (map[string]bool, error) {
+	templateConfig, err := s.settingService.GetXrayConfigTemplate()
+	if err != nil {
+		return nil, err
+	}
+	config := struct {
+		Routing struct {
+			Balancers []struct {
+				Tag string `json:"tag"`
+			} `json:"balancers"`
+		} `json:"routing"`
+	}{}
+	if err := json.Unmarshal([]byte(templateConfig), &config); err != nil {
+		return nil, err
+	}
+	tags := make(map[string]bool, len(config.Routing.Balancers))
+	for _, balancer := range config.Routing.Balancers {
+		tags[balancer.Tag] = true
+	}
+	return tags, nil
+}
+
+// validateBalancer checks the selector, strategy and fallback of a balancer.
+func (s *BalancerService) validateBalancer(balancer *model.Balancer) error {
+	balancer.Tag = strings.TrimSpace(balancer.Tag)
+	balancer.Selector = strings.Join(splitRuleList(balancer.Selector), ",")
+	balancer.FallbackTag = strings.TrimSpace(balancer.FallbackTag)
+
+	if balancer.Tag == "" {
+		return common.NewError("Balancer tag is empty")
+	}
+	templateTags, err := s.getTemplateBalancerTags()
+	if err != nil {
+		return err
+	}
+	if templateTags[balancer.Tag] {
+		return common.NewError("Balancer tag is already used in the Xray template:", balancer.Tag)
+	}
+	if balancer.Selector == "" {
+		return common.NewError("Balancer has no outbound selector")
+	}
+	switch balancer.Strategy {
+	case "random", "roundRobin", "leastPing", "leastLoad":
+	default:
+		return common.NewError("Balancer strategy is not supported:", balancer.Strategy)
+	}
+	if balancer.FallbackTag != "" {
+		tags, err := s.outboundService.GetOutboundTags()
+		if err != nil {
+			return err
+		}
+		if !tags[balancer.FallbackTag] {
+			return common.NewError("Balancer fallback outbound does not exist:", balancer.FallbackTag)
+		}
+	}
+	return nil
+}
+
+// addBalancers adds the enabled panel-managed balancers to the routing section of the config,
+// with an observatory for the ones whose strategy needs outbound latencies.
+func (s *BalancerService) addBalancers(xrayConfig *xray.Config) error {
+	balancers, err := s.GetBalancers()
+	if err != nil {
+		return err
+	}
+	var xrayBalancers []any
+	var observed []string
+	needsBurst := false
+	for _, balancer := range balancers {
+		if !balancer.Enable {
+			continue
+		}
+		selector := splitRuleList(balancer.Selector)
+		xrayBalancer := map[string]any{
+			"tag":      balancer.Tag,
+			"selector": selector,
+			"strategy": map[string]any{"type": balancer.Strategy},
+		}
+		if balancer.FallbackTag != "" {
+			xrayBalancer["fallbackTag"] = balancer.FallbackTag
+		}
+		xrayBalancers = append(xrayBalancers, xrayBalancer)
+
+		switch balancer.Strategy {
+		case "leastLoad":
+			needsBurst = true
+			fallthrough
+		case "leastPing":
+			for _, prefix := range selector {
+				if !slices.Contains(observed, prefix) {
+					observed = append(observed, prefix)
+				}
+			}
+		}
+	}
+	if len(xrayBalancers) == 0 {
+		return nil
+	}
+
+	routing := map[string]any{}
+	if len(xrayConfig.RouterConfig) > 0 {
+		if err := json.Unmarshal(xrayConfig.RouterConfig, &routing); err != nil {
+			return err
+		}
+	}
+	templateBalancers, _ := routing["balancers"].([]any)
+	routing["balancers"] = append(templateBalancers, xrayBalancers...)
+	routerConfig, err := json.MarshalIndent(routing, "", "  ")
+	if err != nil {
+		return err
+	}
+	xrayConfig.RouterConfig = routerConfig
+
+	// The template keeps control of the observatory if it configures one
+	if len(observed) == 0 || len(xrayConfig.Observatory) > 0 || len(xrayConfig.BurstObservatory) > 0 {
+		return nil
+	}
+	probeUrl, err := s.settingService.GetObservatoryProbeUrl()
+	if err != nil {
+		return err
+	}
+	probeInterval, err := s.settingService.GetObservatoryProbeInterval()
+	if err != nil {
+		return err
+	}
+	// Xray runs a single observatory, the burst observatory also serves leastPing balancers
+	if needsBurst {
+		xrayConfig.BurstObservatory, err = json.MarshalIndent(map[string]any{
+			"subjectSelector": observed,
+			"pingConfig": map[string]any{
+				"destination": probeUrl,
+				"interval":    probeInterval,
+				"sampling":    3,
+				"timeout":     "5s",
+			},
+		}, "", "  ")
+	} else {
+		xrayConfig.Observatory, err = json.MarshalIndent(map[string]any{
+			"subjectSelector":   observed,
+			"probeUrl":          probeUrl,
+			"probeInterval":     probeInterval,
+			"enableConcurrency": true,
+		}, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	xrayConfig.API, err = addAPIService(xrayConfig.API, "ObservatoryService")
+	return err
+}
+
+// addAPIService adds a service to the services of the Xray API section if it is missing.
+func addAPIService(apiConfig json_util.RawMessage, service string) (json_util.RawMessage, error) {
+	api := map[string]any{}
+	if len(apiConfig) > 0 {
+		if err := json.Unmarshal(apiConfig, &api); err != nil {
+			return nil, err
+		}
+	}
+	services, _ := api["services"].([]any)
+	for _, s := range services {
+		if s == service {
+			return apiConfig, nil
+		}
+	}
+	api["services"] = append(services, service)
+	return json.MarshalIndent(api, "", "  ")
+}
+
+// hasAPIService reports whether the API section of a config enables the named service.
+func hasAPIService(apiConfig json_util.RawMessage, service string) bool {
+	api := struct {
+		Services []string `json:"services"`
+	}{}
+	if err := json.Unmarshal(apiConfig, &api); err != nil {
+		return false
+	}
+	return slices.Contains(api.Services, service)
+}
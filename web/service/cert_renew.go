@@ -0,0 +1,165 @@
+package service
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+)
+
+// certReloadInterval is how often the certificate files of the listeners are checked for changes.
+const certReloadInterval = time.Minute
+
+// reloadingCert is a certificate and key file pair served by a listener, loaded again when the files
+// change so that renewed certificates are used without restarting the panel.
+type reloadingCert struct {
+	certPath string
+	keyPath  string
+
+	mu      sync.Mutex
+	checked time.Time
+	modTime time.Time
+	cert    *tls.Certificate
+}
+
+// loadCertFile loads a certificate and key file pair.
+func loadCertFile(certPath, keyPath string) (*reloadingCert, error) {
+	c := &reloadingCert{certPath: certPath, keyPath: keyPath}
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	c.checked, c.modTime, c.cert = time.Now(), modTime, &cert
+	return c, nil
+}
+
+// latestModTime returns the time either file was changed last.
+func (c *reloadingCert) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{c.certPath, c.keyPath} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// Get returns the certificate, loading it again when the files changed. A pair that does not
+// load, e.g. while the files are being replaced, keeps the previous certificate.
+func (c *reloadingCert) Get() *tls.Certificate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.checked) < certReloadInterval {
+		return c.cert
+	}
+	c.checked = time.Now()
+	modTime, err := c.latestModTime()
+	if err != nil || modTime.Equal(c.modTime) {
+		return c.cert
+	}
+	cert, err := tls.LoadX509KeyPair(c.certPath, c.keyPath)
+	if err != nil {
+		logger.Warning("Error reloading the certificate", c.certPath+":", err)
+		return c.cert
+	}
+	c.modTime, c.cert = modTime, &cert
+	logger.Info("Certificate", c.certPath, "reloaded")
+	return c.cert
+}
+
+// AcmeCertPaths returns the files the ACME certificate of a domain is written to, for the TLS
+// inbounds to use.
+func AcmeCertPaths(domain string) (certPath string, keyPath string) {
+	dir := filepath.Join(config.GetDBFolderPath(), "cert", domain)
+	return filepath.Join(dir, "fullchain.pem"), filepath.Join(dir, "privkey.pem")
+}
+
+// Renew makes sure the ACME certificates are obtained, which renews those about to expire, and
+// writes the new ones to their files. It returns the files written and the domains whose
+// certificate could not be obtained.
+func (s *AcmeService) Renew() ([]string, map[string]error) {
+	acmeState.Lock()
+	getCertificate, domains := acmeState.getCertificate, acmeState.domains
+	acmeState.Unlock()
+
+	var written []string
+	failed := make(map[string]error)
+	for _, domain := range domains {
+		cert, err := getCertificate(acmeHello(domain))
+		if err != nil {
+			failed[domain] = err
+			continue
+		}
+		certPath, keyPath := AcmeCertPaths(domain)
+		if changed, err := writeCertFiles(cert, certPath, keyPath); err != nil {
+			logger.Warning("Error writing the ACME certificate of", domain+":", err)
+		} else if changed {
+			written = append(written, certPath)
+		}
+	}
+	return written, failed
+}
+
+// writeCertFiles writes a certificate chain and its key unless the files hold it already. Each
+// file is replaced at once, so that readers never see a partial file.
+func writeCertFiles(cert *tls.Certificate, certPath, keyPath string) (bool, error) {
+	var chain bytes.Buffer
+	for _, der := range cert.Certificate {
+		pem.Encode(&chain, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	if current, err := os.ReadFile(certPath); err == nil && bytes.Equal(current, chain.Bytes()) {
+		return false, nil
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return false, err
+	}
+	key := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})
+
+	if err := os.MkdirAll(filepath.Dir(certPath), 0o700); err != nil {
+		return false, err
+	}
+	if err := replaceFile(keyPath, key, 0o600); err != nil {
+		return false, err
+	}
+	if err := replaceFile(certPath, chain.Bytes(), 0o644); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// replaceFile writes a file through a temporary file renamed over it.
+func replaceFile(path string, data []byte, perm os.FileMode) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Chmod(perm); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"cmp"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+)
+
+// CertificateStatus is a certificate used by the panel, the subscription server, the certificate
+// store, the ACME client or the TLS inbounds, with when it expires.
+type CertificateStatus struct {
+	Source    string   `json:"source"` // The certificate file, or "inline:<tag>" for a certificate in the settings of an inbound
+	Domains   []string `json:"domains"`
+	Issuer    string   `json:"issuer"`
+	Serial    string   `json:"serial"`
+	NotBefore int64    `json:"notBefore"` // Unix milliseconds
+	NotAfter  int64    `json:"notAfter"`  // Unix milliseconds
+	DaysLeft  int      `json:"daysLeft"`  // Whole days until it expires, negative once expired
+	UsedBy    []string `json:"usedBy"`    // "panel", "subscription", "store:<remark>" or "acme:<domain>"
+	Inbounds  []string `json:"inbounds"`  // Tags of the inbounds using it
+	Error     string   `json:"error,omitempty"`
+
+	data []byte // The PEM of an inline certificate
+}
+
+// IsInline reports whether the certificate is in the settings of an inbound rather than a file.
+func (c *CertificateStatus) IsInline() bool {
+	return strings.HasPrefix(c.Source, "inline:")
+}
+
+// GetCertificateStatus returns every certificate in use, including the files managed outside the
+// panel, those expiring first at the front. A certificate that cannot be read has its Error set.
+func (s *CertificateService) GetCertificateStatus() ([]*CertificateStatus, error) {
+	settingService := SettingService{}
+	statuses := make(map[string]*CertificateStatus)
+	var order []string
+	status := func(source string) *CertificateStatus {
+		if statuses[source] == nil {
+			statuses[source] = &CertificateStatus{Source: source, Domains: []string{}, UsedBy: []string{}, Inbounds: []string{}}
+			order = append(order, source)
+		}
+		return statuses[source]
+	}
+	use := func(source, user string) {
+		if source != "" && !slices.Contains(status(source).UsedBy, user) {
+			status(source).UsedBy = append(status(source).UsedBy, user)
+		}
+	}
+
+	if file, err := settingService.GetCertFile(); err == nil {
+		use(file, "panel")
+	}
+	if file, err := settingService.GetSubCertFile(); err == nil {
+		use(file, "subscription")
+	}
+	certificates, err := s.GetCertificates()
+	if err != nil {
+		return nil, err
+	}
+	for _, certificate := range certificates {
+		if !certificate.Enable {
+			continue
+		}
+		name := certificate.Remark
+		if name == "" {
+			name = "#" + strconv.Itoa(certificate.Id)
+		}
+		use(certificate.CertFile, "store:"+name)
+	}
+	acmeState.Lock()
+	acmeDomains := acmeState.domains
+	acmeState.Unlock()
+	for _, domain := range acmeDomains {
+		if file, _ := AcmeCertPaths(domain); fileExists(file) {
+			use(file, "acme:"+domain)
+		}
+	}
+
+	var inbounds []*model.Inbound
+	err = database.GetDB().Model(model.Inbound{}).Where("enable = ?", true).Find(&inbounds).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, inbound := range inbounds {
+		var stream struct {
+			Security    string `json:"security"`
+			TlsSettings struct {
+				Certificates []struct {
+					CertificateFile string   `json:"certificateFile"`
+					Certificate     []string `json:"certificate"`
+				} `json:"certificates"`
+			} `json:"tlsSettings"`
+		}
+		if inbound.StreamSettings == "" || json.Unmarshal([]byte(inbound.StreamSettings), &stream) != nil || stream.Security != "tls" {
+			continue
+		}
+		inline := 0
+		for _, certificate := range stream.TlsSettings.Certificates {
+			source := certificate.CertificateFile
+			if source == "" {
+				if len(certificate.Certificate) == 0 {
+					continue
+				}
+				if inline++; inline > 1 {
+					source = "inline:" + inbound.Tag + "#" + strconv.Itoa(inline)
+				} else {
+					source = "inline:" + inbound.Tag
+				}
+				status(source).data = []byte(strings.Join(certificate.Certificate, "\n"))
+			}
+			if c := status(source); !slices.Contains(c.Inbounds, inbound.Tag) {
+				c.Inbounds = append(c.Inbounds, inbound.Tag)
+			}
+		}
+	}
+
+	result := make([]*CertificateStatus, 0, len(order))
+	for _, source := range order {
+		c := statuses[source]
+		data := c.data
+		if !c.IsInline() {
+			if data, err = os.ReadFile(source); err != nil {
+				c.Error = err.Error()
+			}
+		}
+		if c.Error == "" {
+			if leaf, err := parseLeafCertificate(data); err != nil {
+				c.Error = err.Error()
+			} else {
+				c.fill(leaf)
+			}
+		}
+		result = append(result, c)
+	}
+	slices.SortStableFunc(result, func(a, b *CertificateStatus) int {
+		if (a.Error != "") != (b.Error != "") {
+			if a.Error != "" {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Compare(a.NotAfter, b.NotAfter)
+	})
+	return result, nil
+}
+
+// fill sets the details of the certificate.
+func (c *CertificateStatus) fill(leaf *x509.Certificate) {
+	c.Domains = append(c.Domains, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		c.Domains = append(c.Domains, ip.String())
+	}
+	if len(c.Domains) == 0 && leaf.Subject.CommonName != "" {
+		c.Domains = []string{leaf.Subject.CommonName}
+	}
+	c.Issuer = leaf.Issuer.CommonName
+	if c.Issuer == "" && len(leaf.Issuer.Organization) > 0 {
+		c.Issuer = leaf.Issuer.Organization[0]
+	}
+	c.Serial = leaf.SerialNumber.String()
+	c.NotBefore = leaf.NotBefore.UnixMilli()
+	c.NotAfter = leaf.NotAfter.UnixMilli()
+	c.DaysLeft = int(math.Floor(time.Until(leaf.NotAfter).Hours() / 24))
+}
+
+// parseLeafCertificate returns the first certificate of PEM data.
+func parseLeafCertificate(data []byte) (*x509.Certificate, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, common.NewError("no certificate found")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+// fileExists reports whether a file exists.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
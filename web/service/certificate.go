@@ -0,0 +1,295 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+
+	"gorm.io/gorm"
+)
+
+// certStore holds the enabled certificates of the store, loaded for the listeners of the panel.
+var certStore struct {
+	sync.RWMutex
+	entries []certStoreEntry
+}
+
+// certStoreEntry is a certificate of the store with the names it is served for.
+type certStoreEntry struct {
+	names []string
+	cert  *reloadingCert
+}
+
+// CertificateService manages the certificate store, the certificate and key file pairs mapped
+// to domains. The listeners of the panel pick the certificate of the name a client asks for,
+// and the inbounds with the certStore option of their TLS settings get all of them.
+type CertificateService struct{}
+
+// GetCertificates returns all the certificates of the store.
+func (s *CertificateService) GetCertificates() ([]*model.Certificate, error) {
+	db := database.GetDB()
+	var certificates []*model.Certificate
+	err := db.Model(model.Certificate{}).Order("id").Find(&certificates).Error
+	if err != nil {
+		return nil, err
+	}
+	return certificates, nil
+}
+
+// GetCertificate returns the certificate with the given ID.
+func (s *CertificateService) GetCertificate(id int) (*model.Certificate, error) {
+	db := database.GetDB()
+	certificate := &model.Certificate{}
+	err := db.Model(model.Certificate{}).First(certificate, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return certificate, nil
+}
+
+// AddCertificate validates and adds a certificate to the store. It reports whether inbounds
+// using the store changed and Xray needs a restart.
+func (s *CertificateService) AddCertificate(certificate *model.Certificate) (bool, error) {
+	certificate.Id = 0
+	if err := s.validateCertificate(certificate); err != nil {
+		return false, err
+	}
+	return s.save(func(tx *gorm.DB) error {
+		return tx.Create(certificate).Error
+	})
+}
+
+// UpdateCertificate validates and saves a certificate of the store. It reports whether inbounds
+// using the store changed and Xray needs a restart.
+func (s *CertificateService) UpdateCertificate(certificate *model.Certificate) (bool, error) {
+	if _, err := s.GetCertificate(certificate.Id); err != nil {
+		return false, err
+	}
+	if err := s.validateCertificate(certificate); err != nil {
+		return false, err
+	}
+	return s.save(func(tx *gorm.DB) error {
+		return tx.Save(certificate).Error
+	})
+}
+
+// DelCertificate removes a certificate from the store. It reports whether inbounds using the
+// store changed and Xray needs a restart.
+func (s *CertificateService) DelCertificate(id int) (bool, error) {
+	return s.save(func(tx *gorm.DB) error {
+		return tx.Delete(model.Certificate{}, id).Error
+	})
+}
+
+// save changes the store, gives the inbounds using it the enabled certificates and loads them
+// for the listeners of the panel.
+func (s *CertificateService) save(change func(tx *gorm.DB) error) (bool, error) {
+	needRestart := false
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := change(tx); err != nil {
+			return err
+		}
+		var err error
+		needRestart, err = cascadeCertStore(tx)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	if err := s.LoadStore(); err != nil {
+		logger.Warning("Error loading the certificate store:", err)
+	}
+	return needRestart, nil
+}
+
+// validateCertificate checks that the files hold a certificate and its key, and cleans up the
+// domains.
+func (s *CertificateService) validateCertificate(certificate *model.Certificate) error {
+	certificate.Remark = strings.TrimSpace(certificate.Remark)
+	certificate.CertFile = strings.TrimSpace(certificate.CertFile)
+	certificate.KeyFile = strings.TrimSpace(certificate.KeyFile)
+	if certificate.CertFile == "" || certificate.KeyFile == "" {
+		return common.NewError("Certificate needs a certificate file and a key file")
+	}
+	var domains []string
+	for _, domain := range strings.Split(certificate.Domains, ",") {
+		domain = normalizeCertName(domain)
+		if domain == "" || slices.Contains(domains, domain) {
+			continue
+		}
+		if strings.ContainsAny(domain, " \t/:") || strings.Contains(domain[1:], "*") {
+			return common.NewError("Certificate domain is not valid:", domain)
+		}
+		domains = append(domains, domain)
+	}
+	certificate.Domains = strings.Join(domains, ",")
+
+	cert, err := tls.LoadX509KeyPair(certificate.CertFile, certificate.KeyFile)
+	if err != nil {
+		return common.NewError("Error loading the certificate:", err)
+	}
+	if len(certStoreNames(certificate, &cert)) == 0 {
+		return common.NewError("Certificate has no domain, set its domains")
+	}
+	return nil
+}
+
+// LoadStore loads the enabled certificates of the store for the listeners of the panel. The
+// certificates that do not load are left out.
+func (s *CertificateService) LoadStore() error {
+	var certificates []*model.Certificate
+	err := database.GetDB().Model(model.Certificate{}).Where("enable = ?", true).Order("id").Find(&certificates).Error
+	if err != nil {
+		return err
+	}
+	var entries []certStoreEntry
+	for _, certificate := range certificates {
+		cert, err := loadCertFile(certificate.CertFile, certificate.KeyFile)
+		if err != nil {
+			logger.Warning("Error loading the certificate", certificate.CertFile, "of the store:", err)
+			continue
+		}
+		entries = append(entries, certStoreEntry{names: certStoreNames(certificate, cert.Get()), cert: cert})
+	}
+	certStore.Lock()
+	certStore.entries = entries
+	certStore.Unlock()
+	return nil
+}
+
+// certStoreNames returns the domains of a certificate of the store, or the names it is issued
+// for when none are set.
+func certStoreNames(certificate *model.Certificate, cert *tls.Certificate) []string {
+	if certificate.Domains != "" {
+		return strings.Split(certificate.Domains, ",")
+	}
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil
+		}
+	}
+	names := slices.Clone(leaf.DNSNames)
+	if len(names) == 0 && leaf.Subject.CommonName != "" {
+		names = []string{leaf.Subject.CommonName}
+	}
+	for i := range names {
+		names[i] = normalizeCertName(names[i])
+	}
+	return names
+}
+
+// certStoreGet returns the certificate of the store for a name, one for the name itself before
+// one for its wildcard. It returns nil when the store has none.
+func certStoreGet(name string) *tls.Certificate {
+	name = normalizeCertName(name)
+	if name == "" {
+		return nil
+	}
+	wildcard := ""
+	if _, parent, ok := strings.Cut(name, "."); ok {
+		wildcard = "*." + parent
+	}
+	certStore.RLock()
+	defer certStore.RUnlock()
+	var match *reloadingCert
+	for _, entry := range certStore.entries {
+		if slices.Contains(entry.names, name) {
+			return entry.cert.Get()
+		}
+		if match == nil && wildcard != "" && slices.Contains(entry.names, wildcard) {
+			match = entry.cert
+		}
+	}
+	if match != nil {
+		return match.Get()
+	}
+	return nil
+}
+
+// normalizeCertName lowercases a domain and drops its trailing dot.
+func normalizeCertName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
+
+// certStoreCertificates returns the tlsSettings certificates of the enabled certificates of the store.
+func certStoreCertificates(tx *gorm.DB) ([]any, error) {
+	var certificates []*model.Certificate
+	err := tx.Model(model.Certificate{}).Where("enable = ?", true).Order("id").Find(&certificates).Error
+	if err != nil {
+		return nil, err
+	}
+	result := make([]any, 0, len(certificates))
+	for _, certificate := range certificates {
+		result = append(result, map[string]any{
+			"certificateFile": certificate.CertFile,
+			"keyFile":         certificate.KeyFile,
+			"oneTimeLoading":  false,
+			"usage":           "encipherment",
+			"buildChain":      false,
+		})
+	}
+	return result, nil
+}
+
+// applyCertStore replaces the certificates of TLS stream settings using the certificate store
+// with those of the store. Other stream settings are returned unchanged.
+func applyCertStore(tx *gorm.DB, streamSettings string) (string, bool, error) {
+	var stream map[string]any
+	if streamSettings == "" || json.Unmarshal([]byte(streamSettings), &stream) != nil || stream["security"] != "tls" {
+		return streamSettings, false, nil
+	}
+	tlsSettings, _ := stream["tlsSettings"].(map[string]any)
+	settings, _ := tlsSettings["settings"].(map[string]any)
+	if use, _ := settings["certStore"].(bool); !use {
+		return streamSettings, false, nil
+	}
+	certificates, err := certStoreCertificates(tx)
+	if err != nil {
+		return "", false, err
+	}
+	if len(certificates) == 0 {
+		return "", false, common.NewError("The certificate store has no enabled certificate")
+	}
+	tlsSettings["certificates"] = certificates
+	newStream, err := json.MarshalIndent(stream, "", "  ")
+	if err != nil {
+		return "", false, err
+	}
+	return string(newStream), true, nil
+}
+
+// cascadeCertStore gives the inbounds using the certificate store its enabled certificates
+// after the store changed. It reports whether an enabled inbound changed.
+func cascadeCertStore(tx *gorm.DB) (bool, error) {
+	var inbounds []*model.Inbound
+	err := tx.Model(model.Inbound{}).Find(&inbounds).Error
+	if err != nil {
+		return false, err
+	}
+	needRestart := false
+	for _, inbound := range inbounds {
+		newStream, use, err := applyCertStore(tx, inbound.StreamSettings)
+		if err != nil {
+			return false, common.NewErrorf("Inbound %s uses the certificate store: %v", inbound.Tag, err)
+		}
+		if !use || newStream == inbound.StreamSettings {
+			continue
+		}
+		err = tx.Model(model.Inbound{}).Where("id = ?", inbound.Id).Update("stream_settings", newStream).Error
+		if err != nil {
+			return false, err
+		}
+		needRestart = needRestart || inbound.Enable
+	}
+	return needRestart, nil
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+)
+
+const (
+	clientNoticeExpiry = "expiry"
+	clientNoticeQuota  = "quota"
+)
+
+// NotifyClientThresholds sends a notice for every client that crossed one of the configured
+// expiry or quota thresholds. Each threshold is notified once; the record is cleared when the
+// client is renewed or its traffic is reset, so the notices are sent again in the next period.
+func (t *Tgbot) NotifyClientThresholds() {
+	notifyService := NotifyService{}
+	if !t.IsRunning() && !notifyService.HasDestinations() {
+		return
+	}
+	daysSetting, err := t.settingService.GetExpiryNotifyDays()
+	if err != nil {
+		return
+	}
+	percentSetting, err := t.settingService.GetQuotaNotifyPercent()
+	if err != nil {
+		return
+	}
+	expiryDays, err := common.ParseIntList(daysSetting)
+	if err != nil {
+		logger.Warning("Invalid expiry notify days:", err)
+		return
+	}
+	quotaPercents, err := common.ParseIntList(percentSetting)
+	if err != nil {
+		logger.Warning("Invalid quota notify percentages:", err)
+		return
+	}
+	if len(expiryDays) == 0 && len(quotaPercents) == 0 {
+		return
+	}
+	notifyUser, _ := t.settingService.GetNotifyClientUser()
+	mailClient, _ := t.settingService.GetSmtpClientNotify()
+	mailService := MailService{}
+
+	inbounds, err := t.inboundService.GetAllInbounds()
+	if err != nil {
+		logger.Warning("Unable to load Inbounds", err)
+		return
+	}
+
+	db := database.GetDB()
+	var notices []*model.ClientNotice
+	if err = db.Model(model.ClientNotice{}).Find(&notices).Error; err != nil {
+		logger.Warning("Unable to load client notices", err)
+		return
+	}
+	sent := make(map[string]*model.ClientNotice, len(notices))
+	for _, notice := range notices {
+		sent[notice.Kind+"|"+notice.Email] = notice
+	}
+
+	now := time.Now()
+	for _, inbound := range inbounds {
+		if !inbound.Enable || len(inbound.ClientStats) == 0 {
+			continue
+		}
+		tgIds := make(map[string]int64)
+		if notifyUser {
+			clients, err := t.inboundService.GetClients(inbound)
+			if err == nil {
+				for _, client := range clients {
+					tgIds[client.Email] = client.TgID
+				}
+			}
+		}
+
+		for _, traffic := range inbound.ClientStats {
+			if !traffic.Enable {
+				continue
+			}
+			expiryMsg, quotaMsg := "", ""
+
+			level := 0
+			if traffic.ExpiryTime > 0 {
+				left := time.UnixMilli(traffic.ExpiryTime).Sub(now)
+				daysLeft := int((left + 24*time.Hour - 1) / (24 * time.Hour))
+				for _, days := range expiryDays {
+					if daysLeft <= days && (level == 0 || days < level) {
+						level = days
+					}
+				}
+				if daysLeft > 0 && t.updateClientNotice(sent, traffic.Email, clientNoticeExpiry, level) {
+					expiryMsg = t.I18nBot("tgbot.messages.expiryNotice",
+						"Email=="+traffic.Email,
+						"Days=="+strconv.Itoa(daysLeft),
+						"Time=="+time.UnixMilli(traffic.ExpiryTime).Format("2006-01-02 15:04:05"))
+				}
+			} else {
+				t.updateClientNotice(sent, traffic.Email, clientNoticeExpiry, 0)
+			}
+
+			level = 0
+			if traffic.Total > 0 {
+				used := int((traffic.Up + traffic.Down) * 100 / traffic.Total)
+				for _, percent := range quotaPercents {
+					if used >= percent && percent > level {
+						level = percent
+					}
+				}
+				if used < 100 && t.updateClientNotice(sent, traffic.Email, clientNoticeQuota, level) {
+					quotaMsg = t.I18nBot("tgbot.messages.quotaNotice",
+						"Email=="+traffic.Email,
+						"Percent=="+strconv.Itoa(used),
+						"Used=="+common.FormatTraffic(traffic.Up+traffic.Down),
+						"Total=="+common.FormatTraffic(traffic.Total))
+				}
+			} else {
+				t.updateClientNotice(sent, traffic.Email, clientNoticeQuota, 0)
+			}
+
+			if expiryMsg != "" {
+				notifyService.Notify(NotifyExpiry, expiryMsg)
+			}
+			if quotaMsg != "" {
+				notifyService.Notify(NotifyQuota, quotaMsg)
+			}
+			msgs := expiryMsg + quotaMsg
+			if msgs == "" {
+				continue
+			}
+			if tgId := tgIds[traffic.Email]; tgId != 0 && !checkAdmin(tgId) {
+				t.SendMsgToTgbot(tgId, msgs)
+			}
+			if mailClient && isMailAddress(traffic.Email) {
+				go func(email string, msg string) {
+					subject := t.I18nBot("tgbot.messages.clientNoticeSubject", "Hostname=="+reportHostname())
+					if err := mailService.SendMail([]string{email}, subject, plainNotifyText(msg)); err != nil {
+						logger.Warning("Email the notice of client", email, "failed:", err)
+					}
+				}(traffic.Email, msgs)
+			}
+		}
+	}
+}
+
+// updateClientNotice stores the threshold level a client reached and reports whether a notice
+// is due. A level of 0 means no threshold is crossed and clears the stored record.
+func (t *Tgbot) updateClientNotice(sent map[string]*model.ClientNotice, email string, kind string, level int) bool {
+	db := database.GetDB()
+	notice, ok := sent[kind+"|"+email]
+	if level == 0 {
+		if ok {
+			if err := db.Delete(notice).Error; err != nil {
+				logger.Warning("Unable to clear client notice", err)
+			}
+			delete(sent, kind+"|"+email)
+		}
+		return false
+	}
+
+	if ok {
+		// Only a tighter threshold than the one already notified triggers a new notice
+		if (kind == clientNoticeExpiry && level >= notice.Threshold) ||
+			(kind == clientNoticeQuota && level <= notice.Threshold) {
+			return false
+		}
+	} else {
+		notice = &model.ClientNotice{Email: email, Kind: kind}
+		sent[kind+"|"+email] = notice
+	}
+	notice.Threshold = level
+	notice.CreatedAt = time.Now().UnixMilli()
+	if err := db.Save(notice).Error; err != nil {
+		logger.Warning("Unable to save client notice", err)
+		return false
+	}
+	return true
+}
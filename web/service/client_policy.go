@@ -0,0 +1,141 @@
+package service
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
+)
+
+// Placeholders supported by the client email pattern.
+const (
+	clientPatternSeq  = "{seq}"
+	clientPatternRand = "{rand}"
+	clientLowerAndNum = "0123456789abcdefghijklmnopqrstuvwxyz"
+)
+
+// applyClientPolicy fills the empty email, ID and password of the clients in the inbound settings
+// following the credential policy settings, and gives every client a status token. IDs are left
+// empty in the custom ID mode, and Shadowsocks 2022 clients always get a key of the size required
+// by the method.
+func (s *InboundService) applyClientPolicy(settings string, protocol model.Protocol) (string, error) {
+	var data map[string]any
+	if err := json.Unmarshal([]byte(settings), &data); err != nil {
+		return settings, err
+	}
+	clients, _ := data["clients"].([]any)
+	if len(clients) == 0 {
+		return settings, nil
+	}
+	idMode, err := s.settingService.GetClientIdMode()
+	if err != nil {
+		return settings, err
+	}
+	length, err := s.settingService.GetClientPasswordLength()
+	if err != nil {
+		return settings, err
+	}
+	alphabet, err := s.settingService.GetClientPasswordAlphabet()
+	if err != nil {
+		return settings, err
+	}
+	pattern, err := s.settingService.GetClientEmailPattern()
+	if err != nil {
+		return settings, err
+	}
+	method, _ := data["method"].(string)
+
+	seq := -1
+	changed := false
+	for _, client := range clients {
+		c, ok := client.(map[string]any)
+		if !ok {
+			continue
+		}
+		if email, _ := c["email"].(string); email == "" && pattern != "" {
+			if seq < 0 {
+				if seq, err = s.lastClientSeq(pattern); err != nil {
+					return settings, err
+				}
+			}
+			seq++
+			c["email"] = expandClientPattern(pattern, seq)
+			changed = true
+		}
+		if token, _ := c["statusToken"].(string); token == "" {
+			c["statusToken"] = random.SeqFrom(24, clientLowerAndNum)
+			changed = true
+		}
+		switch protocol {
+		case model.VMESS, model.VLESS, model.TUIC:
+			if id, _ := c["id"].(string); id == "" && idMode != "custom" {
+				c["id"] = uuid.NewString()
+				changed = true
+			}
+		}
+		switch protocol {
+		case model.Trojan, model.Shadowsocks, model.Hysteria2, model.TUIC:
+			if password, _ := c["password"].(string); password == "" {
+				if protocol == model.Shadowsocks && strings.HasPrefix(method, "2022") {
+					regenerateClientCredential(c, protocol, method)
+				} else {
+					c["password"] = random.SeqFrom(length, alphabet)
+				}
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return settings, nil
+	}
+	newSettings, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return settings, err
+	}
+	return string(newSettings), nil
+}
+
+// lastClientSeq returns the highest sequence number used by the existing client emails
+// that match the pattern, or 0 if there is none.
+func (s *InboundService) lastClientSeq(pattern string) (int, error) {
+	if !strings.Contains(pattern, clientPatternSeq) {
+		return 0, nil
+	}
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, regexp.QuoteMeta(clientPatternRand), "[0-9a-z]+")
+	expr = strings.Replace(expr, regexp.QuoteMeta(clientPatternSeq), `(\d+)`, 1)
+	expr = strings.ReplaceAll(expr, regexp.QuoteMeta(clientPatternSeq), `\d+`)
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return 0, err
+	}
+
+	emails, err := s.getAllEmails()
+	if err != nil {
+		return 0, err
+	}
+	last := 0
+	for _, email := range emails {
+		match := re.FindStringSubmatch(email)
+		if match == nil {
+			continue
+		}
+		if n, err := strconv.Atoi(match[1]); err == nil && n > last {
+			last = n
+		}
+	}
+	return last, nil
+}
+
+// expandClientPattern replaces the placeholders of a client email pattern.
+func expandClientPattern(pattern string, seq int) string {
+	email := strings.ReplaceAll(pattern, clientPatternSeq, strconv.Itoa(seq))
+	for strings.Contains(email, clientPatternRand) {
+		email = strings.Replace(email, clientPatternRand, random.SeqFrom(8, clientLowerAndNum), 1)
+	}
+	return email
+}
@@ -0,0 +1,162 @@
+package service
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
+
+	"gorm.io/gorm"
+)
+
+// RotateClientSubId gives the subscription of a client a new random ID. All clients sharing
+// the old ID move to the new one. With graceHours > 0 the old URL keeps working for that
+// long, otherwise it stops working immediately. Returns the new subscription ID.
+func (s *InboundService) RotateClientSubId(email string, graceHours int) (string, error) {
+	if graceHours < 0 {
+		return "", common.NewError("Invalid grace period:", graceHours)
+	}
+	_, client, err := s.GetClientByEmail(email)
+	if err != nil {
+		return "", err
+	}
+	oldSubId := client.SubID
+	newSubId := random.SeqFrom(16, clientLowerAndNum)
+
+	db := database.GetDB()
+	tx := db.Begin()
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	if _, err = s.replaceClientSubId(tx, email, oldSubId, newSubId); err != nil {
+		return "", err
+	}
+	now := time.Now().UnixMilli()
+	if err = tx.Where("expiry_time <= ?", now).Delete(model.SubIdAlias{}).Error; err != nil {
+		return "", err
+	}
+	if oldSubId == "" {
+		return newSubId, nil
+	}
+	if graceHours == 0 {
+		// Earlier IDs still in their grace period are invalidated as well
+		err = tx.Where("new_sub_id = ?", oldSubId).Delete(model.SubIdAlias{}).Error
+		return newSubId, err
+	}
+	err = tx.Model(model.SubIdAlias{}).Where("new_sub_id = ?", oldSubId).Update("new_sub_id", newSubId).Error
+	if err != nil {
+		return "", err
+	}
+	err = tx.Create(&model.SubIdAlias{
+		SubId:      oldSubId,
+		NewSubId:   newSubId,
+		ExpiryTime: now + int64(graceHours)*int64(time.Hour/time.Millisecond),
+	}).Error
+	if err != nil {
+		return "", err
+	}
+	return newSubId, nil
+}
+
+// RevokeClientSubId removes the subscription ID from a client and every client sharing it,
+// so the subscription URL stops working while the clients stay in place. A new ID can be
+// issued later with RotateClientSubId. Returns the number of updated clients.
+func (s *InboundService) RevokeClientSubId(email string) (int, error) {
+	_, client, err := s.GetClientByEmail(email)
+	if err != nil {
+		return 0, err
+	}
+	if client.SubID == "" {
+		return 0, common.NewError("Client has no subscription:", email)
+	}
+
+	db := database.GetDB()
+	tx := db.Begin()
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	count, err := s.replaceClientSubId(tx, email, client.SubID, "")
+	if err != nil {
+		return 0, err
+	}
+	err = tx.Where("new_sub_id = ?", client.SubID).Delete(model.SubIdAlias{}).Error
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// ResolveSubId returns the current subscription ID for an old ID that is still in its
+// grace period, or the given ID otherwise.
+func (s *InboundService) ResolveSubId(subId string) string {
+	db := database.GetDB()
+	var alias model.SubIdAlias
+	err := db.Model(model.SubIdAlias{}).
+		Where("sub_id = ? AND expiry_time > ?", subId, time.Now().UnixMilli()).
+		First(&alias).Error
+	if err != nil {
+		return subId
+	}
+	return alias.NewSubId
+}
+
+// replaceClientSubId sets newSubId on every client with oldSubId, or only on the client with
+// the given email when oldSubId is empty. Returns the number of updated clients.
+func (s *InboundService) replaceClientSubId(tx *gorm.DB, email string, oldSubId string, newSubId string) (int, error) {
+	inbounds, err := s.GetAllInbounds()
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().UnixMilli()
+	count := 0
+	for _, inbound := range inbounds {
+		var settings map[string]any
+		if json.Unmarshal([]byte(inbound.Settings), &settings) != nil {
+			continue
+		}
+		clients, _ := settings["clients"].([]any)
+		changed := false
+		for _, client := range clients {
+			c, ok := client.(map[string]any)
+			if !ok {
+				continue
+			}
+			subId, _ := c["subId"].(string)
+			clientEmail, _ := c["email"].(string)
+			if (oldSubId != "" && subId == oldSubId) || (oldSubId == "" && clientEmail == email) {
+				c["subId"] = newSubId
+				c["updated_at"] = now
+				changed = true
+				count++
+			}
+		}
+		if !changed {
+			continue
+		}
+		newSettings, err := json.MarshalIndent(settings, "", "  ")
+		if err != nil {
+			return 0, err
+		}
+		err = tx.Model(model.Inbound{}).Where("id = ?", inbound.Id).Update("settings", string(newSettings)).Error
+		if err != nil {
+			return 0, err
+		}
+	}
+	if count == 0 {
+		return 0, common.NewError("Client Not Found For Email:", email)
+	}
+	return count, nil
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"gorm.io/gorm"
+)
+
+const clientDailyTrafficLayout = "2006-01-02"
+
+// addClientDailyTraffic adds the traffic of the current collection cycle to today's bucket
+// of every known client. dbTraffics limits the update to clients that exist in the database.
+func (s *InboundService) addClientDailyTraffic(tx *gorm.DB, dbTraffics []*xray.ClientTraffic, traffics []*xray.ClientTraffic) error {
+	known := make(map[string]bool, len(dbTraffics))
+	for _, traffic := range dbTraffics {
+		known[traffic.Email] = true
+	}
+
+	loc, err := s.settingService.GetTimeLocation()
+	if err != nil {
+		loc = time.Local
+	}
+	day := time.Now().In(loc).Format(clientDailyTrafficLayout)
+
+	for _, traffic := range traffics {
+		if !known[traffic.Email] || traffic.Up+traffic.Down == 0 {
+			continue
+		}
+		result := tx.Model(model.ClientTrafficDaily{}).
+			Where("email = ? AND day = ?", traffic.Email, day).
+			Updates(map[string]any{
+				"up":   gorm.Expr("up + ?", traffic.Up),
+				"down": gorm.Expr("down + ?", traffic.Down),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			continue
+		}
+		err = tx.Create(&model.ClientTrafficDaily{
+			Email: traffic.Email,
+			Day:   day,
+			Up:    traffic.Up,
+			Down:  traffic.Down,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetClientDailyTraffics returns the daily traffic buckets of a client between from and to,
+// both inclusive and formatted as 2006-01-02. Empty bounds leave the range open.
+func (s *InboundService) GetClientDailyTraffics(email string, from string, to string) ([]*model.ClientTrafficDaily, error) {
+	for _, day := range []string{from, to} {
+		if day == "" {
+			continue
+		}
+		if _, err := time.Parse(clientDailyTrafficLayout, day); err != nil {
+			return nil, common.NewError("Invalid day:", day)
+		}
+	}
+
+	db := database.GetDB()
+	query := db.Model(model.ClientTrafficDaily{}).Where("email = ?", email)
+	if from != "" {
+		query = query.Where("day >= ?", from)
+	}
+	if to != "" {
+		query = query.Where("day <= ?", to)
+	}
+	var traffics []*model.ClientTrafficDaily
+	err := query.Order("day").Find(&traffics).Error
+	if err != nil {
+		return nil, err
+	}
+	return traffics, nil
+}
@@ -0,0 +1,31 @@
+package service
+
+// Proxy cores the panel can run, selected with the coreType setting.
+const (
+	CoreXray    = "xray"
+	CoreSingbox = "sing-box"
+)
+
+// coreProcess is the process of the proxy core run by the panel, either
+// *xray.Process or *singbox.Process. Features specific to one core type-assert it.
+type coreProcess interface {
+	IsRunning() bool
+	GetErr() error
+	GetResult() string
+	GetLastLines() []string
+	GetVersion() string
+	GetAPIPort() int
+	GetUptime() uint64
+	GetOnlineClients() []string
+	SetOnlineClients(users []string)
+	Stop() error
+}
+
+// getAPIPort returns the API port of the running core, 0 without one, e.g. when the services
+// are used from the command line, so that API calls fail and the change waits for a restart.
+func getAPIPort() int {
+	if p == nil {
+		return 0
+	}
+	return p.GetAPIPort()
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+// crashEventsKept is the number of crash events kept in the database.
+const crashEventsKept = 100
+
+// RecordCrash stores the exit error and last output lines of the stopped core as a crash event.
+func (s *XrayService) RecordCrash(attempt int) (*model.CrashEvent, error) {
+	event := &model.CrashEvent{
+		Time:    time.Now().UnixMilli(),
+		Attempt: attempt,
+	}
+	coreCrashes.Inc()
+	event.CoreType, _ = s.settingService.GetCoreType()
+	if p != nil {
+		event.Version = p.GetVersion()
+		if err := p.GetErr(); err != nil {
+			event.Error = err.Error()
+		}
+		event.Output = strings.Join(p.GetLastLines(), "\n")
+	}
+
+	db := database.GetDB()
+	if err := db.Create(event).Error; err != nil {
+		return event, err
+	}
+	// Keep the table bounded, a core that keeps crashing would otherwise fill it
+	err := db.Where("id <= ?", event.Id-crashEventsKept).Delete(model.CrashEvent{}).Error
+	return event, err
+}
+
+// GetCrashEvents returns the most recent crash events, newest first.
+func (s *XrayService) GetCrashEvents(limit int) ([]*model.CrashEvent, error) {
+	var events []*model.CrashEvent
+	err := database.GetDB().Model(model.CrashEvent{}).Order("id desc").Limit(limit).Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
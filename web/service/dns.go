@@ -0,0 +1,257 @@
+package service
+
+import (
+	"encoding/json"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"github.com/xtls/xray-core/infra/conf"
+	"gorm.io/gorm"
+)
+
+// DnsService provides business logic for the panel-managed Xray DNS block.
+// While managed DNS is enabled in the settings, the enabled servers replace
+// the DNS block of the template.
+type DnsService struct {
+	settingService SettingService
+}
+
+// GetServers returns all DNS servers in the order they are queried.
+func (s *DnsService) GetServers() ([]*model.DnsServer, error) {
+	db := database.GetDB()
+	var servers []*model.DnsServer
+	err := db.Model(model.DnsServer{}).Order("priority").Order("id").Find(&servers).Error
+	if err != nil {
+		return nil, err
+	}
+	return servers, nil
+}
+
+// GetServer returns the DNS server with the given ID.
+func (s *DnsService) GetServer(id int) (*model.DnsServer, error) {
+	db := database.GetDB()
+	server := &model.DnsServer{}
+	err := db.Model(model.DnsServer{}).First(server, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// AddServer validates and creates a DNS server. A server without priority is queried after the others.
+func (s *DnsService) AddServer(server *model.DnsServer) error {
+	if err := normalizeDnsServer(server); err != nil {
+		return err
+	}
+	db := database.GetDB()
+	if server.Priority == 0 {
+		var maxPriority int
+		err := db.Model(model.DnsServer{}).Select("COALESCE(MAX(priority), 0)").Scan(&maxPriority).Error
+		if err != nil {
+			return err
+		}
+		server.Priority = maxPriority + 1
+	}
+	server.Id = 0
+	return db.Create(server).Error
+}
+
+// UpdateServer validates and saves a DNS server.
+func (s *DnsService) UpdateServer(server *model.DnsServer) error {
+	if _, err := s.GetServer(server.Id); err != nil {
+		return err
+	}
+	if err := normalizeDnsServer(server); err != nil {
+		return err
+	}
+	return database.GetDB().Save(server).Error
+}
+
+// DelServer deletes a DNS server.
+func (s *DnsService) DelServer(id int) error {
+	return database.GetDB().Delete(model.DnsServer{}, id).Error
+}
+
+// ReorderServers sets the priorities of the servers to the order of ids. Every server has to be listed once.
+func (s *DnsService) ReorderServers(ids []int) error {
+	servers, err := s.GetServers()
+	if err != nil {
+		return err
+	}
+	if len(ids) != len(servers) {
+		return common.NewError("Server order must list every DNS server")
+	}
+	known := make(map[int]bool, len(servers))
+	for _, server := range servers {
+		known[server.Id] = true
+	}
+
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		for i, id := range ids {
+			if !known[id] {
+				return common.NewError("Server order lists an unknown or duplicate DNS server:", id)
+			}
+			delete(known, id)
+			if err := tx.Model(model.DnsServer{}).Where("id = ?", id).Update("priority", i+1).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// normalizeDnsServer trims the list fields of a DNS server and validates it.
+func normalizeDnsServer(server *model.DnsServer) error {
+	server.Address = strings.TrimSpace(server.Address)
+	server.Domains = strings.Join(splitRuleList(server.Domains), ",")
+	server.ExpectIPs = strings.Join(splitRuleList(server.ExpectIPs), ",")
+
+	if !validDnsAddress(server.Address) {
+		return common.NewError("DNS server address is not valid:", server.Address)
+	}
+	if server.Port < 0 || server.Port > 65535 {
+		return common.NewError("DNS server port is not valid:", server.Port)
+	}
+	if server.Port != 0 && net.ParseIP(server.Address) == nil {
+		return common.NewError("DNS server port can only be set for a plain IP address")
+	}
+	switch server.QueryStrategy {
+	case "", "UseIP", "UseIPv4", "UseIPv6":
+	default:
+		return common.NewError("DNS query strategy is not valid:", server.QueryStrategy)
+	}
+	for _, ip := range splitRuleList(server.ExpectIPs) {
+		if !validRuleIP(ip) {
+			return common.NewError("DNS expected IP is not valid:", ip)
+		}
+	}
+	for _, domain := range splitRuleList(server.Domains) {
+		if strings.ContainsAny(domain, " \t\"") {
+			return common.NewError("DNS domain is not valid:", domain)
+		}
+	}
+	return nil
+}
+
+// validDnsAddress reports whether address is a name server Xray understands.
+func validDnsAddress(address string) bool {
+	switch address {
+	case "":
+		return false
+	case "localhost", "fakedns":
+		return true
+	}
+	if net.ParseIP(address) != nil {
+		return true
+	}
+	u, err := url.Parse(address)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https", "https+local", "h2c", "quic", "quic+local", "tcp", "tcp+local":
+		return true
+	}
+	return false
+}
+
+// applyDns replaces the DNS block of the config with the managed servers if managed DNS is enabled.
+func (s *DnsService) applyDns(xrayConfig *xray.Config) error {
+	managed, err := s.settingService.GetDnsManaged()
+	if err != nil || !managed {
+		return err
+	}
+	queryStrategy, err := s.settingService.GetDnsQueryStrategy()
+	if err != nil {
+		return err
+	}
+	fakeDns, err := s.settingService.GetDnsFakeDns()
+	if err != nil {
+		return err
+	}
+	disableCache, err := s.settingService.GetDnsDisableCache()
+	if err != nil {
+		return err
+	}
+	servers, err := s.GetServers()
+	if err != nil {
+		return err
+	}
+
+	var xrayServers []any
+	if fakeDns {
+		xrayServers = append(xrayServers, "fakedns")
+	}
+	for _, server := range servers {
+		if server.Enable {
+			xrayServers = append(xrayServers, dnsServerToXray(server))
+		}
+	}
+	if len(xrayServers) == 0 {
+		xrayServers = append(xrayServers, "localhost")
+	}
+
+	dnsConfig, err := json.MarshalIndent(map[string]any{
+		"servers":       xrayServers,
+		"queryStrategy": queryStrategy,
+		"disableCache":  disableCache,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := checkDnsConfig(dnsConfig); err != nil {
+		return err
+	}
+	xrayConfig.DNSConfig = dnsConfig
+
+	if fakeDns && len(xrayConfig.FakeDNS) == 0 {
+		xrayConfig.FakeDNS, err = json.MarshalIndent([]any{
+			map[string]any{"ipPool": "198.18.0.0/15", "poolSize": 65535},
+			map[string]any{"ipPool": "fc00::/18", "poolSize": 65535},
+		}, "", "  ")
+	}
+	return err
+}
+
+// dnsServerToXray converts a DNS server into an Xray name server, using the short
+// address form when the server has no other options.
+func dnsServerToXray(server *model.DnsServer) any {
+	if server.Port == 0 && server.Domains == "" && server.ExpectIPs == "" && !server.SkipFallback && server.QueryStrategy == "" {
+		return server.Address
+	}
+	xrayServer := map[string]any{"address": server.Address}
+	if server.Port != 0 {
+		xrayServer["port"] = server.Port
+	}
+	if domains := splitRuleList(server.Domains); len(domains) > 0 {
+		xrayServer["domains"] = domains
+	}
+	if expectIPs := splitRuleList(server.ExpectIPs); len(expectIPs) > 0 {
+		xrayServer["expectIPs"] = expectIPs
+	}
+	if server.SkipFallback {
+		xrayServer["skipFallback"] = true
+	}
+	if server.QueryStrategy != "" {
+		xrayServer["queryStrategy"] = server.QueryStrategy
+	}
+	return xrayServer
+}
+
+// checkDnsConfig parses a DNS block the way Xray does, so a malformed block is reported
+// before Xray fails to start on it.
+func checkDnsConfig(dnsConfig []byte) error {
+	if len(dnsConfig) == 0 || string(dnsConfig) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(dnsConfig, &conf.DNSConfig{}); err != nil {
+		return common.NewError("DNS config invalid:", err)
+	}
+	return nil
+}
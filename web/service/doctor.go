@@ -0,0 +1,389 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/singbox"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// Outcomes of a diagnostic check.
+const (
+	DiagnosticOk   = "ok"
+	DiagnosticWarn = "warn"
+	DiagnosticFail = "fail"
+	DiagnosticSkip = "skip"
+)
+
+const (
+	// diagnosticCertWarnDays is how long before its expiry a certificate is reported.
+	diagnosticCertWarnDays = 14
+	// diagnosticGeofileMaxAge is the age after which geo databases are reported as stale.
+	diagnosticGeofileMaxAge = 30 * 24 * time.Hour
+	// diagnosticMaxClockSkew is the clock difference reported, VMess rejects clients past 90 seconds.
+	diagnosticMaxClockSkew = 30 * time.Second
+	// diagnosticTimeout bounds each network check.
+	diagnosticTimeout = 5 * time.Second
+)
+
+// DiagnosticCheck is the outcome of one check of the doctor command.
+type DiagnosticCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"` // What to do about a warning or failure
+}
+
+// Diagnostics is the report of the doctor command: the environment, which maintainers ask for in
+// bug reports, and the outcome of every check.
+type Diagnostics struct {
+	Version  string             `json:"version"`
+	OS       string             `json:"os"`
+	Arch     string             `json:"arch"`
+	CoreType string             `json:"coreType"`
+	Checks   []*DiagnosticCheck `json:"checks"`
+}
+
+// DoctorService runs diagnostics of the panel and its environment.
+type DoctorService struct {
+	settingService SettingService
+	inboundService InboundService
+	serverService  ServerService
+	tgbot          Tgbot
+}
+
+// RunDiagnostics runs every check. It works whether the panel runs or not.
+func (s *DoctorService) RunDiagnostics() *Diagnostics {
+	report := &Diagnostics{
+		Version: config.GetVersion(),
+		OS:      runtime.GOOS,
+		Arch:    runtime.GOARCH,
+	}
+	report.CoreType, _ = s.settingService.GetCoreType()
+	if report.CoreType == "" {
+		report.CoreType = CoreXray
+	}
+	report.Checks = append(report.Checks, s.checkCore(report.CoreType))
+	report.Checks = append(report.Checks, s.checkDatabase())
+	report.Checks = append(report.Checks, s.checkPorts()...)
+	report.Checks = append(report.Checks, s.checkCertificates()...)
+	report.Checks = append(report.Checks, s.checkClock())
+	report.Checks = append(report.Checks, s.checkGeofiles())
+	report.Checks = append(report.Checks, s.checkConnectivity()...)
+	return report
+}
+
+// checkCore checks that the binary of the configured core is present and runs.
+func (s *DoctorService) checkCore(coreType string) *DiagnosticCheck {
+	check := &DiagnosticCheck{Name: "core"}
+	path := xray.GetBinaryPath()
+	if coreType == CoreSingbox {
+		path = singbox.GetBinaryPath()
+	}
+	if _, err := os.Stat(path); err != nil {
+		check.Status = DiagnosticFail
+		check.Message = fmt.Sprintf("%s binary not found at %s", coreType, path)
+		check.Hint = "Reinstall the panel or install the core from the panel settings"
+		return check
+	}
+	if coreType == CoreXray {
+		if err := xray.VerifyBinary(); err != nil {
+			check.Status = DiagnosticFail
+			check.Message = err.Error()
+			check.Hint = "Replace the binary or update XUI_XRAY_BINARY_SHA256"
+			return check
+		}
+	}
+	var version string
+	var err error
+	if coreType == CoreSingbox {
+		version, err = singbox.GetBinaryVersion()
+	} else {
+		version, err = xray.GetBinaryVersion(path)
+	}
+	if err != nil {
+		check.Status = DiagnosticFail
+		check.Message = fmt.Sprintf("%s binary at %s does not run: %v", coreType, path, err)
+		check.Hint = "Check that the binary is executable and built for " + runtime.GOOS + "/" + runtime.GOARCH
+		return check
+	}
+	check.Status = DiagnosticOk
+	check.Message = fmt.Sprintf("%s %s at %s", coreType, version, path)
+	return check
+}
+
+// checkDatabase checks the integrity of the SQLite database, or that MySQL answers.
+func (s *DoctorService) checkDatabase() *DiagnosticCheck {
+	check := &DiagnosticCheck{Name: "database"}
+	dbConfig, err := config.GetDatabaseConfig()
+	if err == nil && dbConfig.Connection == "mysql" {
+		sqlDB, err := database.GetDB().DB()
+		if err == nil {
+			err = sqlDB.Ping()
+		}
+		if err != nil {
+			check.Status = DiagnosticFail
+			check.Message = "MySQL does not answer: " + err.Error()
+			check.Hint = "Check the XUI_DB_* variables and that MySQL is running"
+			return check
+		}
+		check.Status = DiagnosticOk
+		check.Message = "MySQL database " + dbConfig.Database + " on " + dbConfig.Host
+		return check
+	}
+	if err := database.ValidateSQLiteDB(config.GetDBPath()); err != nil {
+		check.Status = DiagnosticFail
+		check.Message = "integrity check failed: " + err.Error()
+		check.Hint = "Restore a backup with x-ui restore"
+		return check
+	}
+	check.Status = DiagnosticOk
+	check.Message = "integrity check passed for " + config.GetDBPath()
+	return check
+}
+
+// checkPorts checks that the ports of the panel and of the subscription server are either served
+// by the panel or free, and that no inbound takes one of them.
+func (s *DoctorService) checkPorts() []*DiagnosticCheck {
+	var checks []*DiagnosticCheck
+	panelPort, _ := s.settingService.GetPort()
+	panelListen, _ := s.settingService.GetListen()
+	basePath, _ := s.settingService.GetBasePath()
+	checks = append(checks, diagnosePort("panel port", panelListen, panelPort, basePath))
+
+	subPort := 0
+	if enable, _ := s.settingService.GetSubEnable(); enable {
+		subPort, _ = s.settingService.GetSubPort()
+		subListen, _ := s.settingService.GetSubListen()
+		subPath, _ := s.settingService.GetSubPath()
+		if subPort == panelPort {
+			checks = append(checks, &DiagnosticCheck{Name: "subscription port", Status: DiagnosticFail,
+				Message: fmt.Sprintf("port %d is used by both the panel and the subscription server", subPort),
+				Hint:    "Set another subscription port in the panel settings"})
+		} else {
+			checks = append(checks, diagnosePort("subscription port", subListen, subPort, subPath))
+		}
+	}
+
+	inbounds, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		return checks
+	}
+	check := &DiagnosticCheck{Name: "inbound ports", Status: DiagnosticOk, Message: "no inbound uses the port of the panel or of the subscription server"}
+	for _, inbound := range inbounds {
+		if inbound.Enable && (inbound.Port == panelPort || (subPort > 0 && inbound.Port == subPort)) {
+			check.Status = DiagnosticFail
+			check.Message = fmt.Sprintf("inbound %q uses port %d, taken by the panel", inbound.Remark, inbound.Port)
+			check.Hint = "Change the port of the inbound or of the panel"
+			break
+		}
+	}
+	return append(checks, check)
+}
+
+// diagnosePort checks a port the panel listens on. A port in use is fine as long as it answers
+// HTTP on the path of the panel, which means the panel is the one listening.
+func diagnosePort(name string, listen string, port int, path string) *DiagnosticCheck {
+	check := &DiagnosticCheck{Name: name}
+	if port <= 0 || port > 65535 {
+		check.Status = DiagnosticFail
+		check.Message = fmt.Sprintf("invalid port %d", port)
+		check.Hint = "Set a port with x-ui setting -port"
+		return check
+	}
+	addr := net.JoinHostPort(listen, strconv.Itoa(port))
+	if listener, err := net.Listen("tcp", addr); err == nil {
+		listener.Close()
+		check.Status = DiagnosticWarn
+		check.Message = fmt.Sprintf("port %d is free, the panel is not running", port)
+		check.Hint = "Start the panel with x-ui start"
+		return check
+	}
+	host := listen
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	client := &http.Client{
+		Timeout:   diagnosticTimeout,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	for _, scheme := range []string{"https", "http"} {
+		resp, err := client.Get(scheme + "://" + net.JoinHostPort(host, strconv.Itoa(port)) + path)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode < http.StatusInternalServerError {
+			check.Status = DiagnosticOk
+			check.Message = fmt.Sprintf("port %d is served by the panel", port)
+			return check
+		}
+	}
+	check.Status = DiagnosticFail
+	check.Message = fmt.Sprintf("port %d is taken by another program", port)
+	check.Hint = fmt.Sprintf("Find it with ss -ltnp 'sport = :%d' and stop it, or change the port", port)
+	return check
+}
+
+// checkCertificates checks that the certificates of the panel and of the subscription server load
+// and are not about to expire.
+func (s *DoctorService) checkCertificates() []*DiagnosticCheck {
+	certFile, _ := s.settingService.GetCertFile()
+	keyFile, _ := s.settingService.GetKeyFile()
+	checks := []*DiagnosticCheck{diagnoseCertificate("panel certificate", certFile, keyFile)}
+	if enable, _ := s.settingService.GetSubEnable(); enable {
+		subCertFile, _ := s.settingService.GetSubCertFile()
+		subKeyFile, _ := s.settingService.GetSubKeyFile()
+		checks = append(checks, diagnoseCertificate("subscription certificate", subCertFile, subKeyFile))
+	}
+	return checks
+}
+
+// diagnoseCertificate checks a certificate and its key.
+func diagnoseCertificate(name string, certFile string, keyFile string) *DiagnosticCheck {
+	check := &DiagnosticCheck{Name: name}
+	if certFile == "" || keyFile == "" {
+		check.Status = DiagnosticWarn
+		check.Message = "no certificate, served over plain HTTP"
+		check.Hint = "Issue one from the SSL certificate menu of x-ui"
+		return check
+	}
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		check.Status = DiagnosticFail
+		check.Message = "certificate does not load: " + err.Error()
+		check.Hint = "Renew the certificate or fix its paths with x-ui cert"
+		return check
+	}
+	cert := pair.Leaf
+	left := time.Until(cert.NotAfter)
+	switch {
+	case left <= 0:
+		check.Status = DiagnosticFail
+		check.Message = fmt.Sprintf("certificate for %v expired on %s", cert.DNSNames, cert.NotAfter.Format("2006-01-02"))
+		check.Hint = "Renew the certificate from the SSL certificate menu of x-ui"
+	case left < diagnosticCertWarnDays*24*time.Hour:
+		check.Status = DiagnosticWarn
+		check.Message = fmt.Sprintf("certificate for %v expires on %s", cert.DNSNames, cert.NotAfter.Format("2006-01-02"))
+		check.Hint = "Check that the automatic renewal works"
+	default:
+		check.Status = DiagnosticOk
+		check.Message = fmt.Sprintf("certificate for %v valid until %s", cert.DNSNames, cert.NotAfter.Format("2006-01-02"))
+	}
+	return check
+}
+
+// checkClock compares the system clock with the Date header of a well-known server. VMess and
+// two-factor codes fail on a clock that is off.
+func (s *DoctorService) checkClock() *DiagnosticCheck {
+	check := &DiagnosticCheck{Name: "time sync"}
+	client := &http.Client{Timeout: diagnosticTimeout}
+	start := time.Now()
+	resp, err := client.Head("https://www.cloudflare.com")
+	if err != nil {
+		check.Status = DiagnosticSkip
+		check.Message = "could not reach a time reference: " + err.Error()
+		return check
+	}
+	resp.Body.Close()
+	remote, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		check.Status = DiagnosticSkip
+		check.Message = "time reference sent no date"
+		return check
+	}
+	local := start.Add(time.Since(start) / 2)
+	skew := local.Sub(remote).Round(time.Second)
+	if skew.Abs() > diagnosticMaxClockSkew {
+		check.Status = DiagnosticWarn
+		check.Message = fmt.Sprintf("system clock is off by %v", skew)
+		check.Hint = "Enable time synchronization, e.g. timedatectl set-ntp true"
+		return check
+	}
+	check.Status = DiagnosticOk
+	check.Message = fmt.Sprintf("system clock is off by %v", skew)
+	return check
+}
+
+// checkGeofiles checks that the geo databases are present and were updated recently.
+func (s *DoctorService) checkGeofiles() *DiagnosticCheck {
+	check := &DiagnosticCheck{Name: "geo data", Status: DiagnosticOk}
+	var missing, stale []string
+	for _, version := range s.serverService.GetGeofileVersions() {
+		if _, err := os.Stat(filepath.Join(config.GetBinFolderPath(), version.FileName)); err != nil {
+			missing = append(missing, version.FileName)
+		} else if time.Since(time.UnixMilli(version.UpdatedAt)) > diagnosticGeofileMaxAge {
+			stale = append(stale, version.FileName)
+		}
+	}
+	switch {
+	case len(missing) > 0:
+		check.Status = DiagnosticFail
+		check.Message = fmt.Sprintf("missing %v", missing)
+		check.Hint = "Update the geo files from the panel, or from the Geo Files menu of x-ui"
+	case len(stale) > 0:
+		check.Status = DiagnosticWarn
+		check.Message = fmt.Sprintf("not updated for %d days: %v", int(diagnosticGeofileMaxAge.Hours()/24), stale)
+		check.Hint = "Update the geo files from the panel, or from the Geo Files menu of x-ui"
+	default:
+		check.Message = "all geo files present and up to date"
+	}
+	return check
+}
+
+// checkConnectivity checks that the Telegram bot reaches its API, when enabled, and that GitHub,
+// where updates and geo files come from, can be reached.
+func (s *DoctorService) checkConnectivity() []*DiagnosticCheck {
+	var checks []*DiagnosticCheck
+	check := &DiagnosticCheck{Name: "telegram"}
+	token, _ := s.settingService.GetTgBotToken()
+	if enabled, _ := s.settingService.GetTgbotEnabled(); !enabled || token == "" {
+		check.Status = DiagnosticSkip
+		check.Message = "Telegram bot disabled"
+	} else {
+		proxy, _ := s.settingService.GetTgBotProxy()
+		apiServer, _ := s.settingService.GetTgBotAPIServer()
+		bot, err := s.tgbot.NewBot(token, proxy, apiServer)
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), diagnosticTimeout)
+			_, err = bot.GetMe(ctx)
+			cancel()
+		}
+		if err != nil {
+			check.Status = DiagnosticFail
+			check.Message = "Telegram API not reachable: " + err.Error()
+			check.Hint = "Check the bot token, or set a proxy or API server in the Telegram settings"
+		} else {
+			check.Status = DiagnosticOk
+			check.Message = "Telegram API reachable"
+		}
+	}
+	checks = append(checks, check)
+
+	check = &DiagnosticCheck{Name: "github"}
+	client := &http.Client{Timeout: diagnosticTimeout}
+	if resp, err := client.Head("https://api.github.com"); err != nil {
+		check.Status = DiagnosticWarn
+		check.Message = "GitHub not reachable: " + err.Error()
+		check.Hint = "Updates and geo files download from GitHub, set geo file mirrors if it is blocked"
+	} else {
+		resp.Body.Close()
+		check.Status = DiagnosticOk
+		check.Message = "GitHub reachable"
+	}
+	return append(checks, check)
+}
@@ -0,0 +1,20 @@
+package service
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/extension"
+)
+
+// ExtensionService runs the extension hooks, with the external ones configured in the settings.
+type ExtensionService struct {
+	settingService SettingService
+}
+
+// RunHooks runs the hooks of an event on its payload, which they may change.
+func (s *ExtensionService) RunHooks(event string, payload any) {
+	external, err := s.settingService.GetExtensionHooks()
+	if err != nil {
+		logger.Warning("Invalid extension hooks:", err)
+	}
+	extension.Run(event, payload, external)
+}
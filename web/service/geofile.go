@@ -0,0 +1,291 @@
+package service
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+)
+
+// geofileSource is a geo database and the URL it is downloaded from.
+type geofileSource struct {
+	URL      string
+	FileName string
+}
+
+// defaultGeofiles are the geo databases the panel keeps in the bin folder.
+var defaultGeofiles = []geofileSource{
+	{"https://github.com/Loyalsoldier/v2ray-rules-dat/releases/latest/download/geoip.dat", "geoip.dat"},
+	{"https://github.com/Loyalsoldier/v2ray-rules-dat/releases/latest/download/geosite.dat", "geosite.dat"},
+	{"https://github.com/chocolate4u/Iran-v2ray-rules/releases/latest/download/geoip.dat", "geoip_IR.dat"},
+	{"https://github.com/chocolate4u/Iran-v2ray-rules/releases/latest/download/geosite.dat", "geosite_IR.dat"},
+	{"https://github.com/runetfreedom/russia-v2ray-rules-dat/releases/latest/download/geoip.dat", "geoip_RU.dat"},
+	{"https://github.com/runetfreedom/russia-v2ray-rules-dat/releases/latest/download/geosite.dat", "geosite_RU.dat"},
+	// Not used by Xray, the connection analytics locate the networks of destinations with it
+	{"https://github.com/P3TERX/GeoLite.mmdb/raw/download/GeoLite2-ASN.mmdb", "GeoLite2-ASN.mmdb"},
+}
+
+// GeofileVersion describes the installed copy of a geo database.
+type GeofileVersion struct {
+	FileName  string `json:"fileName"`
+	Version   string `json:"version"`   // Release tag the file was downloaded from, if the mirror reports one
+	Sha256    string `json:"sha256"`    // Checksum of the installed file
+	UpdatedAt int64  `json:"updatedAt"` // Unix milliseconds of the last change
+}
+
+// geofileLock serializes geofile downloads of the panel and the update job.
+var geofileLock sync.Mutex
+
+// geofileVersionsPath returns the file keeping the versions of the downloaded geo databases.
+func geofileVersionsPath() string {
+	return filepath.Join(config.GetBinFolderPath(), "geofiles.json")
+}
+
+// getGeofileSources returns the default geo databases with the mirror URLs from the settings applied.
+// Mirrors for other file names add them to the list.
+func (s *ServerService) getGeofileSources() []geofileSource {
+	sources := append([]geofileSource(nil), defaultGeofiles...)
+	mirrors, _ := s.settingService.GetGeofileMirrors()
+	for line := range strings.SplitSeq(mirrors, "\n") {
+		name, url, ok := strings.Cut(strings.TrimSpace(line), "=")
+		name, url = strings.TrimSpace(name), strings.TrimSpace(url)
+		if !ok || !s.IsValidGeofileName(name) || url == "" {
+			continue
+		}
+		found := false
+		for i := range sources {
+			if sources[i].FileName == name {
+				sources[i].URL = url
+				found = true
+			}
+		}
+		if !found {
+			sources = append(sources, geofileSource{URL: url, FileName: name})
+		}
+	}
+	return sources
+}
+
+// loadGeofileVersions reads the recorded geofile versions keyed by file name.
+func loadGeofileVersions() map[string]*GeofileVersion {
+	versions := map[string]*GeofileVersion{}
+	data, err := os.ReadFile(geofileVersionsPath())
+	if err != nil {
+		return versions
+	}
+	if err := json.Unmarshal(data, &versions); err != nil {
+		logger.Warning("Failed to parse geofile versions:", err)
+	}
+	return versions
+}
+
+// saveGeofileVersions writes the recorded geofile versions.
+func saveGeofileVersions(versions map[string]*GeofileVersion) error {
+	data, err := json.MarshalIndent(versions, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(geofileVersionsPath(), data, 0644)
+}
+
+// GetGeofileVersions reports the installed version of every geo database. Files that were not
+// downloaded by the panel are reported with their modification time only.
+func (s *ServerService) GetGeofileVersions() []*GeofileVersion {
+	geofileLock.Lock()
+	defer geofileLock.Unlock()
+
+	recorded := loadGeofileVersions()
+	var versions []*GeofileVersion
+	for _, source := range s.getGeofileSources() {
+		version := recorded[source.FileName]
+		if version == nil {
+			version = &GeofileVersion{FileName: source.FileName}
+			if stat, err := os.Stat(filepath.Join(config.GetBinFolderPath(), source.FileName)); err == nil {
+				version.UpdatedAt = stat.ModTime().UnixMilli()
+			}
+		}
+		version.FileName = source.FileName
+		versions = append(versions, version)
+	}
+	return versions
+}
+
+// fetchGeofileSHA256 downloads the checksum published next to a geofile as url.sha256sum.
+// It returns "" if the mirror does not publish one.
+func fetchGeofileSHA256(url string) (string, error) {
+	resp, err := http.Get(url + ".sha256sum")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", common.NewErrorf("Failed to download checksum: %s", resp.Status)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	if scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 0 && len(fields[0]) == sha256.Size*2 {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", common.NewError("Checksum file is not valid")
+}
+
+// geofileReleaseTag returns the release tag from a GitHub release download URL,
+// which "latest/download" URLs redirect to.
+func geofileReleaseTag(downloadPath string) string {
+	dir, _ := path.Split(downloadPath)
+	dir = strings.TrimSuffix(dir, "/")
+	tag := path.Base(dir)
+	if path.Base(path.Dir(dir)) != "download" || tag == "latest" {
+		return ""
+	}
+	return tag
+}
+
+// downloadGeofile downloads a geo database, verifies it and replaces the installed copy.
+// It reports whether the file changed; an unchanged file is left in place.
+func downloadGeofile(source geofileSource, versions map[string]*GeofileVersion) (bool, error) {
+	checksum, err := fetchGeofileSHA256(source.URL)
+	if err != nil {
+		return false, common.NewErrorf("Failed to download checksum of Geofile %s: %v", source.FileName, err)
+	}
+	if checksum == "" {
+		logger.Warningf("No checksum published for Geofile %s, skipping verification", source.FileName)
+	}
+
+	resp, err := http.Get(source.URL)
+	if err != nil {
+		return false, common.NewErrorf("Failed to download Geofile from %s: %v", source.URL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, common.NewErrorf("Failed to download Geofile from %s: %s", source.URL, resp.Status)
+	}
+
+	destPath := filepath.Join(config.GetBinFolderPath(), filepath.Base(source.FileName))
+	file, err := os.CreateTemp(config.GetBinFolderPath(), source.FileName+".*.tmp")
+	if err != nil {
+		return false, common.NewErrorf("Failed to create Geofile %s: %v", destPath, err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hash), resp.Body); err != nil {
+		return false, common.NewErrorf("Failed to save Geofile %s: %v", destPath, err)
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if checksum != "" && sum != checksum {
+		return false, common.NewErrorf("Checksum mismatch for Geofile %s: expected %s, got %s", source.FileName, checksum, sum)
+	}
+
+	if existing, err := os.Open(destPath); err == nil {
+		existingHash := sha256.New()
+		_, err = io.Copy(existingHash, existing)
+		existing.Close()
+		if err == nil && hex.EncodeToString(existingHash.Sum(nil)) == sum {
+			if versions[source.FileName] == nil {
+				versions[source.FileName] = &GeofileVersion{Sha256: sum, UpdatedAt: time.Now().UnixMilli()}
+			}
+			return false, nil
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		return false, common.NewErrorf("Failed to save Geofile %s: %v", destPath, err)
+	}
+	if err := os.Rename(file.Name(), destPath); err != nil {
+		return false, common.NewErrorf("Failed to save Geofile %s: %v", destPath, err)
+	}
+	versions[source.FileName] = &GeofileVersion{
+		Version:   geofileReleaseTag(resp.Request.URL.Path),
+		Sha256:    sum,
+		UpdatedAt: time.Now().UnixMilli(),
+	}
+	return true, nil
+}
+
+// updateGeofiles downloads the given geo databases and reports whether any of them changed.
+func (s *ServerService) updateGeofiles(fileName string) (bool, error) {
+	geofileLock.Lock()
+	defer geofileLock.Unlock()
+
+	sources := s.getGeofileSources()
+	if fileName != "" {
+		if !s.IsValidGeofileName(fileName) {
+			return false, common.NewErrorf("Invalid geofile name: contains unsafe path characters: %s", fileName)
+		}
+		var selected []geofileSource
+		for _, source := range sources {
+			if source.FileName == fileName {
+				selected = append(selected, source)
+			}
+		}
+		if len(selected) == 0 {
+			return false, common.NewErrorf("Invalid geofile name: %s not in allowlist", fileName)
+		}
+		sources = selected
+	}
+
+	versions := loadGeofileVersions()
+	changed := false
+	var errorMessages []string
+	for _, source := range sources {
+		updated, err := downloadGeofile(source, versions)
+		if err != nil {
+			errorMessages = append(errorMessages, fmt.Sprintf("Error downloading Geofile '%s': %v", source.FileName, err))
+			continue
+		}
+		changed = changed || updated
+	}
+	if err := saveGeofileVersions(versions); err != nil {
+		logger.Warning("Failed to save geofile versions:", err)
+	}
+
+	if len(errorMessages) > 0 {
+		return changed, common.NewErrorf("%s", strings.Join(errorMessages, "\r\n"))
+	}
+	return changed, nil
+}
+
+// UpdateGeofile downloads one geo database, or all of them if fileName is empty, and restarts Xray.
+func (s *ServerService) UpdateGeofile(fileName string) error {
+	_, err := s.updateGeofiles(fileName)
+
+	if restartErr := s.RestartXrayService(); restartErr != nil {
+		restartErr = common.NewErrorf("Updated Geofile '%s' but Failed to start Xray: %v", fileName, restartErr)
+		if err == nil {
+			return restartErr
+		}
+		return common.NewErrorf("%v\r\n%v", err, restartErr)
+	}
+	return err
+}
+
+// UpdateGeofilesIfChanged downloads all geo databases and restarts Xray only if one of them changed.
+func (s *ServerService) UpdateGeofilesIfChanged() (bool, error) {
+	changed, err := s.updateGeofiles("")
+	if changed {
+		if restartErr := s.RestartXrayService(); restartErr != nil {
+			return changed, restartErr
+		}
+	}
+	return changed, err
+}
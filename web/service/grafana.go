@@ -0,0 +1,138 @@
+package service
+
+import (
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// grafanaServerFields are the server resource series, named after the ServerStatSample fields.
+var grafanaServerFields = []string{"cpu", "mem", "swap", "disk", "load", "netUp", "netDown", "tcpCount", "udpCount"}
+
+// GrafanaSeries is a time series in the format of the Grafana JSON datasource,
+// each data point being the value and its Unix milliseconds.
+type GrafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// GrafanaService answers the Grafana JSON datasource with the stored traffic and server history.
+// Targets are "server:<field>", "inbound:<tag>:<up|down|total>" and "client:<email>:<up|down|total>".
+type GrafanaService struct {
+	trafficSampleService TrafficSampleService
+	serverService        ServerService
+}
+
+// SearchTargets returns the targets whose name contains filter, or all targets if it is empty.
+func (s *GrafanaService) SearchTargets(filter string) ([]string, error) {
+	var targets []string
+	for _, field := range grafanaServerFields {
+		targets = append(targets, "server:"+field)
+	}
+
+	var tags []string
+	if err := database.GetDB().Model(model.Inbound{}).Order("tag").Pluck("tag", &tags).Error; err != nil {
+		return nil, err
+	}
+	var emails []string
+	if err := database.GetDB().Model(xray.ClientTraffic{}).Order("email").Pluck("email", &emails).Error; err != nil {
+		return nil, err
+	}
+	for _, tag := range tags {
+		targets = append(targets, "inbound:"+tag+":up", "inbound:"+tag+":down", "inbound:"+tag+":total")
+	}
+	for _, email := range emails {
+		targets = append(targets, "client:"+email+":up", "client:"+email+":down", "client:"+email+":total")
+	}
+
+	if filter == "" {
+		return targets, nil
+	}
+	filtered := []string{}
+	for _, target := range targets {
+		if strings.Contains(target, filter) {
+			filtered = append(filtered, target)
+		}
+	}
+	return filtered, nil
+}
+
+// Query returns the series of the targets between from and to. Server series are averaged
+// per interval; traffic series keep the resolution of the stored samples.
+func (s *GrafanaService) Query(targets []string, from time.Time, to time.Time, interval time.Duration) ([]*GrafanaSeries, error) {
+	result := make([]*GrafanaSeries, 0, len(targets))
+	var serverSamples []*model.ServerStatSample
+	for _, target := range targets {
+		series := &GrafanaSeries{Target: target, Datapoints: [][2]float64{}}
+		kind, rest, _ := strings.Cut(target, ":")
+		switch kind {
+		case "server":
+			if _, ok := serverSampleField(&model.ServerStatSample{}, rest); !ok {
+				return nil, common.NewError("Unknown server series:", rest)
+			}
+			if serverSamples == nil {
+				var err error
+				serverSamples, err = s.serverService.GetStatusHistory(from.Unix(), to.Unix(), int64(interval.Seconds()))
+				if err != nil {
+					return nil, err
+				}
+			}
+			for _, sample := range serverSamples {
+				value, _ := serverSampleField(sample, rest)
+				series.Datapoints = append(series.Datapoints, [2]float64{value, float64(sample.Time * 1000)})
+			}
+		case "inbound", "client":
+			i := strings.LastIndex(rest, ":")
+			if i < 0 || (rest[i+1:] != "up" && rest[i+1:] != "down" && rest[i+1:] != "total") {
+				return nil, common.NewError("Traffic target must end with :up, :down or :total:", target)
+			}
+			name, direction := rest[:i], rest[i+1:]
+			samples, err := s.trafficSampleService.GetTrafficSamples(kind, name, from.UnixMilli(), to.UnixMilli())
+			if err != nil {
+				return nil, err
+			}
+			for _, sample := range samples {
+				value := sample.Up + sample.Down
+				switch direction {
+				case "up":
+					value = sample.Up
+				case "down":
+					value = sample.Down
+				}
+				series.Datapoints = append(series.Datapoints, [2]float64{float64(value), float64(sample.Time)})
+			}
+		default:
+			return nil, common.NewError("Unknown target:", target)
+		}
+		result = append(result, series)
+	}
+	return result, nil
+}
+
+func serverSampleField(sample *model.ServerStatSample, field string) (float64, bool) {
+	switch field {
+	case "cpu":
+		return sample.Cpu, true
+	case "mem":
+		return float64(sample.Mem), true
+	case "swap":
+		return float64(sample.Swap), true
+	case "disk":
+		return float64(sample.Disk), true
+	case "load":
+		return sample.Load, true
+	case "netUp":
+		return float64(sample.NetUp), true
+	case "netDown":
+		return float64(sample.NetDown), true
+	case "tcpCount":
+		return float64(sample.TcpCount), true
+	case "udpCount":
+		return float64(sample.UdpCount), true
+	}
+	return 0, false
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"strings"
+)
+
+// HttpAccessLog is the configuration of the HTTP access log of the panel and the subscription
+// server.
+type HttpAccessLog struct {
+	Format  string   // "combined" or "json"
+	Exclude []string // Path prefixes, relative to the base path, of the requests not logged
+}
+
+// GetHttpAccessLog returns the configuration of the HTTP access log, or nil when it is disabled.
+func (s *SettingService) GetHttpAccessLog() (*HttpAccessLog, error) {
+	enable, err := s.getBool("httpAccessLog")
+	if err != nil || !enable {
+		return nil, err
+	}
+	format, err := s.getString("httpAccessLogFormat")
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := s.getString("httpAccessLogExclude")
+	if err != nil {
+		return nil, err
+	}
+	accessLog := &HttpAccessLog{Format: format}
+	for _, prefix := range strings.Split(exclude, ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			accessLog.Exclude = append(accessLog.Exclude, "/"+strings.TrimPrefix(prefix, "/"))
+		}
+	}
+	return accessLog, nil
+}
@@ -5,6 +5,8 @@ package service
 import (
 	"encoding/json"
 	"fmt"
+	"net"
+	"net/netip"
 	"sort"
 	"strconv"
 	"strings"
@@ -14,8 +16,12 @@ import (
 	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/logger"
 	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
+	"github.com/mhsanaei/3x-ui/v2/util/sys"
+	"github.com/mhsanaei/3x-ui/v2/web/extension"
 	"github.com/mhsanaei/3x-ui/v2/xray"
 
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
@@ -23,7 +29,19 @@ import (
 // It handles CRUD operations for inbounds, client management, traffic monitoring,
 // and integration with the Xray API for real-time updates.
 type InboundService struct {
-	xrayApi xray.XrayAPI
+	xrayApi          xray.XrayAPI
+	settingService   SettingService
+	outboundService  OutboundService
+	extensionService ExtensionService
+}
+
+// InboundGroup holds the aggregated traffic of the inbounds sharing a group.
+type InboundGroup struct {
+	Name     string `json:"name"`
+	Inbounds int    `json:"inbounds"`
+	Up       int64  `json:"up"`
+	Down     int64  `json:"down"`
+	AllTime  int64  `json:"allTime"`
 }
 
 // GetInbounds retrieves all inbounds for a specific user.
@@ -57,9 +75,29 @@ func (s *InboundService) GetInbounds(userId int) ([]*model.Inbound, error) {
 	return inbounds, nil
 }
 
+// The caches of the inbound reads on hot paths, emptied whenever the inbounds or the traffic
+// of their clients change.
+var (
+	allInboundsCache   = newReadCache[[]*model.Inbound]("inbounds", "client_traffics")
+	subInboundsCache   = newReadCache[[]*model.Inbound]("inbounds", "client_traffics")
+	clientInboundCache = newReadCache[clientInbound]("inbounds", "client_traffics")
+)
+
+// clientInbound is the traffic of a client with the inbound it belongs to.
+type clientInbound struct {
+	traffic *xray.ClientTraffic
+	inbound *model.Inbound
+}
+
 // GetAllInbounds retrieves all inbounds from the database.
 // Returns a slice of all inbound models with their associated client statistics.
 func (s *InboundService) GetAllInbounds() ([]*model.Inbound, error) {
+	inbounds, err := allInboundsCache.get("", s.loadAllInbounds)
+	return cloneInbounds(inbounds), err
+}
+
+// loadAllInbounds reads all inbounds from the database for GetAllInbounds.
+func (s *InboundService) loadAllInbounds() ([]*model.Inbound, error) {
 	db := database.GetDB()
 	var inbounds []*model.Inbound
 	err := db.Model(model.Inbound{}).Preload("ClientStats").Find(&inbounds).Error
@@ -87,6 +125,91 @@ func (s *InboundService) GetAllInbounds() ([]*model.Inbound, error) {
 	return inbounds, nil
 }
 
+// FilterInbounds returns the inbounds that belong to the given group, carry the given tag and
+// have a client matching the client and field filters. Empty values are not used for filtering.
+func (s *InboundService) FilterInbounds(inbounds []*model.Inbound, group string, tag string, client string, field string) []*model.Inbound {
+	if group == "" && tag == "" && client == "" && field == "" {
+		return inbounds
+	}
+	filtered := make([]*model.Inbound, 0, len(inbounds))
+	for _, inbound := range inbounds {
+		if group != "" && !strings.EqualFold(inbound.GroupName, group) {
+			continue
+		}
+		if tag != "" && !s.hasTag(inbound, tag) {
+			continue
+		}
+		if (client != "" || field != "") && !s.hasClient(inbound, client, field) {
+			continue
+		}
+		filtered = append(filtered, inbound)
+	}
+	return filtered
+}
+
+// hasClient reports whether the inbound has a client matching both filters. query is searched
+// case-insensitively in the email, comment, note and custom field values; field has the form
+// key=value and matches a custom field exactly, or key alone for any client having the field.
+func (s *InboundService) hasClient(inbound *model.Inbound, query string, field string) bool {
+	clients, err := s.GetClients(inbound)
+	if err != nil {
+		return false
+	}
+	query = strings.ToLower(query)
+	fieldKey, fieldValue, withValue := strings.Cut(field, "=")
+	for _, client := range clients {
+		if field != "" {
+			value, ok := client.Fields[fieldKey]
+			if !ok || (withValue && value != fieldValue) {
+				continue
+			}
+		}
+		if query == "" || clientMatches(client, query) {
+			return true
+		}
+	}
+	return false
+}
+
+func clientMatches(client model.Client, query string) bool {
+	for _, text := range []string{client.Email, client.Comment, client.Note} {
+		if strings.Contains(strings.ToLower(text), query) {
+			return true
+		}
+	}
+	for _, value := range client.Fields {
+		if strings.Contains(strings.ToLower(value), query) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *InboundService) hasTag(inbound *model.Inbound, tag string) bool {
+	for _, t := range strings.Split(inbound.Tags, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetInboundGroups returns the traffic of the user's inbounds aggregated per group.
+func (s *InboundService) GetInboundGroups(userId int) ([]*InboundGroup, error) {
+	db := database.GetDB()
+	var groups []*InboundGroup
+	err := db.Model(model.Inbound{}).
+		Select("COALESCE(group_name, '') as name, count(*) as inbounds, sum(up) as up, sum(down) as down, sum(COALESCE(all_time, 0)) as all_time").
+		Where("user_id = ?", userId).
+		Group("COALESCE(group_name, '')").
+		Order("name").
+		Scan(&groups).Error
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
 func (s *InboundService) GetInboundsByTrafficReset(period string) ([]*model.Inbound, error) {
 	db := database.GetDB()
 	var inbounds []*model.Inbound
@@ -127,6 +250,219 @@ func (s *InboundService) checkPortExist(listen string, port int, ignoreId int) (
 	return count > 0, nil
 }
 
+// parsePortRange parses a comma separated list of ports and port ranges such as "20000-30000,40000".
+func parsePortRange(portRange string) ([][2]int, error) {
+	var ranges [][2]int
+	for _, part := range strings.Split(portRange, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		from, to, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(strings.TrimSpace(from))
+		if err != nil {
+			return nil, common.NewError("Invalid port range:", part)
+		}
+		end := start
+		if isRange {
+			end, err = strconv.Atoi(strings.TrimSpace(to))
+			if err != nil {
+				return nil, common.NewError("Invalid port range:", part)
+			}
+		}
+		if start < 1 || end > 65535 || start > end {
+			return nil, common.NewError("Invalid port range:", part)
+		}
+		ranges = append(ranges, [2]int{start, end})
+	}
+	return ranges, nil
+}
+
+func isAnyListen(listen string) bool {
+	return listen == "" || listen == "0.0.0.0" || listen == "::" || listen == "::0"
+}
+
+// NormalizeListen returns the listen address of an inbound in its canonical form, so that the
+// same address is always stored and compared alike: IPv6 addresses are compressed and without
+// brackets, IPv4-mapped ones become IPv4. Unix domain sockets and fallback names are kept.
+func NormalizeListen(listen string) (string, error) {
+	listen = strings.TrimSpace(listen)
+	if listen == "" || isSocketListen(listen) {
+		return listen, nil
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(listen, "["), "]"))
+	if err != nil {
+		return "", common.NewError("Listen is not an IP address or a unix socket:", listen)
+	}
+	return addr.Unmap().String(), nil
+}
+
+// InboundTag returns the tag of an inbound listening on an address and a port, with the address
+// only when the inbound is bound to a specific one.
+func InboundTag(listen string, port int) string {
+	if isAnyListen(listen) {
+		return fmt.Sprintf("inbound-%v", port)
+	}
+	return "inbound-" + net.JoinHostPort(listen, strconv.Itoa(port))
+}
+
+// isSocketListen reports whether the inbound listens on a unix domain socket instead of a port.
+func isSocketListen(listen string) bool {
+	return strings.HasPrefix(listen, "/") || strings.HasPrefix(listen, "@")
+}
+
+// isPortInUse reports whether another process on this host already listens on the port over TCP or UDP.
+func isPortInUse(listen string, port int) bool {
+	host := listen
+	if isAnyListen(listen) {
+		host = ""
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return true
+	}
+	listener.Close()
+	packetConn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return true
+	}
+	packetConn.Close()
+	return false
+}
+
+// checkPortConflict returns an error when the port is used by the panel, the subscription server
+// or, if checkListener is set, by another process on this host.
+func (s *InboundService) checkPortConflict(listen string, port int, checkListener bool) error {
+	if isSocketListen(listen) {
+		return nil
+	}
+	if port < 1 || port > 65535 {
+		return common.NewError("Invalid port:", port)
+	}
+	webPort, err := s.settingService.GetPort()
+	if err != nil {
+		return err
+	}
+	if port == webPort {
+		return common.NewError("Port is used by the panel:", port)
+	}
+	subEnable, err := s.settingService.GetSubEnable()
+	if err != nil {
+		return err
+	}
+	if subEnable {
+		subPort, err := s.settingService.GetSubPort()
+		if err != nil {
+			return err
+		}
+		if port == subPort {
+			return common.NewError("Port is used by the subscription server:", port)
+		}
+	}
+	if checkListener && isPortInUse(listen, port) {
+		return common.NewError("Port is already in use on this host:", port)
+	}
+	return nil
+}
+
+// checkOutboundTagExist returns an error when the outbound tag is not defined in the Xray template
+// or as a panel-managed outbound.
+func (s *InboundService) checkOutboundTagExist(tag string) error {
+	if tag == "" {
+		return nil
+	}
+	tags, err := s.outboundService.GetOutboundTags()
+	if err != nil {
+		return err
+	}
+	if !tags[tag] {
+		return common.NewError("Outbound not found:", tag)
+	}
+	return nil
+}
+
+// ValidateInbound checks the fallbacks, sniffing and transport settings of an inbound
+// so that a broken combination is rejected before it reaches the Xray config.
+func (s *InboundService) ValidateInbound(inbound *model.Inbound) error {
+	err := inbound.GenXrayInboundConfig().Validate()
+	if err != nil {
+		return common.NewError("Invalid inbound configuration:", err)
+	}
+	return nil
+}
+
+// GetFreePort picks a random port that is not used by any inbound, the panel,
+// the subscription server or another process on this host. A candidate is only returned
+// once it could be bound over TCP and UDP on the listen address.
+func (s *InboundService) GetFreePort(listen string) (int, error) {
+	for range 100 {
+		port := 10000 + random.Num(50000)
+		exist, err := s.checkPortExist(listen, port, 0)
+		if err != nil {
+			return 0, err
+		}
+		if exist {
+			continue
+		}
+		exist, err = s.checkPortRangeExist(&model.Inbound{Listen: listen, Port: port}, 0)
+		if err != nil {
+			return 0, err
+		}
+		if exist {
+			continue
+		}
+		if s.checkPortConflict(listen, port, false) != nil {
+			continue
+		}
+		// The database only knows the inbounds, another program may still have the port bound
+		if !isPortInUse(listen, port) {
+			return port, nil
+		}
+	}
+	return 0, common.NewError("Unable to find a free port")
+}
+
+// checkPortRangeExist reports whether the port range of the inbound overlaps the ports
+// of another inbound, or the port of the inbound falls in the range of another inbound.
+func (s *InboundService) checkPortRangeExist(inbound *model.Inbound, ignoreId int) (bool, error) {
+	ranges, err := parsePortRange(inbound.PortRange)
+	if err != nil {
+		return false, err
+	}
+	ranges = append(ranges, [2]int{inbound.Port, inbound.Port})
+
+	db := database.GetDB()
+	var others []*model.Inbound
+	query := db.Model(model.Inbound{}).Select("id, listen, port, port_range").Where("id != ?", ignoreId)
+	if inbound.PortRange == "" {
+		query = query.Where("port_range != ?", "")
+	}
+	err = query.Find(&others).Error
+	if err != nil {
+		return false, err
+	}
+
+	for _, other := range others {
+		if !isAnyListen(inbound.Listen) && !isAnyListen(other.Listen) && inbound.Listen != other.Listen {
+			continue
+		}
+		otherRanges, err := parsePortRange(other.PortRange)
+		if err != nil {
+			logger.Warning("Invalid port range of inbound", other.Id, ":", err)
+		}
+		otherRanges = append(otherRanges, [2]int{other.Port, other.Port})
+		for _, r := range ranges {
+			for _, o := range otherRanges {
+				if r[0] <= o[1] && o[0] <= r[1] {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
 func (s *InboundService) GetClients(inbound *model.Inbound) ([]model.Client, error) {
 	settings := map[string][]model.Client{}
 	json.Unmarshal([]byte(inbound.Settings), &settings)
@@ -223,6 +559,11 @@ func (s *InboundService) checkEmailExistForInbound(inbound *model.Inbound) (stri
 // then saves the inbound to the database and optionally adds it to the running Xray instance.
 // Returns the created inbound, whether Xray needs restart, and any error.
 func (s *InboundService) AddInbound(inbound *model.Inbound) (*model.Inbound, bool, error) {
+	listen, err := NormalizeListen(inbound.Listen)
+	if err != nil {
+		return inbound, false, err
+	}
+	inbound.Listen = listen
 	exist, err := s.checkPortExist(inbound.Listen, inbound.Port, 0)
 	if err != nil {
 		return inbound, false, err
@@ -230,6 +571,38 @@ func (s *InboundService) AddInbound(inbound *model.Inbound) (*model.Inbound, boo
 	if exist {
 		return inbound, false, common.NewError("Port already exists:", inbound.Port)
 	}
+	exist, err = s.checkPortRangeExist(inbound, 0)
+	if err != nil {
+		return inbound, false, err
+	}
+	if exist {
+		return inbound, false, common.NewError("Port range overlaps another inbound:", inbound.PortRange)
+	}
+	err = s.checkPortConflict(inbound.Listen, inbound.Port, inbound.Enable)
+	if err != nil {
+		return inbound, false, err
+	}
+	err = s.checkOutboundTagExist(inbound.OutboundTag)
+	if err != nil {
+		return inbound, false, err
+	}
+	inbound.Settings, err = s.applyClientPolicy(inbound.Settings, inbound.Protocol)
+	if err != nil {
+		return inbound, false, err
+	}
+	inbound.StreamSettings, _, err = applyCertStore(database.GetDB(), inbound.StreamSettings)
+	if err != nil {
+		return inbound, false, err
+	}
+	err = s.ValidateInbound(inbound)
+	if err != nil {
+		return inbound, false, err
+	}
+
+	inbound.Settings, err = applyClientPlans(inbound.Settings)
+	if err != nil {
+		return inbound, false, err
+	}
 
 	existEmail, err := s.checkEmailExistForInbound(inbound)
 	if err != nil {
@@ -271,7 +644,7 @@ func (s *InboundService) AddInbound(inbound *model.Inbound) (*model.Inbound, boo
 	// Secure client ID
 	for _, client := range clients {
 		switch inbound.Protocol {
-		case "trojan":
+		case "trojan", "hysteria2":
 			if client.Password == "" {
 				return inbound, false, common.NewError("empty client ID")
 			}
@@ -307,9 +680,10 @@ func (s *InboundService) AddInbound(inbound *model.Inbound) (*model.Inbound, boo
 		return inbound, false, err
 	}
 
-	needRestart := false
+	// Routing pins are part of the routing config, which can't be changed through the API
+	needRestart := inbound.Enable && inbound.OutboundTag != ""
 	if inbound.Enable {
-		s.xrayApi.Init(p.GetAPIPort())
+		s.xrayApi.Init(getAPIPort())
 		inboundJson, err1 := json.MarshalIndent(inbound.GenXrayInboundConfig(), "", "  ")
 		if err1 != nil {
 			logger.Debug("Unable to marshal inbound config:", err1)
@@ -325,6 +699,7 @@ func (s *InboundService) AddInbound(inbound *model.Inbound) (*model.Inbound, boo
 		s.xrayApi.Close()
 	}
 
+	s.runClientHooks(inbound, clients)
 	return inbound, needRestart, err
 }
 
@@ -338,7 +713,7 @@ func (s *InboundService) DelInbound(id int) (bool, error) {
 	needRestart := false
 	result := db.Model(model.Inbound{}).Select("tag").Where("id = ? and enable = ?", id, true).First(&tag)
 	if result.Error == nil {
-		s.xrayApi.Init(p.GetAPIPort())
+		s.xrayApi.Init(getAPIPort())
 		err1 := s.xrayApi.DelInbound(tag)
 		if err1 == nil {
 			logger.Debug("Inbound deleted by api:", tag)
@@ -356,10 +731,17 @@ func (s *InboundService) DelInbound(id int) (bool, error) {
 	if err != nil {
 		return false, err
 	}
+	err = db.Where("inbound_id = ?", id).Delete(model.InboundHistory{}).Error
+	if err != nil {
+		return false, err
+	}
 	inbound, err := s.GetInbound(id)
 	if err != nil {
 		return false, err
 	}
+	if inbound.Enable && inbound.OutboundTag != "" {
+		needRestart = true
+	}
 	clients, err := s.GetClients(inbound)
 	if err != nil {
 		return false, err
@@ -388,6 +770,11 @@ func (s *InboundService) GetInbound(id int) (*model.Inbound, error) {
 // It validates changes, updates the database, and syncs with the running Xray instance.
 // Returns the updated inbound, whether Xray needs restart, and any error.
 func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound, bool, error) {
+	listen, err := NormalizeListen(inbound.Listen)
+	if err != nil {
+		return inbound, false, err
+	}
+	inbound.Listen = listen
 	exist, err := s.checkPortExist(inbound.Listen, inbound.Port, inbound.Id)
 	if err != nil {
 		return inbound, false, err
@@ -395,12 +782,39 @@ func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound,
 	if exist {
 		return inbound, false, common.NewError("Port already exists:", inbound.Port)
 	}
+	exist, err = s.checkPortRangeExist(inbound, inbound.Id)
+	if err != nil {
+		return inbound, false, err
+	}
+	if exist {
+		return inbound, false, common.NewError("Port range overlaps another inbound:", inbound.PortRange)
+	}
 
 	oldInbound, err := s.GetInbound(inbound.Id)
 	if err != nil {
 		return inbound, false, err
 	}
 
+	// The running Xray instance holds the port of an enabled inbound, so only probe
+	// the host listeners when the inbound moves to a new address.
+	portChanged := oldInbound.Port != inbound.Port || oldInbound.Listen != inbound.Listen || !oldInbound.Enable
+	err = s.checkPortConflict(inbound.Listen, inbound.Port, inbound.Enable && portChanged)
+	if err != nil {
+		return inbound, false, err
+	}
+	err = s.checkOutboundTagExist(inbound.OutboundTag)
+	if err != nil {
+		return inbound, false, err
+	}
+	inbound.StreamSettings, _, err = applyCertStore(database.GetDB(), inbound.StreamSettings)
+	if err != nil {
+		return inbound, false, err
+	}
+	err = s.ValidateInbound(inbound)
+	if err != nil {
+		return inbound, false, err
+	}
+
 	tag := oldInbound.Tag
 
 	db := database.GetDB()
@@ -414,6 +828,11 @@ func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound,
 		}
 	}()
 
+	err = s.saveInboundHistory(tx, oldInbound)
+	if err != nil {
+		return inbound, false, err
+	}
+
 	err = s.updateClientTraffics(tx, oldInbound, inbound)
 	if err != nil {
 		return inbound, false, err
@@ -486,19 +905,23 @@ func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound,
 	oldInbound.ExpiryTime = inbound.ExpiryTime
 	oldInbound.TrafficReset = inbound.TrafficReset
 	oldInbound.Listen = inbound.Listen
+	oldInbound.GroupName = inbound.GroupName
+	oldInbound.Tags = inbound.Tags
+	// Routing pins are part of the routing config, which can't be changed through the API
+	routingChanged := oldInbound.OutboundTag != inbound.OutboundTag ||
+		(inbound.OutboundTag != "" && oldInbound.Enable != inbound.Enable)
+	oldInbound.OutboundTag = inbound.OutboundTag
+	oldInbound.MaxConnections = inbound.MaxConnections
 	oldInbound.Port = inbound.Port
+	oldInbound.PortRange = inbound.PortRange
 	oldInbound.Protocol = inbound.Protocol
 	oldInbound.Settings = inbound.Settings
 	oldInbound.StreamSettings = inbound.StreamSettings
 	oldInbound.Sniffing = inbound.Sniffing
-	if inbound.Listen == "" || inbound.Listen == "0.0.0.0" || inbound.Listen == "::" || inbound.Listen == "::0" {
-		oldInbound.Tag = fmt.Sprintf("inbound-%v", inbound.Port)
-	} else {
-		oldInbound.Tag = fmt.Sprintf("inbound-%v:%v", inbound.Listen, inbound.Port)
-	}
+	oldInbound.Tag = InboundTag(inbound.Listen, inbound.Port)
 
-	needRestart := false
-	s.xrayApi.Init(p.GetAPIPort())
+	needRestart := routingChanged || (oldInbound.OutboundTag != "" && oldInbound.Tag != tag)
+	s.xrayApi.Init(getAPIPort())
 	if s.xrayApi.DelInbound(tag) == nil {
 		logger.Debug("Old inbound deleted by api:", tag)
 	}
@@ -568,6 +991,19 @@ func (s *InboundService) updateClientTraffics(tx *gorm.DB, oldInbound *model.Inb
 }
 
 func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
+	oldInbound, err := s.GetInbound(data.Id)
+	if err != nil {
+		return false, err
+	}
+	data.Settings, err = s.applyClientPolicy(data.Settings, oldInbound.Protocol)
+	if err != nil {
+		return false, err
+	}
+	data.Settings, err = applyClientPlans(data.Settings)
+	if err != nil {
+		return false, err
+	}
+
 	clients, err := s.GetClients(data)
 	if err != nil {
 		return false, err
@@ -599,15 +1035,10 @@ func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
 		return false, common.NewError("Duplicate email:", existEmail)
 	}
 
-	oldInbound, err := s.GetInbound(data.Id)
-	if err != nil {
-		return false, err
-	}
-
 	// Secure client ID
 	for _, client := range clients {
 		switch oldInbound.Protocol {
-		case "trojan":
+		case "trojan", "hysteria2":
 			if client.Password == "" {
 				return false, common.NewError("empty client ID")
 			}
@@ -651,8 +1082,13 @@ func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
 		}
 	}()
 
+	err = s.snapshotInbound(tx, data.Id)
+	if err != nil {
+		return false, err
+	}
+
 	needRestart := false
-	s.xrayApi.Init(p.GetAPIPort())
+	s.xrayApi.Init(getAPIPort())
 	for _, client := range clients {
 		if len(client.Email) > 0 {
 			s.AddClientStat(tx, data.Id, &client)
@@ -682,7 +1118,25 @@ func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
 	}
 	s.xrayApi.Close()
 
-	return needRestart, tx.Save(oldInbound).Error
+	if err = tx.Save(oldInbound).Error; err != nil {
+		return false, err
+	}
+	s.runClientHooks(oldInbound, clients)
+	return needRestart, nil
+}
+
+// runClientHooks runs the extension hooks of the clients added to an inbound in the background.
+func (s *InboundService) runClientHooks(inbound *model.Inbound, clients []model.Client) {
+	go func() {
+		for _, client := range clients {
+			s.extensionService.RunHooks(extension.ClientCreated, &extension.ClientPayload{
+				InboundId:  inbound.Id,
+				InboundTag: inbound.Tag,
+				Protocol:   string(inbound.Protocol),
+				Client:     client,
+			})
+		}
+	}()
 }
 
 func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool, error) {
@@ -699,7 +1153,7 @@ func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool,
 
 	email := ""
 	client_key := "id"
-	if oldInbound.Protocol == "trojan" {
+	if oldInbound.Protocol == "trojan" || oldInbound.Protocol == "hysteria2" {
 		client_key = "password"
 	}
 	if oldInbound.Protocol == "shadowsocks" {
@@ -733,8 +1187,21 @@ func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool,
 	oldInbound.Settings = string(newSettings)
 
 	db := database.GetDB()
+	tx := db.Begin()
 
-	err = s.DelClientIPs(db, email)
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	err = s.snapshotInbound(tx, inboundId)
+	if err != nil {
+		return false, err
+	}
+	err = s.DelClientIPs(tx, email)
 	if err != nil {
 		logger.Error("Error in delete client IPs")
 		return false, err
@@ -743,18 +1210,18 @@ func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool,
 
 	if len(email) > 0 {
 		notDepleted := true
-		err = db.Model(xray.ClientTraffic{}).Select("enable").Where("email = ?", email).First(&notDepleted).Error
+		err = tx.Model(xray.ClientTraffic{}).Select("enable").Where("email = ?", email).First(&notDepleted).Error
 		if err != nil {
 			logger.Error("Get stats error")
 			return false, err
 		}
-		err = s.DelClientStat(db, email)
+		err = s.DelClientStat(tx, email)
 		if err != nil {
 			logger.Error("Delete stats Data Error")
 			return false, err
 		}
 		if needApiDel && notDepleted {
-			s.xrayApi.Init(p.GetAPIPort())
+			s.xrayApi.Init(getAPIPort())
 			err1 := s.xrayApi.RemoveUser(oldInbound.Tag, email)
 			if err1 == nil {
 				logger.Debug("Client deleted by api:", email)
@@ -770,7 +1237,283 @@ func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool,
 			s.xrayApi.Close()
 		}
 	}
-	return needRestart, db.Save(oldInbound).Error
+	err = tx.Save(oldInbound).Error
+	return needRestart, err
+}
+
+// MoveClients moves or copies the clients with the given emails from one inbound to another.
+// Protocol-specific credentials are carried over when both protocols use the same kind
+// (UUID or password) and generated otherwise. Moved clients keep their traffic record,
+// copied clients get a new email with a copy of the source counters and expiry.
+// Returns whether Xray needs restart and any error.
+func (s *InboundService) MoveClients(fromId int, toId int, emails []string, copyOnly bool) (bool, error) {
+	if fromId == toId {
+		return false, common.NewError("Source and target inbound are the same")
+	}
+	if len(emails) == 0 {
+		return false, common.NewError("No clients selected")
+	}
+	source, err := s.GetInbound(fromId)
+	if err != nil {
+		return false, err
+	}
+	target, err := s.GetInbound(toId)
+	if err != nil {
+		return false, err
+	}
+	switch target.Protocol {
+	case model.VMESS, model.VLESS, model.Trojan, model.Shadowsocks:
+	default:
+		return false, common.NewError("Target inbound does not support clients:", target.Remark)
+	}
+
+	var sourceSettings, targetSettings map[string]any
+	err = json.Unmarshal([]byte(source.Settings), &sourceSettings)
+	if err != nil {
+		return false, err
+	}
+	err = json.Unmarshal([]byte(target.Settings), &targetSettings)
+	if err != nil {
+		return false, err
+	}
+	sourceClients, _ := sourceSettings["clients"].([]any)
+	targetClients, _ := targetSettings["clients"].([]any)
+	targetMethod, _ := targetSettings["method"].(string)
+
+	keepFlow := false
+	if target.Protocol == model.VLESS {
+		stream, _ := target.GenXrayInboundConfig().GetStream()
+		keepFlow = stream != nil && (stream.Network == "tcp" || stream.Network == "raw") &&
+			(stream.Security == "tls" || stream.Security == "reality")
+	}
+
+	selected := make(map[string]bool, len(emails))
+	for _, email := range emails {
+		selected[email] = true
+	}
+	allEmails, err := s.getAllEmails()
+	if err != nil {
+		return false, err
+	}
+
+	var remainingClients []any
+	var movedClients []map[string]any
+	var movedEmails []string
+	renamed := make(map[string]string)
+	nowTs := time.Now().Unix() * 1000
+	for _, client := range sourceClients {
+		c, ok := client.(map[string]any)
+		email, _ := c["email"].(string)
+		if !ok || !selected[email] {
+			remainingClients = append(remainingClients, client)
+			continue
+		}
+		delete(selected, email)
+		movedEmails = append(movedEmails, email)
+		if copyOnly {
+			remainingClients = append(remainingClients, client)
+		}
+
+		newClient := make(map[string]any, len(c))
+		for k, v := range c {
+			newClient[k] = v
+		}
+		if copyOnly {
+			newEmail := email + "-" + random.Seq(4)
+			for s.contains(allEmails, newEmail) {
+				newEmail = email + "-" + random.Seq(4)
+			}
+			allEmails = append(allEmails, newEmail)
+			newClient["email"] = newEmail
+			if _, ok := newClient["statusToken"]; ok {
+				newClient["statusToken"] = random.SeqFrom(24, clientLowerAndNum)
+			}
+			renamed[email] = newEmail
+		}
+		s.remapClientCredentials(newClient, source.Protocol, target.Protocol, targetMethod)
+		if flow, _ := newClient["flow"].(string); flow != "" && !keepFlow {
+			delete(newClient, "flow")
+		}
+		newClient["updated_at"] = nowTs
+		movedClients = append(movedClients, newClient)
+	}
+	if len(selected) > 0 {
+		missing := make([]string, 0, len(selected))
+		for email := range selected {
+			missing = append(missing, email)
+		}
+		return false, common.NewError("Clients not found in source inbound:", strings.Join(missing, ", "))
+	}
+	if len(remainingClients) == 0 {
+		return false, common.NewError("no client remained in Inbound")
+	}
+
+	for _, client := range movedClients {
+		targetClients = append(targetClients, client)
+	}
+	sourceSettings["clients"] = remainingClients
+	targetSettings["clients"] = targetClients
+	newSourceSettings, err := json.MarshalIndent(sourceSettings, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	newTargetSettings, err := json.MarshalIndent(targetSettings, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	source.Settings = string(newSourceSettings)
+	target.Settings = string(newTargetSettings)
+
+	db := database.GetDB()
+	tx := db.Begin()
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	if !copyOnly {
+		err = s.snapshotInbound(tx, fromId)
+		if err != nil {
+			return false, err
+		}
+	}
+	err = s.snapshotInbound(tx, toId)
+	if err != nil {
+		return false, err
+	}
+
+	for _, email := range movedEmails {
+		if copyOnly {
+			var traffic xray.ClientTraffic
+			err = tx.Model(xray.ClientTraffic{}).Where("email = ?", email).First(&traffic).Error
+			if err != nil {
+				return false, err
+			}
+			traffic.Id = 0
+			traffic.InboundId = toId
+			traffic.Email = renamed[email]
+			err = tx.Create(&traffic).Error
+		} else {
+			err = tx.Model(xray.ClientTraffic{}).Where("email = ?", email).Update("inbound_id", toId).Error
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	if !copyOnly {
+		err = tx.Save(source).Error
+		if err != nil {
+			return false, err
+		}
+	}
+	err = tx.Save(target).Error
+	if err != nil {
+		return false, err
+	}
+
+	// Clients disabled by their traffic or expiry limits stay out of Xray in the target inbound
+	var disabledEmails []string
+	err = tx.Model(xray.ClientTraffic{}).Where("email IN ? AND enable = ?", movedEmails, false).Pluck("email", &disabledEmails).Error
+	if err != nil {
+		return false, err
+	}
+
+	needRestart := false
+	s.xrayApi.Init(getAPIPort())
+	for i, client := range movedClients {
+		enable, _ := client["enable"].(bool)
+		if !enable || s.contains(disabledEmails, movedEmails[i]) {
+			continue
+		}
+		email, _ := client["email"].(string)
+		if !copyOnly && source.Enable {
+			err1 := s.xrayApi.RemoveUser(source.Tag, email)
+			if err1 == nil {
+				logger.Debug("Client deleted by api:", email)
+			} else if !strings.Contains(err1.Error(), fmt.Sprintf("User %s not found.", email)) {
+				logger.Debug("Error in deleting client by api:", err1)
+				needRestart = true
+			}
+		}
+		if target.Enable {
+			id, _ := client["id"].(string)
+			security, _ := client["security"].(string)
+			flow, _ := client["flow"].(string)
+			password, _ := client["password"].(string)
+			err1 := s.xrayApi.AddUser(string(target.Protocol), target.Tag, map[string]any{
+				"email":    email,
+				"id":       id,
+				"security": security,
+				"flow":     flow,
+				"password": password,
+				"cipher":   targetMethod,
+			})
+			if err1 == nil {
+				logger.Debug("Client added by api:", email)
+			} else {
+				logger.Debug("Error in adding client by api:", err1)
+				needRestart = true
+			}
+		}
+	}
+	s.xrayApi.Close()
+
+	return needRestart, nil
+}
+
+// TransferClient moves the client with the given email to another inbound. The client keeps its
+// email, subscription ID, traffic counters and expiry, so its subscription URL stays valid.
+// Returns whether Xray needs restart and any error.
+func (s *InboundService) TransferClient(email string, toId int) (bool, error) {
+	_, inbound, err := s.GetClientInboundByEmail(email)
+	if err != nil {
+		return false, err
+	}
+	if inbound == nil {
+		return false, common.NewError("Client not found:", email)
+	}
+	return s.MoveClients(inbound.Id, toId, []string{email}, false)
+}
+
+// remapClientCredentials rewrites the protocol-specific fields of a client that changes protocol.
+// VMess and VLESS identify clients by UUID, Trojan and Shadowsocks by password.
+func (s *InboundService) remapClientCredentials(client map[string]any, from model.Protocol, to model.Protocol, method string) {
+	switch to {
+	case model.VMESS, model.VLESS:
+		if id, _ := client["id"].(string); id == "" {
+			client["id"] = uuid.NewString()
+		}
+		if to == model.VMESS {
+			if security, _ := client["security"].(string); security == "" {
+				client["security"] = "auto"
+			}
+		} else {
+			delete(client, "security")
+		}
+		delete(client, "password")
+		delete(client, "method")
+	case model.Trojan:
+		if from != model.Trojan {
+			regenerateClientCredential(client, to, method)
+		}
+		delete(client, "id")
+		delete(client, "security")
+		delete(client, "method")
+	case model.Shadowsocks:
+		if from != model.Shadowsocks {
+			regenerateClientCredential(client, to, method)
+		}
+		if strings.HasPrefix(method, "2022") {
+			client["method"] = ""
+		} else {
+			client["method"] = method
+		}
+		delete(client, "id")
+		delete(client, "security")
+	}
 }
 
 func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId string) (bool, error) {
@@ -804,7 +1547,7 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 	for index, oldClient := range oldClients {
 		oldClientId := ""
 		switch oldInbound.Protocol {
-		case "trojan":
+		case "trojan", "hysteria2":
 			oldClientId = oldClient.Password
 			newClientId = clients[0].Password
 		case "shadowsocks":
@@ -881,6 +1624,11 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 		}
 	}()
 
+	err = s.snapshotInbound(tx, data.Id)
+	if err != nil {
+		return false, err
+	}
+
 	if len(clients[0].Email) > 0 {
 		if len(oldEmail) > 0 {
 			err = s.UpdateClientStat(tx, oldEmail, &clients[0])
@@ -906,7 +1654,7 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 	}
 	needRestart := false
 	if len(oldEmail) > 0 {
-		s.xrayApi.Init(p.GetAPIPort())
+		s.xrayApi.Init(getAPIPort())
 		if oldClients[clientIndex].Enable {
 			err1 := s.xrayApi.RemoveUser(oldInbound.Tag, oldEmail)
 			if err1 == nil {
@@ -948,7 +1696,9 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 	return needRestart, tx.Save(oldInbound).Error
 }
 
-func (s *InboundService) AddTraffic(inboundTraffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) (error, bool) {
+// AddNodeTraffic adds the traffic used on a node to the inbounds and clients and applies their
+// limits like FlushTraffic does, without touching the online clients of this server.
+func (s *InboundService) AddNodeTraffic(inboundTraffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) (bool, error) {
 	var err error
 	db := database.GetDB()
 	tx := db.Begin()
@@ -962,13 +1712,20 @@ func (s *InboundService) AddTraffic(inboundTraffics []*xray.Traffic, clientTraff
 	}()
 	err = s.addInboundTraffic(tx, inboundTraffics)
 	if err != nil {
-		return err, false
+		return false, err
 	}
-	err = s.addClientTraffic(tx, clientTraffics)
-	if err != nil {
-		return err, false
+	if len(clientTraffics) > 0 {
+		_, err = s.addClientTrafficCounters(tx, clientTraffics)
+		if err != nil {
+			return false, err
+		}
 	}
+	return s.applyTrafficLimits(tx), nil
+}
 
+// applyTrafficLimits renews the clients that are due and disables the clients and inbounds
+// over their limits, reporting whether the core needs a restart.
+func (s *InboundService) applyTrafficLimits(tx *gorm.DB) bool {
 	needRestart0, count, err := s.autoRenewClients(tx)
 	if err != nil {
 		logger.Warning("Error in renew clients:", err)
@@ -989,7 +1746,7 @@ func (s *InboundService) AddTraffic(inboundTraffics []*xray.Traffic, clientTraff
 	} else if count > 0 {
 		logger.Debugf("%v inbounds disabled", count)
 	}
-	return nil, (needRestart0 || needRestart1 || needRestart2)
+	return needRestart0 || needRestart1 || needRestart2
 }
 
 func (s *InboundService) addInboundTraffic(tx *gorm.DB, traffics []*xray.Traffic) error {
@@ -998,6 +1755,7 @@ func (s *InboundService) addInboundTraffic(tx *gorm.DB, traffics []*xray.Traffic
 	}
 
 	var err error
+	hour := currentTrafficHour(&s.settingService)
 
 	for _, traffic := range traffics {
 		if traffic.IsInbound {
@@ -1010,22 +1768,20 @@ func (s *InboundService) addInboundTraffic(tx *gorm.DB, traffics []*xray.Traffic
 			if err != nil {
 				return err
 			}
+			if traffic.Up+traffic.Down > 0 {
+				err = addTrafficSample(tx, "inbound", traffic.Tag, TrafficSampleHour, hour, traffic.Up, traffic.Down)
+				if err != nil {
+					return err
+				}
+			}
 		}
 	}
 	return nil
 }
 
-func (s *InboundService) addClientTraffic(tx *gorm.DB, traffics []*xray.ClientTraffic) (err error) {
-	if len(traffics) == 0 {
-		// Empty onlineUsers
-		if p != nil {
-			p.SetOnlineClients(nil)
-		}
-		return nil
-	}
-
-	var onlineClients []string
-
+// addClientTrafficCounters adds traffic to the counters, the daily traffic and the samples of
+// the clients and returns the clients that used traffic.
+func (s *InboundService) addClientTrafficCounters(tx *gorm.DB, traffics []*xray.ClientTraffic) (onlineClients []string, err error) {
 	emails := make([]string, 0, len(traffics))
 	for _, traffic := range traffics {
 		emails = append(emails, traffic.Email)
@@ -1033,17 +1789,17 @@ func (s *InboundService) addClientTraffic(tx *gorm.DB, traffics []*xray.ClientTr
 	dbClientTraffics := make([]*xray.ClientTraffic, 0, len(traffics))
 	err = tx.Model(xray.ClientTraffic{}).Where("email IN (?)", emails).Find(&dbClientTraffics).Error
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Avoid empty slice error
 	if len(dbClientTraffics) == 0 {
-		return nil
+		return nil, nil
 	}
 
-	dbClientTraffics, err = s.adjustTraffics(tx, dbClientTraffics)
+	dbClientTraffics, err = s.adjustTraffics(tx, dbClientTraffics, traffics)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	for dbTraffic_index := range dbClientTraffics {
@@ -1063,21 +1819,49 @@ func (s *InboundService) addClientTraffic(tx *gorm.DB, traffics []*xray.ClientTr
 		}
 	}
 
-	// Set onlineUsers
-	p.SetOnlineClients(onlineClients)
-
 	err = tx.Save(dbClientTraffics).Error
 	if err != nil {
 		logger.Warning("AddClientTraffic update data ", err)
 	}
 
-	return nil
+	err = s.addClientDailyTraffic(tx, dbClientTraffics, traffics)
+	if err != nil {
+		logger.Warning("AddClientTraffic update daily data ", err)
+	}
+
+	known := make(map[string]bool, len(dbClientTraffics))
+	for _, traffic := range dbClientTraffics {
+		known[traffic.Email] = true
+	}
+	hour := currentTrafficHour(&s.settingService)
+	for _, traffic := range traffics {
+		if !known[traffic.Email] || traffic.Up+traffic.Down == 0 {
+			continue
+		}
+		err = addTrafficSample(tx, "client", traffic.Email, TrafficSampleHour, hour, traffic.Up, traffic.Down)
+		if err != nil {
+			logger.Warning("AddClientTraffic update traffic samples ", err)
+			break
+		}
+	}
+
+	return onlineClients, nil
 }
 
-func (s *InboundService) adjustTraffics(tx *gorm.DB, dbClientTraffics []*xray.ClientTraffic) ([]*xray.ClientTraffic, error) {
+// adjustTraffics starts the expiry of clients with a delayed start that used traffic in this
+// cycle. A negative expiry holds the validity duration until the first use, when it is replaced
+// by the real expiry counted from now. Xray keeps reporting idle counters once a user connected,
+// so only actual traffic counts as the first use.
+func (s *InboundService) adjustTraffics(tx *gorm.DB, dbClientTraffics []*xray.ClientTraffic, traffics []*xray.ClientTraffic) ([]*xray.ClientTraffic, error) {
+	used := make(map[string]bool, len(traffics))
+	for _, traffic := range traffics {
+		if traffic.Up+traffic.Down > 0 {
+			used[traffic.Email] = true
+		}
+	}
 	inboundIds := make([]int, 0, len(dbClientTraffics))
 	for _, dbClientTraffic := range dbClientTraffics {
-		if dbClientTraffic.ExpiryTime < 0 {
+		if dbClientTraffic.ExpiryTime < 0 && used[dbClientTraffic.Email] {
 			inboundIds = append(inboundIds, dbClientTraffic.InboundId)
 		}
 	}
@@ -1097,12 +1881,18 @@ func (s *InboundService) adjustTraffics(tx *gorm.DB, dbClientTraffics []*xray.Cl
 				for client_index := range clients {
 					c := clients[client_index].(map[string]any)
 					for traffic_index := range dbClientTraffics {
-						if dbClientTraffics[traffic_index].ExpiryTime < 0 && c["email"] == dbClientTraffics[traffic_index].Email {
-							oldExpiryTime := c["expiryTime"].(float64)
-							newExpiryTime := (time.Now().Unix() * 1000) - int64(oldExpiryTime)
+						if dbClientTraffics[traffic_index].ExpiryTime < 0 && used[dbClientTraffics[traffic_index].Email] && c["email"] == dbClientTraffics[traffic_index].Email {
+							delay := -int64(c["expiryTime"].(float64))
+							newExpiryTime := time.Now().UnixMilli() + delay
+							if delay%86400000 == 0 {
+								// A delay of whole days runs until the end of the last day
+								newExpiryTime = s.settingService.ExpiryAfterDays(int(delay / 86400000))
+							}
 							c["expiryTime"] = newExpiryTime
 							c["updated_at"] = time.Now().Unix() * 1000
 							dbClientTraffics[traffic_index].ExpiryTime = newExpiryTime
+							logger.Infof("Client %s used for the first time, expires at %s", dbClientTraffics[traffic_index].Email,
+								time.UnixMilli(newExpiryTime).Format("2006-01-02 15:04:05"))
 							break
 						}
 					}
@@ -1173,7 +1963,7 @@ func (s *InboundService) autoRenewClients(tx *gorm.DB) (bool, int64, error) {
 				if traffic.Email == c["email"].(string) {
 					newExpiryTime := traffic.ExpiryTime
 					for newExpiryTime < now {
-						newExpiryTime += (int64(traffic.Reset) * 86400000)
+						newExpiryTime = s.settingService.ExtendExpiry(newExpiryTime, traffic.Reset)
 					}
 					c["expiryTime"] = newExpiryTime
 					traffics[traffic_index].ExpiryTime = newExpiryTime
@@ -1213,7 +2003,7 @@ func (s *InboundService) autoRenewClients(tx *gorm.DB) (bool, int64, error) {
 		return false, 0, err
 	}
 	if p != nil {
-		err1 = s.xrayApi.Init(p.GetAPIPort())
+		err1 = s.xrayApi.Init(getAPIPort())
 		if err1 != nil {
 			return true, int64(len(traffics)), nil
 		}
@@ -1241,7 +2031,7 @@ func (s *InboundService) disableInvalidInbounds(tx *gorm.DB) (bool, int64, error
 		if err != nil {
 			return false, 0, err
 		}
-		s.xrayApi.Init(p.GetAPIPort())
+		s.xrayApi.Init(getAPIPort())
 		for _, tag := range tags {
 			err1 := s.xrayApi.DelInbound(tag)
 			if err1 == nil {
@@ -1280,7 +2070,7 @@ func (s *InboundService) disableInvalidClients(tx *gorm.DB) (bool, int64, error)
 		if err != nil {
 			return false, 0, err
 		}
-		s.xrayApi.Init(p.GetAPIPort())
+		s.xrayApi.Init(getAPIPort())
 		for _, result := range results {
 			err1 := s.xrayApi.RemoveUser(result.Tag, result.Email)
 			if err1 == nil {
@@ -1379,17 +2169,38 @@ func (s *InboundService) AddClientStat(tx *gorm.DB, inboundId int, client *model
 }
 
 func (s *InboundService) UpdateClientStat(tx *gorm.DB, email string, client *model.Client) error {
+	updates := map[string]any{
+		"enable":      client.Enable,
+		"email":       client.Email,
+		"total":       client.TotalGB,
+		"expiry_time": client.ExpiryTime,
+		"reset":       client.Reset,
+	}
+	if client.ResetPolicy == "renewal" && client.ExpiryTime > 0 {
+		// Extending the expiry starts a new period with a fresh quota
+		var oldExpiryTime int64
+		err := tx.Model(xray.ClientTraffic{}).Select("expiry_time").Where("email = ?", email).Scan(&oldExpiryTime).Error
+		if err != nil {
+			return err
+		}
+		if oldExpiryTime != 0 && client.ExpiryTime > oldExpiryTime {
+			updates["up"] = 0
+			updates["down"] = 0
+			updates["last_reset_time"] = time.Now().UnixMilli()
+		}
+	}
 	result := tx.Model(xray.ClientTraffic{}).
 		Where("email = ?", email).
-		Updates(map[string]any{
-			"enable":      client.Enable,
-			"email":       client.Email,
-			"total":       client.TotalGB,
-			"expiry_time": client.ExpiryTime,
-			"reset":       client.Reset,
-		})
+		Updates(updates)
 	err := result.Error
-	return err
+	if err != nil || email == client.Email {
+		return err
+	}
+	err = tx.Model(model.ClientTrafficDaily{}).Where("email = ?", email).Update("email", client.Email).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(model.ClientNotice{}).Where("email = ?", email).Update("email", client.Email).Error
 }
 
 func (s *InboundService) UpdateClientIPs(tx *gorm.DB, oldEmail string, newEmail string) error {
@@ -1397,6 +2208,12 @@ func (s *InboundService) UpdateClientIPs(tx *gorm.DB, oldEmail string, newEmail
 }
 
 func (s *InboundService) DelClientStat(tx *gorm.DB, email string) error {
+	if err := tx.Where("email = ?", email).Delete(model.ClientNotice{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("email = ?", email).Delete(model.ClientTrafficDaily{}).Error; err != nil {
+		return err
+	}
 	return tx.Where("email = ?", email).Delete(xray.ClientTraffic{}).Error
 }
 
@@ -1404,57 +2221,210 @@ func (s *InboundService) DelClientIPs(tx *gorm.DB, email string) error {
 	return tx.Where("client_email = ?", email).Delete(model.InboundClientIps{}).Error
 }
 
-func (s *InboundService) GetClientInboundByTrafficID(trafficId int) (traffic *xray.ClientTraffic, inbound *model.Inbound, err error) {
+func (s *InboundService) GetClientInboundByTrafficID(trafficId int) (traffic *xray.ClientTraffic, inbound *model.Inbound, err error) {
+	db := database.GetDB()
+	var traffics []*xray.ClientTraffic
+	err = db.Model(xray.ClientTraffic{}).Where("id = ?", trafficId).Find(&traffics).Error
+	if err != nil {
+		logger.Warningf("Error retrieving ClientTraffic with trafficId %d: %v", trafficId, err)
+		return nil, nil, err
+	}
+	if len(traffics) > 0 {
+		inbound, err = s.GetInbound(traffics[0].InboundId)
+		return traffics[0], inbound, err
+	}
+	return nil, nil, nil
+}
+
+func (s *InboundService) GetClientInboundByEmail(email string) (traffic *xray.ClientTraffic, inbound *model.Inbound, err error) {
+	found, err := clientInboundCache.get(email, func() (clientInbound, error) {
+		db := database.GetDB()
+		var traffics []*xray.ClientTraffic
+		err := db.Model(xray.ClientTraffic{}).Where("email = ?", email).Find(&traffics).Error
+		if err != nil || len(traffics) == 0 {
+			return clientInbound{}, err
+		}
+		inbound, err := s.GetInbound(traffics[0].InboundId)
+		return clientInbound{traffic: traffics[0], inbound: inbound}, err
+	})
+	if err != nil {
+		logger.Warningf("Error retrieving ClientTraffic with email %s: %v", email, err)
+	}
+	if found.traffic == nil {
+		return nil, nil, err
+	}
+	traffic = new(xray.ClientTraffic)
+	*traffic = *found.traffic
+	return traffic, cloneInbound(found.inbound), err
+}
+
+// GetInboundsBySubId returns the enabled inbounds of the protocols served by the subscriptions
+// that have a client with the given subscription ID.
+func (s *InboundService) GetInboundsBySubId(subId string) ([]*model.Inbound, error) {
+	inbounds, err := subInboundsCache.get(subId, func() ([]*model.Inbound, error) {
+		db := database.GetDB()
+		var inbounds []*model.Inbound
+		err := db.Model(model.Inbound{}).
+			Preload("ClientStats").
+			Where("protocol IN ? AND enable = ?", []string{"vmess", "vless", "trojan", "shadowsocks", "hysteria2", "tuic"}, true).
+			Find(&inbounds).Error
+		if err != nil {
+			return nil, err
+		}
+
+		// Filter inbounds that have clients with matching subId
+		var filteredInbounds []*model.Inbound
+		for _, inbound := range inbounds {
+			clients, err := s.GetClients(inbound)
+			if err != nil {
+				continue
+			}
+			for _, client := range clients {
+				if client.SubID == subId {
+					filteredInbounds = append(filteredInbounds, inbound)
+					break
+				}
+			}
+		}
+		return filteredInbounds, nil
+	})
+	return cloneInbounds(inbounds), err
+}
+
+func (s *InboundService) GetClientByEmail(clientEmail string) (*xray.ClientTraffic, *model.Client, error) {
+	traffic, inbound, err := s.GetClientInboundByEmail(clientEmail)
+	if err != nil {
+		return nil, nil, err
+	}
+	if inbound == nil {
+		return nil, nil, common.NewError("Inbound Not Found For Email:", clientEmail)
+	}
+
+	clients, err := s.GetClients(inbound)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, client := range clients {
+		if client.Email == clientEmail {
+			return traffic, &client, nil
+		}
+	}
+
+	return nil, nil, common.NewError("Client Not Found In Inbound For Email:", clientEmail)
+}
+
+// GetClientByStatusToken returns the traffic record and the client owning the given status token.
+func (s *InboundService) GetClientByStatusToken(token string) (*xray.ClientTraffic, *model.Client, error) {
+	if token == "" {
+		return nil, nil, common.NewError("Empty status token")
+	}
 	db := database.GetDB()
-	var traffics []*xray.ClientTraffic
-	err = db.Model(xray.ClientTraffic{}).Where("id = ?", trafficId).Find(&traffics).Error
+	var inbounds []*model.Inbound
+	err := db.Model(model.Inbound{}).Where("settings LIKE ?", "%"+token+"%").Find(&inbounds).Error
 	if err != nil {
-		logger.Warningf("Error retrieving ClientTraffic with trafficId %d: %v", trafficId, err)
 		return nil, nil, err
 	}
-	if len(traffics) > 0 {
-		inbound, err = s.GetInbound(traffics[0].InboundId)
-		return traffics[0], inbound, err
+	for _, inbound := range inbounds {
+		clients, err := s.GetClients(inbound)
+		if err != nil {
+			continue
+		}
+		for _, client := range clients {
+			if client.StatusToken != token {
+				continue
+			}
+			traffic := &xray.ClientTraffic{}
+			err = db.Model(xray.ClientTraffic{}).Where("email = ?", client.Email).First(traffic).Error
+			if err != nil {
+				return nil, nil, err
+			}
+			return traffic, &client, nil
+		}
 	}
-	return nil, nil, nil
+	return nil, nil, common.NewError("Client not found for status token")
 }
 
-func (s *InboundService) GetClientInboundByEmail(email string) (traffic *xray.ClientTraffic, inbound *model.Inbound, err error) {
+// GetClientSpeedLimits returns the speed limits in Mbit/s of the enabled clients that have one, by email.
+func (s *InboundService) GetClientSpeedLimits() (map[string]int, error) {
 	db := database.GetDB()
-	var traffics []*xray.ClientTraffic
-	err = db.Model(xray.ClientTraffic{}).Where("email = ?", email).Find(&traffics).Error
+	var inbounds []*model.Inbound
+	err := db.Model(model.Inbound{}).Where("enable = ? AND settings LIKE ?", true, "%speedLimit%").Find(&inbounds).Error
 	if err != nil {
-		logger.Warningf("Error retrieving ClientTraffic with email %s: %v", email, err)
-		return nil, nil, err
+		return nil, err
 	}
-	if len(traffics) > 0 {
-		inbound, err = s.GetInbound(traffics[0].InboundId)
-		return traffics[0], inbound, err
+	limits := make(map[string]int)
+	for _, inbound := range inbounds {
+		clients, err := s.GetClients(inbound)
+		if err != nil {
+			continue
+		}
+		for _, client := range clients {
+			if client.Enable && client.SpeedLimit > 0 {
+				limits[client.Email] = client.SpeedLimit
+			}
+		}
 	}
-	return nil, nil, nil
+	return limits, nil
 }
 
-func (s *InboundService) GetClientByEmail(clientEmail string) (*xray.ClientTraffic, *model.Client, error) {
+// SetClientSuspended removes an enabled client from the running Xray instance, or adds it back,
+// without changing its stored state. A suspended client is served again after an Xray restart.
+// Returns whether Xray needs restart and any error.
+func (s *InboundService) SetClientSuspended(clientEmail string, suspended bool) (bool, error) {
 	traffic, inbound, err := s.GetClientInboundByEmail(clientEmail)
 	if err != nil {
-		return nil, nil, err
+		return false, err
 	}
-	if inbound == nil {
-		return nil, nil, common.NewError("Inbound Not Found For Email:", clientEmail)
+	if inbound == nil || traffic == nil {
+		return false, common.NewError("Inbound Not Found For Email:", clientEmail)
+	}
+	if !inbound.Enable || !traffic.Enable || p == nil {
+		return false, nil
+	}
+
+	s.xrayApi.Init(getAPIPort())
+	defer s.xrayApi.Close()
+
+	if suspended {
+		err = s.xrayApi.RemoveUser(inbound.Tag, clientEmail)
+		if err != nil && !strings.Contains(err.Error(), fmt.Sprintf("User %s not found.", clientEmail)) {
+			return true, err
+		}
+		return false, nil
 	}
 
 	clients, err := s.GetClients(inbound)
 	if err != nil {
-		return nil, nil, err
+		return false, err
 	}
-
 	for _, client := range clients {
-		if client.Email == clientEmail {
-			return traffic, &client, nil
+		if client.Email != clientEmail || !client.Enable {
+			continue
+		}
+		cipher := ""
+		if inbound.Protocol == model.Shadowsocks {
+			var settings map[string]any
+			err = json.Unmarshal([]byte(inbound.Settings), &settings)
+			if err != nil {
+				return false, err
+			}
+			cipher, _ = settings["method"].(string)
+		}
+		err = s.xrayApi.AddUser(string(inbound.Protocol), inbound.Tag, map[string]any{
+			"email":    client.Email,
+			"id":       client.ID,
+			"security": client.Security,
+			"flow":     client.Flow,
+			"password": client.Password,
+			"cipher":   cipher,
+		})
+		if err != nil && !strings.Contains(err.Error(), "already exists") {
+			return true, err
 		}
+		return false, nil
 	}
-
-	return nil, nil, common.NewError("Client Not Found In Inbound For Email:", clientEmail)
+	return false, nil
 }
 
 func (s *InboundService) SetClientTelegramUserID(trafficId int, tgId int64) (bool, error) {
@@ -1478,7 +2448,7 @@ func (s *InboundService) SetClientTelegramUserID(trafficId int, tgId int64) (boo
 	for _, oldClient := range oldClients {
 		if oldClient.Email == clientEmail {
 			switch inbound.Protocol {
-			case "trojan":
+			case "trojan", "hysteria2":
 				clientId = oldClient.Password
 			case "shadowsocks":
 				clientId = oldClient.Email
@@ -1564,7 +2534,7 @@ func (s *InboundService) ToggleClientEnableByEmail(clientEmail string) (bool, bo
 	for _, oldClient := range oldClients {
 		if oldClient.Email == clientEmail {
 			switch inbound.Protocol {
-			case "trojan":
+			case "trojan", "hysteria2":
 				clientId = oldClient.Password
 			case "shadowsocks":
 				clientId = oldClient.Email
@@ -1645,7 +2615,7 @@ func (s *InboundService) ResetClientIpLimitByEmail(clientEmail string, count int
 	for _, oldClient := range oldClients {
 		if oldClient.Email == clientEmail {
 			switch inbound.Protocol {
-			case "trojan":
+			case "trojan", "hysteria2":
 				clientId = oldClient.Password
 			case "shadowsocks":
 				clientId = oldClient.Email
@@ -1704,7 +2674,7 @@ func (s *InboundService) ResetClientExpiryTimeByEmail(clientEmail string, expiry
 	for _, oldClient := range oldClients {
 		if oldClient.Email == clientEmail {
 			switch inbound.Protocol {
-			case "trojan":
+			case "trojan", "hysteria2":
 				clientId = oldClient.Password
 			case "shadowsocks":
 				clientId = oldClient.Email
@@ -1748,6 +2718,27 @@ func (s *InboundService) ResetClientTrafficLimitByEmail(clientEmail string, tota
 	if totalGB < 0 {
 		return false, common.NewError("totalGB must be >= 0")
 	}
+	return s.setClientTrafficLimitByEmail(clientEmail, int64(totalGB)*1024*1024*1024)
+}
+
+// AddClientTrafficLimitByEmail raises the traffic limit of a client by addGB gigabytes.
+// Clients with unlimited traffic are left unchanged and reported as an error.
+func (s *InboundService) AddClientTrafficLimitByEmail(clientEmail string, addGB int) (bool, error) {
+	if addGB <= 0 {
+		return false, common.NewError("addGB must be > 0")
+	}
+	_, client, err := s.GetClientByEmail(clientEmail)
+	if err != nil {
+		return false, err
+	}
+	if client.TotalGB == 0 {
+		return false, common.NewError("Traffic of client is unlimited:", clientEmail)
+	}
+	return s.setClientTrafficLimitByEmail(clientEmail, client.TotalGB+int64(addGB)*1024*1024*1024)
+}
+
+// setClientTrafficLimitByEmail sets the traffic limit of a client in bytes.
+func (s *InboundService) setClientTrafficLimitByEmail(clientEmail string, totalBytes int64) (bool, error) {
 	_, inbound, err := s.GetClientInboundByEmail(clientEmail)
 	if err != nil {
 		return false, err
@@ -1766,7 +2757,7 @@ func (s *InboundService) ResetClientTrafficLimitByEmail(clientEmail string, tota
 	for _, oldClient := range oldClients {
 		if oldClient.Email == clientEmail {
 			switch inbound.Protocol {
-			case "trojan":
+			case "trojan", "hysteria2":
 				clientId = oldClient.Password
 			case "shadowsocks":
 				clientId = oldClient.Email
@@ -1791,7 +2782,7 @@ func (s *InboundService) ResetClientTrafficLimitByEmail(clientEmail string, tota
 	for client_index := range clients {
 		c := clients[client_index].(map[string]any)
 		if c["email"] == clientEmail {
-			c["totalGB"] = totalGB * 1024 * 1024 * 1024
+			c["totalGB"] = totalBytes
 			c["updated_at"] = time.Now().Unix() * 1000
 			newClients = append(newClients, any(c))
 		}
@@ -1841,7 +2832,7 @@ func (s *InboundService) ResetClientTraffic(id int, clientEmail string) (bool, e
 		}
 		for _, client := range clients {
 			if client.Email == clientEmail && client.Enable {
-				s.xrayApi.Init(p.GetAPIPort())
+				s.xrayApi.Init(getAPIPort())
 				cipher := ""
 				if string(inbound.Protocol) == "shadowsocks" {
 					var oldSettings map[string]any
@@ -1884,11 +2875,83 @@ func (s *InboundService) ResetClientTraffic(id int, clientEmail string) (bool, e
 	return needRestart, nil
 }
 
-func (s *InboundService) ResetAllClientTraffics(id int) error {
+// ResetScheduledClientTraffics resets the traffic of clients whose monthly or interval reset is due.
+// A client without a previous reset starts its schedule now instead of being reset right away.
+// Returns whether Xray needs restart, the number of reset clients, and any error.
+func (s *InboundService) ResetScheduledClientTraffics() (bool, int, error) {
+	inbounds, err := s.GetAllInbounds()
+	if err != nil {
+		return false, 0, err
+	}
+
+	loc, err := s.settingService.GetTrafficResetTimeLocation()
+	if err != nil {
+		loc = time.Local
+	}
+	db := database.GetDB()
+	now := time.Now().In(loc)
+	needRestart := false
+	count := 0
+	for _, inbound := range inbounds {
+		clients, err := s.GetClients(inbound)
+		if err != nil {
+			continue
+		}
+		for _, client := range clients {
+			if client.Email == "" || client.ResetValue <= 0 || (client.ResetPolicy != "monthly" && client.ResetPolicy != "interval") {
+				continue
+			}
+			var lastResetTime int64
+			err = db.Model(xray.ClientTraffic{}).Select("last_reset_time").Where("email = ?", client.Email).Scan(&lastResetTime).Error
+			if err != nil {
+				logger.Warning("Failed to get last traffic reset of client", client.Email, ":", err)
+				continue
+			}
+			if lastResetTime == 0 {
+				db.Model(xray.ClientTraffic{}).Where("email = ?", client.Email).Update("last_reset_time", now.UnixMilli())
+				continue
+			}
+			if !isClientResetDue(client.ResetPolicy, client.ResetValue, time.UnixMilli(lastResetTime), now) {
+				continue
+			}
+
+			restart, err := s.ResetClientTraffic(inbound.Id, client.Email)
+			if err != nil {
+				logger.Warning("Failed to reset traffic of client", client.Email, ":", err)
+				continue
+			}
+			needRestart = needRestart || restart
+			db.Model(xray.ClientTraffic{}).Where("email = ?", client.Email).Update("last_reset_time", now.UnixMilli())
+			count++
+		}
+	}
+	return needRestart, count, nil
+}
+
+// isClientResetDue reports whether a scheduled client traffic reset has to run.
+// For the monthly policy value is the day of the month, clamped to the last day of short months.
+// For the interval policy value is the number of days between resets.
+func isClientResetDue(policy string, value int, lastReset time.Time, now time.Time) bool {
+	switch policy {
+	case "monthly":
+		lastDay := time.Date(now.Year(), now.Month()+1, 0, 0, 0, 0, 0, now.Location()).Day()
+		resetAt := time.Date(now.Year(), now.Month(), min(value, lastDay), 0, 0, 0, 0, now.Location())
+		return !now.Before(resetAt) && lastReset.Before(resetAt)
+	case "interval":
+		return now.Sub(lastReset) >= time.Duration(value)*24*time.Hour
+	}
+	return false
+}
+
+// ResetAllClientTraffics resets the traffic of all clients of an inbound, or of all inbounds for id -1,
+// and enables the clients again. Returns whether clients were enabled again, which needs the Xray
+// config to be reapplied, and any error.
+func (s *InboundService) ResetAllClientTraffics(id int) (bool, error) {
 	db := database.GetDB()
 	now := time.Now().Unix() * 1000
+	needRestart := false
 
-	return db.Transaction(func(tx *gorm.DB) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
 		whereText := "inbound_id "
 		if id == -1 {
 			whereText += " > ?"
@@ -1896,6 +2959,15 @@ func (s *InboundService) ResetAllClientTraffics(id int) error {
 			whereText += " = ?"
 		}
 
+		var disabled int64
+		err := tx.Model(xray.ClientTraffic{}).
+			Where(whereText+" AND enable = ?", id, false).
+			Count(&disabled).Error
+		if err != nil {
+			return err
+		}
+		needRestart = disabled > 0
+
 		// Reset client traffics
 		result := tx.Model(xray.ClientTraffic{}).
 			Where(whereText, id).
@@ -1919,6 +2991,7 @@ func (s *InboundService) ResetAllClientTraffics(id int) error {
 
 		return result.Error
 	})
+	return needRestart, err
 }
 
 func (s *InboundService) ResetAllTraffics() error {
@@ -1932,6 +3005,257 @@ func (s *InboundService) ResetAllTraffics() error {
 	return err
 }
 
+// ResetInboundTraffic resets the traffic counters of a single inbound.
+// An inbound that was disabled for reaching its total traffic limit is enabled again
+// and added back to the running Xray instance.
+// Returns whether Xray needs restart and any error.
+func (s *InboundService) ResetInboundTraffic(id int) (bool, error) {
+	inbound, err := s.GetInbound(id)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now().Unix() * 1000
+	depleted := !inbound.Enable && inbound.Total > 0 && inbound.Up+inbound.Down >= inbound.Total
+	expired := inbound.ExpiryTime > 0 && inbound.ExpiryTime <= now
+
+	updates := map[string]any{"up": 0, "down": 0}
+	reEnable := depleted && !expired
+	if reEnable {
+		updates["enable"] = true
+	}
+
+	db := database.GetDB()
+	err = db.Model(model.Inbound{}).Where("id = ?", id).Updates(updates).Error
+	if err != nil {
+		return false, err
+	}
+
+	needRestart := false
+	if reEnable && p != nil {
+		inbound.Enable = true
+		s.xrayApi.Init(getAPIPort())
+		inboundJson, err1 := json.MarshalIndent(inbound.GenXrayInboundConfig(), "", "  ")
+		if err1 != nil {
+			logger.Debug("Unable to marshal inbound config:", err1)
+			needRestart = true
+		} else {
+			err1 = s.xrayApi.AddInbound(inboundJson)
+			if err1 == nil {
+				logger.Debug("Inbound enabled by api:", inbound.Tag)
+			} else {
+				logger.Debug("Unable to enable inbound by api:", err1)
+				needRestart = true
+			}
+		}
+		s.xrayApi.Close()
+	}
+
+	return needRestart, nil
+}
+
+// RotateRealityKeys replaces the REALITY key pair of an inbound with a newly generated one.
+// Share links and subscriptions are built from the stored settings, so they advertise the new
+// public key right away. For the configured grace period the previous keys and short IDs keep
+// working through a grace inbound on a loopback port and stay listed in subscriptions, so clients
+// have time to refresh their subscription before RetireRealityKeys drops them.
+// Returns the updated inbound, whether Xray needs restart, and any error.
+func (s *InboundService) RotateRealityKeys(id int) (*model.Inbound, bool, error) {
+	inbound, err := s.GetInbound(id)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var stream map[string]any
+	err = json.Unmarshal([]byte(inbound.StreamSettings), &stream)
+	if err != nil {
+		return nil, false, err
+	}
+	realitySettings, ok := stream["realitySettings"].(map[string]any)
+	if !ok || stream["security"] != "reality" {
+		return nil, false, common.NewError("Inbound does not use REALITY:", inbound.Remark)
+	}
+	graceHours, err := s.settingService.GetRealityKeyGrace()
+	if err != nil {
+		return nil, false, err
+	}
+
+	privateKey, publicKey, err := generateX25519KeyPair()
+	if err != nil {
+		return nil, false, err
+	}
+	settings, _ := realitySettings["settings"].(map[string]any)
+	if settings == nil {
+		settings = map[string]any{}
+	}
+	delete(settings, "previousKeys")
+	if oldPrivateKey, _ := realitySettings["privateKey"].(string); graceHours > 0 && oldPrivateKey != "" {
+		previous := model.RealityPreviousKeys{
+			PrivateKey: oldPrivateKey,
+			Until:      time.Now().Add(time.Duration(graceHours) * time.Hour).UnixMilli(),
+		}
+		previous.PublicKey, _ = settings["publicKey"].(string)
+		shortIds, _ := realitySettings["shortIds"].([]any)
+		for _, shortId := range shortIds {
+			if shortId, ok := shortId.(string); ok {
+				previous.ShortIds = append(previous.ShortIds, shortId)
+			}
+		}
+		if current := inbound.GetRealityPreviousKeys(); current != nil {
+			previous.Port = current.Port
+		} else if previous.Port, err = s.GetFreePort("127.0.0.1"); err != nil {
+			return nil, false, err
+		}
+		settings["previousKeys"] = previous
+	}
+	realitySettings["privateKey"] = privateKey
+	settings["publicKey"] = publicKey
+	settings["keyRotatedAt"] = time.Now().Unix() * 1000
+	realitySettings["settings"] = settings
+	stream["realitySettings"] = realitySettings
+
+	newStream, err := json.MarshalIndent(stream, "", "  ")
+	if err != nil {
+		return nil, false, err
+	}
+	inbound.StreamSettings = string(newStream)
+
+	db := database.GetDB()
+	err = db.Model(model.Inbound{}).Where("id = ?", id).Update("stream_settings", inbound.StreamSettings).Error
+	if err != nil {
+		return nil, false, err
+	}
+
+	// The inbound is replaced, and its grace inbound added, when the new config is applied
+	return inbound, inbound.Enable, nil
+}
+
+// RetireRealityKeys drops the previous REALITY keys of the inbounds whose grace period is over,
+// which removes their grace inbounds. Returns whether Xray needs restart.
+func (s *InboundService) RetireRealityKeys() (bool, error) {
+	inbounds, err := s.GetAllInbounds()
+	if err != nil {
+		return false, err
+	}
+
+	db := database.GetDB()
+	now := time.Now().UnixMilli()
+	needRestart := false
+	for _, inbound := range inbounds {
+		previous := inbound.GetRealityPreviousKeys()
+		if previous == nil || previous.Until > now {
+			continue
+		}
+		var stream map[string]any
+		if err := json.Unmarshal([]byte(inbound.StreamSettings), &stream); err != nil {
+			return needRestart, err
+		}
+		realitySettings, _ := stream["realitySettings"].(map[string]any)
+		settings, _ := realitySettings["settings"].(map[string]any)
+		delete(settings, "previousKeys")
+		newStream, err := json.MarshalIndent(stream, "", "  ")
+		if err != nil {
+			return needRestart, err
+		}
+		err = db.Model(model.Inbound{}).Where("id = ?", inbound.Id).Update("stream_settings", string(newStream)).Error
+		if err != nil {
+			return needRestart, err
+		}
+		logger.Infof("Previous REALITY keys of inbound %s retired", inbound.Remark)
+		needRestart = needRestart || inbound.Enable
+	}
+	return needRestart, nil
+}
+
+// GetRealityKeyRotatedAt returns when the REALITY keys of an inbound were last rotated (0 if never)
+// and whether the inbound uses REALITY at all.
+func (s *InboundService) GetRealityKeyRotatedAt(inbound *model.Inbound) (int64, bool) {
+	var stream map[string]any
+	if err := json.Unmarshal([]byte(inbound.StreamSettings), &stream); err != nil || stream["security"] != "reality" {
+		return 0, false
+	}
+	realitySettings, _ := stream["realitySettings"].(map[string]any)
+	settings, _ := realitySettings["settings"].(map[string]any)
+	rotatedAt, _ := settings["keyRotatedAt"].(float64)
+	return int64(rotatedAt), true
+}
+
+// GetInboundConnections returns the number of established TCP connections accepted by each inbound,
+// keyed by inbound ID. Connections on the extra ports of a port range are included, and an inbound
+// bound to an address only counts the connections to that address. UDP traffic has no connection
+// state and is not counted.
+func (s *InboundService) GetInboundConnections(inbounds []*model.Inbound) (map[int]int, error) {
+	addrCounts, err := sys.GetEstablishedTCPCountByAddr()
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[int]int, len(inbounds))
+	for _, inbound := range inbounds {
+		if isSocketListen(inbound.Listen) {
+			counts[inbound.Id] = 0
+			continue
+		}
+		var listen netip.Addr
+		if !isAnyListen(inbound.Listen) {
+			normalized, err := NormalizeListen(inbound.Listen)
+			if err != nil {
+				counts[inbound.Id] = 0
+				continue
+			}
+			listen, _ = netip.ParseAddr(normalized)
+		}
+		ports := map[int]bool{inbound.Port: true}
+		ranges, _ := parsePortRange(inbound.PortRange)
+		for _, r := range ranges {
+			for port := r[0]; port <= r[1]; port++ {
+				ports[port] = true
+			}
+		}
+		count := 0
+		for addr, n := range addrCounts {
+			if ports[int(addr.Port())] && (!listen.IsValid() || addr.Addr() == listen) {
+				count += n
+			}
+		}
+		counts[inbound.Id] = count
+	}
+	return counts, nil
+}
+
+// SetInboundPaused removes an enabled inbound from the running Xray instance, or adds it back,
+// without changing its stored state. Removing the inbound closes its listener so no new
+// connections are accepted, while connections that are already established keep working.
+func (s *InboundService) SetInboundPaused(inbound *model.Inbound, paused bool) error {
+	err := s.xrayApi.Init(getAPIPort())
+	if err != nil {
+		return err
+	}
+	defer s.xrayApi.Close()
+	if paused {
+		return s.xrayApi.DelInbound(inbound.Tag)
+	}
+	// The inbound may have been added back already by an update
+	_ = s.xrayApi.DelInbound(inbound.Tag)
+	inboundJson, err := json.MarshalIndent(inbound.GenXrayInboundConfig(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return s.xrayApi.AddInbound(inboundJson)
+}
+
+// GetDepletedInbounds returns the inbounds that were disabled for reaching their total traffic limit.
+func (s *InboundService) GetDepletedInbounds() ([]*model.Inbound, error) {
+	db := database.GetDB()
+	var inbounds []*model.Inbound
+	err := db.Model(model.Inbound{}).
+		Where("total > 0 and up + down >= total and enable = ?", false).
+		Find(&inbounds).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	return inbounds, nil
+}
+
 func (s *InboundService) DelDepletedClients(id int) (err error) {
 	db := database.GetDB()
 	tx := db.Begin()
@@ -1998,6 +3322,10 @@ func (s *InboundService) DelDepletedClients(id int) (err error) {
 			}
 
 			oldInbound.Settings = string(newSettings)
+			err = s.snapshotInbound(tx, oldInbound.Id)
+			if err != nil {
+				return err
+			}
 			err = tx.Save(oldInbound).Error
 			if err != nil {
 				return err
@@ -2017,6 +3345,40 @@ func (s *InboundService) DelDepletedClients(id int) (err error) {
 	return nil
 }
 
+// DelExpiredClients deletes the clients whose expiry passed more than graceDays ago. Expired
+// clients are only disabled by the traffic job, so they keep their stats and can be renewed
+// during the grace period. Clients with auto-renew are never deleted.
+// Returns whether Xray needs restart and the number of deleted clients.
+func (s *InboundService) DelExpiredClients(graceDays int) (bool, int, error) {
+	if graceDays <= 0 {
+		return false, 0, nil
+	}
+	deadline := time.Now().Add(-time.Duration(graceDays) * 24 * time.Hour).UnixMilli()
+
+	db := database.GetDB()
+	var traffics []*xray.ClientTraffic
+	err := db.Model(xray.ClientTraffic{}).
+		Where("reset = 0 and expiry_time > 0 and expiry_time <= ?", deadline).
+		Find(&traffics).Error
+	if err != nil {
+		return false, 0, err
+	}
+
+	needRestart := false
+	count := 0
+	for _, traffic := range traffics {
+		restart, err := s.DelInboundClientByEmail(traffic.InboundId, traffic.Email)
+		if err != nil {
+			logger.Warning("Failed to delete expired client", traffic.Email, ":", err)
+			continue
+		}
+		logger.Infof("Expired client %s deleted after the grace period", traffic.Email)
+		needRestart = needRestart || restart
+		count++
+	}
+	return needRestart, count, nil
+}
+
 func (s *InboundService) GetClientTrafficTgBot(tgId int64) ([]*xray.ClientTraffic, error) {
 	db := database.GetDB()
 	var inbounds []*model.Inbound
@@ -2388,8 +3750,9 @@ func (s *InboundService) MigrateDB() {
 	s.MigrationRemoveOrphanedTraffics()
 }
 
+// GetOnlineClients returns the clients online on this server or on any of its nodes.
 func (s *InboundService) GetOnlineClients() []string {
-	return p.GetOnlineClients()
+	return getOnlineClients()
 }
 
 func (s *InboundService) GetClientsLastOnline() (map[string]int64, error) {
@@ -2490,9 +3853,22 @@ func (s *InboundService) DelInboundClientByEmail(inboundId int, email string) (b
 	oldInbound.Settings = string(newSettings)
 
 	db := database.GetDB()
+	tx := db.Begin()
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	if err = s.snapshotInbound(tx, inboundId); err != nil {
+		return false, err
+	}
 
 	// remove IP bindings
-	if err := s.DelClientIPs(db, email); err != nil {
+	if err = s.DelClientIPs(tx, email); err != nil {
 		logger.Error("Error in delete client IPs")
 		return false, err
 	}
@@ -2501,19 +3877,20 @@ func (s *InboundService) DelInboundClientByEmail(inboundId int, email string) (b
 
 	// remove stats too
 	if len(email) > 0 {
-		traffic, err := s.GetClientTrafficByEmail(email)
+		var traffic *xray.ClientTraffic
+		traffic, err = s.GetClientTrafficByEmail(email)
 		if err != nil {
 			return false, err
 		}
 		if traffic != nil {
-			if err := s.DelClientStat(db, email); err != nil {
+			if err = s.DelClientStat(tx, email); err != nil {
 				logger.Error("Delete stats Data Error")
 				return false, err
 			}
 		}
 
 		if needApiDel {
-			s.xrayApi.Init(p.GetAPIPort())
+			s.xrayApi.Init(getAPIPort())
 			if err1 := s.xrayApi.RemoveUser(oldInbound.Tag, email); err1 == nil {
 				logger.Debug("Client deleted by api:", email)
 				needRestart = false
@@ -2529,5 +3906,6 @@ func (s *InboundService) DelInboundClientByEmail(inboundId int, email string) (b
 		}
 	}
 
-	return needRestart, db.Save(oldInbound).Error
+	err = tx.Save(oldInbound).Error
+	return needRestart, err
 }
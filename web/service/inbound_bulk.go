@@ -0,0 +1,256 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// Bulk client actions supported by BulkUpdateClients.
+const (
+	BulkExtendExpiry  = "extendExpiry"
+	BulkAddTraffic    = "addTraffic"
+	BulkResetTraffic  = "resetTraffic"
+	BulkEnable        = "enable"
+	BulkDisable       = "disable"
+	BulkRegenerate    = "regenerate"
+	bulkDayMillis     = int64(86400000)
+	bulkGigabyteBytes = int64(1073741824)
+)
+
+// bulkApiChange is a pending change of a client in Xray, applied after the database commit.
+type bulkApiChange struct {
+	inbound *model.Inbound
+	client  map[string]any
+	remove  bool
+	add     bool
+}
+
+// BulkUpdateClients applies one action to the clients with the given emails across all inbounds.
+// value is the number of days for BulkExtendExpiry and of GB for BulkAddTraffic.
+// All changes are saved in one transaction and applied to Xray in one API session afterwards.
+// Returns whether Xray needs restart and the number of updated clients.
+func (s *InboundService) BulkUpdateClients(emails []string, action string, value int) (bool, int, error) {
+	switch action {
+	case BulkExtendExpiry, BulkAddTraffic:
+		if value <= 0 {
+			return false, 0, common.NewError("Invalid value for", action, ":", value)
+		}
+	case BulkResetTraffic, BulkEnable, BulkDisable, BulkRegenerate:
+	default:
+		return false, 0, common.NewError("Unknown bulk action:", action)
+	}
+	if len(emails) == 0 {
+		return false, 0, common.NewError("No clients selected")
+	}
+	selected := make(map[string]bool, len(emails))
+	for _, email := range emails {
+		selected[email] = true
+	}
+
+	inbounds, err := s.GetAllInbounds()
+	if err != nil {
+		return false, 0, err
+	}
+
+	db := database.GetDB()
+	tx := db.Begin()
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	now := time.Now().UnixMilli()
+	var changes []bulkApiChange
+	count := 0
+	for _, inbound := range inbounds {
+		var settings map[string]any
+		if json.Unmarshal([]byte(inbound.Settings), &settings) != nil {
+			continue
+		}
+		clients, _ := settings["clients"].([]any)
+		method, _ := settings["method"].(string)
+		changed := false
+		for _, client := range clients {
+			c, ok := client.(map[string]any)
+			email, _ := c["email"].(string)
+			if !ok || !selected[email] {
+				continue
+			}
+			delete(selected, email)
+
+			var traffic *xray.ClientTraffic
+			for i := range inbound.ClientStats {
+				if inbound.ClientStats[i].Email == email {
+					traffic = &inbound.ClientStats[i]
+					break
+				}
+			}
+			if traffic == nil {
+				traffic = &xray.ClientTraffic{InboundId: inbound.Id, Email: email, Enable: true}
+			}
+			enable, _ := c["enable"].(bool)
+			wasActive := enable && traffic.Enable
+
+			switch action {
+			case BulkExtendExpiry:
+				expiryTime, _ := c["expiryTime"].(float64)
+				newExpiry := int64(expiryTime)
+				switch {
+				case newExpiry > now:
+					newExpiry += int64(value) * bulkDayMillis
+				case newExpiry > 0:
+					newExpiry = now + int64(value)*bulkDayMillis
+				case newExpiry < 0:
+					// Not started yet, the expiry holds the duration
+					newExpiry -= int64(value) * bulkDayMillis
+				}
+				c["expiryTime"] = newExpiry
+				traffic.ExpiryTime = newExpiry
+			case BulkAddTraffic:
+				totalGB, _ := c["totalGB"].(float64)
+				if totalGB > 0 {
+					c["totalGB"] = int64(totalGB) + int64(value)*bulkGigabyteBytes
+					traffic.Total = int64(totalGB) + int64(value)*bulkGigabyteBytes
+				}
+			case BulkResetTraffic:
+				traffic.Up = 0
+				traffic.Down = 0
+			case BulkEnable, BulkDisable:
+				enable = action == BulkEnable
+				c["enable"] = enable
+			case BulkRegenerate:
+				regenerateClientCredential(c, inbound.Protocol, method)
+			}
+			c["updated_at"] = now
+
+			// The traffic row follows the client state, limited clients stay disabled until renewed
+			withinLimits := (traffic.ExpiryTime <= 0 || traffic.ExpiryTime > now) &&
+				(traffic.Total <= 0 || traffic.Up+traffic.Down < traffic.Total)
+			traffic.Enable = enable && withinLimits
+			if err = tx.Save(traffic).Error; err != nil {
+				return false, 0, err
+			}
+
+			isActive := enable && traffic.Enable
+			if inbound.Enable {
+				change := bulkApiChange{inbound: inbound, client: c}
+				change.remove = wasActive && (!isActive || action == BulkRegenerate)
+				change.add = isActive && (!wasActive || action == BulkRegenerate)
+				if change.remove || change.add {
+					changes = append(changes, change)
+				}
+			}
+			changed = true
+			count++
+		}
+		if !changed {
+			continue
+		}
+		newSettings, err1 := json.MarshalIndent(settings, "", "  ")
+		if err1 != nil {
+			err = err1
+			return false, 0, err
+		}
+		err = s.snapshotInbound(tx, inbound.Id)
+		if err != nil {
+			return false, 0, err
+		}
+		err = tx.Model(model.Inbound{}).Where("id = ?", inbound.Id).Update("settings", string(newSettings)).Error
+		if err != nil {
+			return false, 0, err
+		}
+	}
+	if len(selected) > 0 {
+		missing := make([]string, 0, len(selected))
+		for email := range selected {
+			missing = append(missing, email)
+		}
+		err = common.NewError("Clients not found:", strings.Join(missing, ", "))
+		return false, 0, err
+	}
+
+	return s.applyBulkChanges(changes), count, nil
+}
+
+// applyBulkChanges removes and adds the changed clients through the Xray API in one session.
+// Returns whether a change failed and Xray needs restart.
+func (s *InboundService) applyBulkChanges(changes []bulkApiChange) bool {
+	if len(changes) == 0 || p == nil || !p.IsRunning() {
+		return len(changes) > 0
+	}
+	needRestart := false
+	s.xrayApi.Init(getAPIPort())
+	defer s.xrayApi.Close()
+	for _, change := range changes {
+		email, _ := change.client["email"].(string)
+		if change.remove {
+			err := s.xrayApi.RemoveUser(change.inbound.Tag, email)
+			if err == nil {
+				logger.Debug("Client deleted by api:", email)
+			} else if !strings.Contains(err.Error(), fmt.Sprintf("User %s not found.", email)) {
+				logger.Debug("Error in deleting client by api:", err)
+				needRestart = true
+			}
+		}
+		if change.add {
+			var settings map[string]any
+			_ = json.Unmarshal([]byte(change.inbound.Settings), &settings)
+			cipher, _ := settings["method"].(string)
+			id, _ := change.client["id"].(string)
+			security, _ := change.client["security"].(string)
+			flow, _ := change.client["flow"].(string)
+			password, _ := change.client["password"].(string)
+			err := s.xrayApi.AddUser(string(change.inbound.Protocol), change.inbound.Tag, map[string]any{
+				"email":    email,
+				"id":       id,
+				"security": security,
+				"flow":     flow,
+				"password": password,
+				"cipher":   cipher,
+			})
+			if err == nil {
+				logger.Debug("Client added by api:", email)
+			} else {
+				logger.Debug("Error in adding client by api:", err)
+				needRestart = true
+			}
+		}
+	}
+	return needRestart
+}
+
+// regenerateClientCredential replaces the ID or password of a client with a new random one.
+func regenerateClientCredential(client map[string]any, protocol model.Protocol, method string) {
+	switch protocol {
+	case model.VMESS, model.VLESS:
+		client["id"] = uuid.NewString()
+	case model.Trojan, model.Hysteria2:
+		client["password"] = random.Seq(10)
+	case model.TUIC:
+		client["id"] = uuid.NewString()
+		client["password"] = random.Seq(10)
+	case model.Shadowsocks:
+		size := 32
+		if method == "2022-blake3-aes-128-gcm" {
+			size = 16
+		}
+		key := make([]byte, size)
+		_, _ = rand.Read(key)
+		client["password"] = base64.StdEncoding.EncodeToString(key)
+	}
+}
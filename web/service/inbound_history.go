@@ -0,0 +1,177 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+
+	"gorm.io/gorm"
+)
+
+// inboundHistoryLimit is the number of snapshots kept for each inbound.
+const inboundHistoryLimit = 20
+
+// inboundHistoryIgnoredFields are inbound fields that change on their own and are not part of the configuration.
+var inboundHistoryIgnoredFields = map[string]bool{
+	"id":                   true,
+	"up":                   true,
+	"down":                 true,
+	"allTime":              true,
+	"lastTrafficResetTime": true,
+	"clientStats":          true,
+}
+
+// InboundFieldDiff describes a configuration field that differs between two inbound versions.
+type InboundFieldDiff struct {
+	Field string `json:"field"`
+	Old   any    `json:"old"`
+	New   any    `json:"new"`
+}
+
+// saveInboundHistory stores a snapshot of the inbound and drops the oldest snapshots above the limit.
+func (s *InboundService) saveInboundHistory(tx *gorm.DB, inbound *model.Inbound) error {
+	snapshot := *inbound
+	snapshot.ClientStats = nil
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	err = tx.Create(&model.InboundHistory{
+		InboundId: inbound.Id,
+		CreatedAt: time.Now().UnixMilli(),
+		Snapshot:  string(data),
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	var staleIds []int
+	err = tx.Model(model.InboundHistory{}).
+		Where("inbound_id = ?", inbound.Id).
+		Order("id desc").
+		Offset(inboundHistoryLimit).
+		Pluck("id", &staleIds).Error
+	if err != nil || len(staleIds) == 0 {
+		return err
+	}
+	return tx.Where("id in ?", staleIds).Delete(model.InboundHistory{}).Error
+}
+
+// snapshotInbound stores the inbound as it is before tx changes it in its history.
+func (s *InboundService) snapshotInbound(tx *gorm.DB, inboundId int) error {
+	inbound := &model.Inbound{}
+	err := tx.Model(model.Inbound{}).Where("id = ?", inboundId).First(inbound).Error
+	if err != nil {
+		return err
+	}
+	return s.saveInboundHistory(tx, inbound)
+}
+
+// GetInboundHistory returns the stored snapshots of an inbound, newest first.
+func (s *InboundService) GetInboundHistory(inboundId int) ([]*model.InboundHistory, error) {
+	db := database.GetDB()
+	var history []*model.InboundHistory
+	err := db.Model(model.InboundHistory{}).Where("inbound_id = ?", inboundId).Order("id desc").Find(&history).Error
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// getInboundVersion returns the inbound as stored in a snapshot, or the current inbound for version 0.
+func (s *InboundService) getInboundVersion(inboundId int, versionId int) (*model.Inbound, error) {
+	if versionId == 0 {
+		return s.GetInbound(inboundId)
+	}
+	db := database.GetDB()
+	history := &model.InboundHistory{}
+	err := db.Model(model.InboundHistory{}).Where("id = ? and inbound_id = ?", versionId, inboundId).First(history).Error
+	if err != nil {
+		return nil, err
+	}
+	inbound := &model.Inbound{}
+	err = json.Unmarshal([]byte(history.Snapshot), inbound)
+	if err != nil {
+		return nil, err
+	}
+	return inbound, nil
+}
+
+// DiffInboundVersions compares two versions of an inbound and returns the configuration fields that differ.
+// Version 0 refers to the current configuration.
+func (s *InboundService) DiffInboundVersions(inboundId int, fromVersion int, toVersion int) ([]InboundFieldDiff, error) {
+	from, err := s.getInboundVersion(inboundId, fromVersion)
+	if err != nil {
+		return nil, err
+	}
+	to, err := s.getInboundVersion(inboundId, toVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	fromFields, err := inboundFields(from)
+	if err != nil {
+		return nil, err
+	}
+	toFields, err := inboundFields(to)
+	if err != nil {
+		return nil, err
+	}
+
+	diffs := make([]InboundFieldDiff, 0)
+	for field, oldValue := range fromFields {
+		if newValue := toFields[field]; !reflect.DeepEqual(oldValue, newValue) {
+			diffs = append(diffs, InboundFieldDiff{Field: field, Old: oldValue, New: newValue})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Field < diffs[j].Field
+	})
+	return diffs, nil
+}
+
+// inboundFields returns the configuration fields of an inbound keyed by their JSON name.
+func inboundFields(inbound *model.Inbound) (map[string]any, error) {
+	data, err := json.Marshal(inbound)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, err
+	}
+	for field := range inboundHistoryIgnoredFields {
+		delete(fields, field)
+	}
+	return fields, nil
+}
+
+// RollbackInbound restores the configuration of an inbound from a snapshot.
+// Traffic counters are kept, and the configuration being replaced is stored as a new snapshot
+// so the rollback itself can be undone.
+// Returns the updated inbound, whether Xray needs restart, and any error.
+func (s *InboundService) RollbackInbound(inboundId int, versionId int) (*model.Inbound, bool, error) {
+	if versionId == 0 {
+		return nil, false, common.NewError("No version selected")
+	}
+	current, err := s.GetInbound(inboundId)
+	if err != nil {
+		return nil, false, err
+	}
+	inbound, err := s.getInboundVersion(inboundId, versionId)
+	if err != nil {
+		return nil, false, err
+	}
+	inbound.Id = current.Id
+	inbound.Up = current.Up
+	inbound.Down = current.Down
+	inbound.AllTime = current.AllTime
+	inbound.LastTrafficResetTime = current.LastTrafficResetTime
+	return s.UpdateInbound(inbound)
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+)
+
+// LogLevels is the console log level of the panel and that of each of its modules.
+type LogLevels struct {
+	Level   string            `json:"level"`
+	Modules map[string]string `json:"modules"`
+}
+
+// LogLevelService sets the console log level of the modules of the panel, such as the web
+// server or the Telegram bot, at runtime. The levels set are saved and applied again at start.
+type LogLevelService struct {
+	settingService SettingService
+}
+
+// GetLogLevels returns the default console log level and the level of every module.
+func (s *LogLevelService) GetLogLevels() *LogLevels {
+	return &LogLevels{Level: logger.GetLevel(), Modules: logger.GetModuleLevels()}
+}
+
+// SetLogLevel sets the console log level of a module and saves it. An empty level resets the
+// module to the default level.
+func (s *LogLevelService) SetLogLevel(module, level string) error {
+	level = strings.ToLower(strings.TrimSpace(level))
+	if err := logger.SetModuleLevel(module, level); err != nil {
+		return err
+	}
+	saved, err := s.savedLevels()
+	if err != nil {
+		return err
+	}
+	if level == "" {
+		delete(saved, module)
+	} else {
+		saved[module] = level
+	}
+	var pairs []string
+	for _, module := range logger.Modules {
+		if saved[module] != "" {
+			pairs = append(pairs, module+"="+saved[module])
+		}
+	}
+	return s.settingService.SetLogModuleLevels(strings.Join(pairs, ","))
+}
+
+// ApplyLogLevels sets the saved console log levels of the modules.
+func (s *LogLevelService) ApplyLogLevels() error {
+	saved, err := s.savedLevels()
+	if err != nil {
+		return err
+	}
+	for module, level := range saved {
+		if err := logger.SetModuleLevel(module, level); err != nil {
+			logger.Warning("Log level", level, "of module", module, "ignored:", err)
+		}
+	}
+	return nil
+}
+
+// savedLevels returns the saved levels of the modules, from "module=level" pairs separated by
+// commas. The pairs of modules that no longer exist are dropped.
+func (s *LogLevelService) savedLevels() (map[string]string, error) {
+	value, err := s.settingService.GetLogModuleLevels()
+	if err != nil {
+		return nil, err
+	}
+	saved := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		module, level, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && slices.Contains(logger.Modules, module) && level != "" {
+			saved[module] = level
+		}
+	}
+	return saved, nil
+}
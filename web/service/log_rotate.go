@@ -0,0 +1,173 @@
+package service
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// logArchiveTimeFormat is the time a log was rotated at in the name of its archive.
+const logArchiveTimeFormat = "20060102-150405"
+
+// logRotateSeen is when the logs without archives were first checked, their age is counted
+// from then.
+var logRotateSeen struct {
+	sync.Mutex
+	times map[string]time.Time
+}
+
+// LogRotateService rotates the logs of the panel and the access and error logs of Xray when they
+// exceed a size or an age, so that they do not fill the disk without logrotate. A log is copied
+// to a timestamped archive, compressed if enabled, then truncated in place: the panel and Xray
+// append to it, so they keep writing without being reopened.
+type LogRotateService struct {
+	settingService SettingService
+}
+
+// RotateLogs rotates the logs that are due and deletes their oldest archives beyond the
+// configured number.
+func (s *LogRotateService) RotateLogs() error {
+	sizeMB, err := s.settingService.GetLogRotateSize()
+	if err != nil {
+		return err
+	}
+	days, err := s.settingService.GetLogRotateDays()
+	if err != nil {
+		return err
+	}
+	keep, err := s.settingService.GetLogRotateKeep()
+	if err != nil {
+		return err
+	}
+	compress, err := s.settingService.GetLogRotateCompress()
+	if err != nil {
+		return err
+	}
+	if sizeMB <= 0 && days <= 0 {
+		return nil
+	}
+	maxSize := int64(sizeMB) << 20
+	maxAge := time.Duration(days) * 24 * time.Hour
+
+	for _, path := range logRotateFiles() {
+		stat, err := os.Stat(path)
+		if err != nil || stat.Size() == 0 {
+			continue
+		}
+		archives := logArchives(path)
+		if !(maxSize > 0 && stat.Size() >= maxSize) && !(maxAge > 0 && time.Since(logRotatedAt(path, archives)) >= maxAge) {
+			continue
+		}
+		if err := rotateLog(path, compress); err != nil {
+			logger.Warning("Rotate log", path, "failed:", err)
+			continue
+		}
+		logger.Info("Rotated log", path)
+		archives = logArchives(path)
+		if len(archives) <= keep {
+			continue
+		}
+		for _, archive := range archives[keep:] {
+			if err := os.Remove(archive); err != nil {
+				logger.Warning("Delete log archive", archive, "failed:", err)
+			}
+		}
+	}
+	return nil
+}
+
+// logRotateFiles returns the log and the HTTP access log of the panel and the log files of Xray.
+func logRotateFiles() []string {
+	files := []string{logger.GetLogFilePath(), logger.GetAccessLogFilePath()}
+	accessLogPath, _ := xray.GetAccessLogPath()
+	errorLogPath, _ := xray.GetErrorLogPath()
+	for _, path := range []string{accessLogPath, errorLogPath} {
+		if path != "" && path != "none" && !slices.Contains(files, path) {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// logArchives returns the archives of a log, the newest first.
+func logArchives(path string) []string {
+	matches, _ := filepath.Glob(path + ".*")
+	var archives []string
+	for _, match := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(match, path+"."), ".gz")
+		if _, err := time.Parse(logArchiveTimeFormat, stamp); err == nil {
+			archives = append(archives, match)
+		}
+	}
+	// The timestamps sort in time order
+	slices.Sort(archives)
+	slices.Reverse(archives)
+	return archives
+}
+
+// logRotatedAt returns when a log was last rotated, or first checked when it has no archive.
+func logRotatedAt(path string, archives []string) time.Time {
+	if len(archives) > 0 {
+		if stat, err := os.Stat(archives[0]); err == nil {
+			return stat.ModTime()
+		}
+	}
+	logRotateSeen.Lock()
+	defer logRotateSeen.Unlock()
+	if logRotateSeen.times == nil {
+		logRotateSeen.times = make(map[string]time.Time)
+	}
+	if _, ok := logRotateSeen.times[path]; !ok {
+		logRotateSeen.times[path] = time.Now()
+	}
+	return logRotateSeen.times[path]
+}
+
+// rotateLog copies a log to a new archive and truncates it. The lines written between the copy
+// and the truncation are lost.
+func rotateLog(path string, compress bool) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	archive := path + "." + time.Now().Format(logArchiveTimeFormat)
+	if compress {
+		archive += ".gz"
+	}
+	tmp := archive + ".tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	var w io.WriteCloser = dst
+	if compress {
+		w = gzip.NewWriter(dst)
+	}
+	_, err = io.Copy(w, src)
+	if compress {
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, archive)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Truncate(path, 0)
+}
@@ -0,0 +1,219 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// Sources of the streamed logs.
+const (
+	LogSourcePanel  = "panel"  // Log of the panel, including the core output
+	LogSourceXray   = "xray"   // Error log of Xray
+	LogSourceAccess = "access" // Access log of Xray
+)
+
+// logLevelRanks orders the log levels from the most to the least severe.
+var logLevelRanks = map[string]int{
+	"CRITICAL": 0,
+	"ERROR":    1,
+	"WARNING":  2,
+	"NOTICE":   3,
+	"INFO":     4,
+	"DEBUG":    5,
+}
+
+// xrayLogLineRegex matches a line of the Xray error log: time, level and message.
+var xrayLogLineRegex = regexp.MustCompile(`^(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2})(?:\.\d+)? \[([^\]]+)\] (.+)$`)
+
+// logFollowInterval is how often followed log files are checked for new lines.
+const logFollowInterval = 500 * time.Millisecond
+
+// LogLine is a streamed log line.
+type LogLine struct {
+	Source  string `json:"source"`
+	Time    string `json:"time"`
+	Level   string `json:"level"`            // Empty for access log lines
+	Module  string `json:"module,omitempty"` // The module of the panel that logged the line
+	Message string `json:"message"`
+}
+
+// LogFilter selects the streamed log lines.
+type LogFilter struct {
+	Source string // One of the log sources
+	Level  string // Least severe level sent, e.g. "INFO"; empty sends all levels
+	Search string // Substring the lines must contain, case insensitive
+}
+
+// LogStreamService streams new lines of the panel and Xray logs as they are written.
+type LogStreamService struct{}
+
+// StreamLogs calls send with every new log line that passes the filter, until ctx is done
+// or send fails. Lines written before the call are not sent.
+func (s *LogStreamService) StreamLogs(ctx context.Context, filter LogFilter, send func(*LogLine) error) error {
+	maxRank := len(logLevelRanks)
+	if filter.Level != "" {
+		rank, ok := logLevelRanks[strings.ToUpper(filter.Level)]
+		if !ok {
+			return common.NewError("Unknown log level:", filter.Level)
+		}
+		maxRank = rank
+	}
+	search := strings.ToLower(filter.Search)
+	pass := func(line *LogLine) bool {
+		if line.Level != "" {
+			if rank, ok := logLevelRanks[line.Level]; ok && rank > maxRank {
+				return false
+			}
+		}
+		return search == "" || strings.Contains(strings.ToLower(line.Message), search)
+	}
+
+	switch filter.Source {
+	case LogSourcePanel:
+		return streamPanelLogs(ctx, func(line *LogLine) bool { return true }, pass, send)
+	case LogSourceXray:
+		path, err := xray.GetErrorLogPath()
+		if err != nil {
+			return err
+		}
+		if path == "" {
+			// The error log goes to the output of the core, which the panel logs with an XRAY prefix
+			isXray := func(line *LogLine) bool {
+				if !strings.HasPrefix(line.Message, "XRAY: ") {
+					return false
+				}
+				line.Source = LogSourceXray
+				line.Message = strings.TrimPrefix(line.Message, "XRAY: ")
+				return true
+			}
+			return streamPanelLogs(ctx, isXray, pass, send)
+		}
+		return followLogFile(ctx, path, func(text string) error {
+			line := &LogLine{Source: LogSourceXray, Message: text}
+			if matches := xrayLogLineRegex.FindStringSubmatch(text); matches != nil {
+				line.Time = matches[1]
+				line.Level = strings.ToUpper(matches[2])
+				line.Message = matches[3]
+			}
+			if !pass(line) {
+				return nil
+			}
+			return send(line)
+		})
+	case LogSourceAccess:
+		path, err := xray.GetAccessLogPath()
+		if err != nil {
+			return err
+		}
+		if path == "" || path == "none" {
+			return common.NewError("The Xray access log is not written to a file")
+		}
+		return followLogFile(ctx, path, func(text string) error {
+			line := &LogLine{Source: LogSourceAccess, Message: text}
+			if len(text) >= 19 {
+				line.Time = text[:19]
+			}
+			if !pass(line) {
+				return nil
+			}
+			return send(line)
+		})
+	}
+	return common.NewError("Unknown log source:", filter.Source)
+}
+
+// streamPanelLogs sends the new entries of the panel log that match and pass the filter.
+func streamPanelLogs(ctx context.Context, match func(*LogLine) bool, pass func(*LogLine) bool, send func(*LogLine) error) error {
+	entries, unsubscribe := logger.Subscribe()
+	defer unsubscribe()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case entry := <-entries:
+			line := &LogLine{Source: LogSourcePanel, Time: entry.Time, Level: entry.Level, Module: entry.Module, Message: entry.Message}
+			if !match(line) || !pass(line) {
+				continue
+			}
+			if err := send(line); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// followLogFile calls handle with every line appended to a file, like tail -f. A file that
+// shrinks was rotated or truncated and is read again from its start.
+func followLogFile(ctx context.Context, path string, handle func(string) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { file.Close() }()
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(logFollowInterval)
+	defer ticker.Stop()
+	var partial string
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			// Being rotated, the new file shows up on a later tick
+			continue
+		}
+		current, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		if !os.SameFile(info, current) || info.Size() < offset {
+			file.Close()
+			if file, err = os.Open(path); err != nil {
+				return err
+			}
+			offset, partial = 0, ""
+		}
+		if info.Size() == offset {
+			continue
+		}
+
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		reader := bufio.NewReader(io.LimitReader(file, info.Size()-offset))
+		for {
+			text, err := reader.ReadString('\n')
+			offset += int64(len(text))
+			if err != nil {
+				// The last line is not complete yet, it is finished on a later tick
+				partial += text
+				break
+			}
+			text = strings.TrimRight(partial+text, "\r\n")
+			partial = ""
+			if text == "" {
+				continue
+			}
+			if err := handle(text); err != nil {
+				return err
+			}
+		}
+	}
+}
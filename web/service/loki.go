@@ -0,0 +1,277 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+const (
+	// lokiBatchInterval is how often the collected lines are pushed.
+	lokiBatchInterval = 2 * time.Second
+	// lokiBatchSize is the number of lines pushed at once at most.
+	lokiBatchSize = 1000
+	// lokiMaxPending is the number of lines kept while Loki cannot be reached, the oldest are
+	// dropped beyond it.
+	lokiMaxPending = 20000
+	// lokiMaxBackoff is the longest wait between two failed pushes.
+	lokiMaxBackoff = time.Minute
+)
+
+// LokiService ships the logs of the panel and of Xray to Grafana Loki. Every line is labeled
+// with the instance, the module that logged it and its level. The lines are pushed in batches,
+// which are retried with a growing delay while Loki fails.
+type LokiService struct {
+	settingService   SettingService
+	logStreamService LogStreamService
+}
+
+// lokiLine is a log line waiting to be pushed.
+type lokiLine struct {
+	time   time.Time
+	module string
+	level  string
+	text   string
+}
+
+// lokiShipper collects the lines and pushes them to Loki.
+type lokiShipper struct {
+	url      string
+	token    string
+	instance string
+	client   *http.Client
+
+	mu      sync.Mutex
+	pending []lokiLine
+	dropped int
+}
+
+// Start ships the logs until ctx is done, when enabled in the settings. The panel log is always
+// shipped, with the Xray error log when it is written to a file and the Xray access log when
+// enabled.
+func (s *LokiService) Start(ctx context.Context) error {
+	enable, err := s.settingService.GetLokiEnable()
+	if err != nil || !enable {
+		return err
+	}
+	url, err := s.settingService.GetLokiUrl()
+	if err != nil {
+		return err
+	}
+	if url == "" {
+		return common.NewError("Loki push URL is empty")
+	}
+	token, err := s.settingService.GetLokiToken()
+	if err != nil {
+		return err
+	}
+	instance, _ := s.settingService.GetLokiInstance()
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+	accessLog, err := s.settingService.GetLokiAccessLog()
+	if err != nil {
+		return err
+	}
+
+	shipper := &lokiShipper{
+		url:      url,
+		token:    token,
+		instance: instance,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+	go shipper.run(ctx)
+	go s.follow(ctx, LogSourcePanel, shipper)
+	if path, _ := xray.GetErrorLogPath(); path != "" {
+		go s.follow(ctx, LogSourceXray, shipper)
+	}
+	if accessLog {
+		go s.follow(ctx, LogSourceAccess, shipper)
+	}
+	return nil
+}
+
+// follow passes the new lines of a log source to the shipper until ctx is done. The source is
+// followed again after a while when it fails, e.g. while its file does not exist.
+func (s *LokiService) follow(ctx context.Context, source string, shipper *lokiShipper) {
+	for {
+		err := s.logStreamService.StreamLogs(ctx, LogFilter{Source: source}, func(line *LogLine) error {
+			shipper.add(lokiLineOf(line))
+			return nil
+		})
+		if err != nil {
+			logger.Debug("Loki: following the", source, "log failed:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(30 * time.Second):
+		}
+	}
+}
+
+// lokiLineOf returns the line to push for a streamed log line.
+func lokiLineOf(line *LogLine) lokiLine {
+	l := lokiLine{time: time.Now(), module: line.Module, level: strings.ToLower(line.Level), text: line.Message}
+	switch line.Source {
+	case LogSourceXray:
+		l.module = logger.ModuleXray
+	case LogSourceAccess:
+		l.module, l.level = "xray-access", "info"
+	}
+	if l.module == "" {
+		l.module = "x-ui"
+	}
+	if l.level == "" {
+		l.level = "info"
+	}
+	return l
+}
+
+// add queues a line, dropping the oldest one when too many are waiting.
+func (p *lokiShipper) add(line lokiLine) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.pending) >= lokiMaxPending {
+		p.pending = p.pending[1:]
+		p.dropped++
+	}
+	p.pending = append(p.pending, line)
+}
+
+// run pushes the queued lines in batches until ctx is done, then pushes what is left once.
+func (p *lokiShipper) run(ctx context.Context) {
+	backoff := time.Duration(0)
+	failing := false
+	for {
+		wait := lokiBatchInterval
+		if backoff > 0 {
+			wait = backoff
+		}
+		select {
+		case <-ctx.Done():
+			// Some lines may still be pushed while the panel stops
+			shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			p.flush(shutdown)
+			cancel()
+			return
+		case <-time.After(wait):
+		}
+		if err := p.flush(ctx); err != nil {
+			if !failing {
+				logger.Warning("Loki: push failed, retrying:", err)
+			}
+			failing = true
+			backoff = min(max(backoff*2, time.Second), lokiMaxBackoff)
+			continue
+		}
+		if failing {
+			logger.Info("Loki: pushing logs again")
+		}
+		failing, backoff = false, 0
+	}
+}
+
+// flush pushes the queued lines in batches. The lines of a batch that fails are queued again,
+// unless Loki rejected them as invalid.
+func (p *lokiShipper) flush(ctx context.Context) error {
+	for {
+		p.mu.Lock()
+		batch := p.pending[:min(len(p.pending), lokiBatchSize)]
+		p.pending = p.pending[len(batch):]
+		dropped := p.dropped
+		p.dropped = 0
+		p.mu.Unlock()
+		if len(batch) == 0 {
+			return nil
+		}
+		if dropped > 0 {
+			logger.Warning("Loki:", dropped, "log lines were dropped while Loki could not be reached")
+		}
+
+		retry, err := p.push(ctx, batch)
+		if err != nil {
+			if retry {
+				p.mu.Lock()
+				p.pending = slices.Concat(batch, p.pending)
+				if excess := len(p.pending) - lokiMaxPending; excess > 0 {
+					p.pending = p.pending[excess:]
+					p.dropped += excess
+				}
+				p.mu.Unlock()
+			}
+			return err
+		}
+	}
+}
+
+// push sends a batch to the push API of Loki, one stream per set of labels. It reports whether
+// the batch should be sent again after an error.
+func (p *lokiShipper) push(ctx context.Context, batch []lokiLine) (bool, error) {
+	type stream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	var streams []*stream
+	index := make(map[string]*stream)
+	for _, line := range batch {
+		key := line.module + "\x00" + line.level
+		st := index[key]
+		if st == nil {
+			st = &stream{Stream: map[string]string{
+				"job":      "3x-ui",
+				"instance": p.instance,
+				"module":   line.module,
+				"level":    line.level,
+			}}
+			index[key] = st
+			streams = append(streams, st)
+		}
+		st.Values = append(st.Values, [2]string{strconv.FormatInt(line.time.UnixNano(), 10), line.text})
+	}
+	payload, err := json.Marshal(map[string]any{"streams": streams})
+	if err != nil {
+		return false, err
+	}
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	gz.Write(payload)
+	gz.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, &body)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	if user, password, ok := strings.Cut(p.token, ":"); ok {
+		// Grafana Cloud takes the user ID and an access token
+		req.SetBasicAuth(user, password)
+	} else if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, common.NewErrorf("Loki push failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return false, nil
+}
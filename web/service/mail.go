@@ -0,0 +1,234 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/web/locale"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// Security modes of the SMTP connection.
+const (
+	SmtpSecurityAuto     = "auto"     // Implicit TLS on port 465, STARTTLS on other ports when offered
+	SmtpSecurityTLS      = "tls"      // Implicit TLS
+	SmtpSecuritySTARTTLS = "starttls" // STARTTLS, required
+	SmtpSecurityNone     = "none"     // Plain text
+)
+
+// MailAttachment is a file attached to an email.
+type MailAttachment struct {
+	Name string
+	Data []byte
+}
+
+// MailService sends plain text emails through the configured SMTP server.
+type MailService struct {
+	settingService SettingService
+	serverService  ServerService
+}
+
+// SendMail sends a plain text email with optional attachments to the given addresses.
+func (s *MailService) SendMail(to []string, subject string, body string, attachments ...MailAttachment) error {
+	host, err := s.settingService.GetSmtpHost()
+	if err != nil {
+		return err
+	}
+	port, err := s.settingService.GetSmtpPort()
+	if err != nil {
+		return err
+	}
+	security, err := s.settingService.GetSmtpSecurity()
+	if err != nil {
+		return err
+	}
+	from, err := s.settingService.GetSmtpFrom()
+	if err != nil {
+		return err
+	}
+	if host == "" || from == "" {
+		return common.NewError("SMTP host and sender are not configured")
+	}
+	if len(to) == 0 {
+		return common.NewError("No email recipients")
+	}
+	username, err := s.settingService.GetSmtpUsername()
+	if err != nil {
+		return err
+	}
+	password, err := s.settingService.GetSmtpPassword()
+	if err != nil {
+		return err
+	}
+
+	implicitTLS := security == SmtpSecurityTLS || (security == SmtpSecurityAuto && port == 465)
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	dialer := &net.Dialer{Timeout: 15 * time.Second}
+	var conn net.Conn
+	if implicitTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if !implicitTLS && security != SmtpSecurityNone {
+		ok, _ := client.Extension("STARTTLS")
+		if ok {
+			if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+				return err
+			}
+		} else if security == SmtpSecuritySTARTTLS {
+			return common.NewError("SMTP server does not support STARTTLS")
+		}
+	}
+	if username != "" {
+		if err := client.Auth(smtp.PlainAuth("", username, password, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := client.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(buildMail(from, to, subject, body, attachments)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// SendAdminMail sends an email to the configured admin addresses. It does nothing when there are none.
+func (s *MailService) SendAdminMail(subject string, body string, attachments ...MailAttachment) error {
+	adminTo, err := s.settingService.GetSmtpAdminTo()
+	if err != nil {
+		return err
+	}
+	to := splitMailAddresses(adminTo)
+	if len(to) == 0 {
+		return nil
+	}
+	return s.SendMail(to, subject, body, attachments...)
+}
+
+// SendTestMail sends a test email to the admin addresses, or to the report addresses when no
+// admin address is configured, to check the SMTP settings.
+func (s *MailService) SendTestMail(msg string) error {
+	adminTo, err := s.settingService.GetSmtpAdminTo()
+	if err != nil {
+		return err
+	}
+	to := splitMailAddresses(adminTo)
+	if len(to) == 0 {
+		reportTo, err := s.settingService.GetReportEmailTo()
+		if err != nil {
+			return err
+		}
+		to = splitMailAddresses(reportTo)
+	}
+	subject := locale.I18n(locale.Bot, "tgbot.messages.testMailSubject", "Hostname=="+reportHostname())
+	return s.SendMail(to, subject, msg)
+}
+
+// SendBackup emails the database and the Xray configuration to the admin addresses.
+func (s *MailService) SendBackup() error {
+	db, err := s.serverService.GetDb()
+	if err != nil {
+		return err
+	}
+	attachments := []MailAttachment{{Name: filepath.Base(config.GetDBPath()), Data: db}}
+	if xrayConfig, err := os.ReadFile(xray.GetConfigPath()); err == nil {
+		attachments = append(attachments, MailAttachment{Name: filepath.Base(xray.GetConfigPath()), Data: xrayConfig})
+	} else {
+		logger.Warning("Error in reading config.json for the backup email:", err)
+	}
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+	subject := locale.I18n(locale.Bot, "tgbot.messages.backupSubject", "Hostname=="+reportHostname())
+	body := plainNotifyText(locale.I18n(locale.Bot, "tgbot.messages.backupTime", "Time=="+now))
+	return s.SendAdminMail(subject, body, attachments...)
+}
+
+// splitMailAddresses splits a comma separated list of email addresses.
+func splitMailAddresses(list string) []string {
+	var addrs []string
+	for addr := range strings.SplitSeq(list, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// isMailAddress reports whether a client email is a deliverable email address rather than a plain identifier.
+func isMailAddress(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
+// buildMail renders an email message. Attachments turn it into a multipart message.
+func buildMail(from string, to []string, subject string, body string, attachments []MailAttachment) []byte {
+	body = strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n")
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\n",
+		from, strings.Join(to, ", "), mime.QEncoding.Encode("utf-8", subject), time.Now().Format(time.RFC1123Z))
+	if len(attachments) == 0 {
+		fmt.Fprintf(&msg, "Content-Type: text/plain; charset=UTF-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n%s", body)
+		return msg.Bytes()
+	}
+
+	random := make([]byte, 12)
+	rand.Read(random)
+	boundary := "3x-ui-" + hex.EncodeToString(random)
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", boundary)
+	fmt.Fprintf(&msg, "--%s\r\nContent-Type: text/plain; charset=UTF-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n%s\r\n", boundary, body)
+	for _, attachment := range attachments {
+		name := mime.QEncoding.Encode("utf-8", attachment.Name)
+		fmt.Fprintf(&msg, "--%s\r\nContent-Type: application/octet-stream; name=%q\r\nContent-Transfer-Encoding: base64\r\nContent-Disposition: attachment; filename=%q\r\n\r\n", boundary, name, name)
+		encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+		// Lines of an email must not be longer than 998 characters
+		for len(encoded) > 76 {
+			msg.WriteString(encoded[:76] + "\r\n")
+			encoded = encoded[76:]
+		}
+		msg.WriteString(encoded + "\r\n")
+	}
+	fmt.Fprintf(&msg, "--%s--\r\n", boundary)
+	return msg.Bytes()
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"cmp"
+	"regexp"
+	"slices"
+	"text/template"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/web/locale"
+)
+
+// messageTemplateSection is the translation section of the notification messages that can be customized.
+const messageTemplateSection = "tgbot.messages"
+
+// messageFieldRegex matches the event fields used in a message template, e.g. {{ .Email }}.
+var messageFieldRegex = regexp.MustCompile(`\{\{-?\s*\.(\w+)`)
+
+// MessageTemplateInfo describes a notification message of a language and its customization.
+type MessageTemplateInfo struct {
+	Key      string   `json:"key"`      // Message ID
+	Default  string   `json:"default"`  // Translated message
+	Template string   `json:"template"` // Customized message, empty when the translated one is used
+	Fields   []string `json:"fields"`   // Event fields the message is filled with
+}
+
+// MessageTemplateService lets the notification messages be customized per language. The
+// customized messages are Go templates filled with the same event fields as the translated ones.
+type MessageTemplateService struct{}
+
+// GetMessageTemplates returns the notification messages of a language, ordered by key.
+func (s *MessageTemplateService) GetMessageTemplates(lang string) ([]*MessageTemplateInfo, error) {
+	defaults, err := locale.TranslationMessages(lang, messageTemplateSection)
+	if err != nil {
+		return nil, err
+	}
+	var templates []*model.MessageTemplate
+	if err := database.GetDB().Model(model.MessageTemplate{}).Where("lang = ?", lang).Find(&templates).Error; err != nil {
+		return nil, err
+	}
+	custom := make(map[string]string, len(templates))
+	for _, t := range templates {
+		custom[t.Key] = t.Template
+	}
+
+	infos := make([]*MessageTemplateInfo, 0, len(defaults))
+	for key, text := range defaults {
+		var fields []string
+		for _, match := range messageFieldRegex.FindAllStringSubmatch(text, -1) {
+			if !slices.Contains(fields, match[1]) {
+				fields = append(fields, match[1])
+			}
+		}
+		infos = append(infos, &MessageTemplateInfo{
+			Key:      key,
+			Default:  text,
+			Template: custom[key],
+			Fields:   fields,
+		})
+	}
+	slices.SortFunc(infos, func(a, b *MessageTemplateInfo) int { return cmp.Compare(a.Key, b.Key) })
+	return infos, nil
+}
+
+// UpdateMessageTemplate customizes a notification message of a language. An empty template
+// restores the translated message.
+func (s *MessageTemplateService) UpdateMessageTemplate(lang string, key string, text string) error {
+	defaults, err := locale.TranslationMessages(lang, messageTemplateSection)
+	if err != nil {
+		return err
+	}
+	if _, ok := defaults[key]; !ok {
+		return common.NewError("Unknown message:", key)
+	}
+
+	db := database.GetDB()
+	if text == "" {
+		err = db.Where("lang = ? AND `key` = ?", lang, key).Delete(model.MessageTemplate{}).Error
+	} else {
+		if _, err := template.New(key).Parse(text); err != nil {
+			return common.NewError("Invalid message template:", err)
+		}
+		messageTemplate := &model.MessageTemplate{}
+		err = db.Where("lang = ? AND `key` = ?", lang, key).
+			Attrs(model.MessageTemplate{Lang: lang, Key: key}).
+			FirstOrInit(messageTemplate).Error
+		if err != nil {
+			return err
+		}
+		messageTemplate.Template = text
+		err = db.Save(messageTemplate).Error
+	}
+	if err != nil {
+		return err
+	}
+	return s.LoadMessageTemplates()
+}
+
+// LoadMessageTemplates applies the customized notification messages to the translations.
+func (s *MessageTemplateService) LoadMessageTemplates() error {
+	var templates []*model.MessageTemplate
+	if err := database.GetDB().Model(model.MessageTemplate{}).Find(&templates).Error; err != nil {
+		return err
+	}
+	overrides := make(map[string]map[string]string)
+	for _, t := range templates {
+		if overrides[t.Lang] == nil {
+			overrides[t.Lang] = make(map[string]string)
+		}
+		overrides[t.Lang][t.Key] = t.Template
+	}
+	return locale.SetMessageOverrides(overrides)
+}
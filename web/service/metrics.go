@@ -0,0 +1,246 @@
+package service
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"go.uber.org/atomic"
+)
+
+var (
+	coreStarts  atomic.Int64 // Successful starts of the core since the panel started
+	coreCrashes atomic.Int64 // Recorded crashes of the core since the panel started
+
+	httpMetricsLock sync.Mutex
+	httpMetrics     = map[httpMetricKey]*httpMetric{}
+)
+
+// httpMetricKey identifies the requests counted together: the route pattern instead of the
+// request path keeps the number of series bounded.
+type httpMetricKey struct {
+	method string
+	route  string
+	status int
+}
+
+type httpMetric struct {
+	count   int64
+	seconds float64
+}
+
+// ObserveHTTPRequest counts a request served by the panel and its duration.
+func ObserveHTTPRequest(method string, route string, status int, duration time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	key := httpMetricKey{method: method, route: route, status: status}
+	httpMetricsLock.Lock()
+	defer httpMetricsLock.Unlock()
+	metric, ok := httpMetrics[key]
+	if !ok {
+		metric = &httpMetric{}
+		httpMetrics[key] = metric
+	}
+	metric.count++
+	metric.seconds += duration.Seconds()
+}
+
+// MetricsService renders the panel and core metrics in the Prometheus text format.
+type MetricsService struct {
+	settingService SettingService
+	xrayService    XrayService
+}
+
+// GetMetrics returns the metrics of the panel, the core and the traffic counters
+// in the Prometheus text exposition format.
+func (s *MetricsService) GetMetrics() (string, error) {
+	w, err := s.collectMetrics()
+	if err != nil {
+		return "", err
+	}
+	return w.String(), nil
+}
+
+// collectMetrics gathers the metrics of the panel, the core and the traffic counters.
+func (s *MetricsService) collectMetrics() (*metricsWriter, error) {
+	w := &metricsWriter{}
+
+	start := time.Now()
+	dbErr := database.GetDB().Exec("SELECT 1").Error
+	w.header("xui_db_up", "gauge", "Whether the panel database answered a query.")
+	w.sample("xui_db_up", nil, boolMetric(dbErr == nil))
+	w.header("xui_db_query_seconds", "gauge", "Duration of a trivial query to the panel database.")
+	w.sample("xui_db_query_seconds", nil, time.Since(start).Seconds())
+	if dbErr != nil {
+		return w, nil
+	}
+
+	running := s.xrayService.IsXrayRunning()
+	w.header("xui_core_up", "gauge", "Whether the proxy core is running.")
+	w.sample("xui_core_up", nil, boolMetric(running))
+	w.header("xui_core_uptime_seconds", "gauge", "Seconds since the panel started the proxy core.")
+	var uptime uint64
+	if running {
+		uptime = p.GetUptime()
+	}
+	w.sample("xui_core_uptime_seconds", nil, float64(uptime))
+	w.header("xui_core_starts_total", "counter", "Starts of the proxy core since the panel started.")
+	w.sample("xui_core_starts_total", nil, float64(coreStarts.Load()))
+	w.header("xui_core_crashes_total", "counter", "Crashes of the proxy core since the panel started.")
+	w.sample("xui_core_crashes_total", nil, float64(coreCrashes.Load()))
+
+	var onlineClients []string
+	if running {
+		onlineClients = p.GetOnlineClients()
+	}
+	w.header("xui_online_clients", "gauge", "Clients with traffic in the last collection interval.")
+	w.sample("xui_online_clients", nil, float64(len(onlineClients)))
+
+	var inbounds []*model.Inbound
+	if err := database.GetDB().Model(model.Inbound{}).Select("id, tag, remark, protocol, enable, up, down").Find(&inbounds).Error; err != nil {
+		return nil, err
+	}
+	inboundTags := make(map[int]string, len(inbounds))
+	w.header("xui_inbound_enabled", "gauge", "Whether the inbound is enabled.")
+	for _, inbound := range inbounds {
+		inboundTags[inbound.Id] = inbound.Tag
+		w.sample("xui_inbound_enabled", inboundLabels(inbound), boolMetric(inbound.Enable))
+	}
+	w.header("xui_inbound_traffic_bytes", "counter", "Traffic of the inbound since its last reset.")
+	for _, inbound := range inbounds {
+		w.sample("xui_inbound_traffic_bytes", append(inboundLabels(inbound), "direction", "up"), float64(inbound.Up))
+		w.sample("xui_inbound_traffic_bytes", append(inboundLabels(inbound), "direction", "down"), float64(inbound.Down))
+	}
+
+	var clients []*xray.ClientTraffic
+	if err := database.GetDB().Model(xray.ClientTraffic{}).Order("email").Find(&clients).Error; err != nil {
+		return nil, err
+	}
+	online := make(map[string]bool, len(onlineClients))
+	for _, email := range onlineClients {
+		online[email] = true
+	}
+	w.header("xui_client_traffic_bytes", "counter", "Traffic of the client since its last reset.")
+	for _, client := range clients {
+		labels := []string{"email", client.Email, "inbound", inboundTags[client.InboundId]}
+		w.sample("xui_client_traffic_bytes", append(labels, "direction", "up"), float64(client.Up))
+		w.sample("xui_client_traffic_bytes", append(labels, "direction", "down"), float64(client.Down))
+	}
+	w.header("xui_client_enabled", "gauge", "Whether the client is enabled and within its limits.")
+	for _, client := range clients {
+		w.sample("xui_client_enabled", []string{"email", client.Email, "inbound", inboundTags[client.InboundId]}, boolMetric(client.Enable))
+	}
+	w.header("xui_client_online", "gauge", "Whether the client had traffic in the last collection interval.")
+	for _, client := range clients {
+		w.sample("xui_client_online", []string{"email", client.Email, "inbound", inboundTags[client.InboundId]}, boolMetric(online[client.Email]))
+	}
+
+	s.writeHTTPMetrics(w)
+	return w, nil
+}
+
+// writeHTTPMetrics adds the counters of the requests served by the panel, sorted for a stable output.
+func (s *MetricsService) writeHTTPMetrics(w *metricsWriter) {
+	httpMetricsLock.Lock()
+	keys := make([]httpMetricKey, 0, len(httpMetrics))
+	values := make(map[httpMetricKey]httpMetric, len(httpMetrics))
+	for key, metric := range httpMetrics {
+		keys = append(keys, key)
+		values[key] = *metric
+	}
+	httpMetricsLock.Unlock()
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].status < keys[j].status
+	})
+
+	w.header("xui_http_requests_total", "counter", "Requests served by the panel.")
+	for _, key := range keys {
+		w.sample("xui_http_requests_total", httpLabels(key), float64(values[key].count))
+	}
+	w.header("xui_http_request_duration_seconds_total", "counter", "Time spent serving the requests of the panel.")
+	for _, key := range keys {
+		w.sample("xui_http_request_duration_seconds_total", httpLabels(key), values[key].seconds)
+	}
+}
+
+// CheckMetricsToken reports whether the metrics endpoint is enabled and token is its token.
+func (s *MetricsService) CheckMetricsToken(token string) bool {
+	enabled, err := s.settingService.GetMetricsEnable()
+	if err != nil || !enabled {
+		return false
+	}
+	expected, err := s.settingService.GetMetricsToken()
+	if err != nil || expected == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+func inboundLabels(inbound *model.Inbound) []string {
+	return []string{"inbound", inbound.Tag, "remark", inbound.Remark, "protocol", string(inbound.Protocol)}
+}
+
+func httpLabels(key httpMetricKey) []string {
+	return []string{"method", key.method, "route", key.route, "status", strconv.Itoa(key.status)}
+}
+
+func boolMetric(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// metricsWriter builds a Prometheus text exposition and keeps the samples for the push exporter.
+type metricsWriter struct {
+	bytes.Buffer
+	samples []metricSample
+}
+
+// metricSample is one written sample; labels alternate between names and values.
+type metricSample struct {
+	name   string
+	labels []string
+	value  float64
+}
+
+func (w *metricsWriter) header(name string, metricType string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// sample writes one sample; labels alternate between names and values.
+func (w *metricsWriter) sample(name string, labels []string, value float64) {
+	w.samples = append(w.samples, metricSample{name: name, labels: labels, value: value})
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", labels[i], metricsLabelEscaper.Replace(labels[i+1]))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	w.WriteByte('\n')
+}
+
+var metricsLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
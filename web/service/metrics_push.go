@@ -0,0 +1,170 @@
+package service
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Formats the metrics can be pushed in.
+const (
+	MetricsPushInflux      = "influx"
+	MetricsPushRemoteWrite = "remoteWrite"
+)
+
+// PushMetrics writes the current metrics to the configured time-series database, either in the
+// InfluxDB line protocol or as a Prometheus remote-write request. The configured instance name,
+// or the host name by default, is added as the instance label so many panels can be told apart.
+func (s *MetricsService) PushMetrics() error {
+	format, err := s.settingService.GetMetricsPushFormat()
+	if err != nil {
+		return err
+	}
+	url, err := s.settingService.GetMetricsPushUrl()
+	if err != nil {
+		return err
+	}
+	if url == "" {
+		return common.NewError("Metrics push URL is empty")
+	}
+	token, err := s.settingService.GetMetricsPushToken()
+	if err != nil {
+		return err
+	}
+	instance, _ := s.settingService.GetMetricsPushInstance()
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+
+	w, err := s.collectMetrics()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+
+	var req *http.Request
+	switch format {
+	case MetricsPushInflux:
+		req, err = http.NewRequest(http.MethodPost, url, bytes.NewReader(influxLines(w.samples, instance, now)))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		if token != "" {
+			req.Header.Set("Authorization", "Token "+token)
+		}
+	case MetricsPushRemoteWrite:
+		body := snappy.Encode(nil, remoteWriteRequest(w.samples, instance, now))
+		req, err = http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		req.Header.Set("Content-Encoding", "snappy")
+		req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	default:
+		return common.NewError("Unknown metrics push format:", format)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return common.NewErrorf("Metrics push failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// influxLines encodes the samples in the InfluxDB line protocol, one measurement per metric
+// with the labels as tags and the sample in the value field.
+func influxLines(samples []metricSample, instance string, now time.Time) []byte {
+	var buf bytes.Buffer
+	timestamp := strconv.FormatInt(now.UnixNano(), 10)
+	for _, sample := range samples {
+		if math.IsNaN(sample.value) || math.IsInf(sample.value, 0) {
+			continue
+		}
+		buf.WriteString(influxMeasurementEscaper.Replace(sample.name))
+		for _, label := range pushLabels(sample.labels, instance) {
+			buf.WriteByte(',')
+			buf.WriteString(influxTagEscaper.Replace(label[0]))
+			buf.WriteByte('=')
+			buf.WriteString(influxTagEscaper.Replace(label[1]))
+		}
+		buf.WriteString(" value=")
+		buf.WriteString(strconv.FormatFloat(sample.value, 'g', -1, 64))
+		buf.WriteByte(' ')
+		buf.WriteString(timestamp)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// remoteWriteRequest encodes the samples as a Prometheus remote-write WriteRequest protobuf.
+func remoteWriteRequest(samples []metricSample, instance string, now time.Time) []byte {
+	var request []byte
+	for _, sample := range samples {
+		var series []byte
+		labels := append([][2]string{{"__name__", sample.name}}, pushLabels(sample.labels, instance)...)
+		// Remote-write receivers expect the labels sorted by name
+		sort.Slice(labels, func(i, j int) bool { return labels[i][0] < labels[j][0] })
+		for _, label := range labels {
+			var encoded []byte
+			encoded = protowire.AppendTag(encoded, 1, protowire.BytesType)
+			encoded = protowire.AppendString(encoded, label[0])
+			encoded = protowire.AppendTag(encoded, 2, protowire.BytesType)
+			encoded = protowire.AppendString(encoded, label[1])
+			series = protowire.AppendTag(series, 1, protowire.BytesType)
+			series = protowire.AppendBytes(series, encoded)
+		}
+		var point []byte
+		point = protowire.AppendTag(point, 1, protowire.Fixed64Type)
+		point = protowire.AppendFixed64(point, math.Float64bits(sample.value))
+		point = protowire.AppendTag(point, 2, protowire.VarintType)
+		point = protowire.AppendVarint(point, uint64(now.UnixMilli()))
+		series = protowire.AppendTag(series, 2, protowire.BytesType)
+		series = protowire.AppendBytes(series, point)
+
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, series)
+	}
+	return request
+}
+
+// pushLabels pairs the alternating label names and values and adds the instance label.
+// Empty values are dropped, neither protocol accepts them.
+func pushLabels(labels []string, instance string) [][2]string {
+	pairs := make([][2]string, 0, len(labels)/2+1)
+	if instance != "" {
+		pairs = append(pairs, [2]string{"instance", instance})
+	}
+	for i := 0; i+1 < len(labels); i += 2 {
+		if labels[i+1] != "" {
+			pairs = append(pairs, [2]string{labels[i], labels[i+1]})
+		}
+	}
+	return pairs
+}
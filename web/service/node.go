@@ -0,0 +1,451 @@
+package service
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// Validity of the registration token of a node.
+const nodeTokenValidity = 24 * time.Hour
+
+// nodeSyncMu keeps a slow sync from overlapping with the next one.
+var nodeSyncMu sync.Mutex
+
+// NodeService manages the servers this panel controls through their node agents: it pushes
+// the inbounds assigned to every node and adds the traffic the nodes report to them.
+type NodeService struct {
+	settingService SettingService
+	inboundService InboundService
+	xrayService    XrayService
+}
+
+// GetNodes returns all nodes.
+func (s *NodeService) GetNodes() ([]*model.Node, error) {
+	db := database.GetDB()
+	var nodes []*model.Node
+	err := db.Model(model.Node{}).Find(&nodes).Error
+	if err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// checkNode validates the fields of a node that can be edited.
+func (s *NodeService) checkNode(node *model.Node) error {
+	node.Name = strings.TrimSpace(node.Name)
+	if node.Name == "" {
+		return common.NewError("Node name is required")
+	}
+	node.Group = strings.TrimSpace(node.Group)
+	node.Host = strings.TrimSpace(node.Host)
+	node.Region = strings.TrimSpace(node.Region)
+	node.Flag = strings.TrimSpace(node.Flag)
+	node.Labels = strings.Join(splitRuleList(node.Labels), ",")
+	if node.Bandwidth < 0 {
+		return common.NewError("Node bandwidth cannot be negative")
+	}
+	_, err := parseNodeInboundIds(node.Inbounds)
+	return err
+}
+
+// AddNode creates a node with a registration token, which the node agent registers with.
+func (s *NodeService) AddNode(node *model.Node) error {
+	if err := s.checkNode(node); err != nil {
+		return err
+	}
+	node.Id = 0
+	node.Address = ""
+	node.Token = random.Seq(32)
+	node.TokenExpiry = time.Now().Add(nodeTokenValidity).Unix()
+	node.CertExpiry = 0
+	node.ConfigHash = ""
+	node.LastSeen = 0
+	node.LastError = ""
+	node.Status = ""
+	node.SyncStatus = ""
+	node.Conflicts = ""
+	node.LastSync = 0
+	node.Healthy = false
+	node.HealthError = ""
+	return database.GetDB().Create(node).Error
+}
+
+// UpdateNode updates the name, the enable state, the group, the host and the inbounds of a node.
+func (s *NodeService) UpdateNode(node *model.Node) error {
+	if err := s.checkNode(node); err != nil {
+		return err
+	}
+	result := database.GetDB().Model(model.Node{}).Where("id = ?", node.Id).
+		Select("name", "enable", "group", "host", "region", "flag", "labels", "bandwidth", "inbounds").
+		Updates(node)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return common.NewError("Node not found:", node.Id)
+	}
+	return nil
+}
+
+// DelNode deletes a node along with its traffic counters. The inbounds stay on the node until
+// its node agent is disabled.
+func (s *NodeService) DelNode(id int) error {
+	db := database.GetDB()
+	if err := db.Where("node_id = ?", id).Delete(model.NodeTraffic{}).Error; err != nil {
+		return err
+	}
+	if err := db.Where("node_id = ?", id).Delete(model.NodePlacement{}).Error; err != nil {
+		return err
+	}
+	return db.Delete(model.Node{}, id).Error
+}
+
+// RenewNodeToken issues a new registration token to a node, e.g. to register it again after it
+// was reinstalled.
+func (s *NodeService) RenewNodeToken(id int) (*model.Node, error) {
+	db := database.GetDB()
+	node := &model.Node{}
+	if err := db.Model(model.Node{}).Where("id = ?", id).First(node).Error; err != nil {
+		return nil, err
+	}
+	node.Token = random.Seq(32)
+	node.TokenExpiry = time.Now().Add(nodeTokenValidity).Unix()
+	err := db.Model(node).Updates(map[string]any{"token": node.Token, "token_expiry": node.TokenExpiry}).Error
+	if err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// RegisterNode signs the certificate request of a node agent presenting a valid registration
+// token. The token is used up, and the configuration is pushed to the node on the next sync.
+func (s *NodeService) RegisterNode(token string, address string, csrPEM string) (*NodeCertificate, error) {
+	if token == "" {
+		return nil, common.NewError("Node registration token is required")
+	}
+	nodes, err := s.GetNodes()
+	if err != nil {
+		return nil, err
+	}
+	var node *model.Node
+	for _, candidate := range nodes {
+		if candidate.Token != "" && subtle.ConstantTimeCompare([]byte(candidate.Token), []byte(token)) == 1 {
+			node = candidate
+		}
+	}
+	if node == nil || node.TokenExpiry < time.Now().Unix() {
+		return nil, common.NewError("Invalid or expired node registration token")
+	}
+
+	agentUrl, err := url.Parse(address)
+	if err != nil || agentUrl.Scheme != "https" || agentUrl.Hostname() == "" {
+		return nil, common.NewError("Node address must be an https URL:", address)
+	}
+	cert, expiry, err := s.settingService.signNodeCertificate(csrPEM, agentUrl.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	ca, err := s.settingService.getNodeCAPEM()
+	if err != nil {
+		return nil, err
+	}
+
+	err = database.GetDB().Model(node).Updates(map[string]any{
+		"address":      strings.TrimSuffix(address, "/"),
+		"token":        "",
+		"token_expiry": 0,
+		"cert_expiry":  expiry.Unix(),
+		"config_hash":  "",
+		"last_error":   "",
+		"sync_status":  "",
+		"conflicts":    "",
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Node", node.Name, "registered from", address)
+	return &NodeCertificate{Cert: cert, Ca: ca}, nil
+}
+
+// SyncNodes pulls the traffic of every enabled, registered node and pushes its inbounds when
+// they changed since the last push.
+func (s *NodeService) SyncNodes() {
+	if !nodeSyncMu.TryLock() {
+		return
+	}
+	defer nodeSyncMu.Unlock()
+
+	if err := s.placeNodeClients(); err != nil {
+		logger.Warning("Place node clients failed:", err)
+	}
+	nodes, err := s.GetNodes()
+	if err != nil {
+		logger.Warning("Get nodes failed:", err)
+		return
+	}
+	var client *http.Client
+	for _, node := range nodes {
+		if !node.Enable || node.Address == "" {
+			continue
+		}
+		if client == nil {
+			client, err = s.nodeClient()
+			if err != nil {
+				logger.Warning("Get node client certificate failed:", err)
+				return
+			}
+		}
+		s.syncNodeAndSave(client, node)
+	}
+}
+
+// SyncNode syncs a node right away, waiting for a running sync to finish first.
+func (s *NodeService) SyncNode(id int) (*model.Node, error) {
+	nodeSyncMu.Lock()
+	defer nodeSyncMu.Unlock()
+
+	node := &model.Node{}
+	if err := database.GetDB().Model(model.Node{}).Where("id = ?", id).First(node).Error; err != nil {
+		return nil, err
+	}
+	if node.Address == "" {
+		return nil, common.NewError("Node is not registered:", node.Name)
+	}
+	client, err := s.nodeClient()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.syncNodeAndSave(client, node); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// nodeClient returns an HTTP client that calls the node agents with the client certificate of
+// the panel.
+func (s *NodeService) nodeClient() (*http.Client, error) {
+	tlsConfig, err := s.settingService.nodeClientTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}, nil
+}
+
+// syncNodeAndSave syncs a node and saves the outcome of the sync to it.
+func (s *NodeService) syncNodeAndSave(client *http.Client, node *model.Node) error {
+	err := s.syncNode(client, node)
+	if err != nil {
+		logger.Warning("Sync node", node.Name, "failed:", err)
+		node.LastError = err.Error()
+		node.SyncStatus = NodeSyncError
+	} else {
+		node.LastError = ""
+		node.LastSeen = time.Now().Unix()
+		if node.Conflicts != "" {
+			node.SyncStatus = NodeSyncConflict
+		} else {
+			node.SyncStatus = NodeSyncSynced
+		}
+	}
+	saveErr := database.GetDB().Model(node).
+		Select("last_error", "last_seen", "status", "config_hash", "sync_status", "conflicts", "last_sync").
+		Updates(node).Error
+	if saveErr != nil {
+		logger.Warning("Update node", node.Name, "failed:", saveErr)
+	}
+	return err
+}
+
+// syncNode pulls the traffic and the status of a node, then pushes its inbounds if they changed
+// since the last push or were changed on the node, updating the node accordingly. Pushes with
+// conflicts are repeated on every sync until the node reports none. The limits of the clients,
+// with their current usage, are sent on every sync for the node to enforce during outages.
+func (s *NodeService) syncNode(client *http.Client, node *model.Node) error {
+	stats := &NodeAgentStats{}
+	if err := s.nodeRequest(client, http.MethodGet, node.Address+"/stats", nil, nil, stats); err != nil {
+		return err
+	}
+	if err := s.addNodeTraffic(node, stats); err != nil {
+		return err
+	}
+	status, err := json.Marshal(stats.Status)
+	if err != nil {
+		return err
+	}
+	node.Status = string(status)
+
+	if node.ConfigHash != "" && stats.ConfigHash != node.ConfigHash {
+		logger.Warning("Inbounds of node", node.Name, "were changed on the node, pushing them again")
+		node.ConfigHash = ""
+	}
+
+	inbounds, err := s.getNodeInbounds(node)
+	if err != nil {
+		return err
+	}
+	limits, err := getNodeClientLimits(inbounds)
+	if err != nil {
+		return err
+	}
+	limitsPayload, err := json.Marshal(limits)
+	if err != nil {
+		return err
+	}
+	if err := s.nodeRequest(client, http.MethodPost, node.Address+"/limits", limitsPayload, nil, nil); err != nil {
+		return err
+	}
+
+	payload, hash, err := encodeNodeInbounds(inbounds)
+	if err != nil {
+		return err
+	}
+	if hash == node.ConfigHash {
+		return nil
+	}
+	header := http.Header{}
+	header.Set(NodeConfigHashHeader, hash)
+	result := &NodeSyncResult{}
+	if err := s.nodeRequest(client, http.MethodPost, node.Address+"/sync", payload, header, result); err != nil {
+		return err
+	}
+	node.LastSync = time.Now().Unix()
+	node.Conflicts = strings.Join(result.Conflicts, "\n")
+	if len(result.Conflicts) > 0 {
+		node.ConfigHash = ""
+	} else {
+		node.ConfigHash = hash
+	}
+	return nil
+}
+
+// nodeRequest calls the node agent and decodes its JSON answer into result, if not nil.
+func (s *NodeService) nodeRequest(client *http.Client, method string, target string, body []byte, header http.Header, result any) error {
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key := range header {
+		req.Header.Set(key, header.Get(key))
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return common.NewErrorf("Node answered with status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// addNodeTraffic adds the traffic a node used since the previous sync to the inbounds and
+// clients. The counters of the node only grow, unless they were reset there, in which case all
+// of the current counter is new traffic.
+func (s *NodeService) addNodeTraffic(node *model.Node, stats *NodeAgentStats) error {
+	db := database.GetDB()
+	var counters []*model.NodeTraffic
+	if err := db.Where("node_id = ?", node.Id).Find(&counters).Error; err != nil {
+		return err
+	}
+	previous := make(map[string]*model.NodeTraffic, len(counters))
+	for _, counter := range counters {
+		previous[counter.Kind+":"+counter.Name] = counter
+	}
+
+	totals, err := s.getPanelUsage(stats)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UnixMilli()
+	updated := make([]*model.NodeTraffic, 0, len(counters))
+	delta := func(kind string, traffic *NodeAgentTraffic) (int64, int64) {
+		key := kind + ":" + traffic.Name
+		counter, ok := previous[key]
+		if !ok {
+			counter = &model.NodeTraffic{NodeId: node.Id, Kind: kind, Name: traffic.Name}
+		}
+		delete(previous, key)
+		up, down := traffic.Up-counter.Up, traffic.Down-counter.Down
+		if up < 0 || down < 0 {
+			up, down = traffic.Up, traffic.Down
+		}
+		counter.Up, counter.Down = traffic.Up, traffic.Down
+
+		// The usage on the panel only grows until it is reset, which resets the contributions too
+		if totals[key] < counter.Seen {
+			counter.UsedUp, counter.UsedDown = 0, 0
+		}
+		counter.UsedUp += up
+		counter.UsedDown += down
+		counter.Seen = totals[key] + up + down
+		if up+down > 0 {
+			counter.LastOnline = now
+		}
+		updated = append(updated, counter)
+		return up, down
+	}
+
+	var inboundTraffics []*xray.Traffic
+	for _, traffic := range stats.Inbounds {
+		up, down := delta("inbound", traffic)
+		if up+down > 0 {
+			inboundTraffics = append(inboundTraffics, &xray.Traffic{IsInbound: true, Tag: traffic.Name, Up: up, Down: down})
+		}
+	}
+	var clientTraffics []*xray.ClientTraffic
+	for _, traffic := range stats.Clients {
+		up, down := delta("client", traffic)
+		if up+down > 0 {
+			clientTraffics = append(clientTraffics, &xray.ClientTraffic{Email: traffic.Name, Up: up, Down: down})
+		}
+	}
+
+	if len(inboundTraffics) > 0 || len(clientTraffics) > 0 {
+		needRestart, err := s.inboundService.AddNodeTraffic(inboundTraffics, clientTraffics)
+		if err != nil {
+			return err
+		}
+		if needRestart {
+			s.xrayService.SetToNeedRestart()
+		}
+	}
+
+	// Counters are saved after the traffic was added, so that failed additions are retried
+	if len(updated) > 0 {
+		if err := db.Save(updated).Error; err != nil {
+			return err
+		}
+	}
+	for _, stale := range previous {
+		if err := db.Delete(stale).Error; err != nil {
+			return err
+		}
+	}
+	setNodeOnlineClients(node.Id, stats.Online)
+	return nil
+}
@@ -0,0 +1,393 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"gorm.io/gorm"
+)
+
+// NodeConfigHashHeader carries the hash of the inbounds a panel pushes to a node agent.
+const NodeConfigHashHeader = "X-Config-Hash"
+
+// NodeAgentTraffic is the traffic counter of an inbound, by tag, or of a client, by email.
+type NodeAgentTraffic struct {
+	Name string `json:"name"`
+	Up   int64  `json:"up"`
+	Down int64  `json:"down"`
+}
+
+// NodeAgentStats is what a node agent reports to its panel on every sync.
+type NodeAgentStats struct {
+	Status     *Status             `json:"status"`
+	Inbounds   []*NodeAgentTraffic `json:"inbounds"`
+	Clients    []*NodeAgentTraffic `json:"clients"`
+	Online     []string            `json:"online"`     // Emails of the managed clients online
+	ConfigHash string              `json:"configHash"` // Hash of the last push, empty if the managed inbounds were changed since
+}
+
+// NodeSyncResult is what a node agent answers a push with.
+type NodeSyncResult struct {
+	Conflicts []string `json:"conflicts"` // Pushed inbounds that were skipped as they clash with local inbounds
+}
+
+// nodeRegistration is the request a node agent registers with its panel with.
+type nodeRegistration struct {
+	Token   string `json:"token"`
+	Address string `json:"address"`
+	Csr     string `json:"csr"`
+}
+
+// NodeCertificate is the certificate a panel issues to a registered node, along with the
+// certificate of the node authority.
+type NodeCertificate struct {
+	Cert string `json:"cert"`
+	Ca   string `json:"ca"`
+}
+
+var (
+	nodeAgentStatus   *Status
+	nodeAgentStatusMu sync.Mutex
+)
+
+// NodeAgentService runs the inbounds a panel pushes to this server when it is a node of that
+// panel. The inbounds are managed by the panel only: their limits are enforced there, so they are
+// stored here without any, and their traffic is reported back. The limits of the clients are only
+// enforced here while the panel cannot be reached.
+type NodeAgentService struct {
+	settingService SettingService
+	serverService  ServerService
+	userService    UserService
+	xrayService    XrayService
+}
+
+// getManagedTags returns the tags of the inbounds pushed by the panel.
+func (s *NodeAgentService) getManagedTags() ([]string, error) {
+	tags, err := s.settingService.getString("nodeAgentTags")
+	if err != nil || tags == "" {
+		return nil, err
+	}
+	return strings.Split(tags, ","), nil
+}
+
+// GetStats returns the status of the server and the traffic counters of the managed inbounds
+// and of their clients.
+func (s *NodeAgentService) GetStats() (*NodeAgentStats, error) {
+	markNodeAgentContact()
+	nodeAgentStatusMu.Lock()
+	nodeAgentStatus = s.serverService.GetStatus(nodeAgentStatus)
+	stats := &NodeAgentStats{
+		Status:   nodeAgentStatus,
+		Inbounds: []*NodeAgentTraffic{},
+		Clients:  []*NodeAgentTraffic{},
+		Online:   []string{},
+	}
+	nodeAgentStatusMu.Unlock()
+
+	tags, err := s.getManagedTags()
+	if err != nil {
+		return nil, err
+	}
+	inbounds, err := getManagedInbounds(database.GetDB(), tags)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := s.settingService.getString("nodeAgentHash")
+	if err != nil {
+		return nil, err
+	}
+	fingerprint, err := s.settingService.getString("nodeAgentFingerprint")
+	if err != nil {
+		return nil, err
+	}
+	if managedFingerprint(inbounds) == fingerprint {
+		stats.ConfigHash = hash
+	}
+
+	var online []string
+	if p != nil {
+		online = p.GetOnlineClients()
+	}
+	for _, inbound := range inbounds {
+		stats.Inbounds = append(stats.Inbounds, &NodeAgentTraffic{Name: inbound.Tag, Up: inbound.Up, Down: inbound.Down})
+		for _, clientTraffic := range inbound.ClientStats {
+			stats.Clients = append(stats.Clients, &NodeAgentTraffic{Name: clientTraffic.Email, Up: clientTraffic.Up, Down: clientTraffic.Down})
+			if slices.Contains(online, clientTraffic.Email) {
+				stats.Online = append(stats.Online, clientTraffic.Email)
+			}
+		}
+	}
+	return stats, nil
+}
+
+// SyncInbounds replaces the managed inbounds with those pushed by the panel, matched by tag.
+// Traffic counters are kept, the clients take the enable state of their ClientStats and the
+// inbounds no longer pushed are removed. Pushed inbounds whose tag or port is taken by a local
+// inbound are skipped and reported as conflicts. The hash of the push is reported back in the
+// stats for as long as the managed inbounds are not changed on this server.
+func (s *NodeAgentService) SyncInbounds(inbounds []*model.Inbound, hash string) (*NodeSyncResult, error) {
+	markNodeAgentContact()
+	tags, err := s.getManagedTags()
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.userService.GetFirstUser()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &NodeSyncResult{Conflicts: []string{}}
+	changed := false
+	db := database.GetDB()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		managed, err := getManagedInbounds(tx, tags)
+		if err != nil {
+			return err
+		}
+		before := managedFingerprint(managed)
+
+		var local []*model.Inbound
+		query := tx.Model(model.Inbound{})
+		if len(tags) > 0 {
+			query = query.Where("tag NOT IN ?", tags)
+		}
+		if err := query.Find(&local).Error; err != nil {
+			return err
+		}
+
+		managedIds := make([]int, 0, len(managed))
+		managedByTag := make(map[string]*model.Inbound, len(managed))
+		for _, inbound := range managed {
+			managedIds = append(managedIds, inbound.Id)
+			managedByTag[inbound.Tag] = inbound
+		}
+
+		// The client stats are recreated below, as clients may move between inbounds
+		oldStats := make(map[string]xray.ClientTraffic)
+		if len(managedIds) > 0 {
+			var stats []xray.ClientTraffic
+			if err := tx.Where("inbound_id IN ?", managedIds).Find(&stats).Error; err != nil {
+				return err
+			}
+			for _, stat := range stats {
+				oldStats[stat.Email] = stat
+			}
+			if err := tx.Where("inbound_id IN ?", managedIds).Delete(xray.ClientTraffic{}).Error; err != nil {
+				return err
+			}
+		}
+
+		pushedTags := make([]string, 0, len(inbounds))
+		for _, pushed := range inbounds {
+			if pushed.Tag == "" {
+				return common.NewError("pushed inbound without tag:", pushed.Remark)
+			}
+			if conflict := nodeInboundConflict(pushed, local); conflict != nil {
+				result.Conflicts = append(result.Conflicts, fmt.Sprintf("%s (%s): port %d is taken by the local inbound %s (%s)",
+					pushed.Remark, pushed.Tag, pushed.Port, conflict.Remark, conflict.Tag))
+				continue
+			}
+			inbound, ok := managedByTag[pushed.Tag]
+			if ok {
+				delete(managedByTag, pushed.Tag)
+			} else {
+				inbound = &model.Inbound{UserId: user.Id, Tag: pushed.Tag}
+			}
+			inbound.Remark = pushed.Remark
+			inbound.Enable = pushed.Enable
+			inbound.Listen = pushed.Listen
+			inbound.Port = pushed.Port
+			inbound.PortRange = pushed.PortRange
+			inbound.Protocol = pushed.Protocol
+			inbound.Settings = pushed.Settings
+			inbound.StreamSettings = pushed.StreamSettings
+			inbound.Sniffing = pushed.Sniffing
+			inbound.GroupName = pushed.GroupName
+			inbound.Tags = pushed.Tags
+			inbound.MaxConnections = pushed.MaxConnections
+			inbound.Total = 0
+			inbound.ExpiryTime = 0
+			inbound.TrafficReset = "never"
+			inbound.OutboundTag = ""
+			inbound.ClientStats = nil
+			if err := tx.Save(inbound).Error; err != nil {
+				return err
+			}
+
+			for _, pushedStat := range pushed.ClientStats {
+				stat := oldStats[pushedStat.Email]
+				stat.Id = 0
+				stat.InboundId = inbound.Id
+				stat.Email = pushedStat.Email
+				stat.Enable = pushedStat.Enable
+				stat.Total = 0
+				stat.ExpiryTime = 0
+				stat.Reset = 0
+				if err := tx.Create(&stat).Error; err != nil {
+					return err
+				}
+			}
+			pushedTags = append(pushedTags, pushed.Tag)
+		}
+
+		for _, removed := range managedByTag {
+			if err := tx.Delete(removed).Error; err != nil {
+				return err
+			}
+		}
+
+		managed, err = getManagedInbounds(tx, pushedTags)
+		if err != nil {
+			return err
+		}
+		after := managedFingerprint(managed)
+		changed = after != before
+		settings := map[string]string{
+			"nodeAgentTags":        strings.Join(pushedTags, ","),
+			"nodeAgentHash":        hash,
+			"nodeAgentFingerprint": after,
+		}
+		for key, value := range settings {
+			setting := &model.Setting{}
+			if err := tx.Where("`key` = ?", key).FirstOrInit(setting, model.Setting{Key: key}).Error; err != nil {
+				return err
+			}
+			setting.Value = value
+			if err := tx.Save(setting).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		s.xrayService.SetToNeedRestart()
+	}
+	return result, nil
+}
+
+// getManagedInbounds returns the managed inbounds with their client stats, ordered by tag.
+func getManagedInbounds(tx *gorm.DB, tags []string) ([]*model.Inbound, error) {
+	inbounds := make([]*model.Inbound, 0, len(tags))
+	if len(tags) == 0 {
+		return inbounds, nil
+	}
+	err := tx.Model(model.Inbound{}).Preload("ClientStats").Where("tag IN ?", tags).Order("tag").Find(&inbounds).Error
+	if err != nil {
+		return nil, err
+	}
+	return inbounds, nil
+}
+
+// managedFingerprint hashes the configuration of the managed inbounds, leaving out their traffic,
+// to notice when they are changed on this server rather than by the panel.
+func managedFingerprint(inbounds []*model.Inbound) string {
+	hash := sha256.New()
+	for _, inbound := range inbounds {
+		fmt.Fprintf(hash, "%s\x00%v\x00%s\x00%d\x00%s\x00%s\x00%s\x00%s\x00%s\x00%s\x00%s\x00%d\n",
+			inbound.Tag, inbound.Enable, inbound.Listen, inbound.Port, inbound.PortRange, inbound.Protocol,
+			inbound.Settings, inbound.StreamSettings, inbound.Sniffing, inbound.Remark, inbound.GroupName, inbound.MaxConnections)
+		emails := make([]string, 0, len(inbound.ClientStats))
+		for _, clientTraffic := range inbound.ClientStats {
+			emails = append(emails, fmt.Sprintf("%s\x00%v", clientTraffic.Email, clientTraffic.Enable))
+		}
+		slices.Sort(emails)
+		fmt.Fprintln(hash, strings.Join(emails, "\x01"))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// nodeInboundConflict returns the local inbound that takes the tag or the port of a pushed inbound.
+func nodeInboundConflict(pushed *model.Inbound, local []*model.Inbound) *model.Inbound {
+	anyAddress := func(listen string) bool {
+		return listen == "" || listen == "0.0.0.0" || listen == "::" || listen == "::0"
+	}
+	for _, inbound := range local {
+		if inbound.Tag == pushed.Tag {
+			return inbound
+		}
+		if inbound.Port == pushed.Port &&
+			(inbound.Listen == pushed.Listen || anyAddress(inbound.Listen) || anyAddress(pushed.Listen)) {
+			return inbound
+		}
+	}
+	return nil
+}
+
+// RegisterNodeAgent registers this server as a node of the panel at panelUrl with a registration
+// token issued there. The node agent will be reachable by the panel at address, an https URL
+// whose port the agent listens on.
+func (s *NodeAgentService) RegisterNodeAgent(panelUrl string, token string, address string) error {
+	agentUrl, err := url.Parse(address)
+	if err != nil || agentUrl.Scheme != "https" || agentUrl.Hostname() == "" {
+		return common.NewError("node address must be an https URL:", address)
+	}
+	port := 443
+	if agentUrl.Port() != "" {
+		port, err = strconv.Atoi(agentUrl.Port())
+		if err != nil {
+			return err
+		}
+	}
+
+	keyPEM, csrPEM, err := newNodeKeyRequest(agentUrl.Hostname())
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(&nodeRegistration{Token: token, Address: address, Csr: csrPEM})
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(strings.TrimSuffix(panelUrl, "/")+"/node/register", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return common.NewErrorf("Node registration failed with status %d", resp.StatusCode)
+	}
+	var msg struct {
+		Success bool             `json:"success"`
+		Msg     string           `json:"msg"`
+		Obj     *NodeCertificate `json:"obj"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&msg); err != nil {
+		return err
+	}
+	if !msg.Success || msg.Obj == nil {
+		return common.NewError("Node registration failed:", msg.Msg)
+	}
+
+	settings := map[string]string{
+		"nodeAgentCert":   msg.Obj.Cert,
+		"nodeAgentKey":    keyPEM,
+		"nodeAgentCa":     msg.Obj.Ca,
+		"nodeAgentPort":   strconv.Itoa(port),
+		"nodeAgentEnable": "true",
+	}
+	for key, value := range settings {
+		if err := s.settingService.saveSetting(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,395 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Timeouts of the SSH connection to a server being bootstrapped and of the installation.
+const (
+	nodeBootstrapDialTimeout = 30 * time.Second
+	nodeBootstrapRunTimeout  = 15 * time.Minute
+)
+
+// nodeBootstrapMaxLog limits the installation output kept for the administrator.
+const nodeBootstrapMaxLog = 64 << 10
+
+// NodeBootstrap describes a fresh server the panel installs the node agent on over SSH and
+// registers as a node.
+type NodeBootstrap struct {
+	Name     string `json:"name" form:"name"`
+	Group    string `json:"group" form:"group"`
+	Host     string `json:"host" form:"host"`
+	Inbounds string `json:"inbounds" form:"inbounds"`
+
+	SshHost     string `json:"sshHost" form:"sshHost"`
+	SshPort     int    `json:"sshPort" form:"sshPort"`
+	SshUser     string `json:"sshUser" form:"sshUser"`
+	SshPassword string `json:"sshPassword" form:"sshPassword"`
+	SshKey      string `json:"sshKey" form:"sshKey"`         // PEM private key, used instead of the password
+	SshHostKey  string `json:"sshHostKey" form:"sshHostKey"` // SHA256 fingerprint of the host key, as returned by GetNodeHostKey and confirmed by the administrator
+
+	AgentPort int    `json:"agentPort" form:"agentPort"` // Port of the node agent, 2097 if 0
+	PanelUrl  string `json:"panelUrl" form:"panelUrl"`   // URL the server reaches this panel at, including its base path
+	Version   string `json:"version" form:"version"`     // Release to install, the version of this panel if empty
+	Firewall  bool   `json:"firewall" form:"firewall"`   // Open the ports of the agent and of the inbounds in ufw or firewalld
+}
+
+// NodeBootstrapResult is the outcome of a bootstrap: the registered node, the host key of the
+// server and the output of the installation.
+type NodeBootstrapResult struct {
+	Node    *model.Node `json:"node"`
+	HostKey string      `json:"hostKey"`
+	Log     string      `json:"log"`
+}
+
+// BootstrapNode connects to a fresh server over SSH, installs the panel with its Xray core,
+// opens the firewall and registers the server as a node of this panel. The node is removed
+// again if the bootstrap fails, and the output of the installation is returned either way.
+func (s *NodeService) BootstrapNode(bootstrap *NodeBootstrap) (*NodeBootstrapResult, error) {
+	if err := checkNodeBootstrap(bootstrap); err != nil {
+		return nil, err
+	}
+	node := &model.Node{
+		Name:     bootstrap.Name,
+		Enable:   true,
+		Group:    bootstrap.Group,
+		Host:     bootstrap.Host,
+		Inbounds: bootstrap.Inbounds,
+	}
+	if err := s.AddNode(node); err != nil {
+		return nil, err
+	}
+
+	result, err := s.bootstrapNode(bootstrap, node)
+	if err == nil {
+		err = database.GetDB().Model(model.Node{}).Where("id = ?", node.Id).First(node).Error
+	}
+	if err == nil && node.Address == "" {
+		err = common.NewError("the node did not register with the panel")
+	}
+	if err != nil {
+		logger.Warning("Bootstrap node", node.Name, "failed:", err)
+		if delErr := s.DelNode(node.Id); delErr != nil {
+			logger.Warning("Delete node", node.Name, "failed:", delErr)
+		}
+		return result, err
+	}
+	logger.Info("Node", node.Name, "bootstrapped at", node.Address)
+	result.Node = node
+	return result, nil
+}
+
+// errNodeHostKeyRead ends the SSH handshake of GetNodeHostKey once the host key is known.
+var errNodeHostKeyRead = errors.New("host key read")
+
+// GetNodeHostKey connects to the SSH server of a server to bootstrap and returns the SHA256
+// fingerprint of its host key. The connection is closed before authenticating, so no credentials
+// are sent; the administrator confirms the fingerprint and passes it to BootstrapNode.
+func (s *NodeService) GetNodeHostKey(host string, port int) (string, error) {
+	host = strings.TrimSpace(host)
+	if host == "" {
+		return "", common.NewError("SSH host is required")
+	}
+	if port == 0 {
+		port = 22
+	}
+	if port < 1 || port > 65535 {
+		return "", common.NewError("invalid SSH port:", port)
+	}
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	conn, err := net.DialTimeout("tcp", address, nodeBootstrapDialTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(nodeBootstrapDialTimeout))
+
+	var fingerprint string
+	_, _, _, err = ssh.NewClientConn(conn, address, &ssh.ClientConfig{
+		User: "root",
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			fingerprint = ssh.FingerprintSHA256(key)
+			return errNodeHostKeyRead
+		},
+	})
+	if fingerprint == "" {
+		return "", err
+	}
+	return fingerprint, nil
+}
+
+// checkNodeBootstrap validates a bootstrap request and fills in its defaults.
+func checkNodeBootstrap(bootstrap *NodeBootstrap) error {
+	bootstrap.SshHost = strings.TrimSpace(bootstrap.SshHost)
+	if bootstrap.SshHost == "" {
+		return common.NewError("SSH host is required")
+	}
+	if bootstrap.SshPort == 0 {
+		bootstrap.SshPort = 22
+	}
+	if bootstrap.SshPort < 1 || bootstrap.SshPort > 65535 {
+		return common.NewError("invalid SSH port:", bootstrap.SshPort)
+	}
+	bootstrap.SshUser = strings.TrimSpace(bootstrap.SshUser)
+	if bootstrap.SshUser == "" {
+		bootstrap.SshUser = "root"
+	}
+	if bootstrap.SshPassword == "" && strings.TrimSpace(bootstrap.SshKey) == "" {
+		return common.NewError("SSH password or private key is required")
+	}
+	bootstrap.SshHostKey = strings.TrimSpace(bootstrap.SshHostKey)
+	if bootstrap.SshHostKey == "" {
+		return common.NewError("SSH host key fingerprint is required, get it and confirm it before the bootstrap")
+	}
+	if bootstrap.AgentPort == 0 {
+		bootstrap.AgentPort = 2097
+	}
+	if bootstrap.AgentPort < 1 || bootstrap.AgentPort > 65535 {
+		return common.NewError("invalid node agent port:", bootstrap.AgentPort)
+	}
+	panelUrl, err := url.Parse(strings.TrimSpace(bootstrap.PanelUrl))
+	if err != nil || (panelUrl.Scheme != "http" && panelUrl.Scheme != "https") || panelUrl.Host == "" {
+		return common.NewError("panel URL must be an http or https URL:", bootstrap.PanelUrl)
+	}
+	bootstrap.PanelUrl = panelUrl.String()
+	bootstrap.Version = strings.TrimSpace(bootstrap.Version)
+	if bootstrap.Version == "" {
+		bootstrap.Version = "v" + config.GetVersion()
+	}
+	if strings.ContainsAny(bootstrap.Version, "/ ") {
+		return common.NewError("invalid version:", bootstrap.Version)
+	}
+	return nil
+}
+
+// bootstrapNode runs the installation script on the server of a node.
+func (s *NodeService) bootstrapNode(bootstrap *NodeBootstrap, node *model.Node) (*NodeBootstrapResult, error) {
+	result := &NodeBootstrapResult{}
+	auth := []ssh.AuthMethod{}
+	if key := strings.TrimSpace(bootstrap.SshKey); key != "" {
+		signer, err := ssh.ParsePrivateKey([]byte(key))
+		if err != nil {
+			return result, common.NewError("invalid SSH private key:", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if bootstrap.SshPassword != "" {
+		auth = append(auth, ssh.Password(bootstrap.SshPassword))
+	}
+	client, err := ssh.Dial("tcp", net.JoinHostPort(bootstrap.SshHost, strconv.Itoa(bootstrap.SshPort)), &ssh.ClientConfig{
+		User: bootstrap.SshUser,
+		Auth: auth,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			result.HostKey = ssh.FingerprintSHA256(key)
+			if bootstrap.SshHostKey != result.HostKey {
+				return common.NewError("host key mismatch:", result.HostKey)
+			}
+			return nil
+		},
+		Timeout: nodeBootstrapDialTimeout,
+	})
+	if err != nil {
+		return result, err
+	}
+	defer client.Close()
+
+	inbounds, err := s.getNodeInbounds(node)
+	if err != nil {
+		return result, err
+	}
+	script := nodeBootstrapScript(bootstrap, node.Token, inbounds)
+
+	session, err := client.NewSession()
+	if err != nil {
+		return result, err
+	}
+	defer session.Close()
+	var output bytes.Buffer
+	session.Stdin = strings.NewReader(script)
+	session.Stdout = &output
+	command := "bash -s 2>&1"
+	if bootstrap.SshUser != "root" {
+		command = "sudo -n bash -s 2>&1"
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- session.Run(command) }()
+	select {
+	case err = <-done:
+	case <-time.After(nodeBootstrapRunTimeout):
+		client.Close()
+		<-done
+		err = common.NewError("installation timed out")
+	}
+	result.Log = nodeBootstrapLog(output.String())
+	return result, err
+}
+
+// nodeBootstrapScript returns the script installing the panel on a fresh server and registering
+// it with this panel. The panel of the server only listens on localhost, with random credentials,
+// as the node is managed from this panel.
+func nodeBootstrapScript(bootstrap *NodeBootstrap, token string, inbounds []*model.Inbound) string {
+	var tcpPorts, udpPorts []string
+	tcpPorts = append(tcpPorts, strconv.Itoa(bootstrap.AgentPort))
+	for _, inbound := range inbounds {
+		port := strconv.Itoa(inbound.Port)
+		tcpPorts = append(tcpPorts, port)
+		udpPorts = append(udpPorts, port)
+	}
+	address := "https://" + net.JoinHostPort(bootstrap.SshHost, strconv.Itoa(bootstrap.AgentPort))
+
+	var script strings.Builder
+	vars := [][2]string{
+		{"VERSION", bootstrap.Version},
+		{"PANEL_URL", bootstrap.PanelUrl},
+		{"TOKEN", token},
+		{"ADDRESS", address},
+		{"USERNAME", random.Seq(10)},
+		{"PASSWORD", random.Seq(16)},
+		{"BASE_PATH", random.Seq(18)},
+		{"FIREWALL", strconv.FormatBool(bootstrap.Firewall)},
+		{"TCP_PORTS", strings.Join(tcpPorts, " ")},
+		{"UDP_PORTS", strings.Join(udpPorts, " ")},
+	}
+	for _, v := range vars {
+		fmt.Fprintf(&script, "%s=%s\n", v[0], shellQuote(v[1]))
+	}
+	script.WriteString(nodeBootstrapBody)
+	return script.String()
+}
+
+// nodeBootstrapLog keeps the end of the installation output.
+func nodeBootstrapLog(output string) string {
+	if len(output) > nodeBootstrapMaxLog {
+		output = output[len(output)-nodeBootstrapMaxLog:]
+	}
+	return output
+}
+
+// shellQuote quotes a string as a single word of a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// nodeBootstrapBody installs the panel like install.sh does, without its prompts.
+const nodeBootstrapBody = `
+set -e
+
+arch() {
+    case "$(uname -m)" in
+    x86_64 | x64 | amd64) echo 'amd64' ;;
+    i*86 | x86) echo '386' ;;
+    armv8* | armv8 | arm64 | aarch64) echo 'arm64' ;;
+    armv7* | armv7 | arm) echo 'armv7' ;;
+    armv6* | armv6) echo 'armv6' ;;
+    armv5* | armv5) echo 'armv5' ;;
+    s390x) echo 's390x' ;;
+    *) echo "Unsupported CPU architecture: $(uname -m)" >&2 && exit 1 ;;
+    esac
+}
+
+release=""
+if [[ -f /etc/os-release ]]; then
+    source /etc/os-release
+    release=$ID
+fi
+echo "OS: ${release}, arch: $(arch)"
+
+echo "Installing base packages..."
+case "${release}" in
+ubuntu | debian | armbian)
+    apt-get update -q && apt-get install -y -q wget curl tar tzdata
+    ;;
+centos | rhel | almalinux | rocky | ol | fedora | amzn | virtuozzo)
+    if command -v dnf >/dev/null; then dnf install -y -q wget curl tar tzdata; else yum install -y -q wget curl tar tzdata; fi
+    ;;
+arch | manjaro | parch)
+    pacman -Sy --noconfirm wget curl tar tzdata
+    ;;
+alpine)
+    apk update && apk add bash wget curl tar tzdata
+    ;;
+*)
+    echo "Unknown OS, assuming wget, curl and tar are installed"
+    ;;
+esac
+
+echo "Downloading x-ui ${VERSION}..."
+cd /usr/local/
+wget -q -O x-ui-linux-$(arch).tar.gz "https://github.com/MHSanaei/3x-ui/releases/download/${VERSION}/x-ui-linux-$(arch).tar.gz"
+wget -q -O /usr/bin/x-ui-temp https://raw.githubusercontent.com/MHSanaei/3x-ui/main/x-ui.sh
+
+if [[ -e /usr/local/x-ui/ ]]; then
+    if [[ $release == "alpine" ]]; then rc-service x-ui stop || true; else systemctl stop x-ui || true; fi
+    rm /usr/local/x-ui/ -rf
+fi
+tar zxf x-ui-linux-$(arch).tar.gz
+rm x-ui-linux-$(arch).tar.gz -f
+cd x-ui
+chmod +x x-ui x-ui.sh
+if [[ $(arch) == "armv5" || $(arch) == "armv6" || $(arch) == "armv7" ]]; then
+    mv bin/xray-linux-$(arch) bin/xray-linux-arm
+    chmod +x bin/xray-linux-arm
+else
+    chmod +x bin/xray-linux-$(arch)
+fi
+mv -f /usr/bin/x-ui-temp /usr/bin/x-ui
+chmod +x /usr/bin/x-ui
+
+echo "Configuring the panel..."
+./x-ui setting -username "${USERNAME}" -password "${PASSWORD}" -webBasePath "${BASE_PATH}" -listenIP 127.0.0.1 >/dev/null
+./x-ui migrate >/dev/null
+
+echo "Registering the node at ${ADDRESS}..."
+output=$(./x-ui node -panel "${PANEL_URL}" -token "${TOKEN}" -address "${ADDRESS}")
+echo "${output}"
+case "${output}" in
+*"register node success"*) ;;
+*) exit 1 ;;
+esac
+
+if [[ "${FIREWALL}" == "true" ]]; then
+    if command -v ufw >/dev/null && ufw status | grep -q "Status: active"; then
+        echo "Opening ports in ufw..."
+        for port in ${TCP_PORTS}; do ufw allow "${port}/tcp" >/dev/null; done
+        for port in ${UDP_PORTS}; do ufw allow "${port}/udp" >/dev/null; done
+    elif command -v firewall-cmd >/dev/null && firewall-cmd --state >/dev/null 2>&1; then
+        echo "Opening ports in firewalld..."
+        for port in ${TCP_PORTS}; do firewall-cmd --permanent --add-port="${port}/tcp" >/dev/null; done
+        for port in ${UDP_PORTS}; do firewall-cmd --permanent --add-port="${port}/udp" >/dev/null; done
+        firewall-cmd --reload >/dev/null
+    else
+        echo "No active firewall found, no ports opened"
+    fi
+fi
+
+echo "Starting the service..."
+if [[ $release == "alpine" ]]; then
+    wget -q -O /etc/init.d/x-ui https://raw.githubusercontent.com/MHSanaei/3x-ui/main/x-ui.rc
+    chmod +x /etc/init.d/x-ui
+    rc-update add x-ui
+    rc-service x-ui restart
+else
+    cp -f x-ui.service /etc/systemd/system/
+    systemctl daemon-reload
+    systemctl enable x-ui
+    systemctl restart x-ui
+fi
+echo "x-ui ${VERSION} installed as a node"
+`
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+)
+
+// nodeHeartbeatTimeout is how long a node may go without a successful sync before it is unhealthy.
+const nodeHeartbeatTimeout = 2 * time.Minute
+
+// NodeEndpoint is a node as the subscriptions see it.
+type NodeEndpoint struct {
+	Name    string
+	Host    string
+	Healthy bool
+	Load    float64             // Load of the node as a fraction, see NodeLoad
+	Flag    string              // Flag emoji of the region of the node
+	Groups  []string            // Proxy groups of the node in subscriptions: its region, then its labels
+	Clients map[string][]string // Emails of the clients pushed to the node, by inbound tag
+}
+
+// nodeHost returns the address of a node in subscription links.
+func nodeHost(node *model.Node) string {
+	if node.Host != "" {
+		return node.Host
+	}
+	agentUrl, err := url.Parse(node.Address)
+	if err != nil {
+		return ""
+	}
+	return agentUrl.Hostname()
+}
+
+// CheckNodesHealth checks every enabled, registered node: it is healthy while it keeps syncing
+// and at least one of its inbounds is reachable from the outside, like the reachability check of
+// this server's inbounds probes them.
+func (s *NodeService) CheckNodesHealth() {
+	nodes, err := s.GetNodes()
+	if err != nil {
+		logger.Warning("Get nodes failed:", err)
+		return
+	}
+	probe, err := s.settingService.GetReachabilityProbeUrl()
+	if err != nil {
+		logger.Warning("Get reachability probe URL failed:", err)
+	}
+	for _, node := range nodes {
+		if !node.Enable || node.Address == "" {
+			continue
+		}
+		healthy, reason := s.checkNodeHealth(node, probe)
+		if healthy != node.Healthy {
+			if healthy {
+				logger.Info("Node", node.Name, "is healthy again")
+			} else {
+				logger.Warning("Node", node.Name, "is unhealthy:", reason)
+			}
+		}
+		// Written only on a change, the subscriptions are rendered again after every write
+		if healthy == node.Healthy && reason == node.HealthError {
+			continue
+		}
+		err := database.GetDB().Model(node).Updates(map[string]any{"healthy": healthy, "health_error": reason}).Error
+		if err != nil {
+			logger.Warning("Update node", node.Name, "failed:", err)
+		}
+	}
+}
+
+// checkNodeHealth reports whether a node is healthy, and why not.
+func (s *NodeService) checkNodeHealth(node *model.Node, probe string) (bool, string) {
+	if time.Since(time.Unix(node.LastSeen, 0)) > nodeHeartbeatTimeout {
+		return false, "no successful sync since " + time.Unix(node.LastSeen, 0).Format("2006-01-02 15:04:05")
+	}
+	host := nodeHost(node)
+	if host == "" {
+		return false, "the address of the node is not valid"
+	}
+	inbounds, err := s.getNodeInbounds(node)
+	if err != nil {
+		return false, err.Error()
+	}
+
+	// Nodes without inbounds that can be checked count as healthy while they sync
+	reason := ""
+	for _, inbound := range inbounds {
+		if !inbound.Enable || inbound.Port <= 0 {
+			continue
+		}
+		result := checkInboundReachability(inbound, host, probe)
+		if result.Skipped {
+			continue
+		}
+		if result.OK() {
+			return true, ""
+		}
+		if reason == "" {
+			reason = inbound.Tag + " is not reachable: " + result.Error
+		}
+	}
+	return reason == "", reason
+}
+
+// GetNodeEndpoints returns the enabled, registered nodes with the clients of every inbound
+// pushed to them, for the subscriptions to add links to the nodes.
+func (s *NodeService) GetNodeEndpoints() ([]*NodeEndpoint, error) {
+	nodes, err := s.GetNodes()
+	if err != nil {
+		return nil, err
+	}
+	endpoints := make([]*NodeEndpoint, 0, len(nodes))
+	for _, node := range nodes {
+		host := nodeHost(node)
+		if !node.Enable || node.Address == "" || host == "" {
+			continue
+		}
+		inbounds, err := s.getNodeInbounds(node)
+		if err != nil {
+			return nil, err
+		}
+		endpoint := &NodeEndpoint{
+			Name:    node.Name,
+			Host:    host,
+			Healthy: node.Healthy,
+			Load:    getNodeLoad(node).Load,
+			Flag:    node.Flag,
+			Groups:  nodeProxyGroups(node),
+			Clients: make(map[string][]string, len(inbounds)),
+		}
+		for _, inbound := range inbounds {
+			if !inbound.Enable {
+				continue
+			}
+			for _, clientTraffic := range inbound.ClientStats {
+				endpoint.Clients[inbound.Tag] = append(endpoint.Clients[inbound.Tag], clientTraffic.Email)
+			}
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, nil
+}
+
+// nodeProxyGroups returns the names of the proxy groups the proxies of a node go in.
+func nodeProxyGroups(node *model.Node) []string {
+	var groups []string
+	if node.Region != "" {
+		groups = append(groups, strings.TrimSpace(node.Flag+" "+node.Region))
+	}
+	for _, label := range splitRuleList(node.Labels) {
+		if !slices.Contains(groups, label) {
+			groups = append(groups, label)
+		}
+	}
+	return groups
+}
@@ -0,0 +1,189 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// nodeAgentOfflineAfter is how long a node agent goes without hearing from its panel before it
+// enforces the limits of the managed clients itself.
+const nodeAgentOfflineAfter = 2 * time.Minute
+
+var (
+	// nodeAgentContact is when the panel last called the node agent. It starts at the start of
+	// the process, so that a node rebooted during an outage waits as long before enforcing.
+	nodeAgentContact   = time.Now()
+	nodeAgentContactMu sync.Mutex
+)
+
+// NodeClientLimit is the quota and the expiry of a client the panel sends its nodes on every
+// sync, along with the traffic the client used across all servers.
+type NodeClientLimit struct {
+	Email      string `json:"email"`
+	Total      int64  `json:"total"`      // Bytes, 0 for unlimited
+	ExpiryTime int64  `json:"expiryTime"` // Unix milliseconds, 0 for never
+	Used       int64  `json:"used"`       // Bytes used on the panel, including the traffic of all nodes
+}
+
+// nodeAgentLimit is a client limit as the node agent keeps it: Base is the local traffic counter
+// of the client when the panel sent the limit, the traffic used on this server since is added to
+// the usage the panel reported.
+type nodeAgentLimit struct {
+	NodeClientLimit
+	Base int64 `json:"base"`
+}
+
+// getNodeClientLimits returns the limits of the clients pushed to a node that have any.
+func getNodeClientLimits(inbounds []*model.Inbound) ([]*NodeClientLimit, error) {
+	var emails []string
+	for _, inbound := range inbounds {
+		for _, clientTraffic := range inbound.ClientStats {
+			emails = append(emails, clientTraffic.Email)
+		}
+	}
+	limits := []*NodeClientLimit{}
+	if len(emails) == 0 {
+		return limits, nil
+	}
+	var traffics []*xray.ClientTraffic
+	err := database.GetDB().Model(xray.ClientTraffic{}).
+		Where("email IN ? AND (total > 0 OR expiry_time > 0)", emails).
+		Find(&traffics).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, traffic := range traffics {
+		limits = append(limits, &NodeClientLimit{
+			Email:      traffic.Email,
+			Total:      traffic.Total,
+			ExpiryTime: traffic.ExpiryTime,
+			Used:       traffic.Up + traffic.Down,
+		})
+	}
+	return limits, nil
+}
+
+// markNodeAgentContact records that the panel called the node agent.
+func markNodeAgentContact() {
+	nodeAgentContactMu.Lock()
+	nodeAgentContact = time.Now()
+	nodeAgentContactMu.Unlock()
+}
+
+// nodeAgentOffline reports whether the panel has not called the node agent for a while.
+func nodeAgentOffline() bool {
+	nodeAgentContactMu.Lock()
+	defer nodeAgentContactMu.Unlock()
+	return time.Since(nodeAgentContact) > nodeAgentOfflineAfter
+}
+
+// SetClientLimits saves the limits of the managed clients sent by the panel, for the node agent to
+// enforce them while the panel cannot be reached.
+func (s *NodeAgentService) SetClientLimits(limits []*NodeClientLimit) error {
+	markNodeAgentContact()
+	tags, err := s.getManagedTags()
+	if err != nil {
+		return err
+	}
+	inbounds, err := getManagedInbounds(database.GetDB(), tags)
+	if err != nil {
+		return err
+	}
+	counters := make(map[string]int64)
+	for _, inbound := range inbounds {
+		for _, clientTraffic := range inbound.ClientStats {
+			counters[clientTraffic.Email] = clientTraffic.Up + clientTraffic.Down
+		}
+	}
+
+	saved := make(map[string]*nodeAgentLimit, len(limits))
+	for _, limit := range limits {
+		saved[limit.Email] = &nodeAgentLimit{NodeClientLimit: *limit, Base: counters[limit.Email]}
+	}
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	return s.settingService.saveSetting("nodeAgentLimits", string(data))
+}
+
+// EnforceClientLimits disables the managed clients that ran out of traffic or expired, while the
+// panel cannot be reached. The traffic of a client is what the panel last reported plus what it
+// used on this server since. The panel takes over again once it is back: it adds up the traffic
+// the node used meanwhile and pushes the inbounds, with the state of the clients it decides.
+func (s *NodeAgentService) EnforceClientLimits() {
+	if enable, err := s.settingService.GetNodeAgentEnable(); err != nil || !enable || !nodeAgentOffline() {
+		return
+	}
+	data, err := s.settingService.getString("nodeAgentLimits")
+	if err != nil || data == "" {
+		return
+	}
+	var limits map[string]*nodeAgentLimit
+	if err := json.Unmarshal([]byte(data), &limits); err != nil {
+		logger.Warning("Node agent: invalid client limits:", err)
+		return
+	}
+	tags, err := s.getManagedTags()
+	if err != nil {
+		logger.Warning("Node agent: get managed inbounds failed:", err)
+		return
+	}
+	db := database.GetDB()
+	inbounds, err := getManagedInbounds(db, tags)
+	if err != nil {
+		logger.Warning("Node agent: get managed inbounds failed:", err)
+		return
+	}
+
+	now := time.Now().UnixMilli()
+	needRestart := false
+	var api xray.XrayAPI
+	apiReady := false
+	if p != nil && api.Init(p.GetAPIPort()) == nil {
+		apiReady = true
+		defer api.Close()
+	}
+	for _, inbound := range inbounds {
+		for _, clientTraffic := range inbound.ClientStats {
+			limit := limits[clientTraffic.Email]
+			if !clientTraffic.Enable || limit == nil {
+				continue
+			}
+			local := clientTraffic.Up + clientTraffic.Down
+			if local >= limit.Base {
+				local -= limit.Base
+			}
+			exhausted := limit.Total > 0 && limit.Used+local >= limit.Total
+			expired := limit.ExpiryTime > 0 && limit.ExpiryTime <= now
+			if !exhausted && !expired {
+				continue
+			}
+			err := db.Model(xray.ClientTraffic{}).Where("id = ?", clientTraffic.Id).Update("enable", false).Error
+			if err != nil {
+				logger.Warning("Node agent: disable client", clientTraffic.Email, "failed:", err)
+				continue
+			}
+			logger.Warning("Node agent: panel unreachable, disabled client", clientTraffic.Email, "that ran out of traffic or expired")
+			if p == nil {
+				continue
+			}
+			if !apiReady {
+				needRestart = true
+			} else if err := api.RemoveUser(inbound.Tag, clientTraffic.Email); err != nil && !strings.Contains(err.Error(), "not found") {
+				logger.Debug("Node agent: remove user by api failed:", err)
+				needRestart = true
+			}
+		}
+	}
+	if needRestart {
+		s.xrayService.SetToNeedRestart()
+	}
+}
@@ -0,0 +1,252 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+)
+
+// Validity of the certificates of the node authority, of the nodes and of the panel.
+const (
+	nodeCAValidity    = 20 * 365 * 24 * time.Hour
+	nodeCertValidity  = 3 * 365 * 24 * time.Hour
+	nodePanelValidity = 365 * 24 * time.Hour
+)
+
+var (
+	nodeCAMu        sync.Mutex
+	nodePanelCert   *tls.Certificate
+	nodePanelCertMu sync.Mutex
+)
+
+// getNodeCA returns the certificate authority the panel signs the certificates of its nodes
+// and its own client certificate with, generating and saving it on first use.
+func (s *SettingService) getNodeCA() (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	nodeCAMu.Lock()
+	defer nodeCAMu.Unlock()
+
+	certPEM, err := s.getString("nodeCaCert")
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := s.getString("nodeCaKey")
+	if err != nil {
+		return nil, nil, err
+	}
+	if certPEM != "" && keyPEM != "" {
+		return parseNodeKeyPair(certPEM, keyPEM)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template, err := nodeCertTemplate("3x-ui node authority", nodeCAValidity)
+	if err != nil {
+		return nil, nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	keyPEM, err = encodeNodeKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.saveSetting("nodeCaCert", certPEM); err != nil {
+		return nil, nil, err
+	}
+	if err := s.saveSetting("nodeCaKey", keyPEM); err != nil {
+		return nil, nil, err
+	}
+	return parseNodeKeyPair(certPEM, keyPEM)
+}
+
+// signNodeCertificate signs the certificate request of a node agent reachable at host. The
+// certificate is only valid to serve the agent, not to call other nodes.
+func (s *SettingService) signNodeCertificate(csrPEM string, host string) (string, time.Time, error) {
+	block, _ := pem.Decode([]byte(csrPEM))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return "", time.Time{}, common.NewError("invalid certificate request")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return "", time.Time{}, err
+	}
+	caCert, caKey, err := s.getNodeCA()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	template, err := nodeCertTemplate(host, nodeCertValidity)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, csr.PublicKey, caKey)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), template.NotAfter, nil
+}
+
+// getNodeCAPEM returns the certificate of the node authority.
+func (s *SettingService) getNodeCAPEM() (string, error) {
+	caCert, _, err := s.getNodeCA()
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})), nil
+}
+
+// nodeClientTLSConfig returns the TLS configuration the panel calls its nodes with: it presents
+// a client certificate of the node authority and only trusts nodes certified by it.
+func (s *SettingService) nodeClientTLSConfig() (*tls.Config, error) {
+	caCert, caKey, err := s.getNodeCA()
+	if err != nil {
+		return nil, err
+	}
+
+	nodePanelCertMu.Lock()
+	defer nodePanelCertMu.Unlock()
+	if nodePanelCert == nil || time.Now().Add(24*time.Hour).After(nodePanelCert.Leaf.NotAfter) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		template, err := nodeCertTemplate("3x-ui panel", nodePanelValidity)
+		if err != nil {
+			return nil, err
+		}
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		if err != nil {
+			return nil, err
+		}
+		leaf, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		nodePanelCert = &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	return &tls.Config{
+		Certificates: []tls.Certificate{*nodePanelCert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// NodeAgentTLSConfig returns the TLS configuration of the node agent: it serves the certificate
+// issued at registration and only accepts the client certificates of the panel it registered with.
+func (s *SettingService) NodeAgentTLSConfig() (*tls.Config, error) {
+	certPEM, err := s.getString("nodeAgentCert")
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := s.getString("nodeAgentKey")
+	if err != nil {
+		return nil, err
+	}
+	caPEM, err := s.getString("nodeAgentCa")
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(caPEM)) {
+		return nil, common.NewError("invalid certificate of the panel")
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// newNodeKeyRequest generates the private key of a node agent and a certificate request for it.
+func newNodeKeyRequest(host string) (keyPEM string, csrPEM string, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: host},
+	}, key)
+	if err != nil {
+		return "", "", err
+	}
+	keyPEM, err = encodeNodeKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	return keyPEM, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})), nil
+}
+
+func nodeCertTemplate(commonName string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(validity),
+	}, nil
+}
+
+func encodeNodeKey(key *ecdsa.PrivateKey) (string, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})), nil
+}
+
+func parseNodeKeyPair(certPEM string, keyPEM string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	pair, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, nil, common.NewError("unsupported key of the node authority")
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
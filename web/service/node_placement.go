@@ -0,0 +1,214 @@
+package service
+
+import (
+	"encoding/json"
+	"slices"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+
+	"gorm.io/gorm"
+)
+
+// nodeMinHeadroom keeps fully loaded nodes eligible for placement, at a much lower weight.
+const nodeMinHeadroom = 0.05
+
+// NodeLoad is the load of a node, derived from the status its agent reported on the last sync.
+type NodeLoad struct {
+	Id        int     `json:"id"`
+	Name      string  `json:"name"`
+	Group     string  `json:"group"`
+	Healthy   bool    `json:"healthy"`
+	Cpu       float64 `json:"cpu"`       // Percent
+	Mem       float64 `json:"mem"`       // Percent
+	Bandwidth float64 `json:"bandwidth"` // Percent of the uplink in use, 0 if the bandwidth of the node is unknown
+	Load      float64 `json:"load"`      // Highest of the above, as a fraction
+	Clients   int     `json:"clients"`   // Clients placed on the node by placement sync policies
+}
+
+// getNodeLoad returns the load of a node. Nodes that have not reported a status yet count as idle.
+func getNodeLoad(node *model.Node) *NodeLoad {
+	load := &NodeLoad{Id: node.Id, Name: node.Name, Group: node.Group, Healthy: node.Healthy}
+	var status Status
+	if node.Status == "" || json.Unmarshal([]byte(node.Status), &status) != nil {
+		return load
+	}
+	load.Cpu = status.Cpu
+	if status.Mem.Total > 0 {
+		load.Mem = float64(status.Mem.Current) * 100 / float64(status.Mem.Total)
+	}
+	if node.Bandwidth > 0 {
+		bitsPerSecond := float64(max(status.NetIO.Up, status.NetIO.Down)) * 8
+		load.Bandwidth = bitsPerSecond * 100 / (float64(node.Bandwidth) * 1e6)
+	}
+	load.Load = max(load.Cpu, load.Mem, load.Bandwidth) / 100
+	return load
+}
+
+// GetNodeLoads returns the load of every node.
+func (s *NodeService) GetNodeLoads() ([]*NodeLoad, error) {
+	nodes, err := s.GetNodes()
+	if err != nil {
+		return nil, err
+	}
+	var counts []struct {
+		NodeId int
+		Count  int
+	}
+	err = database.GetDB().Model(model.NodePlacement{}).Select("node_id, count(*) as count").Group("node_id").Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	loads := make([]*NodeLoad, 0, len(nodes))
+	for _, node := range nodes {
+		load := getNodeLoad(node)
+		for _, count := range counts {
+			if count.NodeId == node.Id {
+				load.Clients = count.Count
+			}
+		}
+		loads = append(loads, load)
+	}
+	return loads, nil
+}
+
+// getPlacedNodeClients returns the emails of the clients of a placement sync policy placed on a node.
+func getPlacedNodeClients(policyId int, nodeId int) ([]string, error) {
+	var emails []string
+	err := database.GetDB().Model(model.NodePlacement{}).
+		Where("policy_id = ? AND node_id = ?", policyId, nodeId).
+		Pluck("email", &emails).Error
+	return emails, err
+}
+
+// placeNodeClients places the clients of every placement sync policy that are not placed yet, or
+// whose node left the group, on a node of the group. Each goes to the node with the fewest placed
+// clients for its headroom, so that users spread evenly and busy nodes receive fewer of them.
+// Healthy nodes are preferred, and placed clients stay where they are.
+func (s *NodeService) placeNodeClients() error {
+	db := database.GetDB()
+	policies, err := s.GetNodeSyncPolicies()
+	if err != nil {
+		return err
+	}
+	policyIds := []int{0} // Never a policy ID, keeps NOT IN valid without placement policies
+	for _, policy := range policies {
+		if policy.Enable && policy.Placement {
+			policyIds = append(policyIds, policy.Id)
+		}
+	}
+	if err := db.Where("policy_id NOT IN ?", policyIds).Delete(model.NodePlacement{}).Error; err != nil {
+		return err
+	}
+	if len(policyIds) == 1 {
+		return nil
+	}
+
+	nodes, err := s.GetNodes()
+	if err != nil {
+		return err
+	}
+	var inbounds []*model.Inbound
+	if err := db.Model(model.Inbound{}).Preload("ClientStats").Find(&inbounds).Error; err != nil {
+		return err
+	}
+	for _, policy := range policies {
+		if !policy.Enable || !policy.Placement {
+			continue
+		}
+		if err := placePolicyClients(db, policy, nodes, inbounds); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// placePolicyClients places the clients of a placement sync policy.
+func placePolicyClients(db *gorm.DB, policy *model.NodeSyncPolicy, nodes []*model.Node, inbounds []*model.Inbound) error {
+	var candidates []*NodeLoad
+	for _, node := range nodes {
+		if node.Enable && node.Address != "" && (policy.NodeGroup == "" || policy.NodeGroup == node.Group) {
+			candidates = append(candidates, getNodeLoad(node))
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	policyIds, _ := parseNodeInboundIds(policy.Inbounds)
+	groups := splitRuleList(policy.Groups)
+	patterns := splitRuleList(policy.Clients)
+	var emails []string
+	for _, inbound := range inbounds {
+		if !slices.Contains(policyIds, inbound.Id) && (inbound.GroupName == "" || !slices.Contains(groups, inbound.GroupName)) {
+			continue
+		}
+		for _, clientTraffic := range inbound.ClientStats {
+			if (len(patterns) == 0 || matchesNodeClient(patterns, clientTraffic.Email)) && !slices.Contains(emails, clientTraffic.Email) {
+				emails = append(emails, clientTraffic.Email)
+			}
+		}
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var placements []*model.NodePlacement
+		if err := tx.Where("policy_id = ?", policy.Id).Find(&placements).Error; err != nil {
+			return err
+		}
+		placed := make(map[string]*model.NodePlacement, len(placements))
+		for _, placement := range placements {
+			if !slices.Contains(emails, placement.Email) {
+				if err := tx.Delete(placement).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			placed[placement.Email] = placement
+			for _, candidate := range candidates {
+				if candidate.Id == placement.NodeId {
+					candidate.Clients++
+				}
+			}
+		}
+
+		moved := 0
+		for _, email := range emails {
+			placement := placed[email]
+			if placement != nil && slices.ContainsFunc(candidates, func(c *NodeLoad) bool { return c.Id == placement.NodeId }) {
+				continue
+			}
+			target := pickNodeForClient(candidates)
+			target.Clients++
+			if placement == nil {
+				placement = &model.NodePlacement{PolicyId: policy.Id, Email: email}
+			}
+			placement.NodeId = target.Id
+			if err := tx.Save(placement).Error; err != nil {
+				return err
+			}
+			moved++
+		}
+		if moved > 0 {
+			logger.Infof("Placed %d clients of sync policy %s on its nodes", moved, policy.Name)
+		}
+		return nil
+	})
+}
+
+// pickNodeForClient returns the node a new client is placed on: the one with the fewest placed
+// clients for its headroom, among the healthy nodes if there are any.
+func pickNodeForClient(candidates []*NodeLoad) *NodeLoad {
+	var best *NodeLoad
+	var bestScore float64
+	for _, candidate := range candidates {
+		if best != nil && best.Healthy && !candidate.Healthy {
+			continue
+		}
+		score := float64(candidate.Clients+1) / max(1-candidate.Load, nodeMinHeadroom)
+		if best == nil || (candidate.Healthy && !best.Healthy) || score < bestScore {
+			best, bestScore = candidate, score
+		}
+	}
+	return best
+}
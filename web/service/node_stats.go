@@ -0,0 +1,159 @@
+package service
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// nodeOnlineTimeout is how long the online clients reported by a node count after its last sync.
+const nodeOnlineTimeout = 2 * time.Minute
+
+type nodeOnline struct {
+	emails []string
+	seen   time.Time
+}
+
+var (
+	nodeOnlineClients   = make(map[int]*nodeOnline)
+	nodeOnlineClientsMu sync.RWMutex
+)
+
+// NodeClientUsage is the share of a client's usage contributed by one server.
+type NodeClientUsage struct {
+	NodeId     int    `json:"nodeId"` // 0 for this server
+	Name       string `json:"name"`   // Node name, empty for this server
+	Up         int64  `json:"up"`
+	Down       int64  `json:"down"`
+	Online     bool   `json:"online"`
+	LastOnline int64  `json:"lastOnline"` // Unix milliseconds
+}
+
+// setNodeOnlineClients records the clients a node reported online.
+func setNodeOnlineClients(nodeId int, emails []string) {
+	nodeOnlineClientsMu.Lock()
+	defer nodeOnlineClientsMu.Unlock()
+	nodeOnlineClients[nodeId] = &nodeOnline{emails: emails, seen: time.Now()}
+}
+
+// getNodeOnlineClients returns the clients online on a node, if it synced recently.
+func getNodeOnlineClients(nodeId int) []string {
+	nodeOnlineClientsMu.RLock()
+	defer nodeOnlineClientsMu.RUnlock()
+	online, ok := nodeOnlineClients[nodeId]
+	if !ok || time.Since(online.seen) > nodeOnlineTimeout {
+		return nil
+	}
+	return online.emails
+}
+
+// getOnlineClients returns the clients online on this server or on any node that synced recently.
+func getOnlineClients() []string {
+	var emails []string
+	if p != nil {
+		emails = append(emails, p.GetOnlineClients()...)
+	}
+	nodeOnlineClientsMu.RLock()
+	defer nodeOnlineClientsMu.RUnlock()
+	for _, online := range nodeOnlineClients {
+		if time.Since(online.seen) > nodeOnlineTimeout {
+			continue
+		}
+		for _, email := range online.emails {
+			if !slices.Contains(emails, email) {
+				emails = append(emails, email)
+			}
+		}
+	}
+	return emails
+}
+
+// getPanelUsage returns the usage on the panel of the inbounds and clients a node reported, by
+// kind and name.
+func (s *NodeService) getPanelUsage(stats *NodeAgentStats) (map[string]int64, error) {
+	db := database.GetDB()
+	totals := make(map[string]int64, len(stats.Inbounds)+len(stats.Clients))
+
+	tags := make([]string, 0, len(stats.Inbounds))
+	for _, traffic := range stats.Inbounds {
+		tags = append(tags, traffic.Name)
+	}
+	if len(tags) > 0 {
+		var inbounds []*model.Inbound
+		if err := db.Model(model.Inbound{}).Select("tag, up, down").Where("tag IN ?", tags).Find(&inbounds).Error; err != nil {
+			return nil, err
+		}
+		for _, inbound := range inbounds {
+			totals["inbound:"+inbound.Tag] = inbound.Up + inbound.Down
+		}
+	}
+
+	emails := make([]string, 0, len(stats.Clients))
+	for _, traffic := range stats.Clients {
+		emails = append(emails, traffic.Name)
+	}
+	if len(emails) > 0 {
+		var clientTraffics []*xray.ClientTraffic
+		if err := db.Model(xray.ClientTraffic{}).Select("email, up, down").Where("email IN ?", emails).Find(&clientTraffics).Error; err != nil {
+			return nil, err
+		}
+		for _, clientTraffic := range clientTraffics {
+			totals["client:"+clientTraffic.Email] = clientTraffic.Up + clientTraffic.Down
+		}
+	}
+	return totals, nil
+}
+
+// GetClientNodeUsage breaks the usage of a client down by the servers it was used on: the nodes
+// and this server, which is credited with the usage no node accounts for.
+func (s *NodeService) GetClientNodeUsage(email string) ([]*NodeClientUsage, error) {
+	db := database.GetDB()
+	clientTraffic := &xray.ClientTraffic{}
+	if err := db.Model(xray.ClientTraffic{}).Where("email = ?", email).First(clientTraffic).Error; err != nil {
+		return nil, err
+	}
+	nodes, err := s.GetNodes()
+	if err != nil {
+		return nil, err
+	}
+	var counters []*model.NodeTraffic
+	if err := db.Where("kind = ? AND name = ?", "client", email).Find(&counters).Error; err != nil {
+		return nil, err
+	}
+
+	local := &NodeClientUsage{
+		Up:         clientTraffic.Up,
+		Down:       clientTraffic.Down,
+		Online:     p != nil && slices.Contains(p.GetOnlineClients(), email),
+		LastOnline: clientTraffic.LastOnline,
+	}
+	usages := []*NodeClientUsage{local}
+	for _, node := range nodes {
+		index := slices.IndexFunc(counters, func(counter *model.NodeTraffic) bool { return counter.NodeId == node.Id })
+		if index < 0 {
+			continue
+		}
+		counter := counters[index]
+		// Contributions from before a reset the node did not sync since are left out
+		if counter.Seen > clientTraffic.Up+clientTraffic.Down {
+			continue
+		}
+		usages = append(usages, &NodeClientUsage{
+			NodeId:     node.Id,
+			Name:       node.Name,
+			Up:         counter.UsedUp,
+			Down:       counter.UsedDown,
+			Online:     slices.Contains(getNodeOnlineClients(node.Id), email),
+			LastOnline: counter.LastOnline,
+		})
+		local.Up -= counter.UsedUp
+		local.Down -= counter.UsedDown
+	}
+	local.Up = max(local.Up, 0)
+	local.Down = max(local.Down, 0)
+	return usages, nil
+}
@@ -0,0 +1,342 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// Sync states of a node.
+const (
+	NodeSyncSynced       = "synced"       // The node runs the current inbounds
+	NodeSyncPending      = "pending"      // The inbounds changed since the last push
+	NodeSyncConflict     = "conflict"     // The node skipped inbounds that clash with its local ones
+	NodeSyncError        = "error"        // The last sync failed
+	NodeSyncDisabled     = "disabled"     // The node is disabled
+	NodeSyncUnregistered = "unregistered" // The node agent did not register yet
+)
+
+// NodeSyncStatus is the sync state of a node.
+type NodeSyncStatus struct {
+	Id        int      `json:"id"`
+	Name      string   `json:"name"`
+	Group     string   `json:"group"`
+	Status    string   `json:"status"`
+	Inbounds  int      `json:"inbounds"`  // Inbounds pushed to the node
+	Clients   int      `json:"clients"`   // Clients pushed to the node
+	Conflicts []string `json:"conflicts"` // Conflicts the node reported on the last push
+	LastSeen  int64    `json:"lastSeen"`
+	LastSync  int64    `json:"lastSync"`
+	LastError string   `json:"lastError"`
+}
+
+// GetNodeSyncPolicies returns all sync policies.
+func (s *NodeService) GetNodeSyncPolicies() ([]*model.NodeSyncPolicy, error) {
+	db := database.GetDB()
+	var policies []*model.NodeSyncPolicy
+	err := db.Model(model.NodeSyncPolicy{}).Find(&policies).Error
+	if err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+// checkNodeSyncPolicy validates a sync policy and normalizes its lists.
+func (s *NodeService) checkNodeSyncPolicy(policy *model.NodeSyncPolicy) error {
+	policy.Name = strings.TrimSpace(policy.Name)
+	if policy.Name == "" {
+		return common.NewError("Sync policy name is required")
+	}
+	policy.NodeGroup = strings.TrimSpace(policy.NodeGroup)
+	if _, err := parseNodeInboundIds(policy.Inbounds); err != nil {
+		return err
+	}
+	for _, pattern := range splitRuleList(policy.Clients) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return common.NewError("Invalid client pattern:", pattern)
+		}
+	}
+	policy.Inbounds = strings.Join(splitRuleList(policy.Inbounds), ",")
+	policy.Groups = strings.Join(splitRuleList(policy.Groups), ",")
+	policy.Clients = strings.Join(splitRuleList(policy.Clients), ",")
+	if policy.Inbounds == "" && policy.Groups == "" {
+		return common.NewError("Sync policy selects no inbounds")
+	}
+	return nil
+}
+
+// AddNodeSyncPolicy creates a sync policy.
+func (s *NodeService) AddNodeSyncPolicy(policy *model.NodeSyncPolicy) error {
+	if err := s.checkNodeSyncPolicy(policy); err != nil {
+		return err
+	}
+	policy.Id = 0
+	return database.GetDB().Create(policy).Error
+}
+
+// UpdateNodeSyncPolicy updates a sync policy.
+func (s *NodeService) UpdateNodeSyncPolicy(policy *model.NodeSyncPolicy) error {
+	if err := s.checkNodeSyncPolicy(policy); err != nil {
+		return err
+	}
+	result := database.GetDB().Model(model.NodeSyncPolicy{}).Where("id = ?", policy.Id).
+		Select("name", "enable", "node_group", "inbounds", "groups", "clients", "placement").
+		Updates(policy)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return common.NewError("Sync policy not found:", policy.Id)
+	}
+	return nil
+}
+
+// DelNodeSyncPolicy deletes a sync policy.
+func (s *NodeService) DelNodeSyncPolicy(id int) error {
+	db := database.GetDB()
+	if err := db.Where("policy_id = ?", id).Delete(model.NodePlacement{}).Error; err != nil {
+		return err
+	}
+	return db.Delete(model.NodeSyncPolicy{}, id).Error
+}
+
+// GetNodeSyncStatuses returns the sync state of every node.
+func (s *NodeService) GetNodeSyncStatuses() ([]*NodeSyncStatus, error) {
+	nodes, err := s.GetNodes()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]*NodeSyncStatus, 0, len(nodes))
+	for _, node := range nodes {
+		status, err := s.getNodeSyncStatus(node)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// GetNodeSyncStatus returns the sync state of a node.
+func (s *NodeService) GetNodeSyncStatus(id int) (*NodeSyncStatus, error) {
+	node := &model.Node{}
+	if err := database.GetDB().Model(model.Node{}).Where("id = ?", id).First(node).Error; err != nil {
+		return nil, err
+	}
+	return s.getNodeSyncStatus(node)
+}
+
+// getNodeSyncStatus derives the sync state of a node from its last sync and the inbounds it
+// should run now.
+func (s *NodeService) getNodeSyncStatus(node *model.Node) (*NodeSyncStatus, error) {
+	inbounds, err := s.getNodeInbounds(node)
+	if err != nil {
+		return nil, err
+	}
+	_, hash, err := encodeNodeInbounds(inbounds)
+	if err != nil {
+		return nil, err
+	}
+	status := &NodeSyncStatus{
+		Id:        node.Id,
+		Name:      node.Name,
+		Group:     node.Group,
+		Status:    node.SyncStatus,
+		Inbounds:  len(inbounds),
+		Conflicts: splitNodeConflicts(node.Conflicts),
+		LastSeen:  node.LastSeen,
+		LastSync:  node.LastSync,
+		LastError: node.LastError,
+	}
+	for _, inbound := range inbounds {
+		status.Clients += len(inbound.ClientStats)
+	}
+	switch {
+	case !node.Enable:
+		status.Status = NodeSyncDisabled
+	case node.Address == "":
+		status.Status = NodeSyncUnregistered
+	case status.Status == NodeSyncSynced && hash != node.ConfigHash, status.Status == "":
+		status.Status = NodeSyncPending
+	}
+	return status, nil
+}
+
+// encodeNodeInbounds encodes the inbounds pushed to a node as JSON and returns their hash.
+func encodeNodeInbounds(inbounds []*model.Inbound) ([]byte, string, error) {
+	payload, err := json.Marshal(inbounds)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(payload)
+	return payload, hex.EncodeToString(sum[:]), nil
+}
+
+// getNodeInbounds returns the inbounds pushed to a node: those assigned to it with all their
+// clients, and those selected by the enabled sync policies of its group with the clients the
+// policies select. They go without their limits and traffic, which stay with this panel, and
+// without the outbound they are routed to, which only exists here.
+func (s *NodeService) getNodeInbounds(node *model.Node) ([]*model.Inbound, error) {
+	policies, err := s.GetNodeSyncPolicies()
+	if err != nil {
+		return nil, err
+	}
+	var inbounds []*model.Inbound
+	err = database.GetDB().Model(model.Inbound{}).Preload("ClientStats").Order("id").Find(&inbounds).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// Inbounds pushed with all their clients, with only the clients matching patterns and with
+	// only the clients placed on the node
+	allClients := make(map[int]bool)
+	clientPatterns := make(map[int][]string)
+	placedClients := make(map[int]map[string]bool)
+	ids, _ := parseNodeInboundIds(node.Inbounds)
+	for _, id := range ids {
+		allClients[id] = true
+	}
+	for _, policy := range policies {
+		if !policy.Enable || (policy.NodeGroup != "" && policy.NodeGroup != node.Group) {
+			continue
+		}
+		policyIds, _ := parseNodeInboundIds(policy.Inbounds)
+		groups := splitRuleList(policy.Groups)
+		patterns := splitRuleList(policy.Clients)
+		var placed []string
+		if policy.Placement {
+			placed, err = getPlacedNodeClients(policy.Id, node.Id)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, inbound := range inbounds {
+			if !slices.Contains(policyIds, inbound.Id) && (inbound.GroupName == "" || !slices.Contains(groups, inbound.GroupName)) {
+				continue
+			}
+			if policy.Placement {
+				if placedClients[inbound.Id] == nil {
+					placedClients[inbound.Id] = make(map[string]bool)
+				}
+				for _, email := range placed {
+					placedClients[inbound.Id][email] = true
+				}
+			} else if len(patterns) == 0 {
+				allClients[inbound.Id] = true
+			} else {
+				clientPatterns[inbound.Id] = append(clientPatterns[inbound.Id], patterns...)
+			}
+		}
+	}
+
+	pushed := make([]*model.Inbound, 0, len(allClients)+len(clientPatterns)+len(placedClients))
+	for _, inbound := range inbounds {
+		patterns, selected := clientPatterns[inbound.Id]
+		placed, placement := placedClients[inbound.Id]
+		if !allClients[inbound.Id] && !selected && !placement {
+			continue
+		}
+		pushClient := func(email string) bool {
+			return allClients[inbound.Id] || matchesNodeClient(patterns, email) || placed[email]
+		}
+		settings := inbound.Settings
+		if !allClients[inbound.Id] {
+			if settings, err = filterNodeClients(settings, pushClient); err != nil {
+				return nil, common.NewError("Filter clients of inbound", inbound.Tag, "failed:", err)
+			}
+		}
+
+		clientStats := make([]xray.ClientTraffic, 0, len(inbound.ClientStats))
+		for _, clientTraffic := range inbound.ClientStats {
+			if pushClient(clientTraffic.Email) {
+				clientStats = append(clientStats, xray.ClientTraffic{Email: clientTraffic.Email, Enable: clientTraffic.Enable})
+			}
+		}
+		slices.SortFunc(clientStats, func(a, b xray.ClientTraffic) int { return strings.Compare(a.Email, b.Email) })
+		pushed = append(pushed, &model.Inbound{
+			Remark:         inbound.Remark,
+			Enable:         inbound.Enable,
+			ClientStats:    clientStats,
+			GroupName:      inbound.GroupName,
+			Tags:           inbound.Tags,
+			MaxConnections: inbound.MaxConnections,
+			Listen:         inbound.Listen,
+			Port:           inbound.Port,
+			PortRange:      inbound.PortRange,
+			Protocol:       inbound.Protocol,
+			Settings:       settings,
+			StreamSettings: inbound.StreamSettings,
+			Tag:            inbound.Tag,
+			Sniffing:       inbound.Sniffing,
+		})
+	}
+	return pushed, nil
+}
+
+// filterNodeClients keeps the clients of inbound settings whose email is pushed to a node.
+func filterNodeClients(settings string, pushClient func(email string) bool) (string, error) {
+	var parsed map[string]any
+	if err := json.Unmarshal([]byte(settings), &parsed); err != nil {
+		return "", err
+	}
+	clients, ok := parsed["clients"].([]any)
+	if !ok {
+		return settings, nil
+	}
+	kept := make([]any, 0, len(clients))
+	for _, client := range clients {
+		if c, ok := client.(map[string]any); ok {
+			if email, _ := c["email"].(string); pushClient(email) {
+				kept = append(kept, client)
+			}
+		}
+	}
+	parsed["clients"] = kept
+	filtered, err := json.MarshalIndent(parsed, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(filtered), nil
+}
+
+// matchesNodeClient reports whether a client email matches one of the patterns of sync policies.
+func matchesNodeClient(patterns []string, email string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, email); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// parseNodeInboundIds parses a comma separated list of inbound IDs.
+func parseNodeInboundIds(list string) ([]int, error) {
+	var ids []int
+	for _, item := range splitRuleList(list) {
+		id, err := strconv.Atoi(item)
+		if err != nil {
+			return nil, common.NewError("Invalid inbound ID:", item)
+		}
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// splitNodeConflicts splits the conflicts saved with a node.
+func splitNodeConflicts(conflicts string) []string {
+	if conflicts == "" {
+		return []string{}
+	}
+	return strings.Split(conflicts, "\n")
+}
@@ -0,0 +1,372 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	neturl "net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/web/locale"
+)
+
+// Events of the administrator notifications, which the channels are subscribed to.
+const (
+	NotifyAlert    = "alert"    // An alert rule fired
+	NotifyCrash    = "crash"    // The core stopped unexpectedly
+	NotifyCpu      = "cpu"      // The CPU load passed the Telegram CPU threshold
+	NotifyDepleted = "depleted" // An inbound reached its traffic limit
+	NotifyExpiry   = "expiry"   // A client crossed an expiry threshold
+	NotifyQuota    = "quota"    // A client crossed a quota threshold
+	NotifyAnomaly  = "anomaly"  // Traffic anomalies were found
+	NotifyReport   = "report"   // A usage report
+	NotifyLogin    = "login"    // Someone logged in to the panel or failed to
+	NotifyBackup   = "backup"   // A scheduled backup was sent
+	NotifyCert     = "cert"     // A certificate was renewed, failed to renew or is about to expire
+)
+
+// NotifyEvents lists every notification event.
+var NotifyEvents = []string{NotifyAlert, NotifyCrash, NotifyCpu, NotifyDepleted, NotifyExpiry, NotifyQuota, NotifyAnomaly, NotifyReport, NotifyLogin, NotifyBackup, NotifyCert}
+
+// Special entries of the event lists of the destinations.
+const (
+	NotifyClient = "client" // Former event of both the expiry and the quota thresholds, routes both
+	NotifyNone   = "none"   // Routes no event, as an empty list routes all of them
+)
+
+// Types of the notification channels.
+const (
+	NotifyChannelDiscord = "discord"
+	NotifyChannelSlack   = "slack"
+	NotifyChannelWebhook = "webhook"
+	NotifyChannelMatrix  = "matrix"
+)
+
+// notifyTagRegex matches the HTML tags of the Telegram messages, which the other channels do not render.
+var notifyTagRegex = regexp.MustCompile(`<[^>]+>`)
+
+// NotificationChannel delivers administrator notifications to one destination.
+type NotificationChannel interface {
+	// Send delivers a message of an event. The message is formatted for Telegram.
+	Send(event string, msg string) error
+}
+
+// NotifyService routes the administrator notifications to the Telegram bot, the admin email
+// addresses and the configured channels that are subscribed to their event.
+type NotifyService struct {
+	settingService SettingService
+	tgbotService   Tgbot
+	mailService    MailService
+}
+
+// Notify sends a message of an event to every destination subscribed to it. Events that are
+// not urgent are held back during the quiet hours of a destination and collected into a digest
+// when it batches them. The Telegram bot is sent to directly, the email and the other channels
+// in the background so a slow server does not hold up the caller.
+func (s *NotifyService) Notify(event string, msg string) {
+	urgentEvents, _ := s.settingService.GetNotifyUrgentEvents()
+	urgent := strings.TrimSpace(urgentEvents) != "" && notifyEventRouted(urgentEvents, event)
+	now := time.Now()
+
+	for _, dest := range s.notifyDestinations() {
+		if !notifyEventRouted(dest.events, event) {
+			continue
+		}
+		if !urgent && (dest.batchMinutes > 0 || s.inQuietHours(dest.quietHours, now)) {
+			pending := &model.PendingNotification{
+				Destination: dest.key,
+				Event:       event,
+				Message:     msg,
+				CreatedAt:   now.Unix(),
+			}
+			if err := database.GetDB().Create(pending).Error; err != nil {
+				logger.Warning("Hold", event, "notification for", dest.name, "failed:", err)
+			}
+			continue
+		}
+		if dest.key == notifyDestinationTelegram {
+			if err := dest.sender.Send(event, msg); err != nil {
+				logger.Warning("Send", event, "notification to", dest.name, "failed:", err)
+			}
+			continue
+		}
+		go func(dest *notifyDestination) {
+			if err := dest.sender.Send(event, msg); err != nil {
+				logger.Warning("Send", event, "notification to", dest.name, "failed:", err)
+			}
+		}(dest)
+	}
+}
+
+// HasDestinations reports whether the Telegram bot, the admin or client emails or any
+// notification channel is enabled, so jobs that only notify are worth scheduling.
+func (s *NotifyService) HasDestinations() bool {
+	if enabled, err := s.settingService.GetTgbotEnabled(); err == nil && enabled {
+		return true
+	}
+	if adminTo, err := s.settingService.GetSmtpAdminTo(); err == nil && adminTo != "" {
+		return true
+	}
+	if enabled, err := s.settingService.GetSmtpClientNotify(); err == nil && enabled {
+		return true
+	}
+	var count int64
+	database.GetDB().Model(model.NotifyChannel{}).Where("enable = ?", true).Count(&count)
+	return count > 0
+}
+
+// GetNotifyChannels returns all notification channels.
+func (s *NotifyService) GetNotifyChannels() ([]*model.NotifyChannel, error) {
+	var channels []*model.NotifyChannel
+	err := database.GetDB().Model(model.NotifyChannel{}).Order("id").Find(&channels).Error
+	if err != nil {
+		return nil, err
+	}
+	return channels, nil
+}
+
+// AddNotifyChannel creates a new notification channel.
+func (s *NotifyService) AddNotifyChannel(channel *model.NotifyChannel) error {
+	if err := checkNotifyChannel(channel); err != nil {
+		return err
+	}
+	channel.Id = 0
+	return database.GetDB().Create(channel).Error
+}
+
+// UpdateNotifyChannel saves a notification channel.
+func (s *NotifyService) UpdateNotifyChannel(channel *model.NotifyChannel) error {
+	if err := checkNotifyChannel(channel); err != nil {
+		return err
+	}
+	db := database.GetDB()
+	if err := db.Model(model.NotifyChannel{}).First(&model.NotifyChannel{}, channel.Id).Error; err != nil {
+		return err
+	}
+	return db.Save(channel).Error
+}
+
+// DelNotifyChannel deletes a notification channel.
+func (s *NotifyService) DelNotifyChannel(id int) error {
+	return database.GetDB().Delete(model.NotifyChannel{}, id).Error
+}
+
+// TestNotifyChannel sends a test message to a notification channel and returns the delivery error.
+func (s *NotifyService) TestNotifyChannel(id int, msg string) error {
+	channel := &model.NotifyChannel{}
+	if err := database.GetDB().Model(model.NotifyChannel{}).First(channel, id).Error; err != nil {
+		return err
+	}
+	sender, err := newNotificationChannel(channel)
+	if err != nil {
+		return err
+	}
+	return sender.Send("test", msg)
+}
+
+func checkNotifyChannel(channel *model.NotifyChannel) error {
+	if channel.Name == "" {
+		return common.NewError("Notification channel name is empty")
+	}
+	if _, err := newNotificationChannel(channel); err != nil {
+		return err
+	}
+	if channel.QuietHours != "" {
+		if _, _, err := common.ParseClockRange(channel.QuietHours); err != nil {
+			return err
+		}
+	}
+	if channel.BatchMinutes < 0 {
+		return common.NewError("Notification channel batch minutes must not be negative:", channel.BatchMinutes)
+	}
+	for event := range strings.SplitSeq(channel.Events, ",") {
+		if event = strings.TrimSpace(event); event != "" && !isNotifyEventListEntry(event) {
+			return common.NewError("Unknown notification event:", event)
+		}
+	}
+	return nil
+}
+
+// isNotifyEventListEntry reports whether a value may appear in the event list of a destination.
+func isNotifyEventListEntry(event string) bool {
+	return slices.Contains(NotifyEvents, event) || event == NotifyClient || event == NotifyNone
+}
+
+// notifyEventRouted reports whether an event is in a comma separated event list, where an empty list routes every event.
+func notifyEventRouted(events string, event string) bool {
+	if strings.TrimSpace(events) == "" {
+		return true
+	}
+	for routed := range strings.SplitSeq(events, ",") {
+		routed = strings.TrimSpace(routed)
+		if routed == event || (routed == NotifyClient && (event == NotifyExpiry || event == NotifyQuota)) {
+			return true
+		}
+	}
+	return false
+}
+
+// newNotificationChannel returns the sender of a configured channel.
+func newNotificationChannel(channel *model.NotifyChannel) (NotificationChannel, error) {
+	if !strings.HasPrefix(channel.Url, "https://") && !strings.HasPrefix(channel.Url, "http://") {
+		return nil, common.NewError("Notification channel URL must be an http or https URL:", channel.Url)
+	}
+	switch channel.Type {
+	case NotifyChannelDiscord:
+		return &discordChannel{url: channel.Url}, nil
+	case NotifyChannelSlack:
+		return &slackChannel{url: channel.Url}, nil
+	case NotifyChannelWebhook:
+		return &webhookChannel{url: channel.Url, secret: channel.Secret}, nil
+	case NotifyChannelMatrix:
+		if channel.Secret == "" || channel.RoomId == "" {
+			return nil, common.NewError("Matrix channel needs an access token and a room ID")
+		}
+		return &matrixChannel{homeserver: strings.TrimRight(channel.Url, "/"), token: channel.Secret, roomId: channel.RoomId}, nil
+	}
+	return nil, common.NewError("Unknown notification channel type:", channel.Type)
+}
+
+// plainNotifyText turns a Telegram HTML message into plain text.
+func plainNotifyText(msg string) string {
+	msg = strings.ReplaceAll(msg, "\r\n", "\n")
+	return strings.TrimSpace(html.UnescapeString(notifyTagRegex.ReplaceAllString(msg, "")))
+}
+
+// telegramChannel sends the notifications to the admins of the Telegram bot.
+type telegramChannel struct {
+	tgbot *Tgbot
+}
+
+func (c *telegramChannel) Send(event string, msg string) error {
+	if !c.tgbot.IsRunning() {
+		return common.NewError("Telegram bot is not running")
+	}
+	c.tgbot.SendMsgToTgbotAdmins(msg)
+	return nil
+}
+
+// emailChannel emails the notifications to the admin addresses.
+type emailChannel struct {
+	mailService *MailService
+}
+
+func (c *emailChannel) Send(event string, msg string) error {
+	subject := locale.I18n(locale.Bot, "tgbot.messages.notifySubject", "Hostname=="+reportHostname(), "Event=="+event)
+	return c.mailService.SendAdminMail(subject, plainNotifyText(msg))
+}
+
+// discordChannel posts the notifications to a Discord webhook.
+type discordChannel struct {
+	url string
+}
+
+func (c *discordChannel) Send(event string, msg string) error {
+	text := plainNotifyText(msg)
+	// Discord rejects messages longer than 2000 characters
+	if runes := []rune(text); len(runes) > 2000 {
+		text = string(runes[:1999]) + "…"
+	}
+	return postNotification(c.url, map[string]any{"content": text}, "")
+}
+
+// slackChannel posts the notifications to a Slack incoming webhook.
+type slackChannel struct {
+	url string
+}
+
+func (c *slackChannel) Send(event string, msg string) error {
+	return postNotification(c.url, map[string]any{"text": plainNotifyText(msg)}, "")
+}
+
+// webhookChannel posts the notifications as JSON to any URL.
+type webhookChannel struct {
+	url    string
+	secret string
+}
+
+func (c *webhookChannel) Send(event string, msg string) error {
+	return postNotification(c.url, map[string]any{
+		"event":    event,
+		"message":  plainNotifyText(msg),
+		"hostname": reportHostname(),
+		"time":     time.Now().Unix(),
+	}, c.secret)
+}
+
+// matrixChannel sends the notifications to a Matrix room as the user of an access token.
+type matrixChannel struct {
+	homeserver string
+	token      string
+	roomId     string
+}
+
+func (c *matrixChannel) Send(event string, msg string) error {
+	// Matrix renders the tags of the Telegram messages, only the line breaks need converting
+	formatted := strings.ReplaceAll(strings.ReplaceAll(strings.TrimSpace(msg), "\r\n", "\n"), "\n", "<br>")
+	body, err := json.Marshal(map[string]any{
+		"msgtype":        "m.text",
+		"body":           plainNotifyText(msg),
+		"format":         "org.matrix.custom.html",
+		"formatted_body": formatted,
+	})
+	if err != nil {
+		return err
+	}
+	txnId := strconv.FormatInt(time.Now().UnixNano(), 10)
+	url := c.homeserver + "/_matrix/client/v3/rooms/" + neturl.PathEscape(c.roomId) + "/send/m.room.message/" + txnId
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	return doNotificationRequest(req)
+}
+
+// postNotification posts a JSON body to a webhook. With a secret, the hex HMAC-SHA256 of the
+// body is sent in the X-Signature header so the receiver can verify where it came from.
+func postNotification(url string, payload map[string]any, secret string) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	return doNotificationRequest(req)
+}
+
+// doNotificationRequest sends a request of a notification channel and checks it succeeded.
+func doNotificationRequest(req *http.Request) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
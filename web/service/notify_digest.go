@@ -0,0 +1,146 @@
+package service
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/web/locale"
+)
+
+// Keys of the notification destinations configured in the settings. The notification
+// channels are keyed "channel:<id>".
+const (
+	notifyDestinationTelegram = "telegram"
+	notifyDestinationEmail    = "email"
+)
+
+// NotifyDigest is the event of the messages that collect held back notifications.
+const NotifyDigest = "digest"
+
+// notifyDestination is a destination of the notifications with its delivery rules.
+type notifyDestination struct {
+	key          string
+	name         string
+	events       string // Comma separated events routed to the destination, empty for all
+	quietHours   string // Daily range during which only urgent events are sent, empty for none
+	batchMinutes int    // Minutes the events that are not urgent are collected for, 0 for none
+	sender       NotificationChannel
+}
+
+// notifyDestinations returns the enabled destinations of the notifications.
+func (s *NotifyService) notifyDestinations() []*notifyDestination {
+	var dests []*notifyDestination
+	if s.tgbotService.IsRunning() {
+		dest := &notifyDestination{key: notifyDestinationTelegram, name: "Telegram", sender: &telegramChannel{tgbot: &s.tgbotService}}
+		dest.events, _ = s.settingService.GetTgBotEvents()
+		dest.quietHours, _ = s.settingService.GetTgBotQuietHours()
+		dest.batchMinutes, _ = s.settingService.GetTgBotBatchMinutes()
+		dests = append(dests, dest)
+	}
+
+	if adminTo, _ := s.settingService.GetSmtpAdminTo(); adminTo != "" {
+		dest := &notifyDestination{key: notifyDestinationEmail, name: "email", sender: &emailChannel{mailService: &s.mailService}}
+		dest.events, _ = s.settingService.GetSmtpEvents()
+		dest.quietHours, _ = s.settingService.GetSmtpQuietHours()
+		dest.batchMinutes, _ = s.settingService.GetSmtpBatchMinutes()
+		dests = append(dests, dest)
+	}
+
+	var channels []*model.NotifyChannel
+	if err := database.GetDB().Model(model.NotifyChannel{}).Where("enable = ?", true).Find(&channels).Error; err != nil {
+		logger.Warning("Load notification channels failed:", err)
+		return dests
+	}
+	for _, channel := range channels {
+		sender, err := newNotificationChannel(channel)
+		if err != nil {
+			logger.Warning("Notification channel", channel.Name, "is invalid:", err)
+			continue
+		}
+		dests = append(dests, &notifyDestination{
+			key:          "channel:" + strconv.Itoa(channel.Id),
+			name:         channel.Name,
+			events:       channel.Events,
+			quietHours:   channel.QuietHours,
+			batchMinutes: channel.BatchMinutes,
+			sender:       sender,
+		})
+	}
+	return dests
+}
+
+// inQuietHours reports whether a time is within a daily range of quiet hours in the panel time zone.
+func (s *NotifyService) inQuietHours(quietHours string, now time.Time) bool {
+	if quietHours == "" {
+		return false
+	}
+	start, end, err := common.ParseClockRange(quietHours)
+	if err != nil {
+		logger.Warning("Invalid quiet hours:", err)
+		return false
+	}
+	if loc, err := s.settingService.GetTimeLocation(); err == nil {
+		now = now.In(loc)
+	}
+	minute := now.Hour()*60 + now.Minute()
+	if start < end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// FlushPendingNotifications sends the held back notifications of every destination that is
+// out of its quiet hours and whose batch is due, as one digest per destination. Notifications
+// of destinations that were removed or disabled are dropped.
+func (s *NotifyService) FlushPendingNotifications() {
+	db := database.GetDB()
+	var pending []*model.PendingNotification
+	if err := db.Model(model.PendingNotification{}).Order("id").Find(&pending).Error; err != nil {
+		logger.Warning("Load pending notifications failed:", err)
+		return
+	}
+	if len(pending) == 0 {
+		return
+	}
+	grouped := make(map[string][]*model.PendingNotification)
+	for _, notification := range pending {
+		grouped[notification.Destination] = append(grouped[notification.Destination], notification)
+	}
+
+	now := time.Now()
+	dests := make(map[string]*notifyDestination)
+	for _, dest := range s.notifyDestinations() {
+		dests[dest.key] = dest
+	}
+	for key, notifications := range grouped {
+		dest, ok := dests[key]
+		if !ok {
+			db.Where("destination = ?", key).Delete(model.PendingNotification{})
+			continue
+		}
+		if s.inQuietHours(dest.quietHours, now) {
+			continue
+		}
+		if dest.batchMinutes > 0 && now.Unix()-notifications[0].CreatedAt < int64(dest.batchMinutes)*60 {
+			continue
+		}
+
+		ids := make([]int, 0, len(notifications))
+		msgs := make([]string, 0, len(notifications)+1)
+		msgs = append(msgs, locale.I18n(locale.Bot, "tgbot.messages.notifyDigest", "Count=="+strconv.Itoa(len(notifications))))
+		for _, notification := range notifications {
+			ids = append(ids, notification.Id)
+			msgs = append(msgs, strings.TrimSpace(notification.Message))
+		}
+		if err := dest.sender.Send(NotifyDigest, strings.Join(msgs, "\r\n\r\n")); err != nil {
+			logger.Warning("Send notification digest to", dest.name, "failed:", err)
+			continue
+		}
+		db.Where("id IN ?", ids).Delete(model.PendingNotification{})
+	}
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+)
+
+// NotifyMatrix maps every notification event to the destinations it is sent to.
+type NotifyMatrix struct {
+	Events       []string                   `json:"events"`
+	Destinations []*NotifyMatrixDestination `json:"destinations"`
+}
+
+// NotifyMatrixDestination is a column of the notification matrix.
+type NotifyMatrixDestination struct {
+	Key    string   `json:"key"`    // "telegram", "email" or "channel:<id>"
+	Name   string   `json:"name"`   // Channel name, empty for the Telegram bot and the email
+	Enable bool     `json:"enable"` // Whether the destination is configured and enabled
+	Events []string `json:"events"` // Events sent to the destination
+}
+
+// GetNotifyMatrix returns the events routed to the Telegram bot, the admin email addresses
+// and every notification channel.
+func (s *NotifyService) GetNotifyMatrix() (*NotifyMatrix, error) {
+	tgEnable, err := s.settingService.GetTgbotEnabled()
+	if err != nil {
+		return nil, err
+	}
+	tgEvents, err := s.settingService.GetTgBotEvents()
+	if err != nil {
+		return nil, err
+	}
+	adminTo, err := s.settingService.GetSmtpAdminTo()
+	if err != nil {
+		return nil, err
+	}
+	smtpEvents, err := s.settingService.GetSmtpEvents()
+	if err != nil {
+		return nil, err
+	}
+	channels, err := s.GetNotifyChannels()
+	if err != nil {
+		return nil, err
+	}
+
+	matrix := &NotifyMatrix{
+		Events: NotifyEvents,
+		Destinations: []*NotifyMatrixDestination{
+			{Key: notifyDestinationTelegram, Enable: tgEnable, Events: routedNotifyEvents(tgEvents)},
+			{Key: notifyDestinationEmail, Enable: adminTo != "", Events: routedNotifyEvents(smtpEvents)},
+		},
+	}
+	for _, channel := range channels {
+		matrix.Destinations = append(matrix.Destinations, &NotifyMatrixDestination{
+			Key:    "channel:" + strconv.Itoa(channel.Id),
+			Name:   channel.Name,
+			Enable: channel.Enable,
+			Events: routedNotifyEvents(channel.Events),
+		})
+	}
+	return matrix, nil
+}
+
+// UpdateNotifyMatrix saves the events routed to the destinations, given by destination key.
+// Destinations that are left out keep their events.
+func (s *NotifyService) UpdateNotifyMatrix(routes map[string][]string) error {
+	for key, events := range routes {
+		for _, event := range events {
+			if !slices.Contains(NotifyEvents, event) {
+				return common.NewError("Unknown notification event:", event)
+			}
+		}
+		list := notifyEventList(events)
+
+		switch {
+		case key == notifyDestinationTelegram:
+			if err := s.settingService.setString("tgBotEvents", list); err != nil {
+				return err
+			}
+		case key == notifyDestinationEmail:
+			if err := s.settingService.setString("smtpEvents", list); err != nil {
+				return err
+			}
+		case strings.HasPrefix(key, "channel:"):
+			id, err := strconv.Atoi(strings.TrimPrefix(key, "channel:"))
+			if err != nil {
+				return common.NewError("Unknown notification destination:", key)
+			}
+			result := database.GetDB().Model(model.NotifyChannel{}).Where("id = ?", id).Update("events", list)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return common.NewError("Notification channel not found:", id)
+			}
+		default:
+			return common.NewError("Unknown notification destination:", key)
+		}
+	}
+	return nil
+}
+
+// routedNotifyEvents returns the events an event list of a destination routes.
+func routedNotifyEvents(events string) []string {
+	routed := make([]string, 0, len(NotifyEvents))
+	for _, event := range NotifyEvents {
+		if notifyEventRouted(events, event) {
+			routed = append(routed, event)
+		}
+	}
+	return routed
+}
+
+// notifyEventList returns the event list of a destination that routes the given events. All
+// events give an empty list, so that events added later are routed too.
+func notifyEventList(events []string) string {
+	if len(events) == 0 {
+		return NotifyNone
+	}
+	if !slices.ContainsFunc(NotifyEvents, func(event string) bool { return !slices.Contains(events, event) }) {
+		return ""
+	}
+	return strings.Join(events, ",")
+}
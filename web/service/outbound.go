@@ -1,37 +1,22 @@
 package service
 
 import (
+	"encoding/json"
+
 	"github.com/mhsanaei/3x-ui/v2/database"
 	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/json_util"
 	"github.com/mhsanaei/3x-ui/v2/xray"
 
 	"gorm.io/gorm"
 )
 
 // OutboundService provides business logic for managing Xray outbound configurations.
-// It handles outbound traffic monitoring and statistics.
-type OutboundService struct{}
-
-func (s *OutboundService) AddTraffic(traffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) (error, bool) {
-	var err error
-	db := database.GetDB()
-	tx := db.Begin()
-
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-		} else {
-			tx.Commit()
-		}
-	}()
-
-	err = s.addOutboundTraffic(tx, traffics)
-	if err != nil {
-		return err, false
-	}
-
-	return nil, false
+// It handles the panel-managed outbounds and outbound traffic monitoring and statistics.
+type OutboundService struct {
+	settingService SettingService
+	warpService    WarpService
 }
 
 func (s *OutboundService) addOutboundTraffic(tx *gorm.DB, traffics []*xray.Traffic) error {
@@ -79,6 +64,27 @@ func (s *OutboundService) GetOutboundsTraffic() ([]*model.OutboundTraffics, erro
 	return traffics, nil
 }
 
+// enableOutboundStats turns on the uplink and downlink counters of all outbounds in the system policy.
+func enableOutboundStats(policyConfig json_util.RawMessage) (json_util.RawMessage, error) {
+	policy := map[string]any{}
+	if len(policyConfig) > 0 {
+		if err := json.Unmarshal(policyConfig, &policy); err != nil {
+			return nil, err
+		}
+	}
+	system, _ := policy["system"].(map[string]any)
+	if system == nil {
+		system = map[string]any{}
+	}
+	if system["statsOutboundUplink"] == true && system["statsOutboundDownlink"] == true {
+		return policyConfig, nil
+	}
+	system["statsOutboundUplink"] = true
+	system["statsOutboundDownlink"] = true
+	policy["system"] = system
+	return json.MarshalIndent(policy, "", "  ")
+}
+
 func (s *OutboundService) ResetOutboundTraffic(tag string) error {
 	db := database.GetDB()
 
@@ -0,0 +1,389 @@
+package service
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+)
+
+// GetOutbounds returns all panel-managed outbounds ordered by tag.
+func (s *OutboundService) GetOutbounds() ([]*model.Outbound, error) {
+	db := database.GetDB()
+	var outbounds []*model.Outbound
+	err := db.Model(model.Outbound{}).Order("tag").Find(&outbounds).Error
+	if err != nil {
+		return nil, err
+	}
+	return outbounds, nil
+}
+
+// GetOutbound returns the panel-managed outbound with the given ID.
+func (s *OutboundService) GetOutbound(id int) (*model.Outbound, error) {
+	db := database.GetDB()
+	outbound := &model.Outbound{}
+	err := db.Model(model.Outbound{}).First(outbound, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return outbound, nil
+}
+
+// AddOutbound validates and creates an outbound.
+func (s *OutboundService) AddOutbound(outbound *model.Outbound) error {
+	outbound.Id = 0
+	if err := s.validateOutbound(outbound); err != nil {
+		return err
+	}
+	return database.GetDB().Create(outbound).Error
+}
+
+// UpdateOutbound validates and saves an outbound. Its tag cannot change while
+// routing rules, inbounds or other outbounds refer to it.
+func (s *OutboundService) UpdateOutbound(outbound *model.Outbound) error {
+	oldOutbound, err := s.GetOutbound(outbound.Id)
+	if err != nil {
+		return err
+	}
+	if oldOutbound.Tag != outbound.Tag || (oldOutbound.Enable && !outbound.Enable) {
+		if err := s.checkOutboundUnused(oldOutbound.Tag); err != nil {
+			return err
+		}
+	}
+	outbound.Warp = oldOutbound.Warp
+	if err := s.validateOutbound(outbound); err != nil {
+		return err
+	}
+	return database.GetDB().Save(outbound).Error
+}
+
+// DelOutbound deletes an outbound that nothing refers to.
+func (s *OutboundService) DelOutbound(id int) error {
+	outbound, err := s.GetOutbound(id)
+	if err != nil {
+		return err
+	}
+	if err := s.checkOutboundUnused(outbound.Tag); err != nil {
+		return err
+	}
+	if outbound.Warp {
+		if err := s.warpService.delWarpAccount(id); err != nil {
+			return err
+		}
+	}
+	return database.GetDB().Delete(model.Outbound{}, id).Error
+}
+
+// AddWarpOutbound registers a new Cloudflare WARP device and adds a WireGuard outbound for it.
+func (s *OutboundService) AddWarpOutbound(tag string, remark string) (*model.Outbound, error) {
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	registration, err := s.warpService.registerWarpDevice(base64.StdEncoding.EncodeToString(privateKey.PublicKey().Bytes()))
+	if err != nil {
+		return nil, err
+	}
+
+	var config struct {
+		ClientId string `json:"client_id"`
+		Peers    []struct {
+			PublicKey string `json:"public_key"`
+			Endpoint  struct {
+				Host string `json:"host"`
+			} `json:"endpoint"`
+		} `json:"peers"`
+		Interface struct {
+			Addresses struct {
+				V4 string `json:"v4"`
+				V6 string `json:"v6"`
+			} `json:"addresses"`
+		} `json:"interface"`
+	}
+	data, _ := json.Marshal(registration["config"])
+	if err := json.Unmarshal(data, &config); err != nil || len(config.Peers) == 0 {
+		return nil, common.NewError("WARP registration has no WireGuard config")
+	}
+
+	host, port, err := net.SplitHostPort(config.Peers[0].Endpoint.Host)
+	if err != nil {
+		return nil, err
+	}
+	var addresses []string
+	if config.Interface.Addresses.V4 != "" {
+		addresses = append(addresses, config.Interface.Addresses.V4+"/32")
+	}
+	if config.Interface.Addresses.V6 != "" {
+		addresses = append(addresses, config.Interface.Addresses.V6+"/128")
+	}
+	var reserved []string
+	clientId, _ := base64.StdEncoding.DecodeString(config.ClientId)
+	for _, b := range clientId {
+		reserved = append(reserved, strconv.Itoa(int(b)))
+	}
+
+	outbound := &model.Outbound{
+		Tag:            tag,
+		Enable:         true,
+		Remark:         remark,
+		Protocol:       "wireguard",
+		Address:        host,
+		PrivateKey:     base64.StdEncoding.EncodeToString(privateKey.Bytes()),
+		PeerPublicKey:  config.Peers[0].PublicKey,
+		LocalAddress:   strings.Join(addresses, ","),
+		Reserved:       strings.Join(reserved, ","),
+		MTU:            1420,
+		DomainStrategy: "ForceIP",
+		Warp:           true,
+	}
+	outbound.Port, _ = strconv.Atoi(port)
+	if err := s.AddOutbound(outbound); err != nil {
+		return nil, err
+	}
+	account := &model.WarpAccount{
+		OutboundId:  outbound.Id,
+		DeviceId:    registration["id"].(string),
+		AccessToken: registration["token"].(string),
+	}
+	if accountData, ok := registration["account"].(map[string]any); ok {
+		setWarpAccountData(account, accountData)
+	}
+	if err := database.GetDB().Create(account).Error; err != nil {
+		return nil, err
+	}
+	return outbound, nil
+}
+
+// GetOutboundTags returns the tags of the outbounds in the Xray config template and
+// of the enabled panel-managed outbounds.
+func (s *OutboundService) GetOutboundTags() (map[string]bool, error) {
+	tags, err := s.getTemplateOutboundTags()
+	if err != nil {
+		return nil, err
+	}
+	outbounds, err := s.GetOutbounds()
+	if err != nil {
+		return nil, err
+	}
+	for _, outbound := range outbounds {
+		if outbound.Enable {
+			tags[outbound.Tag] = true
+		}
+	}
+	return tags, nil
+}
+
+// getTemplateOutboundTags returns the tags of the outbounds in the Xray config template.
+func (s *OutboundService) getTemplateOutboundTags() (map[string]bool, error) {
+	templateConfig, err := s.settingService.GetXrayConfigTemplate()
+	if err != nil {
+		return nil, err
+	}
+	config := struct {
+		Outbounds []struct {
+			Tag string `json:"tag"`
+		} `json:"outbounds"`
+	}{}
+	if err := json.Unmarshal([]byte(templateConfig), &config); err != nil {
+		return nil, err
+	}
+	tags := make(map[string]bool, len(config.Outbounds))
+	for _, outbound := range config.Outbounds {
+		tags[outbound.Tag] = true
+	}
+	return tags, nil
+}
+
+// GetXrayOutbounds returns the enabled panel-managed outbounds in the Xray outbound format.
+func (s *OutboundService) GetXrayOutbounds() ([]any, error) {
+	outbounds, err := s.GetOutbounds()
+	if err != nil {
+		return nil, err
+	}
+	xrayOutbounds := make([]any, 0, len(outbounds))
+	for _, outbound := range outbounds {
+		if outbound.Enable {
+			xrayOutbounds = append(xrayOutbounds, outboundToXray(outbound))
+		}
+	}
+	return xrayOutbounds, nil
+}
+
+// outboundToXray converts a panel-managed outbound into an Xray outbound object.
+func outboundToXray(outbound *model.Outbound) map[string]any {
+	settings := map[string]any{}
+	switch outbound.Protocol {
+	case "freedom":
+		if outbound.DomainStrategy != "" {
+			settings["domainStrategy"] = outbound.DomainStrategy
+		}
+	case "blackhole":
+	case "socks", "http":
+		server := map[string]any{
+			"address": outbound.Address,
+			"port":    outbound.Port,
+		}
+		if outbound.Username != "" {
+			server["users"] = []any{map[string]any{"user": outbound.Username, "pass": outbound.Password}}
+		}
+		settings["servers"] = []any{server}
+	case "wireguard":
+		settings["secretKey"] = outbound.PrivateKey
+		settings["address"] = splitRuleList(outbound.LocalAddress)
+		settings["peers"] = []any{map[string]any{
+			"publicKey": outbound.PeerPublicKey,
+			"endpoint":  net.JoinHostPort(outbound.Address, strconv.Itoa(outbound.Port)),
+		}}
+		if outbound.MTU > 0 {
+			settings["mtu"] = outbound.MTU
+		}
+		if reserved := splitRuleList(outbound.Reserved); len(reserved) > 0 {
+			values := make([]int, 0, len(reserved))
+			for _, value := range reserved {
+				b, _ := strconv.Atoi(value)
+				values = append(values, b)
+			}
+			settings["reserved"] = values
+		}
+		if outbound.DomainStrategy != "" {
+			settings["domainStrategy"] = outbound.DomainStrategy
+		}
+		settings["noKernelTun"] = false
+	}
+
+	xrayOutbound := map[string]any{
+		"tag":      outbound.Tag,
+		"protocol": outbound.Protocol,
+		"settings": settings,
+	}
+	if outbound.DialerProxy != "" {
+		xrayOutbound["streamSettings"] = map[string]any{
+			"sockopt": map[string]any{"dialerProxy": outbound.DialerProxy},
+		}
+	}
+	return xrayOutbound
+}
+
+// validateOutbound checks the fields of an outbound for its protocol and its dialer proxy chain.
+func (s *OutboundService) validateOutbound(outbound *model.Outbound) error {
+	outbound.Tag = strings.TrimSpace(outbound.Tag)
+	outbound.Address = strings.TrimSpace(outbound.Address)
+	outbound.DialerProxy = strings.TrimSpace(outbound.DialerProxy)
+	outbound.LocalAddress = strings.Join(splitRuleList(outbound.LocalAddress), ",")
+	outbound.Reserved = strings.Join(splitRuleList(outbound.Reserved), ",")
+
+	if outbound.Tag == "" {
+		return common.NewError("Outbound tag is empty")
+	}
+	if outbound.Tag == "api" {
+		return common.NewError("Outbound tag is reserved:", outbound.Tag)
+	}
+	templateTags, err := s.getTemplateOutboundTags()
+	if err != nil {
+		return err
+	}
+	if templateTags[outbound.Tag] {
+		return common.NewError("Outbound tag is already used in the Xray template:", outbound.Tag)
+	}
+
+	switch outbound.Protocol {
+	case "freedom", "blackhole":
+	case "socks", "http", "wireguard":
+		if outbound.Address == "" || outbound.Port < 1 || outbound.Port > 65535 {
+			return common.NewError("Outbound needs a server address and port")
+		}
+	default:
+		return common.NewError("Outbound protocol is not supported:", outbound.Protocol)
+	}
+	if outbound.Protocol == "wireguard" {
+		if !validWireguardKey(outbound.PrivateKey) || !validWireguardKey(outbound.PeerPublicKey) {
+			return common.NewError("WireGuard keys must be 32 bytes encoded as base64")
+		}
+		if outbound.LocalAddress == "" {
+			return common.NewError("WireGuard outbound needs a local address")
+		}
+		for _, address := range splitRuleList(outbound.LocalAddress) {
+			if _, _, err := net.ParseCIDR(address); err != nil && net.ParseIP(address) == nil {
+				return common.NewError("WireGuard local address is not valid:", address)
+			}
+		}
+		for _, value := range splitRuleList(outbound.Reserved) {
+			if b, err := strconv.Atoi(value); err != nil || b < 0 || b > 255 {
+				return common.NewError("WireGuard reserved bytes must be between 0 and 255:", value)
+			}
+		}
+	}
+
+	return s.checkDialerChain(outbound)
+}
+
+// checkDialerChain makes sure the dialer proxy of an outbound exists and the chain has no loop.
+func (s *OutboundService) checkDialerChain(outbound *model.Outbound) error {
+	if outbound.DialerProxy == "" {
+		return nil
+	}
+	if outbound.Protocol == "freedom" || outbound.Protocol == "blackhole" {
+		return common.NewError("Outbound protocol does not dial a server:", outbound.Protocol)
+	}
+	tags, err := s.GetOutboundTags()
+	if err != nil {
+		return err
+	}
+	if !tags[outbound.DialerProxy] {
+		return common.NewError("Dialer proxy outbound does not exist:", outbound.DialerProxy)
+	}
+
+	outbounds, err := s.GetOutbounds()
+	if err != nil {
+		return err
+	}
+	dialers := make(map[string]string, len(outbounds))
+	for _, o := range outbounds {
+		dialers[o.Tag] = o.DialerProxy
+	}
+	dialers[outbound.Tag] = outbound.DialerProxy
+	for tag, steps := outbound.DialerProxy, 0; tag != ""; tag, steps = dialers[tag], steps+1 {
+		if tag == outbound.Tag || steps > len(dialers) {
+			return common.NewError("Dialer proxy chain forms a loop:", outbound.Tag)
+		}
+	}
+	return nil
+}
+
+// checkOutboundUnused returns an error if a routing rule, an inbound or another outbound uses the tag.
+func (s *OutboundService) checkOutboundUnused(tag string) error {
+	db := database.GetDB()
+	var count int64
+	if err := db.Model(model.RoutingRule{}).Where("outbound_tag = ?", tag).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return common.NewError("Outbound is used by routing rules:", tag)
+	}
+	if err := db.Model(model.Inbound{}).Where("outbound_tag = ?", tag).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return common.NewError("Outbound is used by inbounds:", tag)
+	}
+	if err := db.Model(model.Outbound{}).Where("dialer_proxy = ?", tag).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return common.NewError("Outbound is used as dialer proxy:", tag)
+	}
+	return nil
+}
+
+// validWireguardKey reports whether key is a base64 encoded 32 byte WireGuard key.
+func validWireguardKey(key string) bool {
+	data, err := base64.StdEncoding.DecodeString(key)
+	return err == nil && len(data) == 32
+}
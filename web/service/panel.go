@@ -10,7 +10,9 @@ import (
 
 // PanelService provides business logic for panel management operations.
 // It handles panel restart, updates, and system-level panel controls.
-type PanelService struct{}
+type PanelService struct {
+	xrayService XrayService
+}
 
 func (s *PanelService) RestartPanel(delay time.Duration) error {
 	p, err := os.FindProcess(syscall.Getpid())
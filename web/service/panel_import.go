@@ -0,0 +1,360 @@
+package service
+
+import (
+	"encoding/json"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// Export formats of other panels ImportPanelUsers reads.
+const (
+	PanelImportAuto     = "auto"
+	PanelImportMarzban  = "marzban"
+	PanelImportHiddify  = "hiddify"
+	panelImportGigabyte = 1 << 30
+	panelImportDay      = int64(86400000)
+)
+
+// panelImportProtocols are the protocols users are imported for, in the order their clients are
+// added. The first client of a user keeps its name as email and carries the traffic it used.
+var panelImportProtocols = []model.Protocol{model.VLESS, model.VMESS, model.Trojan, model.Shadowsocks}
+
+// importedUser is a user of another panel, converted to the terms of this one.
+type importedUser struct {
+	Name       string
+	Enable     bool
+	Total      int64 // Bytes, 0 for unlimited
+	Used       int64 // Bytes
+	ExpiryTime int64 // Unix milliseconds, negative for a duration starting on first use, 0 for never
+	Reset      int   // Days between traffic resets, 0 for never
+	Comment    string
+	TgId       int64
+	Proxies    map[model.Protocol]*importedProxy
+}
+
+// importedProxy is the credential of a user for one protocol.
+type importedProxy struct {
+	ID       string
+	Password string
+	Flow     string
+	Method   string
+}
+
+// PanelImportResult reports what ImportPanelUsers did.
+type PanelImportResult struct {
+	Format   string         `json:"format"`
+	Users    int            `json:"users"`    // Users in the export
+	Clients  map[string]int `json:"clients"`  // Clients added per protocol
+	Skipped  []string       `json:"skipped"`  // Clients whose email already exists
+	Unmapped []string       `json:"unmapped"` // Protocols with users but no target inbound
+}
+
+// ImportPanelUsers converts the users of an export of another panel into clients of the inbounds
+// given per protocol, keeping their credentials, quotas, used traffic and expiry, so that their
+// apps keep working once pointed at this server. Users with several protocols get a client in each
+// inbound, sharing one subscription ID; the emails of all but the first get the protocol appended.
+// Marzban exports are the users list of its API, Hiddify exports are the backup of its manager.
+func (s *InboundService) ImportPanelUsers(data []byte, format string, targets map[model.Protocol]int) (*PanelImportResult, bool, error) {
+	format, users, err := parsePanelExport(data, format)
+	if err != nil {
+		return nil, false, err
+	}
+	result := &PanelImportResult{Format: format, Users: len(users), Clients: map[string]int{}, Skipped: []string{}, Unmapped: []string{}}
+
+	allEmails, err := s.getAllEmails()
+	if err != nil {
+		return nil, false, err
+	}
+	taken := make(map[string]bool, len(allEmails))
+	for _, email := range allEmails {
+		taken[strings.ToLower(email)] = true
+	}
+	subIds := make(map[string]string, len(users))
+	firstEmail := make(map[string]string, len(users))
+	needRestart := false
+	for _, protocol := range panelImportProtocols {
+		var pending []*importedUser
+		for _, user := range users {
+			if user.Proxies[protocol] != nil {
+				pending = append(pending, user)
+			}
+		}
+		if len(pending) == 0 {
+			continue
+		}
+		inboundId := targets[protocol]
+		if inboundId == 0 {
+			result.Unmapped = append(result.Unmapped, string(protocol))
+			continue
+		}
+		inbound, err := s.GetInbound(inboundId)
+		if err != nil {
+			return result, needRestart, common.NewError("inbound not found:", inboundId)
+		}
+		if inbound.Protocol != protocol {
+			return result, needRestart, common.NewErrorf("inbound %d is %s, not %s", inboundId, inbound.Protocol, protocol)
+		}
+		var inboundSettings map[string]any
+		_ = json.Unmarshal([]byte(inbound.Settings), &inboundSettings)
+		method, _ := inboundSettings["method"].(string)
+		var stream map[string]any
+		_ = json.Unmarshal([]byte(inbound.StreamSettings), &stream)
+		network, _ := stream["network"].(string)
+		// Vision only works over TCP, clients of other transports must not keep it
+		keepFlow := network == "tcp" || network == "raw"
+
+		var clients []any
+		for _, user := range pending {
+			email := user.Name
+			if _, ok := firstEmail[user.Name]; ok {
+				email = user.Name + "-" + string(protocol)
+			}
+			if taken[strings.ToLower(email)] {
+				result.Skipped = append(result.Skipped, email)
+				continue
+			}
+			taken[strings.ToLower(email)] = true
+			if _, ok := firstEmail[user.Name]; !ok {
+				firstEmail[user.Name] = email
+			}
+			if subIds[user.Name] == "" {
+				subIds[user.Name] = random.SeqFrom(16, clientLowerAndNum)
+			}
+			client := importedClient(user, protocol, method, email, subIds[user.Name])
+			if !keepFlow {
+				delete(client, "flow")
+			}
+			clients = append(clients, client)
+		}
+		if len(clients) == 0 {
+			continue
+		}
+		settings, err := json.Marshal(map[string]any{"clients": clients})
+		if err != nil {
+			return result, needRestart, err
+		}
+		restart, err := s.AddInboundClient(&model.Inbound{Id: inbound.Id, Settings: string(settings)})
+		if err != nil {
+			return result, needRestart, err
+		}
+		needRestart = needRestart || restart
+		result.Clients[string(protocol)] = len(clients)
+		logger.Infof("Imported %d %s users of %s into inbound %s", len(clients), protocol, format, inbound.Remark)
+	}
+
+	// The traffic a user used is carried by its first client only, so that it counts once
+	db := database.GetDB()
+	for _, user := range users {
+		email, ok := firstEmail[user.Name]
+		if !ok || user.Used == 0 {
+			continue
+		}
+		err := db.Model(xray.ClientTraffic{}).Where("email = ?", email).Updates(map[string]any{"up": 0, "down": user.Used}).Error
+		if err != nil {
+			return result, needRestart, err
+		}
+	}
+	return result, needRestart, nil
+}
+
+// importedClient returns the client of a user in an inbound of a protocol.
+func importedClient(user *importedUser, protocol model.Protocol, method string, email string, subId string) map[string]any {
+	proxy := user.Proxies[protocol]
+	client := map[string]any{
+		"email":      email,
+		"enable":     user.Enable,
+		"totalGB":    user.Total,
+		"expiryTime": user.ExpiryTime,
+		"reset":      user.Reset,
+		"subId":      subId,
+		"comment":    user.Comment,
+		"tgId":       user.TgId,
+		"limitIp":    0,
+	}
+	switch protocol {
+	case model.VLESS:
+		client["id"] = proxy.ID
+		client["flow"] = proxy.Flow
+	case model.VMESS:
+		client["id"] = proxy.ID
+		client["security"] = "auto"
+	case model.Trojan:
+		client["password"] = proxy.Password
+	case model.Shadowsocks:
+		// Keys of Shadowsocks 2022 have a fixed size, other passwords do not fit. The client
+		// policy generates a new key for these users.
+		if !strings.HasPrefix(method, "2022") {
+			client["password"] = proxy.Password
+		}
+	}
+	return client
+}
+
+// parsePanelExport reads the users of an export in the given format, or in the one it looks like.
+func parsePanelExport(data []byte, format string) (string, []*importedUser, error) {
+	var export struct {
+		Users []map[string]json.RawMessage `json:"users"`
+	}
+	if err := json.Unmarshal(data, &export); err != nil {
+		// Bare lists of users
+		if err := json.Unmarshal(data, &export.Users); err != nil {
+			return "", nil, common.NewError("the export is not JSON:", err)
+		}
+	}
+	if len(export.Users) == 0 {
+		return "", nil, common.NewError("no users in the export")
+	}
+	if format == "" || format == PanelImportAuto {
+		first := export.Users[0]
+		switch {
+		case first["proxies"] != nil:
+			format = PanelImportMarzban
+		case first["uuid"] != nil && first["usage_limit_GB"] != nil:
+			format = PanelImportHiddify
+		default:
+			return "", nil, common.NewError("unknown export format, set it explicitly")
+		}
+	}
+	users := make([]*importedUser, 0, len(export.Users))
+	for _, raw := range export.Users {
+		var user *importedUser
+		var err error
+		switch format {
+		case PanelImportMarzban:
+			user, err = parseMarzbanUser(raw)
+		case PanelImportHiddify:
+			user, err = parseHiddifyUser(raw)
+		default:
+			return "", nil, common.NewError("unsupported export format:", format)
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		if user.Name == "" {
+			return "", nil, common.NewError("user without a name in the export")
+		}
+		users = append(users, user)
+	}
+	return format, users, nil
+}
+
+// parseMarzbanUser converts a user of the users list of the Marzban API.
+func parseMarzbanUser(raw map[string]json.RawMessage) (*importedUser, error) {
+	var u struct {
+		Username               string                     `json:"username"`
+		Status                 string                     `json:"status"`
+		UsedTraffic            int64                      `json:"used_traffic"`
+		DataLimit              *int64                     `json:"data_limit"`
+		DataLimitResetStrategy string                     `json:"data_limit_reset_strategy"`
+		Expire                 *int64                     `json:"expire"` // Unix seconds
+		OnHoldExpireDuration   *int64                     `json:"on_hold_expire_duration"`
+		Note                   *string                    `json:"note"`
+		Proxies                map[string]json.RawMessage `json:"proxies"`
+	}
+	if err := remarshal(raw, &u); err != nil {
+		return nil, err
+	}
+	user := &importedUser{
+		Name:    u.Username,
+		Enable:  u.Status == "active" || u.Status == "on_hold",
+		Used:    u.UsedTraffic,
+		Proxies: map[model.Protocol]*importedProxy{},
+	}
+	if u.DataLimit != nil {
+		user.Total = *u.DataLimit
+	}
+	if u.Expire != nil && *u.Expire > 0 {
+		user.ExpiryTime = *u.Expire * 1000
+	}
+	if u.Status == "on_hold" && u.OnHoldExpireDuration != nil && *u.OnHoldExpireDuration > 0 {
+		user.ExpiryTime = -*u.OnHoldExpireDuration * 1000
+	}
+	if u.Note != nil {
+		user.Comment = *u.Note
+	}
+	user.Reset = map[string]int{"day": 1, "week": 7, "month": 30, "year": 365}[u.DataLimitResetStrategy]
+	for name, settings := range u.Proxies {
+		var p struct {
+			ID       string `json:"id"`
+			Password string `json:"password"`
+			Flow     string `json:"flow"`
+			Method   string `json:"method"`
+		}
+		if err := json.Unmarshal(settings, &p); err != nil {
+			return nil, common.NewErrorf("proxy %s of user %s: %v", name, u.Username, err)
+		}
+		protocol := model.Protocol(strings.ToLower(name))
+		if slices.Contains(panelImportProtocols, protocol) {
+			user.Proxies[protocol] = &importedProxy{ID: p.ID, Password: p.Password, Flow: p.Flow, Method: p.Method}
+		}
+	}
+	return user, nil
+}
+
+// parseHiddifyUser converts a user of a Hiddify Manager backup. Hiddify users have one UUID, the
+// password of their Trojan proxy as well.
+func parseHiddifyUser(raw map[string]json.RawMessage) (*importedUser, error) {
+	var u struct {
+		UUID           string  `json:"uuid"`
+		Name           string  `json:"name"`
+		Enable         *bool   `json:"enable"`
+		UsageLimitGB   float64 `json:"usage_limit_GB"`
+		CurrentUsageGB float64 `json:"current_usage_GB"`
+		PackageDays    int64   `json:"package_days"`
+		StartDate      *string `json:"start_date"`
+		Mode           string  `json:"mode"`
+		Comment        *string `json:"comment"`
+		TelegramId     *int64  `json:"telegram_id"`
+	}
+	if err := remarshal(raw, &u); err != nil {
+		return nil, err
+	}
+	user := &importedUser{
+		Name:   u.Name,
+		Enable: u.Enable == nil || *u.Enable,
+		Total:  int64(math.Round(u.UsageLimitGB * panelImportGigabyte)),
+		Used:   int64(math.Round(u.CurrentUsageGB * panelImportGigabyte)),
+		Reset:  map[string]int{"daily": 1, "weekly": 7, "monthly": 30}[u.Mode],
+		Proxies: map[model.Protocol]*importedProxy{
+			model.VLESS:  {ID: u.UUID},
+			model.VMESS:  {ID: u.UUID},
+			model.Trojan: {Password: u.UUID},
+		},
+	}
+	if user.Name == "" {
+		user.Name = u.UUID
+	}
+	if u.PackageDays > 0 {
+		user.ExpiryTime = -u.PackageDays * panelImportDay
+		if u.StartDate != nil && *u.StartDate != "" {
+			if start, err := time.Parse("2006-01-02", (*u.StartDate)[:min(10, len(*u.StartDate))]); err == nil {
+				user.ExpiryTime = start.AddDate(0, 0, int(u.PackageDays)).UnixMilli()
+			}
+		}
+	}
+	if u.Comment != nil {
+		user.Comment = *u.Comment
+	}
+	if u.TelegramId != nil {
+		user.TgId = *u.TelegramId
+	}
+	return user, nil
+}
+
+// remarshal decodes the fields of a raw JSON object into v.
+func remarshal(raw map[string]json.RawMessage, v any) error {
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
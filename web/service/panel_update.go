@@ -0,0 +1,538 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+)
+
+// panelRepo is the GitHub repository the releases of the panel are published in.
+const panelRepo = "MHSanaei/3x-ui"
+
+// panelServiceName is the name of the systemd unit the panel is installed as, when it cannot be
+// read from the control group of the process.
+const panelServiceName = "x-ui"
+
+// panelVersionPattern matches release tags of the panel. Versions are used in URLs, so anything
+// else is rejected.
+var panelVersionPattern = regexp.MustCompile(`^v\d+\.\d+\.\d+$`)
+
+// PanelUpdate describes the running version of the panel and its latest release.
+type PanelUpdate struct {
+	Current   string `json:"current"`   // Version of the running panel
+	Latest    string `json:"latest"`    // Version of the latest release
+	Available bool   `json:"available"` // Whether the latest release is newer than the running panel
+	Supported bool   `json:"supported"` // Whether this build can verify releases and so update itself
+}
+
+// panelUpdateState is the update waiting for the new version to start, kept next to the binary.
+type panelUpdateState struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Starts int    `json:"starts"` // How many times the new version started without confirming
+}
+
+// panelBinaryPath returns the path of the running binary of the panel.
+func panelBinaryPath() (string, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(path)
+}
+
+// panelUpdateStatePath returns the path of the state of the update in progress.
+func panelUpdateStatePath(binary string) string {
+	return binary + ".update"
+}
+
+// panelDBBackupPath returns the path the database is copied to before an update.
+func panelDBBackupPath() string {
+	return config.GetDBPath() + ".pre-update"
+}
+
+// panelReleaseKey returns the public key the checksums of the releases are signed with, or nil
+// when the build has none.
+func panelReleaseKey() ed25519.PublicKey {
+	key, err := base64.StdEncoding.DecodeString(config.GetReleaseKey())
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil
+	}
+	return key
+}
+
+// panelReleaseAssetName returns the name of the release archive for the current OS and architecture.
+func panelReleaseAssetName() string {
+	if runtime.GOOS == "windows" {
+		return "x-ui-windows-amd64.zip"
+	}
+	arch := runtime.GOARCH
+	if arch == "arm" {
+		arch = "armv7"
+		if info, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range info.Settings {
+				if setting.Key == "GOARM" {
+					// The value may carry the float ABI, e.g. 7,softfloat
+					arch = "armv" + strings.Split(setting.Value, ",")[0]
+				}
+			}
+		}
+	}
+	return fmt.Sprintf("x-ui-linux-%s.tar.gz", arch)
+}
+
+// panelReleaseBinaryName returns the path of the panel binary in the release archive.
+func panelReleaseBinaryName() string {
+	if runtime.GOOS == "windows" {
+		return "x-ui/xui-release.exe"
+	}
+	return "x-ui/x-ui"
+}
+
+// CheckPanelUpdate returns the running version of the panel and its latest release.
+func (s *PanelService) CheckPanelUpdate() (*PanelUpdate, error) {
+	resp, err := http.Get(fmt.Sprintf("https://api.github.com/repos/%s/releases/latest", panelRepo))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, common.NewErrorf("Failed to get the latest release: %s", resp.Status)
+	}
+
+	var release Release
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return nil, err
+	}
+	if !panelVersionPattern.MatchString(release.TagName) {
+		return nil, common.NewError("Invalid panel version:", release.TagName)
+	}
+
+	current := "v" + config.GetVersion()
+	return &PanelUpdate{
+		Current:   current,
+		Latest:    release.TagName,
+		Available: panelVersionPattern.MatchString(current) && compareXrayVersions(release.TagName, current) > 0,
+		Supported: panelReleaseKey() != nil,
+	}, nil
+}
+
+// fetchReleaseFile downloads a small file published with a release.
+func fetchReleaseFile(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, common.NewErrorf("Failed to download %s: %s", filepath.Base(url), resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+}
+
+// fetchPanelSHA256 downloads the checksum file published next to a release archive, verifies its
+// signature and returns the SHA-256 checksum of the archive.
+func fetchPanelSHA256(url string, key ed25519.PublicKey) (string, error) {
+	sums, err := fetchReleaseFile(url + ".sha256")
+	if err != nil {
+		return "", err
+	}
+	signature, err := fetchReleaseFile(url + ".sha256.sig")
+	if err != nil {
+		return "", err
+	}
+	if !ed25519.Verify(key, sums, signature) {
+		return "", common.NewError("Invalid signature of the release checksum")
+	}
+
+	// The file is written by sha256sum: the checksum, then the name of the archive
+	fields := strings.Fields(string(sums))
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		return "", common.NewError("SHA-256 checksum not found in checksum file")
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// downloadPanelRelease downloads the release archive of a version into a temporary file and
+// verifies it against the signed checksum.
+func downloadPanelRelease(version string, key ed25519.PublicKey) (string, error) {
+	asset := panelReleaseAssetName()
+	url := fmt.Sprintf("https://github.com/%s/releases/download/%s/%s", panelRepo, version, asset)
+	checksum, err := fetchPanelSHA256(url, key)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", common.NewErrorf("Failed to download panel %s: %s", version, resp.Status)
+	}
+
+	file, err := os.CreateTemp("", "x-ui-*-"+asset)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(file, hash), resp.Body); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != checksum {
+		os.Remove(file.Name())
+		return "", common.NewErrorf("Checksum mismatch for panel %s: expected %s, got %s", version, checksum, sum)
+	}
+
+	return file.Name(), nil
+}
+
+// extractPanelBinary writes the panel binary of a release archive to a file.
+func extractPanelBinary(archive, target string) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var binary io.Reader
+	if strings.HasSuffix(archive, ".zip") {
+		stat, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		reader, err := zip.NewReader(file, stat.Size())
+		if err != nil {
+			return err
+		}
+		zipFile, err := reader.Open(panelReleaseBinaryName())
+		if err != nil {
+			return err
+		}
+		defer zipFile.Close()
+		binary = zipFile
+	} else {
+		gr, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		tr := tar.NewReader(gr)
+		for binary == nil {
+			header, err := tr.Next()
+			if err == io.EOF {
+				return common.NewError("No panel binary in the release archive")
+			}
+			if err != nil {
+				return err
+			}
+			if header.Typeflag == tar.TypeReg && strings.TrimPrefix(header.Name, "./") == panelReleaseBinaryName() {
+				binary = tr
+			}
+		}
+	}
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, binary); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// panelBinaryVersion runs a panel binary to read its version, or returns "" if it cannot be run.
+func panelBinaryVersion(path string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, path, "-v").Output()
+	if err != nil {
+		return ""
+	}
+	return "v" + strings.TrimSpace(string(output))
+}
+
+// backupPanelDB copies the SQLite database before an update, for the rollback to restore the
+// database the previous version used. MySQL databases are left to the backups of their server.
+func backupPanelDB() error {
+	dbConfig, err := config.GetDatabaseConfig()
+	if err != nil {
+		return err
+	}
+	os.Remove(panelDBBackupPath())
+	if dbConfig.Connection == "mysql" {
+		return nil
+	}
+	if err := database.Checkpoint(); err != nil {
+		return err
+	}
+
+	in, err := os.Open(config.GetDBPath())
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(panelDBBackupPath(), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(panelDBBackupPath())
+		return err
+	}
+	return out.Close()
+}
+
+// swapPanelBinary replaces the binary of the panel with a staged one, keeping the replaced one
+// for the rollback. The binary is replaced atomically, except on Windows, where a running
+// executable can only be moved away.
+func swapPanelBinary(binary, staged string) error {
+	previous := binary + ".prev"
+	os.Remove(previous)
+	if runtime.GOOS == "windows" {
+		if err := os.Rename(binary, previous); err != nil {
+			return err
+		}
+		if err := os.Rename(staged, binary); err != nil {
+			os.Rename(previous, binary)
+			return err
+		}
+		return nil
+	}
+	if err := os.Link(binary, previous); err != nil {
+		if err := copyBinary(binary, previous); err != nil {
+			return err
+		}
+	}
+	return os.Rename(staged, binary)
+}
+
+// readPanelUpdateState reads the state of the update in progress.
+func readPanelUpdateState(binary string) (*panelUpdateState, error) {
+	data, err := os.ReadFile(panelUpdateStatePath(binary))
+	if err != nil {
+		return nil, err
+	}
+	state := &panelUpdateState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// writePanelUpdateState writes the state of the update in progress.
+func writePanelUpdateState(binary string, state *panelUpdateState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(panelUpdateStatePath(binary), data, 0o600)
+}
+
+// InstallPanelUpdate downloads a release of the panel, verifies it, backs up the database and
+// replaces the binary of the panel with it. The new version runs once the panel restarts, and the
+// previous one is restored if it fails to start.
+func (s *PanelService) InstallPanelUpdate(version string) error {
+	if !panelVersionPattern.MatchString(version) {
+		return common.NewError("Invalid panel version:", version)
+	}
+	key := panelReleaseKey()
+	if key == nil {
+		return common.NewError("This build cannot verify releases, update the panel with the install script")
+	}
+	binary, err := panelBinaryPath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(panelUpdateStatePath(binary)); err == nil {
+		return common.NewError("A panel update waits for the panel to restart")
+	}
+
+	archive, err := downloadPanelRelease(version, key)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archive)
+
+	// The new binary is staged next to the running one, so that it can be renamed over it
+	staged := binary + ".new"
+	defer os.Remove(staged)
+	if err := extractPanelBinary(archive, staged); err != nil {
+		return err
+	}
+	if stagedVersion := panelBinaryVersion(staged); stagedVersion != version {
+		return common.NewErrorf("The downloaded panel does not run on this system or is not version %s", version)
+	}
+
+	if err := backupPanelDB(); err != nil {
+		return common.NewErrorf("Error backing up the database: %v", err)
+	}
+	state := &panelUpdateState{From: "v" + config.GetVersion(), To: version}
+	if err := writePanelUpdateState(binary, state); err != nil {
+		return err
+	}
+	if err := swapPanelBinary(binary, staged); err != nil {
+		os.Remove(panelUpdateStatePath(binary))
+		return err
+	}
+	logger.Infof("Panel %s installed, it runs once the panel restarts", version)
+	return nil
+}
+
+// UpdatePanel installs a release of the panel and restarts the panel to run it.
+func (s *PanelService) UpdatePanel(version string) error {
+	if err := s.InstallPanelUpdate(version); err != nil {
+		return err
+	}
+	return s.RestartPanelProcess(3 * time.Second)
+}
+
+// panelSystemdUnit returns the systemd unit of the panel, from its control group.
+func panelSystemdUnit() string {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err == nil {
+		for line := range strings.SplitSeq(strings.TrimSpace(string(data)), "\n") {
+			if unit := filepath.Base(line); strings.HasSuffix(unit, ".service") {
+				return unit
+			}
+		}
+	}
+	return panelServiceName
+}
+
+// RestartPanelProcess runs the binary of the panel again after a delay, unlike RestartPanel which
+// only restarts the servers. The service manager restarts the panel: systemd, OpenRC, or the
+// service control manager of Windows, which restarts the panel once it exits with a failure.
+// Otherwise, the process is replaced in place.
+func (s *PanelService) RestartPanelProcess(delay time.Duration) error {
+	binary, err := panelBinaryPath()
+	if err != nil {
+		return err
+	}
+	go func() {
+		time.Sleep(delay)
+		var cmd *exec.Cmd
+		switch {
+		case os.Getenv("INVOCATION_ID") != "":
+			cmd = exec.Command("systemctl", "--no-block", "restart", panelSystemdUnit())
+		case os.Getenv("RC_SVCNAME") != "":
+			cmd = exec.Command("rc-service", os.Getenv("RC_SVCNAME"), "restart")
+		case runtime.GOOS == "windows":
+			logger.Info("Exiting for the service manager to restart the panel")
+			os.Exit(1)
+		default:
+			// Xray would outlive the process otherwise
+			if err := s.xrayService.StopXray(); err != nil {
+				logger.Warning("failed to stop xray before restarting the panel:", err)
+			}
+			if err := syscall.Exec(binary, os.Args, os.Environ()); err != nil {
+				logger.Error("failed to restart the panel:", err)
+			}
+			return
+		}
+		if output, err := cmd.CombinedOutput(); err != nil {
+			logger.Errorf("failed to restart the panel: %v %s", err, output)
+		}
+	}()
+	return nil
+}
+
+// PreparePanelStart is called when the panel starts, before the database is opened. The first
+// start of a new version is counted; a second start before ConfirmPanelStart means the new
+// version failed to start, so the previous binary and its database are restored and run instead.
+func (s *PanelService) PreparePanelStart() error {
+	binary, err := panelBinaryPath()
+	if err != nil {
+		return nil
+	}
+	state, err := readPanelUpdateState(binary)
+	if err != nil {
+		return nil
+	}
+	if state.To != "v"+config.GetVersion() {
+		// The binary was replaced otherwise since
+		os.Remove(panelUpdateStatePath(binary))
+		return nil
+	}
+	if state.Starts == 0 {
+		state.Starts++
+		return writePanelUpdateState(binary, state)
+	}
+
+	logger.Warningf("Panel %s failed to start, rolling back to %s", state.To, state.From)
+	os.Remove(panelUpdateStatePath(binary))
+	if err := rollbackPanelDB(); err != nil {
+		return common.NewErrorf("Error restoring the database: %v", err)
+	}
+	failed := binary + ".failed"
+	os.Remove(failed)
+	if err := os.Rename(binary, failed); err != nil {
+		return err
+	}
+	if err := os.Rename(binary+".prev", binary); err != nil {
+		os.Rename(failed, binary)
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		return common.NewErrorf("Panel rolled back to %s, exiting for the service manager to start it", state.From)
+	}
+	return syscall.Exec(binary, os.Args, os.Environ())
+}
+
+// rollbackPanelDB restores the database copied before the update, if any.
+func rollbackPanelDB() error {
+	backup := panelDBBackupPath()
+	if _, err := os.Stat(backup); err != nil {
+		return nil
+	}
+	// The write-ahead log belongs to the database of the new version
+	os.Remove(config.GetDBPath() + "-wal")
+	os.Remove(config.GetDBPath() + "-shm")
+	return os.Rename(backup, config.GetDBPath())
+}
+
+// ConfirmPanelStart completes the update in progress once the new version started its servers.
+// The database backup is removed, while the replaced binary is kept.
+func (s *PanelService) ConfirmPanelStart() {
+	binary, err := panelBinaryPath()
+	if err != nil {
+		return
+	}
+	state, err := readPanelUpdateState(binary)
+	if err != nil {
+		return
+	}
+	os.Remove(panelUpdateStatePath(binary))
+	os.Remove(panelDBBackupPath())
+	logger.Infof("Panel updated from %s to %s", state.From, state.To)
+}
@@ -0,0 +1,231 @@
+package service
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"gorm.io/gorm"
+)
+
+// PlanService provides business logic for managing client plans.
+// A plan bundles a traffic quota, a duration, an IP limit and a speed tier
+// that are copied to the clients it is assigned to.
+type PlanService struct {
+	inboundService InboundService
+}
+
+// GetPlans returns all client plans ordered by name.
+func (s *PlanService) GetPlans() ([]*model.ClientPlan, error) {
+	db := database.GetDB()
+	var plans []*model.ClientPlan
+	err := db.Model(model.ClientPlan{}).Order("name").Find(&plans).Error
+	if err != nil {
+		return nil, err
+	}
+	return plans, nil
+}
+
+// GetPlan returns the client plan with the given ID.
+func (s *PlanService) GetPlan(id int) (*model.ClientPlan, error) {
+	db := database.GetDB()
+	plan := &model.ClientPlan{}
+	err := db.Model(model.ClientPlan{}).First(plan, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// AddPlan creates a new client plan.
+func (s *PlanService) AddPlan(plan *model.ClientPlan) error {
+	if plan.Name == "" {
+		return common.NewError("Plan name is empty")
+	}
+	plan.Id = 0
+	return database.GetDB().Create(plan).Error
+}
+
+// UpdatePlan saves a client plan. With cascade set, the new quota and IP limit are applied
+// to every client on the plan and their expiry is shifted by the change in duration.
+func (s *PlanService) UpdatePlan(plan *model.ClientPlan, cascade bool) error {
+	if plan.Name == "" {
+		return common.NewError("Plan name is empty")
+	}
+	oldPlan, err := s.GetPlan(plan.Id)
+	if err != nil {
+		return err
+	}
+
+	db := database.GetDB()
+	tx := db.Begin()
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	err = tx.Save(plan).Error
+	if err != nil {
+		return err
+	}
+	if cascade {
+		err = s.cascadePlan(tx, oldPlan, plan)
+	}
+	return err
+}
+
+// cascadePlan updates the clients assigned to a plan after the plan changed.
+func (s *PlanService) cascadePlan(tx *gorm.DB, oldPlan *model.ClientPlan, plan *model.ClientPlan) error {
+	// Read through tx, so the settings changed earlier in the transaction are kept
+	var inbounds []*model.Inbound
+	err := tx.Model(model.Inbound{}).Find(&inbounds).Error
+	if err != nil {
+		return err
+	}
+	expiryShift := int64(plan.ExpiryDays-oldPlan.ExpiryDays) * 86400000
+
+	for _, inbound := range inbounds {
+		var settings map[string]any
+		if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+			continue
+		}
+		clients, _ := settings["clients"].([]any)
+		changed := false
+		for _, client := range clients {
+			c, ok := client.(map[string]any)
+			if !ok {
+				continue
+			}
+			if planId, _ := c["planId"].(float64); int(planId) != plan.Id {
+				continue
+			}
+			c["totalGB"] = plan.TotalGB
+			c["limitIp"] = plan.LimitIP
+			expiryTime, _ := c["expiryTime"].(float64)
+			newExpiry := int64(expiryTime)
+			switch {
+			case plan.ExpiryDays == 0:
+				newExpiry = 0
+			case newExpiry > 0:
+				newExpiry += expiryShift
+			case newExpiry < 0:
+				// Not started yet, the expiry holds the duration
+				newExpiry = -int64(plan.ExpiryDays) * 86400000
+			}
+			c["expiryTime"] = newExpiry
+			c["updated_at"] = time.Now().UnixMilli()
+
+			email, _ := c["email"].(string)
+			err = tx.Model(xray.ClientTraffic{}).
+				Where("email = ?", email).
+				Updates(map[string]any{
+					"total":       plan.TotalGB,
+					"expiry_time": newExpiry,
+				}).Error
+			if err != nil {
+				return err
+			}
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		newSettings, err := json.MarshalIndent(settings, "", "  ")
+		if err != nil {
+			return err
+		}
+		err = s.inboundService.saveInboundHistory(tx, inbound)
+		if err != nil {
+			return err
+		}
+		err = tx.Model(model.Inbound{}).Where("id = ?", inbound.Id).Update("settings", string(newSettings)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DelPlan deletes a client plan that is not assigned to any client.
+func (s *PlanService) DelPlan(id int) error {
+	inbounds, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		return err
+	}
+	for _, inbound := range inbounds {
+		clients, err := s.inboundService.GetClients(inbound)
+		if err != nil {
+			continue
+		}
+		for _, client := range clients {
+			if client.PlanId == id {
+				return common.NewError("Plan is assigned to client:", client.Email)
+			}
+		}
+	}
+	return database.GetDB().Delete(model.ClientPlan{}, id).Error
+}
+
+// applyClientPlans copies the limits of the assigned plans to the clients in the inbound settings.
+// A plan with a duration sets the expiry relative to now, or to the first use for clients
+// with a delayed start.
+func applyClientPlans(settings string) (string, error) {
+	var data map[string]any
+	if err := json.Unmarshal([]byte(settings), &data); err != nil {
+		// Malformed settings are reported by the callers
+		return settings, nil
+	}
+	clients, _ := data["clients"].([]any)
+
+	settingService := SettingService{}
+	plans := make(map[int]*model.ClientPlan)
+	changed := false
+	for _, client := range clients {
+		c, ok := client.(map[string]any)
+		if !ok {
+			continue
+		}
+		planId, _ := c["planId"].(float64)
+		if planId <= 0 {
+			continue
+		}
+		plan, ok := plans[int(planId)]
+		if !ok {
+			plan = &model.ClientPlan{}
+			err := database.GetDB().Model(model.ClientPlan{}).First(plan, int(planId)).Error
+			if err != nil {
+				return settings, common.NewError("Plan not found:", int(planId))
+			}
+			plans[plan.Id] = plan
+		}
+
+		c["totalGB"] = plan.TotalGB
+		c["limitIp"] = plan.LimitIP
+		expiryTime, _ := c["expiryTime"].(float64)
+		switch {
+		case plan.ExpiryDays == 0:
+			c["expiryTime"] = 0
+		case expiryTime < 0:
+			c["expiryTime"] = -int64(plan.ExpiryDays) * 86400000
+		default:
+			c["expiryTime"] = settingService.ExpiryAfterDays(plan.ExpiryDays)
+		}
+		changed = true
+	}
+	if !changed {
+		return settings, nil
+	}
+
+	newSettings, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return settings, err
+	}
+	return string(newSettings), nil
+}
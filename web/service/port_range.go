@@ -0,0 +1,117 @@
+package service
+
+import (
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+)
+
+// portRangeChain is the nat chain redirecting the extra ports of the inbounds to their port.
+// sing-box listens on one port per inbound, so it needs these redirects to serve a port range.
+const portRangeChain = "XUI-PORT-RANGE"
+
+var (
+	// portRangeRedirected describes the redirects set up last, so they are only rebuilt when they
+	// change. It is empty until the first setup, which also clears redirects left by a previous run.
+	portRangeRedirected string
+	portRangeLock       sync.Mutex
+)
+
+// redirectPortRanges redirects the extra ports of the enabled inbounds to their port when sing-box
+// serves them, and removes the redirects for Xray, which listens on the port ranges itself.
+func (s *XrayService) redirectPortRanges(coreType string) {
+	if runtime.GOOS != "linux" {
+		return
+	}
+	if _, err := exec.LookPath("iptables"); err != nil {
+		return
+	}
+
+	var rules [][]string
+	if coreType == CoreSingbox {
+		inbounds, err := s.inboundService.GetAllInbounds()
+		if err != nil {
+			logger.Warning("get inbounds for the port range redirects failed:", err)
+			return
+		}
+		for _, inbound := range inbounds {
+			if !inbound.Enable || inbound.PortRange == "" || isSocketListen(inbound.Listen) {
+				continue
+			}
+			ranges, err := parsePortRange(inbound.PortRange)
+			if err != nil {
+				continue
+			}
+			commands := []string{"iptables", "ip6tables"}
+			var destination []string
+			if !isAnyListen(inbound.Listen) {
+				addr, err := netip.ParseAddr(inbound.Listen)
+				if err != nil {
+					continue
+				}
+				destination = []string{"-d", addr.String()}
+				if addr.Is4() {
+					commands = commands[:1]
+				} else {
+					commands = commands[1:]
+				}
+			}
+			for _, command := range commands {
+				for _, protocol := range []string{"tcp", "udp"} {
+					for _, r := range ranges {
+						rule := append([]string{command}, destination...)
+						rule = append(rule, "-p", protocol, "--dport", fmt.Sprintf("%d:%d", r[0], r[1]),
+							"-j", "REDIRECT", "--to-ports", strconv.Itoa(inbound.Port))
+						rules = append(rules, rule)
+					}
+				}
+			}
+		}
+	}
+
+	portRangeLock.Lock()
+	defer portRangeLock.Unlock()
+	redirected := fmt.Sprint(rules)
+	if redirected == portRangeRedirected {
+		return
+	}
+	commands := []string{"iptables"}
+	if _, err := exec.LookPath("ip6tables"); err == nil {
+		commands = append(commands, "ip6tables")
+	}
+	if len(rules) == 0 {
+		for _, command := range commands {
+			exec.Command(command, "-t", "nat", "-D", "PREROUTING", "-j", portRangeChain).Run()
+			exec.Command(command, "-t", "nat", "-F", portRangeChain).Run()
+			exec.Command(command, "-t", "nat", "-X", portRangeChain).Run()
+		}
+		portRangeRedirected = redirected
+		return
+	}
+
+	for _, command := range commands {
+		exec.Command(command, "-t", "nat", "-N", portRangeChain).Run()
+		exec.Command(command, "-t", "nat", "-F", portRangeChain).Run()
+		if exec.Command(command, "-t", "nat", "-C", "PREROUTING", "-j", portRangeChain).Run() != nil {
+			if err := runNetCommand(command, "-t", "nat", "-I", "PREROUTING", "-j", portRangeChain); err != nil {
+				logger.Warning("Port ranges are not redirected:", err)
+				return
+			}
+		}
+	}
+	for _, rule := range rules {
+		if rule[0] == "ip6tables" && len(commands) == 1 {
+			continue
+		}
+		args := append([]string{"-t", "nat", "-A", portRangeChain}, rule[1:]...)
+		if err := runNetCommand(rule[0], args...); err != nil {
+			logger.Warning("Port range is not redirected:", err)
+		}
+	}
+	portRangeRedirected = redirected
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// publicIPv6TTL is how long the detected public IPv6 address of the server is reused.
+const publicIPv6TTL = time.Hour
+
+// publicIPv6 is the public IPv6 address of the server when it was last detected.
+var publicIPv6 struct {
+	sync.Mutex
+	addr       string
+	detectedAt time.Time
+}
+
+// GetPublicIPv6 returns the public IPv6 address of the server, empty if it has none. A global
+// address of a network interface is preferred, otherwise the address is asked online. The
+// result is reused for an hour.
+func (s *ServerService) GetPublicIPv6() string {
+	publicIPv6.Lock()
+	defer publicIPv6.Unlock()
+	if time.Since(publicIPv6.detectedAt) < publicIPv6TTL {
+		return publicIPv6.addr
+	}
+	publicIPv6.addr = interfaceIPv6()
+	if publicIPv6.addr == "" {
+		for _, ip6Service := range showIp6ServiceLists {
+			if addr, err := netip.ParseAddr(getPublicIP(ip6Service)); err == nil && isPublicIPv6(addr) {
+				publicIPv6.addr = addr.String()
+				break
+			}
+		}
+	}
+	publicIPv6.detectedAt = time.Now()
+	return publicIPv6.addr
+}
+
+// interfaceIPv6 returns the first public IPv6 address of the network interfaces, or "".
+func interfaceIPv6() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if addr, ok := netip.AddrFromSlice(ipNet.IP); ok && isPublicIPv6(addr) {
+			return addr.String()
+		}
+	}
+	return ""
+}
+
+// isPublicIPv6 reports whether an address is a globally routable IPv6 address, excluding the
+// unique local ones.
+func isPublicIPv6(addr netip.Addr) bool {
+	return addr.Is6() && !addr.Is4In6() && addr.IsGlobalUnicast() && !addr.IsPrivate()
+}
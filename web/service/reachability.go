@@ -0,0 +1,226 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+)
+
+// InboundReachability is the result of the last reachability check of an inbound.
+type InboundReachability struct {
+	InboundId int     `json:"inboundId"`
+	Tag       string  `json:"tag"`
+	Remark    string  `json:"remark"`
+	Address   string  `json:"address"`   // Host and port that were checked
+	Security  string  `json:"security"`  // "none", "tls" or "reality"
+	Reachable bool    `json:"reachable"` // Whether a TCP connection was accepted
+	Handshake bool    `json:"handshake"` // Whether the TLS or REALITY handshake succeeded, true without security
+	LatencyMs float64 `json:"latencyMs"`
+	Skipped   bool    `json:"skipped"` // UDP based inbounds cannot be checked with a connection
+	Error     string  `json:"error"`
+	CheckedAt int64   `json:"checkedAt"` // Unix seconds
+}
+
+// OK reports whether the inbound was reachable and completed its handshake, or was skipped.
+func (r *InboundReachability) OK() bool {
+	return r.Skipped || (r.Reachable && r.Handshake)
+}
+
+var (
+	reachabilityLock    sync.Mutex
+	reachabilityResults = map[int]*InboundReachability{}
+	reachabilityHost    string // Detected public IPv4, kept between checks
+)
+
+// ReachabilityService checks that the enabled inbounds accept connections from the outside,
+// to find inbounds that run in the core but are blocked by a firewall or censorship.
+type ReachabilityService struct {
+	settingService SettingService
+	inboundService InboundService
+}
+
+// GetReachability returns the results of the last check, ordered by inbound.
+func (s *ReachabilityService) GetReachability() []*InboundReachability {
+	reachabilityLock.Lock()
+	defer reachabilityLock.Unlock()
+	results := make([]*InboundReachability, 0, len(reachabilityResults))
+	for _, result := range reachabilityResults {
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].InboundId < results[j].InboundId })
+	return results
+}
+
+// CheckInbounds connects to every enabled inbound through its public address and, for TLS and
+// REALITY, completes a handshake with its server name. With a probe URL configured the probe
+// is asked instead, so the check really comes from another network; it answers 2xx when the
+// address is reachable. Results of deleted or disabled inbounds are dropped.
+func (s *ReachabilityService) CheckInbounds() ([]*InboundReachability, error) {
+	host, err := s.settingService.GetReachabilityHost()
+	if err != nil {
+		return nil, err
+	}
+	if host == "" {
+		host = detectReachabilityHost()
+	}
+	if host == "" {
+		return nil, common.NewError("The public address of the server could not be detected, set the check host")
+	}
+	probe, err := s.settingService.GetReachabilityProbeUrl()
+	if err != nil {
+		return nil, err
+	}
+	inbounds, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		return nil, err
+	}
+
+	results := map[int]*InboundReachability{}
+	for _, inbound := range inbounds {
+		if !inbound.Enable || inbound.Port <= 0 {
+			continue
+		}
+		results[inbound.Id] = checkInboundReachability(inbound, host, probe)
+	}
+
+	reachabilityLock.Lock()
+	reachabilityResults = results
+	reachabilityLock.Unlock()
+	return s.GetReachability(), nil
+}
+
+// detectReachabilityHost returns the public IPv4 of the server, asking the IP services once.
+func detectReachabilityHost() string {
+	reachabilityLock.Lock()
+	defer reachabilityLock.Unlock()
+	if reachabilityHost == "" {
+		for _, ip4Service := range showIp4ServiceLists {
+			if ip := getPublicIP(ip4Service); ip != "N/A" {
+				reachabilityHost = ip
+				break
+			}
+		}
+	}
+	return reachabilityHost
+}
+
+func checkInboundReachability(inbound *model.Inbound, host string, probe string) *InboundReachability {
+	result := &InboundReachability{
+		InboundId: inbound.Id,
+		Tag:       inbound.Tag,
+		Remark:    inbound.Remark,
+		Address:   net.JoinHostPort(host, strconv.Itoa(inbound.Port)),
+		Security:  "none",
+		CheckedAt: time.Now().Unix(),
+	}
+
+	var stream map[string]any
+	_ = json.Unmarshal([]byte(inbound.StreamSettings), &stream)
+	network, _ := stream["network"].(string)
+	if inbound.Protocol == model.WireGuard || inbound.Protocol == model.Hysteria2 || inbound.Protocol == model.TUIC ||
+		network == "kcp" || network == "quic" {
+		result.Skipped = true
+		return result
+	}
+	var serverName string
+	if security, _ := stream["security"].(string); security == "tls" || security == "reality" {
+		result.Security = security
+		serverName = streamServerName(stream, security)
+	}
+
+	if probe != "" {
+		checkReachabilityProbe(result, probe, host, inbound.Port, serverName)
+		return result
+	}
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", result.Address, 5*time.Second)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer conn.Close()
+	result.Reachable = true
+	result.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+	if result.Security == "none" {
+		result.Handshake = true
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// The certificate is not verified: REALITY presents the one of its target and
+	// TLS inbounds often use self-signed certificates; the handshake itself is what is checked
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Handshake = true
+	return result
+}
+
+// streamServerName returns the server name a client of a TLS or REALITY inbound sends.
+func streamServerName(stream map[string]any, security string) string {
+	settings, _ := stream[security+"Settings"].(map[string]any)
+	if security == "tls" {
+		name, _ := settings["serverName"].(string)
+		return name
+	}
+	names, _ := settings["serverNames"].([]any)
+	for _, name := range names {
+		if name, ok := name.(string); ok && name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// checkReachabilityProbe asks the probe URL, with {host}, {port} and {sni} replaced, whether the inbound is reachable.
+func checkReachabilityProbe(result *InboundReachability, probe string, host string, port int, serverName string) {
+	target := strings.NewReplacer(
+		"{host}", url.QueryEscape(host),
+		"{port}", strconv.Itoa(port),
+		"{sni}", url.QueryEscape(serverName),
+	).Replace(probe)
+	client := &http.Client{Timeout: 15 * time.Second}
+	start := time.Now()
+	resp, err := client.Get(target)
+	if err != nil {
+		result.Error = err.Error()
+		return
+	}
+	defer resp.Body.Close()
+	result.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+	if resp.StatusCode/100 != 2 {
+		result.Error = "probe answered " + resp.Status
+		return
+	}
+	result.Reachable = true
+	result.Handshake = true
+}
+
+// unreachableInbounds returns the tags of the inbounds that failed their last check.
+func unreachableInbounds() []string {
+	reachabilityLock.Lock()
+	defer reachabilityLock.Unlock()
+	var tags []string
+	for _, result := range reachabilityResults {
+		if !result.OK() {
+			tags = append(tags, result.Tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+const (
+	// readCacheTTL bounds how long a read is served from the cache.
+	readCacheTTL = time.Minute
+	// readCacheMaxEntries bounds the entries of a cache, which is emptied once it is reached.
+	readCacheMaxEntries = 10000
+)
+
+// readCache is a read-through cache of values loaded from the database by key. It is emptied
+// whenever a write to the tables the values are loaded from commits, and its entries also expire
+// after readCacheTTL. The cached values are shared, so they must be copied before they are
+// handed out to callers that may change them.
+type readCache[T any] struct {
+	tables  []string
+	mu      sync.Mutex
+	version uint64
+	entries map[string]readCacheEntry[T]
+}
+
+// readCacheEntry is a cached value.
+type readCacheEntry[T any] struct {
+	value   T
+	expires time.Time
+}
+
+// newReadCache creates a cache of values loaded from the given tables.
+func newReadCache[T any](tables ...string) *readCache[T] {
+	return &readCache[T]{tables: tables, entries: make(map[string]readCacheEntry[T])}
+}
+
+// get returns the value of key from the cache, loading it with load on a miss.
+func (c *readCache[T]) get(key string, load func() (T, error)) (T, error) {
+	version := database.TableVersion(c.tables...)
+	now := time.Now()
+
+	c.mu.Lock()
+	if c.version != version {
+		c.version = version
+		clear(c.entries)
+	}
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.value, nil
+	}
+
+	value, err := load()
+	if err != nil {
+		return value, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// A write that committed during the load may not be in the value
+	if c.version == version && database.TableVersion(c.tables...) == version {
+		if len(c.entries) >= readCacheMaxEntries {
+			clear(c.entries)
+		}
+		c.entries[key] = readCacheEntry[T]{value: value, expires: now.Add(readCacheTTL)}
+	}
+	return value, nil
+}
+
+// cloneInbound copies an inbound with its client statistics.
+func cloneInbound(inbound *model.Inbound) *model.Inbound {
+	if inbound == nil {
+		return nil
+	}
+	clone := *inbound
+	clone.ClientStats = slices.Clone(inbound.ClientStats)
+	return &clone
+}
+
+// cloneInbounds copies inbounds with their client statistics.
+func cloneInbounds(inbounds []*model.Inbound) []*model.Inbound {
+	if inbounds == nil {
+		return nil
+	}
+	clones := make([]*model.Inbound, 0, len(inbounds))
+	for _, inbound := range inbounds {
+		clones = append(clones, cloneInbound(inbound))
+	}
+	return clones
+}
@@ -0,0 +1,279 @@
+package service
+
+import (
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/web/locale"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// Periods of the usage reports.
+const (
+	ReportDaily  = "daily"
+	ReportWeekly = "weekly"
+)
+
+// reportTopClients is the number of clients with the most traffic listed in a report.
+const reportTopClients = 5
+
+// ClientUsage is the traffic of a client during a report period.
+type ClientUsage struct {
+	Email string `json:"email"`
+	Up    int64  `json:"up"`
+	Down  int64  `json:"down"`
+}
+
+// UsageReport summarizes how the server was used during a period.
+type UsageReport struct {
+	Period         string         `json:"period"` // "daily" or "weekly"
+	From           int64          `json:"from"`   // Unix milliseconds, inclusive
+	To             int64          `json:"to"`     // Unix milliseconds, exclusive
+	Up             int64          `json:"up"`
+	Down           int64          `json:"down"`
+	TopClients     []*ClientUsage `json:"topClients"`
+	NewClients     []string       `json:"newClients"`
+	ExpiredClients []string       `json:"expiredClients"`
+	CoreRestarts   int64          `json:"coreRestarts"` // Restarts of the core after it crashed
+}
+
+// ReportService builds the periodic usage reports and delivers them to the administrators
+// through the notification channels and by email.
+type ReportService struct {
+	settingService SettingService
+	inboundService InboundService
+	mailService    MailService
+	notifyService  NotifyService
+}
+
+// BuildUsageReport summarizes the previous day or the previous seven days, ending at the
+// last midnight in the panel time zone.
+func (s *ReportService) BuildUsageReport(period string) (*UsageReport, error) {
+	days := 1
+	switch period {
+	case ReportDaily:
+	case ReportWeekly:
+		days = 7
+	default:
+		return nil, common.NewError("Unknown report period:", period)
+	}
+	loc, err := s.settingService.GetReportTimeLocation()
+	if err != nil {
+		loc = time.Local
+	}
+	now := time.Now().In(loc)
+	end := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	report := &UsageReport{
+		Period: period,
+		From:   end.AddDate(0, 0, -days).UnixMilli(),
+		To:     end.UnixMilli(),
+	}
+
+	db := database.GetDB()
+	// Samples of every resolution are summed, the buckets of a period never overlap
+	var total ClientUsage
+	err = db.Model(model.TrafficSample{}).
+		Select("COALESCE(SUM(up), 0) AS up, COALESCE(SUM(down), 0) AS down").
+		Where("kind = ? AND time >= ? AND time < ?", "inbound", report.From, report.To).
+		Scan(&total).Error
+	if err != nil {
+		return nil, err
+	}
+	report.Up, report.Down = total.Up, total.Down
+
+	err = db.Model(model.TrafficSample{}).
+		Select("name AS email, SUM(up) AS up, SUM(down) AS down").
+		Where("kind = ? AND time >= ? AND time < ?", "client", report.From, report.To).
+		Group("name").
+		Order("SUM(up + down) DESC").
+		Limit(reportTopClients).
+		Scan(&report.TopClients).Error
+	if err != nil {
+		return nil, err
+	}
+
+	inbounds, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		return nil, err
+	}
+	for _, inbound := range inbounds {
+		clients, _ := s.inboundService.GetClients(inbound)
+		for _, client := range clients {
+			if client.CreatedAt >= report.From && client.CreatedAt < report.To {
+				report.NewClients = append(report.NewClients, client.Email)
+			}
+		}
+	}
+
+	err = db.Model(xray.ClientTraffic{}).
+		Where("expiry_time >= ? AND expiry_time < ?", report.From, report.To).
+		Order("expiry_time").
+		Pluck("email", &report.ExpiredClients).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Model(model.CrashEvent{}).
+		Where("time >= ? AND time < ?", report.From, report.To).
+		Count(&report.CoreRestarts).Error
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// TrafficReportRow is the traffic of a client or an inbound in a traffic report.
+type TrafficReportRow struct {
+	Kind      string `json:"kind"` // "inbound" or "client"
+	Name      string `json:"name"` // Inbound tag or client email
+	InboundId int    `json:"inboundId"`
+	Remark    string `json:"remark"` // Remark of the inbound
+	Up        int64  `json:"up"`
+	Down      int64  `json:"down"`
+	Total     int64  `json:"total"`
+}
+
+// TrafficReport is the traffic of every inbound and client over a period, for billing.
+type TrafficReport struct {
+	From int64               `json:"from"` // Unix milliseconds, inclusive
+	To   int64               `json:"to"`   // Unix milliseconds, exclusive
+	Rows []*TrafficReportRow `json:"rows"`
+}
+
+// BuildTrafficReport sums the traffic samples of every inbound and client between from and to,
+// or only of one inbound and its clients if inboundId is set. Samples are rolled up into coarser
+// buckets as they age, so old periods are only as precise as the buckets kept.
+func (s *ReportService) BuildTrafficReport(from int64, to int64, inboundId int) (*TrafficReport, error) {
+	if from >= to {
+		return nil, common.NewError("Invalid report period")
+	}
+	inbounds, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		return nil, err
+	}
+	byTag := make(map[string]*model.Inbound, len(inbounds))
+	byEmail := make(map[string]*model.Inbound)
+	for _, inbound := range inbounds {
+		byTag[inbound.Tag] = inbound
+		for _, clientTraffic := range inbound.ClientStats {
+			byEmail[clientTraffic.Email] = inbound
+		}
+	}
+	if inboundId != 0 && !slices.ContainsFunc(inbounds, func(inbound *model.Inbound) bool { return inbound.Id == inboundId }) {
+		return nil, common.NewError("inbound not found:", inboundId)
+	}
+
+	var rows []*TrafficReportRow
+	err = database.GetDB().Model(model.TrafficSample{}).
+		Select("kind, name, SUM(up) AS up, SUM(down) AS down").
+		Where("time >= ? AND time < ?", from, to).
+		Group("kind, name").
+		Order("kind, SUM(up + down) DESC").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	report := &TrafficReport{From: from, To: to, Rows: []*TrafficReportRow{}}
+	for _, row := range rows {
+		inbound := byTag[row.Name]
+		if row.Kind == "client" {
+			inbound = byEmail[row.Name]
+		}
+		if inbound != nil {
+			row.InboundId = inbound.Id
+			row.Remark = inbound.Remark
+		}
+		if inboundId != 0 && row.InboundId != inboundId {
+			continue
+		}
+		row.Total = row.Up + row.Down
+		report.Rows = append(report.Rows, row)
+	}
+	return report, nil
+}
+
+// SendUsageReport builds the report of a period and sends it to the notification channels,
+// when enabled, and to the configured email addresses.
+func (s *ReportService) SendUsageReport(period string) error {
+	report, err := s.BuildUsageReport(period)
+	if err != nil {
+		return err
+	}
+	loc, err := s.settingService.GetReportTimeLocation()
+	if err != nil {
+		loc = time.Local
+	}
+	msg := formatUsageReport(report, loc)
+
+	if enabled, _ := s.settingService.GetReportTelegram(); enabled {
+		s.notifyService.Notify(NotifyReport, msg)
+	}
+
+	emailTo, err := s.settingService.GetReportEmailTo()
+	if err != nil || emailTo == "" {
+		return err
+	}
+	to := splitMailAddresses(emailTo)
+	subject := locale.I18n(locale.Bot, "tgbot.messages.reportSubject"+strings.ToUpper(period[:1])+period[1:], "Hostname=="+reportHostname())
+	return s.mailService.SendMail(to, subject, plainNotifyText(msg))
+}
+
+// formatUsageReport renders a report with the translations of the bot.
+func formatUsageReport(report *UsageReport, loc *time.Location) string {
+	bot := func(name string, params ...string) string {
+		return locale.I18n(locale.Bot, "tgbot.messages."+name, params...)
+	}
+	from := time.UnixMilli(report.From).In(loc)
+	to := time.UnixMilli(report.To).In(loc).AddDate(0, 0, -1)
+	days := from.Format("2006-01-02")
+	if report.Period == ReportWeekly {
+		days += " – " + to.Format("2006-01-02")
+	}
+
+	var sb strings.Builder
+	title := "reportDigestDaily"
+	if report.Period == ReportWeekly {
+		title = "reportDigestWeekly"
+	}
+	sb.WriteString(bot(title, "Hostname=="+reportHostname(), "Days=="+days))
+	sb.WriteString(bot("reportDigestTraffic",
+		"Total=="+common.FormatTraffic(report.Up+report.Down),
+		"Upload=="+common.FormatTraffic(report.Up),
+		"Download=="+common.FormatTraffic(report.Down)))
+	if len(report.TopClients) > 0 {
+		sb.WriteString(bot("reportDigestTopClients"))
+		for i, client := range report.TopClients {
+			sb.WriteString(bot("reportDigestClient",
+				"Rank=="+strconv.Itoa(i+1),
+				"Email=="+client.Email,
+				"Total=="+common.FormatTraffic(client.Up+client.Down)))
+		}
+	}
+	sb.WriteString(bot("reportDigestNewClients", "Count=="+strconv.Itoa(len(report.NewClients)), "Emails=="+reportEmailList(report.NewClients)))
+	sb.WriteString(bot("reportDigestExpiredClients", "Count=="+strconv.Itoa(len(report.ExpiredClients)), "Emails=="+reportEmailList(report.ExpiredClients)))
+	sb.WriteString(bot("reportDigestRestarts", "Count=="+strconv.FormatInt(report.CoreRestarts, 10)))
+	return sb.String()
+}
+
+// reportHostname returns the host name of the server, also when the Telegram bot is not running.
+func reportHostname() string {
+	if hostname != "" {
+		return hostname
+	}
+	host, _ := os.Hostname()
+	return host
+}
+
+// reportEmailList joins the first emails of a list for a report line.
+func reportEmailList(emails []string) string {
+	if len(emails) > 10 {
+		emails = append(emails[:10:10], "…")
+	}
+	return strings.Join(emails, ", ")
+}
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// ReverseService provides business logic for the panel-managed Xray reverse proxies.
+// Enabled bridges and portals are added to the reverse section of the generated config
+// together with the routing rules that connect them to their tunnel and target.
+type ReverseService struct {
+	outboundService OutboundService
+}
+
+// GetReverses returns all reverse proxies ordered by tag.
+func (s *ReverseService) GetReverses() ([]*model.ReverseProxy, error) {
+	db := database.GetDB()
+	var reverses []*model.ReverseProxy
+	err := db.Model(model.ReverseProxy{}).Order("tag").Find(&reverses).Error
+	if err != nil {
+		return nil, err
+	}
+	return reverses, nil
+}
+
+// GetReverse returns the reverse proxy with the given ID.
+func (s *ReverseService) GetReverse(id int) (*model.ReverseProxy, error) {
+	db := database.GetDB()
+	reverse := &model.ReverseProxy{}
+	err := db.Model(model.ReverseProxy{}).First(reverse, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return reverse, nil
+}
+
+// AddReverse validates and creates a reverse proxy.
+func (s *ReverseService) AddReverse(reverse *model.ReverseProxy) error {
+	reverse.Id = 0
+	if err := s.validateReverse(reverse); err != nil {
+		return err
+	}
+	return database.GetDB().Create(reverse).Error
+}
+
+// UpdateReverse validates and saves a reverse proxy.
+func (s *ReverseService) UpdateReverse(reverse *model.ReverseProxy) error {
+	if _, err := s.GetReverse(reverse.Id); err != nil {
+		return err
+	}
+	if err := s.validateReverse(reverse); err != nil {
+		return err
+	}
+	return database.GetDB().Save(reverse).Error
+}
+
+// DelReverse deletes a reverse proxy.
+func (s *ReverseService) DelReverse(id int) error {
+	return database.GetDB().Delete(model.ReverseProxy{}, id).Error
+}
+
+// validateReverse checks the type, domain and the tags a reverse proxy connects.
+func (s *ReverseService) validateReverse(reverse *model.ReverseProxy) error {
+	reverse.Tag = strings.TrimSpace(reverse.Tag)
+	reverse.Domain = strings.TrimSpace(reverse.Domain)
+	reverse.TunnelTag = strings.TrimSpace(reverse.TunnelTag)
+	reverse.TargetTag = strings.Join(splitRuleList(reverse.TargetTag), ",")
+
+	if reverse.Tag == "" {
+		return common.NewError("Reverse proxy tag is empty")
+	}
+	if reverse.Domain == "" || strings.ContainsAny(reverse.Domain, " \t,:") {
+		return common.NewError("Reverse proxy domain is not valid:", reverse.Domain)
+	}
+	if reverse.TunnelTag == "" || reverse.TargetTag == "" {
+		return common.NewError("Reverse proxy needs a tunnel and a target")
+	}
+
+	outboundTags, err := s.outboundService.GetOutboundTags()
+	if err != nil {
+		return err
+	}
+	// The tag of a bridge is used as an inbound tag and the tag of a portal as an outbound tag
+	if outboundTags[reverse.Tag] {
+		return common.NewError("Reverse proxy tag is already used by an outbound:", reverse.Tag)
+	}
+	inboundTags, err := getInboundTags()
+	if err != nil {
+		return err
+	}
+	if inboundTags[reverse.Tag] {
+		return common.NewError("Reverse proxy tag is already used by an inbound:", reverse.Tag)
+	}
+
+	switch reverse.Type {
+	case "bridge":
+		if strings.Contains(reverse.TargetTag, ",") {
+			return common.NewError("Reverse bridge target must be a single outbound")
+		}
+		for _, tag := range []string{reverse.TunnelTag, reverse.TargetTag} {
+			if !outboundTags[tag] {
+				return common.NewError("Reverse bridge outbound does not exist:", tag)
+			}
+		}
+	case "portal":
+		for _, tag := range append([]string{reverse.TunnelTag}, splitRuleList(reverse.TargetTag)...) {
+			if !inboundTags[tag] {
+				return common.NewError("Reverse portal inbound does not exist:", tag)
+			}
+		}
+		if strings.Contains(","+reverse.TargetTag+",", ","+reverse.TunnelTag+",") {
+			return common.NewError("Reverse portal tunnel inbound cannot also be an entry inbound")
+		}
+	default:
+		return common.NewError("Reverse proxy type must be bridge or portal:", reverse.Type)
+	}
+	return nil
+}
+
+// getInboundTags returns the tags of the inbounds stored in the database.
+func getInboundTags() (map[string]bool, error) {
+	var tags []string
+	err := database.GetDB().Model(model.Inbound{}).Pluck("tag", &tags).Error
+	if err != nil {
+		return nil, err
+	}
+	inboundTags := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		inboundTags[tag] = true
+	}
+	return inboundTags, nil
+}
+
+// reverseRules returns the routing rules that connect the enabled reverse proxies.
+// A bridge sends its own traffic for the internal domain through the tunnel and all other
+// traffic to the target; a portal sends traffic of the entry and tunnel inbounds to itself.
+func reverseRules(reverses []*model.ReverseProxy) []any {
+	var rules []any
+	for _, reverse := range reverses {
+		if !reverse.Enable {
+			continue
+		}
+		switch reverse.Type {
+		case "bridge":
+			rules = append(rules,
+				map[string]any{
+					"type":        "field",
+					"inboundTag":  []string{reverse.Tag},
+					"domain":      []string{"full:" + reverse.Domain},
+					"outboundTag": reverse.TunnelTag,
+				},
+				map[string]any{
+					"type":        "field",
+					"inboundTag":  []string{reverse.Tag},
+					"outboundTag": reverse.TargetTag,
+				})
+		case "portal":
+			rules = append(rules,
+				map[string]any{
+					"type":        "field",
+					"inboundTag":  splitRuleList(reverse.TargetTag),
+					"outboundTag": reverse.Tag,
+				},
+				map[string]any{
+					"type":        "field",
+					"inboundTag":  []string{reverse.TunnelTag},
+					"outboundTag": reverse.Tag,
+				})
+		}
+	}
+	return rules
+}
+
+// addReverses adds the enabled reverse proxies to the reverse section of the config and
+// returns their routing rules.
+func (s *ReverseService) addReverses(xrayConfig *xray.Config) ([]any, error) {
+	reverses, err := s.GetReverses()
+	if err != nil {
+		return nil, err
+	}
+	var bridges, portals []any
+	for _, reverse := range reverses {
+		if !reverse.Enable {
+			continue
+		}
+		entry := map[string]any{"tag": reverse.Tag, "domain": reverse.Domain}
+		if reverse.Type == "bridge" {
+			bridges = append(bridges, entry)
+		} else {
+			portals = append(portals, entry)
+		}
+	}
+	if len(bridges) == 0 && len(portals) == 0 {
+		return nil, nil
+	}
+
+	reverseConfig := map[string]any{}
+	if len(xrayConfig.Reverse) > 0 {
+		if err := json.Unmarshal(xrayConfig.Reverse, &reverseConfig); err != nil {
+			return nil, err
+		}
+	}
+	if len(bridges) > 0 {
+		templateBridges, _ := reverseConfig["bridges"].([]any)
+		reverseConfig["bridges"] = append(templateBridges, bridges...)
+	}
+	if len(portals) > 0 {
+		templatePortals, _ := reverseConfig["portals"].([]any)
+		reverseConfig["portals"] = append(templatePortals, portals...)
+	}
+	xrayConfig.Reverse, err = json.MarshalIndent(reverseConfig, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return reverseRules(reverses), nil
+}
@@ -0,0 +1,266 @@
+package service
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+
+	"gorm.io/gorm"
+)
+
+// RoutingService provides business logic for the panel-managed Xray routing rules.
+// Enabled rules are added to the routing section of the generated Xray config,
+// so they do not have to be edited inside the raw template.
+type RoutingService struct {
+	outboundService OutboundService
+	balancerService BalancerService
+}
+
+// GetRules returns all routing rules in the order they are applied.
+func (s *RoutingService) GetRules() ([]*model.RoutingRule, error) {
+	db := database.GetDB()
+	var rules []*model.RoutingRule
+	err := db.Model(model.RoutingRule{}).Order("priority").Order("id").Find(&rules).Error
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// GetRule returns the routing rule with the given ID.
+func (s *RoutingService) GetRule(id int) (*model.RoutingRule, error) {
+	db := database.GetDB()
+	rule := &model.RoutingRule{}
+	err := db.Model(model.RoutingRule{}).First(rule, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// AddRule validates and creates a routing rule. A rule without priority is appended after the others.
+func (s *RoutingService) AddRule(rule *model.RoutingRule) error {
+	if err := s.normalizeRule(rule); err != nil {
+		return err
+	}
+	db := database.GetDB()
+	if rule.Priority == 0 {
+		var maxPriority int
+		err := db.Model(model.RoutingRule{}).Select("COALESCE(MAX(priority), 0)").Scan(&maxPriority).Error
+		if err != nil {
+			return err
+		}
+		rule.Priority = maxPriority + 1
+	}
+	rule.Id = 0
+	return db.Create(rule).Error
+}
+
+// UpdateRule validates and saves a routing rule.
+func (s *RoutingService) UpdateRule(rule *model.RoutingRule) error {
+	if _, err := s.GetRule(rule.Id); err != nil {
+		return err
+	}
+	if err := s.normalizeRule(rule); err != nil {
+		return err
+	}
+	return database.GetDB().Save(rule).Error
+}
+
+// DelRule deletes a routing rule.
+func (s *RoutingService) DelRule(id int) error {
+	return database.GetDB().Delete(model.RoutingRule{}, id).Error
+}
+
+// SetRuleEnable enables or disables a routing rule.
+func (s *RoutingService) SetRuleEnable(id int, enable bool) error {
+	if _, err := s.GetRule(id); err != nil {
+		return err
+	}
+	return database.GetDB().Model(model.RoutingRule{}).Where("id = ?", id).Update("enable", enable).Error
+}
+
+// ReorderRules sets the priorities of the rules to the order of ids. Every rule has to be listed once.
+func (s *RoutingService) ReorderRules(ids []int) error {
+	rules, err := s.GetRules()
+	if err != nil {
+		return err
+	}
+	if len(ids) != len(rules) {
+		return common.NewError("Rule order must list every routing rule")
+	}
+	known := make(map[int]bool, len(rules))
+	for _, rule := range rules {
+		known[rule.Id] = true
+	}
+
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		for i, id := range ids {
+			if !known[id] {
+				return common.NewError("Rule order lists an unknown or duplicate rule:", id)
+			}
+			delete(known, id)
+			if err := tx.Model(model.RoutingRule{}).Where("id = ?", id).Update("priority", i+1).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetXrayRules returns the enabled routing rules in the Xray rule format.
+func (s *RoutingService) GetXrayRules() ([]any, error) {
+	rules, err := s.GetRules()
+	if err != nil {
+		return nil, err
+	}
+	xrayRules := make([]any, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Enable {
+			xrayRules = append(xrayRules, routingRuleToXray(rule))
+		}
+	}
+	return xrayRules, nil
+}
+
+// routingRuleToXray converts a routing rule into an Xray field rule.
+func routingRuleToXray(rule *model.RoutingRule) map[string]any {
+	xrayRule := map[string]any{"type": "field"}
+	lists := map[string]string{
+		"inboundTag": rule.InboundTag,
+		"domain":     rule.Domain,
+		"ip":         rule.IP,
+		"source":     rule.SourceIP,
+		"user":       rule.User,
+		"protocol":   rule.Protocol,
+	}
+	for key, value := range lists {
+		if values := splitRuleList(value); len(values) > 0 {
+			xrayRule[key] = values
+		}
+	}
+	if rule.Port != "" {
+		xrayRule["port"] = rule.Port
+	}
+	if rule.Network != "" {
+		xrayRule["network"] = rule.Network
+	}
+	if rule.OutboundTag != "" {
+		xrayRule["outboundTag"] = rule.OutboundTag
+	} else {
+		xrayRule["balancerTag"] = rule.BalancerTag
+	}
+	if rule.Remark != "" {
+		xrayRule["ruleTag"] = rule.Remark
+	}
+	return xrayRule
+}
+
+// splitRuleList splits a comma separated list and drops empty entries.
+func splitRuleList(value string) []string {
+	var values []string
+	for item := range strings.SplitSeq(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+// normalizeRule trims the list fields of a rule and validates it.
+func (s *RoutingService) normalizeRule(rule *model.RoutingRule) error {
+	for _, field := range []*string{&rule.InboundTag, &rule.Domain, &rule.IP, &rule.SourceIP, &rule.User, &rule.Protocol} {
+		*field = strings.Join(splitRuleList(*field), ",")
+	}
+	rule.Port = strings.ReplaceAll(rule.Port, " ", "")
+	rule.Network = strings.ReplaceAll(rule.Network, " ", "")
+	rule.OutboundTag = strings.TrimSpace(rule.OutboundTag)
+	rule.BalancerTag = strings.TrimSpace(rule.BalancerTag)
+
+	if rule.InboundTag == "" && rule.Domain == "" && rule.IP == "" && rule.Port == "" &&
+		rule.SourceIP == "" && rule.User == "" && rule.Network == "" && rule.Protocol == "" {
+		return common.NewError("Routing rule has no matcher")
+	}
+	if (rule.OutboundTag == "") == (rule.BalancerTag == "") {
+		return common.NewError("Routing rule needs either an outbound tag or a balancer tag")
+	}
+	if err := validateRulePorts(rule.Port); err != nil {
+		return err
+	}
+	switch rule.Network {
+	case "", "tcp", "udp", "tcp,udp", "udp,tcp":
+	default:
+		return common.NewError("Routing rule network must be tcp, udp or tcp,udp:", rule.Network)
+	}
+	for _, protocol := range splitRuleList(rule.Protocol) {
+		switch protocol {
+		case "http", "tls", "quic", "bittorrent":
+		default:
+			return common.NewError("Unknown routing rule protocol:", protocol)
+		}
+	}
+	for _, ip := range append(splitRuleList(rule.IP), splitRuleList(rule.SourceIP)...) {
+		if !validRuleIP(ip) {
+			return common.NewError("Routing rule IP is not valid:", ip)
+		}
+	}
+
+	if rule.BalancerTag != "" {
+		tags, err := s.balancerService.GetBalancerTags()
+		if err != nil {
+			return err
+		}
+		if !tags[rule.BalancerTag] {
+			return common.NewError("Routing rule balancer does not exist:", rule.BalancerTag)
+		}
+	}
+	if rule.OutboundTag != "" {
+		tags, err := s.outboundService.GetOutboundTags()
+		if err != nil {
+			return err
+		}
+		if !tags[rule.OutboundTag] {
+			return common.NewError("Routing rule outbound does not exist:", rule.OutboundTag)
+		}
+	}
+	return nil
+}
+
+// validateRulePorts checks a comma separated list of ports and port ranges.
+func validateRulePorts(ports string) error {
+	for part := range strings.SplitSeq(ports, ",") {
+		if part == "" {
+			if ports == "" {
+				return nil
+			}
+			return common.NewError("Routing rule port list has an empty entry:", ports)
+		}
+		from, to, isRange := strings.Cut(part, "-")
+		if !isRange {
+			to = from
+		}
+		fromPort, err1 := strconv.Atoi(from)
+		toPort, err2 := strconv.Atoi(to)
+		if err1 != nil || err2 != nil || fromPort < 1 || toPort > 65535 || fromPort > toPort {
+			return common.NewError("Routing rule port is not valid:", part)
+		}
+	}
+	return nil
+}
+
+// validRuleIP reports whether an entry is an IP, a CIDR or a geoip/ext reference.
+func validRuleIP(ip string) bool {
+	if strings.HasPrefix(ip, "geoip:") || strings.HasPrefix(ip, "ext:") {
+		return true
+	}
+	ip = strings.TrimPrefix(ip, "!")
+	if net.ParseIP(ip) != nil {
+		return true
+	}
+	_, _, err := net.ParseCIDR(ip)
+	return err == nil
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+)
+
+const (
+	// selfSignedValidity is how long a generated certificate is valid.
+	selfSignedValidity = 825 * 24 * time.Hour
+	// selfSignedCAValidity is how long the local CA is valid, clients trust it once for all the
+	// certificates it issues.
+	selfSignedCAValidity = 10 * 365 * 24 * time.Hour
+)
+
+// SelfSignedCert is the files of a generated certificate. CaFile is the certificate of the local
+// CA that issued it, for the clients to trust, and empty for a self-signed certificate.
+type SelfSignedCert struct {
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	CaFile   string `json:"caFile"`
+}
+
+// GenerateSelfSignedCert creates a certificate for the hosts, self-signed or issued by a local CA,
+// and sets it as the certificate of the panel. Without hosts it is made for the panel domain, the
+// addresses of this server and localhost. The local CA is kept and reused, so that clients
+// trusting it keep trusting the certificates generated later.
+func (s *SettingService) GenerateSelfSignedCert(hosts []string, withCA bool) (*SelfSignedCert, error) {
+	var names []string
+	for _, host := range hosts {
+		host = normalizeCertName(host)
+		if host == "" || slices.Contains(names, host) {
+			continue
+		}
+		if strings.ContainsAny(host, " \t/*") {
+			return nil, common.NewError("Host is not valid:", host)
+		}
+		names = append(names, host)
+	}
+	if len(names) == 0 {
+		names = s.selfSignedHosts()
+	}
+
+	dir := filepath.Join(config.GetDBFolderPath(), "cert", "self-signed")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template, err := selfSignedTemplate(names[0], selfSignedValidity)
+	if err != nil {
+		return nil, err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+
+	result := &SelfSignedCert{
+		CertFile: filepath.Join(dir, "fullchain.pem"),
+		KeyFile:  filepath.Join(dir, "privkey.pem"),
+	}
+	issuer, issuerKey := template, crypto.Signer(key)
+	var chain [][]byte
+	if withCA {
+		result.CaFile = filepath.Join(dir, "ca.pem")
+		ca, err := loadOrCreateLocalCA(result.CaFile, filepath.Join(dir, "ca.key"))
+		if err != nil {
+			return nil, err
+		}
+		issuer, issuerKey = ca.Leaf, ca.PrivateKey.(crypto.Signer)
+		chain = ca.Certificate
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, issuerKey)
+	if err != nil {
+		return nil, err
+	}
+	cert := &tls.Certificate{Certificate: append([][]byte{der}, chain...), PrivateKey: key}
+	if _, err := writeCertFiles(cert, result.CertFile, result.KeyFile); err != nil {
+		return nil, err
+	}
+
+	if err := s.SetCertFile(result.CertFile); err != nil {
+		return nil, err
+	}
+	if err := s.SetKeyFile(result.KeyFile); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// selfSignedHosts returns the names a certificate is generated for by default: the panel domain
+// and listen address, the addresses of the network interfaces and localhost.
+func (s *SettingService) selfSignedHosts() []string {
+	var hosts []string
+	add := func(host string) {
+		host = normalizeCertName(host)
+		if host != "" && !slices.Contains(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
+	if domain, err := s.GetWebDomain(); err == nil {
+		add(domain)
+	}
+	if listen, err := s.GetListen(); err == nil {
+		if ip := net.ParseIP(listen); ip != nil && !ip.IsUnspecified() {
+			add(listen)
+		}
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.IsGlobalUnicast() {
+				add(ipNet.IP.String())
+			}
+		}
+	}
+	add("localhost")
+	add("127.0.0.1")
+	return hosts
+}
+
+// loadOrCreateLocalCA loads the local CA, or creates it when the files do not exist.
+func loadOrCreateLocalCA(certPath, keyPath string) (*tls.Certificate, error) {
+	if ca, err := tls.LoadX509KeyPair(certPath, keyPath); err == nil {
+		if ca.Leaf == nil {
+			if ca.Leaf, err = x509.ParseCertificate(ca.Certificate[0]); err != nil {
+				return nil, err
+			}
+		}
+		if time.Now().Before(ca.Leaf.NotAfter) {
+			return &ca, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, common.NewError("Error loading the local CA:", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	template, err := selfSignedTemplate("3x-ui local CA "+hex.EncodeToString(suffix), selfSignedCAValidity)
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = true
+	template.MaxPathLenZero = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := replaceFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0o600); err != nil {
+		return nil, err
+	}
+	if err := replaceFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// selfSignedTemplate returns a certificate template with a random serial number, valid from an
+// hour ago to tolerate clock skew.
+func selfSignedTemplate(commonName string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName, Organization: []string{"3x-ui"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		BasicConstraintsValid: true,
+	}, nil
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+)
+
+// AddStatusSample stores a snapshot of the server load from a status for the resource history.
+func (s *ServerService) AddStatusSample(status *Status) error {
+	if status == nil {
+		return nil
+	}
+	sample := &model.ServerStatSample{
+		Time:     status.T.Unix(),
+		Cpu:      status.Cpu,
+		Mem:      status.Mem.Current,
+		Swap:     status.Swap.Current,
+		Disk:     status.Disk.Current,
+		NetUp:    status.NetIO.Up,
+		NetDown:  status.NetIO.Down,
+		TcpCount: status.TcpCount,
+		UdpCount: status.UdpCount,
+	}
+	if len(status.Loads) > 0 {
+		sample.Load = status.Loads[0]
+	}
+	return database.GetDB().Create(sample).Error
+}
+
+// PruneStatusSamples deletes the server status samples older than the configured retention.
+func (s *ServerService) PruneStatusSamples() error {
+	days, err := s.settingService.GetServerHistoryRetentionDays()
+	if err != nil {
+		return err
+	}
+	if days < 1 {
+		days = 1
+	}
+	before := time.Now().AddDate(0, 0, -days).Unix()
+	return database.GetDB().Where("time < ?", before).Delete(model.ServerStatSample{}).Error
+}
+
+// GetStatusHistory returns the server status samples between from and to, in Unix seconds and both
+// inclusive, ordered by time. A zero bound leaves the range open. With a bucket of more than one second
+// the samples are averaged per bucket, so long ranges can be graphed without thousands of points.
+func (s *ServerService) GetStatusHistory(from int64, to int64, bucket int64) ([]*model.ServerStatSample, error) {
+	query := database.GetDB().Model(model.ServerStatSample{})
+	if from > 0 {
+		query = query.Where("time >= ?", from)
+	}
+	if to > 0 {
+		query = query.Where("time <= ?", to)
+	}
+	var samples []*model.ServerStatSample
+	if err := query.Order("time").Find(&samples).Error; err != nil {
+		return nil, err
+	}
+	if bucket <= 1 || len(samples) == 0 {
+		return samples, nil
+	}
+
+	var averaged []*model.ServerStatSample
+	var acc []*model.ServerStatSample
+	flush := func(t int64) {
+		if len(acc) == 0 {
+			return
+		}
+		n := len(acc)
+		avg := &model.ServerStatSample{Time: t}
+		var cpu, load float64
+		var mem, swap, disk, up, down uint64
+		var tcp, udp int
+		for _, sample := range acc {
+			cpu += sample.Cpu
+			load += sample.Load
+			mem += sample.Mem
+			swap += sample.Swap
+			disk += sample.Disk
+			up += sample.NetUp
+			down += sample.NetDown
+			tcp += sample.TcpCount
+			udp += sample.UdpCount
+		}
+		avg.Cpu = cpu / float64(n)
+		avg.Load = load / float64(n)
+		avg.Mem = mem / uint64(n)
+		avg.Swap = swap / uint64(n)
+		avg.Disk = disk / uint64(n)
+		avg.NetUp = up / uint64(n)
+		avg.NetDown = down / uint64(n)
+		avg.TcpCount = tcp / n
+		avg.UdpCount = udp / n
+		averaged = append(averaged, avg)
+		acc = acc[:0]
+	}
+	current := samples[0].Time / bucket * bucket
+	for _, sample := range samples {
+		if b := sample.Time / bucket * bucket; b != current {
+			flush(current)
+			current = b
+		}
+		acc = append(acc, sample)
+	}
+	flush(current)
+	return averaged, nil
+}
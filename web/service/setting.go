@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"reflect"
 	"strconv"
 	"strings"
@@ -17,35 +18,63 @@ import (
 	"github.com/mhsanaei/3x-ui/v2/util/random"
 	"github.com/mhsanaei/3x-ui/v2/util/reflect_util"
 	"github.com/mhsanaei/3x-ui/v2/web/entity"
+	"github.com/mhsanaei/3x-ui/v2/web/extension"
+	"github.com/mhsanaei/3x-ui/v2/web/network"
 	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"gorm.io/gorm"
 )
 
 //go:embed config.json
 var xrayTemplateConfig string
 
+//go:embed singbox.json
+var singboxTemplateConfig string
+
 var defaultValueMap = map[string]string{
 	"xrayTemplateConfig":          xrayTemplateConfig,
 	"webListen":                   "",
 	"webDomain":                   "",
 	"webPort":                     "2053",
+	"webListeners":                "",
+	"trustedProxies":              "127.0.0.1,::1",
 	"webCertFile":                 "",
 	"webKeyFile":                  "",
+	"acmeEnable":                  "false",
+	"acmeEmail":                   "",
+	"acmeDirectory":               "https://acme-v02.api.letsencrypt.org/directory",
+	"acmeChallenge":               "http",
+	"acmeDnsProvider":             "cloudflare",
+	"acmeDnsCredentials":          "",
+	"acmeWildcard":                "false",
+	"certExpiryDays":              "14,7,1",
 	"secret":                      random.Seq(32),
 	"webBasePath":                 "/",
+	"decoySite":                   "",
 	"sessionMaxAge":               "360",
 	"pageSize":                    "25",
 	"expireDiff":                  "0",
 	"trafficDiff":                 "0",
 	"remarkModel":                 "-ieo",
 	"timeLocation":                "Local",
+	"expiryTimeLocation":          "",
+	"trafficResetTimeLocation":    "",
+	"reportTimeLocation":          "",
 	"tgBotEnable":                 "false",
 	"tgBotToken":                  "",
 	"tgBotProxy":                  "",
 	"tgBotAPIServer":              "",
 	"tgBotChatId":                 "",
+	"tgBotSupportChatId":          "",
 	"tgRunTime":                   "@daily",
 	"tgBotBackup":                 "false",
 	"tgBotLoginNotify":            "true",
+	"tgBotEvents":                 "",
+	"tgBotQuietHours":             "",
+	"tgBotBatchMinutes":           "0",
+	"tgBotWebhookEnable":          "false",
+	"tgBotWebhookUrl":             "",
+	"tgBotWebhookSecret":          random.Seq(32),
 	"tgCpu":                       "80",
 	"tgLang":                      "en-US",
 	"twoFactorEnable":             "false",
@@ -55,6 +84,7 @@ var defaultValueMap = map[string]string{
 	"subTitle":                    "",
 	"subListen":                   "",
 	"subPort":                     "2096",
+	"subListeners":                "",
 	"subPath":                     "/sub/",
 	"subDomain":                   "",
 	"subCertFile":                 "",
@@ -69,10 +99,137 @@ var defaultValueMap = map[string]string{
 	"subJsonNoises":               "",
 	"subJsonMux":                  "",
 	"subJsonRules":                "",
+	"subPortalEnable":             "false",
+	"subPortalPath":               "/portal/",
+	"subStatusEnable":             "false",
+	"subStatusPath":               "/status/",
+	"subClashEnable":              "false",
+	"subClashPath":                "/clash/",
+	"subClashRules":               "",
+	"subSingboxEnable":            "false",
+	"subSingboxPath":              "/singbox/",
+	"subLinksTemplate":            "",
+	"subClashTemplate":            "",
+	"subSingboxTemplate":          "",
+	"subAddressGroups":            "",
+	"subSignEnable":               "false",
+	"subSignSecret":               random.Seq(32),
+	"subSignDays":                 "30",
+	"subFragmentAll":              "false",
+	"subCacheTtl":                 "0",
+	"subNodeUnhealthy":            "exclude",
+	"subNodeLoadOrder":            "false",
+	"subIpv6Links":                "false",
+	"subShortEnable":              "false",
+	"subShortPath":                "/s/",
+	"qrLogoFile":                  "",
 	"datepicker":                  "gregorian",
 	"warp":                        "",
 	"externalTrafficInformEnable": "false",
 	"externalTrafficInformURI":    "",
+	"extensionHooks":              "",
+	"realityKeyRotation":          "0",
+	"realityKeyGrace":             "24",
+	"ipLimitAction":               "suspend",
+	"ipLimitWindow":               "5",
+	"expiryGraceDays":             "0",
+	"expiryNotifyDays":            "",
+	"quotaNotifyPercent":          "",
+	"notifyUrgentEvents":          "crash,alert",
+	"notifyClientUser":            "false",
+	"clientIdMode":                "uuid",
+	"clientPasswordLength":        "10",
+	"clientPasswordAlphabet":      "",
+	"clientEmailPattern":          "",
+	"coreType":                    CoreXray,
+	"singboxTemplateConfig":       singboxTemplateConfig,
+	"geofileUpdateInterval":       "0",
+	"geofileMirrors":              "",
+	"speedtestInterval":           "0",
+	"speedtestTargets":            "https://speed.cloudflare.com/__down?bytes=25000000",
+	"reachabilityInterval":        "0",
+	"reachabilityHost":            "",
+	"reachabilityProbeUrl":        "",
+	"reportDaily":                 "false",
+	"reportWeekly":                "false",
+	"reportTelegram":              "true",
+	"reportEmailTo":               "",
+	"smtpHost":                    "",
+	"smtpPort":                    "587",
+	"smtpUsername":                "",
+	"smtpPassword":                "",
+	"smtpFrom":                    "",
+	"smtpSecurity":                "auto",
+	"smtpAdminTo":                 "",
+	"smtpEvents":                  "",
+	"smtpQuietHours":              "",
+	"smtpBatchMinutes":            "0",
+	"smtpClientNotify":            "false",
+	"smtpBackup":                  "false",
+	"observatoryProbeUrl":         "https://www.google.com/generate_204",
+	"observatoryProbeInterval":    "1m",
+	"dnsManaged":                  "false",
+	"dnsQueryStrategy":            "UseIP",
+	"dnsFakeDns":                  "false",
+	"dnsDisableCache":             "false",
+	"trafficStatsInterval":        "10",
+	"trafficFlushInterval":        "30",
+	"jobWorkers":                  "4",
+	"outboundTrafficStats":        "true",
+	"accessLogAnalytics":          "false",
+	"accessLogAnalyticsDays":      "7",
+	"logRotateSize":               "100",
+	"logRotateDays":               "7",
+	"logRotateKeep":               "5",
+	"logRotateCompress":           "true",
+	"logModuleLevels":             "",
+	"httpAccessLog":               "false",
+	"httpAccessLogFormat":         "combined",
+	"httpAccessLogExclude":        "/assets/,/panel/api/server/status",
+	"trafficHourlyRetentionDays":  "7",
+	"trafficDailyRetentionDays":   "90",
+	"trafficMonthlyRetention":     "24",
+	"trafficArchiveDays":          "0",
+	"trafficArchiveFolder":        "",
+	"trafficArchiveS3Url":         "",
+	"trafficArchiveS3Region":      "us-east-1",
+	"trafficArchiveS3AccessKey":   "",
+	"trafficArchiveS3SecretKey":   "",
+	"serverHistoryInterval":       "60",
+	"serverHistoryRetentionDays":  "7",
+	"anomalyDetection":            "false",
+	"anomalySpikeFactor":          "5",
+	"metricsEnable":               "false",
+	"metricsToken":                "",
+	"diagnosticsListen":           "",
+	"diagnosticsToken":            "",
+	"metricsPushEnable":           "false",
+	"metricsPushFormat":           "influx",
+	"metricsPushUrl":              "",
+	"metricsPushToken":            "",
+	"metricsPushInstance":         "",
+	"metricsPushInterval":         "60",
+	"lokiEnable":                  "false",
+	"lokiUrl":                     "",
+	"lokiToken":                   "",
+	"lokiInstance":                "",
+	"lokiAccessLog":               "false",
+	"fragmentEnable":              "false",
+	"fragmentPackets":             "tlshello",
+	"fragmentLength":              "100-200",
+	"fragmentInterval":            "10-20",
+	"nodeCaCert":                  "",
+	"nodeCaKey":                   "",
+	"nodeAgentEnable":             "false",
+	"nodeAgentListen":             "",
+	"nodeAgentPort":               "2097",
+	"nodeAgentCert":               "",
+	"nodeAgentKey":                "",
+	"nodeAgentCa":                 "",
+	"nodeAgentTags":               "",
+	"nodeAgentHash":               "",
+	"nodeAgentFingerprint":        "",
+	"nodeAgentLimits":             "",
 	// LDAP defaults
 	"ldapEnable":            "false",
 	"ldapHost":              "",
@@ -194,19 +351,35 @@ func (s *SettingService) ResetSettings() error {
 		Where("1 = 1").Error
 }
 
+// settingCache holds the stored settings by key.
+var settingCache = newReadCache[map[string]model.Setting]("settings")
+
 func (s *SettingService) getSetting(key string) (*model.Setting, error) {
-	db := database.GetDB()
-	setting := &model.Setting{}
-	err := db.Model(model.Setting{}).Where("`key` = ?", key).First(setting).Error
+	settings, err := settingCache.get("", func() (map[string]model.Setting, error) {
+		var rows []*model.Setting
+		if err := database.GetDB().Model(model.Setting{}).Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		settings := make(map[string]model.Setting, len(rows))
+		for _, row := range rows {
+			settings[row.Key] = *row
+		}
+		return settings, nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	return setting, nil
+	setting, ok := settings[key]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &setting, nil
 }
 
 func (s *SettingService) saveSetting(key string, value string) error {
-	setting, err := s.getSetting(key)
 	db := database.GetDB()
+	setting := &model.Setting{}
+	err := db.Model(model.Setting{}).Where("`key` = ?", key).First(setting).Error
 	if database.IsNotFound(err) {
 		return db.Create(&model.Setting{
 			Key:   key,
@@ -278,6 +451,10 @@ func (s *SettingService) GetWebDomain() (string, error) {
 	return s.getString("webDomain")
 }
 
+func (s *SettingService) SetWebDomain(domain string) error {
+	return s.setString("webDomain", domain)
+}
+
 func (s *SettingService) GetTgBotToken() (string, error) {
 	return s.getString("tgBotToken")
 }
@@ -310,6 +487,30 @@ func (s *SettingService) SetTgBotChatId(chatIds string) error {
 	return s.setString("tgBotChatId", chatIds)
 }
 
+func (s *SettingService) GetTgBotSupportChatId() (string, error) {
+	return s.getString("tgBotSupportChatId")
+}
+
+func (s *SettingService) GetTgBotEvents() (string, error) {
+	return s.getString("tgBotEvents")
+}
+
+func (s *SettingService) GetTgBotQuietHours() (string, error) {
+	return s.getString("tgBotQuietHours")
+}
+
+func (s *SettingService) GetTgBotBatchMinutes() (int, error) {
+	return s.getInt("tgBotBatchMinutes")
+}
+
+func (s *SettingService) GetTgBotWebhookEnable() (bool, error) {
+	return s.getBool("tgBotWebhookEnable")
+}
+
+func (s *SettingService) GetTgBotWebhookUrl() (string, error) {
+	return s.getString("tgBotWebhookUrl")
+}
+
 func (s *SettingService) GetTgbotEnabled() (bool, error) {
 	return s.getBool("tgBotEnable")
 }
@@ -366,6 +567,15 @@ func (s *SettingService) SetPort(port int) error {
 	return s.setInt("webPort", port)
 }
 
+// GetWebListeners returns the addresses the panel listens on besides its main one.
+func (s *SettingService) GetWebListeners() ([]network.ListenerConfig, error) {
+	value, err := s.getString("webListeners")
+	if err != nil {
+		return nil, err
+	}
+	return network.ParseListeners(value)
+}
+
 func (s *SettingService) SetCertFile(webCertFile string) error {
 	return s.setString("webCertFile", webCertFile)
 }
@@ -382,6 +592,62 @@ func (s *SettingService) GetKeyFile() (string, error) {
 	return s.getString("webKeyFile")
 }
 
+func (s *SettingService) GetAcmeEnable() (bool, error) {
+	return s.getBool("acmeEnable")
+}
+
+func (s *SettingService) SetAcmeEnable(enable bool) error {
+	return s.setBool("acmeEnable", enable)
+}
+
+func (s *SettingService) GetAcmeEmail() (string, error) {
+	return s.getString("acmeEmail")
+}
+
+func (s *SettingService) SetAcmeEmail(email string) error {
+	return s.setString("acmeEmail", email)
+}
+
+func (s *SettingService) GetAcmeDirectory() (string, error) {
+	return s.getString("acmeDirectory")
+}
+
+func (s *SettingService) GetAcmeChallenge() (string, error) {
+	return s.getString("acmeChallenge")
+}
+
+func (s *SettingService) SetAcmeChallenge(challenge string) error {
+	return s.setString("acmeChallenge", challenge)
+}
+
+func (s *SettingService) GetAcmeDnsProvider() (string, error) {
+	return s.getString("acmeDnsProvider")
+}
+
+func (s *SettingService) SetAcmeDnsProvider(provider string) error {
+	return s.setString("acmeDnsProvider", provider)
+}
+
+func (s *SettingService) GetAcmeDnsCredentials() (string, error) {
+	return s.getString("acmeDnsCredentials")
+}
+
+func (s *SettingService) SetAcmeDnsCredentials(credentials string) error {
+	return s.setString("acmeDnsCredentials", credentials)
+}
+
+func (s *SettingService) GetAcmeWildcard() (bool, error) {
+	return s.getBool("acmeWildcard")
+}
+
+func (s *SettingService) SetAcmeWildcard(wildcard bool) error {
+	return s.setBool("acmeWildcard", wildcard)
+}
+
+func (s *SettingService) GetCertExpiryDays() (string, error) {
+	return s.getString("certExpiryDays")
+}
+
 func (s *SettingService) GetExpireDiff() (int, error) {
 	return s.getInt("expireDiff")
 }
@@ -390,6 +656,38 @@ func (s *SettingService) GetTrafficDiff() (int, error) {
 	return s.getInt("trafficDiff")
 }
 
+func (s *SettingService) GetExpiryNotifyDays() (string, error) {
+	return s.getString("expiryNotifyDays")
+}
+
+func (s *SettingService) GetQuotaNotifyPercent() (string, error) {
+	return s.getString("quotaNotifyPercent")
+}
+
+func (s *SettingService) GetNotifyUrgentEvents() (string, error) {
+	return s.getString("notifyUrgentEvents")
+}
+
+func (s *SettingService) GetNotifyClientUser() (bool, error) {
+	return s.getBool("notifyClientUser")
+}
+
+func (s *SettingService) GetClientIdMode() (string, error) {
+	return s.getString("clientIdMode")
+}
+
+func (s *SettingService) GetClientPasswordLength() (int, error) {
+	return s.getInt("clientPasswordLength")
+}
+
+func (s *SettingService) GetClientPasswordAlphabet() (string, error) {
+	return s.getString("clientPasswordAlphabet")
+}
+
+func (s *SettingService) GetClientEmailPattern() (string, error) {
+	return s.getString("clientEmailPattern")
+}
+
 func (s *SettingService) GetSessionMaxAge() (int, error) {
 	return s.getInt("sessionMaxAge")
 }
@@ -433,6 +731,12 @@ func (s *SettingService) GetBasePath() (string, error) {
 	return basePath, nil
 }
 
+// GetDecoySite returns the folder or the http(s) URL of the website served for the requests
+// matching neither the panel nor the subscription paths, empty when disabled.
+func (s *SettingService) GetDecoySite() (string, error) {
+	return s.getString("decoySite")
+}
+
 func (s *SettingService) GetTimeLocation() (*time.Location, error) {
 	l, err := s.getString("timeLocation")
 	if err != nil {
@@ -447,6 +751,62 @@ func (s *SettingService) GetTimeLocation() (*time.Location, error) {
 	return location, nil
 }
 
+// GetExpiryTimeLocation returns the time zone the expiries of clients are computed in.
+func (s *SettingService) GetExpiryTimeLocation() (*time.Location, error) {
+	return s.getTimeLocationOverride("expiryTimeLocation")
+}
+
+// GetTrafficResetTimeLocation returns the time zone the traffic resets of inbounds and clients
+// are scheduled in.
+func (s *SettingService) GetTrafficResetTimeLocation() (*time.Location, error) {
+	return s.getTimeLocationOverride("trafficResetTimeLocation")
+}
+
+// GetReportTimeLocation returns the time zone the periods of the usage reports are computed in.
+func (s *SettingService) GetReportTimeLocation() (*time.Location, error) {
+	return s.getTimeLocationOverride("reportTimeLocation")
+}
+
+// getTimeLocationOverride returns the time zone of a setting overriding the panel time zone for
+// some jobs, or the panel time zone when the setting is empty.
+func (s *SettingService) getTimeLocationOverride(key string) (*time.Location, error) {
+	l, err := s.getString(key)
+	if err != nil {
+		return nil, err
+	}
+	if l == "" {
+		return s.GetTimeLocation()
+	}
+	location, err := time.LoadLocation(l)
+	if err != nil {
+		logger.Errorf("location <%v> of %s not exist, using the panel time zone", l, key)
+		return s.GetTimeLocation()
+	}
+	return location, nil
+}
+
+// ExpiryAfterDays returns the expiry, in Unix milliseconds, of a client valid for days from now.
+// It is the end of that day in the expiry time zone, so the client does not expire at whatever
+// time of day it was created or first used.
+func (s *SettingService) ExpiryAfterDays(days int) int64 {
+	loc, err := s.GetExpiryTimeLocation()
+	if err != nil {
+		loc = time.Local
+	}
+	now := time.Now().In(loc)
+	return time.Date(now.Year(), now.Month(), now.Day()+days+1, 0, 0, 0, 0, loc).UnixMilli()
+}
+
+// ExtendExpiry returns an expiry, in Unix milliseconds, moved by days calendar days in the expiry
+// time zone, so it keeps its time of day across daylight saving changes.
+func (s *SettingService) ExtendExpiry(expiryTime int64, days int) int64 {
+	loc, err := s.GetExpiryTimeLocation()
+	if err != nil {
+		loc = time.Local
+	}
+	return time.UnixMilli(expiryTime).In(loc).AddDate(0, 0, days).UnixMilli()
+}
+
 func (s *SettingService) GetSubEnable() (bool, error) {
 	return s.getBool("subEnable")
 }
@@ -467,6 +827,15 @@ func (s *SettingService) GetSubPort() (int, error) {
 	return s.getInt("subPort")
 }
 
+// GetSubListeners returns the addresses the subscription server listens on besides its main one.
+func (s *SettingService) GetSubListeners() ([]network.ListenerConfig, error) {
+	value, err := s.getString("subListeners")
+	if err != nil {
+		return nil, err
+	}
+	return network.ParseListeners(value)
+}
+
 func (s *SettingService) GetSubPath() (string, error) {
 	return s.getString("subPath")
 }
@@ -483,6 +852,22 @@ func (s *SettingService) GetSubCertFile() (string, error) {
 	return s.getString("subCertFile")
 }
 
+// GetSubTLS reports whether the subscription server serves HTTPS, with the certificate files,
+// with a certificate of the ACME client or with a certificate of the store for its domain.
+func (s *SettingService) GetSubTLS() bool {
+	subKeyFile, _ := s.GetSubKeyFile()
+	subCertFile, _ := s.GetSubCertFile()
+	if subKeyFile != "" && subCertFile != "" {
+		return true
+	}
+	subDomain, _ := s.GetSubDomain()
+	if certStoreGet(subDomain) != nil {
+		return true
+	}
+	acmeEnable, _ := s.GetAcmeEnable()
+	return acmeEnable && subDomain != "" && net.ParseIP(subDomain) == nil
+}
+
 func (s *SettingService) GetSubKeyFile() (string, error) {
 	return s.getString("subKeyFile")
 }
@@ -499,6 +884,410 @@ func (s *SettingService) GetSubShowInfo() (bool, error) {
 	return s.getBool("subShowInfo")
 }
 
+func (s *SettingService) GetSubPortalEnable() (bool, error) {
+	return s.getBool("subPortalEnable")
+}
+
+func (s *SettingService) GetSubPortalPath() (string, error) {
+	return s.getString("subPortalPath")
+}
+
+func (s *SettingService) GetSubStatusEnable() (bool, error) {
+	return s.getBool("subStatusEnable")
+}
+
+func (s *SettingService) GetSubStatusPath() (string, error) {
+	return s.getString("subStatusPath")
+}
+
+func (s *SettingService) GetSubClashEnable() (bool, error) {
+	return s.getBool("subClashEnable")
+}
+
+func (s *SettingService) GetSubClashPath() (string, error) {
+	return s.getString("subClashPath")
+}
+
+func (s *SettingService) GetSubClashRules() (string, error) {
+	return s.getString("subClashRules")
+}
+
+func (s *SettingService) GetSubSingboxEnable() (bool, error) {
+	return s.getBool("subSingboxEnable")
+}
+
+func (s *SettingService) GetSubSingboxPath() (string, error) {
+	return s.getString("subSingboxPath")
+}
+
+func (s *SettingService) GetSubLinksTemplate() (string, error) {
+	return s.getString("subLinksTemplate")
+}
+
+func (s *SettingService) GetSubClashTemplate() (string, error) {
+	return s.getString("subClashTemplate")
+}
+
+func (s *SettingService) GetSubSingboxTemplate() (string, error) {
+	return s.getString("subSingboxTemplate")
+}
+
+func (s *SettingService) GetSubAddressGroups() (string, error) {
+	return s.getString("subAddressGroups")
+}
+
+func (s *SettingService) GetSubSignEnable() (bool, error) {
+	return s.getBool("subSignEnable")
+}
+
+func (s *SettingService) GetSubSignDays() (int, error) {
+	return s.getInt("subSignDays")
+}
+
+func (s *SettingService) GetSubNodeUnhealthy() (string, error) {
+	return s.getString("subNodeUnhealthy")
+}
+
+func (s *SettingService) GetSubNodeLoadOrder() (bool, error) {
+	return s.getBool("subNodeLoadOrder")
+}
+
+func (s *SettingService) GetSubIpv6Links() (bool, error) {
+	return s.getBool("subIpv6Links")
+}
+
+func (s *SettingService) GetSubFragmentAll() (bool, error) {
+	return s.getBool("subFragmentAll")
+}
+
+func (s *SettingService) GetSubCacheTtl() (int, error) {
+	return s.getInt("subCacheTtl")
+}
+
+func (s *SettingService) GetSubShortEnable() (bool, error) {
+	return s.getBool("subShortEnable")
+}
+
+func (s *SettingService) GetSubShortPath() (string, error) {
+	return s.getString("subShortPath")
+}
+
+func (s *SettingService) GetCoreType() (string, error) {
+	return s.getString("coreType")
+}
+
+func (s *SettingService) GetSingboxConfigTemplate() (string, error) {
+	return s.getString("singboxTemplateConfig")
+}
+
+func (s *SettingService) GetGeofileUpdateInterval() (int, error) {
+	return s.getInt("geofileUpdateInterval")
+}
+
+func (s *SettingService) GetGeofileMirrors() (string, error) {
+	return s.getString("geofileMirrors")
+}
+
+func (s *SettingService) GetSpeedtestInterval() (int, error) {
+	return s.getInt("speedtestInterval")
+}
+
+func (s *SettingService) GetSpeedtestTargets() (string, error) {
+	return s.getString("speedtestTargets")
+}
+
+func (s *SettingService) GetReachabilityInterval() (int, error) {
+	return s.getInt("reachabilityInterval")
+}
+
+func (s *SettingService) GetReachabilityHost() (string, error) {
+	return s.getString("reachabilityHost")
+}
+
+func (s *SettingService) GetReachabilityProbeUrl() (string, error) {
+	return s.getString("reachabilityProbeUrl")
+}
+
+func (s *SettingService) GetReportDaily() (bool, error) {
+	return s.getBool("reportDaily")
+}
+
+func (s *SettingService) GetReportWeekly() (bool, error) {
+	return s.getBool("reportWeekly")
+}
+
+func (s *SettingService) GetReportTelegram() (bool, error) {
+	return s.getBool("reportTelegram")
+}
+
+func (s *SettingService) GetReportEmailTo() (string, error) {
+	return s.getString("reportEmailTo")
+}
+
+func (s *SettingService) GetSmtpHost() (string, error) {
+	return s.getString("smtpHost")
+}
+
+func (s *SettingService) GetSmtpPort() (int, error) {
+	return s.getInt("smtpPort")
+}
+
+func (s *SettingService) GetSmtpUsername() (string, error) {
+	return s.getString("smtpUsername")
+}
+
+func (s *SettingService) GetSmtpPassword() (string, error) {
+	return s.getString("smtpPassword")
+}
+
+func (s *SettingService) GetSmtpFrom() (string, error) {
+	return s.getString("smtpFrom")
+}
+
+func (s *SettingService) GetSmtpSecurity() (string, error) {
+	return s.getString("smtpSecurity")
+}
+
+func (s *SettingService) GetSmtpAdminTo() (string, error) {
+	return s.getString("smtpAdminTo")
+}
+
+func (s *SettingService) GetSmtpEvents() (string, error) {
+	return s.getString("smtpEvents")
+}
+
+func (s *SettingService) GetSmtpQuietHours() (string, error) {
+	return s.getString("smtpQuietHours")
+}
+
+func (s *SettingService) GetSmtpBatchMinutes() (int, error) {
+	return s.getInt("smtpBatchMinutes")
+}
+
+func (s *SettingService) GetSmtpClientNotify() (bool, error) {
+	return s.getBool("smtpClientNotify")
+}
+
+func (s *SettingService) GetSmtpBackup() (bool, error) {
+	return s.getBool("smtpBackup")
+}
+
+func (s *SettingService) GetObservatoryProbeUrl() (string, error) {
+	return s.getString("observatoryProbeUrl")
+}
+
+func (s *SettingService) GetObservatoryProbeInterval() (string, error) {
+	return s.getString("observatoryProbeInterval")
+}
+
+func (s *SettingService) GetDnsManaged() (bool, error) {
+	return s.getBool("dnsManaged")
+}
+
+func (s *SettingService) GetDnsQueryStrategy() (string, error) {
+	return s.getString("dnsQueryStrategy")
+}
+
+func (s *SettingService) GetDnsFakeDns() (bool, error) {
+	return s.getBool("dnsFakeDns")
+}
+
+func (s *SettingService) GetDnsDisableCache() (bool, error) {
+	return s.getBool("dnsDisableCache")
+}
+
+func (s *SettingService) GetTrafficStatsInterval() (int, error) {
+	return s.getInt("trafficStatsInterval")
+}
+
+func (s *SettingService) GetTrafficFlushInterval() (int, error) {
+	return s.getInt("trafficFlushInterval")
+}
+
+func (s *SettingService) GetJobWorkers() (int, error) {
+	return s.getInt("jobWorkers")
+}
+
+func (s *SettingService) GetOutboundTrafficStats() (bool, error) {
+	return s.getBool("outboundTrafficStats")
+}
+
+func (s *SettingService) GetAccessLogAnalytics() (bool, error) {
+	return s.getBool("accessLogAnalytics")
+}
+
+func (s *SettingService) GetAccessLogAnalyticsDays() (int, error) {
+	return s.getInt("accessLogAnalyticsDays")
+}
+
+func (s *SettingService) GetLogRotateSize() (int, error) {
+	return s.getInt("logRotateSize")
+}
+
+func (s *SettingService) GetLogRotateDays() (int, error) {
+	return s.getInt("logRotateDays")
+}
+
+func (s *SettingService) GetLogRotateKeep() (int, error) {
+	return s.getInt("logRotateKeep")
+}
+
+func (s *SettingService) GetLogRotateCompress() (bool, error) {
+	return s.getBool("logRotateCompress")
+}
+
+func (s *SettingService) GetLogModuleLevels() (string, error) {
+	return s.getString("logModuleLevels")
+}
+
+func (s *SettingService) SetLogModuleLevels(levels string) error {
+	return s.setString("logModuleLevels", levels)
+}
+
+func (s *SettingService) GetTrafficHourlyRetentionDays() (int, error) {
+	return s.getInt("trafficHourlyRetentionDays")
+}
+
+func (s *SettingService) GetTrafficDailyRetentionDays() (int, error) {
+	return s.getInt("trafficDailyRetentionDays")
+}
+
+func (s *SettingService) GetTrafficMonthlyRetentionMonths() (int, error) {
+	return s.getInt("trafficMonthlyRetention")
+}
+
+func (s *SettingService) GetTrafficArchiveDays() (int, error) {
+	return s.getInt("trafficArchiveDays")
+}
+
+func (s *SettingService) GetTrafficArchiveFolder() (string, error) {
+	return s.getString("trafficArchiveFolder")
+}
+
+func (s *SettingService) GetTrafficArchiveS3Url() (string, error) {
+	return s.getString("trafficArchiveS3Url")
+}
+
+func (s *SettingService) GetTrafficArchiveS3Region() (string, error) {
+	return s.getString("trafficArchiveS3Region")
+}
+
+func (s *SettingService) GetTrafficArchiveS3AccessKey() (string, error) {
+	return s.getString("trafficArchiveS3AccessKey")
+}
+
+func (s *SettingService) GetTrafficArchiveS3SecretKey() (string, error) {
+	return s.getString("trafficArchiveS3SecretKey")
+}
+
+func (s *SettingService) GetServerHistoryInterval() (int, error) {
+	return s.getInt("serverHistoryInterval")
+}
+
+func (s *SettingService) GetServerHistoryRetentionDays() (int, error) {
+	return s.getInt("serverHistoryRetentionDays")
+}
+
+func (s *SettingService) GetAnomalyDetection() (bool, error) {
+	return s.getBool("anomalyDetection")
+}
+
+func (s *SettingService) GetAnomalySpikeFactor() (int, error) {
+	return s.getInt("anomalySpikeFactor")
+}
+
+func (s *SettingService) GetMetricsEnable() (bool, error) {
+	return s.getBool("metricsEnable")
+}
+
+func (s *SettingService) GetNodeAgentEnable() (bool, error) {
+	return s.getBool("nodeAgentEnable")
+}
+
+func (s *SettingService) GetNodeAgentListen() (string, error) {
+	return s.getString("nodeAgentListen")
+}
+
+func (s *SettingService) GetNodeAgentPort() (int, error) {
+	return s.getInt("nodeAgentPort")
+}
+
+func (s *SettingService) GetMetricsToken() (string, error) {
+	return s.getString("metricsToken")
+}
+
+func (s *SettingService) GetDiagnosticsListen() (string, error) {
+	return s.getString("diagnosticsListen")
+}
+
+func (s *SettingService) GetDiagnosticsToken() (string, error) {
+	return s.getString("diagnosticsToken")
+}
+
+func (s *SettingService) GetMetricsPushEnable() (bool, error) {
+	return s.getBool("metricsPushEnable")
+}
+
+func (s *SettingService) GetMetricsPushFormat() (string, error) {
+	return s.getString("metricsPushFormat")
+}
+
+func (s *SettingService) GetMetricsPushUrl() (string, error) {
+	return s.getString("metricsPushUrl")
+}
+
+func (s *SettingService) GetMetricsPushToken() (string, error) {
+	return s.getString("metricsPushToken")
+}
+
+func (s *SettingService) GetMetricsPushInstance() (string, error) {
+	return s.getString("metricsPushInstance")
+}
+
+func (s *SettingService) GetMetricsPushInterval() (int, error) {
+	return s.getInt("metricsPushInterval")
+}
+
+func (s *SettingService) GetLokiEnable() (bool, error) {
+	return s.getBool("lokiEnable")
+}
+
+func (s *SettingService) GetLokiUrl() (string, error) {
+	return s.getString("lokiUrl")
+}
+
+func (s *SettingService) GetLokiToken() (string, error) {
+	return s.getString("lokiToken")
+}
+
+func (s *SettingService) GetLokiInstance() (string, error) {
+	return s.getString("lokiInstance")
+}
+
+func (s *SettingService) GetLokiAccessLog() (bool, error) {
+	return s.getBool("lokiAccessLog")
+}
+
+func (s *SettingService) GetFragmentEnable() (bool, error) {
+	return s.getBool("fragmentEnable")
+}
+
+func (s *SettingService) GetFragmentPackets() (string, error) {
+	return s.getString("fragmentPackets")
+}
+
+func (s *SettingService) GetFragmentLength() (string, error) {
+	return s.getString("fragmentLength")
+}
+
+func (s *SettingService) GetFragmentInterval() (string, error) {
+	return s.getString("fragmentInterval")
+}
+
+func (s *SettingService) GetQrLogoFile() (string, error) {
+	return s.getString("qrLogoFile")
+}
+
 func (s *SettingService) GetPageSize() (int, error) {
 	return s.getInt("pageSize")
 }
@@ -547,6 +1336,16 @@ func (s *SettingService) SetExternalTrafficInformEnable(value bool) error {
 	return s.setBool("externalTrafficInformEnable", value)
 }
 
+func (s *SettingService) GetRealityKeyRotation() (int, error) {
+	return s.getInt("realityKeyRotation")
+}
+
+// GetRealityKeyGrace returns how many hours the previous REALITY keys of an inbound stay valid
+// after a rotation.
+func (s *SettingService) GetRealityKeyGrace() (int, error) {
+	return s.getInt("realityKeyGrace")
+}
+
 func (s *SettingService) GetExternalTrafficInformURI() (string, error) {
 	return s.getString("externalTrafficInformURI")
 }
@@ -555,6 +1354,15 @@ func (s *SettingService) SetExternalTrafficInformURI(InformURI string) error {
 	return s.setString("externalTrafficInformURI", InformURI)
 }
 
+// GetExtensionHooks returns the hooks run by external programs and HTTP endpoints.
+func (s *SettingService) GetExtensionHooks() ([]extension.External, error) {
+	value, err := s.getString("extensionHooks")
+	if err != nil {
+		return nil, err
+	}
+	return extension.ParseExternal(value)
+}
+
 func (s *SettingService) GetIpLimitEnable() (bool, error) {
 	accessLogPath, err := xray.GetAccessLogPath()
 	if err != nil {
@@ -563,6 +1371,18 @@ func (s *SettingService) GetIpLimitEnable() (bool, error) {
 	return (accessLogPath != "none" && accessLogPath != ""), nil
 }
 
+func (s *SettingService) GetIpLimitAction() (string, error) {
+	return s.getString("ipLimitAction")
+}
+
+func (s *SettingService) GetIpLimitWindow() (int, error) {
+	return s.getInt("ipLimitWindow")
+}
+
+func (s *SettingService) GetExpiryGraceDays() (int, error) {
+	return s.getInt("expiryGraceDays")
+}
+
 // LDAP exported getters
 func (s *SettingService) GetLdapEnable() (bool, error) {
 	return s.getBool("ldapEnable")
@@ -677,20 +1497,27 @@ func (s *SettingService) GetDefaultXrayConfig() (any, error) {
 func (s *SettingService) GetDefaultSettings(host string) (any, error) {
 	type settingFunc func() (any, error)
 	settings := map[string]settingFunc{
-		"expireDiff":    func() (any, error) { return s.GetExpireDiff() },
-		"trafficDiff":   func() (any, error) { return s.GetTrafficDiff() },
-		"pageSize":      func() (any, error) { return s.GetPageSize() },
-		"defaultCert":   func() (any, error) { return s.GetCertFile() },
-		"defaultKey":    func() (any, error) { return s.GetKeyFile() },
-		"tgBotEnable":   func() (any, error) { return s.GetTgbotEnabled() },
-		"subEnable":     func() (any, error) { return s.GetSubEnable() },
-		"subJsonEnable": func() (any, error) { return s.GetSubJsonEnable() },
-		"subTitle":      func() (any, error) { return s.GetSubTitle() },
-		"subURI":        func() (any, error) { return s.GetSubURI() },
-		"subJsonURI":    func() (any, error) { return s.GetSubJsonURI() },
-		"remarkModel":   func() (any, error) { return s.GetRemarkModel() },
-		"datepicker":    func() (any, error) { return s.GetDatepicker() },
-		"ipLimitEnable": func() (any, error) { return s.GetIpLimitEnable() },
+		"expireDiff":             func() (any, error) { return s.GetExpireDiff() },
+		"trafficDiff":            func() (any, error) { return s.GetTrafficDiff() },
+		"pageSize":               func() (any, error) { return s.GetPageSize() },
+		"defaultCert":            func() (any, error) { return s.GetCertFile() },
+		"defaultKey":             func() (any, error) { return s.GetKeyFile() },
+		"tgBotEnable":            func() (any, error) { return s.GetTgbotEnabled() },
+		"subEnable":              func() (any, error) { return s.GetSubEnable() },
+		"subJsonEnable":          func() (any, error) { return s.GetSubJsonEnable() },
+		"subTitle":               func() (any, error) { return s.GetSubTitle() },
+		"subURI":                 func() (any, error) { return s.GetSubURI() },
+		"subJsonURI":             func() (any, error) { return s.GetSubJsonURI() },
+		"subSignEnable":          func() (any, error) { return s.GetSubSignEnable() },
+		"subShortEnable":         func() (any, error) { return s.GetSubShortEnable() },
+		"subShortPath":           func() (any, error) { return s.GetSubShortPath() },
+		"remarkModel":            func() (any, error) { return s.GetRemarkModel() },
+		"datepicker":             func() (any, error) { return s.GetDatepicker() },
+		"ipLimitEnable":          func() (any, error) { return s.GetIpLimitEnable() },
+		"clientIdMode":           func() (any, error) { return s.GetClientIdMode() },
+		"clientPasswordLength":   func() (any, error) { return s.GetClientPasswordLength() },
+		"clientPasswordAlphabet": func() (any, error) { return s.GetClientPasswordAlphabet() },
+		"clientEmailPattern":     func() (any, error) { return s.GetClientEmailPattern() },
 	}
 
 	result := make(map[string]any)
@@ -717,20 +1544,22 @@ func (s *SettingService) GetDefaultSettings(host string) (any, error) {
 		subPath, _ := s.GetSubPath()
 		subJsonPath, _ := s.GetSubJsonPath()
 		subDomain, _ := s.GetSubDomain()
-		subKeyFile, _ := s.GetSubKeyFile()
-		subCertFile, _ := s.GetSubCertFile()
-		subTLS := false
-		if subKeyFile != "" && subCertFile != "" {
-			subTLS = true
-		}
+		subTLS := s.GetSubTLS()
 		if subDomain == "" {
-			subDomain = strings.Split(host, ":")[0]
+			subDomain = host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				subDomain = h
+			}
 		}
 		if subTLS {
 			subURI = "https://"
 		} else {
 			subURI = "http://"
 		}
+		if strings.Contains(subDomain, ":") {
+			// An IPv6 address
+			subDomain = "[" + subDomain + "]"
+		}
 		if (subPort == 443 && subTLS) || (subPort == 80 && !subTLS) {
 			subURI += subDomain
 		} else {
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"net"
+	"os"
+
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/qr"
+	"github.com/mhsanaei/3x-ui/v2/web/global"
+)
+
+// ShareService generates share links and QR codes of clients on the server, so bots and
+// external tools use the same link construction as the subscription server.
+type ShareService struct {
+	settingService SettingService
+}
+
+// GetClientLinks returns the share links of a client. The subscription domain is used as
+// server address when set, otherwise the host the request was sent to.
+func (s *ShareService) GetClientLinks(email string, requestHost string) ([]string, error) {
+	subServer := global.GetSubServer()
+	if subServer == nil {
+		return nil, common.NewError("Subscription server is not initialized")
+	}
+	host, err := s.settingService.GetSubDomain()
+	if err != nil || host == "" {
+		host = requestHost
+		if h, _, err := net.SplitHostPort(requestHost); err == nil {
+			host = h
+		}
+	}
+	return subServer.GetClientLinks(email, host)
+}
+
+// GetClientQr renders the share link with the given index of a client as a QR code.
+// format is "png" or "svg"; the configured logo is placed in the center when withLogo is set.
+// Returns the image and its content type.
+func (s *ShareService) GetClientQr(email string, requestHost string, index int, format string, size int, withLogo bool) ([]byte, string, error) {
+	links, err := s.GetClientLinks(email, requestHost)
+	if err != nil {
+		return nil, "", err
+	}
+	if index < 0 || index >= len(links) {
+		return nil, "", common.NewError("Link index out of range:", index)
+	}
+	if size <= 0 {
+		size = 256
+	}
+	size = min(size, 2048)
+
+	var logo []byte
+	if withLogo {
+		logoFile, _ := s.settingService.GetQrLogoFile()
+		if logoFile != "" {
+			logo, err = os.ReadFile(logoFile)
+			if err != nil {
+				return nil, "", err
+			}
+		}
+	}
+
+	switch format {
+	case "", "png":
+		if len(logo) == 0 {
+			data, err := qr.EncodePNG(links[index], size, nil)
+			return data, "image/png", err
+		}
+		logoImage, err := qr.DecodeLogo(logo)
+		if err != nil {
+			return nil, "", err
+		}
+		data, err := qr.EncodePNG(links[index], size, logoImage)
+		return data, "image/png", err
+	case "svg":
+		data, err := qr.EncodeSVG(links[index], size, logo)
+		return data, "image/svg+xml", err
+	}
+	return nil, "", common.NewError("Unknown QR code format:", format)
+}
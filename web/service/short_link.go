@@ -0,0 +1,94 @@
+package service
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
+
+	"gorm.io/gorm"
+)
+
+const (
+	shortLinkCodeLength   = 6
+	shortLinkCodeAlphabet = "23456789abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ"
+)
+
+// ShortLinkService manages the short links served by the subscription server.
+type ShortLinkService struct {
+	inboundService InboundService
+}
+
+// AddShortLink creates a short link to a subscription or share URL.
+// A non-empty email ties the link to that client.
+func (s *ShortLinkService) AddShortLink(target string, email string) (*model.ShortLink, error) {
+	parsed, err := url.Parse(target)
+	if err != nil || parsed.Scheme == "" {
+		return nil, common.NewError("Invalid short link target:", target)
+	}
+	if email != "" {
+		if _, _, err := s.inboundService.GetClientByEmail(email); err != nil {
+			return nil, err
+		}
+	}
+
+	db := database.GetDB()
+	code := ""
+	for range 5 {
+		candidate := random.SeqFrom(shortLinkCodeLength, shortLinkCodeAlphabet)
+		var count int64
+		if err := db.Model(model.ShortLink{}).Where("code = ?", candidate).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			code = candidate
+			break
+		}
+	}
+	if code == "" {
+		return nil, common.NewError("Unable to generate a unique short link code")
+	}
+
+	link := &model.ShortLink{
+		Code:      code,
+		Target:    target,
+		Email:     email,
+		CreatedAt: time.Now().UnixMilli(),
+	}
+	return link, db.Create(link).Error
+}
+
+// GetShortLinks returns the short links of a client, or all short links for an empty email, newest first.
+func (s *ShortLinkService) GetShortLinks(email string) ([]*model.ShortLink, error) {
+	db := database.GetDB()
+	query := db.Model(model.ShortLink{})
+	if email != "" {
+		query = query.Where("email = ?", email)
+	}
+	var links []*model.ShortLink
+	err := query.Order("id desc").Find(&links).Error
+	return links, err
+}
+
+// DelShortLink revokes a short link.
+func (s *ShortLinkService) DelShortLink(id int) error {
+	db := database.GetDB()
+	return db.Delete(model.ShortLink{}, id).Error
+}
+
+// VisitShortLink returns the target of a short link and counts the visit.
+func (s *ShortLinkService) VisitShortLink(code string) (string, error) {
+	db := database.GetDB()
+	var link model.ShortLink
+	if err := db.Model(model.ShortLink{}).Where("code = ?", code).First(&link).Error; err != nil {
+		return "", err
+	}
+	err := db.Model(model.ShortLink{}).Where("id = ?", link.Id).Updates(map[string]any{
+		"clicks":     gorm.Expr("clicks + ?", 1),
+		"last_click": time.Now().UnixMilli(),
+	}).Error
+	return link.Target, err
+}
@@ -0,0 +1,402 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/singbox"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/json_util"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// GetSingboxConfig builds the sing-box configuration from the sing-box template and the inbounds.
+// Inbounds are translated from their Xray settings; protocols and transports sing-box does not
+// support are left out. Traffic of all inbounds and clients is counted through the V2Ray API.
+func (s *XrayService) GetSingboxConfig() (*singbox.Config, error) {
+	templateConfig, err := s.settingService.GetSingboxConfigTemplate()
+	if err != nil {
+		return nil, err
+	}
+
+	singboxConfig := &singbox.Config{}
+	err = json.Unmarshal([]byte(templateConfig), singboxConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	s.inboundService.FlushTraffic(true)
+
+	inbounds, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		return nil, err
+	}
+	var tags, users, sniffTags []string
+	for _, inbound := range inbounds {
+		if !inbound.Enable {
+			continue
+		}
+		if err := s.prepareInbound(inbound); err != nil {
+			return nil, err
+		}
+		config, err := singboxInbound(inbound)
+		if err != nil {
+			logger.Warning("Inbound", inbound.Tag, "is left out of the sing-box config:", err)
+			continue
+		}
+		data, err := json.MarshalIndent(config, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		singboxConfig.Inbounds = append(singboxConfig.Inbounds, data)
+
+		tags = append(tags, inbound.Tag)
+		if list, ok := config["users"].([]map[string]any); ok {
+			for _, user := range list {
+				if name, ok := user["name"].(string); ok {
+					users = append(users, name)
+				}
+			}
+		}
+		sniffing := struct {
+			Enabled bool `json:"enabled"`
+		}{}
+		if json.Unmarshal([]byte(inbound.Sniffing), &sniffing) == nil && sniffing.Enabled {
+			sniffTags = append(sniffTags, inbound.Tag)
+		}
+	}
+
+	singboxConfig.Experimental, err = addSingboxStats(singboxConfig.Experimental, tags, users)
+	if err != nil {
+		return nil, err
+	}
+	if len(sniffTags) > 0 {
+		singboxConfig.Route, err = addSingboxSniffing(singboxConfig.Route, sniffTags)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return singboxConfig, nil
+}
+
+// restartSingbox restarts the sing-box process, optionally forcing a restart even if config unchanged.
+// A running Xray is stopped when the panel switches to sing-box.
+func (s *XrayService) restartSingbox(isForce bool) error {
+	singboxConfig, err := s.GetSingboxConfig()
+	if err != nil {
+		return err
+	}
+
+	if s.IsXrayRunning() {
+		if sp, ok := p.(*singbox.Process); ok && !isForce && sp.GetConfig().Equals(singboxConfig) && !isNeedXrayRestart.Load() {
+			logger.Debug("It does not need to restart sing-box")
+			return nil
+		}
+		s.flushTraffic()
+		p.Stop()
+	}
+
+	sp := singbox.NewProcess(singboxConfig)
+	p = sp
+	result = ""
+	if err := sp.Start(); err != nil {
+		return err
+	}
+	coreStarts.Inc()
+	return nil
+}
+
+// getSingboxTraffic fetches the current traffic statistics from the running sing-box process.
+func (s *XrayService) getSingboxTraffic() ([]*xray.Traffic, []*xray.ClientTraffic, error) {
+	var api singbox.StatsAPI
+	if err := api.Init(p.GetAPIPort()); err != nil {
+		return nil, nil, err
+	}
+	defer api.Close()
+	return api.GetTraffic(true)
+}
+
+// singboxInbound translates an inbound prepared for the core into a sing-box inbound.
+func singboxInbound(inbound *model.Inbound) (map[string]any, error) {
+	settings := map[string]any{}
+	if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+		return nil, err
+	}
+	stream := map[string]any{}
+	if inbound.StreamSettings != "" {
+		if err := json.Unmarshal([]byte(inbound.StreamSettings), &stream); err != nil {
+			return nil, err
+		}
+	}
+
+	listen := inbound.Listen
+	if listen == "" {
+		listen = "::"
+	}
+	config := map[string]any{
+		"type":        string(inbound.Protocol),
+		"tag":         inbound.Tag,
+		"listen":      listen,
+		"listen_port": inbound.Port,
+	}
+
+	clients, _ := settings["clients"].([]any)
+	users := make([]map[string]any, 0, len(clients))
+	for _, item := range clients {
+		c, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		email, _ := c["email"].(string)
+		id, _ := c["id"].(string)
+		password, _ := c["password"].(string)
+		user := map[string]any{"name": email}
+		switch inbound.Protocol {
+		case model.VMESS:
+			user["uuid"] = id
+		case model.VLESS:
+			user["uuid"] = id
+			if flow, _ := c["flow"].(string); flow != "" {
+				user["flow"] = flow
+			}
+		case model.TUIC:
+			user["uuid"] = id
+			user["password"] = password
+		default:
+			user["password"] = password
+		}
+		users = append(users, user)
+	}
+
+	withTls, withTransport := false, false
+	switch inbound.Protocol {
+	case model.VMESS, model.VLESS, model.Trojan:
+		config["users"] = users
+		withTls, withTransport = true, true
+	case model.Shadowsocks:
+		method, _ := settings["method"].(string)
+		config["method"] = method
+		if strings.HasPrefix(method, "2022") {
+			config["password"], _ = settings["password"].(string)
+			if len(users) > 0 {
+				config["users"] = users
+			}
+		} else if len(users) > 0 {
+			// sing-box serves one user on the legacy ciphers
+			config["password"] = users[0]["password"]
+		} else {
+			config["password"], _ = settings["password"].(string)
+		}
+		setSingboxNetwork(config, settings)
+	case model.Hysteria2:
+		config["users"] = users
+		if up, ok := settings["upMbps"].(float64); ok && up > 0 {
+			config["up_mbps"] = int(up)
+		}
+		if down, ok := settings["downMbps"].(float64); ok && down > 0 {
+			config["down_mbps"] = int(down)
+		}
+		if obfs, _ := settings["obfsPassword"].(string); obfs != "" {
+			config["obfs"] = map[string]any{"type": "salamander", "password": obfs}
+		}
+		withTls = true
+	case model.TUIC:
+		config["users"] = users
+		if congestion, _ := settings["congestionControl"].(string); congestion != "" {
+			config["congestion_control"] = congestion
+		}
+		withTls = true
+	case model.Mixed, model.HTTP:
+		accounts, _ := settings["accounts"].([]any)
+		var accountUsers []map[string]any
+		for _, item := range accounts {
+			account, _ := item.(map[string]any)
+			username, _ := account["user"].(string)
+			password, _ := account["pass"].(string)
+			accountUsers = append(accountUsers, map[string]any{"username": username, "password": password})
+		}
+		if len(accountUsers) > 0 {
+			config["users"] = accountUsers
+		}
+		withTls = inbound.Protocol == model.HTTP
+	case model.Tunnel:
+		config["type"] = "direct"
+		if address, _ := settings["address"].(string); address != "" {
+			config["override_address"] = address
+		}
+		if port, ok := settings["port"].(float64); ok && port > 0 {
+			config["override_port"] = int(port)
+		}
+		setSingboxNetwork(config, settings)
+	default:
+		return nil, common.NewError("protocol is not supported by sing-box:", inbound.Protocol)
+	}
+
+	if withTls {
+		tls, err := singboxTls(stream)
+		if err != nil {
+			return nil, err
+		}
+		if tls != nil {
+			config["tls"] = tls
+		} else if inbound.Protocol.IsSingboxOnly() {
+			return nil, common.NewError(inbound.Protocol, "requires TLS")
+		}
+	}
+	if withTransport {
+		transport, err := singboxTransport(stream)
+		if err != nil {
+			return nil, err
+		}
+		if transport != nil {
+			config["transport"] = transport
+		}
+	}
+	return config, nil
+}
+
+// setSingboxNetwork limits an inbound to TCP or UDP when the Xray settings do.
+func setSingboxNetwork(config map[string]any, settings map[string]any) {
+	switch network, _ := settings["network"].(string); network {
+	case "tcp", "udp":
+		config["network"] = network
+	}
+}
+
+// singboxTls translates the TLS or REALITY settings of an inbound stream.
+// It returns nil for streams without security.
+func singboxTls(stream map[string]any) (map[string]any, error) {
+	switch stream["security"] {
+	case "tls":
+		tlsSettings, _ := stream["tlsSettings"].(map[string]any)
+		tls := map[string]any{"enabled": true}
+		if serverName, _ := tlsSettings["serverName"].(string); serverName != "" {
+			tls["server_name"] = serverName
+		}
+		if alpn, ok := tlsSettings["alpn"].([]any); ok && len(alpn) > 0 {
+			tls["alpn"] = alpn
+		}
+		if minVersion, _ := tlsSettings["minVersion"].(string); minVersion != "" {
+			tls["min_version"] = minVersion
+		}
+		if maxVersion, _ := tlsSettings["maxVersion"].(string); maxVersion != "" {
+			tls["max_version"] = maxVersion
+		}
+		certificates, _ := tlsSettings["certificates"].([]any)
+		if len(certificates) == 0 {
+			return nil, errors.New("TLS without certificate")
+		}
+		cert, _ := certificates[0].(map[string]any)
+		if certFile, _ := cert["certificateFile"].(string); certFile != "" {
+			tls["certificate_path"] = certFile
+			tls["key_path"], _ = cert["keyFile"].(string)
+		} else {
+			tls["certificate"] = cert["certificate"]
+			tls["key"] = cert["key"]
+		}
+		return tls, nil
+	case "reality":
+		realitySettings, _ := stream["realitySettings"].(map[string]any)
+		target, _ := realitySettings["target"].(string)
+		if target == "" {
+			target, _ = realitySettings["dest"].(string)
+		}
+		host, port, err := net.SplitHostPort(target)
+		if err != nil {
+			return nil, common.NewError("invalid REALITY target:", target)
+		}
+		serverPort, _ := strconv.Atoi(port)
+		reality := map[string]any{
+			"enabled":     true,
+			"handshake":   map[string]any{"server": host, "server_port": serverPort},
+			"private_key": realitySettings["privateKey"],
+			"short_id":    realitySettings["shortIds"],
+		}
+		tls := map[string]any{"enabled": true, "reality": reality}
+		if serverNames, ok := realitySettings["serverNames"].([]any); ok && len(serverNames) > 0 {
+			tls["server_name"] = serverNames[0]
+		}
+		return tls, nil
+	}
+	return nil, nil
+}
+
+// singboxTransport translates the transport of an inbound stream.
+// It returns nil for plain TCP.
+func singboxTransport(stream map[string]any) (map[string]any, error) {
+	switch network, _ := stream["network"].(string); network {
+	case "", "tcp", "raw":
+		return nil, nil
+	case "ws":
+		ws, _ := stream["wsSettings"].(map[string]any)
+		transport := map[string]any{"type": "ws"}
+		if path, _ := ws["path"].(string); path != "" {
+			transport["path"] = path
+		}
+		if host, _ := ws["host"].(string); host != "" {
+			transport["headers"] = map[string]any{"Host": host}
+		}
+		return transport, nil
+	case "grpc":
+		grpc, _ := stream["grpcSettings"].(map[string]any)
+		transport := map[string]any{"type": "grpc"}
+		if serviceName, _ := grpc["serviceName"].(string); serviceName != "" {
+			transport["service_name"] = serviceName
+		}
+		return transport, nil
+	case "httpupgrade":
+		upgrade, _ := stream["httpupgradeSettings"].(map[string]any)
+		transport := map[string]any{"type": "httpupgrade"}
+		if path, _ := upgrade["path"].(string); path != "" {
+			transport["path"] = path
+		}
+		if host, _ := upgrade["host"].(string); host != "" {
+			transport["host"] = host
+		}
+		return transport, nil
+	default:
+		return nil, common.NewError("transport is not supported by sing-box:", network)
+	}
+}
+
+// addSingboxStats enables the V2Ray API counters for the given inbounds and users.
+func addSingboxStats(experimentalConfig json_util.RawMessage, tags []string, users []string) (json_util.RawMessage, error) {
+	experimental := map[string]any{}
+	if len(experimentalConfig) > 0 {
+		if err := json.Unmarshal(experimentalConfig, &experimental); err != nil {
+			return nil, err
+		}
+	}
+	v2rayApi, _ := experimental["v2ray_api"].(map[string]any)
+	if v2rayApi == nil {
+		return experimentalConfig, nil
+	}
+	stats, _ := v2rayApi["stats"].(map[string]any)
+	if stats == nil {
+		stats = map[string]any{}
+		v2rayApi["stats"] = stats
+	}
+	stats["enabled"] = true
+	stats["inbounds"] = tags
+	stats["users"] = users
+	return json.MarshalIndent(experimental, "", "  ")
+}
+
+// addSingboxSniffing adds a leading route rule sniffing the traffic of the given inbounds.
+func addSingboxSniffing(routeConfig json_util.RawMessage, tags []string) (json_util.RawMessage, error) {
+	route := map[string]any{}
+	if len(routeConfig) > 0 {
+		if err := json.Unmarshal(routeConfig, &route); err != nil {
+			return nil, err
+		}
+	}
+	rules, _ := route["rules"].([]any)
+	sniff := map[string]any{"inbound": tags, "action": "sniff"}
+	route["rules"] = append([]any{sniff}, rules...)
+	return json.MarshalIndent(route, "", "  ")
+}
@@ -0,0 +1,297 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// Speed limits are shaped by the kernel, as Xray has no bandwidth limiter. The traffic of every
+// limited client that would leave through the default outbound is routed to a copy of it that
+// marks its sockets. HTB classes on the interface of the default route shape what the marked
+// sockets send, and the same classes on an IFB device shape what they receive: ingress packets
+// get the mark of their connection back and are redirected to the IFB device before delivery.
+const (
+	// speedLimitTagPrefix starts the tags of the outbounds of the limited clients.
+	speedLimitTagPrefix = "speed-limit-"
+	// speedLimitMarkBase is the socket mark of the first limited client. The low 16 bits number
+	// the clients and are the minor ID of their HTB classes.
+	speedLimitMarkBase = 0x58550000
+	// speedLimitMarkMask selects the part of a mark that tells the marks of the panel apart.
+	speedLimitMarkMask = 0xffff0000
+	// speedLimitIfb is the IFB device the received traffic is shaped on.
+	speedLimitIfb = "xui-ifb"
+	// speedLimitMax is the most clients that can be limited, one HTB class each.
+	speedLimitMax = 0xfffe
+)
+
+// speedLimit is the throughput cap of a client.
+type speedLimit struct {
+	email string
+	mbit  int
+	mark  int
+}
+
+var (
+	speedLimitSupportOnce sync.Once
+	speedLimitSupport     bool
+
+	// speedLimitShaped describes the shaping set up last, so it is only rebuilt when it changes.
+	speedLimitShaped string
+	speedLimitLock   sync.Mutex
+)
+
+// speedLimitsSupported reports whether the host can shape traffic: it must be Linux with the
+// tc, ip and iptables commands.
+func speedLimitsSupported() bool {
+	speedLimitSupportOnce.Do(func() {
+		if runtime.GOOS != "linux" {
+			return
+		}
+		for _, command := range []string{"tc", "ip", "iptables"} {
+			if _, err := exec.LookPath(command); err != nil {
+				logger.Warningf("Client speed limits are not applied, %s is not installed", command)
+				return
+			}
+		}
+		speedLimitSupport = true
+	})
+	return speedLimitSupport
+}
+
+// speedLimitsOf orders the limits by email and gives every client its mark.
+func speedLimitsOf(limits map[string]int) []speedLimit {
+	emails := make([]string, 0, len(limits))
+	for email := range limits {
+		emails = append(emails, email)
+	}
+	slices.Sort(emails)
+	if len(emails) > speedLimitMax {
+		logger.Warningf("Only %d of %d clients are speed limited", speedLimitMax, len(emails))
+		emails = emails[:speedLimitMax]
+	}
+	result := make([]speedLimit, 0, len(emails))
+	for i, email := range emails {
+		result = append(result, speedLimit{email: email, mbit: limits[email], mark: speedLimitMarkBase + i + 1})
+	}
+	return result
+}
+
+// applySpeedLimits adds an outbound marking its sockets for every limited client to the config,
+// as a copy of the default outbound, and routes the traffic of the client to it. The rules go
+// last, so traffic that other rules send elsewhere is not limited.
+func (s *XrayService) applySpeedLimits(xrayConfig *xray.Config) error {
+	if !speedLimitsSupported() || len(xrayConfig.OutboundConfigs) == 0 {
+		return nil
+	}
+	clientLimits, err := s.inboundService.GetClientSpeedLimits()
+	if err != nil || len(clientLimits) == 0 {
+		return err
+	}
+
+	var outbounds []map[string]any
+	if err := json.Unmarshal(xrayConfig.OutboundConfigs, &outbounds); err != nil {
+		return err
+	}
+	if len(outbounds) == 0 || outbounds[0]["protocol"] == "blackhole" {
+		return nil
+	}
+	defaultOutbound, err := json.Marshal(outbounds[0])
+	if err != nil {
+		return err
+	}
+
+	routing := map[string]any{}
+	if len(xrayConfig.RouterConfig) > 0 {
+		if err := json.Unmarshal(xrayConfig.RouterConfig, &routing); err != nil {
+			return err
+		}
+	}
+	rules, _ := routing["rules"].([]any)
+
+	for _, limit := range speedLimitsOf(clientLimits) {
+		outbound := map[string]any{}
+		if err := json.Unmarshal(defaultOutbound, &outbound); err != nil {
+			return err
+		}
+		tag := fmt.Sprintf("%s%d", speedLimitTagPrefix, limit.mark-speedLimitMarkBase)
+		outbound["tag"] = tag
+		streamSettings, _ := outbound["streamSettings"].(map[string]any)
+		if streamSettings == nil {
+			streamSettings = map[string]any{}
+			outbound["streamSettings"] = streamSettings
+		}
+		sockopt, _ := streamSettings["sockopt"].(map[string]any)
+		if sockopt == nil {
+			sockopt = map[string]any{}
+			streamSettings["sockopt"] = sockopt
+		}
+		sockopt["mark"] = limit.mark
+		outbounds = append(outbounds, outbound)
+		rules = append(rules, map[string]any{
+			"type":        "field",
+			"user":        []string{limit.email},
+			"outboundTag": tag,
+		})
+	}
+	routing["rules"] = rules
+
+	xrayConfig.OutboundConfigs, err = json.MarshalIndent(outbounds, "", "  ")
+	if err != nil {
+		return err
+	}
+	xrayConfig.RouterConfig, err = json.MarshalIndent(routing, "", "  ")
+	return err
+}
+
+// shapeSpeedLimits sets up the HTB classes of the limited clients, or removes them when no client
+// is limited. The rates are not part of the Xray config, so this runs on every restart check.
+func (s *XrayService) shapeSpeedLimits() {
+	if !speedLimitsSupported() {
+		return
+	}
+	clientLimits, err := s.inboundService.GetClientSpeedLimits()
+	if err != nil {
+		logger.Warning("get client speed limits failed:", err)
+		return
+	}
+	limits := speedLimitsOf(clientLimits)
+
+	speedLimitLock.Lock()
+	defer speedLimitLock.Unlock()
+	if len(limits) == 0 {
+		// The IFB device outlives a restart of the panel, and tells the shaping is still set up
+		if speedLimitShaped == "" && exec.Command("ip", "link", "show", speedLimitIfb).Run() != nil {
+			return
+		}
+		dev, _ := defaultRouteDevice()
+		removeSpeedLimitShaping(dev)
+		speedLimitShaped = ""
+		logger.Info("Client speed limits removed")
+		return
+	}
+
+	dev, err := defaultRouteDevice()
+	if err != nil {
+		logger.Warning("Client speed limits are not applied:", err)
+		return
+	}
+	var shaped strings.Builder
+	shaped.WriteString(dev)
+	for _, limit := range limits {
+		fmt.Fprintf(&shaped, ",%x:%d", limit.mark, limit.mbit)
+	}
+	if shaped.String() == speedLimitShaped {
+		return
+	}
+	speedLimitShaped = ""
+	if err := setupSpeedLimitShaping(dev, limits); err != nil {
+		logger.Warning("Client speed limits are not applied:", err)
+		removeSpeedLimitShaping(dev)
+		return
+	}
+	speedLimitShaped = shaped.String()
+	logger.Infof("Client speed limits applied to %d clients on %s", len(limits), dev)
+}
+
+// defaultRouteDevice returns the network interface of the default IPv4 route.
+func defaultRouteDevice() (string, error) {
+	output, err := exec.Command("ip", "-o", "route", "show", "default").Output()
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(output))
+	for i := 0; i+1 < len(fields); i++ {
+		if fields[i] == "dev" {
+			return fields[i+1], nil
+		}
+	}
+	return "", common.NewError("No default route")
+}
+
+// setupSpeedLimitShaping rebuilds the shaping of the limited clients on dev and the IFB device.
+func setupSpeedLimitShaping(dev string, limits []speedLimit) error {
+	removeSpeedLimitQdiscs(dev)
+	// The module may be built in or loaded already
+	exec.Command("modprobe", "ifb").Run()
+	if exec.Command("ip", "link", "show", speedLimitIfb).Run() != nil {
+		if err := runNetCommand("ip", "link", "add", speedLimitIfb, "type", "ifb"); err != nil {
+			return err
+		}
+	}
+	mark := fmt.Sprintf("0x%x/0x%x", speedLimitMarkBase, speedLimitMarkMask)
+	commands := [][]string{
+		{"ip", "link", "set", "dev", speedLimitIfb, "up"},
+		// Sent packets take their socket mark, which the connection keeps for the received ones
+		{"iptables", "-t", "mangle", "-A", "OUTPUT", "-m", "mark", "--mark", mark, "-j", "CONNMARK", "--save-mark"},
+		// Traffic without a class of its own bypasses the shaping
+		{"tc", "qdisc", "add", "dev", dev, "root", "handle", "1:", "htb"},
+		{"tc", "qdisc", "add", "dev", dev, "handle", "ffff:", "ingress"},
+		{"tc", "filter", "add", "dev", dev, "parent", "ffff:", "protocol", "all", "prio", "1", "u32", "match", "u32", "0", "0",
+			"action", "connmark", "action", "mirred", "egress", "redirect", "dev", speedLimitIfb},
+		{"tc", "qdisc", "add", "dev", speedLimitIfb, "root", "handle", "1:", "htb"},
+	}
+	if _, err := exec.LookPath("ip6tables"); err == nil {
+		commands = append(commands, []string{"ip6tables", "-t", "mangle", "-A", "OUTPUT", "-m", "mark", "--mark", mark, "-j", "CONNMARK", "--save-mark"})
+	}
+	for _, limit := range limits {
+		classID := fmt.Sprintf("1:%x", limit.mark-speedLimitMarkBase)
+		rate := fmt.Sprintf("%dmbit", limit.mbit)
+		for _, device := range []string{dev, speedLimitIfb} {
+			commands = append(commands,
+				[]string{"tc", "class", "add", "dev", device, "parent", "1:", "classid", classID, "htb", "rate", rate, "ceil", rate},
+				[]string{"tc", "filter", "add", "dev", device, "parent", "1:", "protocol", "all", "prio", "1",
+					"handle", fmt.Sprintf("0x%x", limit.mark), "fw", "flowid", classID})
+		}
+	}
+	for _, command := range commands {
+		if err := runNetCommand(command[0], command[1:]...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeSpeedLimitShaping removes the shaping of the limited clients from dev, if known, and
+// removes the IFB device and the connection mark rules.
+func removeSpeedLimitShaping(dev string) {
+	if dev != "" {
+		removeSpeedLimitQdiscs(dev)
+	}
+	exec.Command("ip", "link", "del", speedLimitIfb).Run()
+}
+
+// removeSpeedLimitQdiscs removes the qdiscs of the shaping from dev and the rules saving the
+// marks of the limited clients to their connections.
+func removeSpeedLimitQdiscs(dev string) {
+	exec.Command("tc", "qdisc", "del", "dev", dev, "root").Run()
+	exec.Command("tc", "qdisc", "del", "dev", dev, "ingress").Run()
+	exec.Command("tc", "qdisc", "del", "dev", speedLimitIfb, "root").Run()
+	mark := fmt.Sprintf("0x%x/0x%x", speedLimitMarkBase, speedLimitMarkMask)
+	for _, command := range []string{"iptables", "ip6tables"} {
+		// Deleted one at a time, until none is left
+		for {
+			err := exec.Command(command, "-t", "mangle", "-D", "OUTPUT", "-m", "mark", "--mark", mark, "-j", "CONNMARK", "--save-mark").Run()
+			if err != nil {
+				break
+			}
+		}
+	}
+}
+
+// runNetCommand runs a command configuring the network of the host, returning its output with the error.
+func runNetCommand(name string, args ...string) error {
+	output, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return common.NewErrorf("%s %s: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+)
+
+const (
+	speedtestDuration      = 15 * time.Second    // Longest download of a target
+	speedtestLatencySample = 3                   // TCP connects averaged for the latency
+	speedtestRetention     = 90 * 24 * time.Hour // Results older than this are deleted
+)
+
+// speedtestLock prevents a manual and a scheduled speedtest from measuring each other.
+var speedtestLock sync.Mutex
+
+// SpeedtestService measures the latency and download throughput of the server towards
+// the configured targets and keeps the results.
+type SpeedtestService struct {
+	settingService SettingService
+}
+
+// RunSpeedtest tests every configured target one after another and stores the results.
+// A target that fails is stored with its error.
+func (s *SpeedtestService) RunSpeedtest() ([]*model.SpeedtestResult, error) {
+	if !speedtestLock.TryLock() {
+		return nil, common.NewError("A speedtest is already running")
+	}
+	defer speedtestLock.Unlock()
+
+	targetSetting, err := s.settingService.GetSpeedtestTargets()
+	if err != nil {
+		return nil, err
+	}
+	var targets []string
+	for line := range strings.SplitSeq(targetSetting, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			targets = append(targets, line)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, common.NewError("No speedtest targets are configured")
+	}
+
+	start := time.Now().Unix()
+	results := make([]*model.SpeedtestResult, 0, len(targets))
+	for _, target := range targets {
+		result := measureSpeedtestTarget(target)
+		result.Time = start
+		if result.Error != "" {
+			logger.Warning("Speedtest of", target, "failed:", result.Error)
+		}
+		results = append(results, result)
+	}
+
+	db := database.GetDB()
+	if err := db.Create(&results).Error; err != nil {
+		return nil, err
+	}
+	before := time.Now().Add(-speedtestRetention).Unix()
+	if err := db.Where("time < ?", before).Delete(model.SpeedtestResult{}).Error; err != nil {
+		logger.Warning("Prune speedtest results failed:", err)
+	}
+	return results, nil
+}
+
+// GetSpeedtestResults returns the most recent speedtest results, newest first.
+func (s *SpeedtestService) GetSpeedtestResults(limit int) ([]*model.SpeedtestResult, error) {
+	var results []*model.SpeedtestResult
+	err := database.GetDB().Model(model.SpeedtestResult{}).Order("time desc, id").Limit(limit).Find(&results).Error
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// lastSpeedtestMbps returns the average throughput of the successful targets of the latest speedtest.
+// It reports false when no speedtest has succeeded yet.
+func lastSpeedtestMbps() (float64, bool, error) {
+	var last model.SpeedtestResult
+	err := database.GetDB().Model(model.SpeedtestResult{}).Order("time desc").Limit(1).Find(&last).Error
+	if err != nil || last.Id == 0 {
+		return 0, false, err
+	}
+	var mbps []float64
+	err = database.GetDB().Model(model.SpeedtestResult{}).
+		Where("time = ? AND error = ?", last.Time, "").
+		Pluck("download_mbps", &mbps).Error
+	if err != nil || len(mbps) == 0 {
+		return 0, false, err
+	}
+	var sum float64
+	for _, v := range mbps {
+		sum += v
+	}
+	return sum / float64(len(mbps)), true, nil
+}
+
+// measureSpeedtestTarget measures the TCP connect latency to the host of target and the throughput
+// of downloading it for at most speedtestDuration.
+func measureSpeedtestTarget(target string) *model.SpeedtestResult {
+	result := &model.SpeedtestResult{Target: target}
+	u, err := url.Parse(target)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	address := net.JoinHostPort(u.Hostname(), port)
+
+	var latency time.Duration
+	for range speedtestLatencySample {
+		connectStart := time.Now()
+		conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		latency += time.Since(connectStart)
+		conn.Close()
+	}
+	result.LatencyMs = float64(latency.Microseconds()) / 1000 / speedtestLatencySample
+
+	ctx, cancel := context.WithTimeout(context.Background(), speedtestDuration)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	downloadStart := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		result.Error = resp.Status
+		return result
+	}
+	// Running out of time ends the download early, the bytes read so far are still measured
+	result.Bytes, err = io.Copy(io.Discard, resp.Body)
+	elapsed := time.Since(downloadStart)
+	if err != nil && ctx.Err() == nil {
+		result.Error = err.Error()
+		return result
+	}
+	if elapsed > 0 {
+		result.DownloadMbps = float64(result.Bytes) * 8 / elapsed.Seconds() / 1e6
+	}
+	return result
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+)
+
+// subAccessLimit is the number of fetches kept for each subscription.
+const subAccessLimit = 200
+
+// SubAccessStats summarizes the recorded fetches of a subscription.
+// The counters cover the fetches still kept, at most subAccessLimit.
+type SubAccessStats struct {
+	SubId       string             `json:"subId"`
+	Count       int                `json:"count"`
+	DistinctIps int                `json:"distinctIps"`
+	LastAccess  int64              `json:"lastAccess"`
+	Accesses    []*model.SubAccess `json:"accesses"`
+}
+
+// AddSubAccess records a fetch of a subscription and drops the oldest records above the limit.
+func (s *InboundService) AddSubAccess(subId string, ip string, userAgent string, format string) error {
+	db := database.GetDB()
+	err := db.Create(&model.SubAccess{
+		SubId:     subId,
+		Ip:        ip,
+		UserAgent: userAgent,
+		Format:    format,
+		CreatedAt: time.Now().UnixMilli(),
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	var staleIds []int
+	err = db.Model(model.SubAccess{}).
+		Where("sub_id = ?", subId).
+		Order("id desc").
+		Offset(subAccessLimit).
+		Pluck("id", &staleIds).Error
+	if err != nil || len(staleIds) == 0 {
+		return err
+	}
+	return db.Where("id in ?", staleIds).Delete(model.SubAccess{}).Error
+}
+
+// GetClientSubAccess returns the recorded fetches of the subscription of a client, newest first.
+func (s *InboundService) GetClientSubAccess(email string) (*SubAccessStats, error) {
+	_, client, err := s.GetClientByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if client.SubID == "" {
+		return nil, common.NewError("Client has no subscription:", email)
+	}
+
+	db := database.GetDB()
+	stats := &SubAccessStats{SubId: client.SubID}
+	err = db.Model(model.SubAccess{}).Where("sub_id = ?", client.SubID).Order("id desc").Find(&stats.Accesses).Error
+	if err != nil {
+		return nil, err
+	}
+	ips := make(map[string]bool)
+	for _, access := range stats.Accesses {
+		ips[access.Ip] = true
+	}
+	stats.Count = len(stats.Accesses)
+	stats.DistinctIps = len(ips)
+	if stats.Count > 0 {
+		stats.LastAccess = stats.Accesses[0].CreatedAt
+	}
+	return stats, nil
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+)
+
+// GetSubSignSecret returns the key subscription URLs are signed with, generating and saving
+// it on first use.
+func (s *SettingService) GetSubSignSecret() ([]byte, error) {
+	secret, err := s.getString("subSignSecret")
+	if secret == defaultValueMap["subSignSecret"] {
+		err := s.saveSetting("subSignSecret", secret)
+		if err != nil {
+			logger.Warning("save subSignSecret failed:", err)
+		}
+	}
+	return []byte(secret), err
+}
+
+// SignSubId returns the query string that makes the subscription URL of subId valid until
+// expires, given in unix seconds. An expires of 0 signs a URL that never expires.
+func (s *SettingService) SignSubId(subId string, expires int64) (string, error) {
+	if subId == "" {
+		return "", common.NewError("Empty subscription ID")
+	}
+	secret, err := s.GetSubSignSecret()
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("sig", subSignature(secret, subId, expires))
+	return query.Encode(), nil
+}
+
+// SignSubIdForDays signs the subscription URL of subId for the given number of days.
+// Days <= 0 fall back to the subSignDays setting; 0 there signs a URL that never expires.
+func (s *SettingService) SignSubIdForDays(subId string, days int) (string, error) {
+	if days <= 0 {
+		defaultDays, err := s.GetSubSignDays()
+		if err != nil {
+			return "", err
+		}
+		days = defaultDays
+	}
+	var expires int64
+	if days > 0 {
+		expires = time.Now().Add(time.Duration(days) * 24 * time.Hour).Unix()
+	}
+	return s.SignSubId(subId, expires)
+}
+
+// VerifySubSignature returns an error unless sig is a valid, unexpired signature of subId.
+func (s *SettingService) VerifySubSignature(subId string, expires string, sig string) error {
+	if expires == "" || sig == "" {
+		return common.NewError("missing subscription signature")
+	}
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || expiresAt < 0 {
+		return common.NewError("invalid subscription expiry:", expires)
+	}
+	if expiresAt > 0 && time.Now().Unix() > expiresAt {
+		return common.NewError("subscription link expired at", time.Unix(expiresAt, 0).Format(time.RFC3339))
+	}
+	secret, err := s.GetSubSignSecret()
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(sig), []byte(subSignature(secret, subId, expiresAt))) {
+		return common.NewError("invalid subscription signature")
+	}
+	return nil
+}
+
+// subSignature is the hex HMAC-SHA256 of the subscription ID and its expiry.
+func subSignature(secret []byte, subId string, expires int64) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s:%d", subId, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
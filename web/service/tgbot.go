@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"embed"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -41,8 +42,10 @@ var (
 	bot         *telego.Bot
 	botHandler  *th.BotHandler
 	adminIds    []int64
+	supportIds  []int64
 	isRunning   bool
 	hostname    string
+	botUsername string
 	hashStorage *global.HashStorage
 
 	// Performance improvements
@@ -100,6 +103,7 @@ type Tgbot struct {
 	settingService SettingService
 	serverService  ServerService
 	xrayService    XrayService
+	planService    PlanService
 	lastStatus     *Status
 }
 
@@ -211,6 +215,23 @@ func (t *Tgbot) Start(i18nFS embed.FS) error {
 		}
 	}
 
+	// Parse support agent IDs from comma-separated string
+	tgBotSupportID, err := t.settingService.GetTgBotSupportChatId()
+	if err != nil {
+		logger.Warning("Failed to get Telegram bot support chat ID:", err)
+		return err
+	}
+	if tgBotSupportID != "" {
+		for _, supportID := range strings.Split(tgBotSupportID, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(supportID))
+			if err != nil {
+				logger.Warning("Failed to parse support ID from Telegram bot support chat ID:", err)
+				return err
+			}
+			supportIds = append(supportIds, int64(id))
+		}
+	}
+
 	// Get Telegram bot proxy URL
 	tgBotProxy, err := t.settingService.GetTgBotProxy()
 	if err != nil {
@@ -230,6 +251,13 @@ func (t *Tgbot) Start(i18nFS embed.FS) error {
 		return err
 	}
 
+	// Get the bot username for the deep links of the client link codes
+	if me, err := bot.GetMe(context.Background()); err == nil {
+		botUsername = me.Username
+	} else {
+		logger.Warning("Failed to get Telegram bot username:", err)
+	}
+
 	// After bot initialization, set up bot commands with localized descriptions
 	err = bot.SetMyCommands(context.Background(), &telego.SetMyCommandsParams{
 		Commands: []telego.BotCommand{
@@ -237,6 +265,7 @@ func (t *Tgbot) Start(i18nFS embed.FS) error {
 			{Command: "help", Description: t.I18nBot("tgbot.commands.helpDesc")},
 			{Command: "status", Description: t.I18nBot("tgbot.commands.statusDesc")},
 			{Command: "id", Description: t.I18nBot("tgbot.commands.idDesc")},
+			{Command: "link", Description: t.I18nBot("tgbot.commands.linkDesc")},
 		},
 	})
 	if err != nil {
@@ -308,12 +337,14 @@ func (t *Tgbot) SetHostname() {
 
 // Stop stops the Telegram bot and cleans up resources.
 func (t *Tgbot) Stop() {
+	t.stopReceivingUpdates()
 	if botHandler != nil {
 		botHandler.Stop()
 	}
 	logger.Info("Stop Telegram receiver ...")
 	isRunning = false
 	adminIds = nil
+	supportIds = nil
 }
 
 // encodeQuery encodes the query string if it's longer than 64 characters.
@@ -342,12 +373,12 @@ func (t *Tgbot) decodeQuery(query string) (string, error) {
 
 // OnReceive starts the message receiving loop for the Telegram bot.
 func (t *Tgbot) OnReceive() {
-	params := telego.GetUpdatesParams{
-		Timeout: 30, // Increased timeout to reduce API calls
+	updates, err := t.receiveUpdates()
+	if err != nil {
+		logger.Error("Failed to receive Telegram bot updates:", err)
+		return
 	}
 
-	updates, _ := bot.UpdatesViaLongPolling(context.Background(), &params)
-
 	botHandler, _ = th.NewBotHandler(bot, updates)
 
 	botHandler.HandleMessage(func(ctx *th.Context, message telego.Message) error {
@@ -363,7 +394,7 @@ func (t *Tgbot) OnReceive() {
 			defer func() { <-messageWorkerPool }() // Release worker
 
 			delete(userStates, message.Chat.ID)
-			t.answerCommand(&message, message.Chat.ID, checkAdmin(message.From.ID))
+			t.answerCommand(&message, message.Chat.ID, checkAdmin(message.From.ID) || checkSupport(message.From.ID))
 		}()
 		return nil
 	}, th.AnyCommand())
@@ -375,7 +406,7 @@ func (t *Tgbot) OnReceive() {
 			defer func() { <-messageWorkerPool }() // Release worker
 
 			delete(userStates, query.Message.GetChat().ID)
-			t.answerCallback(&query, checkAdmin(query.From.ID))
+			t.answerCallback(&query, checkAdmin(query.From.ID) || checkSupport(query.From.ID))
 		}()
 		return nil
 	}, th.AnyCallbackQueryWithMessage())
@@ -535,6 +566,11 @@ func (t *Tgbot) answerCommand(message *telego.Message, chatId int64, isAdmin boo
 
 	command, _, commandArgs := tu.ParseCommand(message.Text)
 
+	if isAdmin && !t.authorizeBotAction(message.From, "/"+command, strings.Join(commandArgs, " ")) {
+		t.sendResponse(chatId, t.I18nBot("tgbot.answers.notPermitted"), true, isAdmin)
+		return
+	}
+
 	// Helper function to handle unknown commands.
 	handleUnknownCommand := func() {
 		msg += t.I18nBot("tgbot.commands.unknown")
@@ -547,6 +583,10 @@ func (t *Tgbot) answerCommand(message *telego.Message, chatId int64, isAdmin boo
 		msg += t.I18nBot("tgbot.commands.pleaseChoose")
 	case "start":
 		msg += t.I18nBot("tgbot.commands.start", "Firstname=="+message.From.FirstName)
+		if len(commandArgs) > 0 {
+			// Opened from the deep link of a client link code
+			msg += t.linkClientByCode(message.From.ID, commandArgs[0]) + "\r\n"
+		}
 		if isAdmin {
 			msg += t.I18nBot("tgbot.commands.welcome", "Hostname=="+hostname)
 		}
@@ -568,6 +608,13 @@ func (t *Tgbot) answerCommand(message *telego.Message, chatId int64, isAdmin boo
 		} else {
 			msg += t.I18nBot("tgbot.commands.usage")
 		}
+	case "link":
+		onlyMessage = true
+		if len(commandArgs) > 0 {
+			msg += t.linkClientByCode(message.From.ID, commandArgs[0])
+		} else {
+			msg += t.I18nBot("tgbot.commands.linkUsage")
+		}
 	case "inbound":
 		onlyMessage = true
 		if isAdmin && len(commandArgs) > 0 {
@@ -575,6 +622,17 @@ func (t *Tgbot) answerCommand(message *telego.Message, chatId int64, isAdmin boo
 		} else {
 			handleUnknownCommand()
 		}
+	case "newclient":
+		onlyMessage = true
+		if isAdmin {
+			if len(commandArgs) > 0 {
+				t.choosePlanForClient(chatId, commandArgs[0])
+			} else {
+				msg += t.I18nBot("tgbot.commands.newClientUsage")
+			}
+		} else {
+			handleUnknownCommand()
+		}
 	case "restart":
 		onlyMessage = true
 		if isAdmin {
@@ -648,6 +706,11 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 		}
 		dataArray := strings.Split(decodedQuery, " ")
 
+		if !t.authorizeBotAction(&callbackQuery.From, dataArray[0], strings.Join(dataArray[1:], " ")) {
+			t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.notPermitted"))
+			return
+		}
+
 		if len(dataArray) >= 2 && len(dataArray[1]) > 0 {
 			email := dataArray[1]
 			switch dataArray[0] {
@@ -978,7 +1041,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 								if traffic.ExpiryTime-time.Now().Unix()*1000 < 0 {
 									date = -int64(days * 24 * 60 * 60000)
 								} else {
-									date = traffic.ExpiryTime + int64(days*24*60*60000)
+									date = t.settingService.ExtendExpiry(traffic.ExpiryTime, days)
 								}
 							} else {
 								date = traffic.ExpiryTime - int64(days*24*60*60000)
@@ -1068,7 +1131,7 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 					if client_ExpiryTime-time.Now().Unix()*1000 < 0 {
 						date = -int64(days * 24 * 60 * 60000)
 					} else {
-						date = client_ExpiryTime + int64(days*24*60*60000)
+						date = t.settingService.ExtendExpiry(client_ExpiryTime, days)
 					}
 				} else {
 					date = client_ExpiryTime - int64(days*24*60*60000)
@@ -1412,6 +1475,69 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 				} else {
 					t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.errorOperation"))
 				}
+			case "add_traffic":
+				inlineKeyboard := tu.InlineKeyboard(
+					tu.InlineKeyboardRow(
+						tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.cancel")).WithCallbackData(t.encodeQuery("client_cancel "+email)),
+					),
+					tu.InlineKeyboardRow(
+						tu.InlineKeyboardButton(t.I18nBot("tgbot.add")+" 1 GB").WithCallbackData(t.encodeQuery("add_traffic_c "+email+" 1")),
+						tu.InlineKeyboardButton(t.I18nBot("tgbot.add")+" 5 GB").WithCallbackData(t.encodeQuery("add_traffic_c "+email+" 5")),
+						tu.InlineKeyboardButton(t.I18nBot("tgbot.add")+" 10 GB").WithCallbackData(t.encodeQuery("add_traffic_c "+email+" 10")),
+					),
+					tu.InlineKeyboardRow(
+						tu.InlineKeyboardButton(t.I18nBot("tgbot.add")+" 20 GB").WithCallbackData(t.encodeQuery("add_traffic_c "+email+" 20")),
+						tu.InlineKeyboardButton(t.I18nBot("tgbot.add")+" 50 GB").WithCallbackData(t.encodeQuery("add_traffic_c "+email+" 50")),
+						tu.InlineKeyboardButton(t.I18nBot("tgbot.add")+" 100 GB").WithCallbackData(t.encodeQuery("add_traffic_c "+email+" 100")),
+					),
+				)
+				t.editMessageCallbackTgBot(chatId, callbackQuery.Message.GetMessageID(), inlineKeyboard)
+			case "add_traffic_c":
+				if len(dataArray) == 3 {
+					addTraffic, err := strconv.Atoi(dataArray[2])
+					if err == nil {
+						needRestart, err := t.inboundService.AddClientTrafficLimitByEmail(email, addTraffic)
+						if needRestart {
+							t.xrayService.SetToNeedRestart()
+						}
+						if err == nil {
+							t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.addTrafficSuccess", "Email=="+email))
+							t.searchClient(chatId, email, callbackQuery.Message.GetMessageID())
+							return
+						}
+						logger.Warning(err)
+					}
+				}
+				t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.errorOperation"))
+				t.searchClient(chatId, email, callbackQuery.Message.GetMessageID())
+			case "plan_client":
+				if len(dataArray) == 3 {
+					inbounds, err := t.getClientInboundsFor("plan_client_c " + email + " " + dataArray[2])
+					if err != nil {
+						t.sendCallbackAnswerTgBot(callbackQuery.ID, err.Error())
+						return
+					}
+					t.editMessageTgBot(chatId, callbackQuery.Message.GetMessageID(), t.I18nBot("tgbot.answers.chooseInbound"), inbounds)
+				}
+			case "plan_client_c":
+				if len(dataArray) == 4 {
+					planId, err1 := strconv.Atoi(dataArray[2])
+					inboundId, err2 := strconv.Atoi(dataArray[3])
+					if err1 == nil && err2 == nil {
+						needRestart, err := t.addPlanClient(inboundId, planId, email)
+						if needRestart {
+							t.xrayService.SetToNeedRestart()
+						}
+						if err != nil {
+							t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.error_add_client", "error=="+err.Error()))
+							return
+						}
+						t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.clientCreated", "Email=="+email))
+						t.searchClient(chatId, email, callbackQuery.Message.GetMessageID())
+						return
+					}
+				}
+				t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.answers.errorOperation"))
 			case "get_clients":
 				inboundId := dataArray[1]
 				inboundIdInt, err := strconv.Atoi(inboundId)
@@ -1505,6 +1631,8 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 			}
 
 		}
+	} else if t.answerClientCallback(callbackQuery) {
+		return
 	}
 
 	switch callbackQuery.Data {
@@ -2205,10 +2333,8 @@ func (t *Tgbot) buildSubscriptionURLs(email string) (string, string, error) {
 	subPath, _ := t.settingService.GetSubPath()
 	subJsonPath, _ := t.settingService.GetSubJsonPath()
 	subJsonEnable, _ := t.settingService.GetSubJsonEnable()
-	subKeyFile, _ := t.settingService.GetSubKeyFile()
-	subCertFile, _ := t.settingService.GetSubCertFile()
 
-	tls := (subKeyFile != "" && subCertFile != "")
+	tls := t.settingService.GetSubTLS()
 	scheme := "http"
 	if tls {
 		scheme = "https"
@@ -2227,10 +2353,14 @@ func (t *Tgbot) buildSubscriptionURLs(email string) (string, string, error) {
 	}
 
 	host := subDomain
+	if strings.Contains(host, ":") {
+		// An IPv6 address
+		host = "[" + host + "]"
+	}
 	if (subPort == 443 && tls) || (subPort == 80 && !tls) {
 		// standard ports: no port in host
 	} else {
-		host = fmt.Sprintf("%s:%d", subDomain, subPort)
+		host = fmt.Sprintf("%s:%d", host, subPort)
 	}
 
 	// Ensure paths
@@ -2497,6 +2627,10 @@ func (t *Tgbot) SendBackupToAdmins() {
 	for _, adminId := range adminIds {
 		t.sendBackup(int64(adminId))
 	}
+	if len(adminIds) > 0 {
+		notifyService := NotifyService{}
+		notifyService.Notify(NotifyBackup, t.I18nBot("tgbot.messages.backupDone", "Hostname=="+hostname, "Via==Telegram"))
+	}
 }
 
 // sendExhaustedToAdmins sends notifications about exhausted clients to admins.
@@ -2547,7 +2681,7 @@ func (t *Tgbot) prepareServerUsageInfo() string {
 		t.lastStatus = t.serverService.GetStatus(t.lastStatus)
 		t.setCachedStatus(t.lastStatus)
 	}
-	onlines := p.GetOnlineClients()
+	onlines := getOnlineClients()
 
 	info += t.I18nBot("tgbot.messages.hostname", "Hostname=="+hostname)
 	info += t.I18nBot("tgbot.messages.version", "Version=="+config.GetVersion())
@@ -2597,10 +2731,6 @@ func (t *Tgbot) prepareServerUsageInfo() string {
 
 // UserLoginNotify sends a notification about user login attempts to admins.
 func (t *Tgbot) UserLoginNotify(username string, password string, ip string, time string, status LoginStatus) {
-	if !t.IsRunning() {
-		return
-	}
-
 	if username == "" || ip == "" || time == "" {
 		logger.Warning("UserLoginNotify failed, invalid info!")
 		return
@@ -2624,7 +2754,8 @@ func (t *Tgbot) UserLoginNotify(username string, password string, ip string, tim
 	msg += t.I18nBot("tgbot.messages.username", "Username=="+username)
 	msg += t.I18nBot("tgbot.messages.ip", "IP=="+ip)
 	msg += t.I18nBot("tgbot.messages.time", "Time=="+time)
-	t.SendMsgToTgbotAdmins(msg)
+	notifyService := NotifyService{}
+	notifyService.Notify(NotifyLogin, msg)
 }
 
 // getInboundUsages retrieves and formats inbound usage information.
@@ -2755,6 +2886,11 @@ func (t *Tgbot) getInboundClientsFor(inboundID int, action string) (*telego.Inli
 
 // getInboundsAddClient creates an inline keyboard for adding clients to inbounds.
 func (t *Tgbot) getInboundsAddClient() (*telego.InlineKeyboardMarkup, error) {
+	return t.getClientInboundsFor("add_client_to")
+}
+
+// getClientInboundsFor builds an inline keyboard of the inbounds that take clients for a custom next action.
+func (t *Tgbot) getClientInboundsFor(nextAction string) (*telego.InlineKeyboardMarkup, error) {
 	inbounds, err := t.inboundService.GetAllInbounds()
 	if err != nil {
 		logger.Warning("GetAllInbounds run failed:", err)
@@ -2783,7 +2919,7 @@ func (t *Tgbot) getInboundsAddClient() (*telego.InlineKeyboardMarkup, error) {
 		if inbound.Enable {
 			status = "✅"
 		}
-		callbackData := t.encodeQuery(fmt.Sprintf("%s %d", "add_client_to", inbound.Id))
+		callbackData := t.encodeQuery(fmt.Sprintf("%s %d", nextAction, inbound.Id))
 		buttons = append(buttons, tu.InlineKeyboardButton(fmt.Sprintf("%v - %v", inbound.Remark, status)).WithCallbackData(callbackData))
 	}
 
@@ -2900,7 +3036,7 @@ func (t *Tgbot) clientInfoMsg(
 
 	status := t.I18nBot("tgbot.offline")
 	if p.IsRunning() {
-		for _, online := range p.GetOnlineClients() {
+		for _, online := range getOnlineClients() {
 			if online == traffic.Email {
 				status = t.I18nBot("tgbot.online")
 				break
@@ -3089,6 +3225,7 @@ func (t *Tgbot) searchClient(chatId int64, email string, messageID ...int) {
 		),
 		tu.InlineKeyboardRow(
 			tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.resetExpire")).WithCallbackData(t.encodeQuery("reset_exp "+email)),
+			tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.addTraffic")).WithCallbackData(t.encodeQuery("add_traffic "+email)),
 		),
 		tu.InlineKeyboardRow(
 			tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.ipLog")).WithCallbackData(t.encodeQuery("ip_log "+email)),
@@ -3100,6 +3237,11 @@ func (t *Tgbot) searchClient(chatId int64, email string, messageID ...int) {
 		tu.InlineKeyboardRow(
 			tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.toggle")).WithCallbackData(t.encodeQuery("toggle_enable "+email)),
 		),
+		tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton(t.I18nBot("pages.settings.subSettings")).WithCallbackData(t.encodeQuery("client_sub_links "+email)),
+			tu.InlineKeyboardButton(t.I18nBot("subscription.individualLinks")).WithCallbackData(t.encodeQuery("client_individual_links "+email)),
+			tu.InlineKeyboardButton(t.I18nBot("qrCode")).WithCallbackData(t.encodeQuery("client_qr_links "+email)),
+		),
 	)
 	if len(messageID) > 0 {
 		t.editMessageTgBot(chatId, messageID[0], output, inlineKeyboard)
@@ -3205,6 +3347,69 @@ func (t *Tgbot) addClient(chatId int64, msg string, messageID ...int) {
 
 }
 
+// choosePlanForClient asks for the plan of a new client with the given email.
+func (t *Tgbot) choosePlanForClient(chatId int64, email string) {
+	plans, err := t.planService.GetPlans()
+	if err != nil {
+		logger.Warning(err)
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.wentWrong"))
+		return
+	}
+	if len(plans) == 0 {
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.answers.noPlans"))
+		return
+	}
+
+	var buttons []telego.InlineKeyboardButton
+	for _, plan := range plans {
+		callbackData := t.encodeQuery(fmt.Sprintf("plan_client %s %d", email, plan.Id))
+		buttons = append(buttons, tu.InlineKeyboardButton(plan.Name).WithCallbackData(callbackData))
+	}
+	cols := 1
+	if len(buttons) >= 6 {
+		cols = 2
+	}
+	keyboard := tu.InlineKeyboardGrid(tu.InlineKeyboardCols(cols, buttons...))
+	t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.answers.choosePlan", "Email=="+email), keyboard)
+}
+
+// addPlanClient adds a new enabled client on a plan to an inbound. The limits and expiry
+// are copied from the plan when the client is added.
+func (t *Tgbot) addPlanClient(inboundId int, planId int, email string) (bool, error) {
+	inbound, err := t.inboundService.GetInbound(inboundId)
+	if err != nil {
+		return false, err
+	}
+	client := model.Client{
+		Email:  email,
+		Enable: true,
+		SubID:  t.randomLowerAndNum(16),
+		PlanId: planId,
+	}
+	switch inbound.Protocol {
+	case model.VMESS:
+		client.ID = uuid.New().String()
+		client.Security = "auto"
+	case model.VLESS:
+		client.ID = uuid.New().String()
+	case model.Trojan, model.Hysteria2:
+		client.Password = t.randomLowerAndNum(10)
+	case model.Shadowsocks:
+		client.Password = t.randomShadowSocksPassword()
+	default:
+		return false, errors.New("unknown protocol")
+	}
+
+	settings, err := json.Marshal(map[string][]model.Client{"clients": {client}})
+	if err != nil {
+		return false, err
+	}
+	return t.inboundService.AddInboundClient(&model.Inbound{
+		Id:       inboundId,
+		Settings: string(settings),
+	})
+}
+
 // searchInbound searches for inbounds by remark and sends the results.
 func (t *Tgbot) searchInbound(chatId int64, remark string) {
 	inbounds, err := t.inboundService.SearchInbounds(remark)
@@ -3428,7 +3633,7 @@ func (t *Tgbot) onlineClients(chatId int64, messageID ...int) {
 		return
 	}
 
-	onlines := p.GetOnlineClients()
+	onlines := getOnlineClients()
 	onlinesCount := len(onlines)
 	output := t.I18nBot("tgbot.messages.onlinesCount", "Count=="+fmt.Sprint(onlinesCount))
 	keyboard := tu.InlineKeyboard(tu.InlineKeyboardRow(
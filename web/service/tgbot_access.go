@@ -0,0 +1,124 @@
+package service
+
+import (
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+
+	"github.com/mymmrac/telego"
+)
+
+// Roles of the Telegram bot users that manage the panel.
+const (
+	BotRoleAdmin   = "admin"   // Full control of the bot
+	BotRoleSupport = "support" // Limited to lookups and renewals
+)
+
+// botAuditRetention is how long the audit log of the bot is kept.
+const botAuditRetention = 90 * 24 * time.Hour
+
+// botSupportActions lists the commands and buttons support agents may use: lookups of
+// clients, inbounds and links, and renewals of the expiry and traffic of a client.
+var botSupportActions = map[string]bool{
+	"/start":   true,
+	"/help":    true,
+	"/status":  true,
+	"/id":      true,
+	"/usage":   true,
+	"/inbound": true,
+	"/link":    true,
+
+	"commands":                      true,
+	"get_usage":                     true,
+	"usage_refresh":                 true,
+	"inbounds":                      true,
+	"deplete_soon":                  true,
+	"onlines":                       true,
+	"onlines_refresh":               true,
+	"get_inbounds":                  true,
+	"get_clients":                   true,
+	"client_get_usage":              true,
+	"client_refresh":                true,
+	"client_cancel":                 true,
+	"ip_log":                        true,
+	"ips_refresh":                   true,
+	"ips_cancel":                    true,
+	"tg_user":                       true,
+	"tgid_refresh":                  true,
+	"tgid_cancel":                   true,
+	"admin_client_sub_links":        true,
+	"admin_client_individual_links": true,
+	"admin_client_qr_links":         true,
+	"get_clients_for_sub":           true,
+	"get_clients_for_individual":    true,
+	"get_clients_for_qr":            true,
+	"client_sub_links":              true,
+	"client_individual_links":       true,
+	"client_qr_links":               true,
+
+	"reset_exp":     true,
+	"reset_exp_c":   true,
+	"reset_exp_in":  true,
+	"add_traffic":   true,
+	"add_traffic_c": true,
+}
+
+// checkSupport checks if the given Telegram ID is a support agent.
+func checkSupport(tgId int64) bool {
+	return int64Contains(supportIds, tgId)
+}
+
+// botRole returns the role of a Telegram user, empty for users that do not manage the panel.
+// An ID listed both as admin and as support agent is an admin.
+func botRole(tgId int64) string {
+	switch {
+	case checkAdmin(tgId):
+		return BotRoleAdmin
+	case checkSupport(tgId):
+		return BotRoleSupport
+	}
+	return ""
+}
+
+// authorizeBotAction reports whether the role of a user permits a command or button, and
+// records the attempt in the audit log of the bot.
+func (t *Tgbot) authorizeBotAction(from *telego.User, action string, target string) bool {
+	role := botRole(from.ID)
+	allowed := role == BotRoleAdmin || (role == BotRoleSupport && botSupportActions[action])
+	if !allowed {
+		logger.Warningf("Telegram user %d (%s) with role %q is not permitted to use %s", from.ID, from.Username, role, action)
+	}
+
+	db := database.GetDB()
+	now := time.Now()
+	entry := &model.BotAuditLog{
+		TgId:      from.ID,
+		Username:  from.Username,
+		Role:      role,
+		Action:    action,
+		Target:    target,
+		Allowed:   allowed,
+		CreatedAt: now.Unix(),
+	}
+	if err := db.Create(entry).Error; err != nil {
+		logger.Warning("Record Telegram bot audit log failed:", err)
+	}
+	db.Where("created_at < ?", now.Add(-botAuditRetention).Unix()).Delete(model.BotAuditLog{})
+	return allowed
+}
+
+// GetBotAuditLogs returns the recent audit log entries of the bot, newest first.
+// A non-zero tgId returns only those of that user.
+func (t *Tgbot) GetBotAuditLogs(tgId int64, limit int) ([]*model.BotAuditLog, error) {
+	query := database.GetDB().Model(model.BotAuditLog{})
+	if tgId != 0 {
+		query = query.Where("tg_id = ?", tgId)
+	}
+	var entries []*model.BotAuditLog
+	if err := query.Order("id desc").Limit(limit).Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"crypto/rand"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+
+	"github.com/mymmrac/telego"
+)
+
+// clientLinkCodeTTL is how long a code for linking a Telegram account to a client is valid.
+const clientLinkCodeTTL = 15 * time.Minute
+
+// clientLinkCodeCharset leaves out the characters that are easily mistaken for each other.
+const clientLinkCodeCharset = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// ClientLinkCode is a one-time code that links the Telegram account sending it to the bot to a client.
+type ClientLinkCode struct {
+	Code      string `json:"code"`
+	Link      string `json:"link"`      // Deep link to the bot sending the code, empty when the bot is not running
+	ExpiresAt int64  `json:"expiresAt"` // Unix time in seconds
+}
+
+type pendingClientLink struct {
+	email     string
+	expiresAt time.Time
+}
+
+var (
+	clientLinkCodes   = make(map[string]pendingClientLink)
+	clientLinkCodesMu sync.Mutex
+)
+
+// CreateClientLinkCode returns a one-time code for linking a Telegram account to a client.
+// A new code replaces the pending one of the client.
+func (t *Tgbot) CreateClientLinkCode(email string) (*ClientLinkCode, error) {
+	traffic, err := t.inboundService.GetClientTrafficByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if traffic == nil {
+		return nil, common.NewError("Client Not Found For Email:", email)
+	}
+
+	code := make([]byte, 8)
+	for i := range code {
+		index, err := rand.Int(rand.Reader, big.NewInt(int64(len(clientLinkCodeCharset))))
+		if err != nil {
+			return nil, err
+		}
+		code[i] = clientLinkCodeCharset[index.Int64()]
+	}
+	expiresAt := time.Now().Add(clientLinkCodeTTL)
+
+	clientLinkCodesMu.Lock()
+	for pending, link := range clientLinkCodes {
+		if link.email == email || time.Now().After(link.expiresAt) {
+			delete(clientLinkCodes, pending)
+		}
+	}
+	clientLinkCodes[string(code)] = pendingClientLink{email: email, expiresAt: expiresAt}
+	clientLinkCodesMu.Unlock()
+
+	linkCode := &ClientLinkCode{Code: string(code), ExpiresAt: expiresAt.Unix()}
+	if isRunning && botUsername != "" {
+		linkCode.Link = "https://t.me/" + botUsername + "?start=" + linkCode.Code
+	}
+	return linkCode, nil
+}
+
+// linkClientByCode links the Telegram account of a user to the client of a link code and
+// returns the answer to send. The code is used up whether or not linking succeeds.
+func (t *Tgbot) linkClientByCode(tgUserID int64, code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+
+	clientLinkCodesMu.Lock()
+	link, ok := clientLinkCodes[code]
+	delete(clientLinkCodes, code)
+	clientLinkCodesMu.Unlock()
+	if !ok || time.Now().After(link.expiresAt) {
+		return t.I18nBot("tgbot.answers.invalidLinkCode")
+	}
+
+	traffic, err := t.inboundService.GetClientTrafficByEmail(link.email)
+	if err != nil || traffic == nil {
+		logger.Warning("Link Telegram user to client", link.email, "failed:", err)
+		return t.I18nBot("tgbot.answers.errorOperation")
+	}
+	needRestart, err := t.inboundService.SetClientTelegramUserID(traffic.Id, tgUserID)
+	if needRestart {
+		t.xrayService.SetToNeedRestart()
+	}
+	if err != nil {
+		logger.Warning("Link Telegram user to client", link.email, "failed:", err)
+		return t.I18nBot("tgbot.answers.errorOperation")
+	}
+	logger.Infof("Telegram user %d linked to client %s", tgUserID, link.email)
+	return t.I18nBot("tgbot.answers.clientLinked", "Email=="+link.email)
+}
+
+// answerClientCallback answers the buttons of the clients of a user that are not an admin,
+// which pick one of their own clients. It reports whether the callback was one of them.
+func (t *Tgbot) answerClientCallback(callbackQuery *telego.CallbackQuery) bool {
+	decodedQuery, err := t.decodeQuery(callbackQuery.Data)
+	if err != nil {
+		return false
+	}
+	dataArray := strings.Split(decodedQuery, " ")
+	if len(dataArray) != 2 || dataArray[1] == "" {
+		return false
+	}
+	action, email := dataArray[0], dataArray[1]
+	if !slices.Contains([]string{"client_sub_links", "client_individual_links", "client_qr_links"}, action) {
+		return false
+	}
+
+	chatId := callbackQuery.Message.GetChat().ID
+	tgUserID := callbackQuery.From.ID
+	traffics, err := t.inboundService.GetClientTrafficTgBot(tgUserID)
+	if err != nil {
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.answers.errorOperation")+"\r\n"+err.Error())
+		return true
+	}
+	if !slices.ContainsFunc(traffics, func(traffic *xray.ClientTraffic) bool { return traffic.Email == email }) {
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.answers.askToAddUserId", "TgUserID=="+strconv.FormatInt(tgUserID, 10)))
+		return true
+	}
+
+	t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.messages.email", "Email=="+email))
+	switch action {
+	case "client_sub_links":
+		t.sendClientSubLinks(chatId, email)
+	case "client_individual_links":
+		t.sendClientIndividualLinks(chatId, email)
+	case "client_qr_links":
+		t.sendClientQRLinks(chatId, email)
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+	"sync"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+
+	"github.com/mymmrac/telego"
+)
+
+// TgBotWebhookPath is the path of the webhook endpoint of the bot under the base path of the
+// panel. The secret of the webhook is appended to it.
+const TgBotWebhookPath = "tgbot/webhook/"
+
+var (
+	botUpdatesCancel  context.CancelFunc
+	botWebhookHandler telego.WebhookHandler
+	botWebhookSecret  string
+	botWebhookMu      sync.RWMutex
+)
+
+// GetTgBotWebhookSecret returns the secret of the webhook endpoint of the bot, generating and
+// saving it on first use.
+func (s *SettingService) GetTgBotWebhookSecret() (string, error) {
+	secret, err := s.getString("tgBotWebhookSecret")
+	if secret == defaultValueMap["tgBotWebhookSecret"] {
+		err := s.saveSetting("tgBotWebhookSecret", secret)
+		if err != nil {
+			logger.Warning("save tgBotWebhookSecret failed:", err)
+		}
+	}
+	return secret, err
+}
+
+// receiveUpdates starts receiving the updates of the bot, through the webhook endpoint of the
+// panel when webhook mode is enabled and by long polling otherwise.
+func (t *Tgbot) receiveUpdates() (<-chan telego.Update, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	botUpdatesCancel = cancel
+
+	webhookEnable, err := t.settingService.GetTgBotWebhookEnable()
+	if err != nil {
+		logger.Warning("Failed to get Telegram bot webhook mode:", err)
+	}
+	if !webhookEnable {
+		// Telegram refuses getUpdates while a webhook, e.g. one left over from webhook mode, is set
+		if err := bot.DeleteWebhook(ctx, &telego.DeleteWebhookParams{}); err != nil {
+			logger.Warning("Failed to delete Telegram bot webhook:", err)
+		}
+		return bot.UpdatesViaLongPolling(ctx, &telego.GetUpdatesParams{
+			Timeout: 30, // Increased timeout to reduce API calls
+		})
+	}
+
+	webhookUrl, err := t.settingService.GetTgBotWebhookUrl()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := t.settingService.GetTgBotWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+	if webhookUrl == "" {
+		return nil, common.NewError("Telegram bot webhook URL is not configured")
+	}
+	params := &telego.SetWebhookParams{
+		URL:         strings.TrimSuffix(webhookUrl, "/") + "/" + TgBotWebhookPath + secret,
+		SecretToken: secret,
+	}
+	return bot.UpdatesViaWebhook(ctx, func(handler telego.WebhookHandler) error {
+		botWebhookMu.Lock()
+		botWebhookHandler = handler
+		botWebhookSecret = secret
+		botWebhookMu.Unlock()
+		return nil
+	}, telego.WithWebhookSet(ctx, params))
+}
+
+// stopReceivingUpdates stops receiving the updates of the bot and removes its webhook, so that
+// Telegram holds the updates back until the bot is started again. It must be called before the
+// bot handler is stopped, which would otherwise leave pending webhook requests blocked.
+func (t *Tgbot) stopReceivingUpdates() {
+	botWebhookMu.Lock()
+	webhook := botWebhookHandler != nil
+	botWebhookHandler = nil
+	botWebhookSecret = ""
+	botWebhookMu.Unlock()
+
+	if webhook && bot != nil {
+		if err := bot.DeleteWebhook(context.Background(), &telego.DeleteWebhookParams{}); err != nil {
+			logger.Warning("Failed to delete Telegram bot webhook:", err)
+		}
+	}
+	if botUpdatesCancel != nil {
+		botUpdatesCancel()
+		botUpdatesCancel = nil
+	}
+}
+
+// HandleWebhook passes an update posted to the webhook endpoint to the bot. It reports false when
+// the bot is not in webhook mode or the secret of the path or of the secret token header is wrong.
+func (t *Tgbot) HandleWebhook(ctx context.Context, pathSecret string, headerSecret string, data []byte) (bool, error) {
+	// The lock is held until the update is passed on, so that the updates channel is not
+	// closed by stopReceivingUpdates meanwhile
+	botWebhookMu.RLock()
+	defer botWebhookMu.RUnlock()
+	if botWebhookHandler == nil ||
+		subtle.ConstantTimeCompare([]byte(pathSecret), []byte(botWebhookSecret)) != 1 ||
+		subtle.ConstantTimeCompare([]byte(headerSecret), []byte(botWebhookSecret)) != 1 {
+		return false, nil
+	}
+	// The update outlives the request, so it must not be canceled with it
+	return true, botWebhookHandler(context.WithoutCancel(ctx), data)
+}
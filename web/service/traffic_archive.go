@@ -0,0 +1,289 @@
+package service
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// trafficArchiveSuffix is the file name suffix of the traffic archives.
+	trafficArchiveSuffix = ".csv.gz"
+	// trafficArchiveBatch is how many archived rows are deleted per statement.
+	trafficArchiveBatch = 500
+)
+
+// TrafficArchiveService moves aged traffic history out of the database into gzip-compressed CSV
+// files, which are kept on disk or uploaded to an S3-compatible bucket, so the database stays
+// small while the history is preserved.
+type TrafficArchiveService struct {
+	settingService SettingService
+}
+
+// archiveFolder returns the folder the traffic archives are written to.
+func (s *TrafficArchiveService) archiveFolder() string {
+	folder, err := s.settingService.GetTrafficArchiveFolder()
+	if err != nil || folder == "" {
+		return filepath.Join(config.GetDBFolderPath(), "archive")
+	}
+	return folder
+}
+
+// Archive moves the daily client traffic and the traffic samples older than the archive age into
+// one archive file per table, then uploads the archive files to the bucket, if one is set.
+func (s *TrafficArchiveService) Archive() error {
+	days, err := s.settingService.GetTrafficArchiveDays()
+	if err != nil || days <= 0 {
+		return err
+	}
+	loc, err := s.settingService.GetTimeLocation()
+	if err != nil {
+		loc = time.Local
+	}
+	folder := s.archiveFolder()
+	if err := os.MkdirAll(folder, 0o750); err != nil {
+		return err
+	}
+
+	now := time.Now().In(loc)
+	cutoff := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, -days)
+	stamp := now.UTC().Format("20060102T150405Z")
+	dailyPath := filepath.Join(folder, "client_traffic_daily-"+stamp+trafficArchiveSuffix)
+	samplesPath := filepath.Join(folder, "traffic_samples-"+stamp+trafficArchiveSuffix)
+
+	var dailyCount, samplesCount int
+	var kept []string
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var err error
+		dailyCount, err = archiveRows(tx, dailyPath,
+			"day < ?", cutoff.Format(clientDailyTrafficLayout),
+			[]string{"email", "day", "up", "down"},
+			func(traffic *model.ClientTrafficDaily) (int, []string) {
+				return traffic.Id, []string{
+					traffic.Email,
+					traffic.Day,
+					strconv.FormatInt(traffic.Up, 10),
+					strconv.FormatInt(traffic.Down, 10),
+				}
+			})
+		if err != nil {
+			return err
+		}
+		samplesCount, err = archiveRows(tx, samplesPath,
+			"time < ?", cutoff.UnixMilli(),
+			[]string{"kind", "name", "resolution", "time", "up", "down"},
+			func(sample *model.TrafficSample) (int, []string) {
+				return sample.Id, []string{
+					sample.Kind,
+					sample.Name,
+					sample.Resolution,
+					time.UnixMilli(sample.Time).In(loc).Format(time.RFC3339),
+					strconv.FormatInt(sample.Up, 10),
+					strconv.FormatInt(sample.Down, 10),
+				}
+			})
+		if err != nil {
+			return err
+		}
+		// The archives are put in place before the deletes commit, so no rows are deleted
+		// without an archive holding them
+		for _, archive := range []struct {
+			path  string
+			count int
+		}{{dailyPath, dailyCount}, {samplesPath, samplesCount}} {
+			if archive.count == 0 {
+				continue
+			}
+			if err := os.Rename(archive.path+".tmp", archive.path); err != nil {
+				return err
+			}
+			kept = append(kept, archive.path)
+		}
+		syncFolder(folder)
+		return nil
+	})
+	if err != nil {
+		// The rows are still in the database, so their archives are dropped
+		for _, path := range []string{dailyPath, samplesPath} {
+			os.Remove(path + ".tmp")
+		}
+		for _, path := range kept {
+			os.Remove(path)
+		}
+		return err
+	}
+	if dailyCount+samplesCount > 0 {
+		logger.Infof("Archived %d daily client traffic rows and %d traffic samples older than %s to %s",
+			dailyCount, samplesCount, cutoff.Format(clientDailyTrafficLayout), folder)
+	}
+	return s.uploadArchives(folder)
+}
+
+// archiveRows writes the rows of T matching the condition to a gzip-compressed CSV file at path
+// with a .tmp suffix and deletes them in tx, returning how many there were. record returns the ID
+// and the CSV fields of a row. No file is left behind when no row matches.
+func archiveRows[T any](tx *gorm.DB, path string, query string, arg any, header []string, record func(*T) (int, []string)) (count int, err error) {
+	rows, err := tx.Model(new(T)).Where(query, arg).Order("id").Rows()
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	file, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		file.Close()
+		if err != nil || count == 0 {
+			os.Remove(path + ".tmp")
+		}
+	}()
+	gz := gzip.NewWriter(file)
+	writer := csv.NewWriter(gz)
+	if err := writer.Write(header); err != nil {
+		return 0, err
+	}
+
+	var ids []int
+	for rows.Next() {
+		var row T
+		if err := tx.ScanRows(rows, &row); err != nil {
+			return 0, err
+		}
+		id, fields := record(&row)
+		if err := writer.Write(fields); err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return 0, err
+	}
+	if err := gz.Close(); err != nil {
+		return 0, err
+	}
+	if err := file.Sync(); err != nil {
+		return 0, err
+	}
+	for start := 0; start < len(ids); start += trafficArchiveBatch {
+		batch := ids[start:min(start+trafficArchiveBatch, len(ids))]
+		if err := tx.Where("id IN ?", batch).Delete(new(T)).Error; err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
+
+// syncFolder flushes the entries of a folder to disk, so files renamed in it survive a crash.
+// It is best effort, as not every platform can sync a folder.
+func syncFolder(folder string) {
+	dir, err := os.Open(folder)
+	if err != nil {
+		return
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		logger.Debugf("Unable to sync folder %s: %v", folder, err)
+	}
+}
+
+// uploadArchives uploads the archive files in folder to the bucket and deletes the uploaded ones,
+// if a bucket is set. Files that fail to upload stay on disk for the next run.
+func (s *TrafficArchiveService) uploadArchives(folder string) error {
+	bucketUrl, err := s.settingService.GetTrafficArchiveS3Url()
+	if err != nil || bucketUrl == "" {
+		return err
+	}
+	region, err := s.settingService.GetTrafficArchiveS3Region()
+	if err != nil {
+		return err
+	}
+	accessKey, err := s.settingService.GetTrafficArchiveS3AccessKey()
+	if err != nil {
+		return err
+	}
+	secretKey, err := s.settingService.GetTrafficArchiveS3SecretKey()
+	if err != nil {
+		return err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(folder, "*"+trafficArchiveSuffix))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		target := strings.TrimSuffix(bucketUrl, "/") + "/" + filepath.Base(path)
+		if err := uploadArchive(path, target, region, accessKey, secretKey); err != nil {
+			return common.NewErrorf("upload of %s failed: %v", filepath.Base(path), err)
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		logger.Infof("Traffic archive %s uploaded to %s", filepath.Base(path), target)
+	}
+	return nil
+}
+
+// uploadArchive puts the file at path to the object URL target, signed for S3.
+func uploadArchive(path string, target string, region string, accessKey string, secretKey string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, file)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/gzip")
+	signAWSRequest(req, hex.EncodeToString(hash.Sum(nil)), region, "s3", accessKey, secretKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		return common.NewErrorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
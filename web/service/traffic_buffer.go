@@ -0,0 +1,126 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// trafficBuffer holds the traffic collected from the core and not written to the database yet,
+// summed by inbound tag, outbound tag and client email.
+var trafficBuffer struct {
+	sync.Mutex
+	inbounds  map[string]*xray.Traffic
+	outbounds map[string]*xray.Traffic
+	clients   map[string]*xray.ClientTraffic
+	flushedAt time.Time
+}
+
+// BufferTraffic adds the traffic collected from the core to the buffer and updates the online
+// clients. The buffer is written to the database by FlushTraffic.
+func (s *InboundService) BufferTraffic(traffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) {
+	var onlineClients []string
+	for _, traffic := range clientTraffics {
+		if traffic.Up+traffic.Down > 0 {
+			onlineClients = append(onlineClients, traffic.Email)
+		}
+	}
+	if p != nil {
+		p.SetOnlineClients(onlineClients)
+	}
+
+	trafficBuffer.Lock()
+	defer trafficBuffer.Unlock()
+	mergeTraffic(traffics, clientTraffics)
+}
+
+// mergeTraffic sums traffic into the buffer, which must be locked.
+func mergeTraffic(traffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) {
+	if trafficBuffer.inbounds == nil {
+		trafficBuffer.inbounds = make(map[string]*xray.Traffic)
+		trafficBuffer.outbounds = make(map[string]*xray.Traffic)
+		trafficBuffer.clients = make(map[string]*xray.ClientTraffic)
+	}
+	for _, traffic := range traffics {
+		buffered := trafficBuffer.inbounds
+		if traffic.IsOutbound {
+			buffered = trafficBuffer.outbounds
+		} else if !traffic.IsInbound {
+			continue
+		}
+		if sum, ok := buffered[traffic.Tag]; ok {
+			sum.Up += traffic.Up
+			sum.Down += traffic.Down
+		} else {
+			copied := *traffic
+			buffered[traffic.Tag] = &copied
+		}
+	}
+	for _, traffic := range clientTraffics {
+		if sum, ok := trafficBuffer.clients[traffic.Email]; ok {
+			sum.Up += traffic.Up
+			sum.Down += traffic.Down
+		} else {
+			trafficBuffer.clients[traffic.Email] = &xray.ClientTraffic{Email: traffic.Email, Up: traffic.Up, Down: traffic.Down}
+		}
+	}
+}
+
+// FlushTraffic writes the buffered traffic of the inbounds, outbounds and clients to the database
+// in a single transaction and applies the traffic limits, once the traffic flush interval has
+// passed since the last write or at once when forced. It reports whether the core needs a
+// restart. The traffic stays buffered for the next write when the transaction fails.
+func (s *InboundService) FlushTraffic(force bool) (bool, error) {
+	interval, err := s.settingService.GetTrafficFlushInterval()
+	if err != nil || interval < 1 {
+		interval = 30
+	}
+
+	trafficBuffer.Lock()
+	if !force && time.Since(trafficBuffer.flushedAt) < time.Duration(interval)*time.Second {
+		trafficBuffer.Unlock()
+		return false, nil
+	}
+	traffics := make([]*xray.Traffic, 0, len(trafficBuffer.inbounds)+len(trafficBuffer.outbounds))
+	for _, traffic := range trafficBuffer.inbounds {
+		traffics = append(traffics, traffic)
+	}
+	for _, traffic := range trafficBuffer.outbounds {
+		traffics = append(traffics, traffic)
+	}
+	clientTraffics := make([]*xray.ClientTraffic, 0, len(trafficBuffer.clients))
+	for _, traffic := range trafficBuffer.clients {
+		clientTraffics = append(clientTraffics, traffic)
+	}
+	trafficBuffer.inbounds = nil
+	trafficBuffer.outbounds = nil
+	trafficBuffer.clients = nil
+	trafficBuffer.flushedAt = time.Now()
+	trafficBuffer.Unlock()
+
+	db := database.GetDB()
+	tx := db.Begin()
+	err = s.addInboundTraffic(tx, traffics)
+	if err == nil {
+		err = s.outboundService.addOutboundTraffic(tx, traffics)
+	}
+	if err == nil && len(clientTraffics) > 0 {
+		_, err = s.addClientTrafficCounters(tx, clientTraffics)
+	}
+	needRestart := false
+	if err == nil {
+		needRestart = s.applyTrafficLimits(tx)
+		err = tx.Commit().Error
+	} else {
+		tx.Rollback()
+	}
+	if err != nil {
+		trafficBuffer.Lock()
+		mergeTraffic(traffics, clientTraffics)
+		trafficBuffer.Unlock()
+		return false, err
+	}
+	return needRestart, nil
+}
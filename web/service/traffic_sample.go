@@ -0,0 +1,159 @@
+package service
+
+import (
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+
+	"gorm.io/gorm"
+)
+
+// Resolutions of the traffic samples, from the finest to the coarsest.
+const (
+	TrafficSampleHour  = "hour"
+	TrafficSampleDay   = "day"
+	TrafficSampleMonth = "month"
+)
+
+// TrafficSampleService keeps the traffic time series of inbounds and clients and
+// downsamples them as they age, so long ranges stay cheap to store.
+type TrafficSampleService struct {
+	settingService SettingService
+}
+
+// addTrafficSample adds traffic to a bucket of the time series of an inbound or a client.
+func addTrafficSample(tx *gorm.DB, kind string, name string, resolution string, bucket int64, up int64, down int64) error {
+	result := tx.Model(model.TrafficSample{}).
+		Where("kind = ? AND name = ? AND resolution = ? AND time = ?", kind, name, resolution, bucket).
+		Updates(map[string]any{
+			"up":   gorm.Expr("up + ?", up),
+			"down": gorm.Expr("down + ?", down),
+		})
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.Error
+	}
+	return tx.Create(&model.TrafficSample{
+		Kind:       kind,
+		Name:       name,
+		Resolution: resolution,
+		Time:       bucket,
+		Up:         up,
+		Down:       down,
+	}).Error
+}
+
+// currentTrafficHour returns the start of the current hour in the panel time zone, in Unix milliseconds.
+func currentTrafficHour(settingService *SettingService) int64 {
+	loc, err := settingService.GetTimeLocation()
+	if err != nil {
+		loc = time.Local
+	}
+	now := time.Now().In(loc)
+	return time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, loc).UnixMilli()
+}
+
+// GetTrafficSamples returns the samples of an inbound tag or a client email between from and to,
+// in Unix milliseconds and both inclusive, ordered by time. A zero bound leaves the range open.
+// Recent samples are hourly and older ones daily or monthly, depending on the retention settings.
+func (s *TrafficSampleService) GetTrafficSamples(kind string, name string, from int64, to int64) ([]*model.TrafficSample, error) {
+	if kind != "inbound" && kind != "client" {
+		return nil, common.NewError("Traffic sample kind must be inbound or client:", kind)
+	}
+	query := database.GetDB().Model(model.TrafficSample{}).Where("kind = ? AND name = ?", kind, name)
+	if from > 0 {
+		query = query.Where("time >= ?", from)
+	}
+	if to > 0 {
+		query = query.Where("time <= ?", to)
+	}
+	var samples []*model.TrafficSample
+	if err := query.Order("time").Find(&samples).Error; err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// Downsample rolls hourly samples older than the hourly retention up into daily samples, daily
+// samples older than the daily retention up into monthly samples, and deletes monthly samples
+// older than the monthly retention. Only whole days and months are rolled up.
+func (s *TrafficSampleService) Downsample() error {
+	loc, err := s.settingService.GetTimeLocation()
+	if err != nil {
+		loc = time.Local
+	}
+	hourlyDays, err := s.settingService.GetTrafficHourlyRetentionDays()
+	if err != nil {
+		return err
+	}
+	dailyDays, err := s.settingService.GetTrafficDailyRetentionDays()
+	if err != nil {
+		return err
+	}
+	monthlyMonths, err := s.settingService.GetTrafficMonthlyRetentionMonths()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	startOfDay := func(t time.Time) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	}
+	startOfMonth := func(t time.Time) time.Time {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+	}
+
+	dayCutoff := today.AddDate(0, 0, -hourlyDays)
+	if err := s.rollUp(TrafficSampleHour, TrafficSampleDay, dayCutoff, startOfDay, loc); err != nil {
+		return err
+	}
+	monthCutoff := startOfMonth(today.AddDate(0, 0, -dailyDays))
+	if err := s.rollUp(TrafficSampleDay, TrafficSampleMonth, monthCutoff, startOfMonth, loc); err != nil {
+		return err
+	}
+	if monthlyMonths <= 0 {
+		return nil
+	}
+	expired := startOfMonth(today).AddDate(0, -monthlyMonths, 0)
+	return database.GetDB().
+		Where("resolution = ? AND time < ?", TrafficSampleMonth, expired.UnixMilli()).
+		Delete(model.TrafficSample{}).Error
+}
+
+// rollUp merges the samples of resolution from that start before cutoff into buckets of resolution to.
+func (s *TrafficSampleService) rollUp(from string, to string, cutoff time.Time, bucketOf func(time.Time) time.Time, loc *time.Location) error {
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var samples []*model.TrafficSample
+		err := tx.Model(model.TrafficSample{}).
+			Where("resolution = ? AND time < ?", from, cutoff.UnixMilli()).
+			Find(&samples).Error
+		if err != nil || len(samples) == 0 {
+			return err
+		}
+
+		type bucketKey struct {
+			kind string
+			name string
+			time int64
+		}
+		buckets := make(map[bucketKey]*model.TrafficSample)
+		for _, sample := range samples {
+			key := bucketKey{sample.Kind, sample.Name, bucketOf(time.UnixMilli(sample.Time).In(loc)).UnixMilli()}
+			bucket, ok := buckets[key]
+			if !ok {
+				bucket = &model.TrafficSample{}
+				buckets[key] = bucket
+			}
+			bucket.Up += sample.Up
+			bucket.Down += sample.Down
+		}
+		for key, bucket := range buckets {
+			if err := addTrafficSample(tx, key.kind, key.name, to, key.time, bucket.Up, bucket.Down); err != nil {
+				return err
+			}
+		}
+		return tx.Where("resolution = ? AND time < ?", from, cutoff.UnixMilli()).Delete(model.TrafficSample{}).Error
+	})
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+)
+
+// GetTrustedProxies returns the reverse proxies in front of the panel and the subscription
+// server. Only their forwarding headers are trusted for the client IP, scheme, host and path
+// prefix of the requests.
+func (s *SettingService) GetTrustedProxies() ([]netip.Prefix, error) {
+	value, err := s.getString("trustedProxies")
+	if err != nil {
+		return nil, err
+	}
+	return ParseTrustedProxies(value)
+}
+
+// ParseTrustedProxies parses comma-separated IP addresses and CIDR ranges.
+func ParseTrustedProxies(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if strings.Contains(item, "/") {
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, common.NewError("Trusted proxy is not valid:", item)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, common.NewError("Trusted proxy is not valid:", item)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
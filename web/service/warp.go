@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/logger"
 	"github.com/mhsanaei/3x-ui/v2/util/common"
 )
@@ -69,6 +72,36 @@ func (s *WarpService) GetWarpConfig() (string, error) {
 }
 
 func (s *WarpService) RegWarp(secretKey string, publicKey string) (string, error) {
+	registration, err := s.registerWarpDevice(publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	deviceId := registration["id"].(string)
+	token := registration["token"].(string)
+	license, ok := registration["account"].(map[string]any)["license"].(string)
+	if !ok {
+		logger.Debug("Error accessing license value.")
+		return "", err
+	}
+
+	warpData := fmt.Sprintf("{\n  \"access_token\": \"%s\",\n  \"device_id\": \"%s\",", token, deviceId)
+	warpData += fmt.Sprintf("\n  \"license_key\": \"%s\",\n  \"private_key\": \"%s\"\n}", license, secretKey)
+
+	s.SettingService.SetWarp(warpData)
+
+	config, err := json.Marshal(registration)
+	if err != nil {
+		return "", err
+	}
+	result := fmt.Sprintf("{\n  \"data\": %s,\n  \"config\": %s\n}", warpData, config)
+
+	return result, nil
+}
+
+// registerWarpDevice registers a new WARP device for the WireGuard public key
+// and returns the registration, which holds the device credentials and its WireGuard config.
+func (s *WarpService) registerWarpDevice(publicKey string) (map[string]any, error) {
 	tos := time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
 	hostName, _ := os.Hostname()
 	data := fmt.Sprintf(`{"key":"%s","tos":"%s","type": "PC","model": "x-ui", "name": "%s"}`, publicKey, tos, hostName)
@@ -77,7 +110,7 @@ func (s *WarpService) RegWarp(secretKey string, publicKey string) (string, error
 
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer([]byte(data)))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	req.Header.Add("CF-Client-Version", "a-7.21-0721")
@@ -86,37 +119,27 @@ func (s *WarpService) RegWarp(secretKey string, publicKey string) (string, error
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 	buffer := &bytes.Buffer{}
 	_, err = buffer.ReadFrom(resp.Body)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	var rspData map[string]any
 	err = json.Unmarshal(buffer.Bytes(), &rspData)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-
-	deviceId := rspData["id"].(string)
-	token := rspData["token"].(string)
-	license, ok := rspData["account"].(map[string]any)["license"].(string)
-	if !ok {
-		logger.Debug("Error accessing license value.")
-		return "", err
+	if _, ok := rspData["id"].(string); !ok {
+		return nil, common.NewError("WARP registration failed:", buffer.String())
 	}
-
-	warpData := fmt.Sprintf("{\n  \"access_token\": \"%s\",\n  \"device_id\": \"%s\",", token, deviceId)
-	warpData += fmt.Sprintf("\n  \"license_key\": \"%s\",\n  \"private_key\": \"%s\"\n}", license, secretKey)
-
-	s.SettingService.SetWarp(warpData)
-
-	result := fmt.Sprintf("{\n  \"data\": %s,\n  \"config\": %s\n}", warpData, buffer.String())
-
-	return result, nil
+	if _, ok := rspData["token"].(string); !ok {
+		return nil, common.NewError("WARP registration failed:", buffer.String())
+	}
+	return rspData, nil
 }
 
 func (s *WarpService) SetWarpLicense(license string) (string, error) {
@@ -130,44 +153,166 @@ func (s *WarpService) SetWarpLicense(license string) (string, error) {
 		return "", err
 	}
 
-	url := fmt.Sprintf("https://api.cloudflareclient.com/v0a2158/reg/%s/account", warpData["device_id"])
-	data := fmt.Sprintf(`{"license": "%s"}`, license)
+	if err := s.bindWarpLicense(warpData["device_id"], warpData["access_token"], license); err != nil {
+		return "", err
+	}
 
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer([]byte(data)))
+	warpData["license_key"] = license
+	newWarpData, err := json.MarshalIndent(warpData, "", "  ")
 	if err != nil {
 		return "", err
 	}
-	req.Header.Set("Authorization", "Bearer "+warpData["access_token"])
+	s.SettingService.SetWarp(string(newWarpData))
 
-	client := &http.Client{}
+	return string(newWarpData), nil
+}
+
+// warpDeviceRequest sends an authenticated request for a registered WARP device and returns the decoded response.
+func (s *WarpService) warpDeviceRequest(method string, path string, token string, body []byte) (map[string]any, error) {
+	url := "https://api.cloudflareclient.com/v0a2158/reg/" + path
+	req, err := http.NewRequest(method, url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Add("CF-Client-Version", "a-7.21-0721")
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 	buffer := &bytes.Buffer{}
 	_, err = buffer.ReadFrom(resp.Body)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	var response map[string]any
-	err = json.Unmarshal(buffer.Bytes(), &response)
-	if err != nil {
-		return "", err
+	response := map[string]any{}
+	if buffer.Len() > 0 {
+		if err := json.Unmarshal(buffer.Bytes(), &response); err != nil {
+			return nil, err
+		}
 	}
 	if response["success"] == false {
-		errorArr, _ := response["errors"].([]any)
-		errorObj := errorArr[0].(map[string]any)
-		return "", common.NewError(errorObj["code"], errorObj["message"])
+		if errorArr, _ := response["errors"].([]any); len(errorArr) > 0 {
+			if errorObj, ok := errorArr[0].(map[string]any); ok {
+				return nil, common.NewError(errorObj["code"], errorObj["message"])
+			}
+		}
+		return nil, common.NewError("WARP request failed:", buffer.String())
 	}
+	if resp.StatusCode >= 300 {
+		return nil, common.NewErrorf("WARP request failed: %s", resp.Status)
+	}
+	return response, nil
+}
 
-	warpData["license_key"] = license
-	newWarpData, err := json.MarshalIndent(warpData, "", "  ")
+// bindWarpLicense binds a WARP+ license key to a registered device.
+func (s *WarpService) bindWarpLicense(deviceId string, token string, license string) error {
+	data, err := json.Marshal(map[string]string{"license": license})
 	if err != nil {
-		return "", err
+		return err
 	}
-	s.SettingService.SetWarp(string(newWarpData))
+	_, err = s.warpDeviceRequest("PUT", deviceId+"/account", token, data)
+	return err
+}
 
-	return string(newWarpData), nil
+// getWarpAccount returns the account of a registered device, with its type and WARP+ data.
+func (s *WarpService) getWarpAccount(deviceId string, token string) (map[string]any, error) {
+	return s.warpDeviceRequest("GET", deviceId+"/account", token, nil)
+}
+
+// deleteWarpDevice removes a registered device from Cloudflare.
+func (s *WarpService) deleteWarpDevice(deviceId string, token string) error {
+	_, err := s.warpDeviceRequest("DELETE", deviceId, token, nil)
+	return err
+}
+
+// GetWarpAccount refreshes the WARP account of a panel-managed outbound from Cloudflare and returns it.
+func (s *WarpService) GetWarpAccount(outboundId int) (*model.WarpAccount, error) {
+	account, err := s.getStoredWarpAccount(outboundId)
+	if err != nil {
+		return nil, err
+	}
+	accountData, err := s.getWarpAccount(account.DeviceId, account.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	setWarpAccountData(account, accountData)
+	if err := database.GetDB().Save(account).Error; err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// SetWarpAccountLicense binds a WARP+ license key to the WARP account of a panel-managed outbound.
+func (s *WarpService) SetWarpAccountLicense(outboundId int, license string) (*model.WarpAccount, error) {
+	license = strings.TrimSpace(license)
+	if license == "" {
+		return nil, common.NewError("WARP license key is empty")
+	}
+	account, err := s.getStoredWarpAccount(outboundId)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.bindWarpLicense(account.DeviceId, account.AccessToken, license); err != nil {
+		return nil, err
+	}
+	account.License = license
+	if err := database.GetDB().Save(account).Error; err != nil {
+		return nil, err
+	}
+	return s.GetWarpAccount(outboundId)
+}
+
+// getStoredWarpAccount returns the stored WARP account of a panel-managed outbound.
+func (s *WarpService) getStoredWarpAccount(outboundId int) (*model.WarpAccount, error) {
+	account := &model.WarpAccount{}
+	err := database.GetDB().Model(model.WarpAccount{}).Where("outbound_id = ?", outboundId).First(account).Error
+	if err != nil {
+		if database.IsNotFound(err) {
+			return nil, common.NewError("Outbound has no WARP account:", outboundId)
+		}
+		return nil, err
+	}
+	return account, nil
+}
+
+// delWarpAccount removes the WARP device of a panel-managed outbound from Cloudflare and deletes its account.
+// A device that Cloudflare fails to remove only logs a warning so the outbound can still be deleted.
+func (s *WarpService) delWarpAccount(outboundId int) error {
+	account := &model.WarpAccount{}
+	err := database.GetDB().Model(model.WarpAccount{}).Where("outbound_id = ?", outboundId).First(account).Error
+	if database.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.deleteWarpDevice(account.DeviceId, account.AccessToken); err != nil {
+		logger.Warning("Failed to remove WARP device", account.DeviceId, ":", err)
+	}
+	return database.GetDB().Delete(account).Error
+}
+
+// setWarpAccountData copies the license, type and WARP+ data of a Cloudflare account response into account.
+func setWarpAccountData(account *model.WarpAccount, accountData map[string]any) {
+	if license, ok := accountData["license"].(string); ok && license != "" {
+		account.License = license
+	}
+	if accountType, ok := accountData["account_type"].(string); ok {
+		account.AccountType = accountType
+	}
+	if premiumData, ok := accountData["premium_data"].(float64); ok {
+		account.PremiumData = int64(premiumData)
+	}
+	if quota, ok := accountData["quota"].(float64); ok {
+		account.Quota = int64(quota)
+	}
+	account.UpdatedAt = time.Now().UnixMilli()
 }
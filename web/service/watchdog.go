@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/util/systemd"
+)
+
+// WatchdogService notifies the watchdog of systemd while the panel is alive: its database
+// answers and the management of the core is not stuck. When the panel wedges, systemd misses the
+// notifications and restarts it instead of leaving it half-alive.
+type WatchdogService struct {
+	xrayService XrayService
+}
+
+// Run checks the health of the panel twice per watchdog interval and notifies systemd when it
+// is alive. It runs as long as the panel.
+func (s *WatchdogService) Run(interval time.Duration) {
+	timeout := min(interval/4, 10*time.Second)
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := s.CheckHealth(timeout); err != nil {
+			logger.Warning("Watchdog: the panel is not healthy:", err)
+			continue
+		}
+		if err := systemd.Notify(systemd.Watchdog); err != nil {
+			logger.Debug("Watchdog: notifying systemd failed:", err)
+		}
+	}
+}
+
+// CheckHealth returns an error when the database does not answer or the core cannot be managed
+// within the timeout.
+func (s *WatchdogService) CheckHealth(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if db := database.GetDB(); db != nil {
+		if err := db.WithContext(ctx).Exec("SELECT 1").Error; err != nil {
+			return common.NewError("database does not answer:", err)
+		}
+	}
+	if !s.xrayService.IsCoreResponsive(timeout) {
+		return common.NewError("core management is stuck for", timeout)
+	}
+	return nil
+}
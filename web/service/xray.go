@@ -5,15 +5,20 @@ import (
 	"errors"
 	"runtime"
 	"sync"
+	"time"
 
+	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/singbox"
+	"github.com/mhsanaei/3x-ui/v2/util/json_util"
+	"github.com/mhsanaei/3x-ui/v2/web/extension"
 	"github.com/mhsanaei/3x-ui/v2/xray"
 
 	"go.uber.org/atomic"
 )
 
 var (
-	p                 *xray.Process
+	p                 coreProcess
 	lock              sync.Mutex
 	isNeedXrayRestart atomic.Bool // Indicates that restart was requested for Xray
 	isManuallyStopped atomic.Bool // Indicates that Xray was stopped manually from the panel
@@ -23,9 +28,15 @@ var (
 // XrayService provides business logic for Xray process management.
 // It handles starting, stopping, restarting Xray, and managing its configuration.
 type XrayService struct {
-	inboundService InboundService
-	settingService SettingService
-	xrayAPI        xray.XrayAPI
+	inboundService   InboundService
+	settingService   SettingService
+	routingService   RoutingService
+	outboundService  OutboundService
+	balancerService  BalancerService
+	dnsService       DnsService
+	reverseService   ReverseService
+	extensionService ExtensionService
+	xrayAPI          xray.XrayAPI
 }
 
 // IsXrayRunning checks if the Xray process is currently running.
@@ -33,6 +44,21 @@ func (s *XrayService) IsXrayRunning() bool {
 	return p != nil && p.IsRunning()
 }
 
+// IsCoreResponsive reports whether the core can be managed, i.e. the lock held while starting or
+// stopping it is free within the timeout, so that an operation hanging on the core is detected.
+func (s *XrayService) IsCoreResponsive(timeout time.Duration) bool {
+	for deadline := time.Now().Add(timeout); ; {
+		if lock.TryLock() {
+			lock.Unlock()
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
 // GetXrayErr returns the error from the Xray process, if any.
 func (s *XrayService) GetXrayErr() error {
 	if p == nil {
@@ -100,104 +126,240 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 		return nil, err
 	}
 
-	s.inboundService.AddTraffic(nil, nil)
+	if outboundStats, err := s.settingService.GetOutboundTrafficStats(); err == nil && outboundStats {
+		xrayConfig.Policy, err = enableOutboundStats(xrayConfig.Policy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	xrayConfig.Policy, err = enableUserOnlineStats(xrayConfig.Policy)
+	if err != nil {
+		return nil, err
+	}
+
+	s.inboundService.FlushTraffic(true)
 
 	inbounds, err := s.inboundService.GetAllInbounds()
 	if err != nil {
 		return nil, err
 	}
+	var enabledInbounds, pinnedInbounds []*model.Inbound
 	for _, inbound := range inbounds {
-		if !inbound.Enable {
+		if !inbound.Enable || inbound.Protocol.IsSingboxOnly() {
 			continue
 		}
-		// get settings clients
-		settings := map[string]any{}
-		json.Unmarshal([]byte(inbound.Settings), &settings)
-		clients, ok := settings["clients"].([]any)
-		if ok {
-			// check users active or not
-			clientStats := inbound.ClientStats
-			for _, clientTraffic := range clientStats {
-				indexDecrease := 0
-				for index, client := range clients {
-					c := client.(map[string]any)
-					if c["email"] == clientTraffic.Email {
-						if !clientTraffic.Enable {
-							clients = RemoveIndex(clients, index-indexDecrease)
-							indexDecrease++
-							logger.Infof("Remove Inbound User %s due to expiration or traffic limit", c["email"])
-						}
-					}
-				}
-			}
+		if inbound.OutboundTag != "" {
+			pinnedInbounds = append(pinnedInbounds, inbound)
+		}
+		enabledInbounds = append(enabledInbounds, inbound)
+	}
+	// Pinned before generating the configs, which splits the REALITY grace inbounds off
+	pins := inboundRoutingPins(pinnedInbounds)
+	inboundConfigs, err := s.genInboundConfigs(enabledInbounds)
+	if err != nil {
+		return nil, err
+	}
+	xrayConfig.InboundConfigs = append(xrayConfig.InboundConfigs, inboundConfigs...)
 
-			// clear client config for additional parameters
-			var final_clients []any
-			for _, client := range clients {
+	outbounds, err := s.outboundService.GetXrayOutbounds()
+	if err != nil {
+		return nil, err
+	}
+	if len(outbounds) > 0 {
+		outboundConfigs, err := appendOutbounds(xrayConfig.OutboundConfigs, outbounds)
+		if err != nil {
+			return nil, err
+		}
+		xrayConfig.OutboundConfigs = outboundConfigs
+	}
+	if err := s.applyFragment(xrayConfig); err != nil {
+		return nil, err
+	}
+
+	reverseRules, err := s.reverseService.addReverses(xrayConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// Inbound pins and reverse proxy glue come first so they are not caught by a managed rule
+	routingRules, err := s.routingService.GetXrayRules()
+	if err != nil {
+		return nil, err
+	}
+	routingRules = append(append(pins, reverseRules...), routingRules...)
+	if len(routingRules) > 0 {
+		routerConfig, err := insertRoutingRules(xrayConfig.RouterConfig, routingRules)
+		if err != nil {
+			return nil, err
+		}
+		xrayConfig.RouterConfig = routerConfig
+	}
+	if err := s.applySpeedLimits(xrayConfig); err != nil {
+		return nil, err
+	}
+	if err := s.balancerService.addBalancers(xrayConfig); err != nil {
+		return nil, err
+	}
+	if err := s.dnsService.applyDns(xrayConfig); err != nil {
+		return nil, err
+	}
+	s.extensionService.RunHooks(extension.ConfigGenerated, xrayConfig)
+	return xrayConfig, nil
+}
+
+// prepareInbound drops the clients that are disabled or over their limits from an inbound
+// and strips the panel-only fields from its settings, leaving what is passed to the core.
+func (s *XrayService) prepareInbound(inbound *model.Inbound) error {
+	// get settings clients
+	settings := map[string]any{}
+	json.Unmarshal([]byte(inbound.Settings), &settings)
+	clients, ok := settings["clients"].([]any)
+	if ok {
+		// check users active or not
+		clientStats := inbound.ClientStats
+		for _, clientTraffic := range clientStats {
+			indexDecrease := 0
+			for index, client := range clients {
 				c := client.(map[string]any)
-				if c["enable"] != nil {
-					if enable, ok := c["enable"].(bool); ok && !enable {
-						continue
-					}
-				}
-				for key := range c {
-					if key != "email" && key != "id" && key != "password" && key != "flow" && key != "method" {
-						delete(c, key)
-					}
-					if c["flow"] == "xtls-rprx-vision-udp443" {
-						c["flow"] = "xtls-rprx-vision"
+				if c["email"] == clientTraffic.Email {
+					if !clientTraffic.Enable {
+						clients = RemoveIndex(clients, index-indexDecrease)
+						indexDecrease++
+						logger.Infof("Remove Inbound User %s due to expiration or traffic limit", c["email"])
 					}
 				}
-				final_clients = append(final_clients, any(c))
 			}
+		}
 
-			settings["clients"] = final_clients
-			modifiedSettings, err := json.MarshalIndent(settings, "", "  ")
-			if err != nil {
-				return nil, err
+		// clear client config for additional parameters
+		var final_clients []any
+		for _, client := range clients {
+			c := client.(map[string]any)
+			if c["enable"] != nil {
+				if enable, ok := c["enable"].(bool); ok && !enable {
+					continue
+				}
+			}
+			for key := range c {
+				if key != "email" && key != "id" && key != "password" && key != "flow" && key != "method" {
+					delete(c, key)
+				}
+				if c["flow"] == "xtls-rprx-vision-udp443" {
+					c["flow"] = "xtls-rprx-vision"
+				}
 			}
+			final_clients = append(final_clients, any(c))
+		}
 
-			inbound.Settings = string(modifiedSettings)
+		settings["clients"] = final_clients
+		modifiedSettings, err := json.MarshalIndent(settings, "", "  ")
+		if err != nil {
+			return err
 		}
 
-		if len(inbound.StreamSettings) > 0 {
-			// Unmarshal stream JSON
-			var stream map[string]any
-			json.Unmarshal([]byte(inbound.StreamSettings), &stream)
-
-			// Remove the "settings" field under "tlsSettings" and "realitySettings"
-			tlsSettings, ok1 := stream["tlsSettings"].(map[string]any)
-			realitySettings, ok2 := stream["realitySettings"].(map[string]any)
-			if ok1 || ok2 {
-				if ok1 {
-					delete(tlsSettings, "settings")
-				} else if ok2 {
-					delete(realitySettings, "settings")
-				}
+		inbound.Settings = string(modifiedSettings)
+	}
+
+	if len(inbound.StreamSettings) > 0 {
+		// Unmarshal stream JSON
+		var stream map[string]any
+		json.Unmarshal([]byte(inbound.StreamSettings), &stream)
+
+		// Remove the "settings" field under "tlsSettings" and "realitySettings"
+		tlsSettings, ok1 := stream["tlsSettings"].(map[string]any)
+		realitySettings, ok2 := stream["realitySettings"].(map[string]any)
+		if ok1 || ok2 {
+			if ok1 {
+				delete(tlsSettings, "settings")
+			} else if ok2 {
+				delete(realitySettings, "settings")
 			}
+		}
 
-			delete(stream, "externalProxy")
+		delete(stream, "externalProxy")
 
-			newStream, err := json.MarshalIndent(stream, "", "  ")
-			if err != nil {
-				return nil, err
-			}
-			inbound.StreamSettings = string(newStream)
+		newStream, err := json.MarshalIndent(stream, "", "  ")
+		if err != nil {
+			return err
 		}
+		inbound.StreamSettings = string(newStream)
+	}
+	return nil
+}
 
-		inboundConfig := inbound.GenXrayInboundConfig()
-		xrayConfig.InboundConfigs = append(xrayConfig.InboundConfigs, *inboundConfig)
+// inboundRoutingPins returns a routing rule for every inbound bound to an outbound.
+func inboundRoutingPins(inbounds []*model.Inbound) []any {
+	pins := make([]any, 0, len(inbounds))
+	for _, inbound := range inbounds {
+		tags := []string{inbound.Tag}
+		if inbound.GetRealityPreviousKeys() != nil {
+			tags = append(tags, xray.RealityGraceTag(inbound.Tag))
+		}
+		pins = append(pins, map[string]any{
+			"type":        "field",
+			"inboundTag":  tags,
+			"outboundTag": inbound.OutboundTag,
+		})
 	}
-	return xrayConfig, nil
+	return pins
+}
+
+// appendOutbounds adds outbounds managed by the panel after the outbounds of the template,
+// so the first outbound of the template stays the default one.
+func appendOutbounds(outboundConfigs json_util.RawMessage, added []any) (json_util.RawMessage, error) {
+	var outbounds []any
+	if len(outboundConfigs) > 0 {
+		if err := json.Unmarshal(outboundConfigs, &outbounds); err != nil {
+			return nil, err
+		}
+	}
+	outbounds = append(outbounds, added...)
+	return json.MarshalIndent(outbounds, "", "  ")
+}
+
+// insertRoutingRules adds rules generated by the panel to the routing section of the template.
+// The rules are placed after the leading API and block rules of the template,
+// so blocked destinations stay blocked for them too.
+func insertRoutingRules(routerConfig json_util.RawMessage, added []any) (json_util.RawMessage, error) {
+	routing := map[string]any{}
+	if len(routerConfig) > 0 {
+		if err := json.Unmarshal(routerConfig, &routing); err != nil {
+			return nil, err
+		}
+	}
+	rules, _ := routing["rules"].([]any)
+
+	insertAt := 0
+	for insertAt < len(rules) {
+		rule, _ := rules[insertAt].(map[string]any)
+		outboundTag, _ := rule["outboundTag"].(string)
+		if outboundTag != "api" && outboundTag != "blocked" {
+			break
+		}
+		insertAt++
+	}
+
+	newRules := make([]any, 0, len(rules)+len(added))
+	newRules = append(newRules, rules[:insertAt]...)
+	newRules = append(newRules, added...)
+	newRules = append(newRules, rules[insertAt:]...)
+	routing["rules"] = newRules
+
+	return json.MarshalIndent(routing, "", "  ")
 }
 
-// GetXrayTraffic fetches the current traffic statistics from the running Xray process.
+// GetXrayTraffic fetches the traffic statistics counted since the last call from the running core.
+// The counters of Xray are read and reset through its StatsService API.
 func (s *XrayService) GetXrayTraffic() ([]*xray.Traffic, []*xray.ClientTraffic, error) {
 	if !s.IsXrayRunning() {
 		err := errors.New("xray is not running")
 		logger.Debug("Attempted to fetch Xray traffic, but Xray is not running:", err)
 		return nil, nil, err
 	}
+	if _, ok := p.(*singbox.Process); ok {
+		return s.getSingboxTraffic()
+	}
 	apiPort := p.GetAPIPort()
 	s.xrayAPI.Init(apiPort)
 	defer s.xrayAPI.Close()
@@ -210,6 +372,94 @@ func (s *XrayService) GetXrayTraffic() ([]*xray.Traffic, []*xray.ClientTraffic,
 	return traffic, clientTraffic, nil
 }
 
+// flushTraffic collects the traffic counted since the last run of the traffic job and writes
+// it with the buffered traffic, so the counters of a core that is about to stop are not lost.
+func (s *XrayService) flushTraffic() {
+	if traffics, clientTraffics, err := s.GetXrayTraffic(); err == nil {
+		s.inboundService.BufferTraffic(traffics, clientTraffics)
+	}
+	if _, err := s.inboundService.FlushTraffic(true); err != nil {
+		logger.Warning("add traffic before stopping Xray failed:", err)
+	}
+}
+
+// GetOutboundStatus returns the outbound latencies measured by the observatory of the running Xray.
+func (s *XrayService) GetOutboundStatus() ([]*xray.OutboundStatus, error) {
+	if !s.IsXrayRunning() {
+		return nil, errors.New("xray is not running")
+	}
+	xp, ok := p.(*xray.Process)
+	if !ok {
+		return nil, errors.New("observatory is only available with the Xray core")
+	}
+	if err := s.xrayAPI.Init(xp.GetAPIPort()); err != nil {
+		return nil, err
+	}
+	defer s.xrayAPI.Close()
+	return s.xrayAPI.GetOutboundStatus()
+}
+
+// CoreStatus is the health of the running core as reported by the core itself.
+type CoreStatus struct {
+	Core        string                 `json:"core"` // CoreXray or CoreSingbox
+	Running     bool                   `json:"running"`
+	Version     string                 `json:"version"`
+	Uptime      uint64                 `json:"uptime"` // Seconds since the panel started the process
+	Error       string                 `json:"error"`  // Exit result of a stopped core
+	ApiError    string                 `json:"apiError"`
+	Sys         *xray.SysStats         `json:"sys"`
+	Inbounds    []string               `json:"inbounds"`
+	Outbounds   []string               `json:"outbounds"`
+	Observatory []*xray.OutboundStatus `json:"observatory"`
+}
+
+// GetCoreStatus returns the health of the running core. The runtime stats, the handler list and
+// the observatory results are only queried from Xray; the observatory is left out if no balancer runs it.
+// A failed API query is reported in ApiError, since a running process with an unresponsive API is unhealthy.
+func (s *XrayService) GetCoreStatus() (*CoreStatus, error) {
+	coreType, err := s.settingService.GetCoreType()
+	if err != nil {
+		return nil, err
+	}
+	status := &CoreStatus{
+		Core:    coreType,
+		Running: s.IsXrayRunning(),
+		Version: s.GetXrayVersion(),
+	}
+	if !status.Running {
+		status.Error = s.GetXrayResult()
+		return status, nil
+	}
+	status.Uptime = p.GetUptime()
+	xp, ok := p.(*xray.Process)
+	if !ok {
+		return status, nil
+	}
+
+	if err := s.xrayAPI.Init(xp.GetAPIPort()); err != nil {
+		status.ApiError = err.Error()
+		return status, nil
+	}
+	defer s.xrayAPI.Close()
+	status.Sys, err = s.xrayAPI.GetSysStats()
+	if err != nil {
+		status.ApiError = err.Error()
+		return status, nil
+	}
+	status.Inbounds, status.Outbounds, err = s.xrayAPI.ListHandlers()
+	if err != nil {
+		status.ApiError = err.Error()
+		return status, nil
+	}
+	if hasAPIService(xp.GetConfig().API, "ObservatoryService") {
+		status.Observatory, err = s.xrayAPI.GetOutboundStatus()
+		if err != nil {
+			status.ApiError = err.Error()
+		}
+	}
+	return status, nil
+}
+
 // RestartXray restarts the Xray process, optionally forcing a restart even if config unchanged.
 func (s *XrayService) RestartXray(isForce bool) error {
 	lock.Lock()
@@ -217,25 +467,49 @@ func (s *XrayService) RestartXray(isForce bool) error {
 	logger.Debug("restart Xray, force:", isForce)
 	isManuallyStopped.Store(false)
 
+	coreType, err := s.settingService.GetCoreType()
+	if err != nil {
+		return err
+	}
+	defer s.redirectPortRanges(coreType)
+	if coreType == CoreSingbox {
+		return s.restartSingbox(isForce)
+	}
+
 	xrayConfig, err := s.GetXrayConfig()
 	if err != nil {
 		return err
 	}
+	defer s.shapeSpeedLimits()
 
 	if s.IsXrayRunning() {
-		if !isForce && p.GetConfig().Equals(xrayConfig) && !isNeedXrayRestart.Load() {
-			logger.Debug("It does not need to restart Xray")
-			return nil
+		if xp, ok := p.(*xray.Process); ok && !isForce {
+			if xp.GetConfig().Equals(xrayConfig) {
+				if !isNeedXrayRestart.Load() {
+					logger.Debug("It does not need to restart Xray")
+					return nil
+				}
+			} else {
+				err := s.hotApplyInbounds(xp, xrayConfig)
+				if err == nil {
+					logger.Debug("Xray inbounds updated through the API without restart")
+					return nil
+				}
+				logger.Debug("Unable to apply the changes through the API, restarting Xray:", err)
+			}
 		}
+		s.flushTraffic()
 		p.Stop()
 	}
 
-	p = xray.NewProcess(xrayConfig)
+	xp := xray.NewProcess(xrayConfig)
+	p = xp
 	result = ""
-	err = p.Start()
+	err = xp.Start()
 	if err != nil {
 		return err
 	}
+	coreStarts.Inc()
 
 	return nil
 }
@@ -246,6 +520,7 @@ func (s *XrayService) StopXray() error {
 	defer lock.Unlock()
 	isManuallyStopped.Store(true)
 	logger.Debug("Attempting to stop Xray...")
+	s.flushTraffic()
 	if s.IsXrayRunning() {
 		return p.Stop()
 	}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// fragmentTestTag is the tag of the temporary inbound used to test the fragment settings.
+const fragmentTestTag = "fragment-test"
+
+// FragmentTestResult is the outcome of a request sent through the running Xray after
+// the fragment settings were applied.
+type FragmentTestResult struct {
+	Url        string `json:"url"`
+	StatusCode int    `json:"statusCode"`
+	Delay      int64  `json:"delay"` // Milliseconds until the response headers arrived
+}
+
+// applyFragment sets the TLS fragment options of the panel settings on every freedom outbound,
+// so the ClientHello of direct connections is split into several packets.
+func (s *XrayService) applyFragment(xrayConfig *xray.Config) error {
+	enabled, err := s.settingService.GetFragmentEnable()
+	if err != nil || !enabled || len(xrayConfig.OutboundConfigs) == 0 {
+		return err
+	}
+	packets, err := s.settingService.GetFragmentPackets()
+	if err != nil {
+		return err
+	}
+	length, err := s.settingService.GetFragmentLength()
+	if err != nil {
+		return err
+	}
+	interval, err := s.settingService.GetFragmentInterval()
+	if err != nil {
+		return err
+	}
+
+	var outbounds []map[string]any
+	if err := json.Unmarshal(xrayConfig.OutboundConfigs, &outbounds); err != nil {
+		return err
+	}
+	for _, outbound := range outbounds {
+		if outbound["protocol"] != "freedom" {
+			continue
+		}
+		settings, _ := outbound["settings"].(map[string]any)
+		if settings == nil {
+			settings = map[string]any{}
+			outbound["settings"] = settings
+		}
+		settings["fragment"] = map[string]any{
+			"packets":  packets,
+			"length":   length,
+			"interval": interval,
+		}
+	}
+	xrayConfig.OutboundConfigs, err = json.MarshalIndent(outbounds, "", "  ")
+	return err
+}
+
+// TestFragment restarts Xray with the current settings and requests the observatory probe URL
+// through a temporary local SOCKS inbound. The request follows the routing of the config,
+// so it leaves through the default outbound unless a rule says otherwise.
+func (s *XrayService) TestFragment() (*FragmentTestResult, error) {
+	if err := s.RestartXray(true); err != nil {
+		return nil, err
+	}
+	if !s.IsXrayRunning() {
+		return nil, errors.New("xray is not running")
+	}
+	xp, ok := p.(*xray.Process)
+	if !ok {
+		return nil, errors.New("fragment is only available with the Xray core")
+	}
+	probeUrl, err := s.settingService.GetObservatoryProbeUrl()
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	inbound, err := json.Marshal(map[string]any{
+		"tag":      fragmentTestTag,
+		"listen":   "127.0.0.1",
+		"port":     port,
+		"protocol": "socks",
+		"settings": map[string]any{"auth": "noauth", "udp": false},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.xrayAPI.Init(xp.GetAPIPort()); err != nil {
+		return nil, err
+	}
+	defer s.xrayAPI.Close()
+	if err := s.xrayAPI.AddInbound(inbound); err != nil {
+		return nil, err
+	}
+	defer s.xrayAPI.DelInbound(fragmentTestTag)
+
+	proxyUrl := &url.URL{Scheme: "socks5", Host: net.JoinHostPort("127.0.0.1", strconv.Itoa(port))}
+	client := &http.Client{
+		Timeout:   15 * time.Second,
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyUrl)},
+	}
+	start := time.Now()
+	resp, err := client.Get(probeUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return &FragmentTestResult{
+		Url:        probeUrl,
+		StatusCode: resp.StatusCode,
+		Delay:      time.Since(start).Milliseconds(),
+	}, nil
+}
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// hotApplyInbounds brings the running Xray process to newConfig through the Xray API,
+// so active connections of unchanged inbounds and clients survive. Clients of an inbound are
+// added and removed one by one; any other change of an inbound replaces the whole inbound.
+// An error means the change could not be applied this way and Xray has to be restarted.
+func (s *XrayService) hotApplyInbounds(xp *xray.Process, newConfig *xray.Config) error {
+	oldConfig := xp.GetConfig()
+	if !oldConfig.EqualsExceptInbounds(newConfig) {
+		return common.NewError("Xray configuration changed outside of the inbounds")
+	}
+
+	oldInbounds := make(map[string]*xray.InboundConfig, len(oldConfig.InboundConfigs))
+	for i := range oldConfig.InboundConfigs {
+		oldInbounds[oldConfig.InboundConfigs[i].Tag] = &oldConfig.InboundConfigs[i]
+	}
+	newInbounds := make(map[string]*xray.InboundConfig, len(newConfig.InboundConfigs))
+	for i := range newConfig.InboundConfigs {
+		newInbounds[newConfig.InboundConfigs[i].Tag] = &newConfig.InboundConfigs[i]
+	}
+	if len(oldInbounds) != len(oldConfig.InboundConfigs) || len(newInbounds) != len(newConfig.InboundConfigs) {
+		return common.NewError("Duplicate inbound tags")
+	}
+	if oldApi, newApi := oldInbounds["api"], newInbounds["api"]; oldApi == nil || newApi == nil || !oldApi.Equals(newApi) {
+		return common.NewError("Xray API inbound changed")
+	}
+
+	if err := s.xrayAPI.Init(xp.GetAPIPort()); err != nil {
+		return err
+	}
+	defer s.xrayAPI.Close()
+
+	for tag := range oldInbounds {
+		if _, ok := newInbounds[tag]; ok {
+			continue
+		}
+		if err := s.xrayAPI.DelInbound(tag); err != nil {
+			return err
+		}
+		logger.Debug("Inbound deleted by api:", tag)
+	}
+
+	for tag, inbound := range newInbounds {
+		oldInbound, ok := oldInbounds[tag]
+		if ok && oldInbound.Equals(inbound) {
+			continue
+		}
+		if ok {
+			applied, err := s.hotApplyClients(oldInbound, inbound)
+			if err != nil {
+				return err
+			}
+			if applied {
+				continue
+			}
+			if err := s.xrayAPI.DelInbound(tag); err != nil {
+				return err
+			}
+		}
+		inboundJson, err := json.MarshalIndent(inbound, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := s.xrayAPI.AddInbound(inboundJson); err != nil {
+			return err
+		}
+		logger.Debug("Inbound added by api:", tag)
+	}
+
+	return xp.SetConfig(newConfig)
+}
+
+// hotApplyClients adds and removes the clients of an inbound whose settings changed only in
+// its client list. It returns false if the inbound changed otherwise and has to be replaced.
+func (s *XrayService) hotApplyClients(oldInbound, newInbound *xray.InboundConfig) (bool, error) {
+	switch newInbound.Protocol {
+	case "vmess", "vless", "trojan", "shadowsocks":
+	default:
+		return false, nil
+	}
+	if !equalInboundExceptSettings(oldInbound, newInbound) {
+		return false, nil
+	}
+
+	var oldSettings, newSettings map[string]any
+	if err := json.Unmarshal(oldInbound.Settings, &oldSettings); err != nil {
+		return false, nil
+	}
+	if err := json.Unmarshal(newInbound.Settings, &newSettings); err != nil {
+		return false, nil
+	}
+	oldClients, ok := clientsByEmail(oldSettings)
+	if !ok {
+		return false, nil
+	}
+	newClients, ok := clientsByEmail(newSettings)
+	if !ok {
+		return false, nil
+	}
+	delete(oldSettings, "clients")
+	delete(newSettings, "clients")
+	oldRest, _ := json.Marshal(oldSettings)
+	newRest, _ := json.Marshal(newSettings)
+	if string(oldRest) != string(newRest) {
+		return false, nil
+	}
+
+	for email, oldClient := range oldClients {
+		if newClient, ok := newClients[email]; ok && newClient == oldClient {
+			continue
+		}
+		err := s.xrayAPI.RemoveUser(newInbound.Tag, email)
+		if err != nil && !strings.Contains(err.Error(), fmt.Sprintf("User %s not found.", email)) {
+			return false, err
+		}
+		logger.Debug("Client deleted by api:", email)
+	}
+
+	cipher, _ := newSettings["method"].(string)
+	for email, newClient := range newClients {
+		if oldClient, ok := oldClients[email]; ok && oldClient == newClient {
+			continue
+		}
+		var client map[string]any
+		_ = json.Unmarshal([]byte(newClient), &client)
+		id, _ := client["id"].(string)
+		security, _ := client["security"].(string)
+		flow, _ := client["flow"].(string)
+		password, _ := client["password"].(string)
+		err := s.xrayAPI.AddUser(newInbound.Protocol, newInbound.Tag, map[string]any{
+			"email":    email,
+			"id":       id,
+			"security": security,
+			"flow":     flow,
+			"password": password,
+			"cipher":   cipher,
+		})
+		if err != nil {
+			return false, err
+		}
+		logger.Debug("Client added by api:", email)
+	}
+	return true, nil
+}
+
+// equalInboundExceptSettings compares two inbound configs without their settings.
+func equalInboundExceptSettings(a, b *xray.InboundConfig) bool {
+	aCopy, bCopy := *a, *b
+	aCopy.Settings, bCopy.Settings = nil, nil
+	return aCopy.Equals(&bCopy)
+}
+
+// clientsByEmail returns the clients of inbound settings as JSON keyed by email.
+// It returns false if a client has no email, since clients are managed by email in the API.
+func clientsByEmail(settings map[string]any) (map[string]string, bool) {
+	list, _ := settings["clients"].([]any)
+	clients := make(map[string]string, len(list))
+	for _, item := range list {
+		client, ok := item.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		email, _ := client["email"].(string)
+		if email == "" {
+			return nil, false
+		}
+		if _, ok := clients[email]; ok {
+			return nil, false
+		}
+		data, err := json.Marshal(client)
+		if err != nil {
+			return nil, false
+		}
+		clients[email] = string(data)
+	}
+	return clients, true
+}
+
+// ReloadInbounds replaces inbounds of the running Xray through the API, so that they load their
+// certificate files again. Xray is restarted when that is not possible.
+func (s *XrayService) ReloadInbounds(tags []string) error {
+	lock.Lock()
+	err := s.reloadInbounds(tags)
+	lock.Unlock()
+	if err != nil {
+		logger.Debug("Unable to reload the inbounds through the API, restarting Xray:", err)
+		return s.RestartXray(true)
+	}
+	return nil
+}
+
+func (s *XrayService) reloadInbounds(tags []string) error {
+	if !s.IsXrayRunning() {
+		return nil
+	}
+	xp, ok := p.(*xray.Process)
+	if !ok {
+		return common.NewError("The core has no API to reload inbounds")
+	}
+	if err := s.xrayAPI.Init(xp.GetAPIPort()); err != nil {
+		return err
+	}
+	defer s.xrayAPI.Close()
+
+	for _, inbound := range xp.GetConfig().InboundConfigs {
+		if !slices.Contains(tags, inbound.Tag) {
+			continue
+		}
+		inboundJson, err := json.MarshalIndent(inbound, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := s.xrayAPI.DelInbound(inbound.Tag); err != nil {
+			return err
+		}
+		if err := s.xrayAPI.AddInbound(inboundJson); err != nil {
+			return err
+		}
+		logger.Debug("Inbound reloaded by api:", inbound.Tag)
+	}
+	return nil
+}
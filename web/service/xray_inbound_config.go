@@ -0,0 +1,95 @@
+package service
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// inboundConfigSource holds the fields of an inbound its Xray config is generated from.
+type inboundConfigSource struct {
+	listen          string
+	port            int
+	portRange       string
+	protocol        model.Protocol
+	settings        string
+	streamSettings  string
+	tag             string
+	sniffing        string
+	disabledClients string // Emails of the clients disabled for their expiry or traffic limit
+}
+
+// inboundConfigEntry is the Xray config generated for an inbound from its source, followed by
+// the config of its REALITY grace inbound while it has one.
+type inboundConfigEntry struct {
+	source  inboundConfigSource
+	configs []xray.InboundConfig
+}
+
+// generatedInbounds keeps the Xray config generated for every inbound, by inbound ID.
+// Rebuilding the config prepares only the inbounds changed since, and the unchanged ones keep
+// sharing their config with the running Xray, which makes comparing the two configs cheap.
+var generatedInbounds struct {
+	sync.Mutex
+	entries map[int]inboundConfigEntry
+}
+
+// newInboundConfigSource returns the source of the Xray config of an inbound.
+func newInboundConfigSource(inbound *model.Inbound) inboundConfigSource {
+	var disabled []string
+	for _, clientTraffic := range inbound.ClientStats {
+		if !clientTraffic.Enable {
+			disabled = append(disabled, clientTraffic.Email)
+		}
+	}
+	return inboundConfigSource{
+		listen:          inbound.Listen,
+		port:            inbound.Port,
+		portRange:       inbound.PortRange,
+		protocol:        inbound.Protocol,
+		settings:        inbound.Settings,
+		streamSettings:  inbound.StreamSettings,
+		tag:             inbound.Tag,
+		sniffing:        inbound.Sniffing,
+		disabledClients: strings.Join(disabled, "\n"),
+	}
+}
+
+// genInboundConfigs returns the Xray configs of inbounds, generating them only for the
+// inbounds that changed since the last time and reusing the others.
+func (s *XrayService) genInboundConfigs(inbounds []*model.Inbound) ([]xray.InboundConfig, error) {
+	generatedInbounds.Lock()
+	defer generatedInbounds.Unlock()
+
+	entries := make(map[int]inboundConfigEntry, len(inbounds))
+	configs := make([]xray.InboundConfig, 0, len(inbounds))
+	generated := 0
+	for _, inbound := range inbounds {
+		source := newInboundConfigSource(inbound)
+		entry, ok := generatedInbounds.entries[inbound.Id]
+		if !ok || entry.source != source {
+			grace := inbound.SplitRealityGrace()
+			if err := s.prepareInbound(inbound); err != nil {
+				return nil, err
+			}
+			entry = inboundConfigEntry{source: source, configs: []xray.InboundConfig{*inbound.GenXrayInboundConfig()}}
+			if grace != nil {
+				if err := s.prepareInbound(grace); err != nil {
+					return nil, err
+				}
+				entry.configs = append(entry.configs, *grace.GenXrayInboundConfig())
+			}
+			generated++
+		}
+		entries[inbound.Id] = entry
+		configs = append(configs, entry.configs...)
+	}
+	generatedInbounds.entries = entries
+	if generated > 0 {
+		logger.Debugf("Xray config generated for %d of %d inbounds", generated, len(inbounds))
+	}
+	return configs, nil
+}
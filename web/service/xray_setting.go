@@ -27,5 +27,5 @@ func (s *XraySettingService) CheckXrayConfig(XrayTemplateConfig string) error {
 	if err != nil {
 		return common.NewError("xray template config invalid:", err)
 	}
-	return nil
+	return checkDnsConfig(xrayConfig.DNSConfig)
 }
@@ -0,0 +1,375 @@
+package service
+
+import (
+	"archive/zip"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// xrayVersionPattern matches release tags of Xray-core. Versions are used in file paths,
+// so anything else is rejected.
+var xrayVersionPattern = regexp.MustCompile(`^v\d+\.\d+\.\d+$`)
+
+// XrayVersions describes the Xray binaries stored in the bin folder.
+type XrayVersions struct {
+	Installed []string `json:"installed"` // Versions downloaded into the version store
+	Current   string   `json:"current"`   // Version of the active binary
+	Previous  string   `json:"previous"`  // Version of the binary a rollback switches to, if any
+}
+
+// xrayVersionsFolder returns the folder keeping one subfolder per downloaded Xray version.
+func xrayVersionsFolder() string {
+	return filepath.Join(config.GetBinFolderPath(), "xray-versions")
+}
+
+// activeXrayBinaryPath returns the path of the Xray binary the panel runs.
+func activeXrayBinaryPath() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join("bin", "xray-windows-amd64.exe")
+	}
+	return xray.GetBinaryPath()
+}
+
+// previousXrayBinaryPath returns the path the replaced binary is kept at for a rollback.
+func previousXrayBinaryPath() string {
+	return activeXrayBinaryPath() + ".prev"
+}
+
+// storedXrayBinaryPath returns the path of a downloaded version in the version store.
+func storedXrayBinaryPath(version string) string {
+	return filepath.Join(xrayVersionsFolder(), version, filepath.Base(activeXrayBinaryPath()))
+}
+
+// xrayReleaseAssetName returns the name of the release archive for the current OS and architecture.
+func xrayReleaseAssetName() string {
+	osName := runtime.GOOS
+	arch := runtime.GOARCH
+
+	switch osName {
+	case "darwin":
+		osName = "macos"
+	case "windows":
+		osName = "windows"
+	}
+
+	switch arch {
+	case "amd64":
+		arch = "64"
+	case "arm64":
+		arch = "arm64-v8a"
+	case "armv7":
+		arch = "arm32-v7a"
+	case "armv6":
+		arch = "arm32-v6"
+	case "armv5":
+		arch = "arm32-v5"
+	case "386":
+		arch = "32"
+	case "s390x":
+		arch = "s390x"
+	}
+
+	return fmt.Sprintf("Xray-%s-%s.zip", osName, arch)
+}
+
+// fetchXraySHA256 downloads the digest file published next to a release archive
+// and returns its SHA2-256 checksum.
+func fetchXraySHA256(url string) (string, error) {
+	resp, err := http.Get(url + ".dgst")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", common.NewErrorf("Failed to download checksum: %s", resp.Status)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), "=")
+		if ok && strings.TrimSpace(name) == "SHA2-256" {
+			return strings.ToLower(strings.TrimSpace(value)), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", common.NewError("SHA2-256 checksum not found in digest file")
+}
+
+// downloadXRay downloads the release archive of a version into a temporary file
+// and verifies it against the published checksum.
+func (s *ServerService) downloadXRay(version string) (string, error) {
+	url := fmt.Sprintf("https://github.com/XTLS/Xray-core/releases/download/%s/%s", version, xrayReleaseAssetName())
+	checksum, err := fetchXraySHA256(url)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", common.NewErrorf("Failed to download Xray %s: %s", version, resp.Status)
+	}
+
+	file, err := os.CreateTemp("", "xray-*.zip")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(file, hash), resp.Body); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != checksum {
+		os.Remove(file.Name())
+		return "", common.NewErrorf("Checksum mismatch for Xray %s: expected %s, got %s", version, checksum, sum)
+	}
+
+	return file.Name(), nil
+}
+
+// InstallXrayVersion downloads a version into the version store without activating it.
+func (s *ServerService) InstallXrayVersion(version string) error {
+	if !xrayVersionPattern.MatchString(version) {
+		return common.NewError("Invalid Xray version:", version)
+	}
+
+	zipFileName, err := s.downloadXRay(version)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(zipFileName)
+
+	reader, err := zip.OpenReader(zipFileName)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	zipName := "xray"
+	if runtime.GOOS == "windows" {
+		zipName = "xray.exe"
+	}
+	zipFile, err := reader.Open(zipName)
+	if err != nil {
+		return err
+	}
+	defer zipFile.Close()
+
+	target := storedXrayBinaryPath(version)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	os.Remove(target)
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR|os.O_TRUNC, fs.ModePerm)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(file, zipFile)
+	return err
+}
+
+// GetInstalledXrayVersions lists the downloaded versions and the active and previous binaries.
+func (s *ServerService) GetInstalledXrayVersions() (*XrayVersions, error) {
+	versions := &XrayVersions{
+		Installed: []string{},
+		Current:   binaryXrayVersion(activeXrayBinaryPath()),
+		Previous:  binaryXrayVersion(previousXrayBinaryPath()),
+	}
+
+	entries, err := os.ReadDir(xrayVersionsFolder())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !xrayVersionPattern.MatchString(entry.Name()) {
+			continue
+		}
+		if _, err := os.Stat(storedXrayBinaryPath(entry.Name())); err == nil {
+			versions.Installed = append(versions.Installed, entry.Name())
+		}
+	}
+	sort.Slice(versions.Installed, func(i, j int) bool {
+		return compareXrayVersions(versions.Installed[i], versions.Installed[j]) > 0
+	})
+	return versions, nil
+}
+
+// compareXrayVersions compares two versions matching xrayVersionPattern numerically.
+func compareXrayVersions(a, b string) int {
+	aParts := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bParts := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := range aParts {
+		x, _ := strconv.Atoi(aParts[i])
+		y, _ := strconv.Atoi(bParts[i])
+		if x != y {
+			return x - y
+		}
+	}
+	return 0
+}
+
+// binaryXrayVersion runs an Xray binary to read its version, or returns "" if it cannot be run.
+func binaryXrayVersion(path string) string {
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	version, err := xray.GetBinaryVersion(path)
+	if err != nil {
+		return ""
+	}
+	return "v" + version
+}
+
+// SwitchXray makes a downloaded version the active binary and restarts Xray. The replaced
+// binary is kept for RollbackXray. If the new version fails to start, the old one is restored.
+func (s *ServerService) SwitchXray(version string) error {
+	if xray.IsBinaryPinned() {
+		return common.NewError("Xray binary is pinned and cannot be switched from the panel")
+	}
+	if !xrayVersionPattern.MatchString(version) {
+		return common.NewError("Invalid Xray version:", version)
+	}
+	source := storedXrayBinaryPath(version)
+	if _, err := os.Stat(source); err != nil {
+		return common.NewError("Xray version is not installed:", version)
+	}
+
+	if err := s.StopXrayService(); err != nil {
+		logger.Warning("failed to stop xray before switching version:", err)
+	}
+
+	active := activeXrayBinaryPath()
+	previous := previousXrayBinaryPath()
+	hasActive := false
+	if _, err := os.Stat(active); err == nil {
+		os.Remove(previous)
+		if err := os.Rename(active, previous); err != nil {
+			return err
+		}
+		hasActive = true
+	}
+	if err := copyBinary(source, active); err != nil {
+		if hasActive {
+			os.Rename(previous, active)
+		}
+		return err
+	}
+
+	if err := s.xrayService.RestartXray(true); err != nil {
+		logger.Error("start xray failed, restoring previous version:", err)
+		if hasActive {
+			if err := swapXrayBinaries(); err != nil {
+				logger.Error("restore previous xray failed:", err)
+			} else if err := s.xrayService.RestartXray(true); err != nil {
+				logger.Error("start previous xray failed:", err)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// RollbackXray switches back to the binary that was active before the last switch.
+func (s *ServerService) RollbackXray() error {
+	if xray.IsBinaryPinned() {
+		return common.NewError("Xray binary is pinned and cannot be switched from the panel")
+	}
+	if _, err := os.Stat(previousXrayBinaryPath()); err != nil {
+		return common.NewError("No previous Xray version to roll back to")
+	}
+
+	if err := s.StopXrayService(); err != nil {
+		logger.Warning("failed to stop xray before rollback:", err)
+	}
+	if err := swapXrayBinaries(); err != nil {
+		return err
+	}
+	if err := s.xrayService.RestartXray(true); err != nil {
+		logger.Error("start xray failed:", err)
+		return err
+	}
+	return nil
+}
+
+// UpdateXray downloads a version if it is not in the version store yet and switches to it.
+func (s *ServerService) UpdateXray(version string) error {
+	if _, err := os.Stat(storedXrayBinaryPath(version)); err != nil || !xrayVersionPattern.MatchString(version) {
+		if err := s.InstallXrayVersion(version); err != nil {
+			return err
+		}
+	}
+	return s.SwitchXray(version)
+}
+
+// DeleteXrayVersion removes a downloaded version from the version store.
+func (s *ServerService) DeleteXrayVersion(version string) error {
+	if !xrayVersionPattern.MatchString(version) {
+		return common.NewError("Invalid Xray version:", version)
+	}
+	return os.RemoveAll(filepath.Join(xrayVersionsFolder(), version))
+}
+
+// swapXrayBinaries exchanges the active and the previous Xray binary.
+func swapXrayBinaries() error {
+	active := activeXrayBinaryPath()
+	previous := previousXrayBinaryPath()
+	swap := active + ".swap"
+	os.Remove(swap)
+	if _, err := os.Stat(active); err == nil {
+		if err := os.Rename(active, swap); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(previous, active); err != nil {
+		os.Rename(swap, active)
+		return err
+	}
+	if _, err := os.Stat(swap); err == nil {
+		return os.Rename(swap, previous)
+	}
+	return nil
+}
+
+// copyBinary copies an executable file, replacing the destination.
+func copyBinary(source, destination string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(destination, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fs.ModePerm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -5,6 +5,7 @@ package session
 import (
 	"encoding/gob"
 	"net/http"
+	"strings"
 
 	"github.com/mhsanaei/3x-ui/v2/database/model"
 
@@ -39,10 +40,19 @@ func SetMaxAge(c *gin.Context, maxAge int) {
 		Path:     defaultPath,
 		MaxAge:   maxAge,
 		HttpOnly: true,
+		Secure:   isSecure(c),
 		SameSite: http.SameSiteLaxMode,
 	})
 }
 
+// isSecure reports whether the request reached the panel over HTTPS, directly or through a
+// trusted proxy terminating TLS, so that the cookie is only sent back over HTTPS. The
+// X-Forwarded-Proto header of the other peers is removed before.
+func isSecure(c *gin.Context) bool {
+	proto, _, _ := strings.Cut(c.GetHeader("X-Forwarded-Proto"), ",")
+	return c.Request.TLS != nil || strings.EqualFold(strings.TrimSpace(proto), "https")
+}
+
 // GetLoginUser retrieves the authenticated user from the session.
 // Returns nil if no user is logged in or if the session data is invalid.
 func GetLoginUser(c *gin.Context) *model.User {
@@ -75,6 +85,7 @@ func ClearSession(c *gin.Context) {
 		Path:     defaultPath,
 		MaxAge:   -1,
 		HttpOnly: true,
+		Secure:   isSecure(c),
 		SameSite: http.SameSiteLaxMode,
 	})
 }
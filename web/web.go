@@ -6,6 +6,7 @@ import (
 	"context"
 	"crypto/tls"
 	"embed"
+	"fmt"
 	"html/template"
 	"io"
 	"io/fs"
@@ -24,6 +25,7 @@ import (
 	"github.com/mhsanaei/3x-ui/v2/web/locale"
 	"github.com/mhsanaei/3x-ui/v2/web/middleware"
 	"github.com/mhsanaei/3x-ui/v2/web/network"
+	"github.com/mhsanaei/3x-ui/v2/web/scheduler"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
 
 	"github.com/gin-contrib/gzip"
@@ -92,18 +94,28 @@ func EmbeddedAssets() embed.FS {
 
 // Server represents the main web server for the 3x-ui panel with controllers, services, and scheduled jobs.
 type Server struct {
-	httpServer *http.Server
-	listener   net.Listener
-
-	index *controller.IndexController
-	panel *controller.XUIController
-	api   *controller.APIController
-
-	xrayService    service.XrayService
-	settingService service.SettingService
-	tgbotService   service.Tgbot
-
-	cron *cron.Cron
+	httpServer        *http.Server
+	listeners         []net.Listener // The main listener first
+	diagnosticsServer *http.Server
+
+	index   *controller.IndexController
+	panel   *controller.XUIController
+	api     *controller.APIController
+	metrics *controller.MetricsController
+	grafana *controller.GrafanaController
+	tgbot   *controller.TgbotController
+	node    *controller.NodeRegisterController
+
+	xrayService            service.XrayService
+	settingService         service.SettingService
+	tgbotService           service.Tgbot
+	messageTemplateService service.MessageTemplateService
+	acmeService            service.AcmeService
+	certificateService     service.CertificateService
+	logLevelService        service.LogLevelService
+	lokiService            service.LokiService
+
+	scheduler *scheduler.Scheduler
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -177,6 +189,27 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 	}
 
 	engine := gin.Default()
+	trustedProxies, err := s.settingService.GetTrustedProxies()
+	if err != nil {
+		return nil, err
+	}
+	if err := middleware.UseTrustedProxies(engine, trustedProxies); err != nil {
+		return nil, err
+	}
+	engine.Use(middleware.RequestIDMiddleware())
+	engine.Use(middleware.MetricsMiddleware())
+
+	accessLog, err := s.settingService.GetHttpAccessLog()
+	if err != nil {
+		return nil, err
+	}
+	if accessLog != nil {
+		basePath, err := s.settingService.GetBasePath()
+		if err != nil {
+			return nil, err
+		}
+		engine.Use(middleware.AccessLogMiddleware("panel", basePath, accessLog))
+	}
 
 	webDomain, err := s.settingService.GetWebDomain()
 	if err != nil {
@@ -211,7 +244,8 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 	}
 	engine.Use(sessions.Sessions("3x-ui", store))
 	engine.Use(func(c *gin.Context) {
-		c.Set("base_path", basePath)
+		// The links and redirects include the prefix a reverse proxy strips from the path
+		c.Set("base_path", middleware.ForwardedPrefix(c)+basePath)
 	})
 	engine.Use(func(c *gin.Context) {
 		uri := c.Request.RequestURI
@@ -225,6 +259,9 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := s.messageTemplateService.LoadMessageTemplates(); err != nil {
+		logger.Warning("Load customized notification messages failed:", err)
+	}
 
 	// Apply locale middleware for i18n
 	i18nWebFunc := func(key string, params ...string) string {
@@ -265,20 +302,41 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 	s.index = controller.NewIndexController(g)
 	s.panel = controller.NewXUIController(g)
 	s.api = controller.NewAPIController(g)
+	s.metrics = controller.NewMetricsController(g)
+	s.grafana = controller.NewGrafanaController(g)
+	s.tgbot = controller.NewTgbotController(g)
+	s.node = controller.NewNodeRegisterController(g)
 
 	// Chrome DevTools endpoint for debugging web apps
 	engine.GET("/.well-known/appspecific/com.chrome.devtools.json", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{})
 	})
 
-	// Add a catch-all route to handle undefined paths and return 404
-	engine.NoRoute(func(c *gin.Context) {
+	// Add a catch-all route to handle undefined paths and return 404, or serve the decoy site
+	notFound := func(c *gin.Context) {
 		c.AbortWithStatus(http.StatusNotFound)
-	})
+	}
+	if decoySite, err := s.settingService.GetDecoySite(); err == nil && decoySite != "" {
+		if decoy, err := middleware.DecoyHandler(decoySite); err != nil {
+			logger.Warning("Serve decoy site failed:", err)
+		} else {
+			notFound = decoy
+		}
+	}
+	engine.NoRoute(notFound)
 
 	return engine, nil
 }
 
+// cronZone returns the prefix of a cron spec that runs it in loc rather than in the panel time
+// zone of the scheduler, or no prefix when loc could not be read.
+func cronZone(loc *time.Location, err error) string {
+	if err != nil {
+		return ""
+	}
+	return "CRON_TZ=" + loc.String() + " "
+}
+
 // startTask schedules background jobs (Xray checks, traffic jobs, cron
 // jobs) which the panel relies on for periodic maintenance and monitoring.
 func (s *Server) startTask() {
@@ -287,10 +345,10 @@ func (s *Server) startTask() {
 		logger.Warning("start xray failed:", err)
 	}
 	// Check whether xray is running every second
-	s.cron.AddJob("@every 1s", job.NewCheckXrayRunningJob())
+	s.scheduler.AddJob("checkXrayRunning", "@every 1s", job.NewCheckXrayRunningJob())
 
 	// Check if xray needs to be restarted every 30 seconds
-	s.cron.AddFunc("@every 30s", func() {
+	s.scheduler.AddFunc("xrayRestart", "@every 30s", func() {
 		if s.xrayService.IsNeedRestartAndSetFalse() {
 			err := s.xrayService.RestartXray(false)
 			if err != nil {
@@ -301,23 +359,111 @@ func (s *Server) startTask() {
 
 	go func() {
 		time.Sleep(time.Second * 5)
-		// Statistics every 10 seconds, start the delay for 5 seconds for the first time, and staggered with the time to restart xray
-		s.cron.AddJob("@every 10s", job.NewXrayTrafficJob())
+		// Statistics every 10 seconds by default, start the delay for 5 seconds for the first time, and staggered with the time to restart xray
+		interval, err := s.settingService.GetTrafficStatsInterval()
+		if err != nil || interval < 1 {
+			interval = 10
+		}
+		s.scheduler.AddJob("xrayTraffic", fmt.Sprintf("@every %ds", interval), job.NewXrayTrafficJob())
 	}()
 
 	// check client ips from log file every 10 sec
-	s.cron.AddJob("@every 10s", job.NewCheckClientIpJob())
+	s.scheduler.AddJob("checkClientIp", "@every 10s", job.NewCheckClientIpJob())
+
+	// Enforce inbound connection limits every 10 seconds
+	s.scheduler.AddJob("connectionLimit", "@every 10s", job.NewConnectionLimitJob())
 
 	// check client ips from log file every day
-	s.cron.AddJob("@daily", job.NewClearLogsJob())
+	s.scheduler.AddJob("clearLogs", "@daily", job.NewClearLogsJob())
 
-	// Inbound traffic reset jobs
+	// Inbound traffic reset jobs, at midnight in the traffic reset time zone
+	resetZone := cronZone(s.settingService.GetTrafficResetTimeLocation())
 	// Run once a day, midnight
-	s.cron.AddJob("@daily", job.NewPeriodicTrafficResetJob("daily"))
+	s.scheduler.AddJob("periodicTrafficResetDaily", resetZone+"@daily", job.NewPeriodicTrafficResetJob("daily"))
 	// Run once a week, midnight between Sat/Sun
-	s.cron.AddJob("@weekly", job.NewPeriodicTrafficResetJob("weekly"))
+	s.scheduler.AddJob("periodicTrafficResetWeekly", resetZone+"@weekly", job.NewPeriodicTrafficResetJob("weekly"))
 	// Run once a month, midnight, first of month
-	s.cron.AddJob("@monthly", job.NewPeriodicTrafficResetJob("monthly"))
+	s.scheduler.AddJob("periodicTrafficResetMonthly", resetZone+"@monthly", job.NewPeriodicTrafficResetJob("monthly"))
+
+	// Scheduled client traffic resets, checked every hour so a missed run is caught up
+	s.scheduler.AddJob("clientTrafficReset", "@hourly", job.NewClientTrafficResetJob())
+
+	// Roll aged traffic samples up into daily and monthly ones
+	s.scheduler.AddJob("trafficSample", "@hourly", job.NewTrafficSampleJob())
+
+	// Move traffic history past the archive age out of the database
+	s.scheduler.AddJob("trafficArchive", "@daily", job.NewTrafficArchiveJob())
+
+	// Push the metrics to a time-series database when enabled
+	if enabled, _ := s.settingService.GetMetricsPushEnable(); enabled {
+		interval, err := s.settingService.GetMetricsPushInterval()
+		if err != nil || interval < 10 {
+			interval = 60
+		}
+		s.scheduler.AddJob("metricsPush", fmt.Sprintf("@every %ds", interval), job.NewMetricsPushJob())
+	}
+
+	// Measure the server bandwidth at the configured interval
+	if hours, _ := s.settingService.GetSpeedtestInterval(); hours > 0 {
+		s.scheduler.AddJob("speedtest", fmt.Sprintf("@every %dh", hours), job.NewSpeedtestJob())
+	}
+
+	// Check that the inbounds are reachable from the outside at the configured interval
+	if minutes, _ := s.settingService.GetReachabilityInterval(); minutes > 0 {
+		s.scheduler.AddJob("reachability", fmt.Sprintf("@every %dm", minutes), job.NewReachabilityJob())
+	}
+
+	// Send the usage reports at midnight in the report time zone when enabled
+	reportZone := cronZone(s.settingService.GetReportTimeLocation())
+	if enabled, _ := s.settingService.GetReportDaily(); enabled {
+		s.scheduler.AddJob("usageReportDaily", reportZone+"@daily", job.NewUsageReportJob(service.ReportDaily))
+	}
+	if enabled, _ := s.settingService.GetReportWeekly(); enabled {
+		s.scheduler.AddJob("usageReportWeekly", reportZone+"@weekly", job.NewUsageReportJob(service.ReportWeekly))
+	}
+
+	// Email a backup to the administrators every day when enabled
+	if enabled, _ := s.settingService.GetSmtpBackup(); enabled {
+		s.scheduler.AddJob("backupMail", "@daily", job.NewBackupMailJob())
+	}
+
+	// Check the traffic of the previous day for anomalies shortly after midnight when enabled
+	if enabled, _ := s.settingService.GetAnomalyDetection(); enabled {
+		s.scheduler.AddJob("anomaly", "0 5 0 * * *", job.NewAnomalyJob())
+	}
+
+	// Evaluate the alert rules every minute
+	s.scheduler.AddJob("alert", "@every 1m", job.NewAlertJob())
+
+	// Sync the traffic and the inbounds of the managed nodes every 30 seconds
+	s.scheduler.AddJob("nodeSync", "@every 30s", job.NewNodeSyncJob())
+
+	// Check the health of the managed nodes every minute
+	s.scheduler.AddJob("nodeHealth", "@every 1m", job.NewNodeHealthJob())
+
+	// Enforce the client limits as a node while the central panel cannot be reached
+	s.scheduler.AddJob("nodeAgentLimit", "@every 30s", job.NewNodeAgentLimitJob())
+
+	// Rotate REALITY keys of inbounds when enabled, and retire the previous keys after their grace period, every hour
+	s.scheduler.AddJob("realityKeyRotation", "@hourly", job.NewRealityKeyRotationJob())
+
+	// Delete expired clients once their grace period is over, when enabled
+	if days, _ := s.settingService.GetExpiryGraceDays(); days > 0 {
+		s.scheduler.AddJob("expiredClientCleanup", "@hourly", job.NewExpiredClientCleanupJob())
+	}
+
+	// Aggregate the connections of the access log when enabled
+	if enabled, _ := s.settingService.GetAccessLogAnalytics(); enabled {
+		s.scheduler.AddJob("accessLogAnalytics", "@every 30s", job.NewAccessLogAnalyticsJob())
+	}
+
+	// Rotate the logs of the panel and of Xray when they exceed the size or age limit
+	s.scheduler.AddJob("logRotate", "@every 5m", job.NewLogRotateJob())
+
+	// Download updated geoip/geosite databases at the configured interval
+	if hours, _ := s.settingService.GetGeofileUpdateInterval(); hours > 0 {
+		s.scheduler.AddJob("geofileUpdate", fmt.Sprintf("@every %dh", hours), job.NewGeofileUpdateJob())
+	}
 
 	// LDAP sync scheduling
 	if ldapEnabled, _ := s.settingService.GetLdapEnable(); ldapEnabled {
@@ -327,7 +473,7 @@ func (s *Server) startTask() {
 		}
 		j := job.NewLdapSyncJob()
 		// job has zero-value services with method receivers that read settings on demand
-		s.cron.AddJob(runtime, j)
+		s.scheduler.AddJob("ldapSync", runtime, j)
 	}
 
 	// Make a traffic condition every day, 8:30
@@ -340,22 +486,35 @@ func (s *Server) startTask() {
 			runtime = "@daily"
 		}
 		logger.Infof("Tg notify enabled,run at %s", runtime)
-		_, err = s.cron.AddJob(runtime, job.NewStatsNotifyJob())
+		_, err = s.scheduler.AddJob("statsNotify", runtime, job.NewStatsNotifyJob())
 		if err != nil {
 			logger.Warning("Add NewStatsNotifyJob error", err)
 			return
 		}
 
 		// check for Telegram bot callback query hash storage reset
-		s.cron.AddJob("@every 2m", job.NewCheckHashStorageJob())
+		s.scheduler.AddJob("checkHashStorage", "@every 2m", job.NewCheckHashStorageJob())
+	} else {
+		s.scheduler.Remove(entry)
+	}
 
-		// Check CPU load and alarm to TgBot if threshold passes
+	// Renew the ACME certificates, reload the inbounds of renewed certificates and alert on failures
+	s.scheduler.AddJob("certRenew", "@every 10m", job.NewCertRenewJob())
+
+	// The threshold notifications also go to the email, Discord, Slack and webhook channels
+	notifyService := service.NotifyService{}
+	if notifyService.HasDestinations() {
+		// Check client expiry and quota thresholds every 10 minutes
+		s.scheduler.AddJob("clientNotify", "@every 10m", job.NewClientNotifyJob())
+
+		// Send the notifications held back by quiet hours and batching once they are due
+		s.scheduler.AddJob("notifyDigest", "@every 1m", job.NewNotifyDigestJob())
+
+		// Check CPU load and alarm if threshold passes
 		cpuThreshold, err := s.settingService.GetTgCpu()
 		if (err == nil) && (cpuThreshold > 0) {
-			s.cron.AddJob("@every 10s", job.NewCheckCpuJob())
+			s.scheduler.AddJob("checkCpu", "@every 10s", job.NewCheckCpuJob())
 		}
-	} else {
-		s.cron.Remove(entry)
 	}
 }
 
@@ -368,18 +527,38 @@ func (s *Server) Start() (err error) {
 		}
 	}()
 
+	if err := s.logLevelService.ApplyLogLevels(); err != nil {
+		logger.Warning("Error applying the log levels of the modules:", err)
+	}
+	// Ship the logs to Loki until the server stops, when enabled
+	if err := s.lokiService.Start(s.ctx); err != nil {
+		logger.Warning("Error starting the Loki log shipping:", err)
+	}
+
 	loc, err := s.settingService.GetTimeLocation()
 	if err != nil {
 		return err
 	}
-	s.cron = cron.New(cron.WithLocation(loc), cron.WithSeconds())
-	s.cron.Start()
+	workers, err := s.settingService.GetJobWorkers()
+	if err != nil || workers < 1 {
+		workers = 4
+	}
+	s.scheduler = scheduler.New(loc, workers)
+	s.scheduler.Start()
 
 	engine, err := s.initRouter()
 	if err != nil {
 		return err
 	}
 
+	// The subscription server started next shares the ACME client and the certificate store
+	if err := s.acmeService.Start(); err != nil {
+		logger.Error("Error starting the ACME client:", err)
+	}
+	if err := s.certificateService.LoadStore(); err != nil {
+		logger.Error("Error loading the certificate store:", err)
+	}
+
 	certFile, err := s.settingService.GetCertFile()
 	if err != nil {
 		return err
@@ -388,6 +567,10 @@ func (s *Server) Start() (err error) {
 	if err != nil {
 		return err
 	}
+	webDomain, err := s.settingService.GetWebDomain()
+	if err != nil {
+		return err
+	}
 	listen, err := s.settingService.GetListen()
 	if err != nil {
 		return err
@@ -397,36 +580,59 @@ func (s *Server) Start() (err error) {
 		return err
 	}
 	listenAddr := net.JoinHostPort(listen, strconv.Itoa(port))
-	listener, err := net.Listen("tcp", listenAddr)
+	// The socket is taken over from the server stopped on the same address by a restart
+	listener, err := network.Listen(listenAddr)
 	if err != nil {
 		return err
 	}
-	if certFile != "" || keyFile != "" {
-		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
-		if err == nil {
-			c := &tls.Config{
-				Certificates: []tls.Certificate{cert},
-			}
-			listener = network.NewAutoHttpsListener(listener)
-			listener = tls.NewListener(listener, c)
-			logger.Info("Web server running HTTPS on", listener.Addr())
-		} else {
-			logger.Error("Error loading certificates:", err)
-			logger.Info("Web server running HTTP on", listener.Addr())
-		}
+	c, err := s.acmeService.TLSConfig(webDomain, certFile, keyFile)
+	if err != nil {
+		logger.Error("Error loading certificates:", err)
+	}
+	if c != nil {
+		listener = network.NewAutoHttpsListener(listener)
+		listener = tls.NewListener(listener, c)
+		logger.Info("Web server running HTTPS on", listener.Addr())
 	} else {
 		logger.Info("Web server running HTTP on", listener.Addr())
 	}
-	s.listener = listener
+	s.listeners = []net.Listener{listener}
+
+	// The additional addresses are skipped when they fail, the main one keeps the server reachable
+	extraListeners, err := s.settingService.GetWebListeners()
+	if err != nil {
+		return err
+	}
+	for _, extra := range extraListeners {
+		extraConfig, err := s.acmeService.ListenerTLSConfig(extra, c)
+		if err != nil {
+			logger.Error("Error loading certificates of listener", extra.Addr+":", err)
+			continue
+		}
+		extraListener, err := network.ListenTLS(extra.Addr, extraConfig)
+		if err != nil {
+			logger.Error("Error listening on", extra.Addr+":", err)
+			continue
+		}
+		s.listeners = append(s.listeners, extraListener)
+		if extraConfig != nil {
+			logger.Info("Web server also running HTTPS on", extraListener.Addr())
+		} else {
+			logger.Info("Web server also running HTTP on", extraListener.Addr())
+		}
+	}
 
 	s.httpServer = &http.Server{
 		Handler: engine,
 	}
 
-	go func() {
-		s.httpServer.Serve(listener)
-	}()
+	for _, listener := range s.listeners {
+		go func() {
+			s.httpServer.Serve(listener)
+		}()
+	}
 
+	s.startDiagnostics()
 	s.startTask()
 
 	isTgbotenabled, err := s.settingService.GetTgbotEnabled()
@@ -438,24 +644,29 @@ func (s *Server) Start() (err error) {
 	return nil
 }
 
-// Stop gracefully shuts down the web server, stops Xray, cron jobs, and Telegram bot.
+// Stop gracefully shuts down the web server, waiting for the requests in flight, then stops Xray,
+// cron jobs, and Telegram bot. Its socket is kept for the next server on the same address.
 func (s *Server) Stop() error {
+	var err1 error
+	var err2 error
+	if s.httpServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), network.DrainTimeout)
+		err1 = s.httpServer.Shutdown(ctx)
+		cancel()
+	}
+	for _, listener := range s.listeners {
+		err2 = common.Combine(err2, listener.Close())
+	}
+	err2 = common.Combine(err2, s.stopDiagnostics())
 	s.cancel()
 	s.xrayService.StopXray()
-	if s.cron != nil {
-		s.cron.Stop()
+	s.acmeService.Stop()
+	if s.scheduler != nil {
+		s.scheduler.Stop()
 	}
 	if s.tgbotService.IsRunning() {
 		s.tgbotService.Stop()
 	}
-	var err1 error
-	var err2 error
-	if s.httpServer != nil {
-		err1 = s.httpServer.Shutdown(s.ctx)
-	}
-	if s.listener != nil {
-		err2 = s.listener.Close()
-	}
 	return common.Combine(err1, err2)
 }
 
@@ -464,7 +675,7 @@ func (s *Server) GetCtx() context.Context {
 	return s.ctx
 }
 
-// GetCron returns the server's cron scheduler instance.
-func (s *Server) GetCron() *cron.Cron {
-	return s.cron
+// GetScheduler returns the server's job scheduler instance.
+func (s *Server) GetScheduler() *scheduler.Scheduler {
+	return s.scheduler
 }
@@ -85,7 +85,37 @@ install() {
 }
 
 update() {
-    confirm "This function will update all x-ui components to the latest version, and the data will not be lost. Do you want to continue?" "y"
+    # Builds that verify signed releases update the panel binary in place and roll back if it
+    # fails to start, the others run update.sh, which updates all x-ui components
+    local check
+    check=$(/usr/local/x-ui/x-ui update -check -json 2>/dev/null)
+    if ! echo "${check}" | grep -q '"supported": true'; then
+        confirm "This function will update all x-ui components to the latest version, and the data will not be lost. Do you want to continue?" "y"
+        if [[ $? != 0 ]]; then
+            LOGE "Cancelled"
+            if [[ $# == 0 ]]; then
+                before_show_menu
+            fi
+            return 0
+        fi
+        bash <(curl -Ls https://raw.githubusercontent.com/MHSanaei/3x-ui/main/update.sh)
+        if [[ $? == 0 ]]; then
+            LOGI "Update is complete, Panel has automatically restarted "
+            if [[ $# == 0 ]]; then
+                before_show_menu
+            fi
+        fi
+        return 0
+    fi
+
+    if ! echo "${check}" | grep -q '"available": true'; then
+        LOGI "The panel is already up to date"
+        if [[ $# == 0 ]]; then
+            before_show_menu
+        fi
+        return 0
+    fi
+    confirm "This function will update the panel binary to the latest signed release, and the data will not be lost. Xray, the geo files and this script are not updated. Do you want to continue?" "y"
     if [[ $? != 0 ]]; then
         LOGE "Cancelled"
         if [[ $# == 0 ]]; then
@@ -93,9 +123,14 @@ update() {
         fi
         return 0
     fi
-    bash <(curl -Ls https://raw.githubusercontent.com/MHSanaei/3x-ui/main/update.sh)
+    /usr/local/x-ui/x-ui update
     if [[ $? == 0 ]]; then
+        restart 0
         LOGI "Update is complete, Panel has automatically restarted "
+    else
+        LOGE "Update failed, the panel is unchanged"
+    fi
+    if [[ $# == 0 ]]; then
         before_show_menu
     fi
 }
@@ -943,11 +978,13 @@ install_acme() {
 }
 
 ssl_cert_issue_main() {
-    echo -e "${green}\t1.${plain} Get SSL"
-    echo -e "${green}\t2.${plain} Revoke"
-    echo -e "${green}\t3.${plain} Force Renew"
-    echo -e "${green}\t4.${plain} Show Existing Domains"
-    echo -e "${green}\t5.${plain} Set Cert paths for the panel"
+    echo -e "${green}\t1.${plain} Get SSL (built-in ACME)"
+    echo -e "${green}\t2.${plain} Get SSL with acme.sh"
+    echo -e "${green}\t3.${plain} Revoke"
+    echo -e "${green}\t4.${plain} Force Renew"
+    echo -e "${green}\t5.${plain} Show Existing Domains"
+    echo -e "${green}\t6.${plain} Set Cert paths for the panel"
+    echo -e "${green}\t7.${plain} Generate a self-signed certificate for the panel"
     echo -e "${green}\t0.${plain} Back to Main Menu"
 
     read -rp "Choose an option: " choice
@@ -956,10 +993,14 @@ ssl_cert_issue_main() {
         show_menu
         ;;
     1)
-        ssl_cert_issue
+        ssl_cert_issue_builtin
         ssl_cert_issue_main
         ;;
     2)
+        ssl_cert_issue
+        ssl_cert_issue_main
+        ;;
+    3)
         local domains=$(find /root/cert/ -mindepth 1 -maxdepth 1 -type d -exec basename {} \;)
         if [ -z "$domains" ]; then
             echo "No certificates found to revoke."
@@ -976,7 +1017,7 @@ ssl_cert_issue_main() {
         fi
         ssl_cert_issue_main
         ;;
-    3)
+    4)
         local domains=$(find /root/cert/ -mindepth 1 -maxdepth 1 -type d -exec basename {} \;)
         if [ -z "$domains" ]; then
             echo "No certificates found to renew."
@@ -993,7 +1034,7 @@ ssl_cert_issue_main() {
         fi
         ssl_cert_issue_main
         ;;
-    4)
+    5)
         local domains=$(find /root/cert/ -mindepth 1 -maxdepth 1 -type d -exec basename {} \;)
         if [ -z "$domains" ]; then
             echo "No certificates found."
@@ -1013,7 +1054,7 @@ ssl_cert_issue_main() {
         fi
         ssl_cert_issue_main
         ;;
-    5)
+    6)
         local domains=$(find /root/cert/ -mindepth 1 -maxdepth 1 -type d -exec basename {} \;)
         if [ -z "$domains" ]; then
             echo "No certificates found."
@@ -1041,6 +1082,10 @@ ssl_cert_issue_main() {
         fi
         ssl_cert_issue_main
         ;;
+    7)
+        ssl_cert_self_signed
+        ssl_cert_issue_main
+        ;;
 
     *)
         echo -e "${red}Invalid option. Please select a valid number.${plain}\n"
@@ -1049,6 +1094,46 @@ ssl_cert_issue_main() {
     esac
 }
 
+ssl_cert_issue_builtin() {
+    local domain=""
+    read -rp "Please enter your domain name: " domain
+    if [[ -z "${domain}" ]]; then
+        LOGE "A domain is required."
+        return 1
+    fi
+    local email=""
+    read -rp "Please enter your email for expiry notices (optional): " email
+
+    LOGI "The panel answers the challenges itself, on port 80 when it is free, or on its own port when that is 443."
+    LOGI "Please make sure ${domain} points to this server and one of these ports is open."
+    /usr/local/x-ui/x-ui cert -acme -domain "${domain}" -email "${email}"
+    if [ $? -ne 0 ]; then
+        LOGE "Enabling ACME failed."
+        return 1
+    fi
+    restart
+    LOGI "The certificate is obtained and renewed by the panel, see x-ui log for its progress."
+}
+
+ssl_cert_self_signed() {
+    local hosts=""
+    read -rp "Please enter the domains or IPs, comma separated (leave empty for this server): " hosts
+    local use_ca=""
+    read -rp "Issue it from a local CA you can trust on your devices? [y/n]: " use_ca
+    local ca_flag="false"
+    if [[ "${use_ca}" == "y" || "${use_ca}" == "Y" ]]; then
+        ca_flag="true"
+    fi
+
+    /usr/local/x-ui/x-ui cert -selfSigned -domain "${hosts}" -ca=${ca_flag}
+    if [ $? -ne 0 ]; then
+        LOGE "Generating the self-signed certificate failed."
+        return 1
+    fi
+    restart
+    LOGI "The panel now uses HTTPS, browsers warn about the certificate until it or its CA is trusted."
+}
+
 ssl_cert_issue() {
     local existing_webBasePath=$(/usr/local/x-ui/x-ui setting -show true | grep -Eo 'webBasePath: .+' | awk '{print $2}')
     local existing_port=$(/usr/local/x-ui/x-ui setting -show true | grep -Eo 'port: .+' | awk '{print $2}')
@@ -1728,7 +1813,7 @@ EOF
     cat << EOF > /etc/fail2ban/filter.d/3x-ipl.conf
 [Definition]
 datepattern = ^%%Y/%%m/%%d %%H:%%M:%%S
-failregex   = \[LIMIT_IP\]\s*Email\s*=\s*<F-USER>.+</F-USER>\s*\|\|\s*SRC\s*=\s*<ADDR>
+failregex   = \[LIMIT_IP\]\s*Email\s*=\s*<F-USER>.+</F-USER>\s*\|\|\s*SRC\s*=\s*<SUBNET>
 ignoreregex =
 EOF
 
@@ -1886,6 +1971,17 @@ show_usage() {
 │  ${blue}x-ui legacy${plain}       - legacy version                   │
 │  ${blue}x-ui install${plain}      - Install                          │
 │  ${blue}x-ui uninstall${plain}    - Uninstall                        │
+│  ${blue}x-ui status --json${plain}     - Status as JSON              │
+│  ${blue}x-ui inbound list${plain}      - List inbounds               │
+│  ${blue}x-ui client list${plain}       - List clients                │
+│  ${blue}x-ui client add${plain}        - Add a client                │
+│  ${blue}x-ui client del${plain}        - Delete a client             │
+│  ${blue}x-ui migrate-db -to mysql${plain} - Copy database to MySQL   │
+│  ${blue}x-ui backup${plain}            - Write a backup archive      │
+│  ${blue}x-ui restore <archive>${plain} - Restore a backup            │
+│  ${blue}x-ui doctor${plain}            - Diagnose problems           │
+│  ${blue}x-ui import <file>${plain}     - Import Marzban/Hiddify users│
+│  ${blue}x-ui report${plain}            - Traffic report              │
 └───────────────────────────────────────────────────────┘"
 }
 
@@ -2027,7 +2123,14 @@ if [[ $# > 0 ]]; then
         check_install 0 && restart 0
         ;;
     "status")
-        check_install 0 && status 0
+        if [[ $# > 1 ]]; then
+            check_install 0 && /usr/local/x-ui/x-ui "$@"
+        else
+            check_install 0 && status 0
+        fi
+        ;;
+    "client" | "inbound" | "migrate-db" | "backup" | "restore" | "doctor" | "import" | "report")
+        check_install 0 && /usr/local/x-ui/x-ui "$@"
         ;;
     "settings")
         check_install 0 && check_config 0
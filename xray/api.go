@@ -9,11 +9,13 @@ import (
 	"fmt"
 	"math"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/mhsanaei/3x-ui/v2/logger"
 	"github.com/mhsanaei/3x-ui/v2/util/common"
 
+	observatoryService "github.com/xtls/xray-core/app/observatory/command"
 	"github.com/xtls/xray-core/app/proxyman/command"
 	statsService "github.com/xtls/xray-core/app/stats/command"
 	"github.com/xtls/xray-core/common/protocol"
@@ -30,10 +32,11 @@ import (
 
 // XrayAPI is a gRPC client for managing Xray core configuration, inbounds, outbounds, and statistics.
 type XrayAPI struct {
-	HandlerServiceClient *command.HandlerServiceClient
-	StatsServiceClient   *statsService.StatsServiceClient
-	grpcClient           *grpc.ClientConn
-	isConnected          bool
+	HandlerServiceClient     *command.HandlerServiceClient
+	StatsServiceClient       *statsService.StatsServiceClient
+	ObservatoryServiceClient *observatoryService.ObservatoryServiceClient
+	grpcClient               *grpc.ClientConn
+	isConnected              bool
 }
 
 // Init connects to the Xray API server and initializes handler and stats service clients.
@@ -53,9 +56,11 @@ func (x *XrayAPI) Init(apiPort int) error {
 
 	hsClient := command.NewHandlerServiceClient(conn)
 	ssClient := statsService.NewStatsServiceClient(conn)
+	osClient := observatoryService.NewObservatoryServiceClient(conn)
 
 	x.HandlerServiceClient = &hsClient
 	x.StatsServiceClient = &ssClient
+	x.ObservatoryServiceClient = &osClient
 
 	return nil
 }
@@ -67,11 +72,15 @@ func (x *XrayAPI) Close() {
 	}
 	x.HandlerServiceClient = nil
 	x.StatsServiceClient = nil
+	x.ObservatoryServiceClient = nil
 	x.isConnected = false
 }
 
 // AddInbound adds a new inbound configuration to the Xray core via gRPC.
 func (x *XrayAPI) AddInbound(inbound []byte) error {
+	if x.grpcClient == nil || x.HandlerServiceClient == nil {
+		return common.NewError("xray api is not initialized")
+	}
 	client := *x.HandlerServiceClient
 
 	conf := new(conf.InboundDetourConfig)
@@ -94,6 +103,9 @@ func (x *XrayAPI) AddInbound(inbound []byte) error {
 
 // DelInbound removes an inbound configuration from the Xray core by tag.
 func (x *XrayAPI) DelInbound(tag string) error {
+	if x.grpcClient == nil || x.HandlerServiceClient == nil {
+		return common.NewError("xray api is not initialized")
+	}
 	client := *x.HandlerServiceClient
 	_, err := client.RemoveInbound(context.Background(), &command.RemoveInboundRequest{
 		Tag: tag,
@@ -103,6 +115,9 @@ func (x *XrayAPI) DelInbound(tag string) error {
 
 // AddUser adds a user to an inbound in the Xray core using the specified protocol and user data.
 func (x *XrayAPI) AddUser(Protocol string, inboundTag string, user map[string]any) error {
+	if x.grpcClient == nil || x.HandlerServiceClient == nil {
+		return common.NewError("xray api is not initialized")
+	}
 	var account *serial.TypedMessage
 	switch Protocol {
 	case "vmess":
@@ -145,7 +160,7 @@ func (x *XrayAPI) AddUser(Protocol string, inboundTag string, user map[string]an
 			})
 		}
 	default:
-		return nil
+		return common.NewError("Protocol is not supported by the Xray API:", Protocol)
 	}
 
 	client := *x.HandlerServiceClient
@@ -162,8 +177,12 @@ func (x *XrayAPI) AddUser(Protocol string, inboundTag string, user map[string]an
 	return err
 }
 
-// RemoveUser removes a user from an inbound in the Xray core by email.
+// RemoveUser removes a user from an inbound, and from its REALITY grace inbound if there is one,
+// in the Xray core by email.
 func (x *XrayAPI) RemoveUser(inboundTag, email string) error {
+	if x.grpcClient == nil || x.HandlerServiceClient == nil {
+		return common.NewError("xray api is not initialized")
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -178,6 +197,11 @@ func (x *XrayAPI) RemoveUser(inboundTag, email string) error {
 		return fmt.Errorf("failed to remove user: %w", err)
 	}
 
+	// The grace inbound accepting the previous REALITY keys of the inbound, if there is one,
+	// must not let the user in either
+	req.Tag = RealityGraceTag(inboundTag)
+	(*x.HandlerServiceClient).AlterInbound(ctx, req)
+
 	return nil
 }
 
@@ -187,9 +211,6 @@ func (x *XrayAPI) GetTraffic(reset bool) ([]*Traffic, []*ClientTraffic, error) {
 		return nil, nil, common.NewError("xray api is not initialized")
 	}
 
-	trafficRegex := regexp.MustCompile(`(inbound|outbound)>>>([^>]+)>>>traffic>>>(downlink|uplink)`)
-	clientTrafficRegex := regexp.MustCompile(`user>>>([^>]+)>>>traffic>>>(downlink|uplink)`)
-
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
 	defer cancel()
 
@@ -203,17 +224,162 @@ func (x *XrayAPI) GetTraffic(reset bool) ([]*Traffic, []*ClientTraffic, error) {
 		return nil, nil, err
 	}
 
+	traffic, clientTraffic := TrafficFromStats(resp.GetStat())
+	return traffic, clientTraffic, nil
+}
+
+// OutboundStatus is the health of an outbound as measured by the Xray observatory.
+type OutboundStatus struct {
+	Tag             string `json:"tag"`
+	Alive           bool   `json:"alive"`
+	Delay           int64  `json:"delay"` // Latency of the last probe in milliseconds
+	LastErrorReason string `json:"lastErrorReason"`
+	LastSeenTime    int64  `json:"lastSeenTime"` // Unix seconds of the last successful probe
+	LastTryTime     int64  `json:"lastTryTime"`  // Unix seconds of the last probe
+}
+
+// GetOutboundStatus queries the observatory for the health of the probed outbounds.
+// The observatory and the ObservatoryService API have to be part of the config.
+func (x *XrayAPI) GetOutboundStatus() ([]*OutboundStatus, error) {
+	if x.grpcClient == nil || x.ObservatoryServiceClient == nil {
+		return nil, common.NewError("xray api is not initialized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	resp, err := (*x.ObservatoryServiceClient).GetOutboundStatus(ctx, &observatoryService.GetOutboundStatusRequest{})
+	if err != nil {
+		logger.Debug("Failed to query Xray observatory:", err)
+		return nil, err
+	}
+
+	var statuses []*OutboundStatus
+	for _, status := range resp.GetStatus().GetStatus() {
+		statuses = append(statuses, &OutboundStatus{
+			Tag:             status.GetOutboundTag(),
+			Alive:           status.GetAlive(),
+			Delay:           status.GetDelay(),
+			LastErrorReason: status.GetLastErrorReason(),
+			LastSeenTime:    status.GetLastSeenTime(),
+			LastTryTime:     status.GetLastTryTime(),
+		})
+	}
+	return statuses, nil
+}
+
+// GetOnlineIps queries the StatsService for the IPs a client is connected from, with the
+// Unix seconds each was last seen. The policy level of the client needs statsUserOnline.
+func (x *XrayAPI) GetOnlineIps(email string) (map[string]int64, error) {
+	if x.grpcClient == nil || x.StatsServiceClient == nil {
+		return nil, common.NewError("xray api is not initialized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	resp, err := (*x.StatsServiceClient).GetStatsOnlineIpList(ctx, &statsService.GetStatsRequest{
+		Name: "user>>>" + email + ">>>online",
+	})
+	if err != nil {
+		logger.Debug("Failed to query Xray online IPs:", err)
+		return nil, err
+	}
+	return resp.GetIps(), nil
+}
+
+// SysStats is the runtime state of the Xray process as reported by its StatsService.
+type SysStats struct {
+	Uptime       uint32 `json:"uptime"` // Seconds since the core started
+	NumGoroutine uint32 `json:"numGoroutine"`
+	NumGC        uint32 `json:"numGC"`
+	Alloc        uint64 `json:"alloc"`      // Bytes of allocated heap objects
+	TotalAlloc   uint64 `json:"totalAlloc"` // Cumulative bytes allocated for heap objects
+	Sys          uint64 `json:"sys"`        // Bytes of memory obtained from the OS
+	Mallocs      uint64 `json:"mallocs"`
+	Frees        uint64 `json:"frees"`
+	LiveObjects  uint64 `json:"liveObjects"`
+	PauseTotalNs uint64 `json:"pauseTotalNs"`
+}
+
+// GetSysStats queries the StatsService for the uptime, goroutines and memory of the core.
+func (x *XrayAPI) GetSysStats() (*SysStats, error) {
+	if x.grpcClient == nil || x.StatsServiceClient == nil {
+		return nil, common.NewError("xray api is not initialized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	resp, err := (*x.StatsServiceClient).GetSysStats(ctx, &statsService.SysStatsRequest{})
+	if err != nil {
+		logger.Debug("Failed to query Xray system stats:", err)
+		return nil, err
+	}
+	return &SysStats{
+		Uptime:       resp.GetUptime(),
+		NumGoroutine: resp.GetNumGoroutine(),
+		NumGC:        resp.GetNumGC(),
+		Alloc:        resp.GetAlloc(),
+		TotalAlloc:   resp.GetTotalAlloc(),
+		Sys:          resp.GetSys(),
+		Mallocs:      resp.GetMallocs(),
+		Frees:        resp.GetFrees(),
+		LiveObjects:  resp.GetLiveObjects(),
+		PauseTotalNs: resp.GetPauseTotalNs(),
+	}, nil
+}
+
+// ListHandlers queries the HandlerService for the tags of the inbound and outbound handlers
+// running in the core.
+func (x *XrayAPI) ListHandlers() ([]string, []string, error) {
+	if x.grpcClient == nil || x.HandlerServiceClient == nil {
+		return nil, nil, common.NewError("xray api is not initialized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	client := *x.HandlerServiceClient
+	inboundsResp, err := client.ListInbounds(ctx, &command.ListInboundsRequest{IsOnlyTags: true})
+	if err != nil {
+		logger.Debug("Failed to list Xray inbounds:", err)
+		return nil, nil, err
+	}
+	outboundsResp, err := client.ListOutbounds(ctx, &command.ListOutboundsRequest{})
+	if err != nil {
+		logger.Debug("Failed to list Xray outbounds:", err)
+		return nil, nil, err
+	}
+
+	inbounds := make([]string, 0, len(inboundsResp.GetInbounds()))
+	for _, inbound := range inboundsResp.GetInbounds() {
+		inbounds = append(inbounds, inbound.GetTag())
+	}
+	outbounds := make([]string, 0, len(outboundsResp.GetOutbounds()))
+	for _, outbound := range outboundsResp.GetOutbounds() {
+		outbounds = append(outbounds, outbound.GetTag())
+	}
+	return inbounds, outbounds, nil
+}
+
+// TrafficFromStats aggregates the inbound, outbound and user traffic counters of a stats query.
+// sing-box reports its counters in the same format through its V2Ray API.
+func TrafficFromStats(stats []*statsService.Stat) ([]*Traffic, []*ClientTraffic) {
+	trafficRegex := regexp.MustCompile(`(inbound|outbound)>>>([^>]+)>>>traffic>>>(downlink|uplink)`)
+	clientTrafficRegex := regexp.MustCompile(`user>>>([^>]+)>>>traffic>>>(downlink|uplink)`)
+
 	tagTrafficMap := make(map[string]*Traffic)
 	emailTrafficMap := make(map[string]*ClientTraffic)
 
-	for _, stat := range resp.GetStat() {
+	for _, stat := range stats {
 		if matches := trafficRegex.FindStringSubmatch(stat.Name); len(matches) == 4 {
 			processTraffic(matches, stat.Value, tagTrafficMap)
 		} else if matches := clientTrafficRegex.FindStringSubmatch(stat.Name); len(matches) == 3 {
 			processClientTraffic(matches, stat.Value, emailTrafficMap)
 		}
 	}
-	return mapToSlice(tagTrafficMap), mapToSlice(emailTrafficMap), nil
+	return mapToSlice(tagTrafficMap), mapToSlice(emailTrafficMap)
 }
 
 // processTraffic aggregates a traffic stat into trafficMap using regex matches and value.
@@ -221,6 +387,10 @@ func processTraffic(matches []string, value int64, trafficMap map[string]*Traffi
 	isInbound := matches[1] == "inbound"
 	tag := matches[2]
 	isDown := matches[3] == "downlink"
+	if isInbound {
+		// The traffic of a REALITY grace inbound is traffic of the inbound it belongs to
+		tag = strings.TrimSuffix(tag, realityGraceTagSuffix)
+	}
 
 	if tag == "api" {
 		return
@@ -237,9 +407,9 @@ func processTraffic(matches []string, value int64, trafficMap map[string]*Traffi
 	}
 
 	if isDown {
-		traffic.Down = value
+		traffic.Down += value
 	} else {
-		traffic.Up = value
+		traffic.Up += value
 	}
 }
 
@@ -35,6 +35,12 @@ func (c *Config) Equals(other *Config) bool {
 			return false
 		}
 	}
+	return c.EqualsExceptInbounds(other)
+}
+
+// EqualsExceptInbounds compares everything but the inbounds of two Config instances.
+// Configs that differ only in their inbounds can be applied through the Xray API without a restart.
+func (c *Config) EqualsExceptInbounds(other *Config) bool {
 	if !bytes.Equal(c.LogConfig, other.LogConfig) {
 		return false
 	}
@@ -65,6 +71,12 @@ func (c *Config) Equals(other *Config) bool {
 	if !bytes.Equal(c.FakeDNS, other.FakeDNS) {
 		return false
 	}
+	if !bytes.Equal(c.Observatory, other.Observatory) {
+		return false
+	}
+	if !bytes.Equal(c.BurstObservatory, other.BurstObservatory) {
+		return false
+	}
 	if !bytes.Equal(c.Metrics, other.Metrics) {
 		return false
 	}
@@ -0,0 +1,287 @@
+package xray
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+)
+
+// geoASNMetadataMarker precedes the metadata at the end of a MaxMind DB file.
+var geoASNMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// geoASNDatabase is a loaded GeoLite2-ASN database in the MaxMind DB format: a binary search
+// tree over the address bits whose leaves point into a data section of typed values.
+type geoASNDatabase struct {
+	modTime    time.Time
+	file       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	data       []byte // Data section, which the pointers of the tree and of the values are offsets into
+	ipv4Start  uint   // Node of the IPv4 addresses in an IPv6 tree, reached by 96 zero bits
+}
+
+var (
+	geoASNLock sync.Mutex
+	geoASN     *geoASNDatabase
+)
+
+// LookupASN returns the autonomous system number and organization of ip in GeoLite2-ASN.mmdb,
+// or 0 and "" if the database has no network containing it. IPv6 addresses may be in brackets
+// and have a zone. The file is loaded on first use and again when it changes.
+func LookupASN(ip string) (uint, string, error) {
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(ip, "["), "]"))
+	if err != nil {
+		return 0, "", err
+	}
+	db, err := loadGeoASN()
+	if err != nil {
+		return 0, "", err
+	}
+	addr = addr.Unmap().WithZone("")
+
+	record, err := db.lookup(addr)
+	if err != nil || record == nil {
+		return 0, "", err
+	}
+	values, _ := record.(map[string]any)
+	number, _ := values["autonomous_system_number"].(uint64)
+	organization, _ := values["autonomous_system_organization"].(string)
+	return uint(number), organization, nil
+}
+
+// loadGeoASN returns the loaded GeoLite2-ASN database, loading it again if it was modified since.
+func loadGeoASN() (*geoASNDatabase, error) {
+	geoASNLock.Lock()
+	defer geoASNLock.Unlock()
+
+	stat, err := os.Stat(GetGeoASNPath())
+	if err != nil {
+		return nil, err
+	}
+	if geoASN != nil && geoASN.modTime.Equal(stat.ModTime()) {
+		return geoASN, nil
+	}
+
+	file, err := os.ReadFile(GetGeoASNPath())
+	if err != nil {
+		return nil, err
+	}
+	start := bytes.LastIndex(file, geoASNMetadataMarker)
+	if start < 0 {
+		return nil, common.NewError("Not a MaxMind DB file:", GetGeoASNPath())
+	}
+	start += len(geoASNMetadataMarker)
+	metadata, _, err := (&geoASNDatabase{data: file[start:]}).decode(0)
+	if err != nil {
+		return nil, err
+	}
+	fields, _ := metadata.(map[string]any)
+	nodeCount, _ := fields["node_count"].(uint64)
+	recordSize, _ := fields["record_size"].(uint64)
+	ipVersion, _ := fields["ip_version"].(uint64)
+	if recordSize != 24 && recordSize != 28 && recordSize != 32 {
+		return nil, common.NewErrorf("Unsupported MaxMind DB record size %d", recordSize)
+	}
+
+	// The tree is followed by 16 zero bytes and the data section
+	treeSize := nodeCount * recordSize / 4
+	if treeSize+16 > uint64(start) {
+		return nil, common.NewError("MaxMind DB search tree is truncated")
+	}
+	db := &geoASNDatabase{
+		modTime:    stat.ModTime(),
+		file:       file,
+		nodeCount:  uint(nodeCount),
+		recordSize: uint(recordSize),
+		ipVersion:  uint(ipVersion),
+		data:       file[treeSize+16 : start-len(geoASNMetadataMarker)],
+	}
+	if db.ipVersion == 6 {
+		for i := 0; i < 96 && db.ipv4Start < db.nodeCount; i++ {
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+	geoASN = db
+	return geoASN, nil
+}
+
+// lookup walks the search tree along the bits of addr and decodes the record it ends at, which
+// is nil if no network contains addr.
+func (db *geoASNDatabase) lookup(addr netip.Addr) (any, error) {
+	node := uint(0)
+	var bits []byte
+	if addr.Is4() {
+		address := addr.As4()
+		bits = address[:]
+		node = db.ipv4Start
+	} else if db.ipVersion == 6 {
+		address := addr.As16()
+		bits = address[:]
+	} else {
+		return nil, nil
+	}
+	for i := 0; i < len(bits)*8 && node < db.nodeCount; i++ {
+		node = db.record(node, (bits[i/8]>>(7-i%8))&1)
+	}
+	if node == db.nodeCount {
+		return nil, nil
+	}
+	if node < db.nodeCount {
+		return nil, common.NewError("MaxMind DB search tree is too deep")
+	}
+	value, _, err := db.decode(node - db.nodeCount - 16)
+	return value, err
+}
+
+// record returns the left (bit 0) or right (bit 1) record of a node of the search tree.
+func (db *geoASNDatabase) record(node uint, bit byte) uint {
+	b := db.file[node*db.recordSize/4:]
+	switch db.recordSize {
+	case 24:
+		if bit == 1 {
+			b = b[3:]
+		}
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 1 {
+			return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+		}
+		return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	default:
+		if bit == 1 {
+			b = b[4:]
+		}
+		return uint(binary.BigEndian.Uint32(b))
+	}
+}
+
+// decode decodes the value at offset of the data section, returning it with the offset of the
+// value after it. Maps decode to map[string]any, arrays to []any, unsigned integers to uint64 and
+// signed ones to int64.
+func (db *geoASNDatabase) decode(offset uint) (any, uint, error) {
+	if offset >= uint(len(db.data)) {
+		return nil, 0, common.NewError("MaxMind DB data is truncated")
+	}
+	control := db.data[offset]
+	offset++
+	kind := uint(control >> 5)
+	if kind == 1 {
+		// Pointers keep the size bits for the pointer itself
+		size := uint(control>>3) & 0x3
+		if offset+size+1 > uint(len(db.data)) {
+			return nil, 0, common.NewError("MaxMind DB data is truncated")
+		}
+		pointer := uint(control & 0x7)
+		if size == 3 {
+			pointer = 0
+		}
+		for _, b := range db.data[offset : offset+size+1] {
+			pointer = pointer<<8 | uint(b)
+		}
+		pointer += [...]uint{0, 2048, 526336, 0}[size]
+		// A pointer never points to a pointer, which also keeps a broken file from looping
+		if pointer < uint(len(db.data)) && db.data[pointer]>>5 == 1 {
+			return nil, 0, common.NewError("MaxMind DB pointer points to a pointer")
+		}
+		value, _, err := db.decode(pointer)
+		return value, offset + size + 1, err
+	}
+	if kind == 0 {
+		if offset >= uint(len(db.data)) {
+			return nil, 0, common.NewError("MaxMind DB data is truncated")
+		}
+		kind = 7 + uint(db.data[offset])
+		offset++
+	}
+	size := uint(control & 0x1f)
+	if size >= 29 {
+		extra := size - 28
+		if offset+extra > uint(len(db.data)) {
+			return nil, 0, common.NewError("MaxMind DB data is truncated")
+		}
+		value := uint(0)
+		for _, b := range db.data[offset : offset+extra] {
+			value = value<<8 | uint(b)
+		}
+		size = [...]uint{29, 285, 65821}[extra-1] + value
+		offset += extra
+	}
+
+	switch kind {
+	case 7: // Map
+		values := make(map[string]any, size)
+		for range size {
+			key, next, err := db.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, next, err := db.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, _ := key.(string)
+			values[name] = value
+			offset = next
+		}
+		return values, offset, nil
+	case 11: // Array
+		values := make([]any, 0, size)
+		for range size {
+			value, next, err := db.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			values = append(values, value)
+			offset = next
+		}
+		return values, offset, nil
+	case 14: // Boolean, its size is the value
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(db.data)) {
+		return nil, 0, common.NewError("MaxMind DB data is truncated")
+	}
+	raw := db.data[offset : offset+size]
+	offset += size
+	switch kind {
+	case 2: // UTF-8 string
+		return string(raw), offset, nil
+	case 3: // Double
+		if size != 8 {
+			return nil, 0, common.NewError("MaxMind DB double is not 8 bytes")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(raw)), offset, nil
+	case 15: // Float
+		if size != 4 {
+			return nil, 0, common.NewError("MaxMind DB float is not 4 bytes")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(raw))), offset, nil
+	case 4: // Bytes
+		return raw, offset, nil
+	case 5, 6, 9, 10: // Unsigned integers of up to 16, 32, 64 and 128 bits, the last cut to 64
+		value := uint64(0)
+		for _, b := range raw {
+			value = value<<8 | uint64(b)
+		}
+		return value, offset, nil
+	case 8: // Signed 32 bit integer
+		value := uint32(0)
+		for _, b := range raw {
+			value = value<<8 | uint32(b)
+		}
+		if size == 4 {
+			return int64(int32(value)), offset, nil
+		}
+		return int64(value), offset, nil
+	}
+	return nil, 0, common.NewErrorf("Unsupported MaxMind DB data type %d", kind)
+}
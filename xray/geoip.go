@@ -0,0 +1,117 @@
+package xray
+
+import (
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/app/router"
+	"google.golang.org/protobuf/proto"
+)
+
+// geoIPDatabase holds the CIDRs of every code in geoip.dat, sorted for binary search.
+type geoIPDatabase struct {
+	modTime  time.Time
+	prefixes map[string][]netip.Prefix
+}
+
+var (
+	geoIPLock sync.Mutex
+	geoIP     *geoIPDatabase
+)
+
+// LookupGeoIP returns the codes of geoip.dat whose CIDRs contain ip, e.g. "DE" or "CLOUDFLARE".
+// Country codes are two letters. IPv6 addresses may be in brackets and have a zone, IPv4-mapped
+// ones are looked up as IPv4. The file is loaded on first use and again when it changes.
+func LookupGeoIP(ip string) ([]string, error) {
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(ip, "["), "]"))
+	if err != nil {
+		return nil, err
+	}
+	db, err := loadGeoIP()
+	if err != nil {
+		return nil, err
+	}
+	addr = addr.Unmap().WithZone("")
+
+	var codes []string
+	for code, prefixes := range db.prefixes {
+		i := sort.Search(len(prefixes), func(i int) bool {
+			return prefixes[i].Addr().Compare(addr) > 0
+		})
+		if i > 0 && prefixes[i-1].Contains(addr) {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	return codes, nil
+}
+
+// GeoIPCountry returns the two letter country code of ip in geoip.dat, or "" if it has none.
+func GeoIPCountry(ip string) string {
+	codes, err := LookupGeoIP(ip)
+	if err != nil {
+		return ""
+	}
+	for _, code := range codes {
+		if len(code) == 2 {
+			return code
+		}
+	}
+	return ""
+}
+
+// loadGeoIP returns the parsed geoip.dat, parsing it again if it was modified since the last load.
+func loadGeoIP() (*geoIPDatabase, error) {
+	geoIPLock.Lock()
+	defer geoIPLock.Unlock()
+
+	stat, err := os.Stat(GetGeoipPath())
+	if err != nil {
+		return nil, err
+	}
+	if geoIP != nil && geoIP.modTime.Equal(stat.ModTime()) {
+		return geoIP, nil
+	}
+
+	data, err := os.ReadFile(GetGeoipPath())
+	if err != nil {
+		return nil, err
+	}
+	list := &router.GeoIPList{}
+	if err := proto.Unmarshal(data, list); err != nil {
+		return nil, err
+	}
+
+	db := &geoIPDatabase{
+		modTime:  stat.ModTime(),
+		prefixes: make(map[string][]netip.Prefix, len(list.GetEntry())),
+	}
+	for _, entry := range list.GetEntry() {
+		if entry.GetReverseMatch() {
+			continue
+		}
+		code := strings.ToUpper(entry.GetCountryCode())
+		prefixes := make([]netip.Prefix, 0, len(entry.GetCidr()))
+		for _, cidr := range entry.GetCidr() {
+			addr, ok := netip.AddrFromSlice(cidr.GetIp())
+			if !ok {
+				continue
+			}
+			prefix, err := addr.Prefix(int(cidr.GetPrefix()))
+			if err != nil {
+				continue
+			}
+			prefixes = append(prefixes, prefix)
+		}
+		sort.Slice(prefixes, func(i, j int) bool {
+			return prefixes[i].Addr().Compare(prefixes[j].Addr()) < 0
+		})
+		db.prefixes[code] = prefixes
+	}
+	geoIP = db
+	return geoIP, nil
+}
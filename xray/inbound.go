@@ -10,7 +10,7 @@ import (
 // It defines how Xray accepts incoming connections including protocol, port, and settings.
 type InboundConfig struct {
 	Listen         json_util.RawMessage `json:"listen"` // listen cannot be an empty string
-	Port           int                  `json:"port"`
+	Port           json_util.RawMessage `json:"port"`   // a single port or a port list such as "443,20000-30000"
 	Protocol       string               `json:"protocol"`
 	Settings       json_util.RawMessage `json:"settings"`
 	StreamSettings json_util.RawMessage `json:"streamSettings"`
@@ -23,7 +23,7 @@ func (c *InboundConfig) Equals(other *InboundConfig) bool {
 	if !bytes.Equal(c.Listen, other.Listen) {
 		return false
 	}
-	if c.Port != other.Port {
+	if !bytes.Equal(c.Port, other.Port) {
 		return false
 	}
 	if c.Protocol != other.Protocol {
@@ -43,3 +43,12 @@ func (c *InboundConfig) Equals(other *InboundConfig) bool {
 	}
 	return true
 }
+
+// realityGraceTagSuffix is appended to the tag of an inbound for the tag of its grace inbound.
+const realityGraceTagSuffix = "-reality-grace"
+
+// RealityGraceTag returns the tag of the grace inbound that accepts the previous REALITY keys
+// of the inbound with the given tag after its keys were rotated.
+func RealityGraceTag(tag string) string {
+	return tag + realityGraceTagSuffix
+}
@@ -0,0 +1,200 @@
+package xray
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The types below are typed views of parts of the inbound settings, used only to validate them.
+// Inbounds are still stored in the database, served by the API and passed to Xray as the raw JSON
+// they were saved with, so fields the views do not know about are kept as they are.
+
+// Fallback represents a single VLESS or Trojan fallback entry.
+type Fallback struct {
+	Name string          `json:"name,omitempty"`
+	Alpn string          `json:"alpn,omitempty"`
+	Path string          `json:"path,omitempty"`
+	Dest json.RawMessage `json:"dest"` // a port number, "addr:port" or a unix socket path
+	Xver int             `json:"xver,omitempty"`
+}
+
+// SniffingConfig represents the sniffing section of an inbound.
+type SniffingConfig struct {
+	Enabled         bool     `json:"enabled"`
+	DestOverride    []string `json:"destOverride,omitempty"`
+	MetadataOnly    bool     `json:"metadataOnly,omitempty"`
+	RouteOnly       bool     `json:"routeOnly,omitempty"`
+	DomainsExcluded []string `json:"domainsExcluded,omitempty"`
+}
+
+// TcpHeader represents the header obfuscation of the TCP (RAW) transport.
+type TcpHeader struct {
+	Type     string          `json:"type"`
+	Request  json.RawMessage `json:"request,omitempty"`
+	Response json.RawMessage `json:"response,omitempty"`
+}
+
+// TcpSettings represents the TCP (RAW) transport settings of an inbound.
+type TcpSettings struct {
+	AcceptProxyProtocol bool       `json:"acceptProxyProtocol,omitempty"`
+	Header              *TcpHeader `json:"header,omitempty"`
+}
+
+// StreamConfig represents the parts of the inbound stream settings that are validated by the panel.
+type StreamConfig struct {
+	Network     string       `json:"network"`
+	Security    string       `json:"security"`
+	TcpSettings *TcpSettings `json:"tcpSettings,omitempty"`
+	RawSettings *TcpSettings `json:"rawSettings,omitempty"`
+}
+
+var sniffingProtocols = []string{"http", "tls", "quic", "fakedns", "fakedns+others"}
+
+// GetFallbacks returns the fallbacks defined in the inbound settings.
+func (c *InboundConfig) GetFallbacks() ([]Fallback, error) {
+	if len(c.Settings) == 0 {
+		return nil, nil
+	}
+	var settings struct {
+		Fallbacks []Fallback `json:"fallbacks"`
+	}
+	if err := json.Unmarshal(c.Settings, &settings); err != nil {
+		return nil, fmt.Errorf("invalid settings: %w", err)
+	}
+	return settings.Fallbacks, nil
+}
+
+// GetSniffing returns the sniffing configuration of the inbound, or nil if it is not set.
+func (c *InboundConfig) GetSniffing() (*SniffingConfig, error) {
+	if len(c.Sniffing) == 0 {
+		return nil, nil
+	}
+	sniffing := &SniffingConfig{}
+	if err := json.Unmarshal(c.Sniffing, sniffing); err != nil {
+		return nil, fmt.Errorf("invalid sniffing: %w", err)
+	}
+	return sniffing, nil
+}
+
+// GetStream returns the validated parts of the inbound stream settings, or nil if they are not set.
+func (c *InboundConfig) GetStream() (*StreamConfig, error) {
+	if len(c.StreamSettings) == 0 {
+		return nil, nil
+	}
+	stream := &StreamConfig{}
+	if err := json.Unmarshal(c.StreamSettings, stream); err != nil {
+		return nil, fmt.Errorf("invalid stream settings: %w", err)
+	}
+	return stream, nil
+}
+
+// Validate rejects fallback, sniffing and transport combinations that Xray refuses to start with.
+func (c *InboundConfig) Validate() error {
+	fallbacks, err := c.GetFallbacks()
+	if err != nil {
+		return err
+	}
+	sniffing, err := c.GetSniffing()
+	if err != nil {
+		return err
+	}
+	stream, err := c.GetStream()
+	if err != nil {
+		return err
+	}
+
+	if len(fallbacks) > 0 {
+		if c.Protocol != "vless" && c.Protocol != "trojan" {
+			return fmt.Errorf("fallbacks are only supported by VLESS and Trojan, not %s", c.Protocol)
+		}
+		if stream != nil && stream.Network != "" && stream.Network != "tcp" && stream.Network != "raw" {
+			return fmt.Errorf("fallbacks require the TCP transport, not %s", stream.Network)
+		}
+		if err = validateFallbacks(fallbacks); err != nil {
+			return err
+		}
+	}
+
+	if sniffing != nil {
+		for _, protocol := range sniffing.DestOverride {
+			if !slices.Contains(sniffingProtocols, protocol) {
+				return fmt.Errorf("unknown sniffing protocol: %s", protocol)
+			}
+		}
+	}
+
+	if stream != nil {
+		if stream.Security == "reality" && !slices.Contains([]string{"", "tcp", "raw", "xhttp", "grpc"}, stream.Network) {
+			return fmt.Errorf("REALITY only supports the TCP, XHTTP and gRPC transports, not %s", stream.Network)
+		}
+		for _, tcp := range []*TcpSettings{stream.TcpSettings, stream.RawSettings} {
+			if tcp == nil || tcp.Header == nil {
+				continue
+			}
+			if tcp.Header.Type != "" && tcp.Header.Type != "none" && tcp.Header.Type != "http" {
+				return fmt.Errorf("unknown TCP header type: %s", tcp.Header.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// validateFallbacks checks every fallback destination and rejects entries that shadow each other.
+func validateFallbacks(fallbacks []Fallback) error {
+	seen := make(map[string]bool, len(fallbacks))
+	for _, fallback := range fallbacks {
+		if err := validateFallbackDest(fallback.Dest); err != nil {
+			return err
+		}
+		if fallback.Path != "" && !strings.HasPrefix(fallback.Path, "/") {
+			return fmt.Errorf("fallback path must be empty or start with \"/\": %s", fallback.Path)
+		}
+		if fallback.Xver < 0 || fallback.Xver > 2 {
+			return fmt.Errorf("fallback xver must be 0, 1 or 2, not %d", fallback.Xver)
+		}
+		key := fallback.Name + "\x00" + fallback.Alpn + "\x00" + fallback.Path
+		if seen[key] {
+			return fmt.Errorf("duplicate fallback for name %q, alpn %q and path %q", fallback.Name, fallback.Alpn, fallback.Path)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// validateFallbackDest accepts a port, "addr:port" or a unix socket path as fallback destination.
+func validateFallbackDest(raw json.RawMessage) error {
+	var port int
+	if json.Unmarshal(raw, &port) == nil {
+		return validateFallbackPort(port)
+	}
+	var dest string
+	if err := json.Unmarshal(raw, &dest); err != nil || dest == "" {
+		return errors.New("every fallback needs a dest")
+	}
+	if port, err := strconv.Atoi(dest); err == nil {
+		return validateFallbackPort(port)
+	}
+	if strings.HasPrefix(dest, "/") || strings.HasPrefix(dest, "@") {
+		return nil
+	}
+	i := strings.LastIndex(dest, ":")
+	if i < 0 {
+		return fmt.Errorf("invalid fallback dest: %s", dest)
+	}
+	port, err := strconv.Atoi(dest[i+1:])
+	if err != nil {
+		return fmt.Errorf("invalid fallback dest: %s", dest)
+	}
+	return validateFallbackPort(port)
+}
+
+func validateFallbackPort(port int) error {
+	if port <= 0 || port > 65535 {
+		return fmt.Errorf("invalid fallback port: %d", port)
+	}
+	return nil
+}
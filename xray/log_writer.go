@@ -4,6 +4,7 @@ import (
 	"regexp"
 	"runtime"
 	"strings"
+	"sync"
 
 	"github.com/mhsanaei/3x-ui/v2/logger"
 )
@@ -16,6 +17,37 @@ func NewLogWriter() *LogWriter {
 // LogWriter processes and filters log output from the Xray process, handling crash detection and message filtering.
 type LogWriter struct {
 	lastLine string
+	tail     logTail
+}
+
+// logTailSize is the number of output lines kept to report why a core stopped.
+const logTailSize = 20
+
+// logTail keeps the last lines written by a core process.
+type logTail struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+// add appends the non-empty lines of an output chunk, dropping the oldest ones.
+func (t *logTail) add(message string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for line := range strings.SplitSeq(message, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			t.lines = append(t.lines, line)
+		}
+	}
+	if len(t.lines) > logTailSize {
+		t.lines = append([]string(nil), t.lines[len(t.lines)-logTailSize:]...)
+	}
+}
+
+// get returns a copy of the kept lines.
+func (t *logTail) get() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.lines...)
 }
 
 // Write processes and filters log output from the Xray process, handling crash detection and message filtering.
@@ -24,6 +56,7 @@ func (lw *LogWriter) Write(m []byte) (n int, err error) {
 
 	// Convert the data to a string
 	message := strings.TrimSpace(string(m))
+	lw.tail.add(message)
 	msgLowerAll := strings.ToLower(message)
 
 	// Suppress noisy Windows process-kill signal that surfaces as exit status 1
@@ -2,13 +2,17 @@ package xray
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"os/exec"
 	"runtime"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -23,11 +27,52 @@ func GetBinaryName() string {
 	return fmt.Sprintf("xray-%s-%s", runtime.GOOS, runtime.GOARCH)
 }
 
-// GetBinaryPath returns the full path to the Xray binary executable.
+// GetBinaryPath returns the full path to the Xray binary executable,
+// which is the custom binary if one is configured.
 func GetBinaryPath() string {
+	if path := config.GetXrayBinaryPath(); path != "" {
+		return path
+	}
 	return config.GetBinFolderPath() + "/" + GetBinaryName()
 }
 
+// IsBinaryPinned reports whether the Xray binary is a custom binary or pinned to a checksum,
+// in which case the panel must not replace it.
+func IsBinaryPinned() bool {
+	return config.GetXrayBinaryPath() != "" || config.GetXrayBinarySHA256() != ""
+}
+
+// VerifyBinary checks the Xray binary against the pinned SHA-256 checksum, if one is configured.
+func VerifyBinary() error {
+	pinned := config.GetXrayBinarySHA256()
+	if pinned == "" {
+		return nil
+	}
+	file, err := os.Open(GetBinaryPath())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+	if checksum := hex.EncodeToString(hash.Sum(nil)); checksum != pinned {
+		return common.NewErrorf("Xray binary checksum %s does not match the pinned checksum %s", checksum, pinned)
+	}
+	return nil
+}
+
+// Command returns a command running the Xray binary with args. If the binary fails
+// verification, running the command returns the verification error.
+func Command(args ...string) *exec.Cmd {
+	cmd := exec.Command(GetBinaryPath(), args...)
+	if err := VerifyBinary(); err != nil {
+		cmd.Err = err
+	}
+	return cmd
+}
+
 // GetConfigPath returns the path to the Xray configuration file in the binary folder.
 func GetConfigPath() string {
 	return config.GetBinFolderPath() + "/config.json"
@@ -43,6 +88,12 @@ func GetGeoipPath() string {
 	return config.GetBinFolderPath() + "/geoip.dat"
 }
 
+// GetGeoASNPath returns the path to the GeoLite2-ASN database the connection analytics locate
+// destination networks with.
+func GetGeoASNPath() string {
+	return config.GetBinFolderPath() + "/GeoLite2-ASN.mmdb"
+}
+
 // GetIPLimitLogPath returns the path to the IP limit log file.
 func GetIPLimitLogPath() string {
 	return config.GetLogFolder() + "/3xipl.log"
@@ -93,6 +144,25 @@ func GetAccessLogPath() (string, error) {
 	return "", err
 }
 
+// GetErrorLogPath reads the Xray config and returns the error log file path. It is empty
+// when the error log goes to the output of the process.
+func GetErrorLogPath() (string, error) {
+	config, err := os.ReadFile(GetConfigPath())
+	if err != nil {
+		return "", err
+	}
+	jsonConfig := map[string]any{}
+	if err := json.Unmarshal(config, &jsonConfig); err != nil {
+		return "", err
+	}
+	jsonLog, _ := jsonConfig["log"].(map[string]any)
+	errorLogPath, _ := jsonLog["error"].(string)
+	if errorLogPath == "none" {
+		return "", nil
+	}
+	return errorLogPath, nil
+}
+
 // stopProcess calls Stop on the given Process instance.
 func stopProcess(p *Process) {
 	p.Stop()
@@ -158,6 +228,11 @@ func (p *process) GetResult() string {
 	return p.logWriter.lastLine
 }
 
+// GetLastLines returns the last lines the Xray process wrote to its output.
+func (p *process) GetLastLines() []string {
+	return p.logWriter.tail.get()
+}
+
 // GetVersion returns the version string of the Xray process.
 func (p *process) GetVersion() string {
 	return p.version
@@ -173,6 +248,13 @@ func (p *Process) GetConfig() *Config {
 	return p.config
 }
 
+// SetConfig replaces the configuration of the running Xray process after it was applied
+// through the Xray API, and writes it to the configuration file.
+func (p *Process) SetConfig(config *Config) error {
+	p.config = config
+	return p.writeConfig()
+}
+
 // GetOnlineClients returns the list of online clients for the Xray process.
 func (p *Process) GetOnlineClients() []string {
 	return p.onlineClients
@@ -188,11 +270,24 @@ func (p *Process) GetUptime() uint64 {
 	return uint64(time.Since(p.startTime).Seconds())
 }
 
+// writeConfig writes the configuration of the process to the configuration file.
+func (p *process) writeConfig() error {
+	data, err := json.MarshalIndent(p.config, "", "  ")
+	if err != nil {
+		return common.NewErrorf("Failed to generate XRAY configuration files: %v", err)
+	}
+	err = os.WriteFile(GetConfigPath(), data, fs.ModePerm)
+	if err != nil {
+		return common.NewErrorf("Failed to write configuration file: %v", err)
+	}
+	return nil
+}
+
 // refreshAPIPort updates the API port from the inbound configs.
 func (p *process) refreshAPIPort() {
 	for _, inbound := range p.config.InboundConfigs {
 		if inbound.Tag == "api" {
-			p.apiPort = inbound.Port
+			p.apiPort, _ = strconv.Atoi(string(inbound.Port))
 			break
 		}
 	}
@@ -200,18 +295,30 @@ func (p *process) refreshAPIPort() {
 
 // refreshVersion updates the version string by running the Xray binary with -version.
 func (p *process) refreshVersion() {
-	cmd := exec.Command(GetBinaryPath(), "-version")
-	data, err := cmd.Output()
+	version, err := GetBinaryVersion(GetBinaryPath())
 	if err != nil {
 		p.version = "Unknown"
-	} else {
-		datas := bytes.Split(data, []byte(" "))
-		if len(datas) <= 1 {
-			p.version = "Unknown"
-		} else {
-			p.version = string(datas[1])
+		return
+	}
+	p.version = version
+}
+
+// GetBinaryVersion runs the Xray binary at path with -version and returns the reported version.
+func GetBinaryVersion(path string) (string, error) {
+	if path == GetBinaryPath() {
+		if err := VerifyBinary(); err != nil {
+			return "", err
 		}
 	}
+	data, err := exec.Command(path, "-version").Output()
+	if err != nil {
+		return "", err
+	}
+	datas := bytes.Split(data, []byte(" "))
+	if len(datas) <= 1 {
+		return "", errors.New("unexpected xray version output")
+	}
+	return string(datas[1]), nil
 }
 
 // Start launches the Xray process with the current configuration.
@@ -227,23 +334,27 @@ func (p *process) Start() (err error) {
 		}
 	}()
 
-	data, err := json.MarshalIndent(p.config, "", "  ")
-	if err != nil {
-		return common.NewErrorf("Failed to generate XRAY configuration files: %v", err)
-	}
-
 	err = os.MkdirAll(config.GetLogFolder(), 0o770)
 	if err != nil {
 		logger.Warningf("Failed to create log folder: %s", err)
 	}
 
-	configPath := GetConfigPath()
-	err = os.WriteFile(configPath, data, fs.ModePerm)
+	err = p.writeConfig()
 	if err != nil {
-		return common.NewErrorf("Failed to write configuration file: %v", err)
+		return err
 	}
 
-	cmd := exec.Command(GetBinaryPath(), "-c", configPath)
+	err = VerifyBinary()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(GetBinaryPath(), "-c", GetConfigPath())
+	if user := config.GetXrayUser(); user != "" {
+		err = setProcessUser(cmd, user)
+		if err != nil {
+			return err
+		}
+	}
 	p.cmd = cmd
 
 	cmd.Stdout = p.logWriter
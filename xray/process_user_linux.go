@@ -0,0 +1,36 @@
+//go:build linux
+// +build linux
+
+package xray
+
+import (
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// capNetBindService is CAP_NET_BIND_SERVICE, which lets the core listen on ports below 1024.
+const capNetBindService = 10
+
+// setProcessUser makes cmd run as the named system user. The core keeps the capability
+// to bind privileged ports, but nothing else of the panel's privileges.
+func setProcessUser(cmd *exec.Cmd, name string) error {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return err
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential:  &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)},
+		AmbientCaps: []uintptr{capNetBindService},
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package xray
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// setProcessUser is only supported on Linux.
+func setProcessUser(cmd *exec.Cmd, name string) error {
+	return errors.New("running xray as another user is only supported on linux")
+}